@@ -0,0 +1,145 @@
+// Package analytics records anonymized search and playback outcomes, so
+// users can see their own usage patterns. Nothing ever leaves the device,
+// and nothing is recorded unless local analytics are enabled.
+package analytics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/store"
+)
+
+const (
+	maxEvents = 1000
+	eventsKey = "events"
+)
+
+const (
+	EventSearch = "search"
+	EventChoice = "choice"
+	EventPlay   = "play"
+)
+
+// Event holds no titles nor hashes, only numbers.
+type Event struct {
+	Type       string        `json:"type"`
+	Time       time.Time     `json:"time"`
+	Kind       string        `json:"kind,omitempty"`
+	Results    int           `json:"results,omitempty"`
+	Resolution string        `json:"resolution,omitempty"`
+	TimeToPlay time.Duration `json:"time_to_play,omitempty"`
+}
+
+var (
+	lock   = sync.Mutex{}
+	events []*Event
+	loaded = false
+)
+
+func bucket() *store.Bucket {
+	return store.Global("analytics")
+}
+
+func load() {
+	if loaded {
+		return
+	}
+	loaded = true
+	bucket().Get(eventsKey, &events)
+}
+
+func record(event *Event) {
+	if config.Get().LocalAnalytics == false {
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	load()
+	event.Time = time.Now()
+	events = append(events, event)
+	if len(events) > maxEvents {
+		events = events[len(events)-maxEvents:]
+	}
+	bucket().Set(eventsKey, events, store.FOREVER)
+}
+
+// kind is movie, episode or query
+func RecordSearch(kind string, results int) {
+	record(&Event{Type: EventSearch, Kind: kind, Results: results})
+}
+
+func RecordChoice(kind string, resolution string) {
+	record(&Event{Type: EventChoice, Kind: kind, Resolution: resolution})
+}
+
+func RecordPlay(timeToPlay time.Duration) {
+	record(&Event{Type: EventPlay, TimeToPlay: timeToPlay})
+}
+
+type Dashboard struct {
+	Enabled           bool           `json:"enabled"`
+	Searches          int            `json:"searches"`
+	SearchesByKind    map[string]int `json:"searches_by_kind"`
+	AverageResults    float64        `json:"average_results"`
+	EmptySearches     int            `json:"empty_searches"`
+	ChosenResolutions map[string]int `json:"chosen_resolutions"`
+	Plays             int            `json:"plays"`
+	AverageTimeToPlay time.Duration  `json:"average_time_to_play"`
+	Since             time.Time      `json:"since"`
+}
+
+func GetDashboard() *Dashboard {
+	lock.Lock()
+	defer lock.Unlock()
+	load()
+
+	dashboard := &Dashboard{
+		Enabled:           config.Get().LocalAnalytics,
+		SearchesByKind:    map[string]int{},
+		ChosenResolutions: map[string]int{},
+	}
+	totalResults := 0
+	totalTimeToPlay := time.Duration(0)
+	for _, event := range events {
+		if dashboard.Since.IsZero() {
+			dashboard.Since = event.Time
+		}
+		switch event.Type {
+		case EventSearch:
+			dashboard.Searches++
+			dashboard.SearchesByKind[event.Kind]++
+			totalResults += event.Results
+			if event.Results == 0 {
+				dashboard.EmptySearches++
+			}
+		case EventChoice:
+			resolution := event.Resolution
+			if resolution == "" {
+				resolution = "unknown"
+			}
+			dashboard.ChosenResolutions[resolution]++
+		case EventPlay:
+			dashboard.Plays++
+			totalTimeToPlay += event.TimeToPlay
+		}
+	}
+	if dashboard.Searches > 0 {
+		dashboard.AverageResults = float64(totalResults) / float64(dashboard.Searches)
+	}
+	if dashboard.Plays > 0 {
+		dashboard.AverageTimeToPlay = totalTimeToPlay / time.Duration(dashboard.Plays)
+	}
+	return dashboard
+}
+
+// Clear forgets everything that was recorded.
+func Clear() {
+	lock.Lock()
+	defer lock.Unlock()
+	events = nil
+	loaded = true
+	bucket().Set(eventsKey, events, store.FOREVER)
+}
@@ -0,0 +1,216 @@
+// Package anidb maps TVDB episodes to AniDB ones, using the anime-lists
+// project, as fansubbers number episodes after AniDB rather than TVDB.
+package anidb
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/metacache"
+)
+
+const (
+	animeListURL   = "https://raw.githubusercontent.com/ScudLee/anime-lists/master/anime-list.xml"
+	animeListKey   = "index"
+	requestTimeout = 30 * time.Second
+	// don't retry a failed download on every search
+	retryDelay = 1 * time.Hour
+
+	// defaulttvdbseason of the entries following TVDB absolute numbers
+	absoluteSeason = "a"
+)
+
+var log = logging.MustGetLogger("anidb")
+
+// EpisodePair maps an AniDB episode to a TVDB one.
+type EpisodePair struct {
+	AniDB int
+	TVDB  int
+}
+
+// Mapping is how the episodes of an AniDB season are split over a TVDB
+// season, either episode by episode or as a range with an offset.
+type Mapping struct {
+	AniDBSeason int    `xml:"anidbseason,attr"`
+	TVDBSeason  int    `xml:"tvdbseason,attr"`
+	Start       int    `xml:"start,attr"`
+	End         int    `xml:"end,attr"`
+	Offset      int    `xml:"offset,attr"`
+	Pairs       string `xml:",chardata"`
+
+	Episodes []EpisodePair
+}
+
+// Anime is an AniDB entry, usually a season or a cour, of a TVDB show.
+type Anime struct {
+	AniDBId           int        `xml:"anidbid,attr"`
+	TVDBId            string     `xml:"tvdbid,attr"`
+	DefaultTVDBSeason string     `xml:"defaulttvdbseason,attr"`
+	EpisodeOffset     int        `xml:"episodeoffset,attr"`
+	Name              string     `xml:"name"`
+	Mappings          []*Mapping `xml:"mapping-list>mapping"`
+}
+
+type animeList struct {
+	Anime []*Anime `xml:"anime"`
+}
+
+// Episode is where a TVDB episode is on AniDB.
+type Episode struct {
+	AniDBId int `json:"anidb_id"`
+	Number  int `json:"number"`
+}
+
+var (
+	indexLock   = sync.Mutex{}
+	lastFailure time.Time
+)
+
+// Pairs look like ";1-5;2-6;", several TVDB episodes being "1-5+6".
+func parsePairs(pairs string) []EpisodePair {
+	episodes := make([]EpisodePair, 0)
+	for _, pair := range strings.Split(pairs, ";") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		anidbEpisode, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		tvdbEpisode, err := strconv.Atoi(strings.Split(parts[1], "+")[0])
+		if err != nil {
+			continue
+		}
+		episodes = append(episodes, EpisodePair{AniDB: anidbEpisode, TVDB: tvdbEpisode})
+	}
+	return episodes
+}
+
+func downloadIndex() (map[string][]*Anime, error) {
+	client := &http.Client{Timeout: requestTimeout}
+	resp, err := client.Get(animeListURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("anime list download failed: %s", resp.Status)
+	}
+	list := &animeList{}
+	if err := xml.NewDecoder(resp.Body).Decode(list); err != nil {
+		return nil, err
+	}
+	byTVDBId := make(map[string][]*Anime)
+	for _, anime := range list.Anime {
+		if _, err := strconv.Atoi(anime.TVDBId); err != nil {
+			// movies, OVAs not on TVDB and such
+			continue
+		}
+		for _, mapping := range anime.Mappings {
+			mapping.Episodes = parsePairs(mapping.Pairs)
+			mapping.Pairs = ""
+		}
+		byTVDBId[anime.TVDBId] = append(byTVDBId[anime.TVDBId], anime)
+	}
+	log.Info("Loaded the AniDB mappings of %d shows", len(byTVDBId))
+	return byTVDBId, nil
+}
+
+// entries are kept in the metadata cache, so invalidating it downloads
+// the mappings again.
+func entries(tvdbId int) ([]*Anime, bool) {
+	indexLock.Lock()
+	defer indexLock.Unlock()
+
+	var index map[string][]*Anime
+	if err := metacache.Get(metacache.AnimeMapping, animeListKey, &index); err != nil || index == nil {
+		if time.Since(lastFailure) < retryDelay {
+			return nil, false
+		}
+		downloaded, err := downloadIndex()
+		if err != nil {
+			log.Warning("Unable to get the AniDB mappings: %s", err)
+			lastFailure = time.Now()
+			return nil, false
+		}
+		index = downloaded
+		metacache.Set(metacache.AnimeMapping, animeListKey, index)
+	}
+	return index[strconv.Itoa(tvdbId)], true
+}
+
+// IsAnime tells whether the show is on AniDB. The second value is false
+// when the mappings couldn't be loaded, and we don't know.
+func IsAnime(tvdbId int) (bool, bool) {
+	animes, ok := entries(tvdbId)
+	return len(animes) > 0, ok
+}
+
+// mapped looks the TVDB episode up in the explicit mappings of the entry.
+func (anime *Anime) mapped(season int, episode int) (int, bool) {
+	for _, mapping := range anime.Mappings {
+		if mapping.TVDBSeason != season || mapping.AniDBSeason != 1 {
+			continue
+		}
+		for _, pair := range mapping.Episodes {
+			if pair.TVDB == episode {
+				return pair.AniDB, true
+			}
+		}
+		if mapping.Start > 0 || mapping.End > 0 {
+			number := episode - mapping.Offset
+			if number >= mapping.Start && (mapping.End == 0 || number <= mapping.End) {
+				return number, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Map finds the AniDB entry and episode number of a TVDB episode. Entries
+// split over the same TVDB season are told apart by their offset, the
+// closest one below the episode winning. Absolute is the TVDB absolute
+// number, for the entries that follow it.
+func Map(tvdbId int, season int, episode int, absolute int) *Episode {
+	animes, _ := entries(tvdbId)
+	for _, anime := range animes {
+		if number, ok := anime.mapped(season, episode); ok {
+			return &Episode{AniDBId: anime.AniDBId, Number: number}
+		}
+	}
+	var best *Anime
+	for _, anime := range animes {
+		target := episode
+		switch anime.DefaultTVDBSeason {
+		case absoluteSeason:
+			if absolute <= 0 {
+				continue
+			}
+			target = absolute
+		case strconv.Itoa(season):
+		default:
+			continue
+		}
+		if target-anime.EpisodeOffset < 1 {
+			continue
+		}
+		if best == nil || anime.EpisodeOffset > best.EpisodeOffset {
+			best = anime
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	number := episode - best.EpisodeOffset
+	if best.DefaultTVDBSeason == absoluteSeason {
+		number = absolute - best.EpisodeOffset
+	}
+	return &Episode{AniDBId: best.AniDBId, Number: number}
+}
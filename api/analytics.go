@@ -0,0 +1,16 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/analytics"
+	"github.com/steeve/pulsar/undo"
+)
+
+func Analytics(ctx *gin.Context) {
+	ctx.JSON(200, analytics.GetDashboard())
+}
+
+// The history is only cleared after the undo grace period.
+func AnalyticsClear(ctx *gin.Context) {
+	ctx.JSON(200, undo.Schedule("Clearing the search and playback history", analytics.Clear))
+}
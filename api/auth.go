@@ -0,0 +1,19 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/util"
+)
+
+// HTTPAuth rejects the requests from the LAN without credentials, when the
+// settings ask for them. The RPC has its own token.
+func HTTPAuth() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if ctx.Request.URL.Path != "/rpc" && util.RequestAllowed(ctx.Request) == false {
+			util.DenyUnauthorized(ctx.Writer)
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/store"
+)
+
+// backups are small, the caches aren't in there
+const maxBackupSize = 256 * 1024 * 1024
+
+// Backup downloads an archive of the data of all the profiles.
+func Backup(ctx *gin.Context) {
+	ctx.Writer.Header().Set("Content-Type", "application/zip")
+	ctx.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"pulsar-%s.zip\"", time.Now().Format("20060102")))
+	if err := store.Backup(ctx.Writer); err != nil {
+		log.Printf("Unable to write the backup: %s", err)
+	}
+}
+
+// Restore puts back the archive posted as the body. Pulsar needs a restart
+// for all of it to be taken in.
+func Restore(ctx *gin.Context) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBackupSize))
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	restored, err := store.Restore(bytes.NewReader(data), int64(len(data)))
+	if err == store.ErrBadArchive {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		ctx.JSON(500, gin.H{"error": err.Error(), "restored": restored})
+		return
+	}
+	ctx.JSON(200, gin.H{"restored": restored, "restart": true})
+}
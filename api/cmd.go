@@ -5,11 +5,33 @@ import (
 	"path/filepath"
 
 	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
 	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/health"
+	"github.com/steeve/pulsar/metacache"
 	"github.com/steeve/pulsar/xbmc"
 )
 
 func ClearCache(ctx *gin.Context) {
-	os.RemoveAll(filepath.Join(config.Get().Info.Profile, "cache"))
-	xbmc.Notify("Pulsar", "Cache cleared", config.AddonIcon())
+	cachePath := filepath.Join(config.Get().Info.Profile, "cache")
+	if confirmDestructive("Clear the cache", "Deletes the cached metadata and search results in "+cachePath) == false {
+		return
+	}
+	scheduleUndoable("Clearing the cache", func() {
+		os.RemoveAll(cachePath)
+		metacache.InvalidateAll()
+		xbmc.Notify("Pulsar", "Cache cleared", config.AddonIcon())
+	})
+}
+
+func BandwidthTest(ctx *gin.Context) {
+	xbmc.Notify("Pulsar", "Running bandwidth test...", config.AddonIcon())
+	health.BandwidthTest().Show("Pulsar bandwidth test")
+}
+
+func ConnectivityDoctor(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		xbmc.Notify("Pulsar", "Checking connectivity...", config.AddonIcon())
+		health.Doctor(btService).Show("Pulsar connectivity doctor")
+	}
 }
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"fmt"
+)
+
+const (
+	menuMovie   = "movie"
+	menuShow    = "show"
+	menuEpisode = "episode"
+	menuRoot    = "root"
+	menuResult  = "result"
+	menuSeeding = "seeding"
+)
+
+// menuTarget is what a list item is about.
+type menuTarget struct {
+	Kind    string
+	IMDBId  string
+	TMDBId  int
+	ShowId  int
+	Season  int
+	Episode int
+
+	CollectionId int
+	InWatchlist  bool
+
+	// root menu sections
+	Section string
+	Pinned  bool
+
+	// search results, and seeding torrents
+	InfoHash string
+	Forever  bool
+
+	// continue watching items
+	HistoryKey string
+}
+
+func (t *menuTarget) episodePath(action string) string {
+	return UrlForXBMC("/show/%d/season/%d/episode/%d/%s", t.ShowId, t.Season, t.Episode, action)
+}
+
+type menuAction struct {
+	Label   string
+	Kinds   []string
+	Command func(t *menuTarget) string
+	// optional, for actions that only make sense on some items
+	Available func(t *menuTarget) bool
+	// hidden in kiosk mode
+	Restricted bool
+}
+
+// contextActions is the table of actions offered on list items, by kind of
+// item. Add actions here rather than in the handlers.
+var contextActions = []*menuAction{
+	{
+		Label: "Choose stream...",
+		Kinds: []string{menuMovie, menuEpisode},
+		Command: func(t *menuTarget) string {
+			if t.Kind == menuMovie {
+				return fmt.Sprintf("XBMC.PlayMedia(%s)", UrlForXBMC("/movie/%s/links", t.IMDBId))
+			}
+			return fmt.Sprintf("XBMC.PlayMedia(%s)", t.episodePath("links"))
+		},
+	},
+	{
+		Label: "Choose stream (refresh results)...",
+		Kinds: []string{menuMovie, menuEpisode},
+		Command: func(t *menuTarget) string {
+			if t.Kind == menuMovie {
+				return fmt.Sprintf("XBMC.PlayMedia(%s)", UrlQuery(UrlForXBMC("/movie/%s/links", t.IMDBId), "refresh", "1"))
+			}
+			return fmt.Sprintf("XBMC.PlayMedia(%s)", UrlQuery(t.episodePath("links"), "refresh", "1"))
+		},
+	},
+	{
+		Label: "Download",
+		Kinds: []string{menuMovie, menuEpisode},
+		Command: func(t *menuTarget) string {
+			if t.Kind == menuMovie {
+				return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%s/download", t.IMDBId))
+			}
+			return fmt.Sprintf("XBMC.RunPlugin(%s)", t.episodePath("download"))
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Mark watched",
+		Kinds: []string{menuMovie, menuEpisode},
+		Command: func(t *menuTarget) string {
+			if t.Kind == menuMovie {
+				return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%s/watched", t.IMDBId))
+			}
+			return fmt.Sprintf("XBMC.RunPlugin(%s)", t.episodePath("watched"))
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Show similar",
+		Kinds: []string{menuMovie, menuShow},
+		Command: func(t *menuTarget) string {
+			if t.Kind == menuMovie {
+				return fmt.Sprintf("XBMC.Container.Update(%s)", UrlForXBMC("/movies/similar/%d", t.TMDBId))
+			}
+			return fmt.Sprintf("XBMC.Container.Update(%s)", UrlForXBMC("/shows/similar/%d", t.TMDBId))
+		},
+	},
+	{
+		Label: "Get entire collection",
+		Kinds: []string{menuMovie},
+		Command: func(t *menuTarget) string {
+			return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%s/collection", t.IMDBId))
+		},
+		Available: func(t *menuTarget) bool {
+			return t.CollectionId > 0
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Add to watchlist",
+		Kinds: []string{menuMovie, menuShow},
+		Command: func(t *menuTarget) string {
+			return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/watchlist/%s/add/%d", t.Kind, t.TMDBId))
+		},
+		Available: func(t *menuTarget) bool {
+			return t.InWatchlist == false
+		},
+	},
+	{
+		Label: "Remove from watchlist",
+		Kinds: []string{menuMovie, menuShow},
+		Command: func(t *menuTarget) string {
+			return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/watchlist/%s/remove/%d", t.Kind, t.TMDBId))
+		},
+		Available: func(t *menuTarget) bool {
+			return t.InWatchlist
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Add to library",
+		Kinds: []string{menuMovie, menuShow},
+		Command: func(t *menuTarget) string {
+			if t.Kind == menuMovie {
+				return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/movie/%s/add", t.IMDBId))
+			}
+			return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/library/show/%d/add", t.ShowId))
+		},
+		Available: func(t *menuTarget) bool {
+			return t.Kind == menuMovie || t.ShowId > 0
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Pin to top",
+		Kinds: []string{menuRoot},
+		Command: func(t *menuTarget) string {
+			return menuSectionCommand(t, "pin")
+		},
+		Available: func(t *menuTarget) bool {
+			return t.Pinned == false
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Unpin",
+		Kinds: []string{menuRoot},
+		Command: func(t *menuTarget) string {
+			return menuSectionCommand(t, "unpin")
+		},
+		Available: func(t *menuTarget) bool {
+			return t.Pinned
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Hide from menu",
+		Kinds: []string{menuRoot},
+		Command: func(t *menuTarget) string {
+			return menuSectionCommand(t, "hide")
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Reset menu",
+		Kinds: []string{menuRoot},
+		Command: func(t *menuTarget) string {
+			return fmt.Sprintf("XBMC.Container.Update(%s,replace)", UrlForXBMC("/menu/reset"))
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Provider debug",
+		Kinds: []string{menuMovie, menuEpisode},
+		Command: func(t *menuTarget) string {
+			if t.Kind == menuMovie {
+				return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/movie/%s/debug", t.IMDBId))
+			}
+			return fmt.Sprintf("XBMC.RunPlugin(%s)", t.episodePath("debug"))
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Remove from history",
+		Kinds: []string{menuMovie, menuEpisode},
+		Command: func(t *menuTarget) string {
+			return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/history/remove/%s", t.HistoryKey))
+		},
+		Available: func(t *menuTarget) bool {
+			return t.HistoryKey != ""
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Edit note...",
+		Kinds: []string{menuMovie, menuEpisode},
+		Command: func(t *menuTarget) string {
+			return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/history/note/%s", t.HistoryKey))
+		},
+		Available: func(t *menuTarget) bool {
+			return t.HistoryKey != ""
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Edit tags...",
+		Kinds: []string{menuMovie, menuEpisode},
+		Command: func(t *menuTarget) string {
+			return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/history/tags/%s", t.HistoryKey))
+		},
+		Available: func(t *menuTarget) bool {
+			return t.HistoryKey != ""
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Why this result?",
+		Kinds: []string{menuResult},
+		Command: func(t *menuTarget) string {
+			return fmt.Sprintf("XBMC.RunPlugin(%s)", UrlForXBMC("/result/%s/why", t.InfoHash))
+		},
+	},
+	{
+		Label: "Stop seeding",
+		Kinds: []string{menuSeeding},
+		Command: func(t *menuTarget) string {
+			return seedingCommand(t, "stop")
+		},
+		Restricted: true,
+	},
+	{
+		Label: "Keep seeding",
+		Kinds: []string{menuSeeding},
+		Command: func(t *menuTarget) string {
+			return seedingCommand(t, "keep")
+		},
+		Available: func(t *menuTarget) bool {
+			return t.Forever == false
+		},
+		Restricted: true,
+	},
+}
+
+func seedingCommand(t *menuTarget, action string) string {
+	return fmt.Sprintf("XBMC.Container.Update(%s,replace)", UrlForXBMC("/seeding/list/%s/%s", t.InfoHash, action))
+}
+
+func menuSectionCommand(t *menuTarget, action string) string {
+	return fmt.Sprintf("XBMC.Container.Update(%s,replace)", UrlForXBMC("/menu/section/%s/%s", t.Section, action))
+}
+
+func (action *menuAction) appliesTo(kind string) bool {
+	for _, k := range action.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func contextMenu(t *menuTarget) [][]string {
+	menu := make([][]string, 0)
+	kiosk := IsKiosk()
+	for _, action := range contextActions {
+		if action.Restricted && kiosk {
+			continue
+		}
+		if action.appliesTo(t.Kind) && (action.Available == nil || action.Available(t)) {
+			menu = append(menu, []string{action.Label, action.Command(t)})
+		}
+	}
+	return menu
+}
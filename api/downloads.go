@@ -0,0 +1,180 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/util"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+func AfterDownloads(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{"action": btService.AfterDownloads()})
+	}
+}
+
+// SetAfterDownloads overrides the after_downloads setting until restart.
+func SetAfterDownloads(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		action := ctx.Params.ByName("action")
+		if err := btService.SetAfterDownloads(action); err != nil {
+			ctx.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, gin.H{"action": action})
+	}
+}
+
+func DownloadsQueue(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		queue, err := queuePage(ctx, btService.Queue())
+		if err != nil {
+			ctx.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, queue)
+	}
+}
+
+func DownloadPause(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := btService.PauseDownload(ctx.Params.ByName("infoHash")); err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.String(200, "")
+	}
+}
+
+func DownloadResume(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := btService.ResumeDownload(ctx.Params.ByName("infoHash")); err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.String(200, "")
+	}
+}
+
+func DownloadPriority(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		priority, err := strconv.Atoi(ctx.Params.ByName("priority"))
+		if err != nil {
+			ctx.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := btService.SetDownloadPriority(ctx.Params.ByName("infoHash"), priority); err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.String(200, "")
+	}
+}
+
+func DownloadRemove(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		query := ctx.Request.URL.Query()
+		options := bittorrent.RemoveOptions{
+			DeleteFiles:      query.Get("files") == "1",
+			DeleteIncomplete: query.Get("incomplete") == "1",
+			DeleteFolder:     query.Get("folder") == "1",
+			DeleteExtras:     query.Get("extras") == "1",
+		}
+		if err := btService.RemoveTorrentWith(ctx.Params.ByName("infoHash"), options); err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.String(200, "")
+	}
+}
+
+// downloadBest queues the best of the links as a background download.
+func downloadBest(btService *bittorrent.BTService, title string, torrents []*bittorrent.Torrent) {
+	if len(torrents) == 0 {
+		xbmc.Notify("Pulsar", "No links were found", config.AddonIcon())
+		return
+	}
+	sort.Sort(sort.Reverse(providers.ByQuality(torrents)))
+	if err := btService.AddDownload(torrents[0].Magnet()); err != nil {
+		log.Printf("Unable to download %s: %s\n", title, err)
+		xbmc.Notify("Pulsar", "Unable to download "+title, config.AddonIcon())
+		return
+	}
+	xbmc.Notify("Pulsar", fmt.Sprintf("Queued %s", title), config.AddonIcon())
+}
+
+func queueItemLabel(item *bittorrent.QueueItem) string {
+	name := item.Name
+	if name == "" {
+		name = item.InfoHash
+	}
+	label := fmt.Sprintf("[%s] %.1f%% %s", item.State, item.Progress*100, name)
+	if item.State == bittorrent.QueueStateDownloading {
+		label += " - " + util.FormatSpeed(int64(item.DownloadRate))
+	} else if item.Added.IsZero() == false {
+		label += " - added " + util.FormatDateTime(item.Added)
+	}
+	return label
+}
+
+// ManageDownloads lets the user pause, resume, prioritize and remove the
+// queued downloads from Kodi.
+func ManageDownloads(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		queue := btService.Queue()
+		if len(queue) == 0 {
+			xbmc.Notify("Pulsar", "No downloads in the queue", config.AddonIcon())
+			return
+		}
+		labels := make([]string, 0, len(queue))
+		for _, item := range queue {
+			labels = append(labels, queueItemLabel(item))
+		}
+		choice := xbmc.ListDialog("Downloads", labels...)
+		if choice < 0 {
+			return
+		}
+		item := queue[choice]
+
+		pauseLabel := "Pause"
+		if item.Paused {
+			pauseLabel = "Resume"
+		}
+		var err error
+		switch xbmc.ListDialog(queueItemLabel(item), pauseLabel, "Download first", "Download later", "Remove") {
+		case 0:
+			if item.Paused {
+				err = btService.ResumeDownload(item.InfoHash)
+			} else {
+				err = btService.PauseDownload(item.InfoHash)
+			}
+		case 1:
+			err = btService.SetDownloadPriority(item.InfoHash, item.Priority+1)
+		case 2:
+			err = btService.SetDownloadPriority(item.InfoHash, item.Priority-1)
+		case 3:
+			options, ok := removeOptionsDialog(queueItemLabel(item))
+			if ok == false {
+				break
+			}
+			files, _ := btService.RemovalFiles(item.InfoHash, options)
+			if len(files) > 0 && confirmDestructive("Delete the files of "+queueItemLabel(item), files...) == false {
+				break
+			}
+			infoHash := item.InfoHash
+			scheduleUndoable("Removing "+queueItemLabel(item), func() {
+				btService.RemoveTorrentWith(infoHash, options)
+			})
+		}
+		if err != nil {
+			xbmc.Notify("Pulsar", err.Error(), config.AddonIcon())
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+)
+
+// Search and play endpoints given ?dry_run=1 run the whole pipeline but
+// answer with what they decided, without dialogs, notifications, analytics,
+// nor adding any torrent. It's to try settings and profiles out safely.
+
+func isDryRun(ctx *gin.Context) bool {
+	dryRun, _ := strconv.ParseBool(ctx.Request.URL.Query().Get("dry_run"))
+	return dryRun
+}
+
+// traceWithoutFailed is withoutFailed, telling the trace what it dropped.
+func traceWithoutFailed(trace *providers.SearchTrace, titleKey string, torrents []*bittorrent.Torrent) []*bittorrent.Torrent {
+	kept := withoutFailed(titleKey, torrents)
+	left := make(map[string]bool)
+	for _, torrent := range kept {
+		left[torrent.InfoHash] = true
+	}
+	for _, torrent := range torrents {
+		if left[torrent.InfoHash] == false {
+			trace.Drop(torrent, "failed", "failed to play before")
+		}
+	}
+	return kept
+}
+
+func movieDryRun(ctx *gin.Context, play bool) {
+	imdbId := ctx.Params.ByName("imdbId")
+	movie := tmdb.GetMovieFromIMDB(imdbId, config.Get().Language)
+	if movie == nil {
+		ctx.JSON(404, gin.H{"error": "movie " + imdbId + " not found"})
+		return
+	}
+	trace, torrents := providers.DryRunMovie(ctx.Request.Context(), providers.GetMovieSearchers(), movie)
+	torrents = traceWithoutFailed(trace, movieTitleKey(imdbId), torrents)
+	trace.Kept(torrents)
+	if play && len(torrents) > 0 {
+		// MoviePlay goes for the best quality
+		byQuality := make([]*bittorrent.Torrent, len(torrents))
+		copy(byQuality, torrents)
+		sort.Sort(sort.Reverse(providers.ByQuality(byQuality)))
+		trace.Pick(byQuality[0])
+	}
+	ctx.JSON(200, trace)
+}
+
+func episodeDryRun(ctx *gin.Context, play bool) {
+	showId := ctx.Params.ByName("showId")
+	seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
+	episodeNumber, _ := strconv.Atoi(ctx.Params.ByName("episode"))
+	show, err := tvdb.NewShowCached(showId, config.Get().Language)
+	if err != nil {
+		ctx.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	if seasonNumber < 0 || seasonNumber >= len(show.Seasons) || show.Seasons[seasonNumber] == nil ||
+		episodeNumber < 1 || episodeNumber > len(show.Seasons[seasonNumber].Episodes) {
+		ctx.JSON(404, gin.H{"error": "no such episode"})
+		return
+	}
+	episode := show.Seasons[seasonNumber].Episodes[episodeNumber-1]
+	trace, torrents := providers.DryRunEpisode(ctx.Request.Context(), providers.GetEpisodeSearchers(), show, episode)
+	torrents = traceWithoutFailed(trace, episodeTitleKey(showId, seasonNumber, episodeNumber), torrents)
+	trace.Kept(torrents)
+	if play && len(torrents) > 0 {
+		trace.Pick(torrents[0])
+	}
+	ctx.JSON(200, trace)
+}
+
+func searchDryRun(ctx *gin.Context) {
+	query := ctx.Request.URL.Query().Get("q")
+	if query == "" {
+		ctx.JSON(400, gin.H{"error": "dry runs take the query in q"})
+		return
+	}
+	trace, torrents := providers.DryRunSearch(ctx.Request.Context(), providers.GetSearchers(), query)
+	trace.Kept(torrents)
+	ctx.JSON(200, trace)
+}
+
+type startCandidate struct {
+	Name        string `json:"name"`
+	InfoHash    string `json:"info_hash"`
+	StartBudget int    `json:"start_budget"` // seconds, 0 for no limit
+}
+
+type playDryRun struct {
+	URI        string            `json:"uri"`
+	InfoHash   string            `json:"info_hash"`
+	Failed     bool              `json:"failed_before"`
+	Candidates []*startCandidate `json:"candidates"`
+}
+
+// playDryRunFor is what Play would try to buffer, in order.
+func playDryRunFor(uri string, query url.Values) *playDryRun {
+	requested := bittorrent.NewTorrent(uri)
+	budget := time.Duration(config.Get().StartBudget) * time.Second
+	failedLock.Lock()
+	failed := failedInfoHashes(titleKeyFromQuery(query))
+	failedLock.Unlock()
+	candidates := startCandidates(requested)
+	result := &playDryRun{
+		URI:        uri,
+		InfoHash:   requested.InfoHash,
+		Failed:     failed[strings.ToLower(requested.InfoHash)],
+		Candidates: make([]*startCandidate, 0, len(candidates)),
+	}
+	for i, candidate := range candidates {
+		c := &startCandidate{
+			Name:     candidate.Name,
+			InfoHash: candidate.InfoHash,
+		}
+		// the last one gets all the time it needs
+		if budget > 0 && i < len(candidates)-1 {
+			c.StartBudget = int(budget.Seconds())
+		}
+		result.Candidates = append(result.Candidates, c)
+	}
+	return result
+}
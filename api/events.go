@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/events"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const playbackEventInterval = 5 * time.Second
+
+// SubscribeEvents has the modules of the API follow the event bus.
+func SubscribeEvents() {
+	events.Handle(scrobble, events.PlaybackStarted, events.PlaybackPaused, events.PlaybackResumed, events.PlaybackStopped)
+	events.Handle(markWatched, events.PlaybackStopped)
+	events.Handle(syncWatched, events.PlaybackStopped)
+	events.Handle(notifyDownloadFinished, events.TorrentFinished)
+	events.Handle(followPlayback, events.PlaybackStarted, events.PlaybackProgress, events.PlaybackStopped)
+}
+
+// publishPlayback tells the bus how playback of the stream goes, so that the
+// modules following it don't all have to ask XBMC.
+func publishPlayback(player *bittorrent.BTPlayer, torrent *bittorrent.Torrent, query url.Values) {
+	tvdbId, _ := strconv.Atoi(query.Get("tvdb_id"))
+	season, _ := strconv.Atoi(query.Get("season"))
+	episode, _ := strconv.Atoi(query.Get("episode"))
+	playback := &events.Playback{
+		InfoHash: torrent.InfoHash,
+		IMDBId:   query.Get("imdb_id"),
+		TVDBId:   tvdbId,
+		Season:   season,
+		Episode:  episode,
+	}
+
+	streamEvents, done := player.StreamEvents()
+	defer close(done)
+
+	started := false
+	paused := false
+	ticker := time.NewTicker(playbackEventInterval)
+	defer ticker.Stop()
+	for playing := true; playing; {
+		select {
+		case _, ok := <-streamEvents:
+			playing = ok
+		case <-ticker.C:
+			if xbmc.PlayerIsPlaying() == false {
+				continue
+			}
+			// subscribers may hold on to the previous ones
+			current := *playback
+			current.Position, current.Duration = xbmc.PlayerTime(), xbmc.PlayerDuration()
+			playback = &current
+			if started {
+				if isPaused := xbmc.PlayerIsPaused(); isPaused != paused {
+					paused = isPaused
+					if paused {
+						events.Publish(events.PlaybackPaused, playback)
+					} else {
+						events.Publish(events.PlaybackResumed, playback)
+					}
+				}
+				events.Publish(events.PlaybackProgress, playback)
+			} else {
+				started = true
+				events.Publish(events.PlaybackStarted, playback)
+			}
+		}
+	}
+	if started {
+		events.Publish(events.PlaybackStopped, playback)
+	}
+}
+
+func notifyDownloadFinished(event *events.Event) {
+	if torrent := event.Data.(*events.Torrent); torrent.Download {
+		xbmc.Notify("Pulsar", fmt.Sprintf("%s is downloaded", torrent.Name), config.AddonIcon())
+	}
+}
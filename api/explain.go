@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/util"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// the raw payload is cut in lines of this many characters in the dialog
+const explainPayloadWidth = 80
+
+func ResultExplain(ctx *gin.Context) {
+	explanation, err := providers.ExplainResult(ctx.Params.ByName("infoHash"))
+	if err != nil {
+		ctx.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(200, explanation)
+}
+
+// ResultWhy shows in XBMC why a result is there and where it ranks.
+func ResultWhy(ctx *gin.Context) {
+	e, err := providers.ExplainResult(ctx.Params.ByName("infoHash"))
+	if err != nil {
+		xbmc.Notify("Pulsar", "This result is too old, search again", config.AddonIcon())
+		return
+	}
+	a := e.Attributes
+	lines := []string{
+		fmt.Sprintf("Provider: %s", e.Provider),
+		fmt.Sprintf("Rank: %d of %d", e.Rank, e.Results),
+		fmt.Sprintf("Resolution: %s, rip: %s, scene: %s", a.Resolution, a.RipType, a.SceneRating),
+		fmt.Sprintf("Codecs: %s / %s", a.VideoCodec, a.AudioCodec),
+		fmt.Sprintf("Release group: %s, language: %s", a.ReleaseGroup, a.Language),
+		fmt.Sprintf("Size: %s, seeds: %d, peers: %d", util.FormatSize(a.Size), a.Seeds, a.Peers),
+		fmt.Sprintf("Score: %.1f = resolution %.1f + codecs %.1f + seeds %.1f", e.Score.Total, e.Score.Resolution, e.Score.Codecs, e.Score.Seeds),
+	}
+	if e.Score.Nuked > 0 {
+		lines = append(lines, fmt.Sprintf("Score multiplied by %.2f as it's nuked", e.Score.Nuked))
+	}
+	if e.Score.SeasonPack > 0 {
+		lines = append(lines, fmt.Sprintf("Score multiplied by %.2f as it's a season pack", e.Score.SeasonPack))
+	}
+	lines = append(lines, fmt.Sprintf("Quality factor: %.0f", e.QualityFactor))
+	for _, filter := range e.Filters {
+		lines = append(lines, "Filters: "+filter)
+	}
+	if len(e.Payload) > 0 {
+		payload := strings.Replace(string(e.Payload), "\n", " ", -1)
+		for len(payload) > explainPayloadWidth {
+			lines = append(lines, "Payload: "+payload[:explainPayloadWidth])
+			payload = payload[explainPayloadWidth:]
+		}
+		lines = append(lines, "Payload: "+payload)
+	}
+	xbmc.ListDialog(e.Name, lines...)
+}
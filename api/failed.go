@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/profiles"
+)
+
+const (
+	failedBucket = "failed"
+	// swarms come back to life eventually
+	failedCacheTime = 7 * 24 * time.Hour
+	// cancelling right away is more likely a wrong click than a dead torrent
+	failedCancelAfter = 30 * time.Second
+)
+
+var failedLock = sync.Mutex{}
+
+func movieTitleKey(imdbId string) string {
+	return "movie." + imdbId
+}
+
+func episodeTitleKey(tvdbId string, season int, episode int) string {
+	return fmt.Sprintf("episode.%s.%d.%d", tvdbId, season, episode)
+}
+
+// titleKeyFromQuery finds what's being played from the /play query.
+func titleKeyFromQuery(query url.Values) string {
+	if imdbId := query.Get("imdb_id"); imdbId != "" {
+		return movieTitleKey(imdbId)
+	}
+	if tvdbId := query.Get("tvdb_id"); tvdbId != "" {
+		return fmt.Sprintf("episode.%s.%s.%s", tvdbId, query.Get("season"), query.Get("episode"))
+	}
+	return ""
+}
+
+func failedInfoHashes(titleKey string) map[string]bool {
+	infoHashes := map[string]bool{}
+	profiles.Current().Bucket(failedBucket).Get(titleKey, &infoHashes)
+	return infoHashes
+}
+
+// recordFailure remembers that the torrent didn't buffer for this title.
+func recordFailure(titleKey string, infoHash string, err error, buffering time.Duration) {
+	if titleKey == "" || infoHash == "" {
+		return
+	}
+	switch {
+	case err == bittorrent.ErrNotEnoughSpace:
+		return
+	case err == bittorrent.ErrBufferCanceled && buffering < failedCancelAfter:
+		return
+	}
+
+	failedLock.Lock()
+	defer failedLock.Unlock()
+	infoHashes := failedInfoHashes(titleKey)
+	infoHashes[strings.ToLower(infoHash)] = true
+	profiles.Current().Bucket(failedBucket).Set(titleKey, infoHashes, failedCacheTime)
+}
+
+// withoutFailed moves the torrents that already failed for this title at the
+// bottom of the list, or drops them if the settings say so.
+func withoutFailed(titleKey string, torrents []*bittorrent.Torrent) []*bittorrent.Torrent {
+	failedLock.Lock()
+	infoHashes := failedInfoHashes(titleKey)
+	failedLock.Unlock()
+	if len(infoHashes) == 0 {
+		return torrents
+	}
+
+	kept := make([]*bittorrent.Torrent, 0, len(torrents))
+	failed := make([]*bittorrent.Torrent, 0)
+	for _, torrent := range torrents {
+		if infoHashes[strings.ToLower(torrent.InfoHash)] {
+			failed = append(failed, torrent)
+		} else {
+			kept = append(kept, torrent)
+		}
+	}
+	if config.Get().HideFailedResults {
+		return kept
+	}
+	return append(kept, failed...)
+}
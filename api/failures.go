@@ -0,0 +1,139 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// failureAction is a way out of a failed search or buffering. Play actions
+// are opened by the player, the others are requested from the daemon.
+type failureAction struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+	Play  bool   `json:"play"`
+}
+
+// failure is what failed, and what the user can do about it.
+type failure struct {
+	Message string           `json:"message"`
+	Actions []*failureAction `json:"actions"`
+}
+
+var (
+	lastFailureLock = sync.Mutex{}
+	lastFailure     *failure
+)
+
+func playAction(label string, url string) *failureAction {
+	return &failureAction{Label: label, URL: url, Play: true}
+}
+
+func commandAction(label string, path string) *failureAction {
+	return &failureAction{Label: label, URL: urlForLocal("%s", path)}
+}
+
+// linksPath is the route listing every result of what's being played.
+func linksPath(query url.Values) string {
+	if imdbId := query.Get("imdb_id"); imdbId != "" {
+		return fmt.Sprintf("/movie/%s/links", url.PathEscape(imdbId))
+	}
+	if tvdbId := query.Get("tvdb_id"); tvdbId != "" {
+		return fmt.Sprintf("/show/%s/season/%s/episode/%s/links", url.PathEscape(tvdbId), url.PathEscape(query.Get("season")), url.PathEscape(query.Get("episode")))
+	}
+	return ""
+}
+
+func playQueryURL(query url.Values) string {
+	return UrlForXBMC("/play") + "?" + query.Encode()
+}
+
+func diagnosticsActions(f *failure) {
+	for _, status := range providers.ProvidersStatus() {
+		if status.Disabled && IsKiosk() == false {
+			f.Actions = append(f.Actions, commandAction("Re-enable failing providers", "/cmd/enable_providers"))
+			break
+		}
+	}
+	f.Actions = append(f.Actions, commandAction("Open diagnostics", "/cmd/doctor"))
+}
+
+// noLinksFailure is for searches that returned nothing, playPath being the
+// route to search again.
+func noLinksFailure(playPath string) *failure {
+	f := &failure{Message: "No links were found"}
+	f.Actions = append(f.Actions, playAction("Retry", UrlForXBMC("%s", playPath)))
+	diagnosticsActions(f)
+	return f
+}
+
+// bufferFailure is for torrents that didn't buffer, query being the one
+// they were played with.
+func bufferFailure(torrent *bittorrent.Torrent, query url.Values, err error) *failure {
+	f := &failure{Message: err.Error()}
+	f.Actions = append(f.Actions, playAction("Retry", playQueryURL(query)))
+	if path := linksPath(query); path != "" {
+		f.Actions = append(f.Actions, playAction("Pick another result", UrlForXBMC("%s", path)))
+	}
+	if lower := providers.LowerQuality(torrent.InfoHash); lower != nil {
+		lowerQuery := url.Values{}
+		for key, values := range query {
+			lowerQuery[key] = values
+		}
+		lowerQuery.Set("uri", lower.Magnet())
+		label := fmt.Sprintf("Lower quality: %s - %s", bittorrent.Resolutions[lower.Resolution], lower.Name)
+		f.Actions = append(f.Actions, playAction(label, playQueryURL(lowerQuery)))
+	}
+	diagnosticsActions(f)
+	return f
+}
+
+// showFailure asks what to do about the failure, and does it. It's meant to
+// run once the request that failed is over, so that XBMC is done with it.
+func showFailure(f *failure) {
+	lastFailureLock.Lock()
+	lastFailure = f
+	lastFailureLock.Unlock()
+
+	labels := make([]string, 0, len(f.Actions))
+	for _, action := range f.Actions {
+		labels = append(labels, action.Label)
+	}
+	choice := xbmc.ListDialog(f.Message, labels...)
+	if choice < 0 {
+		return
+	}
+	action := f.Actions[choice]
+	if action.Play {
+		xbmc.PlayURL(action.URL)
+		return
+	}
+	resp, err := http.Get(action.URL)
+	if err != nil {
+		log.Printf("Unable to run %s: %s\n", action.Label, err)
+		return
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+}
+
+// LastFailure returns the actions offered for the last failure, so other
+// frontends can offer them too.
+func LastFailure(ctx *gin.Context) {
+	lastFailureLock.Lock()
+	f := lastFailure
+	lastFailureLock.Unlock()
+	if f == nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+	ctx.JSON(200, f)
+}
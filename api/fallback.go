@@ -0,0 +1,103 @@
+package api
+
+import (
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/providers"
+)
+
+// how many other results we try when a torrent misses the start budget
+const startFallbacks = 2
+
+func newPlayer(btService *bittorrent.BTService, torrent *bittorrent.Torrent, query url.Values) *bittorrent.BTPlayer {
+	magnet := torrent.Magnet()
+	boosters := url.Values{
+		"tr": providers.DefaultTrackers,
+	}
+	magnet += "&" + boosters.Encode()
+	if torrent.Provider != "" {
+		btService.SetTorrentSource(torrent.InfoHash, torrent.Provider)
+	} else if result, _ := providers.FindResult(torrent.InfoHash); result != nil {
+		btService.SetTorrentSource(torrent.InfoHash, result.Provider)
+	}
+	player := bittorrent.NewBTPlayer(btService, magnet, config.Get().KeepFilesAfterStop == false)
+	// in case it's a season pack
+	season, _ := strconv.Atoi(query.Get("season"))
+	episode, _ := strconv.Atoi(query.Get("episode"))
+	if episode > 0 {
+		player.SetEpisode(season, episode)
+	}
+	if tvdbId, err := strconv.Atoi(query.Get("tvdb_id")); err == nil && config.Get().AnimeVerifyCRC && providers.IsAnimeShow(tvdbId) {
+		player.SetAnime()
+	}
+	return player
+}
+
+// startCandidates is the torrent, the next best results of the same search,
+// and the torrent again, which will have kept downloading in the meantime.
+func startCandidates(torrent *bittorrent.Torrent) []*bittorrent.Torrent {
+	candidates := []*bittorrent.Torrent{torrent}
+	for _, alternative := range providers.Alternatives(torrent.InfoHash) {
+		if len(candidates) > startFallbacks {
+			break
+		}
+		if alternative.InfoHash != torrent.InfoHash {
+			candidates = append(candidates, alternative)
+		}
+	}
+	if len(candidates) > 1 {
+		candidates = append(candidates, torrent)
+	}
+	return candidates
+}
+
+// bufferWithFallback buffers the torrent, falling back to the next results
+// when it can't start within the start budget, when it's a magnet we can't
+// get the metadata of, or when it's quarantined.
+func bufferWithFallback(btService *bittorrent.BTService, torrent *bittorrent.Torrent, query url.Values) (*bittorrent.BTPlayer, *bittorrent.Torrent, error) {
+	titleKey := titleKeyFromQuery(query)
+	budget := time.Duration(config.Get().StartBudget) * time.Second
+	candidates := startCandidates(torrent)
+	deadMagnets := make(map[string]bool)
+
+	var err error
+	for i, candidate := range candidates {
+		if deadMagnets[candidate.InfoHash] {
+			continue
+		}
+		player := newPlayer(btService, candidate, query)
+		// the file played before, only for the torrent it was in
+		if file, err := strconv.Atoi(query.Get("file")); err == nil && candidate.InfoHash == torrent.InfoHash {
+			player.SetFile(file)
+		}
+		// the last one gets all the time it needs
+		if budget > 0 && i < len(candidates)-1 {
+			player.SetStartBudget(budget)
+		}
+		bufferStart := time.Now()
+		if err = player.Buffer(); err == nil {
+			return player, candidate, nil
+		}
+		switch err {
+		case bittorrent.ErrStartBudget:
+			log.Printf("%s didn't start within %s, trying the next result\n", candidate.Name, budget)
+		case bittorrent.ErrMetadataTimeout:
+			recordFailure(titleKey, candidate.InfoHash, err, time.Since(bufferStart))
+			deadMagnets[candidate.InfoHash] = true
+			log.Printf("No metadata for %s, trying the next result\n", candidate.Name)
+		case bittorrent.ErrQuarantined:
+			recordFailure(titleKey, candidate.InfoHash, err, time.Since(bufferStart))
+			deadMagnets[candidate.InfoHash] = true
+			log.Printf("%s is quarantined, trying the next result\n", candidate.Name)
+		default:
+			recordFailure(titleKey, candidate.InfoHash, err, time.Since(bufferStart))
+			return nil, nil, err
+		}
+	}
+	return nil, nil, err
+}
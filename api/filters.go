@@ -0,0 +1,35 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/providers"
+)
+
+func GetFilters(ctx *gin.Context) {
+	ctx.JSON(200, providers.CurrentFilterRules())
+}
+
+// SetFilters takes the rules as JSON, and overrides the settings with them.
+func SetFilters(ctx *gin.Context) {
+	rules := &providers.FilterRules{}
+	if err := json.NewDecoder(ctx.Request.Body).Decode(rules); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if err := providers.SetFilterRules(rules); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ctx.JSON(200, rules)
+}
+
+// ResetFilters goes back to the rules from the settings.
+func ResetFilters(ctx *gin.Context) {
+	if err := providers.SetFilterRules(nil); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, providers.CurrentFilterRules())
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/steeve/pulsar/bittorrent"
+)
+
+var (
+	// only the tags of sites, as a leading [group] like fansubs' tells
+	// releases apart
+	siteTagRe   = regexp.MustCompile(`(?i)^(\[\s*(www\.)?[^\]\s]+\.(com|org|net|to|me|cc|tv|info|ws)\s*\]|\[(ettv|eztv|rarbg|publichd|vtv)\]|www\.[^\s]+\s*-)\s*|\s*\[(ettv|eztv|rarbg|publichd|vtv)\]$`)
+	separatorRe = regexp.MustCompile(`[\s._\-]+`)
+)
+
+// A release, as seen on several providers.
+type releaseGroup struct {
+	best    *bittorrent.Torrent
+	mirrors int
+}
+
+// canonicalRelease turns a torrent name into what's left once the site tags,
+// separators and case differences are gone.
+func canonicalRelease(name string) string {
+	name = strings.TrimSpace(name)
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mkv", ".mp4", ".avi", ".torrent":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	name = siteTagRe.ReplaceAllString(name, "")
+	name = separatorRe.ReplaceAllString(strings.ToLower(name), " ")
+	return strings.TrimSpace(name)
+}
+
+// groupByRelease collapses the torrents of the same release. They are
+// expected to be sorted best first, so the first of a group is its best
+// mirror.
+func groupByRelease(torrents []*bittorrent.Torrent) []*releaseGroup {
+	groups := make([]*releaseGroup, 0, len(torrents))
+	byName := map[string]*releaseGroup{}
+	for _, torrent := range torrents {
+		key := canonicalRelease(torrent.Name)
+		if group, exists := byName[key]; exists && key != "" {
+			group.mirrors++
+			continue
+		}
+		group := &releaseGroup{best: torrent, mirrors: 1}
+		byName[key] = group
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func (group *releaseGroup) label(label string) string {
+	if group.mirrors > 1 {
+		return fmt.Sprintf("%s (%d mirrors)", label, group.mirrors)
+	}
+	return label
+}
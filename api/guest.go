@@ -0,0 +1,158 @@
+package api
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/events"
+	"github.com/steeve/pulsar/util"
+)
+
+// The guest API is served on its own port, for dashboards and roommates: it
+// only reads, and only what's in guest_endpoints, all of it if that's
+// empty. With a guest_token, it wants it as a bearer token or in ?token=.
+
+const (
+	guestStatus     = "status"
+	guestNowPlaying = "now_playing"
+	guestProgress   = "progress"
+)
+
+var (
+	nowPlayingLock = sync.Mutex{}
+	nowPlaying     *events.Playback
+)
+
+// followPlayback keeps what's playing for the guests, who can't ask XBMC.
+func followPlayback(event *events.Event) {
+	nowPlayingLock.Lock()
+	defer nowPlayingLock.Unlock()
+	if event.Topic == events.PlaybackStopped {
+		nowPlaying = nil
+		return
+	}
+	nowPlaying = event.Data.(*events.Playback)
+}
+
+func currentPlayback() *events.Playback {
+	nowPlayingLock.Lock()
+	defer nowPlayingLock.Unlock()
+	return nowPlaying
+}
+
+func guestEndpointEnabled(endpoint string) bool {
+	endpoints := config.Get().GuestEndpoints
+	if len(endpoints) == 0 {
+		return true
+	}
+	for _, enabled := range endpoints {
+		if strings.TrimSpace(enabled) == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func guestGuard(endpoint string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if guestEndpointEnabled(endpoint) == false {
+			ctx.AbortWithStatus(404)
+			return
+		}
+		if token := config.Get().GuestToken; token != "" {
+			given := ctx.Request.URL.Query().Get("token")
+			if header := ctx.Request.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+				given = strings.TrimPrefix(header, "Bearer ")
+			}
+			if util.SameSecret(given, token) == false {
+				ctx.JSON(401, gin.H{"error": "bad or missing token"})
+				ctx.Abort()
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
+
+// GuestRoutes are the routes of the guest API. Any other, and any method
+// but GET, is a 404.
+func GuestRoutes(btService *bittorrent.BTService) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	r.GET("/status", guestGuard(guestStatus), GuestStatus(btService))
+	r.GET("/now_playing", guestGuard(guestNowPlaying), GuestNowPlaying(btService))
+	r.GET("/progress", guestGuard(guestProgress), GuestProgress(btService))
+
+	return r
+}
+
+func GuestStatus(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		torrents := btService.Torrents()
+		downloadRate, uploadRate, downloading := 0, 0, 0
+		for _, torrent := range torrents {
+			downloadRate += torrent.DownloadRate
+			uploadRate += torrent.UploadRate
+			if torrent.Download && torrent.Progress < 1 {
+				downloading++
+			}
+		}
+		ctx.JSON(200, gin.H{
+			"version":       config.Get().Info.Version,
+			"playing":       currentPlayback() != nil,
+			"torrents":      len(torrents),
+			"downloading":   downloading,
+			"download_rate": downloadRate,
+			"upload_rate":   uploadRate,
+		})
+	}
+}
+
+func GuestNowPlaying(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		playback := currentPlayback()
+		if playback == nil {
+			ctx.JSON(200, gin.H{"playing": false})
+			return
+		}
+		name := ""
+		if torrent, err := btService.TorrentStatus(playback.InfoHash); err == nil {
+			name = torrent.Name
+		}
+		ctx.JSON(200, gin.H{
+			"playing":  true,
+			"name":     name,
+			"imdb_id":  playback.IMDBId,
+			"tvdb_id":  playback.TVDBId,
+			"season":   playback.Season,
+			"episode":  playback.Episode,
+			"position": int(playback.Position.Seconds()),
+			"duration": int(playback.Duration.Seconds()),
+			"progress": playback.Progress(),
+		})
+	}
+}
+
+// GuestProgress lists the background downloads, without their info hashes.
+func GuestProgress(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		downloads := make([]gin.H, 0)
+		for _, torrent := range btService.Torrents() {
+			if torrent.Download == false {
+				continue
+			}
+			downloads = append(downloads, gin.H{
+				"name":          torrent.Name,
+				"state":         torrent.State,
+				"progress":      torrent.Progress,
+				"size":          torrent.Size,
+				"download_rate": torrent.DownloadRate,
+			})
+		}
+		ctx.JSON(200, downloads)
+	}
+}
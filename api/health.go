@@ -0,0 +1,51 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/health"
+)
+
+func Health(ctx *gin.Context) {
+	report := health.LastReport()
+	if report == nil {
+		ctx.JSON(503, gin.H{"error": "self-test is still running"})
+		return
+	}
+	status := 200
+	if report.OK == false {
+		status = 503
+	}
+	ctx.JSON(status, report)
+}
+
+// Healthz only tells that the process is alive and serving requests.
+func Healthz(ctx *gin.Context) {
+	ctx.String(200, "ok")
+}
+
+func Readyz(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		report := health.Ready(btService)
+		status := 200
+		if report.OK == false {
+			status = 503
+		}
+		ctx.JSON(status, report)
+	}
+}
+
+func Bandwidth(ctx *gin.Context) {
+	ctx.JSON(200, health.BandwidthTest())
+}
+
+func Doctor(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		report := health.Doctor(btService)
+		if ctx.Request.URL.Query().Get("format") == "text" {
+			ctx.String(200, "%s", report.String())
+			return
+		}
+		ctx.JSON(200, report)
+	}
+}
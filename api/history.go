@@ -0,0 +1,236 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/clock"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/history"
+	"github.com/steeve/pulsar/localsearch"
+	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// how often the position is saved while playing
+const historySaveInterval = 30 * time.Second
+
+// historyTitle returns the title of the entry, and its year if known.
+func historyTitle(entry *history.Entry) (string, int) {
+	language := config.Get().Language
+	if entry.IMDBId != "" {
+		if movie := tmdb.GetMovieFromIMDB(entry.IMDBId, language); movie != nil {
+			year, _ := strconv.Atoi(strings.Split(movie.ReleaseDate, "-")[0])
+			return movie.Title, year
+		}
+		return entry.IMDBId, 0
+	}
+	if show, err := tvdb.NewShowCached(strconv.Itoa(entry.TVDBId), language); err == nil {
+		year, _ := strconv.Atoi(strings.Split(show.FirstAired, "-")[0])
+		return fmt.Sprintf("%s %dx%02d", show.SeriesName, entry.Season, entry.Episode), year
+	}
+	return entry.Key, 0
+}
+
+// recordHistory remembers the torrent and the file being played, then the
+// position reached, for the title of the /play query.
+func recordHistory(player *bittorrent.BTPlayer, torrent *bittorrent.Torrent, query url.Values) {
+	titleKey := titleKeyFromQuery(query)
+	if titleKey == "" {
+		return
+	}
+	entry := &history.Entry{
+		Key:       titleKey,
+		IMDBId:    query.Get("imdb_id"),
+		URI:       torrent.URI,
+		InfoHash:  torrent.InfoHash,
+		FileIndex: player.FileIndex(),
+		Provider:  torrent.Provider,
+	}
+	if entry.Provider == "" {
+		if result, _ := providers.FindResult(torrent.InfoHash); result != nil {
+			entry.Provider = result.Provider
+		}
+	}
+	entry.TVDBId, _ = strconv.Atoi(query.Get("tvdb_id"))
+	entry.Season, _ = strconv.Atoi(query.Get("season"))
+	entry.Episode, _ = strconv.Atoi(query.Get("episode"))
+	entry.Title, entry.Year = historyTitle(entry)
+	if last := history.Get(titleKey); last != nil && strings.EqualFold(last.InfoHash, entry.InfoHash) {
+		entry.Position, entry.Duration, entry.Watched = last.Position, last.Duration, last.Watched
+	}
+	if err := history.Save(entry); err != nil {
+		log.Printf("Unable to save %s in the history: %s\n", entry.Title, err)
+		return
+	}
+	localsearch.Invalidate()
+
+	events, done := player.StreamEvents()
+	defer close(done)
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	lastSave := clock.Now()
+	for playing := true; playing; {
+		select {
+		case _, ok := <-events:
+			playing = ok
+		case <-ticker.C:
+			if xbmc.PlayerIsPlaying() == false {
+				continue
+			}
+			entry.Position = int(xbmc.PlayerTime().Seconds())
+			entry.Duration = int(xbmc.PlayerDuration().Seconds())
+			if clock.Since(lastSave) >= historySaveInterval {
+				history.Save(entry)
+				lastSave = clock.Now()
+			}
+		}
+	}
+	if entry.Duration > 0 && float64(entry.Position) >= float64(entry.Duration)*watchedPercent {
+		entry.Watched = true
+	}
+	history.Save(entry)
+}
+
+// historyPlayURL plays the torrent of the entry again, from where it was
+// left when resuming.
+func historyPlayURL(entry *history.Entry, resume bool) string {
+	query := []string{"uri", entry.URI, "file", strconv.Itoa(entry.FileIndex)}
+	if entry.IMDBId != "" {
+		query = append(query, "imdb_id", entry.IMDBId)
+	} else {
+		query = append(query,
+			"tvdb_id", strconv.Itoa(entry.TVDBId),
+			"season", strconv.Itoa(entry.Season),
+			"episode", strconv.Itoa(entry.Episode))
+	}
+	if resume && entry.InProgress() {
+		query = append(query, "t", strconv.Itoa(entry.Position))
+	}
+	return UrlQuery(UrlForXBMC("/play"), query...)
+}
+
+// historyTorrent is the torrent played the last time for this title,
+// unless it failed since.
+func historyTorrent(titleKey string) *history.Entry {
+	entry := history.Get(titleKey)
+	if entry == nil || entry.URI == "" {
+		return nil
+	}
+	failedLock.Lock()
+	failed := failedInfoHashes(titleKey)
+	failedLock.Unlock()
+	if failed[strings.ToLower(entry.InfoHash)] {
+		return nil
+	}
+	return entry
+}
+
+// playableHistoryTorrent is historyTorrent, unless its files were kept
+// and aren't all there anymore, when a new search is better.
+func playableHistoryTorrent(btService *bittorrent.BTService, titleKey string) *history.Entry {
+	entry := historyTorrent(titleKey)
+	if entry == nil {
+		return nil
+	}
+	if err := btService.VerifyKept(entry.InfoHash); err != nil {
+		log.Printf("Not playing %s from the torrent of the last time: %s\n", entry.Title, err)
+		return nil
+	}
+	return entry
+}
+
+// ContinueWatching lists the movies and episodes left unfinished.
+func ContinueWatching(ctx *gin.Context) {
+	ctx.JSON(200, xbmc.NewView("", historyItems(history.ContinueWatching())))
+}
+
+// historyItems shows the entries with how far they were watched, and their
+// tags and note.
+func historyItems(entries []*history.Entry) xbmc.ListItems {
+	items := make(xbmc.ListItems, 0, len(entries))
+	for _, entry := range entries {
+		label := entry.Title
+		if entry.InProgress() && entry.Duration > 0 {
+			label = fmt.Sprintf("%s (%d%%)", label, entry.Position*100/entry.Duration)
+		}
+		if len(entry.Tags) > 0 {
+			label = fmt.Sprintf("%s [%s]", label, strings.Join(entry.Tags, ", "))
+		}
+		target := &menuTarget{Kind: menuEpisode, HistoryKey: entry.Key}
+		if entry.IMDBId != "" {
+			target.Kind = menuMovie
+			target.IMDBId = entry.IMDBId
+		} else {
+			target.ShowId, target.Season, target.Episode = entry.TVDBId, entry.Season, entry.Episode
+		}
+		item := &xbmc.ListItem{
+			Label:      label,
+			Path:       UrlForXBMC("/history/play/%s", entry.Key),
+			IsPlayable: true,
+			Properties: map[string]string{
+				"ResumeTime": strconv.Itoa(entry.Position),
+				"TotalTime":  strconv.Itoa(entry.Duration),
+			},
+			ContextMenu: contextMenu(target),
+		}
+		if entry.Note != "" {
+			item.Info = &xbmc.ListItemInfo{Plot: entry.Note}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// HistoryEntries returns the history, or the entries matching q and
+// having tag, if given, paged as in listing.go.
+func HistoryEntries(ctx *gin.Context) {
+	query := ctx.Request.URL.Query()
+	entries := history.List()
+	if query.Get("q") != "" || query.Get("tag") != "" {
+		entries = history.Search(query.Get("q"), query.Get("tag"))
+	}
+	entries, err := historyPage(ctx, entries)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(200, entries)
+}
+
+func HistoryPlay(ctx *gin.Context) {
+	entry := history.Get(ctx.Params.ByName("key"))
+	if entry == nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+	ctx.Redirect(302, historyPlayURL(entry, true))
+}
+
+func HistoryRemove(ctx *gin.Context) {
+	if err := history.Remove(ctx.Params.ByName("key")); err != nil {
+		ctx.Error(err)
+		return
+	}
+	localsearch.Invalidate()
+	xbmc.Notify("Pulsar", "Removed from the history", config.AddonIcon())
+	ctx.String(200, "")
+}
+
+func HistoryClear(ctx *gin.Context) {
+	if err := history.Clear(); err != nil {
+		ctx.Error(err)
+		return
+	}
+	localsearch.Invalidate()
+	ctx.String(200, "")
+}
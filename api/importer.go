@@ -0,0 +1,88 @@
+package api
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/importer"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// ImportCandidates lists the addons and services found to import from.
+func ImportCandidates(ctx *gin.Context) {
+	ctx.JSON(200, importer.Detect())
+}
+
+// ImportFrom imports from the source, ?path= being the folder of a .strm
+// library or of an addon, or the slug of a Trakt list.
+func ImportFrom(ctx *gin.Context) {
+	report, err := importer.Import(ctx.Params.ByName("source"), ctx.Request.URL.Query().Get("path"))
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(200, report)
+}
+
+// runImport imports from the candidate the user picked, or from a .strm
+// library or a Trakt list they type, and tells them how it went.
+func runImport(candidates []*importer.Candidate) {
+	labels := make([]string, 0, len(candidates)+2)
+	for _, candidate := range candidates {
+		labels = append(labels, candidate.Label)
+	}
+	labels = append(labels, "A library of .strm files", "A Trakt list")
+	choice := xbmc.ListDialog("Import from other addons", labels...)
+	if choice < 0 {
+		return
+	}
+	var source, path string
+	switch {
+	case choice < len(candidates):
+		source, path = candidates[choice].Source, candidates[choice].Path
+	case choice == len(candidates):
+		source = importer.Strm
+		if path = xbmc.Keyboard("", "Folder of the library"); path == "" {
+			return
+		}
+	default:
+		source = importer.Trakt
+		if path = xbmc.Keyboard("", "Slug of the Trakt list"); path == "" {
+			return
+		}
+	}
+	xbmc.Notify("Pulsar", "Importing...", config.AddonIcon())
+	report, err := importer.Import(source, path)
+	if err != nil {
+		xbmc.Notify("Pulsar", err.Error(), config.AddonIcon())
+		return
+	}
+	message := "Imported " + report.String()
+	if len(report.Errors) > 0 {
+		message += ", with errors, see the logs"
+	}
+	xbmc.Notify("Pulsar", message, config.AddonIcon())
+}
+
+// ImportAssistant is the XBMC command importing from other addons.
+func ImportAssistant(ctx *gin.Context) {
+	runImport(importer.Detect())
+}
+
+// OfferImport offers, once, to import from the addons found next to Pulsar
+// at startup.
+func OfferImport() {
+	if IsKiosk() || importer.Offered() {
+		return
+	}
+	candidates := importer.Detect()
+	if len(candidates) == 0 {
+		return
+	}
+	// cancelled or not, the import command is there to come back to it
+	if err := importer.SetOffered(); err != nil {
+		log.Printf("Unable to remember the import was offered: %s", err)
+	}
+	runImport(candidates)
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/undo"
 	"github.com/steeve/pulsar/xbmc"
 )
 
@@ -17,11 +18,37 @@ func Index(ctx *gin.Context) {
 		return
 	}
 
-	ctx.JSON(200, xbmc.NewView("", xbmc.ListItems{
-		{Label: "Movies", Path: UrlForXBMC("/movies/"), Thumbnail: config.AddonResource("img", "movies.png")},
-		{Label: "TV Shows", Path: UrlForXBMC("/shows/"), Thumbnail: config.AddonResource("img", "tv.png")},
+	sections := []*menuSection{
+		{"movies", &xbmc.ListItem{Label: "Movies", Path: UrlForXBMC("/movies/"), Thumbnail: config.AddonResource("img", "movies.png")}},
+		{"shows", &xbmc.ListItem{Label: "TV Shows", Path: UrlForXBMC("/shows/"), Thumbnail: config.AddonResource("img", "tv.png")}},
 
-		{Label: "Search", Path: UrlForXBMC("/search"), Thumbnail: config.AddonResource("img", "search.png")},
-		{Label: "Paste URL", Path: UrlForXBMC("/pasted"), Thumbnail: config.AddonResource("img", "magnet.png")},
-	}))
+		{"history", &xbmc.ListItem{Label: "Continue watching", Path: UrlForXBMC("/history/"), Thumbnail: config.AddonResource("img", "movies.png")}},
+		{"history_list", &xbmc.ListItem{Label: "History", Path: UrlForXBMC("/history/list"), Thumbnail: config.AddonResource("img", "movies.png")}},
+
+		{"search", &xbmc.ListItem{Label: "Search", Path: UrlForXBMC("/search"), Thumbnail: config.AddonResource("img", "search.png")}},
+		{"local_search", &xbmc.ListItem{Label: "Search your titles", Path: UrlForXBMC("/localsearch"), Thumbnail: config.AddonResource("img", "search.png")}},
+		{"pasted", &xbmc.ListItem{Label: "Paste URL", Path: UrlForXBMC("/pasted"), Thumbnail: config.AddonResource("img", "magnet.png")}},
+		{"downloads", &xbmc.ListItem{Label: "Downloads", Path: UrlForXBMC("/cmd/downloads"), Thumbnail: config.AddonResource("img", "magnet.png")}},
+	}
+	if config.Get().TraktClientId != "" {
+		sections = append(sections, &menuSection{"trakt", &xbmc.ListItem{Label: "Trakt", Path: UrlForXBMC("/trakt/"), Thumbnail: config.AddonResource("img", "popular.png")}})
+	}
+	layout := currentMenuLayout()
+	for _, section := range sections {
+		section.Item.ContextMenu = contextMenu(&menuTarget{
+			Kind:    menuRoot,
+			Section: section.Id,
+			Pinned:  contains(layout.Pinned, section.Id),
+		})
+	}
+	items := layout.apply(sections)
+	// only while there's something to undo
+	for _, action := range undo.Pending() {
+		items = append(items, &xbmc.ListItem{
+			Label: "Undo: " + action.Description,
+			Path:  UrlForXBMC("/cmd/undo/%s", action.Id),
+		})
+	}
+
+	ctx.JSON(200, xbmc.NewView("", items))
 }
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/store"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const kioskKey = "kiosk"
+
+// Kiosk mode is for handing the remote to guests or kids: browsing and
+// playback work, changing settings, deleting things and managing providers
+// don't.
+var (
+	kioskLock    = sync.Mutex{}
+	kioskEnabled *bool
+)
+
+// non GET routes that are fine in kiosk mode
+var kioskAllowedWrites = []string{
+	"/callbacks/",
+	"/subtitles/offset",
+	"/together/",
+}
+
+// GET routes that are fine in kiosk mode, as path.Match patterns: browsing,
+// searching and playing. Some GET routes change things, the others are
+// restricted.
+var kioskAllowedReads = []string{
+	"/",
+	"/health",
+	"/healthz",
+	"/readyz",
+	"/search",
+	"/localsearch",
+	"/localsearch/entries",
+	"/movies/",
+	"/movies/*",
+	"/movies/popular/*",
+	"/movies/similar/*",
+	"/movie/*/links",
+	"/movie/*/links/stream",
+	"/movie/*/play",
+	"/shows/",
+	"/shows/*",
+	"/shows/popular/*",
+	"/shows/similar/*",
+	"/show/*/seasons",
+	"/show/*/season/*/episodes",
+	"/show/*/season/*/episode/*/links",
+	"/show/*/season/*/episode/*/links/stream",
+	"/show/*/season/*/episode/*/play",
+	"/widgets/*",
+	"/widgets/*/*",
+	"/widgets/json/*",
+	"/widgets/json/*/*",
+	"/trakt/",
+	"/trakt/movies/*",
+	"/trakt/shows/*",
+	"/watchlist/*/add/*",
+	"/history/",
+	"/history/entries",
+	"/history/play/*",
+	"/history/list",
+	"/history/search",
+	"/history/tags",
+	"/library/shows",
+	"/youtube/*",
+	"/subtitles",
+	"/subtitles/search",
+	"/subtitles/offset",
+	"/subtitle/*",
+	"/play",
+	"/result/*",
+	"/result/*/why",
+	"/player/markers",
+	"/player/stats",
+	"/queue/",
+	"/remote/",
+	"/remote/search",
+	"/remote/torrents",
+	"/remote/torrents/*",
+	"/kiosk",
+	"/menu",
+	"/filters",
+	"/profiles",
+	"/profiles/current",
+	"/together/*",
+	"/together/*/events",
+	"/callbacks/*",
+	"/failures/last",
+	"/cmd/subtitles",
+	"/cmd/subtitles/sync",
+}
+
+// GET routes of any depth under these are fine too
+var kioskAllowedReadPrefixes = []string{
+	"/repository/",
+	"/usenet/",
+}
+
+func kioskStore() *store.Bucket {
+	return store.Global("kiosk")
+}
+
+// IsKiosk tells whether kiosk mode is on, from the settings or the API.
+func IsKiosk() bool {
+	if config.Get().KioskMode {
+		return true
+	}
+	kioskLock.Lock()
+	defer kioskLock.Unlock()
+	if kioskEnabled == nil {
+		enabled := false
+		kioskStore().Get(kioskKey, &enabled)
+		kioskEnabled = &enabled
+	}
+	return *kioskEnabled
+}
+
+func setKiosk(enabled bool) error {
+	kioskLock.Lock()
+	defer kioskLock.Unlock()
+	kioskEnabled = &enabled
+	return kioskStore().Set(kioskKey, enabled, store.FOREVER)
+}
+
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func kioskRestricted(req *http.Request) bool {
+	name := req.URL.Path
+	if strings.HasPrefix(name, "/kiosk") {
+		return false
+	}
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return hasAnyPrefix(name, kioskAllowedWrites) == false
+	}
+	return matchesAny(name, kioskAllowedReads) == false && hasAnyPrefix(name, kioskAllowedReadPrefixes) == false
+}
+
+// KioskGuard rejects the restricted actions while in kiosk mode.
+func KioskGuard() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if kioskRestricted(ctx.Request) && IsKiosk() {
+			if strings.HasPrefix(ctx.Request.URL.Path, "/cmd/") {
+				xbmc.Notify("Pulsar", "Not available in kiosk mode.", config.AddonIcon())
+			}
+			ctx.AbortWithStatus(403)
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func KioskStatus(ctx *gin.Context) {
+	ctx.JSON(200, gin.H{"enabled": IsKiosk()})
+}
+
+// KioskEnable needs a kiosk PIN in the settings, for it to be disabled.
+func KioskEnable(ctx *gin.Context) {
+	if config.Get().KioskPin == "" {
+		ctx.JSON(400, gin.H{"error": "set a kiosk PIN in the settings first"})
+		return
+	}
+	if err := setKiosk(true); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{"enabled": true})
+}
+
+// KioskDisable needs the kiosk PIN from the settings, each client getting a
+// few tries a minute. Kiosk mode enabled from the settings can only be
+// disabled there.
+func KioskDisable(ctx *gin.Context) {
+	pin := config.Get().KioskPin
+	given := ctx.Request.URL.Query().Get("pin")
+	if pin == "" || subtle.ConstantTimeCompare([]byte(given), []byte(pin)) != 1 {
+		ctx.AbortWithStatus(403)
+		return
+	}
+	if err := setKiosk(false); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{"enabled": IsKiosk()})
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/library"
+	"github.com/steeve/pulsar/localsearch"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+func libraryError(ctx *gin.Context, err error) {
+	if err == library.ErrNoLibraryPath {
+		xbmc.Notify("Pulsar", "Set the library folder first.", config.AddonIcon())
+	} else {
+		xbmc.Notify("Pulsar", "Unable to add to the library.", config.AddonIcon())
+	}
+	ctx.Error(err)
+}
+
+func LibraryShows(ctx *gin.Context) {
+	ctx.JSON(200, library.Shows())
+}
+
+func LibraryAddMovie(ctx *gin.Context) {
+	if err := library.AddMovie(ctx.Params.ByName("imdbId")); err != nil {
+		libraryError(ctx, err)
+		return
+	}
+	localsearch.Invalidate()
+	xbmc.Notify("Pulsar", "Added to the library", config.AddonIcon())
+	ctx.String(200, "")
+}
+
+func LibraryAddShow(ctx *gin.Context) {
+	if err := library.AddShow(ctx.Params.ByName("showId")); err != nil {
+		libraryError(ctx, err)
+		return
+	}
+	localsearch.Invalidate()
+	xbmc.Notify("Pulsar", "Added to the library", config.AddonIcon())
+	ctx.String(200, "")
+}
+
+func LibraryRemoveShow(ctx *gin.Context) {
+	if err := library.RemoveShow(ctx.Params.ByName("showId")); err != nil {
+		ctx.Error(err)
+		return
+	}
+	xbmc.Notify("Pulsar", "New episodes won't be added anymore", config.AddonIcon())
+	ctx.String(200, "")
+}
+
+func LibraryUpdate(ctx *gin.Context) {
+	if err := library.Update(); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.String(200, "")
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/history"
+)
+
+// The lists of torrents, of the queue and of the history may be long, so
+// they're paged with ?limit= and ?offset=, filtered with ?state= (a comma
+// separated list) and sorted with ?sort=, a field prefixed with - to sort
+// descending. They stay JSON arrays, the total before paging going in the
+// X-Total-Count header.
+
+type listing struct {
+	limit      int
+	offset     int
+	states     map[string]bool
+	sortKey    string
+	descending bool
+}
+
+func parseListing(ctx *gin.Context) (*listing, error) {
+	query := ctx.Request.URL.Query()
+	l := &listing{}
+	for name, value := range map[string]*int{"limit": &l.limit, "offset": &l.offset} {
+		if query.Get(name) == "" {
+			continue
+		}
+		n, err := strconv.Atoi(query.Get(name))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("bad %s: %s", name, query.Get(name))
+		}
+		*value = n
+	}
+	if states := query.Get("state"); states != "" {
+		l.states = make(map[string]bool)
+		for _, state := range strings.Split(states, ",") {
+			l.states[strings.ToLower(strings.TrimSpace(state))] = true
+		}
+	}
+	if sortKey := query.Get("sort"); sortKey != "" {
+		l.descending = strings.HasPrefix(sortKey, "-")
+		l.sortKey = strings.TrimPrefix(sortKey, "-")
+	}
+	return l, nil
+}
+
+// wants tells whether an item in any of the states is listed.
+func (l *listing) wants(states ...string) bool {
+	if l.states == nil {
+		return true
+	}
+	for _, state := range states {
+		if l.states[state] {
+			return true
+		}
+	}
+	return false
+}
+
+type listSorter struct {
+	n    int
+	swap func(i, j int)
+	less func(i, j int) bool
+}
+
+func (a listSorter) Len() int           { return a.n }
+func (a listSorter) Swap(i, j int)      { a.swap(i, j) }
+func (a listSorter) Less(i, j int) bool { return a.less(i, j) }
+
+func badSortKey(key string) error {
+	return fmt.Errorf("can't sort by %s", key)
+}
+
+func (l *listing) sort(n int, swap func(i, j int), less func(i, j int) bool) {
+	if l.descending {
+		ascending := less
+		less = func(i, j int) bool { return ascending(j, i) }
+	}
+	sort.Stable(listSorter{n, swap, less})
+}
+
+// page returns the bounds of the page in total items, and tells the client
+// how many there are.
+func (l *listing) page(ctx *gin.Context, total int) (int, int) {
+	ctx.Writer.Header().Set("X-Total-Count", strconv.Itoa(total))
+	start := l.offset
+	if start > total {
+		start = total
+	}
+	end := total
+	// the limit may be anything up to overflowing
+	if l.limit > 0 && l.limit < total-start {
+		end = start + l.limit
+	}
+	return start, end
+}
+
+var torrentOrders = map[string]func(a, b *bittorrent.TorrentStatus) bool{
+	"name":          func(a, b *bittorrent.TorrentStatus) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+	"progress":      func(a, b *bittorrent.TorrentStatus) bool { return a.Progress < b.Progress },
+	"size":          func(a, b *bittorrent.TorrentStatus) bool { return a.Size < b.Size },
+	"download_rate": func(a, b *bittorrent.TorrentStatus) bool { return a.DownloadRate < b.DownloadRate },
+	"upload_rate":   func(a, b *bittorrent.TorrentStatus) bool { return a.UploadRate < b.UploadRate },
+	"seeds":         func(a, b *bittorrent.TorrentStatus) bool { return a.Seeds < b.Seeds },
+	"peers":         func(a, b *bittorrent.TorrentStatus) bool { return a.Peers < b.Peers },
+}
+
+var queueOrders = map[string]func(a, b *bittorrent.QueueItem) bool{
+	"priority":      func(a, b *bittorrent.QueueItem) bool { return a.Priority < b.Priority },
+	"name":          func(a, b *bittorrent.QueueItem) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+	"added":         func(a, b *bittorrent.QueueItem) bool { return a.Added.Before(b.Added) },
+	"progress":      func(a, b *bittorrent.QueueItem) bool { return a.Progress < b.Progress },
+	"download_rate": func(a, b *bittorrent.QueueItem) bool { return a.DownloadRate < b.DownloadRate },
+}
+
+var historyOrders = map[string]func(a, b *history.Entry) bool{
+	"updated":  func(a, b *history.Entry) bool { return a.Updated.Before(b.Updated) },
+	"title":    func(a, b *history.Entry) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) },
+	"position": func(a, b *history.Entry) bool { return a.Position < b.Position },
+	"duration": func(a, b *history.Entry) bool { return a.Duration < b.Duration },
+}
+
+// torrentsPage lists the torrents by their libtorrent state, or as
+// "paused", "download" or "stream".
+func torrentsPage(ctx *gin.Context, torrents []*bittorrent.TorrentStatus) ([]*bittorrent.TorrentStatus, error) {
+	l, err := parseListing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	less, sorted := torrentOrders[l.sortKey]
+	if l.sortKey != "" && sorted == false {
+		return nil, badSortKey(l.sortKey)
+	}
+	listed := make([]*bittorrent.TorrentStatus, 0, len(torrents))
+	for _, torrent := range torrents {
+		kind := "stream"
+		if torrent.Download {
+			kind = "download"
+		}
+		if torrent.Paused && l.wants("paused") || l.wants(torrent.State, kind) {
+			listed = append(listed, torrent)
+		}
+	}
+	if sorted {
+		l.sort(len(listed),
+			func(i, j int) { listed[i], listed[j] = listed[j], listed[i] },
+			func(i, j int) bool { return less(listed[i], listed[j]) })
+	}
+	start, end := l.page(ctx, len(listed))
+	return listed[start:end], nil
+}
+
+// queuePage lists the queued downloads by their queue state.
+func queuePage(ctx *gin.Context, queue []*bittorrent.QueueItem) ([]*bittorrent.QueueItem, error) {
+	l, err := parseListing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	less, sorted := queueOrders[l.sortKey]
+	if l.sortKey != "" && sorted == false {
+		return nil, badSortKey(l.sortKey)
+	}
+	listed := make([]*bittorrent.QueueItem, 0, len(queue))
+	for _, item := range queue {
+		if l.wants(item.State) {
+			listed = append(listed, item)
+		}
+	}
+	if sorted {
+		l.sort(len(listed),
+			func(i, j int) { listed[i], listed[j] = listed[j], listed[i] },
+			func(i, j int) bool { return less(listed[i], listed[j]) })
+	}
+	start, end := l.page(ctx, len(listed))
+	return listed[start:end], nil
+}
+
+// historyPage lists the entries as "watched", "in_progress" or
+// "unwatched", and as "movie" or "episode".
+func historyPage(ctx *gin.Context, entries []*history.Entry) ([]*history.Entry, error) {
+	l, err := parseListing(ctx)
+	if err != nil {
+		return nil, err
+	}
+	less, sorted := historyOrders[l.sortKey]
+	if l.sortKey != "" && sorted == false {
+		return nil, badSortKey(l.sortKey)
+	}
+	listed := make([]*history.Entry, 0, len(entries))
+	for _, entry := range entries {
+		state := "unwatched"
+		switch {
+		case entry.Watched:
+			state = "watched"
+		case entry.InProgress():
+			state = "in_progress"
+		}
+		kind := "movie"
+		if entry.TVDBId != 0 {
+			kind = "episode"
+		}
+		if l.wants(state, kind) {
+			listed = append(listed, entry)
+		}
+	}
+	if sorted {
+		l.sort(len(listed),
+			func(i, j int) { listed[i], listed[j] = listed[j], listed[i] },
+			func(i, j int) bool { return less(listed[i], listed[j]) })
+	}
+	start, end := l.page(ctx, len(listed))
+	return listed[start:end], nil
+}
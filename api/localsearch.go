@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/localsearch"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const defaultLocalResults = 50
+
+func localSearchLimit(ctx *gin.Context) int {
+	if limit, err := strconv.Atoi(ctx.Request.URL.Query().Get("limit")); err == nil && limit > 0 {
+		return limit
+	}
+	return defaultLocalResults
+}
+
+// LocalSearchEntries returns the titles of the history, the library and the
+// watchlist matching q, as the user types it.
+func LocalSearchEntries(ctx *gin.Context) {
+	ctx.JSON(200, localsearch.Search(ctx.Request.URL.Query().Get("q"), localSearchLimit(ctx)))
+}
+
+// LocalSearch is LocalSearchEntries for XBMC, playing the titles of the
+// history and the movies, and browsing the shows.
+func LocalSearch(ctx *gin.Context) {
+	query := ctx.Request.URL.Query().Get("q")
+	if query == "" {
+		query = xbmc.Keyboard("", "Search your titles")
+	}
+	if query == "" {
+		return
+	}
+	docs := localsearch.Search(query, localSearchLimit(ctx))
+	items := make(xbmc.ListItems, 0, len(docs))
+	for _, doc := range docs {
+		label := doc.Title
+		if doc.Year > 0 {
+			label = fmt.Sprintf("%s (%d)", label, doc.Year)
+		}
+		item := &xbmc.ListItem{
+			Label:     fmt.Sprintf("%s [%s]", label, doc.Source),
+			Thumbnail: config.AddonResource("img", "movies.png"),
+		}
+		switch {
+		case doc.Source == localsearch.History:
+			item.Path = UrlForXBMC("/history/play/%s", doc.Key)
+			item.IsPlayable = true
+		case doc.Kind == localsearch.Movie && doc.IMDBId != "":
+			item.Path = UrlForXBMC("/movie/%s/play", doc.IMDBId)
+			item.IsPlayable = true
+		case doc.Kind == localsearch.Show && doc.TVDBId != 0:
+			item.Path = UrlForXBMC("/show/%d/seasons", doc.TVDBId)
+			item.Thumbnail = config.AddonResource("img", "tv.png")
+		default:
+			continue
+		}
+		items = append(items, item)
+	}
+	ctx.JSON(200, xbmc.NewView("", items))
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/logs"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// how many lines the log viewer shows
+const logViewerLines = 200
+
+// Logs returns the recent log records, filtered by subsystem and level.
+// Tailing is asking again with since, the seq of the last record.
+func Logs(ctx *gin.Context) {
+	query := ctx.Request.URL.Query()
+	since, _ := strconv.ParseUint(query.Get("since"), 10, 64)
+	count, err := strconv.Atoi(query.Get("lines"))
+	if err != nil {
+		count = logViewerLines
+	}
+	ctx.JSON(200, gin.H{
+		"levels":  logs.Levels(),
+		"entries": logs.Recent(since, query.Get("subsystem"), query.Get("level"), count),
+	})
+}
+
+// ViewLogs shows the recent log records of a subsystem in XBMC, the most
+// recent first.
+func ViewLogs(ctx *gin.Context) {
+	choices := append([]string{"All"}, logs.Subsystems...)
+	choice := xbmc.ListDialog("Pulsar logs", choices...)
+	if choice < 0 {
+		return
+	}
+	subsystem := ""
+	if choice > 0 {
+		subsystem = logs.Subsystems[choice-1]
+	}
+	entries := logs.Recent(0, subsystem, ctx.Request.URL.Query().Get("level"), logViewerLines)
+	if len(entries) == 0 {
+		xbmc.Notify("Pulsar", "Nothing was logged.", config.AddonIcon())
+		return
+	}
+	lines := make([]string, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		lines = append(lines, fmt.Sprintf("%s %.4s %s: %s", entry.Time.Format("15:04:05"), entry.Level, entry.Module, entry.Message))
+	}
+	xbmc.ListDialog(choices[choice]+" logs", lines...)
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/profiles"
+	"github.com/steeve/pulsar/store"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const (
+	menuBucket = "menu"
+	menuKey    = "layout"
+)
+
+// MenuLayout customizes the root menu, by section id. Pinned sections come
+// first, then the ordered ones, then the others in their default order.
+type MenuLayout struct {
+	Pinned []string `json:"pinned"`
+	Order  []string `json:"order"`
+	Hidden []string `json:"hidden"`
+}
+
+type menuSection struct {
+	Id   string
+	Item *xbmc.ListItem
+}
+
+func settingsMenuLayout() *MenuLayout {
+	conf := config.Get()
+	return &MenuLayout{
+		Pinned: conf.MenuPinned,
+		Order:  conf.MenuOrder,
+		Hidden: conf.MenuHidden,
+	}
+}
+
+// currentMenuLayout is the layout set through the API or the context menu if
+// any, else the one from the settings.
+func currentMenuLayout() *MenuLayout {
+	var layout *MenuLayout
+	if err := profiles.Current().Bucket(menuBucket).Get(menuKey, &layout); err == nil && layout != nil {
+		return layout
+	}
+	return settingsMenuLayout()
+}
+
+func setMenuLayout(layout *MenuLayout) error {
+	bucket := profiles.Current().Bucket(menuBucket)
+	if layout == nil {
+		return bucket.Delete(menuKey)
+	}
+	return bucket.Set(menuKey, layout, store.FOREVER)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func without(list []string, s string) []string {
+	kept := make([]string, 0, len(list))
+	for _, item := range list {
+		if item != s {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// apply orders and hides the sections, unknown ids being ignored.
+func (layout *MenuLayout) apply(sections []*menuSection) xbmc.ListItems {
+	byId := make(map[string]*menuSection, len(sections))
+	for _, section := range sections {
+		byId[section.Id] = section
+	}
+	items := make(xbmc.ListItems, 0, len(sections))
+	added := make(map[string]bool)
+	add := func(id string) {
+		section, ok := byId[id]
+		if !ok || added[id] || contains(layout.Hidden, id) {
+			return
+		}
+		added[id] = true
+		items = append(items, section.Item)
+	}
+	for _, id := range layout.Pinned {
+		add(id)
+	}
+	for _, id := range layout.Order {
+		add(id)
+	}
+	for _, section := range sections {
+		add(section.Id)
+	}
+	return items
+}
+
+func GetMenu(ctx *gin.Context) {
+	ctx.JSON(200, currentMenuLayout())
+}
+
+// SetMenu takes the layout as JSON, and overrides the settings with it.
+func SetMenu(ctx *gin.Context) {
+	layout := &MenuLayout{}
+	if err := json.NewDecoder(ctx.Request.Body).Decode(layout); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if err := setMenuLayout(layout); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, layout)
+}
+
+// ResetMenu goes back to the layout from the settings.
+func ResetMenu(ctx *gin.Context) {
+	if err := setMenuLayout(nil); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, currentMenuLayout())
+}
+
+// ResetMenuCmd is ResetMenu from the context menu, which lists the root menu
+// again in place.
+func ResetMenuCmd(ctx *gin.Context) {
+	if err := setMenuLayout(nil); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	Index(ctx)
+}
+
+// MenuSectionAction pins, unpins or hides a section from the context menu,
+// then lists the root menu again in place.
+func MenuSectionAction(ctx *gin.Context) {
+	section := ctx.Params.ByName("section")
+	layout := currentMenuLayout()
+	switch ctx.Params.ByName("action") {
+	case "pin":
+		layout.Pinned = append(without(layout.Pinned, section), section)
+	case "unpin":
+		layout.Pinned = without(layout.Pinned, section)
+	case "hide":
+		layout.Hidden = append(without(layout.Hidden, section), section)
+	default:
+		ctx.AbortWithStatus(404)
+		return
+	}
+	if err := setMenuLayout(layout); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	Index(ctx)
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/metacache"
+	"github.com/steeve/pulsar/scheduler"
+)
+
+func isMetadataKind(kind string) bool {
+	for _, known := range metacache.Kinds() {
+		if kind == known {
+			return true
+		}
+	}
+	return false
+}
+
+// MetadataInvalidate drops cached metadata: a single entry, a whole kind,
+// or everything with the "all" kind.
+func MetadataInvalidate(ctx *gin.Context) {
+	kind := ctx.Params.ByName("kind")
+	key := ctx.Params.ByName("key")
+
+	var deleted int
+	var err error
+	switch {
+	case kind == "all":
+		deleted, err = metacache.InvalidateAll()
+	case isMetadataKind(kind):
+		deleted, err = metacache.Invalidate(kind, key)
+	default:
+		ctx.AbortWithStatus(404)
+		return
+	}
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(200, gin.H{"invalidated": deleted})
+}
+
+// MetadataRefresh refreshes the metadata of the followed shows and movies in
+// the background, as the scheduled task does.
+func MetadataRefresh(ctx *gin.Context) {
+	if err := scheduler.RunNow("metadata_refresh"); err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(202, gin.H{"triggered": "metadata_refresh"})
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/metacache"
+	"github.com/steeve/pulsar/metrics"
+	"github.com/steeve/pulsar/providers"
+)
+
+// Metrics exports the telemetry of the session, the torrents, the
+// buffering, the providers and the metadata cache for Prometheus.
+func Metrics(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		ctx.Writer.WriteHeader(200)
+		w := metrics.NewWriter(ctx.Writer)
+
+		status := btService.Session.Status()
+		w.Gauge("pulsar_session_download_rate_bytes", "Download rate of the session, in bytes per second.", float64(status.GetDownload_rate()))
+		w.Gauge("pulsar_session_upload_rate_bytes", "Upload rate of the session, in bytes per second.", float64(status.GetUpload_rate()))
+		w.Counter("pulsar_session_downloaded_bytes_total", "Bytes downloaded by the session.", float64(status.GetTotal_download()))
+		w.Counter("pulsar_session_uploaded_bytes_total", "Bytes uploaded by the session.", float64(status.GetTotal_upload()))
+		w.Gauge("pulsar_session_peers", "Peers connected to the session.", float64(status.GetNum_peers()))
+		w.Gauge("pulsar_session_dht_nodes", "Nodes in the DHT routing table.", float64(status.GetDht_nodes()))
+
+		torrents := btService.Torrents()
+		w.Gauge("pulsar_torrents", "Torrents in the session.", float64(len(torrents)))
+		for _, torrent := range torrents {
+			labels := []string{"info_hash", torrent.InfoHash, "name", torrent.Name}
+			w.Gauge("pulsar_torrent_progress", "Progress of the wanted files of the torrent, from 0 to 1.", torrent.Progress, labels...)
+			w.Gauge("pulsar_torrent_download_rate_bytes", "Download rate of the torrent, in bytes per second.", float64(torrent.DownloadRate), labels...)
+			w.Gauge("pulsar_torrent_upload_rate_bytes", "Upload rate of the torrent, in bytes per second.", float64(torrent.UploadRate), labels...)
+			w.Gauge("pulsar_torrent_peers", "Peers connected for the torrent.", float64(torrent.Peers), labels...)
+			w.Gauge("pulsar_torrent_seeds", "Seeds connected for the torrent.", float64(torrent.Seeds), labels...)
+		}
+
+		w.Histogram("pulsar_buffer_seconds", "Time the streams took to buffer, metadata included.", bittorrent.BufferTimes)
+
+		for _, provider := range providers.ProvidersStatus() {
+			w.Counter("pulsar_provider_searches_total", "Searches sent to the provider.", float64(provider.Searches), "provider", provider.Provider)
+			w.Counter("pulsar_provider_timeouts_total", "Searches the provider didn't answer in time.", float64(provider.Timeouts), "provider", provider.Provider)
+			w.Summary("pulsar_provider_latency_seconds", "Time the provider took to answer successful searches.",
+				provider.TotalLatency.Seconds(), uint64(provider.Successes), "provider", provider.Provider)
+			disabled := float64(0)
+			if provider.Disabled {
+				disabled = 1
+			}
+			w.Gauge("pulsar_provider_disabled", "Whether the provider is disabled for failing.", disabled, "provider", provider.Provider)
+		}
+		w.Gauge("pulsar_provider_callbacks_pending", "Searches waiting for the callback of their provider.", float64(providers.PendingCallbacks()))
+
+		lookups := metacache.LookupStats()
+		kinds := make([]string, 0, len(lookups))
+		for kind := range lookups {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		const lookupsHelp = "Lookups of TMDB and TVDB metadata, by where they were found."
+		for _, kind := range kinds {
+			lookup := lookups[kind]
+			w.Counter("pulsar_metadata_cache_lookups_total", lookupsHelp, float64(lookup.MemoryHits), "kind", kind, "result", "memory")
+			w.Counter("pulsar_metadata_cache_lookups_total", lookupsHelp, float64(lookup.DiskHits), "kind", kind, "result", "disk")
+			w.Counter("pulsar_metadata_cache_lookups_total", lookupsHelp, float64(lookup.Misses), "kind", kind, "result", "miss")
+		}
+	}
+}
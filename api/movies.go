@@ -1,17 +1,22 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"sort"
 	"strconv"
-	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/analytics"
 	"github.com/steeve/pulsar/bittorrent"
 	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/fanart"
+	"github.com/steeve/pulsar/library"
+	"github.com/steeve/pulsar/profiles"
 	"github.com/steeve/pulsar/providers"
 	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/watchlist"
 	"github.com/steeve/pulsar/xbmc"
 )
 
@@ -55,6 +60,7 @@ func MoviesIndex(ctx *gin.Context) {
 		{Label: "Top Rated", Path: UrlForXBMC("/movies/top"), Thumbnail: config.AddonResource("img", "top_rated.png")},
 		{Label: "Most Voted", Path: UrlForXBMC("/movies/mostvoted"), Thumbnail: config.AddonResource("img", "most_voted.png")},
 		{Label: "IMDB Top 250", Path: UrlForXBMC("/movies/imdb250"), Thumbnail: config.AddonResource("img", "imdb.png")},
+		{Label: "Watchlist", Path: UrlForXBMC("/movies/watchlist"), Thumbnail: config.AddonResource("img", "popular.png")},
 	}
 	for _, genre := range tmdb.GetMovieGenres(config.Get().Language) {
 		slug, _ := genreSlugs[genre.Id]
@@ -68,22 +74,46 @@ func MoviesIndex(ctx *gin.Context) {
 }
 
 func renderMovies(movies tmdb.Movies, ctx *gin.Context) {
+	ctx.JSON(200, xbmc.NewView("movies", movieListItems(movies)))
+}
+
+func movieListItems(movies tmdb.Movies) xbmc.ListItems {
+	profile := profiles.Current()
+	inWatchlist := watchlistSet(watchlist.Movies)
 	items := make(xbmc.ListItems, 0, len(movies))
+	tmdbIds := make([]int, 0, len(movies))
 	for _, movie := range movies {
 		if movie == nil {
 			continue
 		}
+		genreIds := make([]int, 0, len(movie.Genres))
+		for _, genre := range movie.Genres {
+			genreIds = append(genreIds, genre.Id)
+		}
+		if profile.Allows(movie.IsAdult, genreIds) == false {
+			continue
+		}
 		item := movie.ToListItem()
 		item.Path = UrlForXBMC("/movie/%s/play", movie.IMDBId)
 		item.Info.Trailer = UrlForHTTP("/youtube/%s", item.Info.Trailer)
 		item.IsPlayable = true
-		item.ContextMenu = [][]string{
-			[]string{"Choose stream...", fmt.Sprintf("XBMC.PlayMedia(%s)", UrlForXBMC("/movie/%s/links", movie.IMDBId))},
+		target := &menuTarget{
+			Kind:        menuMovie,
+			IMDBId:      movie.IMDBId,
+			TMDBId:      movie.Id,
+			InWatchlist: inWatchlist[movie.Id],
+		}
+		if movie.BelongsToCollection != nil {
+			target.CollectionId = movie.BelongsToCollection.Id
 		}
+		item.ContextMenu = contextMenu(target)
 		items = append(items, item)
+		tmdbIds = append(tmdbIds, movie.Id)
 	}
-
-	ctx.JSON(200, xbmc.NewView("movies", items))
+	fanart.Lookups(len(items), func(i int) {
+		fanart.GetMovie(tmdbIds[i]).Apply(items[i], config.Get().Language)
+	})
+	return items
 }
 
 func PopularMovies(ctx *gin.Context) {
@@ -118,6 +148,11 @@ func SearchMovies(ctx *gin.Context) {
 	renderMovies(tmdb.SearchMovies(query, config.Get().Language), ctx)
 }
 
+func SimilarMovies(ctx *gin.Context) {
+	tmdbId, _ := strconv.Atoi(ctx.Params.ByName("tmdbId"))
+	renderMovies(tmdb.SimilarMovies(tmdbId, config.Get().Language), ctx)
+}
+
 func MovieGenres(ctx *gin.Context) {
 	genres := tmdb.GetMovieGenres(config.Get().Language)
 	items := make(xbmc.ListItems, 0, len(genres))
@@ -131,68 +166,184 @@ func MovieGenres(ctx *gin.Context) {
 	ctx.JSON(200, xbmc.NewView("", items))
 }
 
-func movieLinks(imdbId string) []*bittorrent.Torrent {
+// isRefresh tells whether the cached search results are to be skipped.
+func isRefresh(ctx *gin.Context) bool {
+	refresh, _ := strconv.ParseBool(ctx.Request.URL.Query().Get("refresh"))
+	return refresh
+}
+
+// movieLinks searches the links of the movie, the cached results being
+// dropped first when refreshing.
+func movieLinks(ctx context.Context, imdbId string, refresh bool) []*bittorrent.Torrent {
 	log.Println("Searching links for IMDB:", imdbId)
 
 	movie := tmdb.GetMovieFromIMDB(imdbId, config.Get().Language)
 
 	log.Printf("Resolved %s to %s\n", imdbId, movie.Title)
+	if refresh {
+		providers.ForgetMovie(movie)
+	}
 
 	searchers := providers.GetMovieSearchers()
 	if len(searchers) == 0 {
 		xbmc.Notify("Pulsar", "Unable to find any providers", config.AddonIcon())
 	}
 
-	return providers.SearchMovie(searchers, movie)
+	torrents := withoutFailed(movieTitleKey(imdbId), providers.SearchMovie(ctx, searchers, movie))
+	analytics.RecordSearch("movie", len(torrents))
+	return torrents
 }
 
 func MovieLinks(ctx *gin.Context) {
-	torrents := movieLinks(ctx.Params.ByName("imdbId"))
+	if isDryRun(ctx) {
+		movieDryRun(ctx, false)
+		return
+	}
+	torrents := movieLinks(ctx.Request.Context(), ctx.Params.ByName("imdbId"), isRefresh(ctx))
 
 	if len(torrents) == 0 {
 		xbmc.Notify("Pulsar", "No links were found", config.AddonIcon())
 		return
 	}
 
-	choices := make([]string, 0, len(torrents))
-	for _, torrent := range torrents {
-		info := make([]string, 0)
-		if torrent.RipType > 0 {
-			info = append(info, bittorrent.Rips[torrent.RipType])
-		}
-		if torrent.Resolution > 0 {
-			info = append(info, bittorrent.Resolutions[torrent.Resolution])
-		}
-		if torrent.VideoCodec > 0 {
-			info = append(info, bittorrent.Codecs[torrent.VideoCodec])
-		}
-		if torrent.AudioCodec > 0 {
-			info = append(info, bittorrent.Codecs[torrent.AudioCodec])
-		}
-
-		label := fmt.Sprintf("S:%d P:%d - %s - %s",
-			torrent.Seeds,
-			torrent.Peers,
-			strings.Join(info, " "),
-			torrent.Name,
+	groups := groupByRelease(torrents)
+	choices := make([]string, 0, len(groups))
+	for _, group := range groups {
+		torrent := group.best
+		label := fmt.Sprintf("%s - %s",
+			swarmLabel(torrent),
+			resultLabel(torrent),
 		)
-		choices = append(choices, label)
+		choices = append(choices, group.label(label))
 	}
 
 	choice := xbmc.ListDialog("Choose stream", choices...)
 	if choice >= 0 {
-		rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[choice].Magnet())
+		torrent := groups[choice].best
+		analytics.RecordChoice("movie", bittorrent.Resolutions[torrent.Resolution])
+		rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrent.Magnet(), "imdb_id", ctx.Params.ByName("imdbId"))
 		ctx.Redirect(302, rUrl)
 	}
 }
 
-func MoviePlay(ctx *gin.Context) {
-	torrents := movieLinks(ctx.Params.ByName("imdbId"))
-	if len(torrents) == 0 {
-		xbmc.Notify("Pulsar", "No links were found", config.AddonIcon())
+func MoviePlay(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if isDryRun(ctx) {
+			movieDryRun(ctx, true)
+			return
+		}
+		if entry := playableHistoryTorrent(btService, movieTitleKey(ctx.Params.ByName("imdbId"))); entry != nil {
+			log.Printf("Playing %s again from the torrent of the last time\n", entry.Title)
+			ctx.Redirect(302, historyPlayURL(entry, true))
+			return
+		}
+		torrents := movieLinks(ctx.Request.Context(), ctx.Params.ByName("imdbId"), isRefresh(ctx))
+		if len(torrents) == 0 {
+			go showFailure(noLinksFailure(ctx.Request.URL.Path))
+			return
+		}
+		sort.Sort(sort.Reverse(providers.ByQuality(torrents)))
+		analytics.RecordChoice("movie", bittorrent.Resolutions[torrents[0].Resolution])
+		rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[0].Magnet(), "imdb_id", ctx.Params.ByName("imdbId"))
+		ctx.Redirect(302, rUrl)
+	}
+}
+
+// Searches each provider separately and tells how many links they returned.
+func MovieDebug(ctx *gin.Context) {
+	movie := tmdb.GetMovieFromIMDB(ctx.Params.ByName("imdbId"), config.Get().Language)
+	lines := make([]string, 0)
+	for _, searcher := range providers.GetMovieSearchers() {
+		torrents := searcher.SearchMovieLinks(movie)
+		lines = append(lines, fmt.Sprintf("%s: %d links", searcher, len(torrents)))
+	}
+	xbmc.ListDialog("Providers for "+movie.Title, lines...)
+}
+
+// MovieDownload queues the best link of the movie as a background download.
+func MovieDownload(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		imdbId := ctx.Params.ByName("imdbId")
+		movie := tmdb.GetMovieFromIMDB(imdbId, config.Get().Language)
+		if movie == nil {
+			xbmc.Notify("Pulsar", "Unable to get the movie", config.AddonIcon())
+			return
+		}
+		go downloadBest(btService, movie.Title, movieLinks(context.Background(), imdbId, false))
+	}
+}
+
+// MovieCollection queues the best link of every movie of the collection the
+// movie belongs to as background downloads, or adds them to the library.
+// The to parameter, download or library, picks without asking.
+func MovieCollection(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		movie := tmdb.GetMovieFromIMDB(ctx.Params.ByName("imdbId"), config.Get().Language)
+		if movie == nil || movie.BelongsToCollection == nil {
+			xbmc.Notify("Pulsar", "This movie is not part of a collection", config.AddonIcon())
+			return
+		}
+		collection := tmdb.GetCollection(movie.BelongsToCollection.Id, config.Get().Language)
+		if collection == nil {
+			xbmc.Notify("Pulsar", "Unable to get the collection", config.AddonIcon())
+			return
+		}
+		var choice int
+		switch ctx.Request.URL.Query().Get("to") {
+		case "download":
+			choice = 0
+		case "library":
+			choice = 1
+		default:
+			choice = xbmc.ListDialog(collection.Name,
+				fmt.Sprintf("Download all %d movies", len(collection.Parts)),
+				fmt.Sprintf("Add all %d movies to the library", len(collection.Parts)),
+				"Cancel")
+		}
+		switch choice {
+		case 0:
+			go acquireCollection(btService, collection)
+		case 1:
+			go addCollectionToLibrary(collection)
+		}
+	}
+}
+
+func addCollectionToLibrary(collection *tmdb.Collection) {
+	imdbIds := make([]string, 0)
+	for _, movie := range collection.Movies(config.Get().Language) {
+		if movie != nil && movie.IMDBId != "" {
+			imdbIds = append(imdbIds, movie.IMDBId)
+		}
+	}
+	added, err := library.AddMovies(imdbIds)
+	if err != nil {
+		log.Printf("Unable to add %s to the library: %s\n", collection.Name, err)
+		xbmc.Notify("Pulsar", "Unable to add to the library", config.AddonIcon())
 		return
 	}
-	sort.Sort(sort.Reverse(providers.ByQuality(torrents)))
-	rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[0].Magnet())
-	ctx.Redirect(302, rUrl)
+	xbmc.Notify("Pulsar", fmt.Sprintf("Added %d of %d movies from %s to the library", added, len(imdbIds), collection.Name), config.AddonIcon())
+}
+
+func acquireCollection(btService *bittorrent.BTService, collection *tmdb.Collection) {
+	searchers := providers.GetMovieSearchers()
+	movies := collection.Movies(config.Get().Language)
+	queued := 0
+	for _, movie := range movies {
+		if movie == nil {
+			continue
+		}
+		torrents := providers.SearchMovie(context.Background(), searchers, movie)
+		if len(torrents) == 0 {
+			log.Printf("No links found for %s\n", movie.Title)
+			continue
+		}
+		sort.Sort(sort.Reverse(providers.ByQuality(torrents)))
+		if err := btService.AddDownload(torrents[0].Magnet()); err != nil {
+			log.Printf("Unable to download %s: %s\n", movie.Title, err)
+			continue
+		}
+		queued++
+	}
+	xbmc.Notify("Pulsar", fmt.Sprintf("Queued %d of %d movies from %s", queued, len(movies), collection.Name), config.AddonIcon())
 }
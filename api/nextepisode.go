@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/library"
+	"github.com/steeve/pulsar/tvdb"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const (
+	// how long before the end the next episode starts prebuffering
+	nextEpisodeLead = 3 * time.Minute
+	// how long the prebuffered torrent waits for its player
+	nextEpisodeRelease = 2 * time.Minute
+)
+
+// upNext is the next episode, the torrent picked for it and the player
+// prebuffering it, if any.
+type upNext struct {
+	episode   *tvdb.Episode
+	query     url.Values
+	torrent   *bittorrent.Torrent
+	prebuffer *bittorrent.BTPlayer
+}
+
+// nextEpisode is the aired episode after this one, in the next season if
+// needed.
+func nextEpisode(show *tvdb.Show, seasonNumber int, episodeNumber int) *tvdb.Episode {
+	for i := seasonNumber; i < len(show.Seasons); i++ {
+		season := show.Seasons[i]
+		if season == nil {
+			continue
+		}
+		for _, episode := range season.Episodes {
+			if (i == seasonNumber && episode.EpisodeNumber == episodeNumber+1) || (i > seasonNumber && episode.EpisodeNumber == 1) {
+				if show.HasAired(episode) {
+					return episode
+				}
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// isSeasonFinale tells whether no episode comes after this one in its
+// season, specials aside.
+func isSeasonFinale(show *tvdb.Show, seasonNumber int, episodeNumber int) bool {
+	if seasonNumber == 0 || seasonNumber >= len(show.Seasons) || show.Seasons[seasonNumber] == nil {
+		return false
+	}
+	for _, episode := range show.Seasons[seasonNumber].Episodes {
+		if episode.EpisodeNumber > episodeNumber {
+			return false
+		}
+	}
+	return true
+}
+
+// isSeriesFinale tells whether the season is the last of a show that ended.
+func isSeriesFinale(show *tvdb.Show, seasonNumber int) bool {
+	if show.Status != "Ended" {
+		return false
+	}
+	for i := seasonNumber + 1; i < len(show.Seasons); i++ {
+		if show.Seasons[i] != nil && len(show.Seasons[i].Episodes) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// atSeasonFinale offers to start the next season, or to add the show to
+// the library to get the next season as it airs, and tells whether to play
+// the next episode.
+func atSeasonFinale(show *tvdb.Show, seasonNumber int, playable bool) bool {
+	if isSeriesFinale(show, seasonNumber) {
+		xbmc.Notify("Pulsar", "That was the series finale of "+show.SeriesName, config.AddonIcon())
+		return false
+	}
+	tvdbId := strconv.Itoa(show.Id)
+	subscribed := false
+	for _, id := range library.Shows() {
+		if id == tvdbId {
+			subscribed = true
+			break
+		}
+	}
+	if playable == false && subscribed {
+		xbmc.Notify("Pulsar", fmt.Sprintf("Season %d of %s isn't out yet, it'll be added to the library", seasonNumber+1, show.SeriesName), config.AddonIcon())
+		return false
+	}
+
+	choices := make([]string, 0, 3)
+	if playable {
+		choices = append(choices, fmt.Sprintf("Start season %d", seasonNumber+1))
+	}
+	if subscribed == false {
+		choices = append(choices, fmt.Sprintf("Add season %d to the library", seasonNumber+1))
+	}
+	choices = append(choices, "Stop")
+	title := fmt.Sprintf("End of season %d of %s", seasonNumber, show.SeriesName)
+	if playable == false {
+		title = fmt.Sprintf("Season %d of %s isn't out yet", seasonNumber+1, show.SeriesName)
+	}
+	choice := xbmc.ListDialog(title, choices...)
+	if choice < 0 || choice == len(choices)-1 {
+		return false
+	}
+	if playable && choice == 0 {
+		return true
+	}
+	// subscribing writes the episodes aired, and those to come
+	if err := library.AddShow(tvdbId); err != nil {
+		xbmc.Notify("Pulsar", err.Error(), config.AddonIcon())
+	} else {
+		xbmc.Notify("Pulsar", fmt.Sprintf("Season %d of %s will be in the library as it airs", seasonNumber+1, show.SeriesName), config.AddonIcon())
+	}
+	return false
+}
+
+// find picks the torrent of the next episode, the one played before if
+// any, and starts prebuffering it unless it's the torrent being played, as
+// with season packs.
+func (next *upNext) find(btService *bittorrent.BTService, playing *bittorrent.Torrent) *upNext {
+	tvdbId := next.query.Get("tvdb_id")
+	titleKey := episodeTitleKey(tvdbId, next.episode.SeasonNumber, next.episode.EpisodeNumber)
+	if entry := playableHistoryTorrent(btService, titleKey); entry != nil {
+		next.torrent = bittorrent.NewTorrent(entry.URI)
+		next.query.Set("file", strconv.Itoa(entry.FileIndex))
+	} else {
+		torrents, err := showEpisodeLinks(context.Background(), tvdbId, next.episode.SeasonNumber, next.episode.EpisodeNumber, false)
+		if err != nil || len(torrents) == 0 {
+			log.Printf("No links for the next episode %dx%02d\n", next.episode.SeasonNumber, next.episode.EpisodeNumber)
+			return next
+		}
+		next.torrent = torrents[0]
+	}
+	if next.torrent.InfoHash == playing.InfoHash {
+		return next
+	}
+	log.Printf("Prebuffering the next episode from %s\n", next.torrent.Name)
+	prebuffer := newPlayer(btService, next.torrent, next.query)
+	if file, err := strconv.Atoi(next.query.Get("file")); err == nil {
+		prebuffer.SetFile(file)
+	}
+	if err := prebuffer.Prebuffer(); err != nil {
+		log.Printf("Unable to prebuffer %s: %s\n", next.torrent.Name, err)
+		return next
+	}
+	next.prebuffer = prebuffer
+	return next
+}
+
+func (next *upNext) drop() {
+	if next != nil && next.prebuffer != nil {
+		next.prebuffer.Close()
+	}
+}
+
+// playNextEpisode prebuffers the next episode near the end of this one, and
+// plays it, or offers to, when this one ends.
+func playNextEpisode(btService *bittorrent.BTService, player *bittorrent.BTPlayer, playing *bittorrent.Torrent, query url.Values) {
+	mode := config.Get().AutoplayNext
+	tvdbId := query.Get("tvdb_id")
+	if mode == config.AutoplayNextOff || tvdbId == "" {
+		return
+	}
+	seasonNumber, _ := strconv.Atoi(query.Get("season"))
+	episodeNumber, _ := strconv.Atoi(query.Get("episode"))
+	show, err := tvdb.NewShowCached(tvdbId, config.Get().Language)
+	if err != nil {
+		return
+	}
+	finale := isSeasonFinale(show, seasonNumber, episodeNumber)
+	episode := nextEpisode(show, seasonNumber, episodeNumber)
+	if episode == nil && finale == false {
+		return
+	}
+	var next *upNext
+	if episode != nil {
+		next = &upNext{
+			episode: episode,
+			query: url.Values{
+				"tvdb_id": {tvdbId},
+				"season":  {strconv.Itoa(episode.SeasonNumber)},
+				"episode": {strconv.Itoa(episode.EpisodeNumber)},
+			},
+		}
+	}
+
+	events, done := player.StreamEvents()
+	defer close(done)
+
+	var position, duration time.Duration
+	var found chan *upNext
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case _, ok := <-events:
+			running = ok
+		case <-ticker.C:
+			if xbmc.PlayerIsPlaying() == false {
+				continue
+			}
+			position, duration = xbmc.PlayerProgress()
+			if next != nil && found == nil && duration > 0 && duration-position <= nextEpisodeLead {
+				found = make(chan *upNext, 1)
+				go func() {
+					found <- next.find(btService, playing)
+				}()
+			}
+		}
+	}
+
+	// stopped before the end
+	if duration == 0 || float64(position) < float64(duration)*watchedPercent {
+		if found != nil {
+			(<-found).drop()
+		}
+		return
+	}
+	if found != nil {
+		next = <-found
+	} else if next != nil {
+		next = next.find(btService, playing)
+	}
+	playable := next != nil && next.torrent != nil
+	if finale {
+		// asked even when autoplaying, the next season may not be wanted
+		if atSeasonFinale(show, seasonNumber, playable) == false {
+			next.drop()
+			return
+		}
+	} else if playable == false {
+		return
+	}
+
+	label := fmt.Sprintf("%s %dx%02d %s", show.SeriesName, episode.SeasonNumber, episode.EpisodeNumber, episode.EpisodeName)
+	if finale {
+		xbmc.Notify("Pulsar", "Up next: "+label, config.AddonIcon())
+	} else if mode == config.AutoplayNextAsk {
+		if xbmc.ListDialog("Up next: "+label, "Play now", "Stop") != 0 {
+			next.drop()
+			return
+		}
+	} else {
+		xbmc.Notify("Pulsar", "Up next: "+label, config.AddonIcon())
+	}
+	playQuery := []string{"uri", next.torrent.URI}
+	for _, key := range []string{"tvdb_id", "season", "episode", "file"} {
+		if value := next.query.Get(key); value != "" {
+			playQuery = append(playQuery, key, value)
+		}
+	}
+	xbmc.PlayURL(UrlQuery(UrlForXBMC("/play"), playQuery...))
+	if next.prebuffer != nil {
+		next.prebuffer.Release(nextEpisodeRelease)
+	}
+}
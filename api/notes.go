@@ -0,0 +1,115 @@
+package api
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/history"
+	"github.com/steeve/pulsar/localsearch"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// HistoryList lists the whole history, after ways to search it, or what
+// matches q and has tag.
+func HistoryList(ctx *gin.Context) {
+	query := ctx.Request.URL.Query()
+	if query.Get("q") != "" || query.Get("tag") != "" {
+		ctx.JSON(200, xbmc.NewView("", historyItems(history.Search(query.Get("q"), query.Get("tag")))))
+		return
+	}
+	items := xbmc.ListItems{
+		{Label: "Search history...", Path: UrlForXBMC("/history/search"), Thumbnail: config.AddonResource("img", "search.png")},
+		{Label: "Tags", Path: UrlForXBMC("/history/tags"), Thumbnail: config.AddonResource("img", "movies.png")},
+	}
+	ctx.JSON(200, xbmc.NewView("", append(items, historyItems(history.List())...)))
+}
+
+// HistorySearch searches the titles, notes and tags of the history.
+func HistorySearch(ctx *gin.Context) {
+	query := ctx.Request.URL.Query().Get("q")
+	if query == "" {
+		query = xbmc.Keyboard("", "Search History")
+	}
+	ctx.JSON(200, xbmc.NewView("", historyItems(history.Search(query, ""))))
+}
+
+// HistoryTags lists the tags in use, each listing its entries.
+func HistoryTags(ctx *gin.Context) {
+	tags := history.Tags()
+	items := make(xbmc.ListItems, 0, len(tags))
+	for _, tag := range tags {
+		items = append(items, &xbmc.ListItem{
+			Label:     tag,
+			Path:      UrlQuery(UrlForXBMC("/history/list"), "tag", tag),
+			Thumbnail: config.AddonResource("img", "movies.png"),
+		})
+	}
+	ctx.JSON(200, xbmc.NewView("", items))
+}
+
+// HistoryEditNote asks for the note of the entry.
+func HistoryEditNote(ctx *gin.Context) {
+	entry := history.Get(ctx.Params.ByName("key"))
+	if entry == nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+	note := xbmc.Keyboard(entry.Note, "Note for "+entry.Title)
+	if note == entry.Note {
+		ctx.String(200, "")
+		return
+	}
+	if err := history.SetNote(entry.Key, note); err != nil {
+		ctx.Error(err)
+		return
+	}
+	localsearch.Invalidate()
+	xbmc.Notify("Pulsar", "Note saved", config.AddonIcon())
+	ctx.String(200, "")
+}
+
+// HistoryEditTags asks for the tags of the entry, separated by commas.
+func HistoryEditTags(ctx *gin.Context) {
+	entry := history.Get(ctx.Params.ByName("key"))
+	if entry == nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+	current := strings.Join(entry.Tags, ", ")
+	tags := xbmc.Keyboard(current, "Tags for "+entry.Title+" (comma separated)")
+	if tags == current {
+		ctx.String(200, "")
+		return
+	}
+	if err := history.SetTags(entry.Key, history.ParseTags(tags)); err != nil {
+		ctx.Error(err)
+		return
+	}
+	localsearch.Invalidate()
+	xbmc.Notify("Pulsar", "Tags saved", config.AddonIcon())
+	ctx.String(200, "")
+}
+
+// HistorySetNote sets the note of the entry to the note parameter.
+func HistorySetNote(ctx *gin.Context) {
+	key := ctx.Params.ByName("key")
+	if err := history.SetNote(key, ctx.Request.URL.Query().Get("note")); err != nil {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	localsearch.Invalidate()
+	ctx.JSON(200, history.Get(key))
+}
+
+// HistorySetTags replaces the tags of the entry with the tags parameter,
+// separated by commas.
+func HistorySetTags(ctx *gin.Context) {
+	key := ctx.Params.ByName("key")
+	if err := history.SetTags(key, history.ParseTags(ctx.Request.URL.Query().Get("tags"))); err != nil {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	localsearch.Invalidate()
+	ctx.JSON(200, history.Get(key))
+}
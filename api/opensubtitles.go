@@ -0,0 +1,204 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/osdb"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+var errNotStreaming = errors.New("nothing is streaming")
+
+// What the subtitles search needs to know about the stream being played.
+type subtitleStream struct {
+	player *bittorrent.BTPlayer
+	query  url.Values
+	// the subtitle loaded, as downloaded, guarded by streamingLock
+	subtitle *osdb.Subtitle
+	original string
+}
+
+var (
+	streamingLock = sync.Mutex{}
+	streaming     *subtitleStream
+)
+
+func currentSubtitleStream() *subtitleStream {
+	streamingLock.Lock()
+	defer streamingLock.Unlock()
+	return streaming
+}
+
+// trackSubtitles remembers the stream for manual subtitles searches while
+// it plays, and downloads the best subtitle when it starts if enabled.
+func trackSubtitles(player *bittorrent.BTPlayer, query url.Values) {
+	stream := &subtitleStream{player: player, query: query}
+	streamingLock.Lock()
+	streaming = stream
+	streamingLock.Unlock()
+
+	events, done := player.StreamEvents()
+	defer close(done)
+
+	conf := config.Get()
+	if conf.SubtitlesAutoDownload && len(conf.SubtitleLanguages) > 0 {
+		go autoDownloadSubtitle(stream)
+	}
+	for _ = range events {
+	}
+
+	streamingLock.Lock()
+	if streaming == stream {
+		streaming = nil
+	}
+	streamingLock.Unlock()
+}
+
+// video hashes the played file, and adds the TMDB or TVDB metadata we
+// played it from.
+func (stream *subtitleStream) video() *osdb.Video {
+	path, size := stream.player.VideoFile()
+	video, err := osdb.NewVideo(path, size)
+	if err != nil {
+		log.Printf("Unable to hash %s, searching subtitles without it: %s\n", path, err)
+		video = &osdb.Video{Path: path, Size: size}
+	}
+
+	language := config.Get().Language
+	if imdbId := stream.query.Get("imdb_id"); imdbId != "" {
+		video.IMDBId = imdbId
+		if movie := tmdb.GetMovieFromIMDB(imdbId, language); movie != nil {
+			video.Query = strings.TrimSpace(fmt.Sprintf("%s %s", movie.OriginalTitle, strings.Split(movie.ReleaseDate, "-")[0]))
+		}
+	} else if tvdbId := stream.query.Get("tvdb_id"); tvdbId != "" {
+		season, _ := strconv.Atoi(stream.query.Get("season"))
+		episode, _ := strconv.Atoi(stream.query.Get("episode"))
+		if show, err := tvdb.NewShowCached(tvdbId, language); err == nil {
+			video.Query = fmt.Sprintf("%s S%02dE%02d", show.SeriesName, season, episode)
+		}
+	}
+	return video
+}
+
+func searchStreamSubtitles(stream *subtitleStream, languages []string, query string) (osdb.Subtitles, error) {
+	video := stream.video()
+	if query != "" {
+		video.Query = query
+	}
+	return osdb.SearchVideo(video, osdbLanguages(languages))
+}
+
+// loadSubtitle downloads the subtitle next to the played file, and loads
+// it in the player.
+func loadSubtitle(stream *subtitleStream, sub *osdb.Subtitle) error {
+	path, _ := stream.player.VideoFile()
+	subtitlePath, err := osdb.DownloadNextTo(sub, path)
+	if err != nil {
+		return err
+	}
+	streamingLock.Lock()
+	stream.subtitle, stream.original = sub, subtitlePath
+	streamingLock.Unlock()
+
+	if offset := subtitleOffset(path); offset != 0 {
+		return applySubtitleOffset(stream, offset)
+	}
+	log.Printf("Loading %s subtitle %s\n", sub.LanguageName, subtitlePath)
+	return playerSetSubtitles(subtitlePath)
+}
+
+// playerSetSubtitles loads the subtitle in the player, telling the user
+// when the plugin can't.
+func playerSetSubtitles(path string) error {
+	if err := xbmc.PlayerSetSubtitles(path); err != nil {
+		xbmc.Notify("Pulsar", "Unable to load the subtitles, Pulsar may need updating", config.AddonIcon())
+		return err
+	}
+	return nil
+}
+
+func autoDownloadSubtitle(stream *subtitleStream) {
+	timeout := time.After(playbackStartTimeout)
+	for xbmc.PlayerIsPlaying() == false {
+		select {
+		case <-timeout:
+			return
+		case <-time.After(1 * time.Second):
+		}
+	}
+	subs, err := searchStreamSubtitles(stream, config.Get().SubtitleLanguages, "")
+	if err != nil {
+		log.Printf("Unable to search subtitles: %s\n", err)
+		return
+	}
+	if best := subs.Best(); best != nil {
+		if err := loadSubtitle(stream, best); err != nil {
+			log.Printf("Unable to download subtitle: %s\n", err)
+		}
+	}
+}
+
+func subtitlesLanguages(ctx *gin.Context) []string {
+	if languages := ctx.Request.URL.Query().Get("languages"); languages != "" {
+		return strings.Split(languages, ",")
+	}
+	return config.Get().SubtitleLanguages
+}
+
+// SubtitlesSearch returns the OpenSubtitles results for the stream being
+// played, best first.
+func SubtitlesSearch(ctx *gin.Context) {
+	stream := currentSubtitleStream()
+	if stream == nil {
+		ctx.AbortWithError(404, errNotStreaming)
+		return
+	}
+	subs, err := searchStreamSubtitles(stream, subtitlesLanguages(ctx), ctx.Request.URL.Query().Get("q"))
+	if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, subs)
+}
+
+// ChooseSubtitles lets the user pick a subtitle for the stream being played.
+func ChooseSubtitles(ctx *gin.Context) {
+	stream := currentSubtitleStream()
+	if stream == nil {
+		xbmc.Notify("Pulsar", "Nothing is streaming.", config.AddonIcon())
+		return
+	}
+	subs, err := searchStreamSubtitles(stream, subtitlesLanguages(ctx), ctx.Request.URL.Query().Get("q"))
+	if err != nil || len(subs) == 0 {
+		xbmc.Notify("Pulsar", "No subtitles found.", config.AddonIcon())
+		return
+	}
+	labels := make([]string, 0, len(subs))
+	for _, sub := range subs {
+		label := fmt.Sprintf("[%s] %s", sub.LanguageName, sub.SubFileName)
+		if sub.MatchedBy == "moviehash" {
+			label += " (synced)"
+		}
+		labels = append(labels, label)
+	}
+	choice := xbmc.ListDialog("Choose subtitles", labels...)
+	if choice < 0 {
+		return
+	}
+	if err := loadSubtitle(stream, &subs[choice]); err != nil {
+		xbmc.Notify("Pulsar", "Unable to download the subtitles.", config.AddonIcon())
+		ctx.AbortWithError(500, err)
+	}
+}
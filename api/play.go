@@ -2,7 +2,11 @@ package api
 
 import (
 	"fmt"
+	"log"
 	"net/url"
+	"path/filepath"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/steeve/pulsar/bittorrent"
@@ -12,28 +16,54 @@ import (
 	"github.com/steeve/pulsar/xbmc"
 )
 
+const (
+	downgradeWarnings    = 3
+	downgradeWindow      = 2 * time.Minute
+	playbackStartTimeout = 60 * time.Second
+	skipOfferWindow      = 10 * time.Second
+)
+
 func Play(btService *bittorrent.BTService) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		uri := ctx.Request.URL.Query().Get("uri")
 		if uri == "" {
 			return
 		}
-		torrent := bittorrent.NewTorrent(uri)
-		magnet := torrent.Magnet()
-		boosters := url.Values{
-			"tr": providers.DefaultTrackers,
+		if isDryRun(ctx) {
+			ctx.JSON(200, playDryRunFor(uri, ctx.Request.URL.Query()))
+			return
+		}
+		requested := bittorrent.NewTorrent(uri)
+		if requested.IsNZB() {
+			playNZB(ctx, requested)
+			return
+		}
+		if lease := btService.CompletedElsewhere(requested.InfoHash); lease != nil {
+			// another instance downloaded it on the shared download path
+			file := lease.BiggestFile()
+			log.Printf("Playing %s, downloaded by %s", file.Path, lease.Instance)
+			rUrl, _ := url.Parse(fmt.Sprintf("%s/files/%s", util.ExternalURL(ctx.Request), filepath.ToSlash(file.Path)))
+			ctx.Redirect(302, util.StreamURL(ctx.Request, rUrl.String()))
+			return
 		}
-		magnet += "&" + boosters.Encode()
-		player := bittorrent.NewBTPlayer(btService, magnet, config.Get().KeepFilesAfterStop == false)
-		if player.Buffer() != nil {
+		player, torrent, err := bufferWithFallback(btService, requested, ctx.Request.URL.Query())
+		if err != nil {
+			if err != bittorrent.ErrBufferCanceled {
+				go showFailure(bufferFailure(requested, ctx.Request.URL.Query(), err))
+			}
 			return
 		}
-		hostname := "localhost"
-		if localIP, err := util.LocalIP(); err == nil {
-			hostname = localIP.String()
+		go watchThroughput(player, torrent.InfoHash)
+		go watchSkipMarkers(player)
+		go publishPlayback(player, torrent, ctx.Request.URL.Query())
+		go recordHistory(player, torrent, ctx.Request.URL.Query())
+		go playNextEpisode(btService, player, torrent, ctx.Request.URL.Query())
+		go trackSubtitles(player, ctx.Request.URL.Query())
+		if t, err := strconv.Atoi(ctx.Request.URL.Query().Get("t")); err == nil && t > 0 {
+			go seekWhenPlaying(time.Duration(t) * time.Second)
 		}
-		rUrl, _ := url.Parse(fmt.Sprintf("http://%s:%d/files/%s", hostname, config.ListenPort, player.PlayURL()))
-		ctx.Redirect(302, rUrl.String())
+		rUrl, _ := url.Parse(fmt.Sprintf("%s/files/%s", util.ExternalURL(ctx.Request), player.PlayURL()))
+		ctx.Redirect(302, util.StreamURL(ctx.Request, rUrl.String()))
 	}
 }
 
@@ -44,3 +74,92 @@ func PasteURL(ctx *gin.Context) {
 	}
 	xbmc.PlayURL(UrlQuery(UrlForXBMC("/play"), "uri", magnet))
 }
+
+// Waits for playback to start and seeks at position, used when switching
+// streams mid-playback.
+func seekWhenPlaying(position time.Duration) {
+	timeout := time.After(playbackStartTimeout)
+	for xbmc.PlayerIsPlaying() == false {
+		select {
+		case <-timeout:
+			return
+		case <-time.After(1 * time.Second):
+		}
+	}
+	xbmc.PlayerSeek(position)
+}
+
+// Offers a lower quality stream of the same search results if the current
+// one can't keep up with playback.
+func watchThroughput(player *bittorrent.BTPlayer, infoHash string) {
+	events, done := player.StreamEvents()
+	defer close(done)
+
+	warnings := make([]time.Time, 0)
+	for event := range events {
+		if _, ok := event.(*bittorrent.UnderrunWarning); ok == false {
+			continue
+		}
+		now := time.Now()
+		recent := make([]time.Time, 0, len(warnings)+1)
+		for _, warning := range warnings {
+			if now.Sub(warning) < downgradeWindow {
+				recent = append(recent, warning)
+			}
+		}
+		warnings = append(recent, now)
+		if len(warnings) < downgradeWarnings {
+			continue
+		}
+
+		lower := providers.LowerQuality(infoHash)
+		if lower == nil {
+			return
+		}
+		if config.Get().AutoDowngrade == false {
+			label := fmt.Sprintf("Switch to %s - %s", bittorrent.Resolutions[lower.Resolution], lower.Name)
+			if xbmc.ListDialog("Download is too slow for this stream", label, "Keep current quality") != 0 {
+				return
+			}
+		}
+		log.Printf("Switching to lower quality stream %s\n", lower.Name)
+		position := xbmc.PlayerTime()
+		xbmc.PlayURL(UrlQuery(UrlForXBMC("/play"),
+			"uri", lower.Magnet(),
+			"t", strconv.Itoa(int(position.Seconds()))))
+		return
+	}
+}
+
+// Offers to jump over intros and recaps when playback enters one.
+func watchSkipMarkers(player *bittorrent.BTPlayer) {
+	events, done := player.StreamEvents()
+	defer close(done)
+
+	offered := map[*bittorrent.SkipMarker]bool{}
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case _, ok := <-events:
+			if ok == false {
+				return
+			}
+		case <-ticker.C:
+			markers := player.SkipMarkers()
+			if len(markers) == 0 || xbmc.PlayerIsPlaying() == false {
+				continue
+			}
+			position := xbmc.PlayerTime()
+			for _, marker := range markers {
+				if offered[marker] || position < marker.Start || position > marker.Start+skipOfferWindow {
+					continue
+				}
+				offered[marker] = true
+				if xbmc.ListDialog("Pulsar", "Skip "+marker.Kind, "Continue watching") == 0 {
+					xbmc.PlayerSeek(marker.End)
+				}
+			}
+		}
+	}
+}
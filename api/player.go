@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+)
+
+type playerMarkers struct {
+	Name    string                   `json:"name"`
+	Markers []*bittorrent.SkipMarker `json:"markers"`
+}
+
+func PlayerMarkers(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		players := btService.Players()
+		result := make([]*playerMarkers, 0, len(players))
+		for _, player := range players {
+			result = append(result, &playerMarkers{
+				Name:    player.Name(),
+				Markers: player.SkipMarkers(),
+			})
+		}
+		ctx.JSON(200, result)
+	}
+}
+
+func PlayerStats(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		players := btService.Players()
+		result := make([]*bittorrent.PlayerStats, 0, len(players))
+		for _, player := range players {
+			if stats := player.Stats(); stats != nil {
+				result = append(result, stats)
+			}
+		}
+		ctx.JSON(200, result)
+	}
+}
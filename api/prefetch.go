@@ -0,0 +1,49 @@
+package api
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/steeve/pulsar/tvdb"
+)
+
+var (
+	prefetchLock = sync.Mutex{}
+	prefetching  = map[int]bool{}
+)
+
+// prefetchSeasons renders the episodes of every season of the show once it
+// is opened, so the page cache has them by the time the user gets there.
+// The show is already fetched whole, so this doesn't hit TVDB again.
+func prefetchSeasons(show *tvdb.Show) {
+	prefetchLock.Lock()
+	if prefetching[show.Id] {
+		prefetchLock.Unlock()
+		return
+	}
+	prefetching[show.Id] = true
+	prefetchLock.Unlock()
+
+	defer func() {
+		prefetchLock.Lock()
+		delete(prefetching, show.Id)
+		prefetchLock.Unlock()
+	}()
+
+	// the most recent seasons are the most likely to be opened
+	for i := len(show.Seasons) - 1; i >= 0; i-- {
+		season := show.Seasons[i]
+		if len(season.Episodes) == 0 {
+			continue
+		}
+		resp, err := http.Get(urlForLocal("/show/%d/season/%d/episodes", show.Id, season.Season))
+		if err != nil {
+			log.Printf("Unable to prefetch season %d of %s: %s\n", season.Season, show.SeriesName, err)
+			return
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/profiles"
+)
+
+func ProfilesList(ctx *gin.Context) {
+	ctx.JSON(200, profiles.List())
+}
+
+func ProfileCurrent(ctx *gin.Context) {
+	ctx.JSON(200, profiles.Current())
+}
+
+// An empty name goes back to following the XBMC profile.
+func ProfileSelect(ctx *gin.Context) {
+	if err := profiles.Select(ctx.Request.URL.Query().Get("name")); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(200, profiles.Current())
+}
+
+func ProfileSave(ctx *gin.Context) {
+	query := ctx.Request.URL.Query()
+	profile := profiles.Get(ctx.Params.ByName("name"))
+	if _, exists := query["library_path"]; exists {
+		profile.LibraryPath = query.Get("library_path")
+	}
+	if _, exists := query["allow_adult"]; exists {
+		profile.AllowAdult = query.Get("allow_adult") == "true"
+	}
+	if _, exists := query["blocked_genres"]; exists {
+		profile.BlockedGenres = make([]int, 0)
+		for _, genre := range strings.Split(query.Get("blocked_genres"), ",") {
+			if genreId, err := strconv.Atoi(strings.TrimSpace(genre)); err == nil {
+				profile.BlockedGenres = append(profile.BlockedGenres, genreId)
+			}
+		}
+	}
+	if err := profiles.Save(profile); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(200, profile)
+}
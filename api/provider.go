@@ -6,9 +6,11 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
 	"github.com/steeve/pulsar/providers"
 	"github.com/steeve/pulsar/tmdb"
 	"github.com/steeve/pulsar/tvdb"
+	"github.com/steeve/pulsar/xbmc"
 )
 
 type providerDebugResponse struct {
@@ -20,7 +22,7 @@ func ProviderGetMovie(ctx *gin.Context) {
 	imdbId := ctx.Params.ByName("imdbId")
 	provider := ctx.Params.ByName("provider")
 	log.Println("Searching links for IMDB:", imdbId)
-	movie := tmdb.GetMovieFromIMDB(imdbId, "en")
+	movie := tmdb.GetMovieFromIMDB(imdbId, config.Get().Language)
 	log.Printf("Resolved %s to %s\n", imdbId, movie.Title)
 
 	searcher := providers.NewAddonSearcher(provider)
@@ -48,7 +50,7 @@ func ProviderGetEpisode(ctx *gin.Context) {
 
 	log.Println("Searching links for TVDB Id:", showId)
 
-	show, err := tvdb.NewShowCached(showId, "en")
+	show, err := tvdb.NewShowCached(showId, config.Get().Language)
 	if err != nil {
 		ctx.Error(err)
 		return
@@ -73,3 +75,92 @@ func ProviderGetEpisode(ctx *gin.Context) {
 	}
 	ctx.Data(200, "application/json", data)
 }
+
+// ProviderTest runs a canned movie and episode search against the provider
+// and reports how it went.
+func ProviderTest(ctx *gin.Context) {
+	ctx.JSON(200, providers.TestProvider(ctx.Params.ByName("provider")))
+}
+
+// ProviderTestRun runs a single movie, episode or plain search against the
+// provider, with what was sent and the raw results, for provider authors
+// working from curl. The canned titles can be replaced with the imdb_id,
+// tvdb_id, season, episode and q parameters.
+func ProviderTestRun(ctx *gin.Context) {
+	query := ctx.Request.URL.Query()
+	season, _ := strconv.Atoi(query.Get("season"))
+	episode, _ := strconv.Atoi(query.Get("episode"))
+	report, err := providers.RunProviderTest(ctx.Params.ByName("provider"), ctx.Params.ByName("test"), &providers.TestOptions{
+		IMDBId:  query.Get("imdb_id"),
+		TVDBId:  query.Get("tvdb_id"),
+		Season:  season,
+		Episode: episode,
+		Query:   query.Get("q"),
+		Raw:     query.Get("raw") != "false",
+	})
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(200, report)
+}
+
+// ProvidersStatus reports the latency, success rate and results of every
+// provider, and whether it's disabled for failing too often.
+func ProvidersStatus(ctx *gin.Context) {
+	ctx.JSON(200, providers.ProvidersStatus())
+}
+
+// ProviderEnable forgives the failures of the provider, and switches it
+// back on if it was.
+func ProviderEnable(ctx *gin.Context) {
+	providers.EnableProvider(ctx.Params.ByName("provider"))
+	if err := providers.SetProviderDisabled(ctx.Params.ByName("provider"), false); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.String(200, "")
+}
+
+// ProviderDisable switches the provider off, without uninstalling it.
+func ProviderDisable(ctx *gin.Context) {
+	if err := providers.SetProviderDisabled(ctx.Params.ByName("provider"), true); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.String(200, "")
+}
+
+func GetProviderSettings(ctx *gin.Context) {
+	ctx.JSON(200, providers.GetProviderSettings())
+}
+
+// SetProviderSettings takes the order, groups, timeouts and toggles of the
+// providers as JSON.
+func SetProviderSettings(ctx *gin.Context) {
+	settings := &providers.ProviderSettings{}
+	if err := json.NewDecoder(ctx.Request.Body).Decode(settings); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	if err := providers.SetProviderSettings(settings); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	ctx.JSON(200, providers.GetProviderSettings())
+}
+
+// ResetProviderSettings searches all the providers again, in no order.
+func ResetProviderSettings(ctx *gin.Context) {
+	if err := providers.SetProviderSettings(nil); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, providers.GetProviderSettings())
+}
+
+// EnableProviders is run from the settings to re-enable all the providers.
+func EnableProviders(ctx *gin.Context) {
+	providers.EnableProvider("")
+	xbmc.Notify("Pulsar", "Providers enabled", config.AddonIcon())
+}
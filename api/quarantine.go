@@ -0,0 +1,24 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+)
+
+// Quarantined lists the torrents that failed verification too often.
+func Quarantined(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(200, btService.Quarantined())
+	}
+}
+
+// ReleaseQuarantine lets a quarantined torrent be played again.
+func ReleaseQuarantine(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := btService.ReleaseQuarantine(ctx.Params.ByName("infoHash")); err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, btService.Quarantined())
+	}
+}
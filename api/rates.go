@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+)
+
+// GetRates returns the session limits in effect, and the schedule from the
+// settings.
+func GetRates(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		schedule := make([]*bittorrent.RateSchedule, 0)
+		for _, entry := range config.Get().RateSchedule {
+			if period, err := bittorrent.ParseRateSchedule(entry); err == nil {
+				schedule = append(schedule, period)
+			}
+		}
+		ctx.JSON(200, gin.H{
+			"limits":   btService.RateLimits(),
+			"schedule": schedule,
+		})
+	}
+}
+
+// SetRates overrides the session limits until restart.
+func SetRates(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		limits := &bittorrent.RateLimits{}
+		if err := json.NewDecoder(ctx.Request.Body).Decode(limits); err != nil {
+			ctx.AbortWithError(400, err)
+			return
+		}
+		btService.SetRateLimits(limits)
+		ctx.JSON(200, limits)
+	}
+}
+
+// ResetRates goes back to the schedule and the settings.
+func ResetRates(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		btService.SetRateLimits(nil)
+		ctx.JSON(200, btService.RateLimits())
+	}
+}
+
+func GetTorrentRates(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(200, btService.TorrentRateLimits(ctx.Params.ByName("infoHash")))
+	}
+}
+
+func SetTorrentRates(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		limits := &bittorrent.RateLimits{}
+		if err := json.NewDecoder(ctx.Request.Body).Decode(limits); err != nil {
+			ctx.AbortWithError(400, err)
+			return
+		}
+		if err := btService.SetTorrentRateLimits(ctx.Params.ByName("infoHash"), limits); err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, limits)
+	}
+}
+
+func ResetTorrentRates(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		infoHash := ctx.Params.ByName("infoHash")
+		if err := btService.SetTorrentRateLimits(infoHash, nil); err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, btService.TorrentRateLimits(infoHash))
+	}
+}
+
+func GetPrivacy(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(200, btService.Privacy())
+	}
+}
+
+// SetPrivacy overrides the encryption, anonymous mode and transport
+// settings until restart.
+func SetPrivacy(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		privacy := btService.Privacy()
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&privacy); err != nil {
+			ctx.AbortWithError(400, err)
+			return
+		}
+		if err := btService.SetPrivacy(&privacy); err != nil {
+			ctx.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, privacy)
+	}
+}
+
+// ResetPrivacy goes back to the settings.
+func ResetPrivacy(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		btService.SetPrivacy(nil)
+		ctx.JSON(200, btService.Privacy())
+	}
+}
+
+// GetClientIdentity returns the client the session passes for, and the ones
+// it may pass for, by default or for some providers and trackers.
+func GetClientIdentity(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(200, gin.H{
+			"current": btService.CurrentClientIdentity(),
+			"default": config.Get().ClientIdentity,
+			"sources": config.Get().SourceClientIdentities,
+			"presets": bittorrent.ClientIdentities,
+			"warning": "Private trackers usually forbid passing for another client, and ban the accounts doing it.",
+		})
+	}
+}
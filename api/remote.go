@@ -0,0 +1,114 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/util"
+)
+
+// The remote API lets a browser drive a headless Pulsar, say on a NAS, with
+// the XBMC boxes only playing. It doesn't go through XBMC's JSON-RPC, except
+// for searching as the providers are XBMC addons.
+
+var (
+	errNoURI       = errors.New("no magnet or torrent URL given")
+	errNotJSON     = errors.New("only JSON is accepted")
+	errCrossOrigin = errors.New("cross origin requests are not accepted")
+)
+
+type remoteAdd struct {
+	URI string `json:"uri"`
+}
+
+func RemoteUI(ctx *gin.Context) {
+	ctx.Data(200, "text/html; charset=utf-8", []byte(webUI))
+}
+
+func RemoteTorrents(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		torrents, err := torrentsPage(ctx, btService.Torrents())
+		if err != nil {
+			ctx.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, torrents)
+	}
+}
+
+func RemoteTorrent(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		status, err := btService.TorrentStatus(ctx.Params.ByName("infoHash"))
+		if err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, status)
+	}
+}
+
+// sameOrigin tells whether the request comes from our own pages, or from
+// something that isn't a browser. A page elsewhere could otherwise have the
+// browser of someone on the LAN queue torrents: only JSON is taken, which
+// browsers can't send cross origin without asking first, and the origin has
+// to be us when given.
+func sameOrigin(req *http.Request) error {
+	if mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		return errNotJSON
+	}
+	host := req.Host
+	if util.IsForwarded(req) {
+		// where the proxy is reached
+		if external, err := url.Parse(util.ExternalURL(req)); err == nil {
+			host = external.Host
+		}
+	}
+	if origin := req.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || u.Host != host {
+			return errCrossOrigin
+		}
+	}
+	return nil
+}
+
+// RemoteAdd queues a magnet or torrent URL, given as JSON, for background
+// download.
+func RemoteAdd(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := sameOrigin(ctx.Request); err != nil {
+			ctx.JSON(403, gin.H{"error": err.Error()})
+			return
+		}
+		add := &remoteAdd{}
+		if err := json.NewDecoder(ctx.Request.Body).Decode(add); err != nil {
+			ctx.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if add.URI == "" {
+			ctx.JSON(400, gin.H{"error": errNoURI.Error()})
+			return
+		}
+		if err := btService.AddDownload(add.URI); err != nil {
+			ctx.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Remote client queued %s\n", add.URI)
+		ctx.JSON(200, gin.H{"info_hash": bittorrent.ExtractInfoHash(add.URI)})
+	}
+}
+
+func RemoteSearch(ctx *gin.Context) {
+	query := ctx.Request.URL.Query().Get("q")
+	if query == "" {
+		ctx.JSON(400, gin.H{"error": "no query given"})
+		return
+	}
+	ctx.JSON(200, providers.Search(ctx.Request.Context(), providers.GetSearchers(), query))
+}
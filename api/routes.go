@@ -12,6 +12,7 @@ import (
 	"github.com/steeve/pulsar/cache"
 	"github.com/steeve/pulsar/config"
 	"github.com/steeve/pulsar/ga"
+	"github.com/steeve/pulsar/profiles"
 	"github.com/steeve/pulsar/providers"
 	"github.com/steeve/pulsar/util"
 )
@@ -20,6 +21,7 @@ const (
 	DefaultCacheTime    = 6 * time.Hour
 	RepositoryCacheTime = 20 * time.Minute
 	EpisodesCacheTime   = 15 * time.Minute
+	WidgetCacheTime     = 1 * time.Hour
 	IndexCacheTime      = 15 * 24 * time.Hour // 15 days caching for index
 )
 
@@ -29,50 +31,138 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 
 	r.Use(ga.GATracker())
+	r.Use(HTTPAuth())
+	r.Use(KioskGuard())
 
 	store := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
 
 	r.GET("/", Index)
-	r.GET("/search", Search)
+	r.GET("/health", Health)
+	r.GET("/healthz", Healthz)
+	r.GET("/readyz", Readyz(btService))
+	r.GET("/metrics", Metrics(btService))
+	r.GET("/diagnostics/bandwidth", Bandwidth)
+	r.GET("/diagnostics/doctor", Doctor(btService))
+	r.GET("/logs", Logs)
+	r.GET("/search", searchThrottle.Throttle(), Search)
+	r.GET("/localsearch", LocalSearch)
+	r.GET("/localsearch/entries", LocalSearchEntries)
 	r.GET("/pasted", PasteURL)
 
 	movies := r.Group("/movies")
 	{
-		movies.GET("/", cache.Cache(store, IndexCacheTime), MoviesIndex)
+		movies.GET("/", profileCache(store, IndexCacheTime), MoviesIndex)
 		movies.GET("/search", SearchMovies)
-		movies.GET("/popular", cache.Cache(store, DefaultCacheTime), PopularMovies)
-		movies.GET("/popular/:genre", cache.Cache(store, DefaultCacheTime), PopularMovies)
-		movies.GET("/top", cache.Cache(store, DefaultCacheTime), TopRatedMovies)
-		movies.GET("/imdb250", cache.Cache(store, DefaultCacheTime), IMDBTop250)
-		movies.GET("/mostvoted", cache.Cache(store, DefaultCacheTime), MoviesMostVoted)
-		movies.GET("/genres", cache.Cache(store, IndexCacheTime), MovieGenres)
+		movies.GET("/popular", profileCache(store, DefaultCacheTime), PopularMovies)
+		movies.GET("/popular/:genre", profileCache(store, DefaultCacheTime), PopularMovies)
+		movies.GET("/top", profileCache(store, DefaultCacheTime), TopRatedMovies)
+		movies.GET("/imdb250", profileCache(store, DefaultCacheTime), IMDBTop250)
+		movies.GET("/mostvoted", profileCache(store, DefaultCacheTime), MoviesMostVoted)
+		movies.GET("/genres", profileCache(store, IndexCacheTime), MovieGenres)
+		movies.GET("/similar/:tmdbId", profileCache(store, DefaultCacheTime), SimilarMovies)
+		movies.GET("/watchlist", MoviesWatchlist)
 	}
 	movie := r.Group("/movie")
 	{
-		movie.GET("/:imdbId/links", MovieLinks)
-		movie.GET("/:imdbId/play", MoviePlay)
+		movie.GET("/:imdbId/links", searchThrottle.Throttle(), MovieLinks)
+		movie.GET("/:imdbId/links/stream", searchThrottle.Throttle(), MovieLinksStream)
+		movie.GET("/:imdbId/play", searchThrottle.Throttle(), MoviePlay(btService))
+		movie.GET("/:imdbId/debug", MovieDebug)
+		movie.GET("/:imdbId/collection", MovieCollection(btService))
+		movie.GET("/:imdbId/download", searchThrottle.Throttle(), MovieDownload(btService))
+		movie.GET("/:imdbId/watched", MovieMarkWatched)
 	}
 
 	shows := r.Group("/shows")
 	{
-		shows.GET("/", cache.Cache(store, IndexCacheTime), TVIndex)
+		shows.GET("/", profileCache(store, IndexCacheTime), TVIndex)
 		shows.GET("/search", SearchShows)
-		shows.GET("/popular", cache.Cache(store, DefaultCacheTime), PopularShows)
-		shows.GET("/popular/:genre", cache.Cache(store, DefaultCacheTime), PopularShows)
-		shows.GET("/top", cache.Cache(store, DefaultCacheTime), TopRatedShows)
-		shows.GET("/mostvoted", cache.Cache(store, DefaultCacheTime), TVMostVoted)
-		shows.GET("/genres", cache.Cache(store, IndexCacheTime), TVGenres)
+		shows.GET("/popular", profileCache(store, DefaultCacheTime), PopularShows)
+		shows.GET("/popular/:genre", profileCache(store, DefaultCacheTime), PopularShows)
+		shows.GET("/top", profileCache(store, DefaultCacheTime), TopRatedShows)
+		shows.GET("/mostvoted", profileCache(store, DefaultCacheTime), TVMostVoted)
+		shows.GET("/genres", profileCache(store, IndexCacheTime), TVGenres)
+		shows.GET("/similar/:tmdbId", profileCache(store, DefaultCacheTime), SimilarShows)
+		shows.GET("/watchlist", ShowsWatchlist)
 	}
 	show := r.Group("/show")
 	{
-		show.GET("/:showId/seasons", cache.Cache(store, DefaultCacheTime), ShowSeasons)
-		show.GET("/:showId/season/:season/episodes", cache.Cache(store, EpisodesCacheTime), ShowEpisodes)
-		show.GET("/:showId/season/:season/episode/:episode/links", ShowEpisodeLinks)
-		show.GET("/:showId/season/:season/episode/:episode/play", ShowEpisodePlay)
+		show.GET("/:showId/seasons", profileCache(store, DefaultCacheTime), ShowSeasons)
+		show.GET("/:showId/season/:season/episodes", profileCache(store, EpisodesCacheTime), ShowEpisodes)
+		show.GET("/:showId/season/:season/episode/:episode/links", searchThrottle.Throttle(), ShowEpisodeLinks)
+		show.GET("/:showId/season/:season/episode/:episode/links/stream", searchThrottle.Throttle(), ShowEpisodeLinksStream)
+		show.GET("/:showId/season/:season/episode/:episode/play", searchThrottle.Throttle(), ShowEpisodePlay(btService))
+		show.GET("/:showId/season/:season/episode/:episode/debug", ShowEpisodeDebug)
+		show.GET("/:showId/season/:season/episode/:episode/download", searchThrottle.Throttle(), ShowEpisodeDownload(btService))
+		show.GET("/:showId/season/:season/episode/:episode/watched", ShowEpisodeMarkWatched)
 	}
 
+	widgetsGroup := r.Group("/widgets")
+	{
+		for _, widget := range widgets() {
+			if widget.live {
+				widgetsGroup.GET(widget.path, widget.full)
+				widgetsGroup.GET("/json"+widget.path, widget.lite)
+				continue
+			}
+			widgetsGroup.GET(widget.path, profileCache(store, WidgetCacheTime), widget.full)
+			widgetsGroup.GET("/json"+widget.path, profileCache(store, WidgetCacheTime), widget.lite)
+		}
+	}
+
+	traktGroup := r.Group("/trakt")
+	{
+		traktGroup.GET("/", TraktIndex)
+		traktGroup.GET("/authorize", TraktAuthorize)
+		traktGroup.GET("/deauthorize", TraktDeauthorize)
+		traktGroup.GET("/movies/:list", TraktMovies)
+		traktGroup.GET("/shows/:list", TraktShows)
+	}
+
+	watchlistGroup := r.Group("/watchlist")
+	{
+		watchlistGroup.GET("/:kind/add/:tmdbId", WatchlistAdd)
+		watchlistGroup.GET("/:kind/remove/:tmdbId", WatchlistRemove)
+	}
+
+	historyGroup := r.Group("/history")
+	{
+		historyGroup.GET("/", ContinueWatching)
+		historyGroup.GET("/entries", HistoryEntries)
+		historyGroup.GET("/play/:key", HistoryPlay)
+		historyGroup.GET("/remove/:key", HistoryRemove)
+		historyGroup.GET("/list", HistoryList)
+		historyGroup.GET("/search", HistorySearch)
+		historyGroup.GET("/tags", HistoryTags)
+		historyGroup.GET("/note/:key", HistoryEditNote)
+		historyGroup.GET("/tags/:key", HistoryEditTags)
+		historyGroup.POST("/note/:key", HistorySetNote)
+		historyGroup.POST("/tags/:key", HistorySetTags)
+		historyGroup.DELETE("/", HistoryClear)
+	}
+
+	libraryGroup := r.Group("/library")
+	{
+		libraryGroup.GET("/shows", LibraryShows)
+		libraryGroup.GET("/update", LibraryUpdate)
+		libraryGroup.GET("/movie/:imdbId/add", LibraryAddMovie)
+		libraryGroup.GET("/show/:showId/add", LibraryAddShow)
+		libraryGroup.GET("/show/:showId/remove", LibraryRemoveShow)
+	}
+
+	// not under /provider, where it would clash with the provider names
+	r.GET("/providers/status", ProvidersStatus)
+	r.GET("/providers/settings", GetProviderSettings)
+	r.PUT("/providers/settings", SetProviderSettings)
+	r.DELETE("/providers/settings", ResetProviderSettings)
+	r.GET("/failures/last", LastFailure)
+
 	provider := r.Group("/provider")
 	{
+		provider.GET("/:provider/test", searchThrottle.Throttle(), ProviderTest)
+		provider.GET("/:provider/test/:test", searchThrottle.Throttle(), ProviderTestRun)
+		provider.POST("/:provider/enable", ProviderEnable)
+		provider.POST("/:provider/disable", ProviderDisable)
 		provider.GET("/:provider/movie/:imdbId", ProviderGetMovie)
 		provider.GET("/:provider/show/:showId/season/:season/episode/:episode", ProviderGetEpisode)
 	}
@@ -86,24 +176,194 @@ func Routes(btService *bittorrent.BTService) *gin.Engine {
 	r.GET("/youtube/:id", PlayYoutubeVideo)
 
 	r.GET("/subtitles", SubtitlesIndex)
+	r.GET("/subtitles/search", SubtitlesSearch)
+	r.GET("/subtitles/offset", GetSubtitleOffset)
+	r.PUT("/subtitles/offset", SetSubtitleOffset)
+	r.DELETE("/subtitles/offset", ResetSubtitleOffset)
+	r.POST("/subtitles/offset/detect", DetectSubtitleOffset)
 	r.GET("/subtitle/:id", SubtitleGet)
 
-	r.GET("/play", Play(btService))
+	r.GET("/play", addThrottle.Throttle(), Play(btService))
+	r.GET("/usenet/:id/*name", UsenetFile)
+
+	r.GET("/result/:infoHash", ResultExplain)
+	r.GET("/result/:infoHash/why", ResultWhy)
+
+	player := r.Group("/player")
+	{
+		player.GET("/markers", PlayerMarkers(btService))
+		player.GET("/stats", PlayerStats(btService))
+	}
+
+	r.GET("/analytics", Analytics)
+	r.POST("/analytics/clear", AnalyticsClear)
+
+	r.GET("/undo", UndoList)
+	r.POST("/undo/:action", UndoAction)
+
+	downloads := r.Group("/downloads")
+	{
+		downloads.GET("/after", AfterDownloads(btService))
+		downloads.POST("/after/:action", SetAfterDownloads(btService))
+	}
+
+	queue := r.Group("/queue")
+	{
+		queue.GET("/", DownloadsQueue(btService))
+		queue.POST("/:infoHash/pause", DownloadPause(btService))
+		queue.POST("/:infoHash/resume", DownloadResume(btService))
+		queue.POST("/:infoHash/priority/:priority", DownloadPriority(btService))
+		queue.DELETE("/:infoHash", DownloadRemove(btService))
+	}
+
+	torrents := r.Group("/torrents")
+	{
+		torrents.GET("/:infoHash/delete", TorrentDelete(btService))
+		torrents.GET("/:infoHash/remove", TorrentRemove(btService))
+		torrents.GET("/:infoHash/mode", TorrentMode(btService))
+		torrents.POST("/:infoHash/mode/:mode", SetTorrentMode(btService))
+		torrents.GET("/:infoHash/rates", GetTorrentRates(btService))
+		torrents.PUT("/:infoHash/rates", SetTorrentRates(btService))
+		torrents.DELETE("/:infoHash/rates", ResetTorrentRates(btService))
+	}
+
+	r.GET("/quarantine", Quarantined(btService))
+	r.DELETE("/quarantine/:infoHash", ReleaseQuarantine(btService))
+
+	r.GET("/rates", GetRates(btService))
+	r.PUT("/rates", SetRates(btService))
+	r.DELETE("/rates", ResetRates(btService))
+
+	r.GET("/privacy", GetPrivacy(btService))
+	r.PUT("/privacy", SetPrivacy(btService))
+	r.DELETE("/privacy", ResetPrivacy(btService))
+	r.GET("/identity", GetClientIdentity(btService))
+
+	r.GET("/import", ImportCandidates)
+	r.POST("/import/:source", ImportFrom)
+
+	remote := r.Group("/remote")
+	{
+		remote.GET("/", RemoteUI)
+		remote.GET("/search", searchThrottle.Throttle(), RemoteSearch)
+		remote.GET("/torrents", RemoteTorrents(btService))
+		remote.POST("/torrents", addThrottle.Throttle(), RemoteAdd(btService))
+		remote.GET("/torrents/:infoHash", RemoteTorrent(btService))
+	}
+
+	r.POST("/rpc", RPC(btService))
+
+	r.GET("/kiosk", KioskStatus)
+	r.POST("/kiosk/enable", KioskEnable)
+	r.POST("/kiosk/disable", kioskPinThrottle.Throttle(), KioskDisable)
+
+	r.GET("/menu", GetMenu)
+	r.PUT("/menu", SetMenu)
+	r.DELETE("/menu", ResetMenu)
+	r.GET("/menu/reset", ResetMenuCmd)
+	r.GET("/menu/section/:section/:action", MenuSectionAction)
+
+	r.GET("/filters", GetFilters)
+	r.PUT("/filters", SetFilters)
+	r.DELETE("/filters", ResetFilters)
+
+	r.GET("/tasks", Tasks)
+	r.POST("/tasks/:task/run", TaskRun)
+
+	r.POST("/watched/sync", WatchedSync)
+
+	r.GET("/vacation", GetVacation)
+	r.PUT("/vacation", SetVacation)
+	r.DELETE("/vacation", EndVacation)
+
+	r.GET("/subsystems", Subsystems)
+	r.POST("/subsystems/:name/start", SubsystemStart)
+	r.POST("/subsystems/:name/stop", SubsystemStop)
+
+	r.GET("/profiles", ProfilesList)
+	r.GET("/profiles/current", ProfileCurrent)
+	r.POST("/profiles/select", ProfileSelect)
+	r.POST("/profile/:name", ProfileSave)
+
+	r.GET("/storage", Storage(btService))
+
+	seeding := r.Group("/seeding")
+	{
+		seeding.GET("/", Seeding(btService))
+		seeding.GET("/list", SeedingList(btService))
+		seeding.GET("/list/:infoHash/:action", SeedingListAction(btService))
+		seeding.POST("/:infoHash/stop", SeedingStop(btService))
+		seeding.POST("/:infoHash/keep", SeedingKeep(btService))
+		seeding.POST("/:infoHash/policy", SetSeedingPolicy(btService))
+	}
+
+	r.GET("/backup", Backup)
+	r.POST("/backup/restore", Restore)
+
+	together := r.Group("/together")
+	{
+		together.GET("/:room", TogetherState)
+		together.POST("/:room/join", TogetherJoin)
+		together.POST("/:room/leave", TogetherLeave)
+		together.POST("/:room/event/:event", TogetherPublish)
+		together.GET("/:room/events", TogetherEvents)
+	}
+
+	r.GET("/callbacks/:cid", providers.CallbackStatus)
 	r.POST("/callbacks/:cid", providers.CallbackHandler)
 
+	metadata := r.Group("/metadata")
+	{
+		metadata.POST("/refresh", MetadataRefresh)
+		metadata.DELETE("/:kind", MetadataInvalidate)
+		metadata.DELETE("/:kind/:key", MetadataInvalidate)
+	}
+
 	cmd := r.Group("/cmd")
 	{
 		cmd.GET("/clear_cache", ClearCache)
+		cmd.GET("/clear_streams", ClearStreamsCache(btService))
+		cmd.GET("/undo", UndoCmd)
+		cmd.GET("/undo/:action", UndoCmd)
+		cmd.GET("/downloads", ManageDownloads(btService))
+		cmd.GET("/subtitles", ChooseSubtitles)
+		cmd.GET("/subtitles/sync", SyncSubtitles)
+		cmd.GET("/logs", ViewLogs)
+		cmd.GET("/bandwidth_test", BandwidthTest)
+		cmd.GET("/doctor", ConnectivityDoctor(btService))
+		cmd.GET("/enable_providers", EnableProviders)
+		cmd.GET("/import", ImportAssistant)
 	}
 
 	return r
 }
 
+// profileCache caches the lists per profile, as they're filtered by its
+// parental settings and show its watchlist.
+func profileCache(store cache.CacheStore, expire time.Duration) gin.HandlerFunc {
+	return cache.CacheBy(store, expire, func(ctx *gin.Context) string {
+		return profiles.Current().CacheKey()
+	})
+}
+
 func UrlForHTTP(pattern string, args ...interface{}) string {
 	u, _ := url.Parse(fmt.Sprintf(pattern, args...))
 	return util.GetHTTPHost() + u.String()
 }
 
+// UrlForClient is UrlForHTTP for the client of the request, which may reach
+// us through a reverse proxy.
+func UrlForClient(ctx *gin.Context, pattern string, args ...interface{}) string {
+	u, _ := url.Parse(fmt.Sprintf(pattern, args...))
+	return util.ExternalURL(ctx.Request) + u.String()
+}
+
+// urlForLocal is for calling ourselves.
+func urlForLocal(pattern string, args ...interface{}) string {
+	u, _ := url.Parse(fmt.Sprintf(pattern, args...))
+	return util.GetLocalHTTPHost() + u.String()
+}
+
 func UrlForXBMC(pattern string, args ...interface{}) string {
 	u, _ := url.Parse(fmt.Sprintf(pattern, args...))
 	return "plugin://" + config.Get().Info.Id + u.String()
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+)
+
+// The control API is JSON-RPC 2.0, POSTed to /rpc with the rpc_token of
+// the settings as a bearer token, for tools such as download managers or
+// home automation to drive Pulsar without XBMC's menus. It's off while
+// rpc_token is empty. Batches are supported. Params are objects:
+//
+//   search          {"query"}                        the torrents found
+//   search_movie    {"imdb_id"}                      the torrents found
+//   search_episode  {"tvdb_id", "season", "episode"} the torrents found
+//   add_magnet      {"uri"}                          {"info_hash"}, queued for download
+//   list_torrents   {}                               the status of the torrents
+//   torrent_status  {"info_hash"}                    the status of the torrent
+//   playback_url    {"uri"}, {"imdb_id"} or {"tvdb_id", "season", "episode"}
+//                   {"plugin_url", "http_url"}, to play in XBMC
+//
+// Searches go through the providers, which are XBMC addons.
+
+const rpcVersion = "2.0"
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	rpcUnauthorized   = -32001
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *rpcError) Error() string {
+	return err.Message
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type rpcParams struct {
+	Query    string `json:"query"`
+	URI      string `json:"uri"`
+	InfoHash string `json:"info_hash"`
+	IMDBId   string `json:"imdb_id"`
+	TVDBId   int    `json:"tvdb_id"`
+	Season   int    `json:"season"`
+	Episode  int    `json:"episode"`
+}
+
+type rpcMethod func(ctx *gin.Context, btService *bittorrent.BTService, params *rpcParams) (interface{}, error)
+
+var rpcMethods = map[string]rpcMethod{
+	"search":         rpcSearch,
+	"search_movie":   rpcSearchMovie,
+	"search_episode": rpcSearchEpisode,
+	"add_magnet":     rpcAddMagnet,
+	"list_torrents":  rpcListTorrents,
+	"torrent_status": rpcTorrentStatus,
+	"playback_url":   rpcPlaybackURL,
+}
+
+func invalidParams(message string) *rpcError {
+	return &rpcError{Code: rpcInvalidParams, Message: message}
+}
+
+func rpcAuthorized(ctx *gin.Context) bool {
+	token := config.Get().RPCToken
+	given := strings.TrimPrefix(ctx.Request.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// RPC answers the JSON-RPC requests of the control API.
+func RPC(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if config.Get().RPCToken == "" {
+			ctx.JSON(403, &rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcUnauthorized, Message: "the control API is off, set rpc_token"}})
+			return
+		}
+		if rpcAuthorized(ctx) == false {
+			log.Printf("Rejected unauthenticated RPC call from %s\n", ctx.Request.RemoteAddr)
+			ctx.JSON(401, &rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcUnauthorized, Message: "bad or missing token"}})
+			return
+		}
+
+		var raw json.RawMessage
+		if err := json.NewDecoder(ctx.Request.Body).Decode(&raw); err != nil {
+			ctx.JSON(200, &rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcParseError, Message: err.Error()}})
+			return
+		}
+		if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") == false {
+			if response := rpcCall(ctx, btService, raw); response != nil {
+				ctx.JSON(200, response)
+			} else {
+				ctx.String(204, "")
+			}
+			return
+		}
+
+		var batch []json.RawMessage
+		if err := json.Unmarshal(raw, &batch); err != nil || len(batch) == 0 {
+			ctx.JSON(200, &rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcInvalidRequest, Message: "empty or invalid batch"}})
+			return
+		}
+		responses := make([]*rpcResponse, 0, len(batch))
+		for _, call := range batch {
+			if response := rpcCall(ctx, btService, call); response != nil {
+				responses = append(responses, response)
+			}
+		}
+		if len(responses) == 0 {
+			ctx.String(204, "")
+			return
+		}
+		ctx.JSON(200, responses)
+	}
+}
+
+// rpcCall runs one request, nil being the answer to notifications, which
+// have no id.
+func rpcCall(ctx *gin.Context, btService *bittorrent.BTService, raw json.RawMessage) *rpcResponse {
+	request := &rpcRequest{}
+	if err := json.Unmarshal(raw, request); err != nil || request.JSONRPC != rpcVersion || request.Method == "" {
+		return &rpcResponse{JSONRPC: rpcVersion, Error: &rpcError{Code: rpcInvalidRequest, Message: "not a JSON-RPC 2.0 request"}, Id: json.RawMessage("null")}
+	}
+	response := &rpcResponse{JSONRPC: rpcVersion, Id: request.Id}
+
+	method, ok := rpcMethods[request.Method]
+	if ok == false {
+		response.Error = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("no method %s", request.Method)}
+	} else {
+		params := &rpcParams{}
+		if len(request.Params) > 0 && string(request.Params) != "null" {
+			if err := json.Unmarshal(request.Params, params); err != nil {
+				response.Error = invalidParams(err.Error())
+			}
+		}
+		if response.Error == nil {
+			result, err := method(ctx, btService, params)
+			if rpcErr, ok := err.(*rpcError); ok {
+				response.Error = rpcErr
+			} else if err != nil {
+				response.Error = &rpcError{Code: rpcInternalError, Message: err.Error()}
+			} else {
+				response.Result = result
+			}
+		}
+	}
+	if len(request.Id) == 0 {
+		return nil
+	}
+	return response
+}
+
+func rpcSearch(ctx *gin.Context, btService *bittorrent.BTService, params *rpcParams) (interface{}, error) {
+	if params.Query == "" {
+		return nil, invalidParams("no query given")
+	}
+	return providers.Search(ctx.Request.Context(), providers.GetSearchers(), params.Query), nil
+}
+
+func rpcSearchMovie(ctx *gin.Context, btService *bittorrent.BTService, params *rpcParams) (interface{}, error) {
+	if params.IMDBId == "" {
+		return nil, invalidParams("no imdb_id given")
+	}
+	if tmdb.GetMovieFromIMDB(params.IMDBId, config.Get().Language) == nil {
+		return nil, invalidParams("no such movie")
+	}
+	return movieLinks(ctx.Request.Context(), params.IMDBId, false), nil
+}
+
+func rpcSearchEpisode(ctx *gin.Context, btService *bittorrent.BTService, params *rpcParams) (interface{}, error) {
+	if params.TVDBId == 0 || params.Episode < 1 {
+		return nil, invalidParams("tvdb_id, season and episode are needed")
+	}
+	showId := strconv.Itoa(params.TVDBId)
+	show, err := tvdb.NewShowCached(showId, config.Get().Language)
+	if err != nil {
+		return nil, err
+	}
+	if params.Season < 0 || params.Season >= len(show.Seasons) || params.Episode > len(show.Seasons[params.Season].Episodes) {
+		return nil, invalidParams("no such episode")
+	}
+	return showEpisodeLinks(ctx.Request.Context(), showId, params.Season, params.Episode, false)
+}
+
+func rpcAddMagnet(ctx *gin.Context, btService *bittorrent.BTService, params *rpcParams) (interface{}, error) {
+	if params.URI == "" {
+		return nil, invalidParams(errNoURI.Error())
+	}
+	if err := btService.AddDownload(params.URI); err != nil {
+		return nil, err
+	}
+	log.Printf("RPC client queued %s\n", params.URI)
+	return gin.H{"info_hash": bittorrent.ExtractInfoHash(params.URI)}, nil
+}
+
+func rpcListTorrents(ctx *gin.Context, btService *bittorrent.BTService, params *rpcParams) (interface{}, error) {
+	return btService.Torrents(), nil
+}
+
+func rpcTorrentStatus(ctx *gin.Context, btService *bittorrent.BTService, params *rpcParams) (interface{}, error) {
+	if params.InfoHash == "" {
+		return nil, invalidParams("no info_hash given")
+	}
+	return btService.TorrentStatus(params.InfoHash)
+}
+
+// rpcPlaybackURL returns where to play the torrent, the movie or the
+// episode: the plugin URL for XBMC, and the one to GET for XBMC to play it.
+func rpcPlaybackURL(ctx *gin.Context, btService *bittorrent.BTService, params *rpcParams) (interface{}, error) {
+	switch {
+	case params.URI != "":
+		return gin.H{
+			"plugin_url": UrlQuery(UrlForXBMC("/play"), "uri", params.URI),
+			"http_url":   UrlQuery(UrlForClient(ctx, "/play"), "uri", params.URI),
+		}, nil
+	case params.IMDBId != "":
+		return gin.H{
+			"plugin_url": UrlForXBMC("/movie/%s/play", params.IMDBId),
+			"http_url":   UrlForClient(ctx, "/movie/%s/play", params.IMDBId),
+		}, nil
+	case params.TVDBId != 0 && params.Episode > 0:
+		return gin.H{
+			"plugin_url": UrlForXBMC("/show/%d/season/%d/episode/%d/play", params.TVDBId, params.Season, params.Episode),
+			"http_url":   UrlForClient(ctx, "/show/%d/season/%d/episode/%d/play", params.TVDBId, params.Season, params.Episode),
+		}, nil
+	}
+	return nil, invalidParams("uri, imdb_id or tvdb_id, season and episode are needed")
+}
@@ -3,13 +3,78 @@ package api
 import (
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/analytics"
+	"github.com/steeve/pulsar/bittorrent"
 	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/util"
 	"github.com/steeve/pulsar/xbmc"
 )
 
+// swarmLabel is the seeds and peers of the torrent, and its size if known.
+func swarmLabel(torrent *bittorrent.Torrent) string {
+	label := fmt.Sprintf("S:%d P:%d", torrent.Seeds, torrent.Peers)
+	if xbmc.TextOnly() {
+		label = fmt.Sprintf("%d seeds, %d peers", torrent.Seeds, torrent.Peers)
+	}
+	if torrent.SeedsVerified {
+		label += " (verified)"
+	}
+	if torrent.Size > 0 {
+		label += " - " + util.FormatSize(torrent.Size)
+	}
+	return label
+}
+
+// resultLabel describes the result from its fields, which the provider set
+// or we parsed from its name. The name is only shown when nothing is known.
+func resultLabel(torrent *bittorrent.Torrent) string {
+	info := make([]string, 0)
+	if torrent.RipType > 0 {
+		info = append(info, bittorrent.Rips[torrent.RipType])
+	}
+	if torrent.Resolution > 0 {
+		info = append(info, bittorrent.Resolutions[torrent.Resolution])
+	}
+	if torrent.VideoCodec > 0 {
+		info = append(info, bittorrent.Codecs[torrent.VideoCodec])
+	}
+	if torrent.AudioCodec > 0 {
+		info = append(info, bittorrent.Codecs[torrent.AudioCodec])
+	}
+	if torrent.Language != "" {
+		info = append(info, strings.ToUpper(torrent.Language))
+	}
+	if torrent.SceneRating > 0 {
+		info = append(info, strings.ToUpper(bittorrent.SceneRatings[torrent.SceneRating]))
+	}
+	parts := make([]string, 0)
+	if len(info) > 0 {
+		parts = append(parts, strings.Join(info, " "))
+	}
+	if torrent.ReleaseGroup != "" {
+		parts = append(parts, torrent.ReleaseGroup)
+	}
+	if torrent.SeasonPack {
+		parts = append(parts, "Season pack")
+	}
+	if len(parts) == 0 {
+		parts = append(parts, torrent.Name)
+	}
+	label := strings.Join(parts, " - ")
+	if tag := providers.ResultTag(torrent); tag != "" {
+		label += " (" + tag + ")"
+	}
+	return label
+}
+
 func Search(c *gin.Context) {
+	if isDryRun(c) {
+		searchDryRun(c)
+		return
+	}
 	query := xbmc.Keyboard("", "Search")
 	if query == "" {
 		return
@@ -18,15 +83,17 @@ func Search(c *gin.Context) {
 	log.Println("Searching providers for:", query)
 
 	searchers := providers.GetSearchers()
-	torrents := providers.Search(searchers, query)
+	torrents := providers.Search(c.Request.Context(), searchers, query)
+	analytics.RecordSearch("query", len(torrents))
 
 	items := make(xbmc.ListItems, 0, len(torrents))
 	for _, torrent := range torrents {
 		item := &xbmc.ListItem{
-			Label:      fmt.Sprintf("S:%d P:%d - %s", torrent.Seeds, torrent.Peers, torrent.Name),
+			Label:      fmt.Sprintf("%s - %s", swarmLabel(torrent), resultLabel(torrent)),
 			Path:       UrlQuery(UrlForXBMC("/play"), "uri", torrent.URI),
 			IsPlayable: true,
 		}
+		item.ContextMenu = contextMenu(&menuTarget{Kind: menuResult, InfoHash: torrent.InfoHash})
 		items = append(items, item)
 	}
 
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/util"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+func Seeding(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(200, btService.Seeding())
+	}
+}
+
+func SeedingStop(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := btService.StopSeeding(ctx.Params.ByName("infoHash")); err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.String(200, "")
+	}
+}
+
+func SeedingKeep(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := btService.KeepSeeding(ctx.Params.ByName("infoHash")); err != nil {
+			ctx.JSON(404, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.String(200, "")
+	}
+}
+
+// SetSeedingPolicy overrides the seeding targets of a torrent, as in
+// ?ratio=1.5&time=12h.
+func SetSeedingPolicy(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		q := ctx.Request.URL.Query()
+		policy := bittorrent.SeedPolicy{}
+		var err error
+		if ratio := q.Get("ratio"); ratio != "" {
+			if policy.Ratio, err = strconv.ParseFloat(ratio, 64); err != nil {
+				ctx.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if seedTime := q.Get("time"); seedTime != "" {
+			if policy.Time, err = time.ParseDuration(seedTime); err != nil {
+				ctx.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if err := btService.SetSeedPolicy(ctx.Params.ByName("infoHash"), policy); err != nil {
+			ctx.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, policy)
+	}
+}
+
+func seedingLabel(seeding *bittorrent.SeedingStatus) string {
+	label := fmt.Sprintf("%s - ratio %.2f, %s", seeding.Name, seeding.Ratio, util.FormatSpeed(int64(seeding.UploadRate)))
+	if seeding.Policy.Forever {
+		label += ", until stopped"
+	}
+	return label
+}
+
+// SeedingList lists the torrents seeding after playback in Kodi, with
+// actions to stop them, or keep them seeding, from their context menu.
+func SeedingList(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		seeding := btService.Seeding()
+		items := make(xbmc.ListItems, 0, len(seeding))
+		for _, torrent := range seeding {
+			items = append(items, &xbmc.ListItem{
+				Label:       seedingLabel(torrent),
+				Path:        UrlForXBMC("/seeding/list"),
+				ContextMenu: contextMenu(&menuTarget{Kind: menuSeeding, InfoHash: torrent.InfoHash, Forever: torrent.Policy.Forever}),
+			})
+		}
+		ctx.JSON(200, xbmc.NewView("", items))
+	}
+}
+
+// SeedingListAction stops or keeps seeding a torrent from the context menu,
+// then lists the seeding torrents again in place.
+func SeedingListAction(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		infoHash := ctx.Params.ByName("infoHash")
+		var err error
+		switch ctx.Params.ByName("action") {
+		case "stop":
+			err = btService.StopSeeding(infoHash)
+		case "keep":
+			err = btService.KeepSeeding(infoHash)
+		default:
+			ctx.AbortWithStatus(404)
+			return
+		}
+		if err != nil {
+			xbmc.Notify("Pulsar", err.Error(), config.AddonIcon())
+		}
+		SeedingList(btService)(ctx)
+	}
+}
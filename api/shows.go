@@ -1,17 +1,22 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/analytics"
 	"github.com/steeve/pulsar/bittorrent"
 	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/fanart"
+	"github.com/steeve/pulsar/profiles"
 	"github.com/steeve/pulsar/providers"
 	"github.com/steeve/pulsar/tmdb"
 	"github.com/steeve/pulsar/tvdb"
+	"github.com/steeve/pulsar/watchlist"
 	"github.com/steeve/pulsar/xbmc"
 )
 
@@ -23,6 +28,7 @@ func TVIndex(ctx *gin.Context) {
 	items := xbmc.ListItems{
 		{Label: "Search", Path: UrlForXBMC("/shows/search"), Thumbnail: config.AddonResource("img", "search.png")},
 		{Label: "Most Popular", Path: UrlForXBMC("/shows/popular"), Thumbnail: config.AddonResource("img", "popular.png")},
+		{Label: "Watchlist", Path: UrlForXBMC("/shows/watchlist"), Thumbnail: config.AddonResource("img", "popular.png")},
 	}
 	for _, genre := range tmdb.GetTVGenres(config.Get().Language) {
 		slug, _ := genreSlugs[genre.Id]
@@ -50,17 +56,40 @@ func TVGenres(ctx *gin.Context) {
 }
 
 func renderShows(shows tmdb.Shows, ctx *gin.Context) {
+	ctx.JSON(200, xbmc.NewView("tvshows", showListItems(shows)))
+}
+
+func showListItems(shows tmdb.Shows) xbmc.ListItems {
+	profile := profiles.Current()
+	inWatchlist := watchlistSet(watchlist.Shows)
 	items := make(xbmc.ListItems, 0, len(shows))
+	tvdbIds := make([]int, 0, len(shows))
 	for _, show := range shows {
 		if show == nil {
 			continue
 		}
+		genreIds := make([]int, 0, len(show.Genres))
+		for _, genre := range show.Genres {
+			genreIds = append(genreIds, genre.Id)
+		}
+		if profile.Allows(show.IsAdult, genreIds) == false {
+			continue
+		}
 		item := show.ToListItem()
 		item.Path = UrlForXBMC("/show/%d/seasons", show.ExternalIDs.TVDBID)
+		item.ContextMenu = contextMenu(&menuTarget{
+			Kind:        menuShow,
+			TMDBId:      show.Id,
+			ShowId:      show.ExternalIDs.TVDBID,
+			InWatchlist: inWatchlist[show.Id],
+		})
 		items = append(items, item)
+		tvdbIds = append(tvdbIds, show.ExternalIDs.TVDBID)
 	}
-
-	ctx.JSON(200, xbmc.NewView("tvshows", items))
+	fanart.Lookups(len(items), func(i int) {
+		fanart.GetShow(tvdbIds[i]).Apply(items[i], config.Get().Language)
+	})
+	return items
 }
 
 func PopularShows(ctx *gin.Context) {
@@ -87,6 +116,11 @@ func SearchShows(ctx *gin.Context) {
 	renderShows(tmdb.SearchShows(query, config.Get().Language), ctx)
 }
 
+func SimilarShows(ctx *gin.Context) {
+	tmdbId, _ := strconv.Atoi(ctx.Params.ByName("tmdbId"))
+	renderShows(tmdb.SimilarShows(tmdbId, config.Get().Language), ctx)
+}
+
 func ShowSeasons(ctx *gin.Context) {
 	show, err := tvdb.NewShowCached(ctx.Params.ByName("showId"), config.Get().Language)
 	if err != nil {
@@ -104,6 +138,8 @@ func ShowSeasons(ctx *gin.Context) {
 	// xbmc.ListItems always returns false to Less() so that order is unchanged
 
 	ctx.JSON(200, xbmc.NewView("seasons", reversedItems))
+
+	go prefetchSeasons(show)
 }
 
 func ShowEpisodes(ctx *gin.Context) {
@@ -123,20 +159,21 @@ func ShowEpisodes(ctx *gin.Context) {
 			season.Season,
 			item.Info.Episode,
 		)
-		item.ContextMenu = [][]string{
-			[]string{"Choose stream...", fmt.Sprintf("XBMC.PlayMedia(%s)", UrlForXBMC("/show/%d/season/%d/episode/%d/links",
-				show.Id,
-				season.Season,
-				item.Info.Episode,
-			))},
-		}
+		item.ContextMenu = contextMenu(&menuTarget{
+			Kind:    menuEpisode,
+			ShowId:  show.Id,
+			Season:  season.Season,
+			Episode: item.Info.Episode,
+		})
 		item.IsPlayable = true
 	}
 
 	ctx.JSON(200, xbmc.NewView("episodes", items))
 }
 
-func showEpisodeLinks(showId string, seasonNumber, episodeNumber int) ([]*bittorrent.Torrent, error) {
+// showEpisodeLinks searches the links of the episode, the cached results
+// being dropped first when refreshing.
+func showEpisodeLinks(ctx context.Context, showId string, seasonNumber, episodeNumber int, refresh bool) ([]*bittorrent.Torrent, error) {
 	log.Println("Searching links for TVDB Id:", showId)
 
 	show, err := tvdb.NewShowCached(showId, config.Get().Language)
@@ -147,19 +184,29 @@ func showEpisodeLinks(showId string, seasonNumber, episodeNumber int) ([]*bittor
 	episode := show.Seasons[seasonNumber].Episodes[episodeNumber-1]
 
 	log.Printf("Resolved %s to %s\n", showId, show.SeriesName)
+	if refresh {
+		providers.ForgetEpisode(show, episode)
+	}
 
 	searchers := providers.GetEpisodeSearchers()
 	if len(searchers) == 0 {
 		xbmc.Notify("Pulsar", "Unable to find any providers", config.AddonIcon())
 	}
 
-	return providers.SearchEpisode(searchers, show, episode), nil
+	torrents := providers.SearchEpisode(ctx, searchers, show, episode)
+	torrents = withoutFailed(episodeTitleKey(showId, seasonNumber, episodeNumber), torrents)
+	analytics.RecordSearch("episode", len(torrents))
+	return torrents, nil
 }
 
 func ShowEpisodeLinks(ctx *gin.Context) {
+	if isDryRun(ctx) {
+		episodeDryRun(ctx, false)
+		return
+	}
 	seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
 	episodeNumber, _ := strconv.Atoi(ctx.Params.ByName("episode"))
-	torrents, err := showEpisodeLinks(ctx.Params.ByName("showId"), seasonNumber, episodeNumber)
+	torrents, err := showEpisodeLinks(ctx.Request.Context(), ctx.Params.ByName("showId"), seasonNumber, episodeNumber, isRefresh(ctx))
 	if err != nil {
 		ctx.Error(err)
 		return
@@ -170,37 +217,97 @@ func ShowEpisodeLinks(ctx *gin.Context) {
 		return
 	}
 
-	choices := make([]string, 0, len(torrents))
-	for _, torrent := range torrents {
-		label := fmt.Sprintf("S:%d P:%d - %s",
-			torrent.Seeds,
-			torrent.Peers,
-			torrent.Name,
+	groups := groupByRelease(torrents)
+	choices := make([]string, 0, len(groups))
+	for _, group := range groups {
+		torrent := group.best
+		label := fmt.Sprintf("%s - %s",
+			swarmLabel(torrent),
+			resultLabel(torrent),
 		)
-		choices = append(choices, label)
+		choices = append(choices, group.label(label))
 	}
 
 	choice := xbmc.ListDialog("Choose stream", choices...)
 	if choice >= 0 {
-		rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[choice].Magnet())
+		torrent := groups[choice].best
+		analytics.RecordChoice("episode", bittorrent.Resolutions[torrent.Resolution])
+		rUrl := UrlQuery(UrlForXBMC("/play"),
+			"uri", torrent.Magnet(),
+			"tvdb_id", ctx.Params.ByName("showId"),
+			"season", ctx.Params.ByName("season"),
+			"episode", ctx.Params.ByName("episode"))
 		ctx.Redirect(302, rUrl)
 	}
 }
 
-func ShowEpisodePlay(ctx *gin.Context) {
-	seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
-	episodeNumber, _ := strconv.Atoi(ctx.Params.ByName("episode"))
-	torrents, err := showEpisodeLinks(ctx.Params.ByName("showId"), seasonNumber, episodeNumber)
+func ShowEpisodePlay(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if isDryRun(ctx) {
+			episodeDryRun(ctx, true)
+			return
+		}
+		seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
+		episodeNumber, _ := strconv.Atoi(ctx.Params.ByName("episode"))
+		if entry := playableHistoryTorrent(btService, episodeTitleKey(ctx.Params.ByName("showId"), seasonNumber, episodeNumber)); entry != nil {
+			log.Printf("Playing %s again from the torrent of the last time\n", entry.Title)
+			ctx.Redirect(302, historyPlayURL(entry, true))
+			return
+		}
+		torrents, err := showEpisodeLinks(ctx.Request.Context(), ctx.Params.ByName("showId"), seasonNumber, episodeNumber, isRefresh(ctx))
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		if len(torrents) == 0 {
+			go showFailure(noLinksFailure(ctx.Request.URL.Path))
+			return
+		}
+
+		analytics.RecordChoice("episode", bittorrent.Resolutions[torrents[0].Resolution])
+		rUrl := UrlQuery(UrlForXBMC("/play"),
+			"uri", torrents[0].Magnet(),
+			"tvdb_id", ctx.Params.ByName("showId"),
+			"season", ctx.Params.ByName("season"),
+			"episode", ctx.Params.ByName("episode"))
+		ctx.Redirect(302, rUrl)
+	}
+}
+
+// ShowEpisodeDownload queues the best link of the episode as a background
+// download.
+func ShowEpisodeDownload(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		showId := ctx.Params.ByName("showId")
+		seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
+		episodeNumber, _ := strconv.Atoi(ctx.Params.ByName("episode"))
+		go func() {
+			torrents, err := showEpisodeLinks(context.Background(), showId, seasonNumber, episodeNumber, false)
+			if err != nil {
+				xbmc.Notify("Pulsar", "Unable to get the episode", config.AddonIcon())
+				return
+			}
+			downloadBest(btService, fmt.Sprintf("S%02dE%02d", seasonNumber, episodeNumber), torrents)
+		}()
+	}
+}
+
+// Searches each provider separately and tells how many links they returned.
+func ShowEpisodeDebug(ctx *gin.Context) {
+	show, err := tvdb.NewShowCached(ctx.Params.ByName("showId"), config.Get().Language)
 	if err != nil {
 		ctx.Error(err)
 		return
 	}
+	seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
+	episodeNumber, _ := strconv.Atoi(ctx.Params.ByName("episode"))
+	episode := show.Seasons[seasonNumber].Episodes[episodeNumber-1]
 
-	if len(torrents) == 0 {
-		xbmc.Notify("Pulsar", "No links were found", config.AddonIcon())
-		return
+	lines := make([]string, 0)
+	for _, searcher := range providers.GetEpisodeSearchers() {
+		torrents := searcher.SearchEpisodeLinks(show, episode)
+		lines = append(lines, fmt.Sprintf("%s: %d links", searcher, len(torrents)))
 	}
-
-	rUrl := UrlQuery(UrlForXBMC("/play"), "uri", torrents[0].Magnet())
-	ctx.Redirect(302, rUrl)
+	xbmc.ListDialog(fmt.Sprintf("Providers for %s S%02dE%02d", show.SeriesName, seasonNumber, episodeNumber), lines...)
 }
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"github.com/dustin/go-humanize"
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// Storage tells how much the kept streams take in the download path.
+func Storage(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.JSON(200, btService.StorageUsage())
+	}
+}
+
+// ClearStreamsCache is the settings action deleting the kept streams, but
+// those being played or seeded.
+func ClearStreamsCache(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		usage := btService.StorageUsage()
+		if len(usage.Torrents) == 0 {
+			xbmc.Notify("Pulsar", "The streams cache is empty", config.AddonIcon())
+			return
+		}
+		names := make([]string, 0, len(usage.Torrents))
+		for _, torrent := range usage.Torrents {
+			names = append(names, torrent.Name)
+		}
+		if confirmDestructive("Clear the streams cache ("+humanize.Bytes(uint64(usage.Used))+")", names...) == false {
+			return
+		}
+		scheduleUndoable("Clearing the streams cache", func() {
+			freed := btService.ClearKept()
+			xbmc.Notify("Pulsar", "Freed "+humanize.Bytes(uint64(freed)), config.AddonIcon())
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+)
+
+// streamResults writes each provider's results as one JSON line as soon as
+// it answers. The run goes with the request: the stragglers are given up on
+// when the client goes away.
+func streamResults(ctx *gin.Context, run *providers.SearchRun) {
+	defer run.Cancel()
+
+	ctx.Writer.Header().Set("Content-Type", "application/x-ndjson")
+	ctx.Writer.WriteHeader(200)
+	encoder := json.NewEncoder(ctx.Writer)
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case partial, ok := <-run.Results:
+			if ok == false {
+				return
+			}
+			if err := encoder.Encode(partial); err != nil {
+				return
+			}
+			if flusher, ok := ctx.Writer.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func MovieLinksStream(ctx *gin.Context) {
+	movie := tmdb.GetMovieFromIMDB(ctx.Params.ByName("imdbId"), config.Get().Language)
+	if movie == nil {
+		ctx.AbortWithStatus(404)
+		return
+	}
+	streamResults(ctx, providers.StreamMovie(ctx.Request.Context(), providers.GetMovieSearchers(), movie))
+}
+
+func ShowEpisodeLinksStream(ctx *gin.Context) {
+	seasonNumber, _ := strconv.Atoi(ctx.Params.ByName("season"))
+	episodeNumber, _ := strconv.Atoi(ctx.Params.ByName("episode"))
+	show, err := tvdb.NewShowCached(ctx.Params.ByName("showId"), config.Get().Language)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	if seasonNumber < 0 || seasonNumber >= len(show.Seasons) || episodeNumber < 1 || episodeNumber > len(show.Seasons[seasonNumber].Episodes) {
+		ctx.AbortWithStatus(404)
+		return
+	}
+	episode := show.Seasons[seasonNumber].Episodes[episodeNumber-1]
+	streamResults(ctx, providers.StreamEpisode(ctx.Request.Context(), providers.GetEpisodeSearchers(), show, episode))
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/lifecycle"
+)
+
+// Subsystems lists the parts of the daemon that can be stopped on their
+// own, and whether they're running.
+func Subsystems(ctx *gin.Context) {
+	ctx.JSON(200, lifecycle.Subsystems())
+}
+
+func SubsystemStart(ctx *gin.Context) {
+	switchSubsystem(ctx, lifecycle.StartSubsystem)
+}
+
+func SubsystemStop(ctx *gin.Context) {
+	switchSubsystem(ctx, lifecycle.StopSubsystem)
+}
+
+func switchSubsystem(ctx *gin.Context, fn func(name string) error) {
+	name := ctx.Params.ByName("name")
+	err := fn(name)
+	if err == lifecycle.ErrUnknownSubsystem {
+		ctx.JSON(404, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		ctx.JSON(409, gin.H{"error": err.Error()})
+		return
+	}
+	for _, subsystem := range lifecycle.Subsystems() {
+		if subsystem.Name == name {
+			ctx.JSON(200, subsystem)
+			return
+		}
+	}
+}
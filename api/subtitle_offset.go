@@ -0,0 +1,241 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/osdb"
+	"github.com/steeve/pulsar/store"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// Out of sync subtitles are shifted by an offset kept for the video file,
+// and applied again whenever a subtitle is loaded for it. The shifted
+// subtitle is written next to the downloaded one, which is kept as is.
+
+const subtitleOffsetsBucket = "subtitle_offsets"
+
+var (
+	errNoSubtitle  = errors.New("no subtitle was loaded for the stream")
+	errNotSubRip   = errors.New("only SubRip subtitles can be shifted")
+	errNoReference = errors.New("no subtitle known to be in sync to compare with")
+)
+
+type subtitleOffsetBody struct {
+	Offset int64 `json:"offset"` // in milliseconds, positive delaying the subtitles
+}
+
+func subtitleOffsetKey(videoPath string) string {
+	sum := sha1.Sum([]byte(videoPath))
+	return hex.EncodeToString(sum[:])
+}
+
+func subtitleOffset(videoPath string) time.Duration {
+	var offset int64
+	if err := store.Global(subtitleOffsetsBucket).Get(subtitleOffsetKey(videoPath), &offset); err != nil {
+		return 0
+	}
+	return time.Duration(offset) * time.Millisecond
+}
+
+func saveSubtitleOffset(videoPath string, offset time.Duration) error {
+	bucket := store.Global(subtitleOffsetsBucket)
+	if offset == 0 {
+		bucket.Delete(subtitleOffsetKey(videoPath))
+		return nil
+	}
+	return bucket.Set(subtitleOffsetKey(videoPath), int64(offset/time.Millisecond), store.FOREVER)
+}
+
+func loadedSubtitle(stream *subtitleStream) (*osdb.Subtitle, string) {
+	streamingLock.Lock()
+	defer streamingLock.Unlock()
+	return stream.subtitle, stream.original
+}
+
+func readSubRip(path string) (osdb.Cues, error) {
+	if strings.EqualFold(filepath.Ext(path), ".srt") == false {
+		return nil, errNotSubRip
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return osdb.ParseSRT(file)
+}
+
+// applySubtitleOffset loads the subtitle of the stream shifted by offset in
+// the player.
+func applySubtitleOffset(stream *subtitleStream, offset time.Duration) error {
+	_, original := loadedSubtitle(stream)
+	if original == "" {
+		return errNoSubtitle
+	}
+	if offset == 0 {
+		return playerSetSubtitles(original)
+	}
+	cues, err := readSubRip(original)
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(original)
+	shiftedPath := strings.TrimSuffix(original, ext) + ".synced" + ext
+	file, err := os.Create(shiftedPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := cues.Shift(offset).WriteSRT(file); err != nil {
+		return err
+	}
+	log.Printf("Loading %s shifted by %s\n", original, offset)
+	return playerSetSubtitles(shiftedPath)
+}
+
+// detectSubtitleOffset compares the subtitle with one matched by the hash
+// of the video, in any of the subtitle languages, whose timing is right.
+func detectSubtitleOffset(stream *subtitleStream) (time.Duration, error) {
+	sub, original := loadedSubtitle(stream)
+	if original == "" {
+		return 0, errNoSubtitle
+	}
+	cues, err := readSubRip(original)
+	if err != nil {
+		return 0, err
+	}
+	languages := append([]string{sub.LanguageName}, config.Get().SubtitleLanguages...)
+	subs, err := searchStreamSubtitles(stream, languages, "")
+	if err != nil {
+		return 0, err
+	}
+	for i := range subs {
+		reference := &subs[i]
+		if reference.MatchedBy != "moviehash" || reference.IDSubtitleFile == sub.IDSubtitleFile || reference.SubFormat != "srt" {
+			continue
+		}
+		reader, err := osdb.NewSubtitleReader(reference)
+		if err != nil {
+			continue
+		}
+		referenceCues, err := osdb.ParseSRT(reader)
+		if err != nil {
+			continue
+		}
+		if offset, err := cues.DetectOffset(referenceCues); err == nil {
+			log.Printf("%s is off by %s from %s\n", original, offset, reference.SubFileName)
+			return offset, nil
+		}
+	}
+	return 0, errNoReference
+}
+
+func streamingVideo(ctx *gin.Context) (*subtitleStream, string) {
+	stream := currentSubtitleStream()
+	if stream == nil {
+		ctx.AbortWithError(404, errNotStreaming)
+		return nil, ""
+	}
+	path, _ := stream.player.VideoFile()
+	return stream, path
+}
+
+// GetSubtitleOffset returns the offset of the subtitles of the stream being
+// played.
+func GetSubtitleOffset(ctx *gin.Context) {
+	stream, path := streamingVideo(ctx)
+	if stream == nil {
+		return
+	}
+	name := ""
+	if sub, _ := loadedSubtitle(stream); sub != nil {
+		name = sub.SubFileName
+	}
+	ctx.JSON(200, gin.H{
+		"offset":   int64(subtitleOffset(path) / time.Millisecond),
+		"subtitle": name,
+	})
+}
+
+func setStreamSubtitleOffset(ctx *gin.Context, stream *subtitleStream, path string, offset time.Duration) {
+	if err := applySubtitleOffset(stream, offset); err != nil {
+		ctx.AbortWithError(409, err)
+		return
+	}
+	if err := saveSubtitleOffset(path, offset); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, gin.H{"offset": int64(offset / time.Millisecond)})
+}
+
+// SetSubtitleOffset shifts the subtitles of the stream being played, and
+// of the file from then on.
+func SetSubtitleOffset(ctx *gin.Context) {
+	stream, path := streamingVideo(ctx)
+	if stream == nil {
+		return
+	}
+	body := &subtitleOffsetBody{}
+	if err := json.NewDecoder(ctx.Request.Body).Decode(body); err != nil {
+		ctx.AbortWithError(400, err)
+		return
+	}
+	setStreamSubtitleOffset(ctx, stream, path, time.Duration(body.Offset)*time.Millisecond)
+}
+
+func ResetSubtitleOffset(ctx *gin.Context) {
+	stream, path := streamingVideo(ctx)
+	if stream == nil {
+		return
+	}
+	setStreamSubtitleOffset(ctx, stream, path, 0)
+}
+
+// DetectSubtitleOffset finds the offset of the subtitles of the stream
+// being played, and applies it.
+func DetectSubtitleOffset(ctx *gin.Context) {
+	stream, path := streamingVideo(ctx)
+	if stream == nil {
+		return
+	}
+	offset, err := detectSubtitleOffset(stream)
+	if err != nil {
+		ctx.AbortWithError(409, err)
+		return
+	}
+	setStreamSubtitleOffset(ctx, stream, path, offset)
+}
+
+// SyncSubtitles is DetectSubtitleOffset for the menus.
+func SyncSubtitles(ctx *gin.Context) {
+	stream := currentSubtitleStream()
+	if stream == nil {
+		xbmc.Notify("Pulsar", "Nothing is streaming.", config.AddonIcon())
+		return
+	}
+	path, _ := stream.player.VideoFile()
+	offset, err := detectSubtitleOffset(stream)
+	if err == nil {
+		err = applySubtitleOffset(stream, offset)
+	}
+	if err == nil {
+		err = saveSubtitleOffset(path, offset)
+	}
+	if err != nil {
+		xbmc.Notify("Pulsar", "Unable to sync the subtitles.", config.AddonIcon())
+		ctx.AbortWithError(500, err)
+		return
+	}
+	xbmc.Notify("Pulsar", fmt.Sprintf("Subtitles shifted by %s", offset), config.AddonIcon())
+}
@@ -75,6 +75,23 @@ func appendEpisodePayloads(labels map[string]string, payloads *[]osdb.SearchPayl
 	return nil
 }
 
+// OpenSubtitles wants ISO 639-2 codes, with a few exceptions.
+func osdbLanguages(languages []string) []string {
+	converted := make([]string, 0, len(languages))
+	for _, lang := range languages {
+		if lang == "Portuguese (Brazil)" {
+			converted = append(converted, "pob")
+		} else {
+			isoLang := xbmc.ConvertLanguage(lang, xbmc.ISO_639_2)
+			if isoLang == "gre" {
+				isoLang = "ell"
+			}
+			converted = append(converted, isoLang)
+		}
+	}
+	return converted
+}
+
 func SubtitlesIndex(ctx *gin.Context) {
 	q := ctx.Request.URL.Query()
 	searchString := q.Get("searchstring")
@@ -92,21 +109,15 @@ func SubtitlesIndex(ctx *gin.Context) {
 
 	// are we reading a file from Pulsar?
 	if strings.HasPrefix(playingFile, util.GetHTTPHost()) {
+		// without the signature
+		if i := strings.Index(playingFile, "?"); i >= 0 {
+			playingFile = playingFile[:i]
+		}
 		playingFile = strings.Replace(playingFile, util.GetHTTPHost()+"/files", config.Get().DownloadPath, 1)
 		playingFile, _ = url.QueryUnescape(playingFile)
 	}
 
-	for i, lang := range languages {
-		if lang == "Portuguese (Brazil)" {
-			languages[i] = "pob"
-		} else {
-			isoLang := xbmc.ConvertLanguage(lang, xbmc.ISO_639_2)
-			if isoLang == "gre" {
-				isoLang = "ell"
-			}
-			languages[i] = isoLang
-		}
-	}
+	languages = osdbLanguages(languages)
 
 	payloads := []osdb.SearchPayload{}
 	if searchString != "" {
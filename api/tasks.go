@@ -0,0 +1,19 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/scheduler"
+)
+
+// Tasks lists the scheduled tasks with their last and next runs.
+func Tasks(ctx *gin.Context) {
+	ctx.JSON(200, scheduler.Status())
+}
+
+func TaskRun(ctx *gin.Context) {
+	if err := scheduler.RunNow(ctx.Params.ByName("task")); err != nil {
+		ctx.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(200, gin.H{"triggered": ctx.Params.ByName("task")})
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+)
+
+// Searching the providers and adding torrents are expensive, so a
+// misbehaving widget or a scanner is kept from hammering them. Each client
+// gets a bucket of requests per minute, and a cap on its requests running
+// at once, so that one client can't keep XBMC itself out. Zero settings use
+// the defaults, negative ones disable the limits.
+const (
+	defaultAPIRateLimit     = 30 // per client and minute
+	defaultAPIMaxConcurrent = 3
+	throttleSweepInterval   = 1 * time.Minute
+	throttleIdleTime        = 10 * time.Minute
+	// guessing a 4 digits PIN takes days at this rate
+	kioskPinAttempts = 3 // per client and minute
+)
+
+type bucket struct {
+	tokens  float64
+	last    time.Time
+	running int
+}
+
+type throttle struct {
+	name      string
+	limits    func() (int, int) // the rate and the running requests cap
+	lock      sync.Mutex
+	clients   map[string]*bucket
+	lastSweep time.Time
+}
+
+var (
+	searchThrottle   = newThrottle("search", throttleLimits)
+	addThrottle      = newThrottle("add", throttleLimits)
+	kioskPinThrottle = newThrottle("kiosk PIN", func() (int, int) { return kioskPinAttempts, 0 })
+)
+
+func newThrottle(name string, limits func() (int, int)) *throttle {
+	return &throttle{
+		name:    name,
+		limits:  limits,
+		clients: make(map[string]*bucket),
+	}
+}
+
+func throttleLimits() (int, int) {
+	conf := config.Get()
+	rate, concurrent := conf.APIRateLimit, conf.APIMaxConcurrent
+	if rate == 0 {
+		rate = defaultAPIRateLimit
+	}
+	if concurrent == 0 {
+		concurrent = defaultAPIMaxConcurrent
+	}
+	return rate, concurrent
+}
+
+func clientOf(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// sweep forgets the clients that have been quiet for a while.
+func (t *throttle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < throttleSweepInterval {
+		return
+	}
+	t.lastSweep = now
+	for client, b := range t.clients {
+		if b.running == 0 && now.Sub(b.last) > throttleIdleTime {
+			delete(t.clients, client)
+		}
+	}
+}
+
+// enter takes a request from the client's bucket and a running slot, or
+// tells how many seconds to wait.
+func (t *throttle) enter(client string) (int, bool) {
+	rate, concurrent := t.limits()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	t.sweep(now)
+
+	b, ok := t.clients[client]
+	if ok == false {
+		b = &bucket{tokens: float64(rate), last: now}
+		t.clients[client] = b
+	}
+	if concurrent > 0 && b.running >= concurrent {
+		return 1, false
+	}
+	if rate > 0 {
+		perSecond := float64(rate) / 60
+		b.tokens = math.Min(float64(rate), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+		b.last = now
+		if b.tokens < 1 {
+			return int(math.Ceil((1 - b.tokens) / perSecond)), false
+		}
+		b.tokens--
+	}
+	b.running++
+	return 0, true
+}
+
+func (t *throttle) leave(client string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if b, ok := t.clients[client]; ok {
+		b.running--
+	}
+}
+
+// Throttle answers 429 with a Retry-After once the client goes over its
+// rate, or too many of its requests are running.
+func (t *throttle) Throttle() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		client := clientOf(ctx.Request)
+		wait, ok := t.enter(client)
+		if ok == false {
+			log.Printf("Throttled %s on %s, retry in %ds\n", client, ctx.Request.URL.Path, wait)
+			ctx.Writer.Header().Set("Retry-After", strconv.Itoa(wait))
+			ctx.AbortWithStatus(429)
+			return
+		}
+		defer t.leave(client)
+		ctx.Next()
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/together"
+)
+
+const (
+	togetherPollTimeout = 30 * time.Second
+)
+
+func TogetherJoin(ctx *gin.Context) {
+	room := together.GetRoom(ctx.Params.ByName("room"))
+	ctx.JSON(200, room.Join(ctx.Request.URL.Query().Get("client")))
+}
+
+func TogetherLeave(ctx *gin.Context) {
+	room := together.GetRoom(ctx.Params.ByName("room"))
+	room.Leave(ctx.Request.URL.Query().Get("client"))
+	ctx.String(200, "")
+}
+
+func TogetherState(ctx *gin.Context) {
+	ctx.JSON(200, together.GetRoom(ctx.Params.ByName("room")).State())
+}
+
+// Clients report their play, pause and seek actions here.
+func TogetherPublish(ctx *gin.Context) {
+	query := ctx.Request.URL.Query()
+	position, _ := strconv.ParseFloat(query.Get("position"), 64)
+	event := &together.Event{
+		Type:     ctx.Params.ByName("event"),
+		Client:   query.Get("client"),
+		Position: position,
+		URL:      query.Get("url"),
+	}
+	room := together.GetRoom(ctx.Params.ByName("room"))
+	if err := room.Publish(event); err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(200, event)
+}
+
+// Long polls the events of the other clients after the since sequence.
+func TogetherEvents(ctx *gin.Context) {
+	query := ctx.Request.URL.Query()
+	since, _ := strconv.Atoi(query.Get("since"))
+	room := together.GetRoom(ctx.Params.ByName("room"))
+	ctx.JSON(200, room.Events(query.Get("client"), since, togetherPollTimeout))
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+var removeChoices = []string{
+	"Delete all the files",
+	"Delete the incomplete files",
+	"Delete the folder, once empty",
+	"Delete the subtitles and NFOs",
+}
+
+// removeOptionsDialog asks what to delete along with the torrent, nothing
+// ticked keeping its files. It's false when the user cancelled.
+func removeOptionsDialog(name string) (bittorrent.RemoveOptions, bool) {
+	options := bittorrent.RemoveOptions{}
+	choices, err := xbmc.MultiSelectDialog("Remove "+name, removeChoices...)
+	if err != nil {
+		// the plugin doesn't have the dialog, all or nothing then
+		switch xbmc.ListDialog("Remove "+name, "Remove", "Remove and delete files") {
+		case 0:
+			return options, true
+		case 1:
+			options.DeleteFiles = true
+			return options, true
+		}
+		return options, false
+	}
+	if choices == nil {
+		return options, false
+	}
+	for _, choice := range choices {
+		switch choice {
+		case 0:
+			options.DeleteFiles = true
+		case 1:
+			options.DeleteIncomplete = true
+		case 2:
+			options.DeleteFolder = true
+		case 3:
+			options.DeleteExtras = true
+		}
+	}
+	return options, true
+}
+
+func TorrentMode(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mode, err := btService.TorrentMode(ctx.Params.ByName("infoHash"))
+		if err != nil {
+			ctx.AbortWithStatus(404)
+			return
+		}
+		ctx.JSON(200, gin.H{"mode": mode})
+	}
+}
+
+// SetTorrentMode switches a torrent between streaming and archive modes.
+func SetTorrentMode(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		mode := ctx.Params.ByName("mode")
+		if err := btService.SetTorrentMode(ctx.Params.ByName("infoHash"), mode); err != nil {
+			ctx.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(200, gin.H{"mode": mode})
+	}
+}
+
+// TorrentDelete removes a torrent along with its downloaded files.
+func TorrentDelete(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		infoHash := ctx.Params.ByName("infoHash")
+		name, files, err := btService.TorrentFiles(infoHash)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		if confirmDestructive("Delete "+name+" and its files", files...) == false {
+			return
+		}
+		scheduleUndoable("Deleting "+name, func() {
+			btService.RemoveTorrent(infoHash, true)
+		})
+	}
+}
+
+// TorrentRemove asks what to delete along with a torrent, then removes it.
+func TorrentRemove(btService *bittorrent.BTService) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		infoHash := ctx.Params.ByName("infoHash")
+		name, _, err := btService.TorrentFiles(infoHash)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		options, ok := removeOptionsDialog(name)
+		if ok == false {
+			return
+		}
+		files, _ := btService.RemovalFiles(infoHash, options)
+		if len(files) > 0 && confirmDestructive("Delete the files of "+name, files...) == false {
+			return
+		}
+		scheduleUndoable("Removing "+name, func() {
+			btService.RemoveTorrentWith(infoHash, options)
+		})
+	}
+}
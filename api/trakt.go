@@ -0,0 +1,176 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/events"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/trakt"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+var traktLists = []struct {
+	list  string
+	label string
+}{
+	{"watchlist", "Watchlist"},
+	{"collection", "Collection"},
+	{"recommendations", "Recommended"},
+}
+
+func TraktIndex(ctx *gin.Context) {
+	items := xbmc.ListItems{}
+	if trakt.Authorized() {
+		for _, list := range traktLists {
+			items = append(items, &xbmc.ListItem{
+				Label:     "Movies: " + list.label,
+				Path:      UrlForXBMC("/trakt/movies/%s", list.list),
+				Thumbnail: config.AddonResource("img", "movies.png"),
+			})
+		}
+		for _, list := range traktLists {
+			items = append(items, &xbmc.ListItem{
+				Label:     "TV Shows: " + list.label,
+				Path:      UrlForXBMC("/trakt/shows/%s", list.list),
+				Thumbnail: config.AddonResource("img", "tv.png"),
+			})
+		}
+		if IsKiosk() == false {
+			items = append(items, &xbmc.ListItem{Label: "Log out of Trakt", Path: UrlForXBMC("/trakt/deauthorize")})
+		}
+	} else if IsKiosk() == false {
+		items = append(items, &xbmc.ListItem{Label: "Log in to Trakt", Path: UrlForXBMC("/trakt/authorize")})
+	}
+	ctx.JSON(200, xbmc.NewView("", items))
+}
+
+// TraktAuthorize runs the device code flow, showing the code to enter until
+// the user does it or cancels.
+func TraktAuthorize(ctx *gin.Context) {
+	code, err := trakt.GetDeviceCode()
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	dialog := xbmc.NewDialogProgress("Trakt", "Go to "+code.VerificationURL, "and enter the code "+code.UserCode, "")
+	if dialog == nil {
+		ctx.String(200, "")
+		return
+	}
+
+	cancel := make(chan struct{})
+	// canceled by the user, or done polling
+	var cancelOnce sync.Once
+	stop := func() {
+		cancelOnce.Do(func() { close(cancel) })
+	}
+	go func() {
+		started := time.Now()
+		expires := time.Duration(code.ExpiresIn) * time.Second
+		for {
+			select {
+			case <-cancel:
+				return
+			case <-time.After(time.Second):
+			}
+			if dialog.IsCanceled() {
+				stop()
+				return
+			}
+			left := 100 - int(100*time.Since(started)/expires)
+			dialog.Update(left, "Go to "+code.VerificationURL, "and enter the code "+code.UserCode, "")
+		}
+	}()
+
+	_, err = trakt.PollToken(code, cancel)
+	stop()
+	dialog.Close()
+	if err != nil {
+		xbmc.Notify("Pulsar", "Trakt authorization failed", config.AddonIcon())
+	} else {
+		xbmc.Notify("Pulsar", "Logged in to Trakt", config.AddonIcon())
+	}
+	ctx.String(200, "")
+}
+
+func TraktDeauthorize(ctx *gin.Context) {
+	if err := trakt.Deauthorize(); err != nil {
+		ctx.Error(err)
+		return
+	}
+	xbmc.Notify("Pulsar", "Logged out of Trakt", config.AddonIcon())
+	ctx.String(200, "")
+}
+
+func traktListIds(kind string, list string) ([]int, error) {
+	switch list {
+	case "watchlist":
+		return trakt.Watchlist(kind)
+	case "collection":
+		return trakt.Collection(kind)
+	case "recommendations":
+		return trakt.Recommendations(kind)
+	}
+	return nil, fmt.Errorf("unknown trakt list %s", list)
+}
+
+func TraktMovies(ctx *gin.Context) {
+	ids, err := traktListIds(trakt.Movies, ctx.Params.ByName("list"))
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	movies := tmdb.GetMovies(ids, config.Get().Language)
+	ctx.JSON(200, xbmc.NewView("movies", movieListItems(movies)))
+}
+
+func TraktShows(ctx *gin.Context) {
+	ids, err := traktListIds(trakt.Shows, ctx.Params.ByName("list"))
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	shows := tmdb.GetShows(ids, config.Get().Language)
+	ctx.JSON(200, xbmc.NewView("tvshows", showListItems(shows)))
+}
+
+func progressPercent(position, duration time.Duration) float64 {
+	if duration == 0 {
+		return 0
+	}
+	return 100 * float64(position) / float64(duration)
+}
+
+// scrobble tells Trakt what's being watched through the player, and how
+// far it got.
+func scrobble(event *events.Event) {
+	if config.Get().TraktScrobble == false || trakt.Authorized() == false {
+		return
+	}
+	playback := event.Data.(*events.Playback)
+	action := trakt.ScrobbleStart
+	switch event.Topic {
+	case events.PlaybackPaused:
+		action = trakt.ScrobblePause
+	case events.PlaybackStopped:
+		action = trakt.ScrobbleStop
+	}
+	progress := progressPercent(playback.Position, playback.Duration)
+	var err error
+	switch {
+	case playback.IMDBId != "":
+		err = trakt.ScrobbleMovie(action, playback.IMDBId, progress)
+	case playback.TVDBId != 0:
+		err = trakt.ScrobbleEpisode(action, playback.TVDBId, playback.Season, playback.Episode, progress)
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to scrobble %s to Trakt: %s\n", action, err)
+	}
+}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/undo"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// Asks the user before a destructive action, listing what will go away.
+func confirmDestructive(action string, what ...string) bool {
+	items := append([]string{action, "Cancel"}, what...)
+	return xbmc.ListDialog(action+"?", items...) == 0
+}
+
+// Schedules the action and tells the user how to undo it.
+func scheduleUndoable(description string, commit func()) *undo.Action {
+	action := undo.Schedule(description, commit)
+	xbmc.Notify("Pulsar", fmt.Sprintf("%s in %ds, undo from the Pulsar main menu", description, int(undo.GracePeriod.Seconds())), config.AddonIcon())
+	return action
+}
+
+func UndoList(ctx *gin.Context) {
+	ctx.JSON(200, undo.Pending())
+}
+
+func UndoAction(ctx *gin.Context) {
+	if undo.Undo(ctx.Params.ByName("action")) == false {
+		ctx.AbortWithStatus(404)
+		return
+	}
+	ctx.String(200, "")
+}
+
+// For the XBMC menus, undoes the given action, or the last one.
+func UndoCmd(ctx *gin.Context) {
+	var action *undo.Action
+	if id := ctx.Params.ByName("action"); id != "" {
+		for _, pendingAction := range undo.Pending() {
+			if pendingAction.Id == id && undo.Undo(id) {
+				action = pendingAction
+			}
+		}
+	} else {
+		action = undo.UndoLast()
+	}
+	if action == nil {
+		xbmc.Notify("Pulsar", "Nothing to undo", config.AddonIcon())
+		return
+	}
+	xbmc.Notify("Pulsar", "Cancelled "+action.Description, config.AddonIcon())
+}
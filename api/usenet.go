@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/usenet"
+	"github.com/steeve/pulsar/util"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+var usenetStates = map[string]string{
+	usenet.StateQueued:      "Queued",
+	usenet.StateDownloading: "Downloading",
+	usenet.StateProcessing:  "Verifying and unpacking",
+}
+
+// playNZB sends the release to the usenet client and waits for it to be
+// downloaded, the files being served once it is: they're unpacked only
+// then, so there's nothing to stream before.
+func playNZB(ctx *gin.Context, torrent *bittorrent.Torrent) {
+	client, err := usenet.NewClient()
+	if err != nil {
+		xbmc.Notify("Pulsar", "Set up SABnzbd or NZBGet to play NZBs", config.AddonIcon())
+		return
+	}
+	id, err := usenet.Start(client, torrent.URI, torrent.Name)
+	if err != nil {
+		log.Printf("Unable to send %s to %s: %s", torrent.Name, client.Name(), err)
+		xbmc.Notify("Pulsar", "Unable to send the NZB to "+client.Name(), config.AddonIcon())
+		return
+	}
+
+	dialog := xbmc.NewDialogProgress(client.Name(), torrent.Name, "Queued", "")
+	job, err := usenet.Wait(client, id, func(job *usenet.Job) bool {
+		if dialog == nil {
+			return true
+		}
+		if dialog.IsCanceled() {
+			return false
+		}
+		dialog.Update(int(job.Progress), torrent.Name, usenetStates[job.State], "")
+		return true
+	})
+	if dialog != nil {
+		dialog.Close()
+	}
+	if err == usenet.ErrCanceled {
+		// the client keeps downloading, playing again picks it up
+		return
+	}
+	if err != nil {
+		log.Printf("Download of %s failed: %s", torrent.Name, err)
+		xbmc.Notify("Pulsar", "Download failed: "+err.Error(), config.AddonIcon())
+		return
+	}
+
+	file, err := usenet.VideoFile(job.Path)
+	if err != nil {
+		log.Printf("No video in %s: %s", job.Path, err)
+		xbmc.Notify("Pulsar", "No video in the download", config.AddonIcon())
+		return
+	}
+	usenet.Serve(id, file)
+	rUrl := fmt.Sprintf("%s/usenet/%s/%s", util.ExternalURL(ctx.Request), id, url.QueryEscape(filepath.Base(file)))
+	ctx.Redirect(302, util.StreamURL(ctx.Request, rUrl))
+}
+
+// UsenetFile serves the video of a completed usenet download, the name
+// being there for Kodi to guess what it is.
+func UsenetFile(ctx *gin.Context) {
+	file, err := usenet.File(ctx.Params.ByName("id"))
+	if err != nil {
+		ctx.AbortWithError(404, err)
+		return
+	}
+	http.ServeFile(ctx.Writer, ctx.Request, file)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/library"
+)
+
+const vacationDay = "2006-01-02"
+
+// parseVacationTime takes dates, the whole day being included when it's
+// the end, or RFC 3339 times.
+func parseVacationTime(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation(vacationDay, value, time.Local)
+	if err != nil {
+		return day, fmt.Errorf("%s is neither a date (%s) nor an RFC 3339 time", value, vacationDay)
+	}
+	if end {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
+
+func vacationStatus(vacation *library.Vacation) gin.H {
+	return gin.H{
+		"vacation": vacation,
+		"active":   vacation.Active(),
+	}
+}
+
+func GetVacation(ctx *gin.Context) {
+	ctx.JSON(200, vacationStatus(library.GetVacation()))
+}
+
+// SetVacation freezes the library from from, now if not given, until until,
+// as in ?from=2015-07-01&until=2015-07-15.
+func SetVacation(ctx *gin.Context) {
+	q := ctx.Request.URL.Query()
+	from := time.Now()
+	var err error
+	if q.Get("from") != "" {
+		if from, err = parseVacationTime(q.Get("from"), false); err != nil {
+			ctx.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	until, err := parseVacationTime(q.Get("until"), true)
+	if err != nil {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	vacation, err := library.SetVacation(from, until)
+	if err == library.ErrBadVacation {
+		ctx.JSON(400, gin.H{"error": err.Error()})
+		return
+	} else if err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, vacationStatus(vacation))
+}
+
+// EndVacation ends the vacation now, or cancels the next one.
+func EndVacation(ctx *gin.Context) {
+	if err := library.EndVacation(); err != nil {
+		ctx.AbortWithError(500, err)
+		return
+	}
+	ctx.JSON(200, vacationStatus(library.GetVacation()))
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/events"
+	"github.com/steeve/pulsar/history"
+	"github.com/steeve/pulsar/scheduler"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// past this, the item is considered watched
+const watchedPercent = 0.9
+
+// Trakt takes a moment to count a scrobbled play, the sync after playback
+// waits for it so as not to add the play a second time.
+const watchedSyncDelay = 2 * time.Minute
+
+// markWatched marks the library .strm item played as watched once playback
+// went far enough, so that library views don't need a Trakt sync to be
+// right.
+func markWatched(event *events.Event) {
+	playback := event.Data.(*events.Playback)
+	if playback.Progress() < watchedPercent {
+		return
+	}
+	if playback.IMDBId != "" {
+		if movie := xbmc.LibraryMovie(playback.IMDBId); movie != nil {
+			log.Printf("Marking %s as watched in the library\n", movie.Label)
+			xbmc.SetMovieWatched(movie)
+		}
+		return
+	}
+	if playback.TVDBId == 0 {
+		return
+	}
+	if libraryEpisode := xbmc.LibraryEpisode(playback.TVDBId, playback.Season, playback.Episode); libraryEpisode != nil {
+		log.Printf("Marking %s as watched in the library\n", libraryEpisode.Label)
+		xbmc.SetEpisodeWatched(libraryEpisode)
+	}
+}
+
+// markItemWatched marks the history entry of the key and the library item, if
+// any, as watched. The watched sync takes it to Trakt.
+func markItemWatched(key string, markLibrary func()) {
+	if err := history.SetWatched(key, true); err != nil && err != history.ErrNoEntry {
+		log.Printf("Unable to mark %s as watched: %s\n", key, err)
+	}
+	markLibrary()
+	if config.Get().WatchedSync {
+		scheduler.RunNow("watched_sync")
+	}
+	xbmc.Notify("Pulsar", "Marked as watched", config.AddonIcon())
+}
+
+func MovieMarkWatched(ctx *gin.Context) {
+	imdbId := ctx.Params.ByName("imdbId")
+	markItemWatched(movieTitleKey(imdbId), func() {
+		if movie := xbmc.LibraryMovie(imdbId); movie != nil {
+			xbmc.SetMovieWatched(movie)
+		}
+	})
+	ctx.String(200, "")
+}
+
+func ShowEpisodeMarkWatched(ctx *gin.Context) {
+	showId := ctx.Params.ByName("showId")
+	tvdbId, _ := strconv.Atoi(showId)
+	season, _ := strconv.Atoi(ctx.Params.ByName("season"))
+	episode, _ := strconv.Atoi(ctx.Params.ByName("episode"))
+	markItemWatched(episodeTitleKey(showId, season, episode), func() {
+		if libraryEpisode := xbmc.LibraryEpisode(tvdbId, season, episode); libraryEpisode != nil {
+			xbmc.SetEpisodeWatched(libraryEpisode)
+		}
+	})
+	ctx.String(200, "")
+}
+
+// syncWatched syncs the watched flags a while after playback, see the
+// watched package.
+func syncWatched(event *events.Event) {
+	if config.Get().WatchedSync == false {
+		return
+	}
+	time.AfterFunc(watchedSyncDelay, func() {
+		scheduler.RunNow("watched_sync")
+	})
+}
+
+// WatchedSync syncs the watched flags of the library, the history and Trakt
+// in the background, as the scheduled task does.
+func WatchedSync(ctx *gin.Context) {
+	if config.Get().WatchedSync == false {
+		ctx.JSON(409, gin.H{"error": "watched_sync is off"})
+		return
+	}
+	if err := scheduler.RunNow("watched_sync"); err != nil {
+		ctx.Error(err)
+		return
+	}
+	ctx.JSON(202, gin.H{"triggered": "watched_sync"})
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/localsearch"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+	"github.com/steeve/pulsar/watchlist"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+func MoviesWatchlist(ctx *gin.Context) {
+	movies := tmdb.GetMovies(watchlist.Ids(watchlist.Movies), config.Get().Language)
+	ctx.JSON(200, xbmc.NewView("movies", movieListItems(movies)))
+}
+
+func ShowsWatchlist(ctx *gin.Context) {
+	shows := tmdb.GetShows(watchlist.Ids(watchlist.Shows), config.Get().Language)
+	ctx.JSON(200, xbmc.NewView("tvshows", showListItems(shows)))
+}
+
+// watchlistSet is the TMDB ids of the watchlist, to tell the listed items
+// that are in it without loading it for each.
+func watchlistSet(kind string) map[int]bool {
+	set := make(map[int]bool)
+	for _, tmdbId := range watchlist.Ids(kind) {
+		set[tmdbId] = true
+	}
+	return set
+}
+
+// PrefetchWatchlist renders the seasons of the watchlist shows ahead, like
+// when they're opened. The new episodes of those shows are looked for along
+// with those of the library, see library.DownloadNewEpisodes.
+func PrefetchWatchlist() error {
+	language := config.Get().Language
+	for _, show := range tmdb.GetShows(watchlist.Ids(watchlist.Shows), language) {
+		prefetchWatchlistShow(show, language)
+	}
+	return nil
+}
+
+func prefetchWatchlistShow(show *tmdb.Show, language string) {
+	if show == nil || show.ExternalIDs == nil || show.ExternalIDs.TVDBID == 0 {
+		return
+	}
+	tvdbShow, err := tvdb.NewShowCached(strconv.Itoa(show.ExternalIDs.TVDBID), language)
+	if err != nil {
+		log.Printf("Unable to prefetch %s: %s\n", show.Name, err)
+		return
+	}
+	prefetchSeasons(tvdbShow)
+}
+
+func watchlistKind(ctx *gin.Context) string {
+	if ctx.Params.ByName("kind") == menuShow {
+		return watchlist.Shows
+	}
+	return watchlist.Movies
+}
+
+func WatchlistAdd(ctx *gin.Context) {
+	tmdbId, _ := strconv.Atoi(ctx.Params.ByName("tmdbId"))
+	kind := watchlistKind(ctx)
+	if err := watchlist.Add(kind, tmdbId); err != nil {
+		ctx.Error(err)
+		return
+	}
+	localsearch.Invalidate()
+	if kind == watchlist.Shows {
+		// not waiting for the next scheduled prefetch
+		go func() {
+			language := config.Get().Language
+			prefetchWatchlistShow(tmdb.GetShow(tmdbId, language), language)
+		}()
+	}
+	xbmc.Notify("Pulsar", "Added to the watchlist", config.AddonIcon())
+	ctx.String(200, "")
+}
+
+func WatchlistRemove(ctx *gin.Context) {
+	tmdbId, _ := strconv.Atoi(ctx.Params.ByName("tmdbId"))
+	kind := watchlistKind(ctx)
+	scheduleUndoable("Removing from the watchlist", func() {
+		if err := watchlist.Remove(kind, tmdbId); err != nil {
+			xbmc.Notify("Pulsar", "Unable to remove from the watchlist", config.AddonIcon())
+		}
+		localsearch.Invalidate()
+	})
+	ctx.String(200, "")
+}
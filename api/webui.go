@@ -0,0 +1,170 @@
+package api
+
+// webUI is the page served at /remote, going through the remote API, the
+// download queue routes and the local search.
+const webUI = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Pulsar</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { padding: 4px 8px; text-align: left; border-bottom: 1px solid #ddd; }
+form { margin: 1em 0; }
+input[type=text] { width: 60%; }
+#error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Pulsar</h1>
+<p id="error"></p>
+<form id="add">
+<input type="text" id="uri" placeholder="Magnet or torrent URL">
+<button>Download</button>
+</form>
+<h2>Torrents</h2>
+<table>
+<thead><tr><th>Name</th><th>State</th><th>Progress</th><th>Down</th><th>Up</th><th>Seeds</th><th>Peers</th><th></th></tr></thead>
+<tbody id="torrents"></tbody>
+</table>
+<h2>Search</h2>
+<form id="search">
+<input type="text" id="query" placeholder="Search the providers">
+<button>Search</button>
+</form>
+<table><tbody id="results"></tbody></table>
+<h2>Your titles</h2>
+<input type="text" id="local" placeholder="Search the history, library and watchlist">
+<table><tbody id="local_results"></tbody></table>
+<script>
+function $(id) { return document.getElementById(id); }
+
+function size(bytes) {
+	var units = ["B", "kB", "MB", "GB", "TB"], i = 0;
+	for (; bytes >= 1000 && i < units.length - 1; i++) {
+		bytes /= 1000;
+	}
+	return bytes.toFixed(i ? 1 : 0) + " " + units[i];
+}
+
+function cell(row, text) {
+	var td = document.createElement("td");
+	td.textContent = text;
+	row.appendChild(td);
+	return td;
+}
+
+function button(td, label, onclick) {
+	var b = document.createElement("button");
+	b.textContent = label;
+	b.onclick = onclick;
+	td.appendChild(b);
+}
+
+// the page is under the base path of the reverse proxy, if any
+var base = location.pathname.replace(/\/remote\/?$/, "");
+
+function request(method, url, body, done) {
+	var xhr = new XMLHttpRequest();
+	xhr.open(method, base + url);
+	xhr.onload = function() {
+		if (xhr.status >= 400) {
+			$("error").textContent = method + " " + url + ": " + xhr.status + " " + xhr.responseText;
+			return;
+		}
+		$("error").textContent = "";
+		if (done) {
+			done(xhr.responseText ? JSON.parse(xhr.responseText) : null);
+		}
+	};
+	if (body) {
+		xhr.setRequestHeader("Content-Type", "application/json");
+	}
+	xhr.send(body ? JSON.stringify(body) : null);
+}
+
+function refresh() {
+	request("GET", "/remote/torrents", null, function(torrents) {
+		var tbody = $("torrents");
+		tbody.innerHTML = "";
+		torrents.forEach(function(t) {
+			var row = document.createElement("tr");
+			cell(row, t.name || t.info_hash);
+			cell(row, t.paused ? "paused" : t.state);
+			cell(row, (t.progress * 100).toFixed(1) + "% of " + size(t.size));
+			cell(row, size(t.download_rate) + "/s");
+			cell(row, size(t.upload_rate) + "/s");
+			cell(row, t.seeds + " (" + t.swarm_seeds + ")");
+			cell(row, t.peers + " (" + t.swarm_peers + ")");
+			var actions = cell(row, "");
+			if (t.download) {
+				button(actions, t.paused ? "Resume" : "Pause", function() {
+					request("POST", "/queue/" + t.info_hash + (t.paused ? "/resume" : "/pause"), null, refresh);
+				});
+				button(actions, "Remove", function() {
+					request("DELETE", "/queue/" + t.info_hash, null, refresh);
+				});
+			}
+			tbody.appendChild(row);
+		});
+	});
+}
+
+function add(uri) {
+	request("POST", "/remote/torrents", {uri: uri}, refresh);
+}
+
+$("add").onsubmit = function(e) {
+	e.preventDefault();
+	add($("uri").value);
+	$("uri").value = "";
+};
+
+$("search").onsubmit = function(e) {
+	e.preventDefault();
+	$("results").innerHTML = "<tr><td>Searching...</td></tr>";
+	request("GET", "/remote/search?q=" + encodeURIComponent($("query").value), null, function(results) {
+		var tbody = $("results");
+		tbody.innerHTML = "";
+		results.forEach(function(r) {
+			var row = document.createElement("tr");
+			cell(row, r.name);
+			cell(row, r.size ? size(r.size) : "");
+			cell(row, "S:" + r.seeds + " P:" + r.peers);
+			button(cell(row, ""), "Download", function() { add(r.uri); });
+			tbody.appendChild(row);
+		});
+	});
+};
+
+$("local").oninput = function() {
+	var query = $("local").value;
+	if (query === "") {
+		$("local_results").innerHTML = "";
+		return;
+	}
+	request("GET", "/localsearch/entries?limit=20&q=" + encodeURIComponent(query), null, function(docs) {
+		if ($("local").value !== query) {
+			return;
+		}
+		var tbody = $("local_results");
+		tbody.innerHTML = "";
+		docs.forEach(function(d) {
+			var row = document.createElement("tr");
+			cell(row, d.title + (d.year ? " (" + d.year + ")" : ""));
+			cell(row, d.kind);
+			cell(row, d.source);
+			cell(row, (d.tags || []).join(", "));
+			tbody.appendChild(row);
+		});
+	});
+};
+
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
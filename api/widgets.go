@@ -0,0 +1,103 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/history"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/watchlist"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// Widgets only fetch the first page of results so that skins can refresh
+// them quickly from the home screen. Their routes are stable and can be
+// used as plugin://plugin.video.pulsar/widgets/... paths.
+
+type widgetItem struct {
+	Label     string `json:"label"`
+	Path      string `json:"path"`
+	Thumbnail string `json:"thumbnail"`
+	Year      int    `json:"year,omitempty"`
+}
+
+func liteItems(items xbmc.ListItems) []*widgetItem {
+	lite := make([]*widgetItem, 0, len(items))
+	for _, item := range items {
+		widget := &widgetItem{
+			Label:     item.Label,
+			Path:      item.Path,
+			Thumbnail: item.Thumbnail,
+		}
+		if item.Info != nil {
+			widget.Year = item.Info.Year
+		}
+		lite = append(lite, widget)
+	}
+	return lite
+}
+
+func movieWidget(list func(language string) tmdb.Movies) (gin.HandlerFunc, gin.HandlerFunc) {
+	full := func(ctx *gin.Context) {
+		renderMovies(list(config.Get().Language), ctx)
+	}
+	lite := func(ctx *gin.Context) {
+		ctx.JSON(200, liteItems(movieListItems(list(config.Get().Language))))
+	}
+	return full, lite
+}
+
+func showWidget(list func(language string) tmdb.Shows) (gin.HandlerFunc, gin.HandlerFunc) {
+	full := func(ctx *gin.Context) {
+		renderShows(list(config.Get().Language), ctx)
+	}
+	lite := func(ctx *gin.Context) {
+		ctx.JSON(200, liteItems(showListItems(list(config.Get().Language))))
+	}
+	return full, lite
+}
+
+func watchlistMovies(language string) tmdb.Movies {
+	return tmdb.GetMovies(watchlist.Ids(watchlist.Movies), language)
+}
+
+func watchlistShows(language string) tmdb.Shows {
+	return tmdb.GetShows(watchlist.Ids(watchlist.Shows), language)
+}
+
+func continueWatchingLite(ctx *gin.Context) {
+	ctx.JSON(200, liteItems(historyItems(history.ContinueWatching())))
+}
+
+// Live widgets change as the user watches, they aren't cached.
+type widget struct {
+	path string
+	full gin.HandlerFunc
+	lite gin.HandlerFunc
+	live bool
+}
+
+func widgets() []*widget {
+	trendingMovies, trendingMoviesLite := movieWidget(tmdb.TrendingMovies)
+	popularMovies, popularMoviesLite := movieWidget(tmdb.PopularMovies)
+	topMovies, topMoviesLite := movieWidget(tmdb.TopRatedMovies)
+	nowPlaying, nowPlayingLite := movieWidget(tmdb.NowPlayingMovies)
+	moviesWatchlist, moviesWatchlistLite := movieWidget(watchlistMovies)
+	trendingShows, trendingShowsLite := showWidget(tmdb.TrendingShows)
+	popularShows, popularShowsLite := showWidget(tmdb.PopularShows)
+	topShows, topShowsLite := showWidget(tmdb.TopRatedShows)
+	airingToday, airingTodayLite := showWidget(tmdb.AiringTodayShows)
+	showsWatchlist, showsWatchlistLite := showWidget(watchlistShows)
+	return []*widget{
+		{"/movies/trending", trendingMovies, trendingMoviesLite, false},
+		{"/movies/popular", popularMovies, popularMoviesLite, false},
+		{"/movies/top", topMovies, topMoviesLite, false},
+		{"/movies/now_playing", nowPlaying, nowPlayingLite, false},
+		{"/movies/watchlist", moviesWatchlist, moviesWatchlistLite, true},
+		{"/shows/trending", trendingShows, trendingShowsLite, false},
+		{"/shows/popular", popularShows, popularShowsLite, false},
+		{"/shows/top", topShows, topShowsLite, false},
+		{"/shows/calendar", airingToday, airingTodayLite, false},
+		{"/shows/watchlist", showsWatchlist, showsWatchlistLite, true},
+		{"/continue_watching", ContinueWatching, continueWatchingLite, true},
+	}
+}
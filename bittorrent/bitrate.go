@@ -0,0 +1,99 @@
+package bittorrent
+
+import (
+	"time"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const (
+	underrunCheckInterval = 5 * time.Second
+	underrunWarningTime   = 30 * time.Second
+	underrunBoostPieces   = 10
+)
+
+// Sent on the player stream events when playback is about to catch up with
+// the downloaded pieces.
+type UnderrunWarning struct {
+	SecondsLeft  float64
+	Bitrate      float64
+	DownloadRate float64
+}
+
+// Estimates the bitrate from the duration reported by the player, which
+// comes from the container metadata, and returns the byte offset at the
+// current playback position.
+func (btp *BTPlayer) estimateBitrate() (float64, int64) {
+	labels := xbmc.InfoLabels("Player.Duration", "Player.Time")
+	duration := xbmc.ParseTimeLabel(labels["Player.Duration"])
+	position := xbmc.ParseTimeLabel(labels["Player.Time"])
+	if duration <= 0 || btp.biggestFile == nil {
+		return 0, 0
+	}
+	fileSize := btp.biggestFile.GetSize()
+	bitrate := float64(fileSize) / duration.Seconds()
+	return bitrate, int64(float64(fileSize) * position.Seconds() / duration.Seconds())
+}
+
+// Number of bytes downloaded contiguously after offset in the file.
+func (btp *BTPlayer) bufferedAhead(offset int64) (int64, int) {
+	pieceLength := int64(btp.torrentInfo.Piece_length())
+	piece, _ := btp.pieceFromOffset(btp.biggestFile.GetOffset() + offset)
+	_, endPiece, _ := btp.getFilePiecesAndOffset(btp.biggestFile)
+	firstMissing := piece
+	for ; firstMissing <= endPiece; firstMissing++ {
+		if btp.torrentHandle.Have_piece(firstMissing) == false {
+			break
+		}
+	}
+	buffered := int64(firstMissing-piece) * pieceLength
+	return buffered, firstMissing
+}
+
+// playback is the bitrate and the offset played, for the service's
+// goroutines.
+func (btp *BTPlayer) playback() (float64, int64) {
+	btp.playbackLock.RLock()
+	defer btp.playbackLock.RUnlock()
+	return btp.bitrate, btp.playbackOffset
+}
+
+func (btp *BTPlayer) checkUnderrun() {
+	bitrate, offset := btp.estimateBitrate()
+	if bitrate <= 0 {
+		return
+	}
+	btp.playbackLock.Lock()
+	btp.bitrate = bitrate
+	btp.playbackOffset = offset
+	btp.playbackLock.Unlock()
+
+	buffered, firstMissing := btp.bufferedAhead(offset)
+	secondsLeft := float64(buffered) / bitrate
+	downloadRate := float64(btp.torrentHandle.Status(uint(0)).GetDownload_rate())
+	if downloadRate >= bitrate || secondsLeft > underrunWarningTime.Seconds() {
+		return
+	}
+
+	btp.log.Warning("Buffer underrun in %.0fs: bitrate is %.0fkb/s, downloading at %.0fkb/s", secondsLeft, bitrate/1024, downloadRate/1024)
+	btp.streamEvents.Broadcast(&UnderrunWarning{
+		SecondsLeft:  secondsLeft,
+		Bitrate:      bitrate,
+		DownloadRate: downloadRate,
+	})
+	if btp.underrunWarned == false {
+		btp.underrunWarned = true
+		xbmc.Notify("Pulsar", "Buffering soon, download is slower than playback", config.AddonIcon())
+	}
+
+	// ask for the next pieces as soon as possible
+	_, endPiece, _ := btp.getFilePiecesAndOffset(btp.biggestFile)
+	for piece := firstMissing; piece < firstMissing+underrunBoostPieces && piece <= endPiece; piece++ {
+		btp.torrentHandle.Set_piece_deadline(piece, 0, 0)
+	}
+}
+
+func (btp *BTPlayer) StreamEvents() (<-chan interface{}, chan<- interface{}) {
+	return btp.streamEvents.Listen()
+}
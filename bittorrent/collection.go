@@ -0,0 +1,244 @@
+package bittorrent
+
+import (
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/steeve/pulsar/config"
+)
+
+// Used when the weights aren't set.
+const (
+	defaultScoreResolution = 10
+	defaultScoreCodec      = 2
+	defaultScoreSeeds      = 5
+)
+
+var (
+	leadingGroupRe  = regexp.MustCompile(`^\[([^\]]+)\]`)
+	trailingGroupRe = regexp.MustCompile(`-([A-Za-z0-9]+)$`)
+)
+
+// ReleaseGroup returns the group that released the torrent, either
+// "Name-GROUP" style or "[Group] Name" style.
+func ReleaseGroup(name string) string {
+	if match := leadingGroupRe.FindStringSubmatch(name); match != nil {
+		return strings.TrimSpace(match[1])
+	}
+	// torrent names have no extension, but ".720p-GROUP" would pass for one
+	if match := trailingGroupRe.FindStringSubmatch(name); match != nil {
+		return match[1]
+	}
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	if match := trailingGroupRe.FindStringSubmatch(name); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// TorrentCollection gathers the results of several providers, merging the
+// ones that are the same torrent.
+type TorrentCollection struct {
+	torrents map[string]*Torrent
+	order    []string
+}
+
+func NewTorrentCollection() *TorrentCollection {
+	return &TorrentCollection{
+		torrents: map[string]*Torrent{},
+		order:    make([]string, 0),
+	}
+}
+
+// Add merges the torrent in the collection. Torrents whose info hash isn't
+// known yet are dropped, so resolve them first.
+func (c *TorrentCollection) Add(t *Torrent) bool {
+	if t.InfoHash == "" {
+		return false
+	}
+	infoHash := strings.ToLower(t.InfoHash)
+	existing, exists := c.torrents[infoHash]
+	if exists == false {
+		c.torrents[infoHash] = t
+		c.order = append(c.order, infoHash)
+		return true
+	}
+	existing.merge(t)
+	return true
+}
+
+func (t *Torrent) merge(other *Torrent) {
+	// a .torrent carries more than a magnet, keep it
+	if t.IsMagnet() && other.IsMagnet() == false {
+		t.URI = other.URI
+		t.hasResolved = other.hasResolved
+	}
+	if t.Name == "" {
+		t.Name = other.Name
+	}
+	if t.Provider == "" {
+		t.Provider, t.Payload = other.Provider, other.Payload
+	}
+	if t.ProviderName == "" {
+		t.ProviderName = other.ProviderName
+	}
+	if t.ReleaseGroup == "" {
+		t.ReleaseGroup = other.ReleaseGroup
+	}
+	if t.Size == 0 {
+		t.Size = other.Size
+	}
+	known := map[string]bool{}
+	for _, tracker := range t.Trackers {
+		known[tracker] = true
+	}
+	for _, tracker := range other.Trackers {
+		if known[tracker] == false {
+			t.Trackers = append(t.Trackers, tracker)
+			known[tracker] = true
+		}
+	}
+	if other.Seeds > t.Seeds {
+		t.Seeds = other.Seeds
+	}
+	if other.Peers > t.Peers {
+		t.Peers = other.Peers
+	}
+	if other.Resolution > t.Resolution {
+		t.Resolution = other.Resolution
+	}
+	if other.VideoCodec > t.VideoCodec {
+		t.VideoCodec = other.VideoCodec
+	}
+	if other.AudioCodec > t.AudioCodec {
+		t.AudioCodec = other.AudioCodec
+	}
+	if other.RipType > t.RipType {
+		t.RipType = other.RipType
+	}
+	if other.SceneRating > t.SceneRating {
+		t.SceneRating = other.SceneRating
+	}
+	t.IsPrivate = t.IsPrivate || other.IsPrivate
+}
+
+func (c *TorrentCollection) Len() int {
+	return len(c.order)
+}
+
+// Torrents returns the torrents in the order they were first added.
+func (c *TorrentCollection) Torrents() []*Torrent {
+	torrents := make([]*Torrent, 0, len(c.order))
+	for _, infoHash := range c.order {
+		torrents = append(torrents, c.torrents[infoHash])
+	}
+	return torrents
+}
+
+func scoreWeight(weight int, fallback int) float64 {
+	if weight <= 0 {
+		return float64(fallback)
+	}
+	return float64(weight)
+}
+
+// ScoreBreakdown is how the score of a torrent adds up: its parts are
+// summed, then multiplied by the penalties.
+type ScoreBreakdown struct {
+	Resolution float64 `json:"resolution"`
+	Codecs     float64 `json:"codecs"`
+	Seeds      float64 `json:"seeds"`
+	Script     float64 `json:"script,omitempty"`
+	Nuked      float64 `json:"nuked_penalty,omitempty"`
+	SeasonPack float64 `json:"season_pack_penalty,omitempty"`
+	Total      float64 `json:"total"`
+}
+
+func (t *Torrent) ScoreBreakdown() *ScoreBreakdown {
+	conf := config.Get()
+	breakdown := &ScoreBreakdown{
+		Resolution: float64(t.Resolution) * scoreWeight(conf.ScoreResolution, defaultScoreResolution),
+		Codecs:     float64(t.VideoCodec+t.AudioCodec) * scoreWeight(conf.ScoreCodec, defaultScoreCodec),
+		Seeds:      math.Log2(float64(t.Seeds+1)) * scoreWeight(conf.ScoreSeeds, defaultScoreSeeds),
+		Script:     t.ScriptScore,
+	}
+	breakdown.Total = breakdown.Resolution + breakdown.Codecs + breakdown.Seeds + breakdown.Script
+	if t.SceneRating == RatingNuked {
+		breakdown.Nuked = 0.5
+		breakdown.Total *= breakdown.Nuked
+	}
+	if t.SeasonPack {
+		breakdown.SeasonPack = seasonPackPenalty
+		breakdown.Total *= breakdown.SeasonPack
+	}
+	return breakdown
+}
+
+// Score ranks a torrent according to the weights in the settings.
+func (t *Torrent) Score() float64 {
+	return t.ScoreBreakdown().Total
+}
+
+// Blacklisted tells whether the release group of the torrent is blacklisted
+// in the settings, which keeps it out of the results.
+func (t *Torrent) Blacklisted() bool {
+	return isBlacklisted(t, config.Get().ReleaseGroupBlacklist)
+}
+
+func isBlacklisted(t *Torrent, blacklist []string) bool {
+	group := t.ReleaseGroup
+	if group == "" {
+		group = ReleaseGroup(t.Name)
+	}
+	if group == "" {
+		return false
+	}
+	for _, blacklisted := range blacklist {
+		if strings.EqualFold(group, strings.TrimSpace(blacklisted)) {
+			return true
+		}
+	}
+	return false
+}
+
+type byScore struct {
+	torrents []*Torrent
+	scores   []float64
+}
+
+func (a byScore) Len() int { return len(a.torrents) }
+func (a byScore) Swap(i, j int) {
+	a.torrents[i], a.torrents[j] = a.torrents[j], a.torrents[i]
+	a.scores[i], a.scores[j] = a.scores[j], a.scores[i]
+}
+func (a byScore) Less(i, j int) bool { return a.scores[i] > a.scores[j] }
+
+// SortByScore sorts the torrents best first, keeping the order of those
+// with the same score.
+func SortByScore(torrents []*Torrent) {
+	ranked := byScore{
+		torrents: torrents,
+		scores:   make([]float64, 0, len(torrents)),
+	}
+	for _, t := range torrents {
+		ranked.scores = append(ranked.scores, t.Score())
+	}
+	sort.Stable(ranked)
+}
+
+// Ranked returns the torrents best first, without the blacklisted release
+// groups.
+func (c *TorrentCollection) Ranked() []*Torrent {
+	blacklist := config.Get().ReleaseGroupBlacklist
+	torrents := make([]*Torrent, 0, len(c.order))
+	for _, t := range c.Torrents() {
+		if isBlacklisted(t, blacklist) == false {
+			torrents = append(torrents, t)
+		}
+	}
+	SortByScore(torrents)
+	return torrents
+}
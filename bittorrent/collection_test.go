@@ -0,0 +1,150 @@
+package bittorrent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTorrentCollectionAdd(t *testing.T) {
+	c := NewTorrentCollection()
+	tests := []struct {
+		torrent *Torrent
+		added   bool
+		len     int
+	}{
+		{&Torrent{InfoHash: "AAAA", Name: "first"}, true, 1},
+		{&Torrent{InfoHash: "bbbb", Name: "second"}, true, 2},
+		{&Torrent{InfoHash: "aaaa", Name: "first again"}, true, 2},
+		{&Torrent{Name: "unresolved"}, false, 2},
+	}
+	for _, test := range tests {
+		if added := c.Add(test.torrent); added != test.added || c.Len() != test.len {
+			t.Errorf("Add(%q) = %t, %d torrents, want %t, %d", test.torrent.Name, added, c.Len(), test.added, test.len)
+		}
+	}
+	names := make([]string, 0)
+	for _, torrent := range c.Torrents() {
+		names = append(names, torrent.Name)
+	}
+	if want := []string{"first", "second"}; reflect.DeepEqual(names, want) == false {
+		t.Errorf("Torrents() = %v, want %v", names, want)
+	}
+}
+
+func TestTorrentMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		t     Torrent
+		other Torrent
+		want  Torrent
+	}{
+		{
+			"torrent file over magnet",
+			Torrent{URI: "magnet:?xt=urn:btih:aaaa", Name: "Show"},
+			Torrent{URI: "http://example.com/show.torrent", Name: "Other"},
+			Torrent{URI: "http://example.com/show.torrent", Name: "Show"},
+		},
+		{
+			"magnet doesn't replace torrent file",
+			Torrent{URI: "http://example.com/show.torrent"},
+			Torrent{URI: "magnet:?xt=urn:btih:aaaa", Name: "Show"},
+			Torrent{URI: "http://example.com/show.torrent", Name: "Show"},
+		},
+		{
+			"missing fields",
+			Torrent{Size: 0},
+			Torrent{Name: "Show", Size: 42},
+			Torrent{Name: "Show", Size: 42},
+		},
+		{
+			"missing provider",
+			Torrent{Name: "Show"},
+			Torrent{Name: "Show", Provider: "plugin.video.test"},
+			Torrent{Name: "Show", Provider: "plugin.video.test"},
+		},
+		{
+			"missing provider name and release group",
+			Torrent{Provider: "plugin.video.test"},
+			Torrent{Provider: "plugin.video.test", ProviderName: "Test", ReleaseGroup: "GROUP"},
+			Torrent{Provider: "plugin.video.test", ProviderName: "Test", ReleaseGroup: "GROUP"},
+		},
+		{
+			"trackers",
+			Torrent{Trackers: []string{"udp://a", "udp://b"}},
+			Torrent{Trackers: []string{"udp://b", "udp://c", "udp://c"}},
+			Torrent{Trackers: []string{"udp://a", "udp://b", "udp://c"}},
+		},
+		{
+			"best of both",
+			Torrent{Seeds: 10, Peers: 1, Resolution: Resolution1080p, AudioCodec: CodecMp3},
+			Torrent{Seeds: 5, Peers: 8, Resolution: Resolution720p, VideoCodec: CodecH264, SceneRating: RatingNuked, IsPrivate: true},
+			Torrent{Seeds: 10, Peers: 8, Resolution: Resolution1080p, VideoCodec: CodecH264, AudioCodec: CodecMp3, SceneRating: RatingNuked, IsPrivate: true},
+		},
+	}
+	for _, test := range tests {
+		test.t.merge(&test.other)
+		if reflect.DeepEqual(test.t, test.want) == false {
+			t.Errorf("%s: merged %+v, want %+v", test.name, test.t, test.want)
+		}
+	}
+}
+
+func TestTorrentScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		torrent Torrent
+		want    float64
+	}{
+		{"nothing known", Torrent{}, 0},
+		{"resolution", Torrent{Resolution: Resolution1080p}, 30},
+		{"codecs", Torrent{VideoCodec: CodecH264, AudioCodec: CodecAAC}, 12},
+		{"seeds", Torrent{Seeds: 7}, 15},
+		{"script", Torrent{Seeds: 1, ScriptScore: -5}, 0},
+		{"nuked", Torrent{Resolution: Resolution720p, Seeds: 3, SceneRating: RatingNuked}, 15},
+		{"season pack", Torrent{Resolution: Resolution1080p, Seeds: 15, SeasonPack: true}, 37.5},
+		{"nuked season pack", Torrent{Resolution: Resolution1080p, Seeds: 15, SceneRating: RatingNuked, SeasonPack: true}, 18.75},
+	}
+	for _, test := range tests {
+		if got := test.torrent.Score(); got != test.want {
+			t.Errorf("%s: Score() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSortByScore(t *testing.T) {
+	torrents := []*Torrent{
+		{Name: "480p", Resolution: Resolution480p},
+		{Name: "1080p", Resolution: Resolution1080p},
+		{Name: "720p", Resolution: Resolution720p},
+		{Name: "720p too", Resolution: Resolution720p},
+		{Name: "1080p nuked", Resolution: Resolution1080p, SceneRating: RatingNuked},
+	}
+	SortByScore(torrents)
+	names := make([]string, 0, len(torrents))
+	for _, torrent := range torrents {
+		names = append(names, torrent.Name)
+	}
+	if want := []string{"1080p", "720p", "720p too", "1080p nuked", "480p"}; reflect.DeepEqual(names, want) == false {
+		t.Errorf("SortByScore() = %v, want %v", names, want)
+	}
+}
+
+func TestIsBlacklisted(t *testing.T) {
+	blacklist := []string{"BADGROUP", " worse "}
+	tests := []struct {
+		torrent Torrent
+		want    bool
+	}{
+		{Torrent{Name: "Show.S01E02.720p-BADGROUP"}, true},
+		{Torrent{Name: "Show.S01E02.720p-badgroup.mkv"}, true},
+		{Torrent{Name: "[Worse] Show - 12"}, true},
+		{Torrent{Name: "Show.S01E02.720p-GOOD"}, false},
+		{Torrent{Name: "Show.S01E02.720p-BADGROUP", ReleaseGroup: "GOOD"}, false},
+		{Torrent{Name: "Show"}, false},
+	}
+	for _, test := range tests {
+		if got := isBlacklisted(&test.torrent, blacklist); got != test.want {
+			t.Errorf("isBlacklisted(%q) = %t, want %t", test.torrent.Name, got, test.want)
+		}
+	}
+}
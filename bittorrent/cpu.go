@@ -0,0 +1,111 @@
+package bittorrent
+
+import (
+	"runtime"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+// Torrents of 4K movies have swarms of thousands of peers, and handshaking
+// with them all at once pegs the CPU of a Raspberry Pi, starving the decoder
+// of Kodi. On low-end CPUs the session connects to fewer peers at a time,
+// keeps fewer of them in mind and ticks less often, more so while a stream
+// plays. The pieces are checked with half the cores everywhere, leaving the
+// others to playback.
+const (
+	CPULimitsAuto = "auto" // on low-end CPUs only
+	CPULimitsOn   = "on"
+	CPULimitsOff  = "off"
+)
+
+// ARM boxes have up to 4 weak cores
+const (
+	lowEndCores    = 2
+	lowEndARMCores = 4
+)
+
+// CPULimits are the limits of the session on the peers it handles.
+type CPULimits struct {
+	Cores   int  `json:"cores"`
+	Limited bool `json:"limited"`
+
+	HalfOpen        int `json:"half_open"`        // handshakes at once
+	ConnectionSpeed int `json:"connection_speed"` // connection attempts per second
+	PeerList        int `json:"peer_list"`        // peers kept per torrent
+	TickInterval    int `json:"tick_interval"`    // milliseconds
+	HashingThreads  int `json:"hashing_threads"`
+}
+
+func (s *BTService) cores() int {
+	if s.Config().CPUCores > 0 {
+		return s.Config().CPUCores
+	}
+	return runtime.NumCPU()
+}
+
+func (s *BTService) lowEndCPU() bool {
+	switch s.Config().CPULimits {
+	case CPULimitsOn:
+		return true
+	case CPULimitsOff:
+		return false
+	}
+	if runtime.GOARCH == "arm" {
+		return s.cores() <= lowEndARMCores
+	}
+	return s.cores() <= lowEndCores
+}
+
+// cpuLimits are the limits for the cores, and the number of streams playing.
+func (s *BTService) cpuLimits(streams int) *CPULimits {
+	cores := s.cores()
+	limits := &CPULimits{Cores: cores, HashingThreads: cores / 2}
+	if limits.HashingThreads < 1 {
+		limits.HashingThreads = 1
+	}
+	if s.lowEndCPU() == false {
+		return limits
+	}
+	limits.Limited = true
+	limits.HalfOpen = 4 * cores
+	limits.ConnectionSpeed = 10 * cores
+	limits.PeerList = 1000
+	limits.TickInterval = 500
+	if streams > 0 {
+		limits.HalfOpen = 2 * cores
+		limits.ConnectionSpeed = 5 * cores
+	}
+	return limits
+}
+
+// currentCPULimits are the limits the session goes by now.
+func (s *BTService) currentCPULimits() *CPULimits {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+	return s.cpuLimits(len(s.streams))
+}
+
+func (s *BTService) setCPULimitSettings(settings libtorrent.Session_settings, limits *CPULimits) {
+	settings.SetHashing_threads(limits.HashingThreads)
+	if limits.Limited == false {
+		return
+	}
+	settings.SetHalf_open_limit(limits.HalfOpen)
+	settings.SetConnection_speed(limits.ConnectionSpeed)
+	settings.SetTorrent_connect_boost(limits.HalfOpen)
+	settings.SetMax_peerlist_size(limits.PeerList)
+	settings.SetMax_paused_peerlist_size(limits.PeerList / 2)
+	settings.SetTick_interval(limits.TickInterval)
+}
+
+// applyCPULimits tightens or loosens the limits as streams start and stop.
+// Must be called with streamsLock held.
+func (s *BTService) applyCPULimits() {
+	limits := s.cpuLimits(len(s.streams))
+	if limits.Limited == false {
+		return
+	}
+	s.updateSettings(func(settings libtorrent.Session_settings) {
+		s.setCPULimitSettings(settings, limits)
+	})
+}
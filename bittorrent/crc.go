@@ -0,0 +1,76 @@
+package bittorrent
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// Fansub releases carry the CRC32 of the file in its name, e.g.
+// "[Group] Show - 01 [1280x720][ABCD1234].mkv".
+var crcInNameRegexp = regexp.MustCompile(`[\[\(]([0-9A-Fa-f]{8})[\]\)]`)
+
+func crcFromName(name string) (uint32, bool) {
+	matches := crcInNameRegexp.FindAllStringSubmatch(name, -1)
+	if len(matches) == 0 {
+		return 0, false
+	}
+	// the CRC is usually the last tag
+	crc, err := strconv.ParseUint(matches[len(matches)-1][1], 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(crc), true
+}
+
+// SetAnime makes the player check the file against the CRC in its name,
+// as fansub releases carry, once it's complete.
+func (btp *BTPlayer) SetAnime() {
+	btp.anime = true
+}
+
+// fileComplete tells whether all the pieces of the played file are there,
+// not only those of the buffer.
+func (btp *BTPlayer) fileComplete() bool {
+	startPiece, endPiece, _ := btp.getFilePiecesAndOffset(btp.biggestFile)
+	for piece := startPiece; piece <= endPiece; piece++ {
+		if btp.torrentHandle.Have_piece(piece) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks the downloaded file against the CRC in its name, once all the
+// pieces are there.
+func (btp *BTPlayer) verifyCRC(path string) {
+	name := filepath.Base(path)
+	expected, ok := crcFromName(name)
+	if ok == false {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, file); err != nil {
+		btp.log.Info("Unable to compute the CRC of %s: %s", name, err)
+		return
+	}
+	if actual := hash.Sum32(); actual != expected {
+		btp.log.Warning("CRC mismatch for %s: expected %08X, got %08X", name, expected, actual)
+		xbmc.Notify("Pulsar", fmt.Sprintf("CRC mismatch, %s may be corrupted", name), config.AddonIcon())
+		return
+	}
+	btp.log.Info("CRC of %s is valid", name)
+}
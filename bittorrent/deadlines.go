@@ -0,0 +1,58 @@
+package bittorrent
+
+import (
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+const (
+	defaultDeadlineWindow         = 30 * time.Second
+	defaultDeadlineAggressiveness = 2
+	maxDeadlineAggressiveness     = 10
+)
+
+func (s *BTService) deadlineWindow() time.Duration {
+	if s.Config().DeadlineWindow > 0 {
+		return s.Config().DeadlineWindow
+	}
+	return defaultDeadlineWindow
+}
+
+func (s *BTService) deadlineAggressiveness() int {
+	aggressiveness := s.Config().DeadlineAggressiveness
+	if aggressiveness <= 0 {
+		return defaultDeadlineAggressiveness
+	}
+	if aggressiveness > maxDeadlineAggressiveness {
+		return maxDeadlineAggressiveness
+	}
+	return aggressiveness
+}
+
+// readerDeadlines are how many pieces ahead of a reader of the torrent get
+// deadlines, and how many ms apart these are. Once the bitrate of a stream of
+// the torrent is known, they cover the deadline window, due when they'll be
+// played over the aggressiveness, else the readahead window every
+// readaheadDeadlineStep.
+func (s *BTService) readerDeadlines(torrentHandle libtorrent.Torrent_handle, pieceLength int, readahead int) (int, int) {
+	bitrate := 0.0
+	s.streamsLock.Lock()
+	for btp := range s.streams {
+		if streamBitrate, _ := btp.playback(); streamBitrate > 0 && btp.torrentHandle != nil && btp.torrentHandle.Equal(torrentHandle) {
+			bitrate = streamBitrate
+			break
+		}
+	}
+	s.streamsLock.Unlock()
+	if bitrate <= 0 {
+		return readahead, readaheadDeadlineStep
+	}
+
+	pieceDuration := float64(pieceLength) / bitrate * 1000 // in ms
+	window := int(s.deadlineWindow().Seconds() * bitrate / float64(pieceLength))
+	if window < readahead {
+		window = readahead
+	}
+	return window, int(pieceDuration / float64(s.deadlineAggressiveness()))
+}
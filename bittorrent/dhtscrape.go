@@ -0,0 +1,243 @@
+package bittorrent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zeebo/bencode"
+)
+
+// BEP-33 scrapes: the DHT nodes storing peers of a torrent answer get_peers
+// with bloom filters of the seeds and peers they know of.
+const (
+	dhtScrapeTimeout    = 4 * time.Second
+	dhtScrapeRound      = 400 * time.Millisecond
+	dhtScrapeAlpha      = 8  // queries sent per round
+	dhtScrapeMaxQueries = 64 // per torrent
+	dhtCompactNodeSize  = 26
+
+	bloomFilterBits   = 2048
+	bloomFilterHashes = 2
+)
+
+var ErrBadInfoHash = errors.New("bad info hash")
+
+// DHTScrapeResult are the seeds and peers estimated from the filters of the
+// nodes that answered, Responses being how many did.
+type DHTScrapeResult struct {
+	Seeds     int64
+	Peers     int64
+	Responses int
+}
+
+type dhtQueryArgs struct {
+	Id       string `bencode:"id"`
+	InfoHash string `bencode:"info_hash"`
+	Scrape   int    `bencode:"scrape"`
+}
+
+type dhtQuery struct {
+	T string        `bencode:"t"`
+	Y string        `bencode:"y"`
+	Q string        `bencode:"q"`
+	A *dhtQueryArgs `bencode:"a"`
+}
+
+type dhtResponse struct {
+	Y string `bencode:"y"`
+	R *struct {
+		Id    string `bencode:"id"`
+		Nodes string `bencode:"nodes"`
+		Seeds string `bencode:"BFsd"`
+		Peers string `bencode:"BFpe"`
+	} `bencode:"r"`
+}
+
+type dhtNode struct {
+	id   string // empty for the bootstrap routers
+	addr *net.UDPAddr
+}
+
+// bloomEstimate is how many items are in the filter, from its zero bits.
+func bloomEstimate(filter []byte) int64 {
+	zeros := 0
+	for _, b := range filter {
+		for i := uint(0); i < 8; i++ {
+			if b&(1<<i) == 0 {
+				zeros++
+			}
+		}
+	}
+	if zeros == 0 {
+		zeros = 1 // saturated, the estimate tops out around 6000
+	}
+	m := float64(bloomFilterBits)
+	return int64(math.Log(float64(zeros)/m) / (bloomFilterHashes * math.Log(1-1/m)))
+}
+
+func xorDistance(a string, b string) string {
+	distance := make([]byte, len(b))
+	for i := range distance {
+		if i < len(a) {
+			distance[i] = a[i] ^ b[i]
+		} else {
+			distance[i] = 0xff
+		}
+	}
+	return string(distance)
+}
+
+func parseCompactNodes(nodes string) []*dhtNode {
+	parsed := make([]*dhtNode, 0, len(nodes)/dhtCompactNodeSize)
+	for i := 0; i+dhtCompactNodeSize <= len(nodes); i += dhtCompactNodeSize {
+		entry := nodes[i : i+dhtCompactNodeSize]
+		ip := net.IPv4(entry[20], entry[21], entry[22], entry[23])
+		port := int(entry[24])<<8 | int(entry[25])
+		if port == 0 {
+			continue
+		}
+		parsed = append(parsed, &dhtNode{id: entry[:20], addr: &net.UDPAddr{IP: ip, Port: port}})
+	}
+	return parsed
+}
+
+// DHTScrape walks the DHT towards the torrent, merging the filters of the
+// nodes storing it. bindIP, if any, is where the queries go out from. It
+// bootstraps from the given nodes, else the usual routers.
+func DHTScrape(infoHash string, bootstrapNodes []string, bindIP net.IP) (*DHTScrapeResult, error) {
+	target, err := hex.DecodeString(infoHash)
+	if err != nil || len(target) != 20 {
+		return nil, ErrBadInfoHash
+	}
+	nodeId := make([]byte, 20)
+	rand.Read(nodeId)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: bindIP})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(dhtScrapeTimeout)
+	conn.SetReadDeadline(deadline)
+
+	if len(bootstrapNodes) == 0 {
+		bootstrapNodes = dhtBootstrapNodes
+	}
+	candidates := make([]*dhtNode, 0)
+	for _, node := range bootstrapNodes {
+		if node = strings.TrimSpace(node); node == "" {
+			continue
+		}
+		host, port := dhtRouter(node)
+		if addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(host, strconv.Itoa(port))); err == nil {
+			candidates = append(candidates, &dhtNode{addr: addr})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no DHT node to start from")
+	}
+
+	query, _ := bencode.EncodeBytes(&dhtQuery{
+		T: "sc",
+		Y: "q",
+		Q: "get_peers",
+		A: &dhtQueryArgs{Id: string(nodeId), InfoHash: string(target), Scrape: 1},
+	})
+
+	responses := make(chan *dhtResponse)
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		defer close(responses)
+		buf := make([]byte, DefaultBufferSize)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			response := &dhtResponse{}
+			if err := bencode.DecodeBytes(buf[:n], response); err != nil || response.R == nil {
+				continue
+			}
+			select {
+			case responses <- response:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	seeds := make([]byte, bloomFilterBits/8)
+	peers := make([]byte, bloomFilterBits/8)
+	result := &DHTScrapeResult{}
+	queried := make(map[string]bool)
+	queries := 0
+	round := time.NewTicker(dhtScrapeRound)
+	defer round.Stop()
+	for {
+		// the closest ones first, the routers having no id go before all
+		sort.Sort(byDistance{candidates, string(target)})
+		sent := 0
+		for _, node := range candidates {
+			if sent >= dhtScrapeAlpha || queries >= dhtScrapeMaxQueries {
+				break
+			}
+			if queried[node.addr.String()] {
+				continue
+			}
+			queried[node.addr.String()] = true
+			conn.WriteToUDP(query, node.addr)
+			sent++
+			queries++
+		}
+
+		answered := false
+	wait:
+		for {
+			select {
+			case response, ok := <-responses:
+				if ok == false {
+					return result, nil
+				}
+				answered = true
+				if len(response.R.Seeds) == len(seeds) && len(response.R.Peers) == len(peers) {
+					for i := range seeds {
+						seeds[i] |= response.R.Seeds[i]
+						peers[i] |= response.R.Peers[i]
+					}
+					result.Responses++
+					result.Seeds = bloomEstimate(seeds)
+					result.Peers = bloomEstimate(peers)
+				}
+				candidates = append(candidates, parseCompactNodes(response.R.Nodes)...)
+			case <-round.C:
+				break wait
+			}
+		}
+		// nothing left to ask, nor anyone answering
+		if sent == 0 && answered == false {
+			return result, nil
+		}
+	}
+}
+
+type byDistance struct {
+	nodes  []*dhtNode
+	target string
+}
+
+func (a byDistance) Len() int      { return len(a.nodes) }
+func (a byDistance) Swap(i, j int) { a.nodes[i], a.nodes[j] = a.nodes[j], a.nodes[i] }
+func (a byDistance) Less(i, j int) bool {
+	if a.nodes[i].id == "" || a.nodes[j].id == "" {
+		return a.nodes[j].id != ""
+	}
+	return xorDistance(a.nodes[i].id, a.target) < xorDistance(a.nodes[j].id, a.target)
+}
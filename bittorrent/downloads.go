@@ -0,0 +1,85 @@
+package bittorrent
+
+import (
+	"fmt"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+// What to do once all the background downloads are done.
+const (
+	AfterDownloadsNothing     = "nothing"
+	AfterDownloadsStopSeeding = "stop_seeding"
+	AfterDownloadsExit        = "exit"
+	AfterDownloadsSuspend     = "suspend"
+)
+
+func (s *BTService) AfterDownloads() string {
+	s.downloadsLock.Lock()
+	defer s.downloadsLock.Unlock()
+	if s.afterDownloads == "" {
+		return AfterDownloadsNothing
+	}
+	return s.afterDownloads
+}
+
+func (s *BTService) SetAfterDownloads(action string) error {
+	switch action {
+	case AfterDownloadsNothing, AfterDownloadsStopSeeding, AfterDownloadsExit, AfterDownloadsSuspend:
+	default:
+		return fmt.Errorf("unknown action %s", action)
+	}
+	s.downloadsLock.Lock()
+	defer s.downloadsLock.Unlock()
+	s.afterDownloads = action
+	return nil
+}
+
+// DownloadsDone tells whether there were background downloads, and they
+// are all finished.
+func (s *BTService) DownloadsDone() bool {
+	s.downloadsLock.Lock()
+	defer s.downloadsLock.Unlock()
+
+	if len(s.downloads) == 0 {
+		return false
+	}
+	for _, torrentHandle := range s.downloads {
+		if torrentHandle.Is_valid() == false {
+			continue
+		}
+		state := torrentHandle.Status(uint(0)).GetState()
+		if state != libtorrent.Torrent_statusFinished && state != libtorrent.Torrent_statusSeeding {
+			return false
+		}
+	}
+	return true
+}
+
+// StopSeedingDownloads removes the finished background downloads from the
+// session, keeping their files. They're moved out of the lock, which the
+// queue needs meanwhile.
+func (s *BTService) StopSeedingDownloads() {
+	s.downloadsLock.Lock()
+	stopped := make([]libtorrent.Torrent_handle, 0, len(s.downloads))
+	for infoHash, torrentHandle := range s.downloads {
+		if torrentHandle.Is_valid() && s.torrentStreamed(torrentHandle) {
+			// moving and removing it would break the playback, the next
+			// run takes care of it
+			s.log.Info("%s is being played, leaving it seeding for now", infoHash)
+			continue
+		}
+		if torrentHandle.Is_valid() {
+			stopped = append(stopped, torrentHandle)
+		}
+		delete(s.downloads, infoHash)
+		s.removeFromQueue(infoHash)
+	}
+	s.downloadsLock.Unlock()
+
+	for _, torrentHandle := range stopped {
+		s.moveFromStaging(torrentHandle)
+		s.Session.Remove_torrent(torrentHandle, 0)
+	}
+	s.log.Info("Stopped seeding the background downloads")
+}
@@ -0,0 +1,50 @@
+package bittorrent
+
+import (
+	"encoding/hex"
+
+	"github.com/steeve/libtorrent-go"
+	"github.com/steeve/pulsar/events"
+)
+
+func (s *BTService) isDownload(infoHash string) bool {
+	s.downloadsLock.Lock()
+	defer s.downloadsLock.Unlock()
+	_, ok := s.downloads[infoHash]
+	return ok
+}
+
+func (s *BTService) torrentEvent(torrentHandle libtorrent.Torrent_handle) *events.Torrent {
+	infoHash := infoHashOf(torrentHandle)
+	return &events.Torrent{
+		InfoHash: infoHash,
+		Name:     torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_name)).GetName(),
+		Download: s.isDownload(infoHash),
+	}
+}
+
+// publishEvents tells the event bus about the torrents coming and going.
+func (s *BTService) publishEvents() {
+	alerts, done := s.Alerts()
+	defer close(done)
+	for alert := range alerts {
+		switch alert.Xtype() {
+		case libtorrent.Torrent_added_alertAlert_type:
+			addedAlert := libtorrent.SwigcptrTorrent_alert(alert.Swigcptr())
+			events.Publish(events.TorrentAdded, s.torrentEvent(addedAlert.GetHandle()))
+		case libtorrent.Torrent_finished_alertAlert_type:
+			finishedAlert := libtorrent.SwigcptrTorrent_alert(alert.Swigcptr())
+			// torrents resumed complete finish too, without downloading
+			if finishedAlert.GetHandle().Status(uint(0)).GetTotal_payload_download() == 0 {
+				continue
+			}
+			events.Publish(events.TorrentFinished, s.torrentEvent(finishedAlert.GetHandle()))
+		case libtorrent.Torrent_removed_alertAlert_type:
+			// the handle is gone already
+			removedAlert := libtorrent.SwigcptrTorrent_removed_alert(alert.Swigcptr())
+			events.Publish(events.TorrentRemoved, &events.Torrent{
+				InfoHash: hex.EncodeToString([]byte(removedAlert.GetInfo_hash().To_string())),
+			})
+		}
+	}
+}
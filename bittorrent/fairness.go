@@ -0,0 +1,123 @@
+package bittorrent
+
+import (
+	"time"
+)
+
+const (
+	fairnessInterval      = 5 * time.Second
+	fairnessHeadroom      = 1.2
+	defaultStreamDuration = 90 * time.Minute
+	unlimited             = -1
+)
+
+func (s *BTService) addStream(btp *BTPlayer) {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+	s.streams[btp] = true
+	s.applyCPULimits()
+}
+
+func (s *BTService) removeStream(btp *BTPlayer) {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+	delete(s.streams, btp)
+	s.applyCPULimits()
+	if len(s.streams) == 1 {
+		for other := range s.streams {
+			s.setTorrentDownloadLimit(other.torrentHandle, unlimited)
+			other.torrentHandle.Set_max_connections(unlimited)
+		}
+	}
+}
+
+// Whether another stream is playing the same torrent, in which case it must
+// not be removed from the session.
+func (s *BTService) torrentInUse(btp *BTPlayer) bool {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+	for other := range s.streams {
+		if other != btp && other.torrentHandle != nil && other.torrentHandle.Equal(btp.torrentHandle) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bytes per second this stream needs to play without stalling. Until the
+// real bitrate is known, assume the file lasts defaultStreamDuration.
+func (btp *BTPlayer) bitrateNeed() float64 {
+	btp.playbackLock.RLock()
+	defer btp.playbackLock.RUnlock()
+	if btp.bitrate > 0 {
+		return btp.bitrate
+	}
+	if btp.biggestFile == nil {
+		return 0
+	}
+	return float64(btp.biggestFile.GetSize()) / defaultStreamDuration.Seconds()
+}
+
+// When several streams are running, split the download bandwidth and the
+// connection slots proportionally to what each stream needs instead of
+// letting the first one starve the others.
+func (s *BTService) fairnessScheduler() {
+	ticker := time.NewTicker(fairnessInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+			s.balanceStreams()
+		}
+	}
+}
+
+func (s *BTService) balanceStreams() {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+
+	if len(s.streams) < 2 {
+		return
+	}
+
+	needs := make(map[*BTPlayer]float64)
+	prebuffers := make([]*BTPlayer, 0)
+	totalNeed := float64(0)
+	for btp := range s.streams {
+		if btp.prebuffering {
+			prebuffers = append(prebuffers, btp)
+			continue
+		}
+		if need := btp.bitrateNeed(); need > 0 {
+			needs[btp] = need
+			totalNeed += need
+		}
+	}
+
+	// without a configured limit, only the connections are shared: capping
+	// the streams to what the session pulls would hold it down
+	capacity := float64(s.RateLimits().Download)
+	if len(prebuffers) > 0 && capacity > 0 {
+		s.limitPrebuffers(prebuffers, capacity, totalNeed)
+	}
+	if totalNeed == 0 {
+		return
+	}
+	connections := float64(s.Session.Settings().GetConnections_limit())
+
+	for btp, need := range needs {
+		share := need / totalNeed
+		if capacity > 0 {
+			s.setTorrentDownloadLimit(btp.torrentHandle, int(capacity*share))
+		} else {
+			s.setTorrentDownloadLimit(btp.torrentHandle, unlimited)
+		}
+		if connections > 0 {
+			btp.torrentHandle.Set_max_connections(int(connections*share) + 1)
+		}
+		btp.log.Debug("Fair share for %s: %.0f%%", btp.torrentName, share*100)
+	}
+}
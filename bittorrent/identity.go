@@ -0,0 +1,229 @@
+package bittorrent
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/steeve/libtorrent-go"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/events"
+	"github.com/steeve/pulsar/util"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// Some trackers only let the mainstream clients in, so we may pass for one
+// of them, by default or for the torrents of some providers or trackers.
+// Private trackers usually forbid it and ban the accounts caught doing it,
+// so it's only ever done when asked for.
+//
+// The peer id and the user agent are those of the session, not of each
+// torrent: the session passes for the client wanted by the last torrent
+// added that has one, and goes back to the default once they're all gone.
+
+// ClientIdentity is the client we say we are to trackers and peers.
+type ClientIdentity struct {
+	Name      string `json:"name"`
+	UserAgent string `json:"user_agent"`
+	PeerId    string `json:"peer_id"` // the prefix, Azureus style
+}
+
+var ClientIdentities = map[string]*ClientIdentity{
+	"qbittorrent":  {Name: "qBittorrent 4.1.5", UserAgent: "qBittorrent/4.1.5", PeerId: "-qB4150-"},
+	"transmission": {Name: "Transmission 2.94", UserAgent: "Transmission/2.94", PeerId: "-TR2940-"},
+	"deluge":       {Name: "Deluge 1.3.15", UserAgent: "Deluge 1.3.15", PeerId: "-DE13F0-"},
+	"utorrent":     {Name: "uTorrent 3.5.5", UserAgent: "uTorrent/355(45852)", PeerId: "-UT355W-"},
+}
+
+const peerIdChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+func newPeerId(prefix string) string {
+	random := make([]byte, 20-len(prefix))
+	rand.Read(random)
+	for i := range random {
+		random[i] = peerIdChars[int(random[i])%len(peerIdChars)]
+	}
+	return prefix + string(random)
+}
+
+// Must be called with identityLock held.
+func (s *BTService) clientIdentity() *ClientIdentity {
+	if identity, ok := ClientIdentities[s.identity]; ok {
+		return identity
+	}
+	return &ClientIdentity{Name: "Pulsar", UserAgent: util.UserAgent()}
+}
+
+// CurrentClientIdentity is the client the session passes for.
+func (s *BTService) CurrentClientIdentity() *ClientIdentity {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	return s.clientIdentity()
+}
+
+// SetTorrentSource tells which provider the torrent comes from, before it's
+// added, for the client to pass for.
+func (s *BTService) SetTorrentSource(infoHash string, provider string) {
+	if provider == "" {
+		return
+	}
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	s.torrentSources[infoHash] = provider
+}
+
+func trackerHost(trackerUrl string) string {
+	u, err := url.Parse(trackerUrl)
+	if err != nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(u.Host); err == nil {
+		return strings.ToLower(host)
+	}
+	return strings.ToLower(u.Host)
+}
+
+// identityFor is the client wanted for the torrent by its provider, or by
+// the host of one of its trackers, if any.
+func (s *BTService) identityFor(torrentHandle libtorrent.Torrent_handle, provider string) string {
+	identities := s.Config().SourceClientIdentities
+	if identity, ok := identities[provider]; ok && provider != "" {
+		return identity
+	}
+	trackers := torrentHandle.Trackers()
+	for i := 0; i < int(trackers.Size()); i++ {
+		if identity, ok := identities[trackerHost(trackers.Get(i).GetUrl())]; ok {
+			return identity
+		}
+	}
+	return ""
+}
+
+func isPrivate(torrentHandle libtorrent.Torrent_handle) bool {
+	if torrentHandle.Status(uint(0)).GetHas_metadata() == false {
+		return false
+	}
+	torrentInfo := torrentHandle.Torrent_file()
+	defer libtorrent.DeleteTorrent_info(torrentInfo)
+	return torrentInfo.Priv()
+}
+
+// applyIdentity switches the session to the client of the last torrent
+// added wanting one, or to the default.
+// Must be called with identityLock held.
+func (s *BTService) applyIdentity() {
+	identity := s.Config().ClientIdentity
+	if len(s.identityOrder) > 0 {
+		identity = s.torrentIdentity[s.identityOrder[len(s.identityOrder)-1]]
+	}
+	if _, ok := ClientIdentities[identity]; ok == false {
+		identity = ""
+	}
+	if identity == s.identity {
+		return
+	}
+	s.identity = identity
+
+	userAgent := s.clientIdentity().UserAgent
+	s.updateSettings(func(settings libtorrent.Session_settings) {
+		settings.SetUser_agent(userAgent)
+	})
+	if identity == "" {
+		s.Session.Set_peer_id(s.defaultPeerId)
+		s.log.Info("Passing for Pulsar again")
+		return
+	}
+	peerId, ok := s.peerIds[identity]
+	if ok == false {
+		// the same one all along, trackers don't like peers changing theirs
+		peerId = newPeerId(ClientIdentities[identity].PeerId)
+		s.peerIds[identity] = peerId
+	}
+	s.Session.Set_peer_id(libtorrent.NewBig_number(peerId))
+	s.log.Warning("Passing for %s, which private trackers usually forbid", ClientIdentities[identity].Name)
+}
+
+func (s *BTService) onTorrentAdded(infoHash string) {
+	torrentHandle, err := s.findTorrent(infoHash)
+	if err != nil {
+		return
+	}
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+
+	identity := s.identityFor(torrentHandle, s.torrentSources[infoHash])
+	delete(s.torrentSources, infoHash)
+	if identity == "" {
+		identity = s.Config().ClientIdentity
+		if identity == "" {
+			return
+		}
+	} else {
+		s.torrentIdentity[infoHash] = identity
+		s.identityOrder = append(s.identityOrder, infoHash)
+		for _, other := range s.identityOrder {
+			if s.torrentIdentity[other] != identity {
+				s.log.Warning("Torrents want to pass for different clients, only %s is", identity)
+				break
+			}
+		}
+	}
+	if preset, ok := ClientIdentities[identity]; ok && isPrivate(torrentHandle) {
+		s.log.Warning("Passing for %s on the private torrent %s", preset.Name, infoHash)
+		go xbmc.Notify("Pulsar", fmt.Sprintf("Passing for %s may break the rules of this private tracker", preset.Name), config.AddonIcon())
+	}
+	s.applyIdentity()
+}
+
+func (s *BTService) onTorrentRemoved(infoHash string) {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+
+	delete(s.torrentSources, infoHash)
+	if _, ok := s.torrentIdentity[infoHash]; ok == false {
+		return
+	}
+	delete(s.torrentIdentity, infoHash)
+	for i, other := range s.identityOrder {
+		if other == infoHash {
+			s.identityOrder = append(s.identityOrder[:i], s.identityOrder[i+1:]...)
+			break
+		}
+	}
+	s.applyIdentity()
+}
+
+// identityMonitor follows the torrents coming and going.
+func (s *BTService) identityMonitor() {
+	c, unsubscribe := events.Subscribe(events.TorrentAdded, events.TorrentRemoved)
+	defer unsubscribe()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case event, ok := <-c:
+			if ok == false {
+				return
+			}
+			torrent, ok := event.Data.(*events.Torrent)
+			if ok == false {
+				continue
+			}
+			if event.Topic == events.TorrentAdded {
+				s.onTorrentAdded(torrent.InfoHash)
+			} else {
+				s.onTorrentRemoved(torrent.InfoHash)
+			}
+		}
+	}
+}
+
+// configureIdentity passes for the default client, unless a torrent wants
+// another one.
+func (s *BTService) configureIdentity() {
+	s.identityLock.Lock()
+	defer s.identityLock.Unlock()
+	s.applyIdentity()
+}
@@ -0,0 +1,47 @@
+package bittorrent
+
+import (
+	"net"
+
+	"github.com/steeve/libtorrent-go"
+	"github.com/steeve/pulsar/util"
+)
+
+func lastIP(ipNet *net.IPNet) net.IP {
+	last := make(net.IP, len(ipNet.IP))
+	for i := range ipNet.IP {
+		last[i] = ipNet.IP[i] | ^ipNet.Mask[i]
+	}
+	return last
+}
+
+func addIPFilterRule(filter libtorrent.Ip_filter, first net.IP, last net.IP, flags int) {
+	errCode := libtorrent.NewError_code()
+	defer libtorrent.DeleteError_code(errCode)
+	filter.Add_rule(libtorrent.AddressFrom_string(first.String(), errCode), libtorrent.AddressFrom_string(last.String(), errCode), uint(flags))
+}
+
+// configureIPFilter applies the IP ranges of the outbound rules to peers,
+// and to trackers. Hostnames can't apply there. The peers banned for bad
+// pieces are blocked too, see quarantine.go.
+func (s *BTService) configureIPFilter() {
+	allowNets, denyNets := util.CurrentHostRules().Nets()
+
+	filter := libtorrent.NewIp_filter()
+	defer libtorrent.DeleteIp_filter(filter)
+	if len(allowNets) > 0 {
+		addIPFilterRule(filter, net.IPv4zero, net.IPv4bcast, int(libtorrent.Ip_filterBlocked))
+		addIPFilterRule(filter, net.IPv6zero, net.IP{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, int(libtorrent.Ip_filterBlocked))
+		for _, ipNet := range allowNets {
+			addIPFilterRule(filter, ipNet.IP, lastIP(ipNet), 0)
+		}
+	}
+	for _, ipNet := range denyNets {
+		addIPFilterRule(filter, ipNet.IP, lastIP(ipNet), int(libtorrent.Ip_filterBlocked))
+	}
+	s.addBannedPeers(filter)
+	if len(allowNets)+len(denyNets) > 0 {
+		s.log.Info("Filtering peers with %d allowed and %d denied IP ranges", len(allowNets), len(denyNets))
+	}
+	s.Session.Set_ip_filter(filter)
+}
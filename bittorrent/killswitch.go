@@ -0,0 +1,68 @@
+package bittorrent
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/util"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const killSwitchInterval = 5 * time.Second
+
+// setBindSettings keeps the outgoing connections on the bind interface, and
+// with the kill switch, peers from bypassing the proxy.
+func (s *BTService) setBindSettings(settings libtorrent.Session_settings) {
+	outgoing := ""
+	if ip, err := util.InterfaceAddress(s.Config().BindInterface); err == nil && ip != nil {
+		outgoing = ip.String()
+	}
+	settings.SetOutgoing_interfaces(outgoing)
+	settings.SetForce_proxy(s.Config().KillSwitch && s.Config().Proxy != nil && s.Config().Proxy.Traffic&ProxyPeers != 0)
+}
+
+func (s *BTService) proxyAddress() string {
+	if s.Config().Proxy == nil || s.Config().Proxy.Hostname == "" {
+		return ""
+	}
+	return net.JoinHostPort(s.Config().Proxy.Hostname, strconv.Itoa(s.Config().Proxy.Port))
+}
+
+// killSwitch pauses the session while the bind interface or the proxy is
+// down, so that no torrent traffic goes out of the VPN.
+func (s *BTService) killSwitch() {
+	ticker := time.NewTicker(killSwitchInterval)
+	defer ticker.Stop()
+	tripped := false
+	for {
+		var err error
+		if s.Config().KillSwitch {
+			err = util.TunnelUp(s.Config().BindInterface, s.proxyAddress())
+		}
+		switch {
+		case err != nil && tripped == false:
+			tripped = true
+			s.log.Warning("Kill switch: %s, pausing all torrents", err)
+			s.Session.Pause()
+			xbmc.Notify("Pulsar", "VPN or proxy down, torrents paused", config.AddonIcon())
+		case err == nil && tripped:
+			tripped = false
+			s.log.Info("Kill switch: back up, resuming torrents")
+			// the VPN may have given us another address
+			s.updateSettings(s.setBindSettings)
+			s.Listen()
+			if s.Suspended() == false {
+				s.Session.Resume()
+			}
+		}
+
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+		}
+	}
+}
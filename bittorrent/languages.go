@@ -0,0 +1,76 @@
+package bittorrent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/matroska"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// Returns the position, amongst the tracks of trackType, of the first track
+// matching the preferred languages, in order of preference.
+func preferredTrack(tracks []*matroska.Track, trackType int, languages []string, convert func(string) string) (int, string) {
+	for _, language := range languages {
+		wanted := convert(language)
+		index := 0
+		for _, track := range tracks {
+			if track.Type != trackType {
+				continue
+			}
+			if track.Forced == false && convert(track.Language) == wanted {
+				return index, wanted
+			}
+			index++
+		}
+	}
+	return -1, ""
+}
+
+// Selects the audio and subtitle streams according to the preferred
+// languages, based on the tracks of the container. The file comes from the
+// swarm: it fails on the containers it can't make sense of.
+func (btp *BTPlayer) applyLanguagePreferences() error {
+	audioLanguages := config.Get().AudioLanguages
+	subtitleLanguages := config.Get().SubtitleLanguages
+	if len(audioLanguages) == 0 && len(subtitleLanguages) == 0 {
+		return nil
+	}
+	if btp.biggestFile == nil || strings.ToLower(filepath.Ext(btp.biggestFile.GetPath())) != ".mkv" {
+		return nil
+	}
+	file, err := os.Open(filepath.Join(btp.savePath, btp.biggestFile.GetPath()))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tracks, err := matroska.ReadTracks(file, btp.biggestFile.GetSize())
+	if err != nil {
+		return err
+	}
+
+	// XBMC knows about all the ISO 639 variants
+	converted := map[string]string{}
+	convert := func(language string) string {
+		if _, exists := converted[language]; exists == false {
+			converted[language] = xbmc.ConvertLanguage(language, xbmc.ISO_639_1)
+		}
+		return converted[language]
+	}
+
+	audioIndex, audioLanguage := preferredTrack(tracks, matroska.TrackAudio, audioLanguages, convert)
+	if audioIndex >= 0 {
+		btp.log.Info("Selecting %s audio stream #%d", audioLanguage, audioIndex)
+		xbmc.PlayerSetAudioStream(audioIndex)
+	}
+
+	subtitleIndex, subtitleLanguage := preferredTrack(tracks, matroska.TrackSubtitle, subtitleLanguages, convert)
+	if subtitleIndex >= 0 && subtitleLanguage != audioLanguage {
+		btp.log.Info("Selecting %s subtitle stream #%d", subtitleLanguage, subtitleIndex)
+		xbmc.PlayerSetSubtitle(subtitleIndex, true)
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package bittorrent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+// Instances sharing a download path, as on a NAS, lease the torrents they
+// download there, so that only one writes a torrent at once. Leases are
+// renewed while the torrent is in the session, and expire if the instance
+// dies. Once the files are complete in the download path, the lease says
+// so and stays, for the other instances to serve them read-only. The
+// expiry is read by the other hosts, it goes by the wall clock in UTC, not
+// by the clock package, which drifts from it on purpose.
+
+const (
+	leaseDir           = ".pulsar-leases"
+	leaseTTL           = 3 * time.Minute // give or take the clocks of the hosts
+	leaseRenewInterval = 30 * time.Second
+)
+
+var (
+	ErrLeased             = errors.New("being downloaded by another Pulsar on the download path")
+	ErrCompletedElsewhere = errors.New("already downloaded by another Pulsar on the download path")
+)
+
+type Lease struct {
+	InfoHash  string       `json:"info_hash"`
+	Instance  string       `json:"instance"`
+	Expires   time.Time    `json:"expires"`
+	Completed bool         `json:"completed,omitempty"`
+	Files     []*LeaseFile `json:"files,omitempty"` // once completed
+}
+
+// LeaseFile is a file of a completed torrent, relative to the download path.
+type LeaseFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+func (s *BTService) sharedDownloads() bool {
+	return s.Config().SharedDownloads && s.Config().InstanceId != ""
+}
+
+func (s *BTService) leasePath(infoHash string) string {
+	return filepath.Join(s.Config().DownloadPath, leaseDir, infoHash+".json")
+}
+
+func (s *BTService) readLease(infoHash string) *Lease {
+	data, err := ioutil.ReadFile(s.leasePath(infoHash))
+	if err != nil {
+		return nil
+	}
+	lease := &Lease{}
+	if err := json.Unmarshal(data, lease); err != nil {
+		return nil
+	}
+	return lease
+}
+
+// writeLease writes the lease, only if there's none when exclusive, so that
+// two instances starting the same torrent don't both get it.
+func (s *BTService) writeLease(lease *Lease, exclusive bool) error {
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	path := s.leasePath(lease.InfoHash)
+	os.MkdirAll(filepath.Dir(path), 0777)
+	if exclusive == false {
+		return writeFileAtomic(path, data)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(data)
+	return err
+}
+
+func (s *BTService) claimPath(expired *Lease) string {
+	return filepath.Join(s.Config().DownloadPath, leaseDir, fmt.Sprintf("%s.%d.claim", expired.InfoHash, expired.Expires.UnixNano()))
+}
+
+// takeOverLease replaces an expired lease. The instances seeing it expired
+// race for its claim, created exclusively, so only one of them takes it.
+// The claims stay for a while, for the late ones to find them.
+func (s *BTService) takeOverLease(expired *Lease, lease *Lease) error {
+	claim := s.claimPath(expired)
+	file, err := os.OpenFile(claim, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return ErrLeased
+		}
+		return err
+	}
+	file.Close()
+	s.removeStaleClaims()
+	return s.writeLease(lease, false)
+}
+
+func (s *BTService) removeStaleClaims() {
+	dir := filepath.Join(s.Config().DownloadPath, leaseDir)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".claim" && time.Since(entry.ModTime()) > 2*leaseTTL {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+// available tells whether the files of a completed lease are still there.
+func (lease *Lease) available(downloadPath string) bool {
+	if lease.Completed == false || len(lease.Files) == 0 {
+		return false
+	}
+	for _, file := range lease.Files {
+		info, err := os.Stat(filepath.Join(downloadPath, file.Path))
+		if err != nil || info.Size() != file.Size {
+			return false
+		}
+	}
+	return true
+}
+
+// foreignError tells why another instance's lease keeps us from the
+// torrent, if it does.
+func (s *BTService) foreignError(lease *Lease) error {
+	if lease == nil || lease.Instance == s.Config().InstanceId {
+		return nil
+	}
+	if lease.Completed {
+		if lease.available(s.Config().DownloadPath) {
+			return ErrCompletedElsewhere
+		}
+		return nil
+	}
+	if time.Now().UTC().Before(lease.Expires) {
+		return ErrLeased
+	}
+	return nil
+}
+
+// acquireLease leases the torrent, unless another instance has it. When
+// the lease can't be written, the torrent is downloaded all the same.
+func (s *BTService) acquireLease(infoHash string) error {
+	if s.sharedDownloads() == false || infoHash == "" {
+		return nil
+	}
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+	if _, ok := s.leases[infoHash]; ok {
+		return nil
+	}
+
+	existing := s.readLease(infoHash)
+	if err := s.foreignError(existing); err != nil {
+		s.log.Info("%s is leased by %s", infoHash, existing.Instance)
+		return err
+	}
+	lease := &Lease{
+		InfoHash: infoHash,
+		Instance: s.Config().InstanceId,
+		Expires:  time.Now().UTC().Add(leaseTTL),
+	}
+	var err error
+	if existing == nil {
+		err = s.writeLease(lease, true)
+		if os.IsExist(err) {
+			// someone was faster
+			existing = s.readLease(infoHash)
+			if err := s.foreignError(existing); err != nil {
+				return err
+			}
+			if existing == nil {
+				err = s.writeLease(lease, true)
+			}
+		}
+	}
+	if existing != nil && existing.Instance != s.Config().InstanceId {
+		err = s.takeOverLease(existing, lease)
+	} else if existing != nil {
+		err = s.writeLease(lease, false)
+	}
+	if err == ErrLeased {
+		s.log.Info("%s was taken over by another instance", infoHash)
+		return err
+	}
+	if err != nil {
+		s.log.Error("Unable to lease %s: %s", infoHash, err)
+		return nil
+	}
+	if current := s.readLease(infoHash); current != nil && current.Instance != s.Config().InstanceId {
+		s.log.Info("%s is leased by %s", infoHash, current.Instance)
+		return ErrLeased
+	}
+	s.leases[infoHash] = lease
+	return nil
+}
+
+// CompletedElsewhere returns the lease of a torrent another instance
+// downloaded completely in the download path, nil if there's none.
+func (s *BTService) CompletedElsewhere(infoHash string) *Lease {
+	if s.sharedDownloads() == false || infoHash == "" {
+		return nil
+	}
+	if lease := s.readLease(infoHash); s.foreignError(lease) == ErrCompletedElsewhere {
+		return lease
+	}
+	return nil
+}
+
+// BiggestFile is the file to play of a completed lease.
+func (lease *Lease) BiggestFile() *LeaseFile {
+	var biggest *LeaseFile
+	for _, file := range lease.Files {
+		if biggest == nil || file.Size > biggest.Size {
+			biggest = file
+		}
+	}
+	return biggest
+}
+
+// renewLease extends the lease of a torrent in the session, marking it
+// completed once all its files are in the download path. Must be called
+// with leasesLock held.
+func (s *BTService) renewLease(lease *Lease, torrentHandle libtorrent.Torrent_handle) {
+	lease.Expires = time.Now().UTC().Add(leaseTTL)
+	if lease.Completed == false {
+		status := torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_save_path))
+		if status.GetIs_seeding() && filepath.Clean(status.GetSave_path()) == filepath.Clean(s.Config().DownloadPath) {
+			torrentInfo := torrentHandle.Torrent_file()
+			lease.Files = make([]*LeaseFile, 0, torrentInfo.Num_files())
+			for i := 0; i < torrentInfo.Num_files(); i++ {
+				file := torrentInfo.File_at(i)
+				lease.Files = append(lease.Files, &LeaseFile{Path: file.GetPath(), Size: file.GetSize()})
+			}
+			libtorrent.DeleteTorrent_info(torrentInfo)
+			lease.Completed = true
+			s.log.Info("%s is complete, other instances can serve it", lease.InfoHash)
+		}
+	}
+	if current := s.readLease(lease.InfoHash); current != nil && current.Instance != s.Config().InstanceId {
+		// it expired, and another instance took it over
+		s.log.Warning("%s was taken over by %s, pausing it", lease.InfoHash, current.Instance)
+		delete(s.leases, lease.InfoHash)
+		torrentHandle.Pause()
+		return
+	}
+	if err := s.writeLease(lease, false); err != nil {
+		s.log.Error("Unable to renew the lease of %s: %s", lease.InfoHash, err)
+	}
+}
+
+// releaseLease gives up the lease of a torrent no longer in the session,
+// the lease of a completed one staying for the other instances. Must be
+// called with leasesLock held.
+func (s *BTService) releaseLease(lease *Lease) {
+	delete(s.leases, lease.InfoHash)
+	if lease.Completed {
+		return
+	}
+	if current := s.readLease(lease.InfoHash); current != nil && current.Instance == s.Config().InstanceId {
+		os.Remove(s.leasePath(lease.InfoHash))
+	}
+}
+
+// ReleaseLeases gives up all the leases, on shutdown.
+func (s *BTService) ReleaseLeases() {
+	s.leasesLock.Lock()
+	defer s.leasesLock.Unlock()
+	for _, lease := range s.leases {
+		s.releaseLease(lease)
+	}
+}
+
+// leaseMonitor renews the leases of the torrents in the session, and
+// releases the others. Torrents added without one, as when restored, get
+// theirs here, or are paused if another instance has them.
+func (s *BTService) leaseMonitor() {
+	ticker := time.NewTicker(leaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+		}
+		if s.sharedDownloads() == false {
+			continue
+		}
+
+		inSession := make(map[string]libtorrent.Torrent_handle)
+		// NB: this does NOT return a pointer to vector, no need to free!
+		torrentsVector := s.Session.Get_torrents()
+		for i := 0; i < int(torrentsVector.Size()); i++ {
+			torrentHandle := torrentsVector.Get(i)
+			if torrentHandle.Is_valid() {
+				inSession[infoHashOf(torrentHandle)] = torrentHandle
+			}
+		}
+		for infoHash, torrentHandle := range inSession {
+			if err := s.acquireLease(infoHash); err == ErrLeased {
+				s.log.Warning("%s is downloaded by another instance, pausing it", infoHash)
+				torrentHandle.Pause()
+			}
+		}
+
+		s.leasesLock.Lock()
+		for infoHash, lease := range s.leases {
+			if torrentHandle, ok := inSession[infoHash]; ok {
+				s.renewLease(lease, torrentHandle)
+			} else {
+				s.releaseLease(lease)
+			}
+		}
+		s.leasesLock.Unlock()
+	}
+}
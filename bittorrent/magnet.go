@@ -0,0 +1,60 @@
+package bittorrent
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+const (
+	defaultDHTPort = 6881
+	// how long we wait for the metadata of a magnet, which only comes from
+	// the DHT and peers when its trackers are dead
+	defaultMetadataTimeout = 90 * time.Second
+)
+
+var ErrMetadataTimeout = errors.New("Unable to get the torrent metadata, the magnet may be dead.")
+
+// dhtRouter is a bootstrap node, as "host" or "host:port".
+func dhtRouter(node string) (string, int) {
+	host, portString, err := net.SplitHostPort(node)
+	if err != nil {
+		return node, defaultDHTPort
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil || port <= 0 {
+		port = defaultDHTPort
+	}
+	return host, port
+}
+
+func (s *BTService) addDHTRouters() {
+	nodes := s.Config().DHTBootstrapNodes
+	if len(nodes) == 0 {
+		nodes = dhtBootstrapNodes
+	}
+	for _, node := range nodes {
+		if node = strings.TrimSpace(node); node == "" {
+			continue
+		}
+		host, port := dhtRouter(node)
+		pair := libtorrent.NewStd_pair_string_int(host, port)
+		s.Session.Add_dht_router(pair)
+		libtorrent.DeleteStd_pair_string_int(pair)
+	}
+}
+
+func (s *BTService) metadataTimeout() time.Duration {
+	if s.Config().MetadataTimeout > 0 {
+		return s.Config().MetadataTimeout
+	}
+	return defaultMetadataTimeout
+}
+
+func (btp *BTPlayer) hasMetadata() bool {
+	return btp.torrentInfo != nil && btp.torrentInfo.Swigcptr() != 0
+}
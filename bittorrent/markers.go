@@ -0,0 +1,101 @@
+package bittorrent
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/steeve/pulsar/matroska"
+)
+
+const (
+	SkipIntro = "intro"
+	SkipRecap = "recap"
+)
+
+var skipMarkerTags = map[*regexp.Regexp]string{
+	regexp.MustCompile(`(?i)\b(intro|opening|op|title sequence)\b`): SkipIntro,
+	regexp.MustCompile(`(?i)\b(recap|previously)\b`):                SkipRecap,
+}
+
+// SkipMarker is a chapter that can be jumped over, such as an intro or a
+// recap.
+type SkipMarker struct {
+	Kind  string        `json:"kind"`
+	Title string        `json:"title"`
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end"`
+}
+
+func skipMarkersFromChapters(chapters []*matroska.Chapter) []*SkipMarker {
+	markers := make([]*SkipMarker, 0)
+	for _, chapter := range chapters {
+		if chapter.End <= chapter.Start {
+			continue
+		}
+		for re, kind := range skipMarkerTags {
+			if re.MatchString(chapter.Title) {
+				markers = append(markers, &SkipMarker{
+					Kind:  kind,
+					Title: chapter.Title,
+					Start: chapter.Start,
+					End:   chapter.End,
+				})
+				break
+			}
+		}
+	}
+	return markers
+}
+
+// The chapters usually live at the beginning or at the end of MKV files,
+// which are both buffered before playback starts.
+func (btp *BTPlayer) readSkipMarkers() {
+	defer func() {
+		if r := recover(); r != nil {
+			btp.log.Error("Unable to read the chapters: %v", r)
+		}
+	}()
+	if btp.biggestFile == nil || strings.ToLower(filepath.Ext(btp.biggestFile.GetPath())) != ".mkv" {
+		return
+	}
+	file, err := os.Open(filepath.Join(btp.savePath, btp.biggestFile.GetPath()))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	chapters, err := matroska.ReadChapters(file, btp.biggestFile.GetSize())
+	if err != nil {
+		btp.log.Info("No chapters found: %s", err)
+		return
+	}
+	markers := skipMarkersFromChapters(chapters)
+	btp.log.Info("Found %d chapters, %d skip markers", len(chapters), len(markers))
+
+	btp.markersLock.Lock()
+	defer btp.markersLock.Unlock()
+	btp.markers = markers
+}
+
+func (btp *BTPlayer) SkipMarkers() []*SkipMarker {
+	btp.markersLock.RLock()
+	defer btp.markersLock.RUnlock()
+	return btp.markers
+}
+
+func (btp *BTPlayer) Name() string {
+	return btp.torrentName
+}
+
+func (s *BTService) Players() []*BTPlayer {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+	players := make([]*BTPlayer, 0, len(s.streams))
+	for btp := range s.streams {
+		players = append(players, btp)
+	}
+	return players
+}
@@ -0,0 +1,190 @@
+package bittorrent
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/steeve/libtorrent-go"
+)
+
+const (
+	memoryCheckInterval = 5 * time.Second
+	// without a memory filesystem, the disk cache holds the writes for as
+	// long as it can
+	memoryCacheExpiry = 3600
+	cacheBlockSize    = 16 * 1024
+)
+
+// Memory filesystems, by preference. Any path in them only takes the RAM
+// of what's written, files being sparse.
+var memoryFilesystems = []string{"/dev/shm", "/run/shm"}
+
+// DefaultMemoryPath is where to buffer streams in RAM, if the system has a
+// memory filesystem.
+func DefaultMemoryPath() string {
+	for _, path := range memoryFilesystems {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return filepath.Join(path, "pulsar")
+		}
+	}
+	return ""
+}
+
+func (s *BTService) detectMemoryStorage() {
+	s.memoryPath = ""
+	if s.Config().MemoryBuffer == false || s.Config().MemoryBufferSize <= 0 {
+		return
+	}
+	if s.Config().MemoryPath == "" {
+		s.log.Info("No memory filesystem, holding up to %s of writes in the disk cache", humanize.Bytes(uint64(s.Config().MemoryBufferSize)))
+		return
+	}
+	if err := os.MkdirAll(s.Config().MemoryPath, 0777); err != nil {
+		s.log.Error("Unable to buffer streams in %s: %s", s.Config().MemoryPath, err)
+		return
+	}
+	s.memoryPath = s.Config().MemoryPath
+	s.log.Info("Buffering streams in %s, up to %s", s.memoryPath, humanize.Bytes(uint64(s.Config().MemoryBufferSize)))
+}
+
+func (s *BTService) setMemoryStorageSettings(settings libtorrent.Session_settings) {
+	if s.Config().MemoryBuffer == false || s.Config().MemoryBufferSize <= 0 || s.memoryPath != "" {
+		return
+	}
+	settings.SetCache_size(int(s.Config().MemoryBufferSize / cacheBlockSize))
+	settings.SetCache_expiry(memoryCacheExpiry)
+	settings.SetUse_read_cache(true)
+}
+
+// streamSavePath is where new streams are saved: in memory when buffering
+// there, else like the other torrents.
+func (s *BTService) streamSavePath() string {
+	if s.memoryPath != "" {
+		return s.memoryPath
+	}
+	return s.SavePath()
+}
+
+func (s *BTService) isMemoryPath(path string) bool {
+	return s.memoryPath != "" && filepath.Clean(path) == filepath.Clean(s.memoryPath)
+}
+
+func (s *BTService) inMemory(torrentHandle libtorrent.Torrent_handle) bool {
+	if s.memoryPath == "" || torrentHandle == nil || torrentHandle.Is_valid() == false {
+		return false
+	}
+	return s.isMemoryPath(torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_save_path)).GetSave_path())
+}
+
+// memoryAheadPieces is how many pieces past where it's read a torrent in
+// memory downloads: half the buffer, so what was watched has room too. -1
+// for torrents on disk, which download the whole file.
+func (s *BTService) memoryAheadPieces(torrentHandle libtorrent.Torrent_handle, pieceLength int) int {
+	if pieceLength <= 0 || s.inMemory(torrentHandle) == false {
+		return -1
+	}
+	return int(s.Config().MemoryBufferSize / 2 / int64(pieceLength))
+}
+
+// memoryUsage returns how much the torrents in memory downloaded, and the
+// one that downloaded the most. What dropWatched freed is left out once
+// rechecked.
+func (s *BTService) memoryUsage() (int64, libtorrent.Torrent_handle) {
+	used := int64(0)
+	biggestSize := int64(-1)
+	var biggest libtorrent.Torrent_handle
+	if s.memoryPath == "" {
+		return used, biggest
+	}
+	// NB: this does NOT return a pointer to vector, no need to free!
+	torrentsVector := s.Session.Get_torrents()
+	for i := 0; i < int(torrentsVector.Size()); i++ {
+		torrentHandle := torrentsVector.Get(i)
+		if torrentHandle.Is_valid() == false {
+			continue
+		}
+		status := torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_save_path))
+		if s.isMemoryPath(status.GetSave_path()) == false {
+			continue
+		}
+		done := status.GetTotal_done()
+		used += done
+		if done > biggestSize {
+			biggest, biggestSize = torrentHandle, done
+		}
+	}
+	return used, biggest
+}
+
+// memoryMonitor spills the torrents in memory to the download path, the
+// biggest first, while they're over the memory buffer size.
+func (s *BTService) memoryMonitor() {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+		}
+		used, biggest := s.memoryUsage()
+		if used <= s.Config().MemoryBufferSize || biggest == nil {
+			continue
+		}
+		s.log.Info("%s buffered in memory, over %s", humanize.Bytes(uint64(used)), humanize.Bytes(uint64(s.Config().MemoryBufferSize)))
+		if s.dropWatched(biggest) {
+			continue
+		}
+		s.spillToDisk(biggest)
+	}
+}
+
+// dropWatched frees the memory of what the streams of the torrent played
+// already, but a quarter of the buffer right behind them, and has libtorrent
+// recheck the torrent so that it downloads them again if seeked back to.
+// Returns false when nothing more can be dropped, the torrent having to go to
+// disk then.
+func (s *BTService) dropWatched(torrentHandle libtorrent.Torrent_handle) bool {
+	keepBehind := s.Config().MemoryBufferSize / 4
+	dropped := false
+	s.streamsLock.Lock()
+	for btp := range s.streams {
+		if btp.torrentHandle == nil || btp.torrentHandle.Equal(torrentHandle) == false {
+			continue
+		}
+		path, _ := btp.VideoFile()
+		_, playbackOffset := btp.playback()
+		end := playbackOffset - keepBehind
+		if path == "" || end <= btp.memoryDropped {
+			continue
+		}
+		if err := punchHole(path, 0, end); err != nil {
+			s.log.Warning("Unable to free the memory of %s: %s", path, err)
+			break
+		}
+		s.log.Info("Freed the %s of %s watched already", humanize.Bytes(uint64(end)), path)
+		btp.memoryDropped = end
+		dropped = true
+	}
+	s.streamsLock.Unlock()
+	if dropped {
+		torrentHandle.Force_recheck()
+	}
+	return dropped
+}
+
+// spillToDisk moves a torrent out of memory. Its files being read are
+// switched to the moved ones, see moving.go.
+func (s *BTService) spillToDisk(torrentHandle libtorrent.Torrent_handle) {
+	s.moveFromStaging(torrentHandle)
+
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+	for btp := range s.streams {
+		if btp.torrentHandle != nil && btp.torrentHandle.Equal(torrentHandle) {
+			btp.savePath = s.Config().DownloadPath
+		}
+	}
+}
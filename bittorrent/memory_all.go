@@ -0,0 +1,9 @@
+// +build !linux
+
+package bittorrent
+
+import "errors"
+
+func punchHole(path string, offset int64, length int64) error {
+	return errors.New("punching holes is not supported")
+}
@@ -0,0 +1,25 @@
+// +build linux
+
+package bittorrent
+
+import (
+	"os"
+	"syscall"
+)
+
+// from <linux/falloc.h>
+const (
+	_FALLOC_FL_KEEP_SIZE  = 0x01
+	_FALLOC_FL_PUNCH_HOLE = 0x02
+)
+
+// punchHole frees the memory of a part of a file on a memory filesystem,
+// which then reads as zeroes.
+func punchHole(path string, offset int64, length int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return syscall.Fallocate(int(file.Fd()), _FALLOC_FL_PUNCH_HOLE|_FALLOC_FL_KEEP_SIZE, offset, length)
+}
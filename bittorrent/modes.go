@@ -0,0 +1,55 @@
+package bittorrent
+
+import (
+	"fmt"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+// Torrents started for playback are downloaded in order, with deadlines on
+// the pieces being played. Background downloads go rarest first, which is
+// better for the swarm.
+const (
+	ModeStreaming = "streaming"
+	ModeArchive   = "archive"
+)
+
+func setTorrentMode(torrentHandle libtorrent.Torrent_handle, mode string) error {
+	switch mode {
+	case ModeStreaming:
+		torrentHandle.Set_sequential_download(true)
+	case ModeArchive:
+		torrentHandle.Set_sequential_download(false)
+		torrentHandle.Clear_piece_deadlines()
+	default:
+		return fmt.Errorf("unknown torrent mode %s", mode)
+	}
+	return nil
+}
+
+func torrentMode(torrentHandle libtorrent.Torrent_handle) string {
+	if torrentHandle.Status(uint(0)).GetSequential_download() {
+		return ModeStreaming
+	}
+	return ModeArchive
+}
+
+func (s *BTService) TorrentMode(infoHash string) (string, error) {
+	torrentHandle, err := s.findTorrent(infoHash)
+	if err != nil {
+		return "", err
+	}
+	return torrentMode(torrentHandle), nil
+}
+
+func (s *BTService) SetTorrentMode(infoHash string, mode string) error {
+	torrentHandle, err := s.findTorrent(infoHash)
+	if err != nil {
+		return err
+	}
+	if err := setTorrentMode(torrentHandle, mode); err != nil {
+		return err
+	}
+	s.log.Info("Switched torrent %s to %s mode", infoHash, mode)
+	return nil
+}
@@ -0,0 +1,76 @@
+package bittorrent
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Files moved out of the staging path while they're played are served from
+// the staging path until the move is done, then from the download path. Once
+// the whole file is there, it's served without the torrent, which is removed
+// right after the move.
+
+// hasFilePieces tells whether all the pieces of the file are downloaded. Must
+// be called with piecesMx held.
+func (tf *TorrentFile) hasFilePieces() bool {
+	startPiece, endPiece := tf.filePieces()
+	for i := startPiece; i <= endPiece; i++ {
+		if tf.pieces.GetBit(i) == false {
+			return false
+		}
+	}
+	return true
+}
+
+func (tf *TorrentFile) isComplete() bool {
+	tf.piecesMx.RLock()
+	defer tf.piecesMx.RUnlock()
+	return tf.complete
+}
+
+func (tf *TorrentFile) readFile(data []byte) (int, error) {
+	tf.fileMx.RLock()
+	defer tf.fileMx.RUnlock()
+	return tf.File.Read(data)
+}
+
+func (tf *TorrentFile) seekFile(offset int64, whence int) (int64, error) {
+	tf.fileMx.RLock()
+	defer tf.fileMx.RUnlock()
+	return tf.File.Seek(offset, whence)
+}
+
+func (tf *TorrentFile) closeFile() error {
+	tf.fileMx.Lock()
+	defer tf.fileMx.Unlock()
+	return tf.File.Close()
+}
+
+// switchToMoved reopens the file where the torrent was moved, at the same
+// offset. Reads go on from the source file if that fails.
+func (tf *TorrentFile) switchToMoved() {
+	path := filepath.Join(tf.tfs.service.Config().DownloadPath, tf.fileEntry.GetPath())
+	moved, err := os.Open(path)
+	if err != nil {
+		tf.tfs.log.Error("Unable to open the moved file %s: %s", path, err)
+		return
+	}
+	if err := unlockFile(moved); err != nil {
+		tf.tfs.log.Error("Unable to unlock file because: %s", err)
+	}
+
+	tf.fileMx.Lock()
+	defer tf.fileMx.Unlock()
+	offset, err := tf.File.Seek(0, os.SEEK_CUR)
+	if err == nil {
+		_, err = moved.Seek(offset, os.SEEK_SET)
+	}
+	if err != nil {
+		tf.tfs.log.Error("Unable to switch to the moved file %s: %s", path, err)
+		moved.Close()
+		return
+	}
+	tf.tfs.log.Info("Now serving %s", path)
+	tf.File.Close()
+	tf.File = moved
+}
@@ -0,0 +1,48 @@
+package bittorrent
+
+import (
+	"github.com/steeve/libtorrent-go"
+	"github.com/steeve/pulsar/config"
+)
+
+func (tf *TorrentFile) bytesAvailable(piece int, from int, to int) bool {
+	if tf.hasPiece(piece) {
+		return true
+	}
+	return config.Get().ServePartialPieces && tf.blocksReceived(piece, from, to)
+}
+
+// blocksReceived tells whether the blocks holding the bytes [from, to) of a
+// piece that isn't complete yet are already written to disk. They are not
+// verified until the piece is complete, but big 4K pieces take a while to
+// complete and a bad block only means some glitch.
+func (tf *TorrentFile) blocksReceived(piece int, from int, to int) bool {
+	if to <= from {
+		return false
+	}
+	queue := libtorrent.NewStd_vector_partial_piece_info()
+	defer libtorrent.DeleteStd_vector_partial_piece_info(queue)
+
+	tf.torrentHandle.Get_download_queue(queue)
+	for i := 0; i < int(queue.Size()); i++ {
+		ppi := queue.Get(i)
+		if ppi.GetPiece_index() != piece {
+			continue
+		}
+		blocks := ppi.Blocks()
+		offset := 0
+		for j := 0; j < ppi.GetBlocks_in_piece(); j++ {
+			block := blocks.Getitem(j)
+			blockEnd := offset + int(block.GetBlock_size())
+			if blockEnd > from && offset < to && block.GetState() != libtorrent.Block_infoFinished {
+				return false
+			}
+			if blockEnd >= to {
+				return true
+			}
+			offset = blockEnd
+		}
+		return false
+	}
+	return false
+}
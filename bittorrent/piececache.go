@@ -0,0 +1,219 @@
+package bittorrent
+
+import (
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/libtorrent-go"
+)
+
+// PieceCache keeps the pieces of deleted torrents on disk, addressed by
+// their SHA1, so that streaming them again starts right away. The oldest
+// pieces are evicted when the cache grows over maxSize.
+type PieceCache struct {
+	path    string
+	maxSize int64
+	mu      sync.Mutex
+	log     *logging.Logger
+}
+
+func NewPieceCache(path string, maxSize int64) *PieceCache {
+	os.MkdirAll(path, 0777)
+	return &PieceCache{
+		path:    path,
+		maxSize: maxSize,
+		log:     logging.MustGetLogger("piececache"),
+	}
+}
+
+func pieceKey(torrentInfo libtorrent.Torrent_info, piece int) string {
+	return hex.EncodeToString([]byte(torrentInfo.Hash_for_piece(piece).To_string()))
+}
+
+func (pc *PieceCache) piecePath(key string) string {
+	return filepath.Join(pc.path, key[:2], key)
+}
+
+func (pc *PieceCache) Get(key string) ([]byte, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	path := pc.piecePath(key)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	// bump it in the LRU
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return data, true
+}
+
+func (pc *PieceCache) Put(key string, data []byte) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	path := pc.piecePath(key)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	os.MkdirAll(filepath.Dir(path), 0777)
+	return ioutil.WriteFile(path, data, 0666)
+}
+
+type cachedPiece struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+type byModTime []cachedPiece
+
+func (a byModTime) Len() int           { return len(a) }
+func (a byModTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byModTime) Less(i, j int) bool { return a[i].modTime.Before(a[j].modTime) }
+
+// Evicts the least recently used pieces until the cache fits in maxSize.
+func (pc *PieceCache) Trim() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pieces := []cachedPiece{}
+	totalSize := int64(0)
+	filepath.Walk(pc.path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		pieces = append(pieces, cachedPiece{path, info.Size(), info.ModTime()})
+		totalSize += info.Size()
+		return nil
+	})
+	if totalSize <= pc.maxSize {
+		return
+	}
+
+	sort.Sort(byModTime(pieces))
+	evicted := 0
+	for _, piece := range pieces {
+		if totalSize <= pc.maxSize {
+			break
+		}
+		if err := os.Remove(piece.path); err == nil {
+			totalSize -= piece.size
+			evicted++
+		}
+	}
+	pc.log.Info("Evicted %d pieces from the cache", evicted)
+}
+
+type pieceCopy struct {
+	key        string
+	fileOffset int64
+	length     int
+}
+
+// Copies the pieces of the biggest file that are fully contained in it
+// into the cache, from its start and no more than the cache holds. Pieces
+// overlapping other files are skipped. The copy runs in the background,
+// from the file opened before the torrent and its files are removed.
+func (btp *BTPlayer) storeCachedPieces() {
+	pc := btp.bts.pieceCache
+	if pc == nil || btp.torrentInfo == nil || btp.torrentInfo.Swigcptr() == 0 || btp.biggestFile == nil {
+		return
+	}
+
+	file, err := os.Open(filepath.Join(btp.savePath, btp.biggestFile.GetPath()))
+	if err != nil {
+		return
+	}
+
+	copies := make([]pieceCopy, 0)
+	size := int64(0)
+	btp.forEachFilePiece(func(piece int, fileOffset int64, length int) bool {
+		if size+int64(length) > pc.maxSize {
+			return false
+		}
+		if btp.torrentHandle.Have_piece(piece) {
+			copies = append(copies, pieceCopy{pieceKey(btp.torrentInfo, piece), fileOffset, length})
+			size += int64(length)
+		}
+		return true
+	})
+	if len(copies) == 0 {
+		file.Close()
+		return
+	}
+
+	go func() {
+		defer file.Close()
+		btp.log.Info("Storing %d pieces in the piece cache...", len(copies))
+		stored := 0
+		for _, c := range copies {
+			data := make([]byte, c.length)
+			if _, err := file.ReadAt(data, c.fileOffset); err != nil && err != io.EOF {
+				continue
+			}
+			if err := pc.Put(c.key, data); err != nil {
+				btp.log.Error("Unable to cache piece %s: %s", c.key, err)
+				continue
+			}
+			stored++
+		}
+		btp.log.Info("Stored %d pieces in the piece cache", stored)
+		pc.Trim()
+	}()
+}
+
+// Feeds the cached pieces of the biggest file to libtorrent, which will
+// verify and write them.
+func (btp *BTPlayer) restoreCachedPieces() {
+	pc := btp.bts.pieceCache
+	if pc == nil {
+		return
+	}
+
+	restored := 0
+	btp.forEachFilePiece(func(piece int, fileOffset int64, length int) bool {
+		select {
+		case <-btp.closing:
+			return false
+		default:
+		}
+		if data, ok := pc.Get(pieceKey(btp.torrentInfo, piece)); ok && len(data) == length {
+			btp.torrentHandle.Add_piece(piece, string(data), 0)
+			restored++
+		}
+		return true
+	})
+	if restored > 0 {
+		btp.log.Info("Restored %d pieces from the piece cache", restored)
+	}
+}
+
+// forEachFilePiece calls f for the pieces of the biggest file, in order,
+// until it returns false.
+func (btp *BTPlayer) forEachFilePiece(f func(piece int, fileOffset int64, length int) bool) {
+	pieceLength := int64(btp.torrentInfo.Piece_length())
+	fileStart := btp.biggestFile.GetOffset()
+	fileEnd := fileStart + btp.biggestFile.GetSize()
+	numPieces := btp.torrentInfo.Num_pieces()
+
+	startPiece, endPiece, _ := btp.getFilePiecesAndOffset(btp.biggestFile)
+	for piece := startPiece; piece <= endPiece && piece < numPieces; piece++ {
+		pieceStart := int64(piece) * pieceLength
+		length := int64(btp.torrentInfo.Piece_size(piece))
+		if pieceStart < fileStart || pieceStart+length > fileEnd {
+			continue
+		}
+		if f(piece, pieceStart-fileStart, int(length)) == false {
+			return
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -12,10 +13,12 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/op/go-logging"
 	"github.com/steeve/libtorrent-go"
+	"github.com/steeve/pulsar/analytics"
 	"github.com/steeve/pulsar/broadcast"
 	"github.com/steeve/pulsar/config"
 	"github.com/steeve/pulsar/diskusage"
 	"github.com/steeve/pulsar/ga"
+	"github.com/steeve/pulsar/util"
 	"github.com/steeve/pulsar/xbmc"
 )
 
@@ -24,6 +27,18 @@ const (
 	startBufferMinSize = 20 * 1024 * 1024 // 20m
 	endBufferSize      = 10 * 1024 * 1024 // 10m
 	playbackMaxWait    = 20 * time.Second
+	// how long a torrent that missed its start budget keeps downloading, in
+	// case the fallbacks do worse
+	backupLinger = 60 * time.Second
+
+	slowStorageBufferFactor = 2
+)
+
+var (
+	ErrNotEnoughSpace  = errors.New("Not enough space on download destination.")
+	ErrBufferCanceled  = errors.New("user canceled the buffering")
+	ErrPlaybackTimeout = errors.New("Playback was unable to start before timeout.")
+	ErrStartBudget     = errors.New("buffering took longer than the start budget")
 )
 
 var statusStrings = []string{
@@ -49,10 +64,33 @@ type BTPlayer struct {
 	bufferPiecesProgressLock sync.RWMutex
 	dialogProgress           *xbmc.DialogProgress
 	torrentName              string
+	savePath                 string
 	deleteAfter              bool
 	diskStatus               *diskusage.DiskStatus
 	closing                  chan interface{}
 	bufferEvents             *broadcast.Broadcaster
+	streamEvents             *broadcast.Broadcaster
+	bitrate                  float64
+	playbackOffset           int64
+	playbackLock             sync.RWMutex // bitrate, playbackOffset and biggestFile once set
+	memoryDropped            int64
+	underrunWarned           bool
+	anime                    bool
+	crcChecked               bool
+	markers                  []*SkipMarker
+	markersLock              sync.RWMutex
+	stats                    *PlayerStats
+	statsLock                sync.RWMutex
+	overlay                  *xbmc.Overlay
+	noOverlay                bool
+	startBudget              time.Duration
+	episode                  *EpisodeRef
+	episodeFileIndex         int
+	fileIndex                int
+	requestedFile            int
+	prebuffering             bool
+	// the goroutines using torrentInfo, which Close waits for
+	background sync.WaitGroup
 }
 
 func NewBTPlayer(bts *BTService, uri string, deleteAfter bool) *BTPlayer {
@@ -63,30 +101,63 @@ func NewBTPlayer(bts *BTService, uri string, deleteAfter bool) *BTPlayer {
 		deleteAfter:          deleteAfter,
 		closing:              make(chan interface{}),
 		bufferEvents:         broadcast.NewBroadcaster(),
+		streamEvents:         broadcast.NewBroadcaster(),
 		bufferPiecesProgress: map[int]float64{},
+		episodeFileIndex:     -1,
+		fileIndex:            -1,
+		requestedFile:        -1,
 	}
 	return btp
 }
 
+// SetStartBudget makes Buffer give up with ErrStartBudget if the buffer
+// isn't full after budget. The torrent keeps downloading for a while after
+// that, as a backup.
+func (btp *BTPlayer) SetStartBudget(budget time.Duration) {
+	btp.startBudget = budget
+}
+
 func (btp *BTPlayer) addTorrent() error {
 	btp.log.Info("Adding torrent")
+	if btp.bts.Suspended() {
+		return ErrSuspended
+	}
+	if btp.bts.IsQuarantined(ExtractInfoHash(btp.uri)) {
+		return ErrQuarantined
+	}
 
-	if status, err := diskusage.DiskUsage(btp.bts.config.DownloadPath); err != nil {
-		btp.bts.log.Info("Unable to retrieve the free space for %s, continuing anyway...", btp.bts.config.DownloadPath)
+	if status, err := diskusage.DiskUsage(btp.bts.Config().DownloadPath); err != nil {
+		btp.bts.log.Info("Unable to retrieve the free space for %s, continuing anyway...", btp.bts.Config().DownloadPath)
 	} else {
 		btp.diskStatus = status
 	}
 
+	if err := btp.bts.acquireLease(ExtractInfoHash(btp.uri)); err != nil {
+		return err
+	}
+
 	torrentParams := libtorrent.NewAdd_torrent_params()
 	defer libtorrent.DeleteAdd_torrent_params(torrentParams)
 
 	torrentParams.SetUrl(btp.uri)
 
-	btp.log.Info("Setting save path to %s\n", btp.bts.config.DownloadPath)
-	torrentParams.SetSave_path(btp.bts.config.DownloadPath)
+	btp.savePath = btp.bts.streamSavePath()
+	btp.log.Info("Setting save path to %s\n", btp.savePath)
+	torrentParams.SetSave_path(btp.savePath)
+	if btp.bts.IsSlowStorage() || btp.bts.isMemoryPath(btp.savePath) {
+		// don't preallocate files on slow storage, nor in memory
+		torrentParams.SetStorage_mode(libtorrent.Storage_mode_sparse)
+	}
+	if resumeData := btp.bts.setResumeData(torrentParams, ExtractInfoHash(btp.uri)); resumeData != nil {
+		defer libtorrent.DeleteStd_vector_char(resumeData)
+	}
 
 	btp.torrentHandle = btp.bts.Session.Add_torrent(torrentParams)
+	if btp.torrentHandle != nil {
+		btp.bts.unseed(btp.torrentHandle)
+	}
 	go btp.consumeAlerts()
+	btp.bts.addStream(btp)
 
 	status := btp.torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_name))
 
@@ -95,9 +166,10 @@ func (btp *BTPlayer) addTorrent() error {
 	if btp.torrentHandle == nil {
 		return fmt.Errorf("unable to add torrent with uri %s", btp.uri)
 	}
+	btp.bts.applyTorrentRateLimits(btp.torrentHandle)
 
-	btp.log.Info("Enabling sequential download")
-	btp.torrentHandle.Set_sequential_download(true)
+	btp.log.Info("Enabling streaming mode")
+	setTorrentMode(btp.torrentHandle, ModeStreaming)
 
 	btp.log.Info("Downloading %s\n", btp.torrentName)
 
@@ -127,10 +199,30 @@ func (btp *BTPlayer) Buffer() error {
 	return nil
 }
 
+// SetFile makes the player stream this file of the torrent, the one played
+// the last time, rather than guess it again.
+func (btp *BTPlayer) SetFile(index int) {
+	btp.requestedFile = index
+}
+
+// FileIndex is the index of the streamed file in the torrent, -1 until the
+// metadata is there.
+func (btp *BTPlayer) FileIndex() int {
+	return btp.fileIndex
+}
+
 func (btp *BTPlayer) PlayURL() string {
 	return strings.Join(strings.Split(btp.biggestFile.GetPath(), string(os.PathSeparator)), "/")
 }
 
+// VideoFile returns where the played file is on disk, and its size.
+func (btp *BTPlayer) VideoFile() (string, int64) {
+	if btp.biggestFile == nil {
+		return "", 0
+	}
+	return filepath.Join(btp.savePath, btp.biggestFile.GetPath()), btp.biggestFile.GetSize()
+}
+
 func (btp *BTPlayer) onMetadataReceived() {
 	btp.log.Info("Metadata received.")
 
@@ -143,17 +235,37 @@ func (btp *BTPlayer) onMetadataReceived() {
 	btp.torrentInfo = btp.torrentHandle.Torrent_file()
 
 	if btp.diskStatus != nil {
-		btp.log.Info("Checking for sufficient space on %s...", btp.bts.config.DownloadPath)
+		btp.log.Info("Checking for sufficient space on %s...", btp.bts.Config().DownloadPath)
 		torrentSize := btp.torrentInfo.Total_size()
 		if btp.diskStatus.Free < torrentSize {
-			btp.log.Info("Unsufficient free space on %s. Has %d, needs %d.", btp.bts.config.DownloadPath, btp.diskStatus.Free, torrentSize)
+			btp.log.Info("Unsufficient free space on %s. Has %d, needs %d.", btp.bts.Config().DownloadPath, btp.diskStatus.Free, torrentSize)
 			xbmc.Notify("Pulsar", "Not enough space available on the download path.", config.AddonIcon())
-			btp.bufferEvents.Broadcast(errors.New("Not enough space on download destination."))
+			btp.bufferEvents.Broadcast(ErrNotEnoughSpace)
 			return
 		}
 	}
 
-	btp.biggestFile = btp.findBiggestFile()
+	biggestFile, fileIndex := btp.findBiggestFile()
+	numFiles := btp.torrentInfo.Num_files()
+	if btp.requestedFile >= 0 && btp.requestedFile < numFiles && numFiles > 1 {
+		biggestFile = btp.torrentInfo.File_at(btp.requestedFile)
+		btp.log.Info("Streaming the file played before, %s", biggestFile.GetPath())
+		fileIndex = btp.requestedFile
+		btp.episodeFileIndex = btp.requestedFile
+		btp.prioritizeEpisodeFile()
+	} else if btp.episode != nil && numFiles > 1 {
+		if episodeFile, index := btp.findEpisodeFile(); index >= 0 {
+			btp.log.Info("Season pack, streaming episode file %s", episodeFile.GetPath())
+			biggestFile = episodeFile
+			fileIndex = index
+			btp.episodeFileIndex = index
+			btp.prioritizeEpisodeFile()
+		}
+	}
+	// the fairness scheduler reads it
+	btp.playbackLock.Lock()
+	btp.biggestFile, btp.fileIndex = biggestFile, fileIndex
+	btp.playbackLock.Unlock()
 	btp.log.Info("Biggest file: %s", btp.biggestFile.GetPath())
 
 	btp.log.Info("Setting piece priorities")
@@ -162,9 +274,20 @@ func (btp *BTPlayer) onMetadataReceived() {
 
 	startPiece, endPiece, _ := btp.getFilePiecesAndOffset(btp.biggestFile)
 
+	// the file usually tells the quality better than the torrent name
+	resolution := ResolutionFromName(btp.biggestFile.GetPath())
+	if resolution == ResolutionUnkown {
+		resolution = ResolutionFromName(btp.torrentName)
+	}
+	preplaySize := float64(preplayBufferSize(resolution))
+	btp.log.Info("Using a %s preplay buffer for %s quality", humanize.Bytes(uint64(preplaySize)), tierName(resolution))
+
 	startLength := float64(endPiece-startPiece) * float64(pieceLength) * startBufferPercent
-	if startLength < startBufferMinSize {
-		startLength = startBufferMinSize
+	if startLength < preplaySize {
+		startLength = preplaySize
+	}
+	if btp.bts.IsSlowStorage() {
+		startLength *= slowStorageBufferFactor
 	}
 	startBufferPieces := int(math.Ceil(startLength / pieceLength))
 
@@ -186,30 +309,47 @@ func (btp *BTPlayer) onMetadataReceived() {
 	for _ = 0; curPiece < startPiece+startBufferPieces; curPiece++ { // get this part
 		piecesPriorities.Add(1)
 		btp.bufferPiecesProgress[curPiece] = 0
-		btp.torrentHandle.Set_piece_deadline(curPiece, 0, 0)
+		btp.setBufferDeadline(curPiece)
+	}
+	// in memory, the readers download what's next, see prioritizeReaders
+	middlePriority := 1
+	if btp.bts.isMemoryPath(btp.savePath) {
+		middlePriority = 0
 	}
 	for _ = 0; curPiece < endPiece-endBufferPieces; curPiece++ {
-		piecesPriorities.Add(1)
+		piecesPriorities.Add(middlePriority)
 	}
 	for _ = 0; curPiece <= endPiece; curPiece++ { // get this part
 		piecesPriorities.Add(7)
 		btp.bufferPiecesProgress[curPiece] = 0
-		btp.torrentHandle.Set_piece_deadline(curPiece, 0, 0)
+		btp.setBufferDeadline(curPiece)
 	}
 	numPieces := btp.torrentInfo.Num_pieces()
 	for _ = 0; curPiece < numPieces; curPiece++ {
 		piecesPriorities.Add(0)
 	}
 	btp.torrentHandle.Prioritize_pieces(piecesPriorities)
+
+	btp.background.Add(1)
+	go func() {
+		defer btp.background.Done()
+		btp.restoreCachedPieces()
+	}()
 }
 
 func (btp *BTPlayer) statusStrings(progress float64, status libtorrent.Torrent_status) (string, string, string) {
 	line1 := fmt.Sprintf("%s (%.2f%%)", statusStrings[int(status.GetState())], progress*100)
-	if btp.torrentInfo != nil && btp.torrentInfo.Swigcptr() != 0 {
-		line1 += " - " + humanize.Bytes(uint64(btp.torrentInfo.Total_size()))
+	if btp.hasMetadata() {
+		line1 += " - " + util.FormatSize(btp.torrentInfo.Total_size())
+	} else {
+		line1 += fmt.Sprintf(" - Fetching metadata, %d DHT nodes", btp.bts.DHTNodes())
 	}
-	line2 := fmt.Sprintf("%.0fkb/s S:%d/%d P:%d/%d",
-		float64(status.GetDownload_rate())/1024,
+	format := "%s S:%d/%d P:%d/%d"
+	if xbmc.TextOnly() {
+		format = "%s, %d of %d seeds, %d of %d peers"
+	}
+	line2 := fmt.Sprintf(format,
+		util.FormatSpeed(int64(status.GetDownload_rate())),
 		status.GetNum_seeds(),
 		status.GetNum_complete(),
 		status.GetNum_peers(),
@@ -232,8 +372,9 @@ func (btp *BTPlayer) getFilePiecesAndOffset(fe libtorrent.File_entry) (int, int,
 	return startPiece, endPiece, offset
 }
 
-func (btp *BTPlayer) findBiggestFile() libtorrent.File_entry {
+func (btp *BTPlayer) findBiggestFile() (libtorrent.File_entry, int) {
 	var biggestFile libtorrent.File_entry
+	index := -1
 	maxSize := int64(0)
 	numFiles := btp.torrentInfo.Num_files()
 
@@ -243,39 +384,85 @@ func (btp *BTPlayer) findBiggestFile() libtorrent.File_entry {
 		if size > maxSize {
 			maxSize = size
 			biggestFile = fe
+			index = i
 		}
 	}
-	return biggestFile
+	return biggestFile, index
 }
 
 func (btp *BTPlayer) onStateChanged(stateAlert libtorrent.State_changed_alert) {
 	switch stateAlert.GetState() {
 	case libtorrent.Torrent_statusFinished:
+		if btp.bts.inMemory(btp.torrentHandle) {
+			// not the whole file in memory, the readers move what's wanted
+			break
+		}
 		btp.log.Info("Buffer is finished, resetting piece priorities...")
 		piecesPriorities := libtorrent.NewStd_vector_int()
 		defer libtorrent.DeleteStd_vector_int(piecesPriorities)
 		numPieces := btp.torrentInfo.Num_pieces()
+		startPiece, endPiece := 0, numPieces-1
+		if btp.episodeFileIndex >= 0 {
+			// the rest of a season pack isn't wanted
+			startPiece, endPiece, _ = btp.getFilePiecesAndOffset(btp.biggestFile)
+		}
 		for i := 0; i < numPieces; i++ {
-			piecesPriorities.Add(1)
+			if i >= startPiece && i <= endPiece {
+				piecesPriorities.Add(1)
+			} else {
+				piecesPriorities.Add(0)
+			}
 		}
 		btp.torrentHandle.Prioritize_pieces(piecesPriorities)
 		break
 	}
 }
 
+// onFinished is told when the wanted pieces are all there, the buffer's
+// first, then the whole file's once the priorities are reset.
+func (btp *BTPlayer) onFinished() {
+	if btp.anime == false || config.Get().AnimeVerifyCRC == false || btp.crcChecked {
+		return
+	}
+	if btp.biggestFile == nil || btp.bts.inMemory(btp.torrentHandle) || btp.fileComplete() == false {
+		return
+	}
+	// the file entry goes away with the player, the check may not
+	btp.crcChecked = true
+	go btp.verifyCRC(filepath.Join(btp.savePath, btp.biggestFile.GetPath()))
+}
+
 func (btp *BTPlayer) Close() {
 	close(btp.closing)
+	btp.bts.removeStream(btp)
+	btp.streamEvents.Close()
 
+	if btp.deleteAfter {
+		btp.storeCachedPieces()
+	}
+
+	btp.background.Wait()
 	if btp.torrentInfo != nil && btp.torrentInfo.Swigcptr() != 0 {
 		libtorrent.DeleteTorrent_info(btp.torrentInfo)
 	}
 
+	if btp.bts.torrentInUse(btp) {
+		btp.log.Info("Torrent is played by another stream, keeping it")
+		return
+	}
+	if btp.bts.startSeeding(btp) {
+		return
+	}
+	btp.bts.forgetResumeData(infoHashOf(btp.torrentHandle))
 	if btp.deleteAfter {
 		btp.log.Info("Removing the torrent and deleting files...")
 		btp.bts.Session.Remove_torrent(btp.torrentHandle, int(libtorrent.SessionDelete_files))
 	} else {
+		btp.bts.moveFromStaging(btp.torrentHandle)
+		btp.bts.keepStream(btp.torrentHandle)
 		btp.log.Info("Removing the torrent without deleting files...")
 		btp.bts.Session.Remove_torrent(btp.torrentHandle, 0)
+		go btp.bts.EnforceRetention()
 	}
 }
 
@@ -302,6 +489,12 @@ func (btp *BTPlayer) consumeAlerts() {
 					btp.onStateChanged(stateAlert)
 				}
 				break
+			case libtorrent.Torrent_finished_alertAlert_type:
+				finishedAlert := libtorrent.SwigcptrTorrent_alert(alert.Swigcptr())
+				if finishedAlert.GetHandle().Equal(btp.torrentHandle) {
+					btp.onFinished()
+				}
+				break
 			}
 		case <-btp.closing:
 			return
@@ -343,14 +536,36 @@ func (btp *BTPlayer) bufferDialog() {
 	defer halfSecond.Stop()
 	oneSecond := time.NewTicker(1 * time.Second)
 	defer oneSecond.Stop()
+	var budget <-chan time.Time
+	if btp.startBudget > 0 {
+		budget = time.After(btp.startBudget)
+	}
+	metadataTimeout := time.After(btp.bts.metadataTimeout())
+	started := time.Now()
 
 	for {
 		select {
+		case <-metadataTimeout:
+			if btp.hasMetadata() == false {
+				btp.log.Info("No metadata after %s, giving up", btp.bts.metadataTimeout())
+				btp.bufferEvents.Broadcast(ErrMetadataTimeout)
+				return
+			}
+			metadataTimeout = nil
+		case <-budget:
+			btp.log.Info("Buffering didn't finish within %s", btp.startBudget)
+			btp.bufferEvents.Broadcast(ErrStartBudget)
+			return
 		case <-halfSecond.C:
+			if btp.hasMetadata() && btp.bts.IsQuarantined(infoHashOf(btp.torrentHandle)) {
+				btp.log.Info("Quarantined while buffering, giving up")
+				btp.bufferEvents.Broadcast(ErrQuarantined)
+				return
+			}
 			if btp.dialogProgress.IsCanceled() {
 				btp.log.Info("User cancelled the buffering")
 				go ga.TrackEvent("player", "buffer_canceled", btp.torrentName, -1)
-				btp.bufferEvents.Broadcast(errors.New("user canceled the buffering"))
+				btp.bufferEvents.Broadcast(ErrBufferCanceled)
 				return
 			}
 		case <-oneSecond.C:
@@ -366,9 +581,11 @@ func (btp *BTPlayer) bufferDialog() {
 			}
 			btp.bufferPiecesProgressLock.Unlock()
 			status := btp.torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_name))
+			btp.collectStats(status, true, bufferProgress)
 			line1, line2, line3 := btp.statusStrings(bufferProgress, status)
 			btp.dialogProgress.Update(int(bufferProgress*100.0), line1, line2, line3)
 			if bufferProgress >= 1 {
+				BufferTimes.Observe(time.Now().Sub(started).Seconds())
 				btp.bufferEvents.Signal()
 				return
 			}
@@ -389,11 +606,20 @@ func (btp *BTPlayer) playerLoop() {
 	go btp.bufferDialog()
 
 	if err := <-buffered; err != nil {
+		if err == ErrStartBudget {
+			btp.log.Info("Keeping the torrent downloading for %s as a backup", backupLinger)
+			select {
+			case <-time.After(backupLinger):
+			case <-btp.bts.closing:
+			}
+		}
 		return
 	}
 
 	ga.TrackTiming("player", "buffer_time_real", int(time.Now().Sub(start).Seconds()*1000), "")
 
+	go btp.readSkipMarkers()
+
 	btp.log.Info("Waiting for playback...")
 	oneSecond := time.NewTicker(1 * time.Second)
 	defer oneSecond.Stop()
@@ -406,7 +632,7 @@ playbackWaitLoop:
 		select {
 		case <-playbackTimeout:
 			btp.log.Info("Playback was unable to start after %d seconds. Aborting...", playbackMaxWait)
-			btp.bufferEvents.Broadcast(errors.New("Playback was unable to start before timeout."))
+			btp.bufferEvents.Broadcast(ErrPlaybackTimeout)
 			return
 		case <-oneSecond.C:
 			ga.TrackEvent("player", "waiting_playback", btp.torrentName, -1)
@@ -414,10 +640,20 @@ playbackWaitLoop:
 	}
 
 	ga.TrackTiming("player", "buffer_time_perceived", int(time.Now().Sub(start).Seconds()*1000), "")
+	analytics.RecordPlay(time.Now().Sub(start))
+
+	if err := btp.applyLanguagePreferences(); err != nil {
+		btp.log.Info("Unable to read tracks: %s", err)
+	}
 
 	btp.log.Info("Playback loop")
 	playingTicker := time.NewTicker(60 * time.Second)
 	defer playingTicker.Stop()
+	underrunTicker := time.NewTicker(underrunCheckInterval)
+	defer underrunTicker.Stop()
+	statsTicker := time.NewTicker(statsInterval)
+	defer statsTicker.Stop()
+	defer btp.closeOverlay()
 playbackLoop:
 	for {
 		if xbmc.PlayerIsPlaying() == false {
@@ -426,6 +662,11 @@ playbackLoop:
 		select {
 		case <-playingTicker.C:
 			ga.TrackEvent("player", "playing", btp.torrentName, -1)
+		case <-underrunTicker.C:
+			btp.checkUnderrun()
+		case <-statsTicker.C:
+			btp.collectStats(btp.torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_name)), false, 0)
+			btp.showStats()
 		case <-oneSecond.C:
 		}
 	}
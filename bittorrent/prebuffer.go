@@ -0,0 +1,61 @@
+package bittorrent
+
+import (
+	"time"
+)
+
+// prebuffers get at least this share of the bandwidth, even when the
+// streams need it all
+const prebufferMinShare = 0.1
+
+// Prebuffer adds the torrent and downloads the start of its file in the
+// background, with what's left of the bandwidth once the playing streams
+// have what they need. A player of the same torrent then starts right away.
+func (btp *BTPlayer) Prebuffer() error {
+	btp.prebuffering = true
+	return btp.addTorrent()
+}
+
+// Release closes the prebuffering player once another player took over its
+// torrent, or after timeout, along with the torrent then.
+func (btp *BTPlayer) Release(timeout time.Duration) {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for btp.bts.torrentInUse(btp) == false {
+		select {
+		case <-deadline:
+			btp.log.Info("%s wasn't played, dropping it", btp.torrentName)
+			btp.Close()
+			return
+		case <-ticker.C:
+		case <-btp.bts.closing:
+			return
+		}
+	}
+	btp.Close()
+}
+
+// Prebuffers have no deadline, they would be served before the playing
+// streams.
+func (btp *BTPlayer) setBufferDeadline(piece int) {
+	if btp.prebuffering == false {
+		btp.torrentHandle.Set_piece_deadline(piece, 0, 0)
+	}
+}
+
+// limitPrebuffers shares among the prebuffers the bandwidth the streams
+// don't need. Must be called with streamsLock held.
+func (s *BTService) limitPrebuffers(prebuffers []*BTPlayer, capacity float64, streamsNeed float64) {
+	spare := capacity - streamsNeed*fairnessHeadroom
+	if spare < capacity*prebufferMinShare {
+		spare = capacity * prebufferMinShare
+	}
+	for _, btp := range prebuffers {
+		if btp.torrentHandle == nil {
+			continue
+		}
+		s.setTorrentDownloadLimit(btp.torrentHandle, int(spare)/len(prebuffers))
+		btp.log.Debug("Prebuffering %s at %dkb/s", btp.torrentName, int(spare)/len(prebuffers)/1024)
+	}
+}
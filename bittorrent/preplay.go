@@ -0,0 +1,48 @@
+package bittorrent
+
+import (
+	"strings"
+
+	"github.com/steeve/pulsar/config"
+)
+
+// Preplay buffer sizes by quality tier: higher bitrates need more in the
+// buffer to not stall right after the start. Unknown qualities get the
+// previous fixed size.
+var defaultPreplayBufferSizes = map[int]int64{
+	ResolutionUnkown: startBufferMinSize,
+	Resolution480p:   5 * 1024 * 1024,
+	Resolution720p:   10 * 1024 * 1024,
+	Resolution1080p:  20 * 1024 * 1024,
+	Resolution1440p:  30 * 1024 * 1024,
+	Resolution4k2k:   40 * 1024 * 1024,
+}
+
+// ResolutionFromName detects the quality tier of a torrent or file name.
+func ResolutionFromName(name string) int {
+	lowName := strings.ToLower(name)
+	resolution := ResolutionUnkown
+	for re, value := range resolutionTags {
+		if re.MatchString(lowName) && value > resolution {
+			resolution = value
+		}
+	}
+	return resolution
+}
+
+func tierName(resolution int) string {
+	if resolution == ResolutionUnkown {
+		return "unknown"
+	}
+	return Resolutions[resolution]
+}
+
+// preplayBufferSize returns how many bytes to buffer before playing a file
+// of the given resolution. The "preplay_buffer_sizes" setting overrides the
+// defaults per tier, in megabytes, e.g. "720p=15,4k=60".
+func preplayBufferSize(resolution int) int64 {
+	if size, ok := config.Get().PreplayBufferSizes[tierName(resolution)]; ok && size > 0 {
+		return int64(size) * 1024 * 1024
+	}
+	return defaultPreplayBufferSizes[resolution]
+}
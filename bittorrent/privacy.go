@@ -0,0 +1,118 @@
+package bittorrent
+
+import (
+	"fmt"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+// Encryption policies with peers
+const (
+	EncryptionForced   = "forced"
+	EncryptionEnabled  = "enabled"
+	EncryptionDisabled = "disabled"
+)
+
+// Transports peers are connected over
+const (
+	TransportPreferTCP = "prefer_tcp"
+	TransportMixed     = "mixed" // both, in proportion of the peers using them
+	TransportTCPOnly   = "tcp"
+	TransportUTPOnly   = "utp"
+)
+
+// PrivacySettings are how the session shows itself to peers and trackers.
+// Anonymous mode hides the client name, version and listen port.
+type PrivacySettings struct {
+	Encryption    string `json:"encryption"`
+	AnonymousMode bool   `json:"anonymous_mode"`
+	Transport     string `json:"transport"`
+}
+
+func (settings *PrivacySettings) validate() error {
+	switch settings.Encryption {
+	case EncryptionForced, EncryptionEnabled, EncryptionDisabled:
+	default:
+		return fmt.Errorf("unknown encryption policy %s", settings.Encryption)
+	}
+	switch settings.Transport {
+	case TransportPreferTCP, TransportMixed, TransportTCPOnly, TransportUTPOnly:
+	default:
+		return fmt.Errorf("unknown transport %s", settings.Transport)
+	}
+	return nil
+}
+
+// Privacy are the settings in effect: the ones set through the API, else
+// the settings.
+func (s *BTService) Privacy() PrivacySettings {
+	s.privacyLock.Lock()
+	defer s.privacyLock.Unlock()
+	if s.privacyOverride != nil {
+		return *s.privacyOverride
+	}
+	privacy := PrivacySettings{
+		Encryption:    s.Config().Encryption,
+		AnonymousMode: s.Config().AnonymousMode,
+		Transport:     s.Config().Transport,
+	}
+	if privacy.Encryption == "" {
+		privacy.Encryption = EncryptionForced
+	}
+	if privacy.Transport == "" {
+		privacy.Transport = TransportPreferTCP
+	}
+	return privacy
+}
+
+// SetPrivacy overrides the settings until restart, nil going back to them.
+func (s *BTService) SetPrivacy(privacy *PrivacySettings) error {
+	if privacy != nil {
+		if err := privacy.validate(); err != nil {
+			return err
+		}
+	}
+	s.privacyLock.Lock()
+	s.privacyOverride = privacy
+	s.privacyLock.Unlock()
+
+	s.updateSettings(s.setPrivacySettings)
+	s.applyEncryption()
+	return nil
+}
+
+func (s *BTService) setPrivacySettings(settings libtorrent.Session_settings) {
+	privacy := s.Privacy()
+	s.log.Info("Anonymous mode: %v, peers over %s", privacy.AnonymousMode, privacy.Transport)
+	settings.SetAnonymous_mode(privacy.AnonymousMode)
+
+	utp := privacy.Transport != TransportTCPOnly
+	tcp := privacy.Transport != TransportUTPOnly
+	settings.SetEnable_outgoing_utp(utp)
+	settings.SetEnable_incoming_utp(utp)
+	settings.SetEnable_outgoing_tcp(tcp)
+	settings.SetEnable_incoming_tcp(tcp)
+	if privacy.Transport == TransportMixed {
+		settings.SetMixed_mode_algorithm(int(libtorrent.Session_settingsPeer_proportional))
+	} else {
+		settings.SetMixed_mode_algorithm(int(libtorrent.Session_settingsPrefer_tcp))
+	}
+}
+
+func (s *BTService) applyEncryption() {
+	policy := byte(libtorrent.Pe_settingsForced)
+	switch s.Privacy().Encryption {
+	case EncryptionEnabled:
+		policy = byte(libtorrent.Pe_settingsEnabled)
+	case EncryptionDisabled:
+		policy = byte(libtorrent.Pe_settingsDisabled)
+	}
+	s.log.Info("Setting Encryption settings...")
+	encryptionSettings := libtorrent.NewPe_settings()
+	defer libtorrent.DeletePe_settings(encryptionSettings)
+	encryptionSettings.SetOut_enc_policy(policy)
+	encryptionSettings.SetIn_enc_policy(policy)
+	encryptionSettings.SetAllowed_enc_level(byte(libtorrent.Pe_settingsBoth))
+	encryptionSettings.SetPrefer_rc4(true)
+	s.Session.Set_pe_settings(encryptionSettings)
+}
@@ -0,0 +1,235 @@
+package bittorrent
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/events"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// Pieces failing their hash check are downloaded again, forever if the data
+// keeps being bad. The peers that were sending a failed piece are suspects,
+// and banned once they were in peerBanFailures failures. Torrents with
+// quarantineFailures failed pieces, or a piece failing pieceFailures times,
+// are quarantined: paused, refused from then on, and their stream giving
+// up with ErrQuarantined for the next result.
+
+const (
+	peerBanFailures    = 3
+	pieceFailures      = 5
+	quarantineFailures = 50
+)
+
+var (
+	ErrQuarantined    = errors.New("the torrent keeps failing verification")
+	ErrNotQuarantined = errors.New("the torrent is not quarantined")
+)
+
+// Quarantine is a torrent that failed verification too often.
+type Quarantine struct {
+	InfoHash string    `json:"info_hash"`
+	Name     string    `json:"name"`
+	Reason   string    `json:"reason"`
+	Time     time.Time `json:"time"`
+}
+
+type hashFailures struct {
+	total  int
+	pieces map[int]int
+}
+
+func (s *BTService) loadQuarantine() {
+	s.quarantineLock.Lock()
+	defer s.quarantineLock.Unlock()
+	s.quarantine = make(map[string]*Quarantine)
+	if s.Config().QuarantinePath == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(s.Config().QuarantinePath)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &s.quarantine); err != nil {
+		s.log.Error("Unable to read the quarantined torrents: %s", err)
+	}
+}
+
+// Must be called with quarantineLock held.
+func (s *BTService) saveQuarantine() {
+	if s.Config().QuarantinePath == "" {
+		return
+	}
+	data, err := json.Marshal(s.quarantine)
+	if err != nil {
+		s.log.Error("Unable to save the quarantined torrents: %s", err)
+		return
+	}
+	if err := writeFileAtomic(s.Config().QuarantinePath, data); err != nil {
+		s.log.Error("Unable to save the quarantined torrents: %s", err)
+	}
+}
+
+func (s *BTService) IsQuarantined(infoHash string) bool {
+	s.quarantineLock.Lock()
+	defer s.quarantineLock.Unlock()
+	_, ok := s.quarantine[strings.ToLower(infoHash)]
+	return ok
+}
+
+type byQuarantineTime []*Quarantine
+
+func (a byQuarantineTime) Len() int           { return len(a) }
+func (a byQuarantineTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byQuarantineTime) Less(i, j int) bool { return a[i].Time.After(a[j].Time) }
+
+// Quarantined lists the quarantined torrents, the most recent first.
+func (s *BTService) Quarantined() []*Quarantine {
+	s.quarantineLock.Lock()
+	defer s.quarantineLock.Unlock()
+	list := make([]*Quarantine, 0, len(s.quarantine))
+	for _, quarantine := range s.quarantine {
+		list = append(list, quarantine)
+	}
+	sort.Sort(byQuarantineTime(list))
+	return list
+}
+
+// ReleaseQuarantine lets the torrent be added again, its failures
+// forgotten. Its paused handle, if still there, resumes.
+func (s *BTService) ReleaseQuarantine(infoHash string) error {
+	infoHash = strings.ToLower(infoHash)
+	s.quarantineLock.Lock()
+	if _, ok := s.quarantine[infoHash]; ok == false {
+		s.quarantineLock.Unlock()
+		return ErrNotQuarantined
+	}
+	delete(s.quarantine, infoHash)
+	delete(s.hashFailures, infoHash)
+	s.saveQuarantine()
+	s.quarantineLock.Unlock()
+
+	if torrentHandle, err := s.findTorrent(infoHash); err == nil {
+		torrentHandle.Resume()
+	}
+	return nil
+}
+
+// hashFailureMonitor follows the failed pieces of all the torrents.
+func (s *BTService) hashFailureMonitor() {
+	alerts, done := s.Alerts()
+	defer close(done)
+	for alert := range alerts {
+		switch alert.Xtype() {
+		case libtorrent.Hash_failed_alertAlert_type:
+			hashAlert := libtorrent.SwigcptrHash_failed_alert(alert.Swigcptr())
+			s.onHashFailed(hashAlert.GetHandle(), hashAlert.GetPiece_index())
+		case libtorrent.Torrent_removed_alertAlert_type:
+			removedAlert := libtorrent.SwigcptrTorrent_removed_alert(alert.Swigcptr())
+			s.quarantineLock.Lock()
+			delete(s.hashFailures, hex.EncodeToString([]byte(removedAlert.GetInfo_hash().To_string())))
+			s.quarantineLock.Unlock()
+		}
+	}
+}
+
+// suspects are the peers downloading the piece when it failed, which the
+// bad blocks most likely came from.
+func suspects(torrentHandle libtorrent.Torrent_handle, piece int) []string {
+	peers := libtorrent.NewStd_vector_peer_info()
+	defer libtorrent.DeleteStd_vector_peer_info(peers)
+	torrentHandle.Get_peer_info(peers)
+	ips := make([]string, 0)
+	for i := 0; i < int(peers.Size()); i++ {
+		peer := peers.Get(i)
+		if peer.GetDownloading_piece_index() == piece {
+			ips = append(ips, peer.GetIp().Address().To_string())
+		}
+	}
+	return ips
+}
+
+func (s *BTService) onHashFailed(torrentHandle libtorrent.Torrent_handle, piece int) {
+	if torrentHandle.Is_valid() == false {
+		return
+	}
+	infoHash := infoHashOf(torrentHandle)
+	ips := suspects(torrentHandle, piece)
+
+	s.quarantineLock.Lock()
+	if _, ok := s.quarantine[infoHash]; ok {
+		s.quarantineLock.Unlock()
+		return
+	}
+	failures, ok := s.hashFailures[infoHash]
+	if ok == false {
+		failures = &hashFailures{pieces: make(map[int]int)}
+		s.hashFailures[infoHash] = failures
+	}
+	failures.total++
+	failures.pieces[piece]++
+	total := failures.total
+	banned := make([]string, 0)
+	for _, ip := range ips {
+		s.peerFailures[ip]++
+		if s.peerFailures[ip] == peerBanFailures {
+			banned = append(banned, ip)
+		}
+	}
+	reason := ""
+	switch {
+	case failures.pieces[piece] >= pieceFailures:
+		reason = fmt.Sprintf("piece %d failed verification %d times", piece, failures.pieces[piece])
+	case failures.total >= quarantineFailures:
+		reason = fmt.Sprintf("%d pieces failed verification", failures.total)
+	}
+	s.quarantineLock.Unlock()
+
+	s.log.Warning("Piece %d of %s failed verification, %d times in all", piece, infoHash, total)
+	if len(banned) > 0 {
+		s.log.Warning("Banning %s, which sent pieces failing verification", strings.Join(banned, ", "))
+		s.configureIPFilter()
+	}
+	if reason != "" {
+		s.quarantineTorrent(torrentHandle, reason)
+	}
+}
+
+func (s *BTService) quarantineTorrent(torrentHandle libtorrent.Torrent_handle, reason string) {
+	event := s.torrentEvent(torrentHandle)
+	s.log.Error("Quarantining %s: %s", event.Name, reason)
+	torrentHandle.Pause()
+
+	s.quarantineLock.Lock()
+	s.quarantine[event.InfoHash] = &Quarantine{
+		InfoHash: event.InfoHash,
+		Name:     event.Name,
+		Reason:   reason,
+		Time:     time.Now(),
+	}
+	s.saveQuarantine()
+	s.quarantineLock.Unlock()
+
+	events.Publish(events.TorrentQuarantined, event)
+	xbmc.Notify("Pulsar", fmt.Sprintf("%s keeps failing verification, quarantined", event.Name), config.AddonIcon())
+}
+
+// addBannedPeers blocks the peers banned for sending bad pieces.
+func (s *BTService) addBannedPeers(filter libtorrent.Ip_filter) {
+	s.quarantineLock.Lock()
+	defer s.quarantineLock.Unlock()
+	for ip, failures := range s.peerFailures {
+		if address := net.ParseIP(ip); address != nil && failures >= peerBanFailures {
+			addIPFilterRule(filter, address, address, int(libtorrent.Ip_filterBlocked))
+		}
+	}
+}
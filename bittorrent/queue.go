@@ -0,0 +1,335 @@
+package bittorrent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+const (
+	defaultMaxActiveDownloads = 3
+	queueScheduleInterval     = 10 * time.Second
+)
+
+// the infohash of .torrent URLs until their metadata comes in
+var zeroInfoHash = strings.Repeat("0", 40)
+
+// States of the queued downloads.
+const (
+	QueueStateDownloading = "downloading"
+	QueueStateQueued      = "queued"
+	QueueStatePaused      = "paused"
+	QueueStateFinished    = "finished"
+	QueueStateMissing     = "missing"
+)
+
+// A background download, as saved in the queue. Higher priorities go first.
+type QueueItem struct {
+	InfoHash string    `json:"info_hash"`
+	URI      string    `json:"uri"`
+	Priority int       `json:"priority"`
+	Paused   bool      `json:"paused"`
+	Added    time.Time `json:"added"`
+
+	// only filled in by Queue
+	Name         string  `json:"name,omitempty"`
+	State        string  `json:"state,omitempty"`
+	Progress     float64 `json:"progress"`
+	DownloadRate int     `json:"download_rate"`
+}
+
+// key is what the download is known by in the queue, its infohash or, while
+// that isn't known yet, its URI.
+func (item *QueueItem) key() string {
+	if item.InfoHash == "" {
+		return item.URI
+	}
+	return item.InfoHash
+}
+
+type byQueueOrder []*QueueItem
+
+func (a byQueueOrder) Len() int      { return len(a) }
+func (a byQueueOrder) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byQueueOrder) Less(i, j int) bool {
+	if a[i].Priority != a[j].Priority {
+		return a[i].Priority > a[j].Priority
+	}
+	return a[i].Added.Before(a[j].Added)
+}
+
+// Must be called with downloadsLock held.
+func (s *BTService) saveQueue() {
+	if s.Config().QueuePath == "" {
+		return
+	}
+	data, err := json.Marshal(s.queue)
+	if err != nil {
+		s.log.Error("Unable to save the download queue: %s", err)
+		return
+	}
+	tmpPath := s.Config().QueuePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		s.log.Error("Unable to save the download queue: %s", err)
+		return
+	}
+	os.Rename(tmpPath, s.Config().QueuePath)
+}
+
+// loadQueue adds back the downloads that were queued before the restart.
+func (s *BTService) loadQueue() {
+	if s.Config().QueuePath == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(s.Config().QueuePath)
+	if err != nil {
+		return
+	}
+	queue := make([]*QueueItem, 0)
+	if err := json.Unmarshal(data, &queue); err != nil {
+		s.log.Error("Unable to read the download queue: %s", err)
+		return
+	}
+
+	s.downloadsLock.Lock()
+	defer s.downloadsLock.Unlock()
+	for _, item := range queue {
+		if item.InfoHash == zeroInfoHash {
+			item.InfoHash = ""
+		}
+		uri := item.URI
+		if item.InfoHash != "" && s.hasResumeData(item.InfoHash) {
+			// the resume data carries the metadata, no need to fetch
+			// the .torrent again
+			uri = "magnet:?xt=urn:btih:" + item.InfoHash
+		}
+		torrentHandle, err := s.addDownloadTorrent(uri, item.InfoHash)
+		if err != nil {
+			s.log.Error("Unable to restore download %s: %s", item.key(), err)
+			continue
+		}
+		torrentHandle.Pause()
+		s.downloads[item.key()] = torrentHandle
+		s.queue = append(s.queue, item)
+	}
+	s.log.Info("Restored %d queued downloads", len(s.queue))
+}
+
+// AddDownload queues a torrent to download in the background, outside of
+// any player. Its files are kept once done.
+func (s *BTService) AddDownload(uri string) error {
+	torrentHandle, err := s.addDownloadTorrent(uri, ExtractInfoHash(uri))
+	if err != nil {
+		return err
+	}
+	torrentHandle.Pause()
+	item := &QueueItem{
+		InfoHash: infoHashOf(torrentHandle),
+		URI:      uri,
+		Added:    time.Now(),
+	}
+	if item.InfoHash == zeroInfoHash {
+		item.InfoHash = ""
+	}
+
+	s.downloadsLock.Lock()
+	s.downloads[item.key()] = torrentHandle
+	if s.queueItem(item.key()) == nil {
+		s.queue = append(s.queue, item)
+		s.saveQueue()
+	}
+	s.downloadsLock.Unlock()
+
+	s.log.Info("Queued background download %s", uri)
+	s.scheduleQueue()
+	return nil
+}
+
+// Must be called with downloadsLock held.
+func (s *BTService) queueItem(infoHash string) *QueueItem {
+	for _, item := range s.queue {
+		if item.key() == infoHash {
+			return item
+		}
+	}
+	return nil
+}
+
+// Must be called with downloadsLock held.
+func (s *BTService) removeFromQueue(infoHash string) {
+	kept := make([]*QueueItem, 0, len(s.queue))
+	for _, item := range s.queue {
+		if item.key() != infoHash {
+			kept = append(kept, item)
+		}
+	}
+	if len(kept) != len(s.queue) {
+		s.queue = kept
+		s.saveQueue()
+	}
+}
+
+func (s *BTService) updateQueueItem(infoHash string, update func(item *QueueItem)) error {
+	s.downloadsLock.Lock()
+	item := s.queueItem(infoHash)
+	if item == nil {
+		s.downloadsLock.Unlock()
+		return fmt.Errorf("no queued download with infohash %s", infoHash)
+	}
+	update(item)
+	s.saveQueue()
+	s.downloadsLock.Unlock()
+
+	s.scheduleQueue()
+	return nil
+}
+
+func (s *BTService) PauseDownload(infoHash string) error {
+	return s.updateQueueItem(infoHash, func(item *QueueItem) { item.Paused = true })
+}
+
+func (s *BTService) ResumeDownload(infoHash string) error {
+	return s.updateQueueItem(infoHash, func(item *QueueItem) { item.Paused = false })
+}
+
+func (s *BTService) SetDownloadPriority(infoHash string, priority int) error {
+	if priority < 0 {
+		priority = 0
+	}
+	return s.updateQueueItem(infoHash, func(item *QueueItem) { item.Priority = priority })
+}
+
+func isFinished(status libtorrent.Torrent_status) bool {
+	state := status.GetState()
+	return state == libtorrent.Torrent_statusFinished || state == libtorrent.Torrent_statusSeeding
+}
+
+// Queue returns the queued downloads in the order they are scheduled, with
+// their current state.
+func (s *BTService) Queue() []*QueueItem {
+	s.downloadsLock.Lock()
+	defer s.downloadsLock.Unlock()
+
+	queue := make([]*QueueItem, 0, len(s.queue))
+	for _, item := range s.queue {
+		dup := *item
+		dup.State = QueueStateMissing
+		if torrentHandle, ok := s.downloads[item.key()]; ok && torrentHandle.Is_valid() {
+			status := torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_name))
+			dup.Name = status.GetName()
+			dup.Progress = float64(status.GetProgress())
+			dup.DownloadRate = status.GetDownload_rate()
+			switch {
+			case isFinished(status):
+				dup.State = QueueStateFinished
+			case item.Paused:
+				dup.State = QueueStatePaused
+			case status.GetPaused():
+				dup.State = QueueStateQueued
+			default:
+				dup.State = QueueStateDownloading
+			}
+		}
+		queue = append(queue, &dup)
+	}
+	sort.Stable(byQueueOrder(queue))
+	return queue
+}
+
+func (s *BTService) maxActiveDownloads() int {
+	if s.Config().MaxActiveDownloads <= 0 {
+		return defaultMaxActiveDownloads
+	}
+	return s.Config().MaxActiveDownloads
+}
+
+// resolveQueueHashes keys the .torrent URLs by their infohash once their
+// metadata came in.
+// Must be called with downloadsLock held.
+func (s *BTService) resolveQueueHashes() {
+	resolved := false
+	for _, item := range s.queue {
+		if item.InfoHash != "" {
+			continue
+		}
+		torrentHandle, ok := s.downloads[item.URI]
+		if ok == false || torrentHandle.Is_valid() == false {
+			continue
+		}
+		if infoHash := infoHashOf(torrentHandle); infoHash != zeroInfoHash {
+			delete(s.downloads, item.URI)
+			s.downloads[infoHash] = torrentHandle
+			item.InfoHash = infoHash
+			resolved = true
+		}
+	}
+	if resolved {
+		s.saveQueue()
+	}
+}
+
+// scheduleQueue runs the highest priority downloads, pauses the others, and
+// shares the queue bandwidth between the running ones by priority.
+func (s *BTService) scheduleQueue() {
+	s.downloadsLock.Lock()
+	defer s.downloadsLock.Unlock()
+	s.resolveQueueHashes()
+
+	ordered := make([]*QueueItem, len(s.queue))
+	copy(ordered, s.queue)
+	sort.Stable(byQueueOrder(ordered))
+
+	active := make([]*QueueItem, 0)
+	for _, item := range ordered {
+		torrentHandle, ok := s.downloads[item.key()]
+		if ok == false || torrentHandle.Is_valid() == false {
+			continue
+		}
+		if isFinished(torrentHandle.Status(uint(0))) {
+			// let it seed, it doesn't take a download slot
+			torrentHandle.Resume()
+			continue
+		}
+		if item.Paused || len(active) >= s.maxActiveDownloads() {
+			torrentHandle.Pause()
+			continue
+		}
+		torrentHandle.Resume()
+		active = append(active, item)
+	}
+
+	if s.Config().QueueDownloadRate <= 0 {
+		for _, item := range active {
+			s.setTorrentDownloadLimit(s.downloads[item.key()], unlimited)
+		}
+		return
+	}
+	// priorities start at 0, weigh everything one more so they all get some
+	weights := 0
+	for _, item := range active {
+		weights += item.Priority + 1
+	}
+	for _, item := range active {
+		limit := s.Config().QueueDownloadRate * (item.Priority + 1) / weights
+		s.setTorrentDownloadLimit(s.downloads[item.key()], limit)
+	}
+}
+
+func (s *BTService) queueScheduler() {
+	ticker := time.NewTicker(queueScheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+			s.scheduleQueue()
+		}
+	}
+}
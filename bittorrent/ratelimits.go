@@ -0,0 +1,218 @@
+package bittorrent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+const rateScheduleInterval = 1 * time.Minute
+
+// RateLimits are in bytes per second, zero meaning unlimited.
+type RateLimits struct {
+	Download int `json:"download"`
+	Upload   int `json:"upload"`
+}
+
+// RateSchedule applies its limits from From to To, which are minutes of the
+// day. To may be before From for periods spanning midnight.
+type RateSchedule struct {
+	From   int        `json:"from"`
+	To     int        `json:"to"`
+	Limits RateLimits `json:"limits"`
+}
+
+func parseClock(clock string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(clock), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %s", clock)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("invalid time %s", clock)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("invalid time %s", clock)
+	}
+	return hours*60 + minutes, nil
+}
+
+// ParseRateSchedule reads entries like "08:00-23:00=1024/256", that is from
+// 8am to 11pm, download at 1024kb/s and upload at 256kb/s. Zero is
+// unlimited.
+func ParseRateSchedule(entry string) (*RateSchedule, error) {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid rate schedule %s", entry)
+	}
+	period := strings.SplitN(parts[0], "-", 2)
+	rates := strings.SplitN(parts[1], "/", 2)
+	if len(period) != 2 || len(rates) != 2 {
+		return nil, fmt.Errorf("invalid rate schedule %s", entry)
+	}
+	schedule := &RateSchedule{}
+	var err error
+	if schedule.From, err = parseClock(period[0]); err != nil {
+		return nil, err
+	}
+	if schedule.To, err = parseClock(period[1]); err != nil {
+		return nil, err
+	}
+	download, err := strconv.Atoi(strings.TrimSpace(rates[0]))
+	if err != nil || download < 0 {
+		return nil, fmt.Errorf("invalid download rate in %s", entry)
+	}
+	upload, err := strconv.Atoi(strings.TrimSpace(rates[1]))
+	if err != nil || upload < 0 {
+		return nil, fmt.Errorf("invalid upload rate in %s", entry)
+	}
+	schedule.Limits = RateLimits{Download: download * 1024, Upload: upload * 1024}
+	return schedule, nil
+}
+
+func (schedule *RateSchedule) active(now time.Time) bool {
+	minute := now.Hour()*60 + now.Minute()
+	if schedule.From <= schedule.To {
+		return minute >= schedule.From && minute < schedule.To
+	}
+	return minute >= schedule.From || minute < schedule.To
+}
+
+// capLimit caps a libtorrent limit, where zero and below are unlimited.
+func capLimit(limit int, max int) int {
+	if max <= 0 {
+		return limit
+	}
+	if limit <= 0 || limit > max {
+		return max
+	}
+	return limit
+}
+
+// RateLimits are the session limits in effect: the ones set through the API,
+// else the first matching schedule, else the settings.
+func (s *BTService) RateLimits() RateLimits {
+	s.ratesLock.Lock()
+	defer s.ratesLock.Unlock()
+	if s.rateOverride != nil {
+		return *s.rateOverride
+	}
+	now := time.Now()
+	for _, entry := range s.Config().RateSchedule {
+		schedule, err := ParseRateSchedule(entry)
+		if err != nil {
+			continue
+		}
+		if schedule.active(now) {
+			return schedule.Limits
+		}
+	}
+	return RateLimits{Download: s.Config().MaxDownloadRate, Upload: s.Config().MaxUploadRate}
+}
+
+// SetRateLimits overrides the schedule and the settings until restart, nil
+// going back to them.
+func (s *BTService) SetRateLimits(limits *RateLimits) {
+	s.ratesLock.Lock()
+	s.rateOverride = limits
+	s.ratesLock.Unlock()
+	s.applyRateLimits(false)
+}
+
+// applyRateLimits sets the session limits when they changed, or always when
+// forced as after reconfiguring the session. The upload tuner works within
+// the upload limit.
+func (s *BTService) applyRateLimits(force bool) {
+	limits := s.RateLimits()
+
+	s.ratesLock.Lock()
+	defer s.ratesLock.Unlock()
+	tuned := s.tunedUpload
+	applied := RateLimits{Download: limits.Download, Upload: capLimit(tuned, limits.Upload)}
+	if force == false && s.appliedRates != nil && *s.appliedRates == applied {
+		return
+	}
+	s.appliedRates = &applied
+
+	if tuned <= 0 {
+		s.log.Info("Rate limiting download to %dkb/s and upload to %dkb/s (0 is unlimited)", limits.Download/1024, limits.Upload/1024)
+	}
+	s.updateSettings(func(settings libtorrent.Session_settings) {
+		settings.SetDownload_rate_limit(applied.Download)
+		settings.SetUpload_rate_limit(applied.Upload)
+		if limits.Upload > 0 {
+			// If we have an upload rate, use the nicer bittyrant choker
+			settings.SetChoking_algorithm(int(libtorrent.Session_settingsBittyrant_choker))
+		} else {
+			settings.SetChoking_algorithm(int(libtorrent.Session_settingsFixed_slots_choker))
+		}
+		slots := -1 // unlimited
+		if tuned > 0 {
+			slots = applied.Upload / uploadRatePerSlot
+			if slots < minUploadSlots {
+				slots = minUploadSlots
+			}
+		}
+		settings.SetUnchoke_slots_limit(slots)
+	})
+}
+
+// rateScheduler switches the session limits as the schedule goes.
+func (s *BTService) rateScheduler() {
+	ticker := time.NewTicker(rateScheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+			s.applyRateLimits(false)
+		}
+	}
+}
+
+// TorrentRateLimits are the limits of a torrent, set through the API, else
+// the per torrent ones from the settings.
+func (s *BTService) TorrentRateLimits(infoHash string) RateLimits {
+	s.ratesLock.Lock()
+	defer s.ratesLock.Unlock()
+	if limits, ok := s.torrentRates[infoHash]; ok {
+		return *limits
+	}
+	return RateLimits{Download: s.Config().MaxTorrentDownloadRate, Upload: s.Config().MaxTorrentUploadRate}
+}
+
+// SetTorrentRateLimits overrides the limits of a torrent, nil going back to
+// the settings. The queue and the streams fairness still share the
+// bandwidth, within those limits.
+func (s *BTService) SetTorrentRateLimits(infoHash string, limits *RateLimits) error {
+	torrentHandle, err := s.findTorrent(infoHash)
+	if err != nil {
+		return err
+	}
+	s.ratesLock.Lock()
+	if limits == nil {
+		delete(s.torrentRates, infoHash)
+	} else {
+		s.torrentRates[infoHash] = limits
+	}
+	s.ratesLock.Unlock()
+	s.applyTorrentRateLimits(torrentHandle)
+	s.scheduleQueue()
+	return nil
+}
+
+func (s *BTService) applyTorrentRateLimits(torrentHandle libtorrent.Torrent_handle) {
+	limits := s.TorrentRateLimits(infoHashOf(torrentHandle))
+	torrentHandle.Set_download_limit(capLimit(unlimited, limits.Download))
+	torrentHandle.Set_upload_limit(capLimit(unlimited, limits.Upload))
+}
+
+// setTorrentDownloadLimit sets a download limit, within the torrent's own.
+func (s *BTService) setTorrentDownloadLimit(torrentHandle libtorrent.Torrent_handle, limit int) {
+	torrentHandle.Set_download_limit(capLimit(limit, s.TorrentRateLimits(infoHashOf(torrentHandle)).Download))
+}
@@ -0,0 +1,187 @@
+package bittorrent
+
+import (
+	"errors"
+	"unsafe"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+const (
+	// how far ahead of the reads we want the pieces first
+	readaheadBytes     = 32 * 1024 * 1024
+	minReadaheadPieces = 4
+	readaheadPriority  = 7
+	// the deadlines of the window pieces are this many ms apart until the
+	// bitrate is known
+	readaheadDeadlineStep = 250
+)
+
+func (tf *TorrentFile) readaheadPieces() int {
+	if pieces := readaheadBytes / tf.pieceLength; pieces > minReadaheadPieces {
+		return pieces
+	}
+	return minReadaheadPieces
+}
+
+func (tf *TorrentFile) filePieces() (int, int) {
+	pieceLength := int64(tf.pieceLength)
+	return int(tf.fileOffset / pieceLength), int((tf.fileOffset + tf.fileSize - 1) / pieceLength)
+}
+
+func (tfs *TorrentFS) addReader(tf *TorrentFile) {
+	tfs.readersMx.Lock()
+	defer tfs.readersMx.Unlock()
+	if tfs.readers[tf.infoHash] == nil {
+		tfs.readers[tf.infoHash] = make(map[*TorrentFile]bool)
+	}
+	tfs.readers[tf.infoHash][tf] = true
+}
+
+// removeReader gives the pieces the closed file was reading to the other
+// readers of the torrent, if any.
+func (tfs *TorrentFS) removeReader(tf *TorrentFile) {
+	tfs.readersMx.Lock()
+	defer tfs.readersMx.Unlock()
+	delete(tfs.readers[tf.infoHash], tf)
+	if len(tfs.readers[tf.infoHash]) == 0 {
+		delete(tfs.readers, tf.infoHash)
+		return
+	}
+	tfs.prioritizeReaders(tf.infoHash)
+}
+
+// prioritizeFrom moves the window of the file to offset, where its player
+// reads from.
+func (tf *TorrentFile) prioritizeFrom(offset int64) {
+	piece, _ := tf.pieceFromOffset(offset)
+	if _, endPiece := tf.filePieces(); piece > endPiece {
+		return
+	}
+	tf.tfs.readersMx.Lock()
+	defer tf.tfs.readersMx.Unlock()
+	tf.tfs.log.Info("Prioritizing pieces %d to %d", piece, piece+tf.readaheadPieces()-1)
+	tf.windowStart = piece
+	tf.windowSet = true
+	tf.tfs.prioritizeReaders(tf.infoHash)
+}
+
+// torrentPieces is what the readers need of a torrent to prioritize it,
+// behind an interface for the tests.
+type torrentPieces interface {
+	valid() bool
+	numPieces() int
+	// prioritize sets the priorities of all the pieces, and replaces the
+	// deadlines with the given ones
+	prioritize(priorities []int, deadlines map[int]int)
+	// bitfield is the pieces of the torrent we have
+	bitfield() (Bitfield, error)
+}
+
+type handlePieces struct {
+	torrentHandle libtorrent.Torrent_handle
+	torrentInfo   libtorrent.Torrent_info
+	lastStatus    libtorrent.Torrent_status
+}
+
+func (hp *handlePieces) valid() bool {
+	return hp.torrentHandle != nil && hp.torrentHandle.Is_valid()
+}
+
+func (hp *handlePieces) numPieces() int {
+	return hp.torrentInfo.Num_pieces()
+}
+
+func (hp *handlePieces) prioritize(priorities []int, deadlines map[int]int) {
+	piecesPriorities := libtorrent.NewStd_vector_int()
+	defer libtorrent.DeleteStd_vector_int(piecesPriorities)
+	for _, priority := range priorities {
+		piecesPriorities.Add(priority)
+	}
+	hp.torrentHandle.Clear_piece_deadlines()
+	hp.torrentHandle.Prioritize_pieces(piecesPriorities)
+	for piece, deadline := range deadlines {
+		if hp.torrentHandle.Have_piece(piece) == false {
+			hp.torrentHandle.Set_piece_deadline(piece, deadline, 0)
+		}
+	}
+}
+
+func (hp *handlePieces) bitfield() (Bitfield, error) {
+	// need to keep a reference to the status or else the pieces bitfield
+	// is at risk of being collected
+	hp.lastStatus = hp.torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_pieces))
+	if hp.lastStatus.GetState() > libtorrent.Torrent_statusSeeding {
+		return nil, errors.New("Torrent file has invalid state.")
+	}
+	piecesBits := hp.lastStatus.GetPieces()
+	piecesBitsSize := piecesBits.Size()
+	piecesSliceSize := piecesBitsSize / 8
+	if piecesBitsSize%8 > 0 {
+		// Add +1 to round up the bitfield
+		piecesSliceSize += 1
+	}
+	data := (*[100000000]byte)(unsafe.Pointer(piecesBits.Bytes()))[:piecesSliceSize]
+	return Bitfield(data), nil
+}
+
+// prioritizeReaders makes the pieces right after where each reader of the
+// torrent is come first, with increasing deadlines over the deadline window
+// of playback. The rest of the files being read follows, up to what fits in
+// memory for torrents there, and what's before the readers, watched
+// already, or in other files isn't wanted anymore. Must be called with
+// readersMx held.
+func (tfs *TorrentFS) prioritizeReaders(infoHash string) {
+	var pieces torrentPieces
+	priorities := make(map[int]int)
+	deadlines := make(map[int]int)
+	for tf := range tfs.readers[infoHash] {
+		if tf.windowSet == false {
+			continue
+		}
+		pieces = tf.torrentPieces
+		window := tf.readaheadPieces()
+		deadlineWindow, deadlineStep := tfs.service.readerDeadlines(tf.torrentHandle, tf.pieceLength, window)
+		_, endPiece := tf.filePieces()
+		if ahead := tfs.service.memoryAheadPieces(tf.torrentHandle, tf.pieceLength); ahead >= 0 {
+			if ahead < window {
+				ahead = window
+			}
+			if tf.windowStart+ahead-1 < endPiece {
+				endPiece = tf.windowStart + ahead - 1
+			}
+		}
+		for i := tf.windowStart; i <= endPiece; i++ {
+			priority := 1
+			if i < tf.windowStart+deadlineWindow {
+				priority = readaheadPriority
+				deadline := (i - tf.windowStart) * deadlineStep
+				if current, exists := deadlines[i]; exists == false || deadline < current {
+					deadlines[i] = deadline
+				}
+			}
+			if priority > priorities[i] {
+				priorities[i] = priority
+			}
+		}
+	}
+	if pieces == nil || pieces.valid() == false {
+		return
+	}
+
+	piecesPriorities := make([]int, pieces.numPieces())
+	for piece, priority := range priorities {
+		if piece < len(piecesPriorities) {
+			piecesPriorities[piece] = priority
+		}
+	}
+	pieces.prioritize(piecesPriorities, deadlines)
+}
+
+// inWindow tells whether reading the piece needs the window to move, which
+// happens once half of it is read, or when the player jumped out of it.
+func (tf *TorrentFile) inWindow(piece int) bool {
+	tf.tfs.readersMx.Lock()
+	defer tf.tfs.readersMx.Unlock()
+	return tf.windowSet && piece >= tf.windowStart && piece < tf.windowStart+tf.readaheadPieces()/2
+}
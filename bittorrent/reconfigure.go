@@ -0,0 +1,109 @@
+package bittorrent
+
+import "reflect"
+
+// Settings changed in XBMC are applied to the running session, redoing
+// only what they change, so the torrents and the streams go on. The state
+// loaded when the service starts is only looked for in its new place after
+// a restart.
+var restartFields = []string{"ResumePath", "QueuePath", "QuarantinePath", "KeptPath", "InstanceId"}
+
+func configChanged(previous *BTConfiguration, current *BTConfiguration, fields ...string) bool {
+	before, after := reflect.ValueOf(previous).Elem(), reflect.ValueOf(current).Elem()
+	for _, field := range fields {
+		if reflect.DeepEqual(before.FieldByName(field).Interface(), after.FieldByName(field).Interface()) == false {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconfigure applies the new configuration, and tells whether some of it
+// needs the service to restart.
+func (s *BTService) Reconfigure(config BTConfiguration) bool {
+	s.configLock.Lock()
+	previous := s.config
+	s.config = &config
+	s.configLock.Unlock()
+	changed := func(fields ...string) bool {
+		return configChanged(previous, &config, fields...)
+	}
+
+	if changed("SlowStorage", "DownloadPath", "StagingPath") {
+		s.detectSlowStorage()
+	}
+	if changed("MemoryBuffer", "MemoryPath", "MemoryBufferSize") {
+		s.detectMemoryStorage()
+	}
+	if changed("PieceCachePath", "PieceCacheSize") {
+		s.configurePieceCache()
+	}
+	if changed("SlowStorage", "DownloadPath", "MemoryBuffer", "MemoryPath", "MemoryBufferSize",
+		"EndgameDuplicates", "BindInterface", "KillSwitch", "Proxy", "AnonymousMode", "Transport",
+		"CPULimits", "CPUCores") {
+		s.applySessionSettings()
+	} else if changed("MaxDownloadRate", "MaxUploadRate", "RateSchedule") {
+		s.applyRateLimits(true)
+	}
+	if changed("Encryption") {
+		s.applyEncryption()
+	}
+	if changed("Proxy") {
+		s.configureProxy()
+	}
+	if changed("ClientIdentity", "SourceClientIdentities") {
+		s.configureIdentity()
+	}
+	// the host rules it goes by aren't part of the configuration
+	s.configureIPFilter()
+
+	if changed("MaxTorrentDownloadRate", "MaxTorrentUploadRate") {
+		s.applyAllTorrentRateLimits()
+	}
+	if changed("MaxActiveDownloads", "QueueDownloadRate", "MaxTorrentDownloadRate") {
+		s.scheduleQueue()
+	}
+	if changed("AfterDownloads") {
+		s.downloadsLock.Lock()
+		s.afterDownloads = config.AfterDownloads
+		s.downloadsLock.Unlock()
+	}
+
+	if changed("LowerListenPort", "UpperListenPort", "BindInterface", "DHTBootstrapNodes") {
+		s.log.Info("Listening again, with the new ports or interface")
+		// the port mappings and the DHT follow the new ports
+		s.stopServices()
+		s.Listen()
+		s.startServices()
+		s.reannounce()
+	} else if changed("Proxy") {
+		s.reannounce()
+	}
+
+	if changed(restartFields...) {
+		s.log.Warning("Some of the new settings are only applied on restart")
+		return true
+	}
+	return false
+}
+
+func (s *BTService) applyAllTorrentRateLimits() {
+	// NB: this does NOT return a pointer to vector, no need to free!
+	torrentsVector := s.Session.Get_torrents()
+	for i := 0; i < int(torrentsVector.Size()); i++ {
+		if torrentHandle := torrentsVector.Get(i); torrentHandle.Is_valid() {
+			s.applyTorrentRateLimits(torrentHandle)
+		}
+	}
+}
+
+// reannounce tells the trackers where to find us now.
+func (s *BTService) reannounce() {
+	// NB: this does NOT return a pointer to vector, no need to free!
+	torrentsVector := s.Session.Get_torrents()
+	for i := 0; i < int(torrentsVector.Size()); i++ {
+		if torrentHandle := torrentsVector.Get(i); torrentHandle.Is_valid() {
+			torrentHandle.Force_reannounce()
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package bittorrent
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Release names number episodes in many ways: S01E02, S01E01E02 or
+// S01E01-E03 for multi-episode releases, 1x02 and 1x01-1x02, Ep. 02
+// without the season, absolute numbers for anime, and air dates for daily
+// shows.
+
+// wider ranges are more likely years than episodes
+const maxEpisodeRange = 50
+
+var (
+	seasonEpisodeRe     = regexp.MustCompile(`(?:^|[^a-z0-9])s(\d{1,2})[ ._-]?e(\d{1,3})`)
+	seasonEpisodeNextRe = regexp.MustCompile(`^(?:[ ._]*(-|\+|&)[ ._]*(?:s\d{1,2})?e?|[ ._-]?(?:s\d{1,2})?(e))(\d{1,3})(?:[^0-9a-z]|$)`)
+	crossEpisodeRe      = regexp.MustCompile(`(?:^|[^a-z0-9])(\d{1,2})x(\d{2,3})`)
+	crossEpisodeNextRe  = regexp.MustCompile(`^(?:[ ._]*(-|\+|&)[ ._]*(?:\d{1,2}x)?|(x))(\d{2,3})(?:[^0-9a-z]|$)`)
+	bareEpisodeRe       = regexp.MustCompile(`(?:^|[^a-z0-9])(?:ep(?:isode)?\.?|e)[ ._]*(\d{1,3})(?:[ ._]*-[ ._]*(?:ep?\.?)?[ ._]*(\d{1,3}))?(?:[^0-9a-z]|$)`)
+	absoluteRe          = regexp.MustCompile(`^0*(\d{1,4})(?:v\d)?$`)
+	absoluteRangeRe     = regexp.MustCompile(`(?:^|[^0-9a-z])(\d{1,4})[ ._]?[-~][ ._]?(\d{1,4})(?:v\d)?(?:[^0-9a-z]|$)`)
+	dottedNumberRe      = regexp.MustCompile(`(?:\d+\.)+\d+(?:[^0-9a-z]|$)`)
+	codecNumberRe       = regexp.MustCompile(`(?:^|[^0-9a-z])[hx]\.26[45]`)
+	tokenRe             = regexp.MustCompile(`[\p{L}\p{N}]+`)
+	dateRe              = regexp.MustCompile(`(?:^|[^0-9])(\d{4})[ ._-](\d{2})[ ._-](\d{2})(?:[^0-9]|$)`)
+	dayFirstDateRe      = regexp.MustCompile(`(?:^|[^0-9])(\d{2})[ ._-](\d{2})[ ._-](\d{4})(?:[^0-9]|$)`)
+)
+
+// ReleaseEpisodes is what a release or file name says of the episodes in
+// it.
+type ReleaseEpisodes struct {
+	Season   int   // -1 when the name doesn't say
+	Episodes []int // of Season
+	Absolute []int // the numbers anime releases may go by
+	Aired    time.Time
+}
+
+// EpisodeRef is an episode, as release names may number it.
+type EpisodeRef struct {
+	Season   int
+	Episode  int
+	Absolute []int     // for anime, the absolute and AniDB numbers
+	Aired    time.Time // for daily shows
+}
+
+func (ref EpisodeRef) String() string {
+	return fmt.Sprintf("S%02dE%02d", ref.Season, ref.Episode)
+}
+
+// ParseEpisodes parses the episode numbers out of a release name.
+func ParseEpisodes(name string) *ReleaseEpisodes {
+	name = strings.ToLower(name)
+	release := &ReleaseEpisodes{Season: -1}
+
+	if season, episodes := parseNumbered(name, seasonEpisodeRe, seasonEpisodeNextRe); len(episodes) > 0 {
+		release.Season, release.Episodes = season, episodes
+	} else if season, episodes := parseNumbered(name, crossEpisodeRe, crossEpisodeNextRe); len(episodes) > 0 {
+		release.Season, release.Episodes = season, episodes
+	} else if match := bareEpisodeRe.FindStringSubmatch(name); match != nil {
+		first, _ := strconv.Atoi(match[1])
+		release.Episodes = []int{first}
+		if match[2] != "" {
+			last, _ := strconv.Atoi(match[2])
+			release.Episodes = episodeRange(first, last)
+		}
+	}
+
+	release.Aired = parseAirDate(name)
+	if release.Aired.IsZero() && release.Season < 0 && len(release.Episodes) == 0 {
+		release.Absolute = parseAbsolute(name)
+	}
+	return release
+}
+
+// parseNumbered parses the season and episodes of the first match of re,
+// and the episodes following it, as in S01E01E02 or 1x01-1x03.
+func parseNumbered(name string, re *regexp.Regexp, nextRe *regexp.Regexp) (int, []int) {
+	for _, loc := range re.FindAllStringSubmatchIndex(name, -1) {
+		// the episode number must end here, or it's something else
+		if loc[1] < len(name) && unicode.IsDigit(rune(name[loc[1]])) {
+			continue
+		}
+		season, _ := strconv.Atoi(name[loc[2]:loc[3]])
+		episode, _ := strconv.Atoi(name[loc[4]:loc[5]])
+		episodes := []int{episode}
+		rest := name[loc[1]:]
+		for {
+			next := nextRe.FindStringSubmatchIndex(rest)
+			if next == nil {
+				break
+			}
+			number, _ := strconv.Atoi(rest[next[6]:next[7]])
+			last := episodes[len(episodes)-1]
+			if number <= last {
+				break
+			}
+			if next[2] >= 0 && rest[next[2]:next[3]] == "-" {
+				episodes = append(episodes[:len(episodes)-1], episodeRange(last, number)...)
+			} else {
+				episodes = append(episodes, number)
+			}
+			rest = rest[next[7]:]
+		}
+		return season, episodes
+	}
+	return -1, nil
+}
+
+func episodeRange(first int, last int) []int {
+	if last < first || last-first > maxEpisodeRange {
+		return []int{first}
+	}
+	episodes := make([]int, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		episodes = append(episodes, i)
+	}
+	return episodes
+}
+
+// parseAbsolute returns the numbers standing on their own, as the "12" of
+// "[Group] Show - 12 [720p]", and those of ranges, as in "01-12". Those of
+// dotted numbers, as the audio of "AAC 2.0" or the codec of "H.264", and of
+// ranges too wide to be episodes are left out.
+func parseAbsolute(name string) []int {
+	numbers := make([]int, 0)
+	covered := append(dottedNumberRe.FindAllStringIndex(name, -1), codecNumberRe.FindAllStringIndex(name, -1)...)
+	for _, loc := range absoluteRangeRe.FindAllStringSubmatchIndex(name, -1) {
+		covered = append(covered, loc[:2])
+		first, _ := strconv.Atoi(name[loc[2]:loc[3]])
+		last, _ := strconv.Atoi(name[loc[4]:loc[5]])
+		if last < first || last-first > maxEpisodeRange {
+			continue
+		}
+		for _, number := range episodeRange(first, last) {
+			if containsInt(numbers, number) == false {
+				numbers = append(numbers, number)
+			}
+		}
+	}
+	for _, loc := range tokenRe.FindAllStringIndex(name, -1) {
+		if isCovered(covered, loc) {
+			continue
+		}
+		if match := absoluteRe.FindStringSubmatch(name[loc[0]:loc[1]]); match != nil {
+			number, _ := strconv.Atoi(match[1])
+			if containsInt(numbers, number) == false {
+				numbers = append(numbers, number)
+			}
+		}
+	}
+	return numbers
+}
+
+func isCovered(spans [][]int, loc []int) bool {
+	for _, span := range spans {
+		if loc[0] >= span[0] && loc[1] <= span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAirDate(name string) time.Time {
+	year, month, day := "", "", ""
+	if match := dateRe.FindStringSubmatch(name); match != nil {
+		year, month, day = match[1], match[2], match[3]
+	} else if match := dayFirstDateRe.FindStringSubmatch(name); match != nil {
+		year, month, day = match[3], match[2], match[1]
+	} else {
+		return time.Time{}
+	}
+	aired, err := time.Parse("2006-01-02", year+"-"+month+"-"+day)
+	if err != nil || aired.Year() < 1950 {
+		return time.Time{}
+	}
+	return aired
+}
+
+// InSeason is the release, those episodes the name doesn't give the season
+// of being in season, as the files of a season pack.
+func (release *ReleaseEpisodes) InSeason(season int) *ReleaseEpisodes {
+	if release.Season >= 0 {
+		return release
+	}
+	dup := *release
+	dup.Season = season
+	return &dup
+}
+
+// Matches tells whether the release contains the episode. Episodes without
+// a season are taken for those of the first one.
+func (release *ReleaseEpisodes) Matches(ref EpisodeRef) bool {
+	if release.Season == ref.Season || (release.Season < 0 && ref.Season <= 1) {
+		if containsInt(release.Episodes, ref.Episode) {
+			return true
+		}
+	}
+	for _, number := range ref.Absolute {
+		if containsInt(release.Absolute, number) {
+			return true
+		}
+	}
+	if ref.Aired.IsZero() == false && release.Aired.IsZero() == false {
+		return ref.Aired.Format("2006-01-02") == release.Aired.Format("2006-01-02")
+	}
+	return false
+}
+
+func containsInt(numbers []int, number int) bool {
+	for _, n := range numbers {
+		if n == number {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesFile tells whether the file of a season pack is the episode.
+// The file name says, or else its directories.
+func (ref EpisodeRef) matchesFile(path string) bool {
+	release := ParseEpisodes(filepath.Base(path))
+	if len(release.Episodes) == 0 && release.Aired.IsZero() {
+		release = ParseEpisodes(path)
+	}
+	return release.InSeason(ref.Season).Matches(ref)
+}
@@ -0,0 +1,115 @@
+package bittorrent
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseEpisodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		season   int
+		episodes []int
+	}{
+		{"Show.S01E02.720p.HDTV.x264-GROUP", 1, []int{2}},
+		{"Show.s1e2.720p", 1, []int{2}},
+		{"Show S01 E02 720p", 1, []int{2}},
+		{"Show.S01E01E02.720p", 1, []int{1, 2}},
+		{"Show.S01E01-E03.720p", 1, []int{1, 2, 3}},
+		{"Show.S01E01-03.720p", 1, []int{1, 2, 3}},
+		{"Show.S01E01-S01E03.720p", 1, []int{1, 2, 3}},
+		{"Show.S01E01+E02.720p", 1, []int{1, 2}},
+		{"Show.S01E01&E03.720p", 1, []int{1, 3}},
+		{"Show.S02E05-E04.720p", 2, []int{5}},
+		{"Show.1x02.HDTV", 1, []int{2}},
+		{"Show.1x01-1x03.HDTV", 1, []int{1, 2, 3}},
+		{"Show.1x01x02.HDTV", 1, []int{1, 2}},
+		{"Show.Ep.02.720p", -1, []int{2}},
+		{"Show Episode 3 - 5", -1, []int{3, 4, 5}},
+		// wider than maxEpisodeRange, the end is more likely a year
+		{"Show.S01E01-E99.720p", 1, []int{1}},
+		{"Show.S01E021080p", -1, nil},
+		{"Show.1080p.x264", -1, nil},
+	}
+	for _, test := range tests {
+		release := ParseEpisodes(test.name)
+		if release.Season != test.season || reflect.DeepEqual(release.Episodes, test.episodes) == false {
+			t.Errorf("ParseEpisodes(%q) = S%d %v, want S%d %v", test.name, release.Season, release.Episodes, test.season, test.episodes)
+		}
+	}
+}
+
+func TestParseEpisodesAbsolute(t *testing.T) {
+	tests := []struct {
+		name     string
+		absolute []int
+	}{
+		{"[Group] Show - 12 [720p]", []int{12}},
+		{"[Group] Show - 012v2 [720p]", []int{12}},
+		{"[Group] Show - 01-03 [720p]", []int{1, 2, 3}},
+		{"[Group] Show 01~03", []int{1, 2, 3}},
+		{"[Group] Show - 1 - 99", nil},
+		{"[Group] Show - 05 [AAC 2.0]", []int{5}},
+		{"Show.07.1080p.WEB.DDP5.1.H.264-Group", []int{7}},
+		{"[Group] Show - 08 (x.265 10bit)", []int{8}},
+		{"Show.S01E02.720p.HDTV.x264-Group", nil},
+		{"Show.2014.03.02.HDTV", nil},
+	}
+	for _, test := range tests {
+		release := ParseEpisodes(test.name)
+		if len(release.Absolute) != len(test.absolute) || (len(test.absolute) > 0 && reflect.DeepEqual(release.Absolute, test.absolute) == false) {
+			t.Errorf("ParseEpisodes(%q).Absolute = %v, want %v", test.name, release.Absolute, test.absolute)
+		}
+	}
+}
+
+func TestParseEpisodesAired(t *testing.T) {
+	tests := []struct {
+		name  string
+		aired string
+	}{
+		{"Show.2014.03.02.HDTV", "2014-03-02"},
+		{"Show 2014-03-02 720p", "2014-03-02"},
+		{"Show.02.03.2014.HDTV", "2014-03-02"},
+		{"Show.2014.13.02.HDTV", ""},
+		{"Show.1900.03.02.HDTV", ""},
+		{"Show.S01E02.HDTV", ""},
+	}
+	for _, test := range tests {
+		aired := ParseEpisodes(test.name).Aired
+		got := ""
+		if aired.IsZero() == false {
+			got = aired.Format("2006-01-02")
+		}
+		if got != test.aired {
+			t.Errorf("ParseEpisodes(%q).Aired = %q, want %q", test.name, got, test.aired)
+		}
+	}
+}
+
+func TestMatchesFile(t *testing.T) {
+	aired, _ := time.Parse("2006-01-02", "2014-03-02")
+	tests := []struct {
+		ref  EpisodeRef
+		path string
+		want bool
+	}{
+		{EpisodeRef{Season: 1, Episode: 2}, "Show.S01/Show.S01E02.mkv", true},
+		{EpisodeRef{Season: 1, Episode: 3}, "Show.S01/Show.S01E02.mkv", false},
+		{EpisodeRef{Season: 2, Episode: 2}, "Show.S01/Show.S01E02.mkv", false},
+		{EpisodeRef{Season: 1, Episode: 2}, "Show.S01/Show.S01E01E02.mkv", true},
+		{EpisodeRef{Season: 1, Episode: 2}, "Show.S01/Show.S01E01-E03.mkv", true},
+		// the episodes of the file, the season of its directory
+		{EpisodeRef{Season: 2, Episode: 4}, "Show.S02/Episode 04.mkv", true},
+		{EpisodeRef{Season: 1, Episode: 4}, "Show.S02/04.mkv", false},
+		{EpisodeRef{Season: 1, Episode: 1, Absolute: []int{27}}, "Show/[Group] Show - 27 [720p].mkv", true},
+		{EpisodeRef{Season: 3, Episode: 1, Aired: aired}, "Show/Show.2014.03.02.mkv", true},
+		{EpisodeRef{Season: 3, Episode: 1, Aired: aired}, "Show/Show.2014.03.03.mkv", false},
+	}
+	for _, test := range tests {
+		if got := test.ref.matchesFile(test.path); got != test.want {
+			t.Errorf("%v matchesFile(%q) = %t, want %t", test.ref, test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package bittorrent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+// RemoveOptions tells what goes away with a torrent, its files staying on
+// disk when none is set.
+type RemoveOptions struct {
+	DeleteFiles      bool `json:"delete_files"`      // all of them
+	DeleteIncomplete bool `json:"delete_incomplete"` // only those not fully downloaded
+	DeleteFolder     bool `json:"delete_folder"`     // the folders of the torrent, once empty
+	DeleteExtras     bool `json:"delete_extras"`     // the subtitles and NFOs of the files
+}
+
+// the session lets go of the files a moment after the torrent is removed,
+// until then Windows won't delete them
+const (
+	removalAttempts = 10
+	removalDelay    = time.Second
+)
+
+var extraExtensions = map[string]bool{
+	".srt": true,
+	".sub": true,
+	".idx": true,
+	".ass": true,
+	".ssa": true,
+	".vtt": true,
+	".smi": true,
+	".nfo": true,
+}
+
+func isExtra(path string) bool {
+	return extraExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// fileComplete tells whether all the pieces of the file are downloaded.
+func fileComplete(torrentHandle libtorrent.Torrent_handle, torrentInfo libtorrent.Torrent_info, file libtorrent.File_entry) bool {
+	if file.GetSize() == 0 {
+		return true
+	}
+	pieceLength := int64(torrentInfo.Piece_length())
+	lastPiece := int((file.GetOffset() + file.GetSize() - 1) / pieceLength)
+	for piece := int(file.GetOffset() / pieceLength); piece <= lastPiece; piece++ {
+		if torrentHandle.Have_piece(piece) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// sidecars are the subtitles and NFOs next to the file and named after it,
+// as the players and the subtitles addons write them: the name, then the
+// language or the extension. Only those, other titles share the folder.
+func sidecars(path string) []string {
+	found := make([]string, 0)
+	infos, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return found
+	}
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	for _, info := range infos {
+		if info.IsDir() == false && isExtra(info.Name()) && strings.HasPrefix(info.Name(), base+".") {
+			found = append(found, filepath.Join(filepath.Dir(path), info.Name()))
+		}
+	}
+	return found
+}
+
+// removalPaths lists the files the options delete, and the folders of the
+// torrent, deepest first. Torrents of a single file have no folder of their
+// own, the one they're saved in is left alone.
+func removalPaths(torrentHandle libtorrent.Torrent_handle, options RemoveOptions) ([]string, []string) {
+	paths := make([]string, 0)
+	folders := make([]string, 0)
+	status := torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_save_path))
+	if status.GetHas_metadata() == false {
+		return paths, folders
+	}
+	savePath := status.GetSave_path()
+	torrentInfo := torrentHandle.Torrent_file()
+	defer libtorrent.DeleteTorrent_info(torrentInfo)
+
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if seen[path] == false {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	seenFolders := make(map[string]bool)
+	for i := 0; i < torrentInfo.Num_files(); i++ {
+		file := torrentInfo.File_at(i)
+		path := filepath.Join(savePath, file.GetPath())
+		switch {
+		case options.DeleteFiles:
+			add(path)
+		case options.DeleteIncomplete && fileComplete(torrentHandle, torrentInfo, file) == false:
+			add(path)
+		case options.DeleteExtras && isExtra(path):
+			add(path)
+		}
+		if options.DeleteExtras {
+			for _, sidecar := range sidecars(path) {
+				add(sidecar)
+			}
+		}
+		if options.DeleteFolder {
+			for folder := filepath.Dir(file.GetPath()); folder != "."; folder = filepath.Dir(folder) {
+				seenFolders[filepath.Join(savePath, folder)] = true
+			}
+		}
+	}
+	for folder := range seenFolders {
+		folders = append(folders, folder)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(folders)))
+	return paths, folders
+}
+
+// RemovalFiles lists what removing the torrent with the options would
+// delete, to ask the user first.
+func (s *BTService) RemovalFiles(infoHash string, options RemoveOptions) ([]string, error) {
+	torrentHandle, err := s.findTorrent(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	paths, _ := removalPaths(torrentHandle, options)
+	return paths, nil
+}
+
+// deleteRemoved deletes the files of a removed torrent, then its folders
+// left empty, trying again for the files the session still holds.
+func (s *BTService) deleteRemoved(paths []string, folders []string) {
+	for attempt := 0; attempt < removalAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(removalDelay)
+		}
+		left := make([]string, 0)
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil && os.IsNotExist(err) == false {
+				left = append(left, path)
+			}
+		}
+		paths = left
+		for _, folder := range folders {
+			os.Remove(folder) // fails while it isn't empty
+		}
+		if len(paths) == 0 {
+			break
+		}
+	}
+	for _, path := range paths {
+		s.log.Warning("Unable to delete %s", path)
+	}
+}
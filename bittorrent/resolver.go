@@ -0,0 +1,153 @@
+package bittorrent
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	hexHashRe    = regexp.MustCompile(`(?i)\b([0-9a-f]{40})\b`)
+	base32HashRe = regexp.MustCompile(`\b([A-Z2-7]{32})\b`)
+
+	errRedirectedToMagnet = errors.New("redirected to a magnet")
+)
+
+// NormalizeURI undoes the encodings providers like to wrap their links in:
+// URL-encoded or base64-encoded magnets, and base32/base64 info hashes.
+func NormalizeURI(uri string) string {
+	uri = strings.TrimSpace(uri)
+	if strings.HasPrefix(strings.ToLower(uri), "magnet%3a") {
+		if unescaped, err := url.QueryUnescape(uri); err == nil {
+			uri = unescaped
+		}
+	}
+	if strings.Contains(uri, ":") == false {
+		if decoded, err := base64.StdEncoding.DecodeString(uri); err == nil && strings.HasPrefix(string(decoded), "magnet:") {
+			uri = string(decoded)
+		} else if decoded, err := base64.URLEncoding.DecodeString(uri); err == nil && strings.HasPrefix(string(decoded), "magnet:") {
+			uri = string(decoded)
+		}
+	}
+	if strings.HasPrefix(uri, "magnet:") == false {
+		return uri
+	}
+
+	magnetURI, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	vals := magnetURI.Query()
+	xt := vals.Get("xt")
+	if strings.HasPrefix(strings.ToLower(xt), "urn:btih:") == false {
+		return uri
+	}
+	if hash := normalizeInfoHash(xt[len("urn:btih:"):]); hash != "" {
+		vals.Set("xt", "urn:btih:"+hash)
+		// some providers encode the display name twice
+		if dn, err := url.QueryUnescape(vals.Get("dn")); err == nil {
+			vals.Set("dn", dn)
+		}
+		return "magnet:?" + vals.Encode()
+	}
+	return uri
+}
+
+// normalizeInfoHash returns the lowercase hex form of a hex, base32 or
+// base64 info hash, or "" if it's none of them.
+func normalizeInfoHash(hash string) string {
+	switch len(hash) {
+	case 40:
+		if _, err := hex.DecodeString(hash); err == nil {
+			return strings.ToLower(hash)
+		}
+	case 32:
+		if raw, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash)); err == nil {
+			return hex.EncodeToString(raw)
+		}
+	}
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+		if raw, err := encoding.DecodeString(hash); err == nil && len(raw) == 20 {
+			return hex.EncodeToString(raw)
+		}
+	}
+	return ""
+}
+
+// ExtractInfoHash finds the info hash of a magnet, or of a .torrent URL
+// that carries it, like most torrent caches do.
+func ExtractInfoHash(uri string) string {
+	uri = NormalizeURI(uri)
+	if strings.HasPrefix(uri, "magnet:") {
+		if magnetURI, err := url.Parse(uri); err == nil {
+			return normalizeInfoHash(strings.TrimPrefix(magnetURI.Query().Get("xt"), "urn:btih:"))
+		}
+		return ""
+	}
+	uri = strings.Split(uri, "|")[0]
+	if match := hexHashRe.FindStringSubmatch(uri); match != nil {
+		return strings.ToLower(match[1])
+	}
+	if match := base32HashRe.FindStringSubmatch(uri); match != nil {
+		return normalizeInfoHash(match[1])
+	}
+	return ""
+}
+
+// fetchTorrentFile downloads a .torrent, following redirects. Some sites
+// redirect to a magnet instead, in which case it is returned.
+func fetchTorrentFile(req *http.Request) (*http.Response, string, error) {
+	magnet := ""
+	client := &http.Client{
+		Transport: httpClient.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme == "magnet" {
+				magnet = req.URL.String()
+				return errRedirectedToMagnet
+			}
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if magnet != "" {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return nil, NormalizeURI(magnet), nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		return nil, "", errors.New(resp.Status)
+	}
+	return resp, "", nil
+}
+
+// fallbackToMagnet turns the torrent into a magnet when its .torrent file
+// couldn't be downloaded, as long as we know its info hash.
+func (t *Torrent) fallbackToMagnet(cause error) error {
+	if t.InfoHash == "" {
+		return cause
+	}
+	params := url.Values{}
+	params.Set("xt", "urn:btih:"+t.InfoHash)
+	if t.Name != "" {
+		params.Set("dn", t.Name)
+	}
+	for _, tracker := range t.Trackers {
+		params.Add("tr", tracker)
+	}
+	t.URI = "magnet:?" + params.Encode()
+	t.hasResolved = true
+	return nil
+}
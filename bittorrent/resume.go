@@ -0,0 +1,423 @@
+package bittorrent
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+	"github.com/steeve/pulsar/clock"
+)
+
+const (
+	resumeDataTimeout = 10 * time.Second
+	// restored streams nobody plays again are removed after this long
+	restoredStreamLinger = 10 * time.Minute
+	restoredStreamCheck  = 1 * time.Minute
+	// how long after a restart players can resume their HTTP streams
+	reconnectWindow = 30 * time.Second
+
+	resumeDataExtension = ".fastresume"
+	resumeTorrentsFile  = "torrents.json"
+	resumeSessionFile   = "session.state"
+)
+
+// resumeEntry is what we need besides the fast-resume data, which holds
+// the pieces, priorities and stats, to add a torrent back after a restart.
+type resumeEntry struct {
+	InfoHash    string   `json:"info_hash"`
+	URI         string   `json:"uri"`
+	SavePath    string   `json:"save_path"`
+	Download    bool     `json:"download"`
+	DeleteAfter bool     `json:"delete_after"`
+	Files       []string `json:"files"`
+
+	// for the streams seeding after playback
+	Seeding      *SeedPolicy `json:"seeding,omitempty"`
+	SeedingSince time.Time   `json:"seeding_since,omitempty"`
+}
+
+func (s *BTService) resumeFile(name string) string {
+	return filepath.Join(s.Config().ResumePath, name)
+}
+
+// writeFileAtomic makes sure a crash while saving doesn't leave a truncated
+// file behind.
+func writeFileAtomic(filename string, data []byte) error {
+	tmpPath := filename + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filename)
+}
+
+// Must be called with downloadsLock held.
+func (s *BTService) resumeEntryOf(torrentHandle libtorrent.Torrent_handle, infoHash string) *resumeEntry {
+	entry := &resumeEntry{
+		InfoHash: infoHash,
+		SavePath: torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_save_path)).GetSave_path(),
+		Files:    make([]string, 0),
+	}
+	torrentInfo := torrentHandle.Torrent_file()
+	for i := 0; i < torrentInfo.Num_files(); i++ {
+		entry.Files = append(entry.Files, torrentInfo.File_at(i).GetPath())
+	}
+	libtorrent.DeleteTorrent_info(torrentInfo)
+	if _, isDownload := s.downloads[infoHash]; isDownload {
+		entry.Download = true
+		if item := s.queueItem(infoHash); item != nil {
+			entry.URI = item.URI
+		}
+		return entry
+	}
+	s.seedingLock.Lock()
+	seeding, isSeeding := s.seeding[infoHash]
+	s.seedingLock.Unlock()
+	if isSeeding {
+		policy := seeding.policy
+		entry.URI = seeding.uri
+		entry.DeleteAfter = seeding.deleteAfter
+		entry.Seeding = &policy
+		entry.SeedingSince = seeding.since
+		return entry
+	}
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+	for btp := range s.streams {
+		if btp.torrentHandle != nil && btp.torrentHandle.Equal(torrentHandle) {
+			entry.URI = btp.uri
+			entry.DeleteAfter = btp.deleteAfter
+			return entry
+		}
+	}
+	// restored, and not played again yet
+	if restored, ok := s.lingering[infoHash]; ok {
+		entry.URI = restored.URI
+		entry.DeleteAfter = restored.DeleteAfter
+	}
+	return entry
+}
+
+// SaveResumeData writes the fast-resume data of every torrent, along with
+// the list of torrents and the session state, to the resume directory.
+func (s *BTService) SaveResumeData() error {
+	if s.Config().ResumePath == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.Config().ResumePath, 0755); err != nil {
+		return err
+	}
+
+	alerts, alertsDone := s.Alerts()
+	defer close(alertsDone)
+
+	entries := make(map[string]*resumeEntry)
+	s.downloadsLock.Lock()
+	torrentsVector := s.Session.Get_torrents()
+	for i := 0; i < int(torrentsVector.Size()); i++ {
+		torrentHandle := torrentsVector.Get(i)
+		if torrentHandle.Is_valid() == false {
+			continue
+		}
+		status := torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_name))
+		if status.GetHas_metadata() == false {
+			continue
+		}
+		infoHash := infoHashOf(torrentHandle)
+		entries[infoHash] = s.resumeEntryOf(torrentHandle, infoHash)
+		torrentHandle.Save_resume_data(int(libtorrent.Torrent_handleFlush_disk_cache | libtorrent.Torrent_handleSave_info_dict))
+	}
+	s.downloadsLock.Unlock()
+
+	pending := len(entries)
+	timeout := time.After(resumeDataTimeout)
+	for pending > 0 {
+		select {
+		case alert, ok := <-alerts:
+			if !ok {
+				pending = 0
+				break
+			}
+			switch alert.Xtype() {
+			case libtorrent.Save_resume_data_alertAlert_type:
+				resumeAlert := libtorrent.SwigcptrSave_resume_data_alert(alert.Swigcptr())
+				infoHash := infoHashOf(resumeAlert.GetHandle())
+				data := []byte(libtorrent.Bencode(resumeAlert.GetResume_data()))
+				if err := writeFileAtomic(s.resumeFile(infoHash+resumeDataExtension), data); err != nil {
+					s.log.Error("Unable to save the resume data of %s: %s", infoHash, err)
+				}
+				pending--
+			case libtorrent.Save_resume_data_failed_alertAlert_type:
+				failedAlert := libtorrent.SwigcptrSave_resume_data_failed_alert(alert.Swigcptr())
+				s.log.Warning("Unable to save the resume data of %s: %s", infoHashOf(failedAlert.GetHandle()), alert.Message())
+				pending--
+			}
+		case <-timeout:
+			s.log.Warning("Timed out waiting for the resume data of %d torrents", pending)
+			pending = 0
+		}
+	}
+
+	list := make([]*resumeEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.resumeFile(resumeTorrentsFile), data); err != nil {
+		return err
+	}
+	s.pruneResumeData(entries)
+
+	state := bytes.Buffer{}
+	if err := s.WriteState(&state); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.resumeFile(resumeSessionFile), state.Bytes()); err != nil {
+		return err
+	}
+
+	s.log.Info("Saved the resume data of %d torrents", len(list))
+	return nil
+}
+
+// pruneResumeData removes the resume data of torrents that are gone.
+func (s *BTService) pruneResumeData(entries map[string]*resumeEntry) {
+	files, err := ioutil.ReadDir(s.Config().ResumePath)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		infoHash := strings.TrimSuffix(file.Name(), resumeDataExtension)
+		if infoHash == file.Name() {
+			continue
+		}
+		if _, exists := entries[infoHash]; exists == false {
+			os.Remove(s.resumeFile(file.Name()))
+		}
+	}
+}
+
+// forgetResumeData is for torrents removed on purpose, so they don't come
+// back if we crash before the next save.
+func (s *BTService) forgetResumeData(infoHash string) {
+	if s.Config().ResumePath == "" || infoHash == "" {
+		return
+	}
+	os.Remove(s.resumeFile(infoHash + resumeDataExtension))
+}
+
+// hasResumeData tells whether fast-resume data was saved for the torrent.
+func (s *BTService) hasResumeData(infoHash string) bool {
+	if s.Config().ResumePath == "" {
+		return false
+	}
+	_, err := os.Stat(s.resumeFile(infoHash + resumeDataExtension))
+	return err == nil
+}
+
+// setResumeData feeds the saved fast-resume data, if any, to libtorrent so
+// it doesn't have to recheck the files. The returned vector must be kept
+// until the torrent is added.
+func (s *BTService) setResumeData(torrentParams libtorrent.Add_torrent_params, infoHash string) libtorrent.Std_vector_char {
+	if s.Config().ResumePath == "" || infoHash == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.resumeFile(infoHash + resumeDataExtension))
+	if err != nil {
+		return nil
+	}
+	resumeData := libtorrent.NewStd_vector_char()
+	for _, b := range data {
+		resumeData.Add(b)
+	}
+	torrentParams.SetResume_data(resumeData)
+	return resumeData
+}
+
+func (s *BTService) loadResumeEntries() []*resumeEntry {
+	entries := make([]*resumeEntry, 0)
+	if s.Config().ResumePath == "" {
+		return entries
+	}
+	data, err := ioutil.ReadFile(s.resumeFile(resumeTorrentsFile))
+	if err != nil {
+		return entries
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		s.log.Error("Unable to read the resume data: %s", err)
+	}
+	return entries
+}
+
+func (s *BTService) loadSessionState() {
+	if s.Config().ResumePath == "" {
+		return
+	}
+	stateFile, err := os.Open(s.resumeFile(resumeSessionFile))
+	if err != nil {
+		return
+	}
+	defer stateFile.Close()
+	if err := s.LoadState(stateFile); err != nil {
+		s.log.Error("Unable to restore the session state: %s", err)
+	}
+}
+
+// restoreStreams adds back the torrents that were streaming when we
+// stopped, so playing them again picks up where they were, and those that
+// were seeding after playback. Background downloads are restored along
+// with the queue.
+func (s *BTService) restoreStreams() {
+	restored := make([]libtorrent.Torrent_handle, 0)
+	lingering := make(map[string]*resumeEntry)
+	reconnecting := make(map[string]bool)
+	for _, entry := range s.loadResumeEntries() {
+		if entry.Download {
+			continue
+		}
+		if entry.URI == "" {
+			s.removeOrphanFiles(entry)
+			continue
+		}
+		if entry.Seeding == nil {
+			for _, file := range entry.Files {
+				reconnecting[file] = true
+			}
+		}
+		torrentParams := libtorrent.NewAdd_torrent_params()
+		torrentParams.SetUrl(entry.URI)
+		torrentParams.SetSave_path(entry.SavePath)
+		resumeData := s.setResumeData(torrentParams, entry.InfoHash)
+		torrentHandle := s.Session.Add_torrent(torrentParams)
+		libtorrent.DeleteAdd_torrent_params(torrentParams)
+		if resumeData != nil {
+			libtorrent.DeleteStd_vector_char(resumeData)
+		}
+		if torrentHandle == nil {
+			s.log.Error("Unable to restore torrent %s", entry.InfoHash)
+			s.removeOrphanFiles(entry)
+			continue
+		}
+		if entry.Seeding != nil {
+			s.addSeeding(&seedingTorrent{
+				torrentHandle: torrentHandle,
+				uri:           entry.URI,
+				deleteAfter:   entry.DeleteAfter,
+				policy:        *entry.Seeding,
+				since:         entry.SeedingSince,
+			})
+			continue
+		}
+		restored = append(restored, torrentHandle)
+		lingering[entry.InfoHash] = entry
+	}
+	if len(restored) == 0 {
+		return
+	}
+	s.log.Info("Restored %d streamed torrents", len(restored))
+
+	s.streamsLock.Lock()
+	s.reconnecting = reconnecting
+	s.reconnectUntil = clock.Now().Add(reconnectWindow)
+	s.lingering = lingering
+	s.streamsLock.Unlock()
+
+	go s.dropRestoredStreams(restored)
+}
+
+// removeOrphanFiles deletes the files of a stream that can't be restored
+// and was to be deleted after playing, as nothing else ever would.
+func (s *BTService) removeOrphanFiles(entry *resumeEntry) {
+	if entry.DeleteAfter == false || entry.SavePath == "" {
+		return
+	}
+	for _, file := range entry.Files {
+		path := filepath.Join(entry.SavePath, file)
+		if err := os.Remove(path); err == nil {
+			s.log.Info("Removed orphan file %s", path)
+		}
+	}
+	s.forgetResumeData(entry.InfoHash)
+}
+
+// dropRestoredStreams removes the restored torrents once nothing plays
+// them anymore, be it a player or a reconnected HTTP stream.
+func (s *BTService) dropRestoredStreams(restored []libtorrent.Torrent_handle) {
+	wait := restoredStreamLinger
+	for len(restored) > 0 {
+		select {
+		case <-s.closing:
+			return
+		case <-time.After(wait):
+		}
+		wait = restoredStreamCheck
+		inUse := make([]libtorrent.Torrent_handle, 0, len(restored))
+		for _, torrentHandle := range restored {
+			if torrentHandle.Is_valid() == false {
+				continue
+			}
+			if s.torrentStreamed(torrentHandle) {
+				inUse = append(inUse, torrentHandle)
+				continue
+			}
+			s.log.Info("Restored torrent %s isn't played anymore, removing it", infoHashOf(torrentHandle))
+			s.removeLingering(torrentHandle)
+		}
+		restored = inUse
+	}
+}
+
+// removeLingering removes a restored torrent, and its files if its stream
+// was to delete them.
+func (s *BTService) removeLingering(torrentHandle libtorrent.Torrent_handle) {
+	infoHash := infoHashOf(torrentHandle)
+	s.streamsLock.Lock()
+	entry := s.lingering[infoHash]
+	delete(s.lingering, infoHash)
+	s.streamsLock.Unlock()
+
+	flags := 0
+	if entry != nil && entry.DeleteAfter {
+		flags = int(libtorrent.SessionDelete_files)
+	} else {
+		s.keepStream(torrentHandle)
+	}
+	s.Session.Remove_torrent(torrentHandle, flags)
+	s.forgetResumeData(infoHash)
+}
+
+// torrentStreamed tells whether a player or the HTTP server uses the torrent.
+func (s *BTService) torrentStreamed(torrentHandle libtorrent.Torrent_handle) bool {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+	for btp := range s.streams {
+		if btp.torrentHandle != nil && btp.torrentHandle.Equal(torrentHandle) {
+			return true
+		}
+	}
+	return s.served[infoHashOf(torrentHandle)] > 0
+}
+
+func (s *BTService) addServedFile(infoHash string, delta int) {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+	s.served[infoHash] += delta
+	if s.served[infoHash] <= 0 {
+		delete(s.served, infoHash)
+	}
+}
+
+// isReconnecting tells whether the file was streaming before the restart
+// and its player may come back to it soon.
+func (s *BTService) isReconnecting(name string) bool {
+	s.streamsLock.Lock()
+	defer s.streamsLock.Unlock()
+	return s.reconnecting[name] && clock.Now().Before(s.reconnectUntil)
+}
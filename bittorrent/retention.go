@@ -0,0 +1,298 @@
+package bittorrent
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+	"github.com/steeve/pulsar/diskusage"
+)
+
+var (
+	ErrKeptMissing    = errors.New("the kept files are gone")
+	ErrKeptIncomplete = errors.New("the kept files are incomplete")
+)
+
+// KeptTorrent is a streamed torrent whose files were kept after playing,
+// which the retention policy may evict once the cache is full.
+type KeptTorrent struct {
+	InfoHash    string    `json:"info_hash"`
+	Name        string    `json:"name"`
+	SavePath    string    `json:"save_path"`
+	Files       []string  `json:"files"`
+	Size        int64     `json:"size"`
+	LastWatched time.Time `json:"last_watched"`
+	// the length of the files that were on disk, and whether the stream
+	// was left before it was all downloaded
+	FileSizes  map[string]int64 `json:"file_sizes,omitempty"`
+	Incomplete bool             `json:"incomplete,omitempty"`
+}
+
+type StorageUsage struct {
+	DownloadPath string         `json:"download_path"`
+	Used         int64          `json:"used"` // by the kept streams
+	MaxCacheSize int64          `json:"max_cache_size"`
+	DiskFree     int64          `json:"disk_free"`
+	DiskTotal    int64          `json:"disk_total"`
+	Buffered     int64          `json:"buffered"` // by the streams in memory
+	Torrents     []*KeptTorrent `json:"torrents"`
+}
+
+type byLastWatched []*KeptTorrent
+
+func (a byLastWatched) Len() int           { return len(a) }
+func (a byLastWatched) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byLastWatched) Less(i, j int) bool { return a[i].LastWatched.Before(a[j].LastWatched) }
+
+func (s *BTService) loadKept() {
+	if s.Config().KeptPath == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(s.Config().KeptPath)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &s.kept); err != nil {
+		s.log.Error("Unable to read the kept streams: %s", err)
+	}
+}
+
+// Must be called with keptLock held.
+func (s *BTService) saveKept() {
+	if s.Config().KeptPath == "" {
+		return
+	}
+	data, err := json.Marshal(s.kept)
+	if err != nil {
+		s.log.Error("Unable to save the kept streams: %s", err)
+		return
+	}
+	if err := writeFileAtomic(s.Config().KeptPath, data); err != nil {
+		s.log.Error("Unable to save the kept streams: %s", err)
+	}
+}
+
+// keepStream records the files of a stream that are kept after playing,
+// as watched now.
+func (s *BTService) keepStream(torrentHandle libtorrent.Torrent_handle) {
+	status := torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_name | libtorrent.Torrent_handleQuery_save_path))
+	if status.GetHas_metadata() == false {
+		return
+	}
+	kept := &KeptTorrent{
+		InfoHash:    infoHashOf(torrentHandle),
+		Name:        status.GetName(),
+		SavePath:    status.GetSave_path(),
+		Files:       make([]string, 0),
+		LastWatched: time.Now(),
+		FileSizes:   make(map[string]int64),
+		Incomplete:  status.GetProgress() < 1,
+	}
+	torrentInfo := torrentHandle.Torrent_file()
+	for i := 0; i < torrentInfo.Num_files(); i++ {
+		file := torrentInfo.File_at(i)
+		kept.Files = append(kept.Files, file.GetPath())
+		if _, err := os.Stat(filepath.Join(kept.SavePath, file.GetPath())); err == nil {
+			kept.FileSizes[file.GetPath()] = file.GetSize()
+		}
+	}
+	libtorrent.DeleteTorrent_info(torrentInfo)
+	kept.Size = kept.sizeOnDisk()
+
+	s.keptLock.Lock()
+	s.forgetKept(kept.InfoHash)
+	s.kept = append(s.kept, kept)
+	s.saveKept()
+	s.keptLock.Unlock()
+}
+
+// Must be called with keptLock held.
+func (s *BTService) forgetKept(infoHash string) {
+	for i, kept := range s.kept {
+		if kept.InfoHash == infoHash {
+			s.kept = append(s.kept[:i], s.kept[i+1:]...)
+			return
+		}
+	}
+}
+
+func (kept *KeptTorrent) sizeOnDisk() int64 {
+	size := int64(0)
+	for _, file := range kept.Files {
+		if info, err := os.Stat(filepath.Join(kept.SavePath, file)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// verify checks that the files are still there, at their length.
+func (kept *KeptTorrent) verify() error {
+	if kept.FileSizes == nil {
+		// kept before the lengths were
+		if kept.sizeOnDisk() == 0 {
+			return ErrKeptMissing
+		}
+		return nil
+	}
+	if kept.Incomplete {
+		return ErrKeptIncomplete
+	}
+	for file, size := range kept.FileSizes {
+		info, err := os.Stat(filepath.Join(kept.SavePath, file))
+		if err != nil {
+			return ErrKeptMissing
+		}
+		if info.Size() != size {
+			return ErrKeptIncomplete
+		}
+	}
+	return nil
+}
+
+// VerifyKept tells whether the kept files of the torrent can still be
+// played, forgetting them when they're gone. Torrents that weren't kept,
+// or are in the session, are fine.
+func (s *BTService) VerifyKept(infoHash string) error {
+	if _, err := s.findTorrent(infoHash); err == nil {
+		return nil
+	}
+	s.keptLock.Lock()
+	defer s.keptLock.Unlock()
+	for _, kept := range s.kept {
+		if kept.InfoHash != infoHash {
+			continue
+		}
+		err := kept.verify()
+		if err == ErrKeptMissing {
+			s.log.Warning("The kept files of %s are gone", kept.Name)
+			s.forgetKept(kept.InfoHash)
+			s.saveKept()
+		}
+		return err
+	}
+	return nil
+}
+
+// evictable tells whether a kept torrent can go: not when it's back in the
+// session, being played or seeded.
+func (s *BTService) evictable(kept *KeptTorrent) bool {
+	_, err := s.findTorrent(kept.InfoHash)
+	return err != nil
+}
+
+// Must be called with keptLock held.
+func (s *BTService) evict(kept *KeptTorrent) {
+	s.log.Info("Evicting %s from the streams cache", kept.Name)
+	for _, file := range kept.Files {
+		path := filepath.Join(kept.SavePath, file)
+		os.Remove(path)
+		removeEmptyDirs(filepath.Dir(path), kept.SavePath)
+	}
+	s.forgetKept(kept.InfoHash)
+}
+
+// removeEmptyDirs removes dir and its parents up to root, while they're
+// empty.
+func removeEmptyDirs(dir string, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && len(dir) > len(root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+// EnforceRetention evicts the least recently watched kept streams until
+// they fit in the maximum cache size, if there's one.
+func (s *BTService) EnforceRetention() error {
+	if s.Config().MaxCacheSize <= 0 {
+		return nil
+	}
+	s.keptLock.Lock()
+	defer s.keptLock.Unlock()
+
+	used := s.refreshKept()
+	if used <= s.Config().MaxCacheSize {
+		return nil
+	}
+	oldest := make([]*KeptTorrent, len(s.kept))
+	copy(oldest, s.kept)
+	sort.Sort(byLastWatched(oldest))
+	for _, kept := range oldest {
+		if used <= s.Config().MaxCacheSize {
+			break
+		}
+		if s.evictable(kept) == false {
+			continue
+		}
+		s.evict(kept)
+		used -= kept.Size
+	}
+	s.saveKept()
+	if used > s.Config().MaxCacheSize {
+		s.log.Warning("The streams cache is still over its size, what's left is in use")
+	}
+	return nil
+}
+
+// refreshKept updates the sizes of the kept streams, forgetting those
+// whose files are gone, and returns their total. Must be called with
+// keptLock held.
+func (s *BTService) refreshKept() int64 {
+	used := int64(0)
+	kept := make([]*KeptTorrent, 0, len(s.kept))
+	for _, torrent := range s.kept {
+		if torrent.Size = torrent.sizeOnDisk(); torrent.Size > 0 {
+			kept = append(kept, torrent)
+			used += torrent.Size
+		}
+	}
+	s.kept = kept
+	return used
+}
+
+// ClearKept evicts every kept stream not in use, and returns how many bytes
+// were freed.
+func (s *BTService) ClearKept() int64 {
+	s.keptLock.Lock()
+	defer s.keptLock.Unlock()
+
+	s.refreshKept()
+	freed := int64(0)
+	for _, kept := range append([]*KeptTorrent{}, s.kept...) {
+		if s.evictable(kept) {
+			s.evict(kept)
+			freed += kept.Size
+		}
+	}
+	s.saveKept()
+	return freed
+}
+
+func (s *BTService) StorageUsage() *StorageUsage {
+	s.keptLock.Lock()
+	used := s.refreshKept()
+	torrents := make([]*KeptTorrent, len(s.kept))
+	copy(torrents, s.kept)
+	s.keptLock.Unlock()
+
+	sort.Sort(sort.Reverse(byLastWatched(torrents)))
+	usage := &StorageUsage{
+		DownloadPath: s.Config().DownloadPath,
+		Used:         used,
+		MaxCacheSize: s.Config().MaxCacheSize,
+		Torrents:     torrents,
+	}
+	usage.Buffered, _ = s.memoryUsage()
+	if disk, err := diskusage.DiskUsage(s.Config().DownloadPath); err == nil {
+		usage.DiskFree = disk.Free
+		usage.DiskTotal = disk.All
+	}
+	return usage
+}
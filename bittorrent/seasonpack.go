@@ -0,0 +1,77 @@
+package bittorrent
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+// season packs rank below the single episodes, all else being equal
+const seasonPackPenalty = 0.75
+
+var (
+	seasonRangeRe = regexp.MustCompile(`(?:^|[^a-z0-9])s(?:eason)?[ ._-]?(\d{1,2})[ ._-]*(?:-|to)[ ._-]*s?(?:eason)?[ ._-]?(\d{1,2})(?:[^0-9e]|$)`)
+)
+
+func seasonRe(season int) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`(?:^|[^a-z0-9])(?:s0*%d|season[ ._-]?0*%d)(?:[^0-9e]|$)`, season, season))
+}
+
+// IsSeasonPack tells whether the torrent name looks like a whole season
+// (or a range of seasons) that contains season.
+func IsSeasonPack(name string, season int) bool {
+	name = strings.ToLower(name)
+	// an episode number means it's not a pack
+	if len(ParseEpisodes(name).Episodes) > 0 {
+		return false
+	}
+	if seasonRe(season).MatchString(name) {
+		return true
+	}
+	if match := seasonRangeRe.FindStringSubmatch(name); match != nil {
+		first, _ := strconv.Atoi(match[1])
+		last, _ := strconv.Atoi(match[2])
+		return season >= first && season <= last
+	}
+	return false
+}
+
+// SetEpisode makes the player stream the file of this episode when the
+// torrent turns out to be a season pack.
+func (btp *BTPlayer) SetEpisode(season int, episode int) {
+	btp.episode = &EpisodeRef{Season: season, Episode: episode}
+}
+
+// findEpisodeFile returns the biggest file matching the episode, and its
+// index, or -1 when there's none.
+func (btp *BTPlayer) findEpisodeFile() (libtorrent.File_entry, int) {
+	var episodeFile libtorrent.File_entry
+	index := -1
+	maxSize := int64(0)
+	for i := 0; i < btp.torrentInfo.Num_files(); i++ {
+		fe := btp.torrentInfo.File_at(i)
+		if btp.episode.matchesFile(fe.GetPath()) && fe.GetSize() > maxSize {
+			maxSize = fe.GetSize()
+			episodeFile = fe
+			index = i
+		}
+	}
+	return episodeFile, index
+}
+
+// Only download the episode file out of the pack.
+func (btp *BTPlayer) prioritizeEpisodeFile() {
+	filesPriorities := libtorrent.NewStd_vector_int()
+	defer libtorrent.DeleteStd_vector_int(filesPriorities)
+	for i := 0; i < btp.torrentInfo.Num_files(); i++ {
+		if i == btp.episodeFileIndex {
+			filesPriorities.Add(1)
+		} else {
+			filesPriorities.Add(0)
+		}
+	}
+	btp.torrentHandle.Prioritize_files(filesPriorities)
+}
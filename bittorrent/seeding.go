@@ -0,0 +1,215 @@
+package bittorrent
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+const seedingCheckInterval = 30 * time.Second
+
+// SeedPolicy is how long a played torrent keeps seeding: until it reaches
+// the ratio or has seeded for the time, whichever comes first. Forever
+// keeps it seeding until it's stopped.
+type SeedPolicy struct {
+	Ratio   float64       `json:"ratio,omitempty"`
+	Time    time.Duration `json:"time,omitempty"`
+	Forever bool          `json:"forever,omitempty"`
+}
+
+func (policy *SeedPolicy) active() bool {
+	return policy.Forever || policy.Ratio > 0 || policy.Time > 0
+}
+
+type seedingTorrent struct {
+	torrentHandle libtorrent.Torrent_handle
+	uri           string
+	deleteAfter   bool
+	policy        SeedPolicy
+	since         time.Time
+}
+
+type SeedingStatus struct {
+	InfoHash    string     `json:"info_hash"`
+	Name        string     `json:"name"`
+	Ratio       float64    `json:"ratio"`
+	Uploaded    int64      `json:"uploaded"`
+	UploadRate  int        `json:"upload_rate"`
+	Peers       int        `json:"peers"`
+	Since       time.Time  `json:"since"`
+	Policy      SeedPolicy `json:"policy"`
+	DeleteAfter bool       `json:"delete_after"`
+}
+
+type bySeedingSince []*SeedingStatus
+
+func (a bySeedingSince) Len() int           { return len(a) }
+func (a bySeedingSince) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bySeedingSince) Less(i, j int) bool { return a[i].Since.Before(a[j].Since) }
+
+func (s *BTService) sessionSeedPolicy() SeedPolicy {
+	return SeedPolicy{
+		Ratio: s.Config().SeedRatio,
+		Time:  s.Config().SeedTime,
+	}
+}
+
+// startSeeding keeps the torrent of a stream that ended seeding, if the
+// settings ask for it. It only uploads from then on.
+func (s *BTService) startSeeding(btp *BTPlayer) bool {
+	policy := s.sessionSeedPolicy()
+	if policy.active() == false || btp.torrentHandle == nil || btp.torrentHandle.Is_valid() == false {
+		return false
+	}
+	s.addSeeding(&seedingTorrent{
+		torrentHandle: btp.torrentHandle,
+		uri:           btp.uri,
+		deleteAfter:   btp.deleteAfter,
+		policy:        policy,
+		since:         time.Now(),
+	})
+	btp.log.Info("Seeding until a ratio of %.2f or for %s", policy.Ratio, policy.Time)
+	return true
+}
+
+func (s *BTService) addSeeding(seeding *seedingTorrent) {
+	seeding.torrentHandle.Set_upload_mode(true)
+	s.seedingLock.Lock()
+	s.seeding[infoHashOf(seeding.torrentHandle)] = seeding
+	s.seedingLock.Unlock()
+}
+
+// unseed takes back a seeding torrent that's played again.
+func (s *BTService) unseed(torrentHandle libtorrent.Torrent_handle) {
+	infoHash := infoHashOf(torrentHandle)
+	s.seedingLock.Lock()
+	_, ok := s.seeding[infoHash]
+	delete(s.seeding, infoHash)
+	s.seedingLock.Unlock()
+	if ok {
+		torrentHandle.Set_upload_mode(false)
+	}
+}
+
+func seedingRatio(status libtorrent.Torrent_status) float64 {
+	if status.GetTotal_wanted_done() == 0 {
+		return 0
+	}
+	return float64(status.GetAll_time_upload()) / float64(status.GetTotal_wanted_done())
+}
+
+func (seeding *seedingTorrent) done(status libtorrent.Torrent_status) bool {
+	if seeding.policy.Forever {
+		return false
+	}
+	if seeding.policy.Ratio > 0 && seedingRatio(status) >= seeding.policy.Ratio {
+		return true
+	}
+	return seeding.policy.Time > 0 && time.Since(seeding.since) >= seeding.policy.Time
+}
+
+// seedingMonitor stops the torrents that met their seeding policy.
+func (s *BTService) seedingMonitor() {
+	ticker := time.NewTicker(seedingCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+		}
+		s.seedingLock.Lock()
+		done := make([]string, 0)
+		for infoHash, seeding := range s.seeding {
+			if seeding.torrentHandle.Is_valid() == false {
+				delete(s.seeding, infoHash)
+				continue
+			}
+			if seeding.done(seeding.torrentHandle.Status(uint(0))) {
+				done = append(done, infoHash)
+			}
+		}
+		s.seedingLock.Unlock()
+		for _, infoHash := range done {
+			s.log.Info("%s met its seeding target", infoHash)
+			s.StopSeeding(infoHash)
+		}
+	}
+}
+
+var errNotSeeding = errors.New("the torrent isn't seeding")
+
+// StopSeeding removes a seeding torrent, and its files if its stream was
+// to delete them.
+func (s *BTService) StopSeeding(infoHash string) error {
+	s.seedingLock.Lock()
+	seeding, ok := s.seeding[infoHash]
+	delete(s.seeding, infoHash)
+	s.seedingLock.Unlock()
+	if ok == false {
+		return errNotSeeding
+	}
+	if s.torrentStreamed(seeding.torrentHandle) {
+		// played again, its player removes it
+		return nil
+	}
+
+	s.forgetResumeData(infoHash)
+	if seeding.deleteAfter {
+		s.Session.Remove_torrent(seeding.torrentHandle, int(libtorrent.SessionDelete_files))
+	} else {
+		s.moveFromStaging(seeding.torrentHandle)
+		s.keepStream(seeding.torrentHandle)
+		s.Session.Remove_torrent(seeding.torrentHandle, 0)
+		go s.EnforceRetention()
+	}
+	s.log.Info("Stopped seeding %s", infoHash)
+	return nil
+}
+
+// KeepSeeding seeds the torrent until it's stopped, whatever the settings.
+func (s *BTService) KeepSeeding(infoHash string) error {
+	return s.SetSeedPolicy(infoHash, SeedPolicy{Forever: true})
+}
+
+func (s *BTService) SetSeedPolicy(infoHash string, policy SeedPolicy) error {
+	if policy.active() == false {
+		return errors.New("no seeding target")
+	}
+	s.seedingLock.Lock()
+	defer s.seedingLock.Unlock()
+	seeding, ok := s.seeding[infoHash]
+	if ok == false {
+		return errNotSeeding
+	}
+	seeding.policy = policy
+	return nil
+}
+
+// Seeding lists the torrents seeding after playback, the oldest first.
+func (s *BTService) Seeding() []*SeedingStatus {
+	s.seedingLock.Lock()
+	defer s.seedingLock.Unlock()
+	list := make([]*SeedingStatus, 0, len(s.seeding))
+	for infoHash, seeding := range s.seeding {
+		if seeding.torrentHandle.Is_valid() == false {
+			continue
+		}
+		status := seeding.torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_name))
+		list = append(list, &SeedingStatus{
+			InfoHash:    infoHash,
+			Name:        status.GetName(),
+			Ratio:       seedingRatio(status),
+			Uploaded:    status.GetAll_time_upload(),
+			UploadRate:  status.GetUpload_rate(),
+			Peers:       status.GetNum_peers(),
+			Since:       seeding.since,
+			Policy:      seeding.policy,
+			DeleteAfter: seeding.deleteAfter,
+		})
+	}
+	sort.Sort(bySeedingSince(list))
+	return list
+}
@@ -1,10 +1,14 @@
 package bittorrent
 
 import (
+	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
+	"path/filepath"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/op/go-logging"
@@ -42,12 +46,24 @@ const (
 	ProxyTypeSocksHTTPPassword
 )
 
+// Traffic classes that can go through the proxy, so that for instance only
+// tracker announces are proxied while peers connect directly.
+const (
+	ProxyTrackers = 1 << iota
+	ProxyPeers
+	ProxyDHT
+	ProxyWebSeeds
+
+	ProxyAll = ProxyTrackers | ProxyPeers | ProxyDHT | ProxyWebSeeds
+)
+
 type ProxySettings struct {
 	Hostname string
 	Port     int
 	Username string
 	Password string
 	Type     int
+	Traffic  int
 }
 
 type BTConfiguration struct {
@@ -56,16 +72,142 @@ type BTConfiguration struct {
 	LowerListenPort int
 	UpperListenPort int
 	DownloadPath    string
+	StagingPath     string
+	SlowStorage     bool
+	PieceCachePath  string
+	PieceCacheSize  int64
 	Proxy           *ProxySettings
+
+	// streaming tuning, for high latency links
+	DeadlineWindow         time.Duration
+	DeadlineAggressiveness int
+	EndgameDuplicates      bool
+
+	UploadAutoTune bool
+	AfterDownloads string
+
+	// background downloads queue
+	QueuePath          string
+	MaxActiveDownloads int
+	QueueDownloadRate  int
+
+	// fast-resume data and session state
+	ResumePath string
+
+	// magnets without working trackers
+	DHTBootstrapNodes []string
+	MetadataTimeout   time.Duration
+
+	// time of day rate limits, and per torrent ones
+	RateSchedule           []string
+	MaxTorrentDownloadRate int
+	MaxTorrentUploadRate   int
+
+	// an IP or interface name to keep the traffic on, and whether to pause
+	// everything when it or the proxy goes down
+	BindInterface string
+	KillSwitch    bool
+
+	// encryption policy, anonymous mode and transport, see PrivacySettings
+	Encryption    string
+	AnonymousMode bool
+	Transport     string
+
+	// streams whose files are kept, and how much of them to keep
+	KeptPath     string
+	MaxCacheSize int64
+
+	// how long played torrents keep seeding, if at all
+	SeedRatio float64
+	SeedTime  time.Duration
+
+	// streams buffered in RAM, on a memory filesystem, until they're over
+	// MemoryBufferSize
+	MemoryBuffer     bool
+	MemoryPath       string
+	MemoryBufferSize int64
+
+	// a download path shared with other instances, see Lease
+	SharedDownloads bool
+	InstanceId      string
+
+	// torrents failing verification too often, see Quarantine
+	QuarantinePath string
+
+	// the client we pass for, by default and by provider or tracker host,
+	// see ClientIdentities
+	ClientIdentity         string
+	SourceClientIdentities map[string]string
+
+	// see CPULimits, CPUCores overrides the number of cores detected
+	CPULimits string
+	CPUCores  int
 }
 
 type BTService struct {
 	Session           libtorrent.Session
-	config            *BTConfiguration
+	config            *BTConfiguration // see Config
+	configLock        sync.RWMutex
 	log               *logging.Logger
 	libtorrentLog     *logging.Logger
 	alertsBroadcaster *broadcast.Broadcaster
 	closing           chan interface{}
+	monitors          sync.WaitGroup // the goroutines Close waits for
+	slowStorage       bool
+	memoryPath        string
+	leases            map[string]*Lease
+	leasesLock        sync.Mutex
+	pieceCache        *PieceCache
+	streams           map[*BTPlayer]bool
+	streamsLock       sync.Mutex
+	served            map[string]int
+	reconnecting      map[string]bool
+	reconnectUntil    time.Time
+	lingering         map[string]*resumeEntry
+	kept              []*KeptTorrent
+	keptLock          sync.Mutex
+	seeding           map[string]*seedingTorrent
+	seedingLock       sync.Mutex
+	downloads         map[string]libtorrent.Torrent_handle
+	downloadsLock     sync.Mutex
+	afterDownloads    string
+	queue             []*QueueItem
+
+	ratesLock    sync.Mutex
+	rateOverride *RateLimits
+	appliedRates *RateLimits
+	torrentRates map[string]*RateLimits
+	tunedUpload  int // by the upload tuner, within the limits
+
+	// see updateSettings
+	settingsLock sync.Mutex
+
+	privacyLock     sync.Mutex
+	privacyOverride *PrivacySettings
+
+	suspendLock sync.Mutex
+	suspended   bool
+
+	quarantineLock sync.Mutex
+	quarantine     map[string]*Quarantine
+	hashFailures   map[string]*hashFailures
+	peerFailures   map[string]int
+
+	identityLock    sync.Mutex
+	identity        string
+	defaultPeerId   libtorrent.Big_number
+	peerIds         map[string]string
+	torrentSources  map[string]string
+	torrentIdentity map[string]string
+	identityOrder   []string
+}
+
+// Config is the configuration in effect, which Reconfigure swaps while the
+// monitors run.
+func (s *BTService) Config() *BTConfiguration {
+	s.configLock.RLock()
+	defer s.configLock.RUnlock()
+	return s.config
 }
 
 func NewBTService(config BTConfiguration) *BTService {
@@ -76,16 +218,58 @@ func NewBTService(config BTConfiguration) *BTService {
 		alertsBroadcaster: broadcast.NewBroadcaster(),
 		config:            &config,
 		closing:           make(chan interface{}),
+		streams:           make(map[*BTPlayer]bool),
+		served:            make(map[string]int),
+		lingering:         make(map[string]*resumeEntry),
+		seeding:           make(map[string]*seedingTorrent),
+		downloads:         make(map[string]libtorrent.Torrent_handle),
+		afterDownloads:    config.AfterDownloads,
+		torrentRates:      make(map[string]*RateLimits),
+		leases:            make(map[string]*Lease),
+		hashFailures:      make(map[string]*hashFailures),
+		peerFailures:      make(map[string]int),
+		peerIds:           make(map[string]string),
+		torrentSources:    make(map[string]string),
+		torrentIdentity:   make(map[string]string),
 	}
 
+	s.loadQuarantine()
+	s.defaultPeerId = s.Session.Id()
 	s.configure()
-	go s.alertsConsumer()
-	go s.logAlerts()
-	go s.internetMonitor()
+	s.configureIdentity()
+	s.loadSessionState()
+	s.goMonitor(s.alertsConsumer)
+	s.goMonitor(s.logAlerts)
+	s.goMonitor(s.publishEvents)
+	s.goMonitor(s.internetMonitor)
+	s.goMonitor(s.fairnessScheduler)
+	s.goMonitor(s.uploadTuner)
+	s.goMonitor(s.rateScheduler)
+	s.goMonitor(s.killSwitch)
+	s.goMonitor(s.seedingMonitor)
+	s.goMonitor(s.memoryMonitor)
+	s.goMonitor(s.leaseMonitor)
+	s.goMonitor(s.hashFailureMonitor)
+	s.goMonitor(s.identityMonitor)
+
+	s.loadKept()
+	s.restoreStreams()
+	s.loadQueue()
+	s.goMonitor(s.queueScheduler)
 
 	return s
 }
 
+// goMonitor runs a monitor of the session until closing, which Close waits
+// for before deleting the session.
+func (s *BTService) goMonitor(monitor func()) {
+	s.monitors.Add(1)
+	go func() {
+		defer s.monitors.Done()
+		monitor()
+	}()
+}
+
 func (s *BTService) onInternetCheck(lastConnected bool) bool {
 	_, err := net.LookupHost(internetCheckAddress)
 	connected := (err == nil)
@@ -117,43 +301,75 @@ func (s *BTService) internetMonitor() {
 
 func (s *BTService) Close() {
 	s.log.Info("Stopping BT Services...")
+	if err := s.SaveResumeData(); err != nil {
+		s.log.Error("Unable to save the resume data: %s", err)
+	}
 	close(s.closing)
+	s.monitors.Wait()
 	libtorrent.DeleteSession(s.Session)
+	s.ReleaseLeases()
 }
 
-func (s *BTService) Reconfigure(config BTConfiguration) {
-	s.stopServices()
-	s.config = &config
-	s.configure()
-	s.Listen()
-	s.startServices()
+func (s *BTService) configure() {
+	s.detectSlowStorage()
+	s.detectMemoryStorage()
+	s.configurePieceCache()
+
+	s.applySessionSettings()
+
+	// Add all the libtorrent extensions
+	s.Session.Add_extensions()
+
+	s.applyEncryption()
+
+	s.configureProxy()
+	s.configureIPFilter()
 }
 
-func (s *BTService) configure() {
+func (s *BTService) configurePieceCache() {
+	s.pieceCache = nil
+	if s.Config().PieceCacheSize > 0 && s.Config().PieceCachePath != "" {
+		s.log.Info("Using a %dmb piece cache in %s", s.Config().PieceCacheSize/1024/1024, s.Config().PieceCachePath)
+		s.pieceCache = NewPieceCache(s.Config().PieceCachePath, s.Config().PieceCacheSize)
+	}
+}
+
+// updateSettings changes the session settings. The loops tuning them read
+// them all and write them all back: they change them one at a time, not to
+// undo each other's changes.
+func (s *BTService) updateSettings(update func(settings libtorrent.Session_settings)) {
+	s.settingsLock.Lock()
+	defer s.settingsLock.Unlock()
 	settings := s.Session.Settings()
+	update(settings)
+	s.Session.Set_settings(settings)
+}
 
+// applySessionSettings sets the session settings from the configuration,
+// the rate limits included.
+func (s *BTService) applySessionSettings() {
 	s.log.Info("Setting Session settings...")
 
-	settings.SetUser_agent(util.UserAgent())
+	s.identityLock.Lock()
+	userAgent := s.clientIdentity().UserAgent
+	s.identityLock.Unlock()
+	limits := s.currentCPULimits()
+	if limits.Limited {
+		s.log.Info("Limiting the peers handled on a low-end CPU of %d cores", limits.Cores)
+	}
+
+	s.settingsLock.Lock()
+	settings := s.Session.Settings()
+	settings.SetUser_agent(userAgent)
 
 	settings.SetRequest_timeout(2)
 	settings.SetPeer_connect_timeout(2)
-	settings.SetStrict_end_game_mode(true)
+	// duplicate requests of the last pieces help on high latency links
+	settings.SetStrict_end_game_mode(s.Config().EndgameDuplicates == false)
 	settings.SetAnnounce_to_all_trackers(true)
 	settings.SetAnnounce_to_all_tiers(true)
 	settings.SetConnection_speed(500)
 
-	if s.config.MaxDownloadRate > 0 {
-		s.log.Info("Rate limiting download to %dkb/s", s.config.MaxDownloadRate/1024)
-		settings.SetDownload_rate_limit(s.config.MaxDownloadRate)
-	}
-	if s.config.MaxUploadRate > 0 {
-		s.log.Info("Rate limiting upload to %dkb/s", s.config.MaxUploadRate/1024)
-		// If we have an upload rate, use the nicer bittyrant choker
-		settings.SetChoking_algorithm(int(libtorrent.Session_settingsBittyrant_choker))
-		settings.SetUpload_rate_limit(s.config.MaxUploadRate)
-	}
-
 	settings.SetPeer_tos(ipToSLowCost)
 	settings.SetTorrent_connect_boost(500)
 	settings.SetRate_limit_ip_overhead(true)
@@ -179,45 +395,73 @@ func (s *BTService) configure() {
 	settings.SetAuto_scrape_min_interval(900) // 15 minutes
 	settings.SetIgnore_limits_on_local_network(true)
 	settings.SetRate_limit_utp(true)
-	settings.SetMixed_mode_algorithm(int(libtorrent.Session_settingsPrefer_tcp))
 
 	setPlatformSpecificSettings(settings)
+	s.setCPULimitSettings(settings, limits)
+	s.setSlowStorageSettings(settings)
+	s.setMemoryStorageSettings(settings)
+	s.setBindSettings(settings)
+	s.setPrivacySettings(settings)
 
 	s.Session.Set_settings(settings)
+	s.settingsLock.Unlock()
+	s.applyRateLimits(true)
+}
 
-	// Add all the libtorrent extensions
-	s.Session.Add_extensions()
-
-	s.log.Info("Setting Encryption settings...")
-	encryptionSettings := libtorrent.NewPe_settings()
-	defer libtorrent.DeletePe_settings(encryptionSettings)
-	encryptionSettings.SetOut_enc_policy(byte(libtorrent.Pe_settingsForced))
-	encryptionSettings.SetIn_enc_policy(byte(libtorrent.Pe_settingsForced))
-	encryptionSettings.SetAllowed_enc_level(byte(libtorrent.Pe_settingsBoth))
-	encryptionSettings.SetPrefer_rc4(true)
-	s.Session.Set_pe_settings(encryptionSettings)
+func (s *BTService) configureProxy() {
+	proxy := libtorrent.NewProxy_settings()
+	defer libtorrent.DeleteProxy_settings(proxy)
+	noProxy := libtorrent.NewProxy_settings()
+	defer libtorrent.DeleteProxy_settings(noProxy)
 
-	if s.config.Proxy != nil {
+	traffic := 0
+	if s.Config().Proxy != nil {
 		s.log.Info("Setting Proxy settings...")
-		proxy := libtorrent.NewProxy_settings()
-		defer libtorrent.DeleteProxy_settings(proxy)
-		proxy.SetHostname(s.config.Proxy.Hostname)
-		proxy.SetPort(uint16(s.config.Proxy.Port))
-		proxy.SetUsername(s.config.Proxy.Username)
-		proxy.SetPassword(s.config.Proxy.Password)
-		proxy.SetXtype(byte(s.config.Proxy.Type))
+		traffic = s.Config().Proxy.Traffic
+		if traffic == 0 {
+			traffic = ProxyAll
+		}
+		proxy.SetHostname(s.Config().Proxy.Hostname)
+		proxy.SetPort(uint16(s.Config().Proxy.Port))
+		proxy.SetUsername(s.Config().Proxy.Username)
+		proxy.SetPassword(s.Config().Proxy.Password)
+		proxy.SetXtype(byte(s.Config().Proxy.Type))
 		proxy.SetProxy_hostnames(true)
-		proxy.SetProxy_peer_connections(true)
-		s.Session.Set_proxy(proxy)
+		proxy.SetProxy_peer_connections(traffic&ProxyPeers != 0)
+	}
+
+	choose := func(class int, name string) libtorrent.Proxy_settings {
+		if traffic&class != 0 {
+			s.log.Info("Proxying %s", name)
+			return proxy
+		}
+		return noProxy
 	}
+	s.Session.Set_tracker_proxy(choose(ProxyTrackers, "trackers"))
+	s.Session.Set_peer_proxy(choose(ProxyPeers, "peers"))
+	s.Session.Set_dht_proxy(choose(ProxyDHT, "DHT"))
+	s.Session.Set_web_seed_proxy(choose(ProxyWebSeeds, "web seeds"))
 }
 
 func (s *BTService) Listen() {
 	errCode := libtorrent.NewError_code()
 	defer libtorrent.DeleteError_code(errCode)
-	ports := libtorrent.NewStd_pair_int_int(s.config.LowerListenPort, s.config.UpperListenPort)
+	ports := libtorrent.NewStd_pair_int_int(s.Config().LowerListenPort, s.Config().UpperListenPort)
 	defer libtorrent.DeleteStd_pair_int_int(ports)
-	s.Session.Listen_on(ports, errCode)
+	if s.Config().BindInterface == "" {
+		s.Session.Listen_on(ports, errCode)
+		return
+	}
+	ip, err := util.InterfaceAddress(s.Config().BindInterface)
+	if err != nil {
+		s.log.Error("Unable to bind to %s: %s", s.Config().BindInterface, err)
+		if s.Config().KillSwitch == false {
+			s.Session.Listen_on(ports, errCode)
+		}
+		return
+	}
+	s.log.Info("Listening on %s", ip)
+	s.Session.Listen_on(ports, errCode, ip.String())
 }
 
 func (s *BTService) WriteState(f io.Writer) error {
@@ -241,12 +485,11 @@ func (s *BTService) LoadState(f io.Reader) error {
 }
 
 func (s *BTService) startServices() {
-	s.log.Info("Starting DHT...")
-	for _, node := range dhtBootstrapNodes {
-		pair := libtorrent.NewStd_pair_string_int(node, 6881)
-		defer libtorrent.DeleteStd_pair_string_int(pair)
-		s.Session.Add_dht_router(pair)
+	if s.Suspended() {
+		return
 	}
+	s.log.Info("Starting DHT...")
+	s.addDHTRouters()
 	s.Session.Start_dht()
 
 	s.log.Info("Starting LSD...")
@@ -275,7 +518,8 @@ func (s *BTService) stopServices() {
 
 func (s *BTService) alertsConsumer() {
 	s.Session.Set_alert_mask(uint(libtorrent.AlertStatus_notification |
-		libtorrent.AlertStorage_notification))
+		libtorrent.AlertStorage_notification |
+		libtorrent.AlertIp_block_notification))
 
 	defer s.alertsBroadcaster.Close()
 
@@ -303,6 +547,8 @@ func (s *BTService) Alerts() (<-chan *Alert, chan<- interface{}) {
 	c, done := s.alertsBroadcaster.Listen()
 	ac := make(chan *Alert)
 	go func() {
+		// the listeners ranging over the alerts stop with the session
+		defer close(ac)
 		for v := range c {
 			ac <- v.(*Alert)
 		}
@@ -325,3 +571,148 @@ func (s *BTService) logAlerts() {
 		}
 	}
 }
+
+func (s *BTService) ListenPort() (int, bool) {
+	return int(s.Session.Listen_port()), s.Session.Is_listening()
+}
+
+func LibtorrentVersion() string {
+	return libtorrent.LIBTORRENT_VERSION
+}
+
+func (s *BTService) DHTNodes() int {
+	return s.Session.Status().GetDht_nodes()
+}
+
+// Whether peers were able to connect to us, which means the port is open.
+func (s *BTService) HasIncomingConnections() bool {
+	return s.Session.Status().GetHas_incoming_connections()
+}
+
+// Counts the connected peers, and how many of them negotiated encryption.
+func (s *BTService) EncryptionStats() (int, int) {
+	total := 0
+	encrypted := 0
+	torrentsVector := s.Session.Get_torrents()
+	for i := 0; i < int(torrentsVector.Size()); i++ {
+		torrentHandle := torrentsVector.Get(i)
+		if torrentHandle.Is_valid() == false {
+			continue
+		}
+		peers := libtorrent.NewStd_vector_peer_info()
+		torrentHandle.Get_peer_info(peers)
+		for j := 0; j < int(peers.Size()); j++ {
+			total++
+			flags := peers.Get(j).GetFlags()
+			if flags&uint(libtorrent.Peer_infoRc4_encrypted|libtorrent.Peer_infoPlaintext_encrypted) != 0 {
+				encrypted++
+			}
+		}
+		libtorrent.DeleteStd_vector_peer_info(peers)
+	}
+	return total, encrypted
+}
+
+func (s *BTService) addDownloadTorrent(uri string, infoHash string) (libtorrent.Torrent_handle, error) {
+	if s.Suspended() {
+		return nil, ErrSuspended
+	}
+	if s.IsQuarantined(infoHash) {
+		return nil, ErrQuarantined
+	}
+	if err := s.acquireLease(infoHash); err != nil {
+		return nil, err
+	}
+	torrentParams := libtorrent.NewAdd_torrent_params()
+	defer libtorrent.DeleteAdd_torrent_params(torrentParams)
+
+	torrentParams.SetUrl(uri)
+	torrentParams.SetSave_path(s.SavePath())
+	if resumeData := s.setResumeData(torrentParams, infoHash); resumeData != nil {
+		defer libtorrent.DeleteStd_vector_char(resumeData)
+	}
+
+	torrentHandle := s.Session.Add_torrent(torrentParams)
+	if torrentHandle == nil {
+		return nil, fmt.Errorf("unable to add torrent with uri %s", uri)
+	}
+	// the queue decides what runs, not libtorrent
+	torrentHandle.Auto_managed(false)
+	setTorrentMode(torrentHandle, ModeArchive)
+	s.applyTorrentRateLimits(torrentHandle)
+	return torrentHandle, nil
+}
+
+func infoHashOf(torrentHandle libtorrent.Torrent_handle) string {
+	return hex.EncodeToString([]byte(torrentHandle.Info_hash().To_string()))
+}
+
+func (s *BTService) findTorrent(infoHash string) (libtorrent.Torrent_handle, error) {
+	torrentsVector := s.Session.Get_torrents()
+	for i := 0; i < int(torrentsVector.Size()); i++ {
+		torrentHandle := torrentsVector.Get(i)
+		if torrentHandle.Is_valid() == false {
+			continue
+		}
+		if infoHashOf(torrentHandle) == infoHash {
+			return torrentHandle, nil
+		}
+	}
+	return nil, fmt.Errorf("no torrent with infohash %s", infoHash)
+}
+
+// TorrentFiles returns the name of the torrent and the paths of its files
+// on disk, to tell the user what removing it would delete.
+func (s *BTService) TorrentFiles(infoHash string) (string, []string, error) {
+	torrentHandle, err := s.findTorrent(infoHash)
+	if err != nil {
+		return "", nil, err
+	}
+	status := torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_name | libtorrent.Torrent_handleQuery_save_path))
+	files := make([]string, 0)
+	if status.GetHas_metadata() {
+		torrentInfo := torrentHandle.Torrent_file()
+		defer libtorrent.DeleteTorrent_info(torrentInfo)
+		for i := 0; i < torrentInfo.Num_files(); i++ {
+			files = append(files, filepath.Join(status.GetSave_path(), torrentInfo.File_at(i).GetPath()))
+		}
+	}
+	return status.GetName(), files, nil
+}
+
+func (s *BTService) RemoveTorrent(infoHash string, deleteFiles bool) error {
+	return s.RemoveTorrentWith(infoHash, RemoveOptions{DeleteFiles: deleteFiles})
+}
+
+// RemoveTorrentWith removes a torrent, deleting what the options say.
+func (s *BTService) RemoveTorrentWith(infoHash string, options RemoveOptions) error {
+	torrentHandle, err := s.findTorrent(infoHash)
+	if err != nil {
+		return err
+	}
+	paths, folders := removalPaths(torrentHandle, options)
+	flags := 0
+	if options.DeleteFiles {
+		flags = int(libtorrent.SessionDelete_files)
+	}
+	s.Session.Remove_torrent(torrentHandle, flags)
+	if len(paths) > 0 || len(folders) > 0 {
+		go s.deleteRemoved(paths, folders)
+	}
+	s.ratesLock.Lock()
+	delete(s.torrentRates, infoHash)
+	s.ratesLock.Unlock()
+	s.downloadsLock.Lock()
+	delete(s.downloads, infoHash)
+	s.removeFromQueue(infoHash)
+	s.downloadsLock.Unlock()
+	if options.DeleteFiles || options.DeleteIncomplete {
+		s.keptLock.Lock()
+		s.forgetKept(infoHash)
+		s.saveKept()
+		s.keptLock.Unlock()
+	}
+	s.forgetResumeData(infoHash)
+	s.log.Info("Removed torrent %s", infoHash)
+	return nil
+}
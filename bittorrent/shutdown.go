@@ -0,0 +1,58 @@
+package bittorrent
+
+import (
+	"github.com/steeve/libtorrent-go"
+)
+
+// StopStreams removes the torrents of the streams, for when nobody will
+// play them again, as when Kodi quits. Their files are deleted unless the
+// streams keep them, those in staging being moved out first. Background
+// downloads are left to resume.
+func (s *BTService) StopStreams() {
+	s.streamsLock.Lock()
+	players := make([]*BTPlayer, 0, len(s.streams))
+	for btp := range s.streams {
+		players = append(players, btp)
+	}
+	s.streams = make(map[*BTPlayer]bool)
+	s.streamsLock.Unlock()
+
+	stopped := make(map[string]bool)
+	for _, btp := range players {
+		if btp.torrentHandle == nil || btp.torrentHandle.Is_valid() == false {
+			continue
+		}
+		infoHash := infoHashOf(btp.torrentHandle)
+		if stopped[infoHash] {
+			continue
+		}
+		stopped[infoHash] = true
+		btp.log.Info("Stopping the stream of %s", infoHash)
+		if btp.deleteAfter {
+			btp.storeCachedPieces()
+			s.Session.Remove_torrent(btp.torrentHandle, int(libtorrent.SessionDelete_files))
+		} else {
+			s.moveFromStaging(btp.torrentHandle)
+			s.keepStream(btp.torrentHandle)
+			s.Session.Remove_torrent(btp.torrentHandle, 0)
+		}
+		s.forgetResumeData(infoHash)
+	}
+
+	s.streamsLock.Lock()
+	lingering := make([]string, 0, len(s.lingering))
+	for infoHash := range s.lingering {
+		lingering = append(lingering, infoHash)
+	}
+	s.streamsLock.Unlock()
+	for _, infoHash := range lingering {
+		if torrentHandle, err := s.findTorrent(infoHash); err == nil && stopped[infoHash] == false {
+			s.removeLingering(torrentHandle)
+			stopped[infoHash] = true
+		}
+	}
+
+	if len(stopped) > 0 {
+		s.log.Info("Stopped %d streams", len(stopped))
+	}
+}
@@ -0,0 +1,156 @@
+package bittorrent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/metrics"
+	"github.com/steeve/pulsar/util"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const statsInterval = 3 * time.Second
+
+// BufferTimes are how long the streams took to buffer, metadata included,
+// in seconds.
+var BufferTimes = metrics.NewHistogram(2, 5, 10, 20, 30, 60, 120, 300)
+
+// PlayerStats is a snapshot of the played torrent. While buffering, Buffered
+// is the progress of the start buffer, then that of the file.
+type PlayerStats struct {
+	Name          string    `json:"name"`
+	InfoHash      string    `json:"info_hash"`
+	State         string    `json:"state"`
+	Buffering     bool      `json:"buffering"`
+	Buffered      float64   `json:"buffered"`
+	ETA           int       `json:"eta"`            // seconds until Buffered is 100%, -1 when stalled
+	BufferedAhead int       `json:"buffered_ahead"` // seconds of playback downloaded, -1 when unknown
+	DownloadRate  int       `json:"download_rate"`
+	UploadRate    int       `json:"upload_rate"`
+	Seeds         int       `json:"seeds"`
+	Peers         int       `json:"peers"`
+	SwarmSeeds    int       `json:"swarm_seeds"`
+	SwarmPeers    int       `json:"swarm_peers"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (btp *BTPlayer) collectStats(status libtorrent.Torrent_status, buffering bool, bufferProgress float64) {
+	stats := &PlayerStats{
+		Name:          btp.torrentName,
+		InfoHash:      infoHashOf(btp.torrentHandle),
+		Buffering:     buffering,
+		ETA:           -1,
+		BufferedAhead: -1,
+		DownloadRate:  status.GetDownload_rate(),
+		UploadRate:    status.GetUpload_rate(),
+		Seeds:         status.GetNum_seeds(),
+		Peers:         status.GetNum_peers(),
+		SwarmSeeds:    status.GetNum_complete(),
+		SwarmPeers:    status.GetNum_incomplete(),
+		UpdatedAt:     time.Now(),
+	}
+	if state := int(status.GetState()); state >= 0 && state < len(torrentStates) {
+		stats.State = torrentStates[state]
+	}
+
+	remaining := int64(0)
+	if buffering {
+		stats.Buffered = bufferProgress
+		if btp.hasMetadata() {
+			btp.bufferPiecesProgressLock.RLock()
+			bufferSize := int64(len(btp.bufferPiecesProgress)) * int64(btp.torrentInfo.Piece_length())
+			btp.bufferPiecesProgressLock.RUnlock()
+			remaining = int64(float64(bufferSize) * (1 - bufferProgress))
+		}
+	} else if wanted := status.GetTotal_wanted(); wanted > 0 {
+		stats.Buffered = float64(status.GetTotal_wanted_done()) / float64(wanted)
+		remaining = wanted - status.GetTotal_wanted_done()
+	}
+	if remaining <= 0 {
+		stats.ETA = 0
+	} else if stats.DownloadRate > 0 {
+		stats.ETA = int(remaining / int64(stats.DownloadRate))
+	}
+
+	if buffering == false && btp.bitrate > 0 && btp.biggestFile != nil {
+		ahead, _ := btp.bufferedAhead(btp.playbackOffset)
+		stats.BufferedAhead = int(float64(ahead) / btp.bitrate)
+	}
+
+	btp.statsLock.Lock()
+	btp.stats = stats
+	btp.statsLock.Unlock()
+}
+
+// Stats returns the last snapshot of the torrent, nil until the buffering
+// has started.
+func (btp *BTPlayer) Stats() *PlayerStats {
+	btp.statsLock.RLock()
+	defer btp.statsLock.RUnlock()
+	return btp.stats
+}
+
+func formatETA(seconds int) string {
+	if seconds < 0 {
+		if xbmc.TextOnly() {
+			return "unknown"
+		}
+		return "∞"
+	}
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+func statsLines(stats *PlayerStats) []string {
+	format := "D:%s U:%s S:%d/%d P:%d/%d"
+	if xbmc.TextOnly() {
+		format = "Down %s, up %s, %d of %d seeds, %d of %d peers"
+	}
+	lines := []string{
+		fmt.Sprintf(format,
+			util.FormatSpeed(int64(stats.DownloadRate)),
+			util.FormatSpeed(int64(stats.UploadRate)),
+			stats.Seeds, stats.SwarmSeeds,
+			stats.Peers, stats.SwarmPeers,
+		),
+		fmt.Sprintf("Downloaded %.1f%%, done in %s", stats.Buffered*100, formatETA(stats.ETA)),
+	}
+	if stats.BufferedAhead >= 0 {
+		lines = append(lines, fmt.Sprintf("%s buffered ahead", formatETA(stats.BufferedAhead)))
+	}
+	return lines
+}
+
+// showStats draws the stats over the video when enabled. Python sides too
+// old for overlays get short lived notifications instead.
+func (btp *BTPlayer) showStats() {
+	stats := btp.Stats()
+	if config.Get().PlaybackStats == false || stats == nil {
+		btp.closeOverlay()
+		return
+	}
+	lines := statsLines(stats)
+	if btp.overlay == nil && btp.noOverlay == false {
+		overlay, err := xbmc.NewOverlay(btp.torrentName)
+		if err != nil {
+			btp.log.Info("Unable to draw the stats over the video, notifying them instead: %s", err)
+		}
+		btp.overlay, btp.noOverlay = overlay, overlay == nil
+	}
+	if btp.overlay != nil {
+		if err := btp.overlay.Update(lines...); err == nil {
+			return
+		}
+		btp.log.Info("Unable to update the stats overlay, notifying them instead")
+		btp.overlay, btp.noOverlay = nil, true
+	}
+	xbmc.Notify("Pulsar", lines[0], config.AddonIcon(), int(statsInterval/time.Millisecond))
+}
+
+func (btp *BTPlayer) closeOverlay() {
+	if btp.overlay != nil {
+		btp.overlay.Close()
+		btp.overlay = nil
+	}
+}
@@ -0,0 +1,101 @@
+package bittorrent
+
+import (
+	"sort"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+// in the order of libtorrent's torrent_status::state_t
+var torrentStates = []string{
+	"queued",
+	"checking",
+	"fetching metadata",
+	"downloading",
+	"finished",
+	"seeding",
+	"allocating",
+	"checking resume data",
+}
+
+// TorrentStatus is what remote clients get to see about a torrent.
+type TorrentStatus struct {
+	InfoHash     string  `json:"info_hash"`
+	Name         string  `json:"name"`
+	State        string  `json:"state"`
+	Paused       bool    `json:"paused"`
+	Mode         string  `json:"mode"`
+	Download     bool    `json:"download"`
+	Progress     float64 `json:"progress"`
+	Size         int64   `json:"size"`
+	Downloaded   int64   `json:"downloaded"`
+	DownloadRate int     `json:"download_rate"`
+	UploadRate   int     `json:"upload_rate"`
+	Seeds        int     `json:"seeds"`
+	Peers        int     `json:"peers"`
+	SwarmSeeds   int     `json:"swarm_seeds"`
+	SwarmPeers   int     `json:"swarm_peers"`
+}
+
+type byTorrentName []*TorrentStatus
+
+func (a byTorrentName) Len() int           { return len(a) }
+func (a byTorrentName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTorrentName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+// Must be called with downloadsLock held.
+func (s *BTService) torrentStatus(torrentHandle libtorrent.Torrent_handle) *TorrentStatus {
+	status := torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_name))
+	infoHash := infoHashOf(torrentHandle)
+	torrentStatus := &TorrentStatus{
+		InfoHash:     infoHash,
+		Name:         status.GetName(),
+		Paused:       status.GetPaused(),
+		Mode:         torrentMode(torrentHandle),
+		Progress:     float64(status.GetProgress()),
+		Size:         status.GetTotal_wanted(),
+		Downloaded:   status.GetTotal_wanted_done(),
+		DownloadRate: status.GetDownload_rate(),
+		UploadRate:   status.GetUpload_rate(),
+		Seeds:        status.GetNum_seeds(),
+		Peers:        status.GetNum_peers(),
+		SwarmSeeds:   status.GetNum_complete(),
+		SwarmPeers:   status.GetNum_incomplete(),
+	}
+	if state := int(status.GetState()); state >= 0 && state < len(torrentStates) {
+		torrentStatus.State = torrentStates[state]
+	}
+	if _, isDownload := s.downloads[infoHash]; isDownload {
+		torrentStatus.Download = true
+	}
+	return torrentStatus
+}
+
+// Torrents returns the status of every torrent of the session, streams and
+// background downloads alike.
+func (s *BTService) Torrents() []*TorrentStatus {
+	s.downloadsLock.Lock()
+	defer s.downloadsLock.Unlock()
+
+	torrents := make([]*TorrentStatus, 0)
+	torrentsVector := s.Session.Get_torrents()
+	for i := 0; i < int(torrentsVector.Size()); i++ {
+		torrentHandle := torrentsVector.Get(i)
+		if torrentHandle.Is_valid() == false {
+			continue
+		}
+		torrents = append(torrents, s.torrentStatus(torrentHandle))
+	}
+	sort.Sort(byTorrentName(torrents))
+	return torrents
+}
+
+func (s *BTService) TorrentStatus(infoHash string) (*TorrentStatus, error) {
+	torrentHandle, err := s.findTorrent(infoHash)
+	if err != nil {
+		return nil, err
+	}
+	s.downloadsLock.Lock()
+	defer s.downloadsLock.Unlock()
+	return s.torrentStatus(torrentHandle), nil
+}
@@ -0,0 +1,127 @@
+package bittorrent
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+)
+
+const (
+	storageProbeSize     = 1024 * 1024 // 1m
+	slowStorageLatency   = 250 * time.Millisecond
+	slowStorageCacheSize = 8192 // in 16KiB blocks, so 128m
+	slowStorageExpiry    = 300  // 5 minutes
+	// moving across filesystems copies everything, big files take a while
+	storageMoveTimeout = 10 * time.Minute
+)
+
+// Measures how long it takes to write and sync a small file on path.
+// Cloud backed mounts (rclone, SMB over WAN...) typically take seconds.
+func probeWriteLatency(path string) (time.Duration, error) {
+	file, err := ioutil.TempFile(path, ".pulsar-probe")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	start := time.Now()
+	if _, err := file.Write(make([]byte, storageProbeSize)); err != nil {
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	return time.Now().Sub(start), nil
+}
+
+func (s *BTService) detectSlowStorage() {
+	s.slowStorage = s.Config().SlowStorage
+	if s.slowStorage == false {
+		latency, err := probeWriteLatency(s.Config().DownloadPath)
+		if err != nil {
+			s.log.Info("Unable to probe write latency of %s: %s", s.Config().DownloadPath, err)
+			return
+		}
+		s.log.Info("Write latency of %s is %s", s.Config().DownloadPath, latency)
+		s.slowStorage = latency > slowStorageLatency
+	}
+	if s.slowStorage {
+		s.log.Info("%s is slow storage, increasing disk cache", s.Config().DownloadPath)
+		if s.Config().StagingPath != "" {
+			s.log.Info("Staging downloads in %s", s.Config().StagingPath)
+		}
+	}
+}
+
+func (s *BTService) setSlowStorageSettings(settings libtorrent.Session_settings) {
+	if s.slowStorage == false {
+		return
+	}
+	settings.SetCache_size(slowStorageCacheSize)
+	settings.SetCache_expiry(slowStorageExpiry)
+	settings.SetUse_read_cache(true)
+}
+
+func (s *BTService) IsSlowStorage() bool {
+	return s.slowStorage
+}
+
+// Where new torrents should be saved. On slow storage, downloads are staged
+// locally when a staging path is set, and moved once done.
+func (s *BTService) SavePath() string {
+	if s.isStaging() {
+		return s.Config().StagingPath
+	}
+	return s.Config().DownloadPath
+}
+
+func (s *BTService) isStaging() bool {
+	return s.slowStorage && s.Config().StagingPath != ""
+}
+
+// Moves the torrent from the staging path, or from memory, to the download
+// path, and waits for libtorrent to be done with it, for storageMoveTimeout
+// at most.
+func (s *BTService) moveFromStaging(torrentHandle libtorrent.Torrent_handle) {
+	if s.isStaging() == false && s.inMemory(torrentHandle) == false {
+		return
+	}
+	alerts, done := s.Alerts()
+	defer close(done)
+
+	s.log.Info("Moving %s to %s...", torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_name)).GetName(), s.Config().DownloadPath)
+	torrentHandle.Move_storage(s.Config().DownloadPath)
+	timeout := time.After(storageMoveTimeout)
+	for {
+		var alert *Alert
+		var ok bool
+		select {
+		case alert, ok = <-alerts:
+			if ok == false {
+				return
+			}
+		case <-timeout:
+			s.log.Error("Gave up waiting for the move to %s after %s", s.Config().DownloadPath, storageMoveTimeout)
+			return
+		case <-s.closing:
+			return
+		}
+		switch alert.Xtype() {
+		case libtorrent.Storage_moved_alertAlert_type:
+			movedAlert := libtorrent.SwigcptrTorrent_alert(alert.Swigcptr())
+			if movedAlert.GetHandle().Equal(torrentHandle) {
+				s.log.Info("Moved to %s", s.Config().DownloadPath)
+				return
+			}
+		case libtorrent.Storage_moved_failed_alertAlert_type:
+			failedAlert := libtorrent.SwigcptrTorrent_alert(alert.Swigcptr())
+			if failedAlert.GetHandle().Equal(torrentHandle) {
+				s.log.Error("Unable to move to %s: %s", s.Config().DownloadPath, alert.Message())
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package bittorrent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/steeve/pulsar/util"
+)
+
+// The session can be suspended from the API, for no torrent traffic at all
+// while the rest of the daemon, metadata browsing and all, keeps running.
+
+var ErrSuspended = errors.New("the torrent session is stopped")
+
+func (s *BTService) Suspended() bool {
+	s.suspendLock.Lock()
+	defer s.suspendLock.Unlock()
+	return s.suspended
+}
+
+// Suspend pauses all the torrents, and stops DHT, LSD, UPNP and NATPMP.
+func (s *BTService) Suspend() {
+	s.suspendLock.Lock()
+	if s.suspended {
+		s.suspendLock.Unlock()
+		return
+	}
+	s.suspended = true
+	s.suspendLock.Unlock()
+
+	s.log.Info("Suspending the torrent session")
+	s.Session.Pause()
+	s.stopServices()
+}
+
+// Unsuspend resumes the session, unless the kill switch would pause it
+// right away.
+func (s *BTService) Unsuspend() error {
+	if s.Config().KillSwitch {
+		if err := util.TunnelUp(s.Config().BindInterface, s.proxyAddress()); err != nil {
+			return fmt.Errorf("kill switch: %s", err)
+		}
+	}
+	s.suspendLock.Lock()
+	if s.suspended == false {
+		s.suspendLock.Unlock()
+		return nil
+	}
+	s.suspended = false
+	s.suspendLock.Unlock()
+
+	s.log.Info("Resuming the torrent session")
+	s.Listen()
+	s.startServices()
+	s.Session.Resume()
+	return nil
+}
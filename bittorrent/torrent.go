@@ -2,7 +2,6 @@ package bittorrent
 
 import (
 	"crypto/sha1"
-	"crypto/tls"
 	"encoding/base32"
 	"encoding/hex"
 	"encoding/json"
@@ -12,6 +11,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/steeve/pulsar/util"
 	"github.com/steeve/pulsar/xbmc"
 	"github.com/zeebo/bencode"
 )
@@ -26,12 +26,29 @@ type Torrent struct {
 	Peers     int64    `json:"peers"`
 	IsPrivate bool     `json:"is_private"`
 
-	Resolution  int    `json:"resolution"`
-	VideoCodec  int    `json:"video_codec"`
-	AudioCodec  int    `json:"audio_codec"`
-	Language    string `json:"language"`
-	RipType     int    `json:"rip_type"`
-	SceneRating int    `json:"scene_rating"`
+	Resolution   int    `json:"resolution"`
+	VideoCodec   int    `json:"video_codec"`
+	AudioCodec   int    `json:"audio_codec"`
+	Language     string `json:"language"`
+	RipType      int    `json:"rip_type"`
+	SceneRating  int    `json:"scene_rating"`
+	ReleaseGroup string `json:"release_group"`
+	SeasonPack   bool   `json:"season_pack"`
+
+	// the provider that returned it, and what it returned
+	Provider     string          `json:"provider,omitempty"`
+	ProviderName string          `json:"provider_name"` // for display, the tracker say
+	Payload      json.RawMessage `json:"-"`
+
+	// added to the score by the user's post-processing script
+	ScriptScore float64 `json:"script_score,omitempty"`
+
+	// the seeds listed before they were checked on the trackers and the DHT
+	ReportedSeeds int64 `json:"reported_seeds,omitempty"`
+	SeedsVerified bool  `json:"seeds_verified,omitempty"`
+
+	// a usenet release, URI being its NZB, see the usenet package
+	NZB bool `json:"nzb,omitempty"`
 
 	hasResolved bool
 }
@@ -50,8 +67,10 @@ var (
 		regexp.MustCompile(`\W+(480p|xvid|dvd)\W*`): Resolution480p,
 		regexp.MustCompile(`\W+(720p|hdrip)\W*`):    Resolution720p,
 		regexp.MustCompile(`\W+1080p\W*`):           Resolution1080p,
+		regexp.MustCompile(`\W+1440p\W*`):           Resolution1440p,
+		regexp.MustCompile(`\W+(2160p|4k|uhd)\W*`):  Resolution4k2k,
 	}
-	Resolutions = []string{"", "480p", "720p", "1080p"}
+	Resolutions = []string{"", "480p", "720p", "1080p", "1440p", "4k"}
 )
 
 const (
@@ -88,6 +107,8 @@ const (
 	RatingNuked
 )
 
+var SceneRatings = []string{"", "Proper", "Nuked"}
+
 var (
 	sceneTags = map[*regexp.Regexp]int{
 		regexp.MustCompile(`\W+nuked\W*`):  RatingNuked,
@@ -126,11 +147,7 @@ var (
 )
 
 var (
-	httpClient = &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-	}
+	httpClient = util.NewHTTPClient(util.TLSTorrents)
 )
 
 const (
@@ -176,7 +193,7 @@ func (t *Torrent) initializeFromMagnet() {
 }
 
 func (t *Torrent) Resolve() error {
-	if t.IsMagnet() {
+	if t.IsMagnet() || t.IsNZB() {
 		t.hasResolved = true
 		return nil
 	}
@@ -206,15 +223,22 @@ func (t *Torrent) Resolve() error {
 		}
 	}
 
-	resp, err := httpClient.Do(req)
+	resp, magnet, err := fetchTorrentFile(req)
 	if err != nil {
-		return err
+		return t.fallbackToMagnet(err)
+	}
+	if magnet != "" {
+		t.URI = magnet
+		t.initialize()
+		t.hasResolved = true
+		return nil
 	}
+	defer resp.Body.Close()
 	dec := bencode.NewDecoder(resp.Body)
 
 	// FIXME!!!!
 	if err := dec.Decode(&torrentFile); err != nil {
-		return err
+		return t.fallbackToMagnet(err)
 	}
 	if t.InfoHash == "" {
 		hasher := sha1.New()
@@ -239,8 +263,17 @@ func (t *Torrent) Resolve() error {
 }
 
 func (t *Torrent) initialize() {
-	if strings.HasPrefix(t.URI, "magnet:") {
+	t.URI = NormalizeURI(t.URI)
+	if t.IsNZB() {
+		// no infohash, but an id all the same
+		t.NZB = true
+		if t.InfoHash == "" {
+			t.InfoHash = fmt.Sprintf("%x", sha1.Sum([]byte(t.URI)))
+		}
+	} else if strings.HasPrefix(t.URI, "magnet:") {
 		t.initializeFromMagnet()
+	} else if t.InfoHash == "" {
+		t.InfoHash = ExtractInfoHash(t.URI)
 	}
 
 	if t.Resolution == ResolutionUnkown {
@@ -258,6 +291,9 @@ func (t *Torrent) initialize() {
 	if t.SceneRating == RatingUnkown {
 		t.SceneRating = matchTags(t, sceneTags)
 	}
+	if t.ReleaseGroup == "" {
+		t.ReleaseGroup = ReleaseGroup(t.Name)
+	}
 }
 
 func NewTorrent(uri string) *Torrent {
@@ -279,6 +315,16 @@ func matchTags(t *Torrent, tokens map[*regexp.Regexp]int) int {
 	return codec
 }
 
+func (t *Torrent) IsNZB() bool {
+	if t.NZB {
+		return true
+	}
+	if u, err := url.Parse(t.URI); err == nil {
+		return strings.HasSuffix(strings.ToLower(u.Path), ".nzb")
+	}
+	return false
+}
+
 func (t *Torrent) IsMagnet() bool {
 	return strings.HasPrefix(t.URI, "magnet:")
 }
@@ -287,6 +333,9 @@ func (t *Torrent) Magnet() string {
 	if t.hasResolved == false {
 		t.Resolve()
 	}
+	if t.IsNZB() {
+		return t.URI
+	}
 	if t.IsMagnet() {
 		return t.URI + "&" + url.Values{"as": []string{fmt.Sprintf(torCache, t.InfoHash)}}.Encode()
 	}
@@ -322,6 +371,14 @@ func (t *Torrent) StreamInfo() *xbmc.StreamInfo {
 		sie.Video.Width = 1920
 		sie.Video.Height = 1080
 		break
+	case Resolution1440p:
+		sie.Video.Width = 2560
+		sie.Video.Height = 1440
+		break
+	case Resolution4k2k:
+		sie.Video.Width = 3840
+		sie.Video.Height = 2160
+		break
 	}
 
 	return sie
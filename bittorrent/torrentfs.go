@@ -7,11 +7,11 @@ import (
 	"path/filepath"
 	"sync"
 	"time"
-	"unsafe"
 
 	"github.com/op/go-logging"
 	"github.com/steeve/libtorrent-go"
 	"github.com/steeve/pulsar/broadcast"
+	"github.com/steeve/pulsar/clock"
 )
 
 const (
@@ -22,6 +22,10 @@ type TorrentFS struct {
 	http.Dir
 	service *BTService
 	log     *logging.Logger
+	// the files being read, by torrent, as several players or a probe and
+	// a player can read the same torrent at once
+	readersMx sync.Mutex
+	readers   map[string]map[*TorrentFile]bool
 }
 
 type TorrentFile struct {
@@ -29,6 +33,7 @@ type TorrentFile struct {
 	tfs               *TorrentFS
 	torrentHandle     libtorrent.Torrent_handle
 	torrentInfo       libtorrent.Torrent_info
+	torrentPieces     torrentPieces
 	fileEntry         libtorrent.File_entry
 	fileEntryIdx      int
 	pieceLength       int
@@ -37,8 +42,16 @@ type TorrentFile struct {
 	piecesMx          sync.RWMutex
 	pieces            Bitfield
 	piecesLastUpdated time.Time
-	lastStatus        libtorrent.Torrent_status
+	infoHash          string
 	removed           *broadcast.Broadcaster
+	// guarded by readersMx of the TorrentFS
+	windowStart int
+	windowSet   bool
+	// guarded by piecesMx, set once the whole file is on disk so it can be
+	// served without the torrent, e.g. after it's moved and removed
+	complete bool
+	// tf.File is swapped for the moved file, see moving.go
+	fileMx sync.RWMutex
 }
 
 func NewTorrentFS(service *BTService, path string) *TorrentFS {
@@ -46,11 +59,12 @@ func NewTorrentFS(service *BTService, path string) *TorrentFS {
 		service: service,
 		log:     logging.MustGetLogger("torrentfs"),
 		Dir:     http.Dir(path),
+		readers: make(map[string]map[*TorrentFile]bool),
 	}
 }
 
 func (tfs *TorrentFS) Open(name string) (http.File, error) {
-	file, err := os.Open(filepath.Join(string(tfs.Dir), name))
+	file, err := tfs.openFile(name)
 	if err != nil {
 		return nil, err
 	}
@@ -61,6 +75,28 @@ func (tfs *TorrentFS) Open(name string) (http.File, error) {
 	}
 
 	tfs.log.Info("Opening %s", name)
+	if torrentFile, err := tfs.openTorrentFile(file, name); torrentFile != nil || err != nil {
+		return torrentFile, err
+	}
+	// the torrent may still be coming back after a restart, don't serve
+	// the sparse file in the meantime
+	if tfs.service.isReconnecting(name[1:]) {
+		tfs.log.Info("Waiting for the torrent of %s to be restored", name)
+		reconnectTicker := time.NewTicker(piecesRefreshDuration)
+		defer reconnectTicker.Stop()
+		for _ = range reconnectTicker.C {
+			if torrentFile, err := tfs.openTorrentFile(file, name); torrentFile != nil || err != nil {
+				return torrentFile, err
+			}
+			if tfs.service.isReconnecting(name[1:]) == false {
+				break
+			}
+		}
+	}
+	return file, err
+}
+
+func (tfs *TorrentFS) openTorrentFile(file *os.File, name string) (*TorrentFile, error) {
 	// NB: this does NOT return a pointer to vector, no need to free!
 	torrentsVector := tfs.service.Session.Get_torrents()
 	torrentsVectorSize := int(torrentsVector.Size())
@@ -69,6 +105,9 @@ func (tfs *TorrentFS) Open(name string) (http.File, error) {
 		if torrentHandle.Is_valid() == false {
 			continue
 		}
+		if torrentHandle.Status(uint(0)).GetHas_metadata() == false {
+			continue
+		}
 		torrentInfo := torrentHandle.Torrent_file()
 		numFiles := torrentInfo.Num_files()
 		for j := 0; j < numFiles; j++ {
@@ -80,7 +119,22 @@ func (tfs *TorrentFS) Open(name string) (http.File, error) {
 		}
 		defer libtorrent.DeleteTorrent_info(torrentInfo)
 	}
-	return file, err
+	return nil, nil
+}
+
+// Files being downloaded live in memory or in the staging path, if any.
+func (tfs *TorrentFS) openFile(name string) (*os.File, error) {
+	if tfs.service.memoryPath != "" {
+		if file, err := os.Open(filepath.Join(tfs.service.memoryPath, name)); err == nil {
+			return file, nil
+		}
+	}
+	if tfs.service.isStaging() {
+		if file, err := os.Open(filepath.Join(tfs.service.Config().StagingPath, name)); err == nil {
+			return file, nil
+		}
+	}
+	return os.Open(filepath.Join(string(tfs.Dir), name))
 }
 
 func NewTorrentFile(file *os.File, tfs *TorrentFS, torrentHandle libtorrent.Torrent_handle, torrentInfo libtorrent.Torrent_info, fileEntry libtorrent.File_entry, fileEntryIdx int) (*TorrentFile, error) {
@@ -89,13 +143,17 @@ func NewTorrentFile(file *os.File, tfs *TorrentFS, torrentHandle libtorrent.Torr
 		tfs:           tfs,
 		torrentHandle: torrentHandle,
 		torrentInfo:   torrentInfo,
+		torrentPieces: &handlePieces{torrentHandle: torrentHandle, torrentInfo: torrentInfo},
 		fileEntry:     fileEntry,
 		fileEntryIdx:  fileEntryIdx,
 		pieceLength:   torrentInfo.Piece_length(),
 		fileOffset:    fileEntry.GetOffset(),
 		fileSize:      fileEntry.GetSize(),
+		infoHash:      infoHashOf(torrentHandle),
 		removed:       broadcast.NewBroadcaster(),
 	}
+	tfs.service.addServedFile(tf.infoHash, 1)
+	tfs.addReader(tf)
 	go tf.consumeAlerts()
 
 	return tf, nil
@@ -112,6 +170,11 @@ func (tf *TorrentFile) consumeAlerts() {
 				tf.removed.Signal()
 				return
 			}
+		case libtorrent.Storage_moved_alertAlert_type:
+			movedAlert := libtorrent.SwigcptrTorrent_alert(alert.Swigcptr())
+			if movedAlert.GetHandle().Equal(tf.torrentHandle) {
+				tf.switchToMoved()
+			}
 		}
 	}
 }
@@ -120,23 +183,17 @@ func (tf *TorrentFile) updatePieces() error {
 	tf.piecesMx.Lock()
 	defer tf.piecesMx.Unlock()
 
-	if time.Now().After(tf.piecesLastUpdated.Add(piecesRefreshDuration)) {
-		// need to keep a reference to the status or else the pieces bitfield
-		// is at risk of being collected
-		tf.lastStatus = tf.torrentHandle.Status(uint(libtorrent.Torrent_handleQuery_pieces))
-		if tf.lastStatus.GetState() > libtorrent.Torrent_statusSeeding {
-			return errors.New("Torrent file has invalid state.")
-		}
-		piecesBits := tf.lastStatus.GetPieces()
-		piecesBitsSize := piecesBits.Size()
-		piecesSliceSize := piecesBitsSize / 8
-		if piecesBitsSize%8 > 0 {
-			// Add +1 to round up the bitfield
-			piecesSliceSize += 1
+	if tf.complete {
+		return nil
+	}
+	if clock.Now().After(tf.piecesLastUpdated.Add(piecesRefreshDuration)) {
+		pieces, err := tf.torrentPieces.bitfield()
+		if err != nil {
+			return err
 		}
-		data := (*[100000000]byte)(unsafe.Pointer(piecesBits.Bytes()))[:piecesSliceSize]
-		tf.pieces = Bitfield(data)
-		tf.piecesLastUpdated = time.Now()
+		tf.pieces = pieces
+		tf.piecesLastUpdated = clock.Now()
+		tf.complete = tf.hasFilePieces()
 	}
 	return nil
 }
@@ -147,28 +204,45 @@ func (tf *TorrentFile) hasPiece(idx int) bool {
 	}
 	tf.piecesMx.RLock()
 	defer tf.piecesMx.RUnlock()
-	return tf.pieces.GetBit(idx)
+	return tf.complete || tf.pieces.GetBit(idx)
 }
 
 func (tf *TorrentFile) Close() error {
 	tf.tfs.log.Info("Closing file...")
+	tf.tfs.service.addServedFile(tf.infoHash, -1)
+	tf.tfs.removeReader(tf)
 	tf.removed.Signal()
 	libtorrent.DeleteTorrent_info(tf.torrentInfo)
-	return tf.File.Close()
+	return tf.closeFile()
 }
 
 func (tf *TorrentFile) Read(data []byte) (int, error) {
-	currentOffset, err := tf.File.Seek(0, os.SEEK_CUR)
+	currentOffset, err := tf.seekFile(0, os.SEEK_CUR)
 	if err != nil {
 		return 0, err
 	}
 	// tf.tfs.log.Info("About to read from file at %d for %d\n", currentOffset, len(data))
-	piece, _ := tf.pieceFromOffset(currentOffset + int64(len(data)))
-	if err := tf.waitForPiece(piece); err != nil {
+	if len(data) == 0 || currentOffset >= tf.fileSize {
+		return tf.readFile(data)
+	}
+	// reads stop at the end of the piece, so we never return bytes of a
+	// piece we don't have yet, and the HTTP server waits for the next one
+	piece, from := tf.pieceFromOffset(currentOffset)
+	if left := tf.pieceLength - from; len(data) > left {
+		data = data[:left]
+	}
+	if left := tf.fileSize - currentOffset; int64(len(data)) > left {
+		data = data[:left]
+	}
+	to := from + len(data)
+	if tf.isComplete() == false && tf.inWindow(piece) == false {
+		tf.prioritizeFrom(currentOffset)
+	}
+	if err := tf.waitForBytes(piece, from, to); err != nil {
 		return 0, err
 	}
 
-	return tf.File.Read(data)
+	return tf.readFile(data)
 }
 
 func (tf *TorrentFile) Seek(offset int64, whence int) (int64, error) {
@@ -176,53 +250,47 @@ func (tf *TorrentFile) Seek(offset int64, whence int) (int64, error) {
 
 	switch whence {
 	case os.SEEK_CUR:
-		currentOffset, err := tf.File.Seek(0, os.SEEK_CUR)
+		currentOffset, err := tf.seekFile(0, os.SEEK_CUR)
 		if err != nil {
 			return currentOffset, err
 		}
 		seekingOffset += currentOffset
 		break
 	case os.SEEK_END:
-		seekingOffset = tf.fileSize - offset
+		seekingOffset = tf.fileSize + offset
 		break
 	}
 
 	tf.tfs.log.Info("Seeking at %d...", seekingOffset)
-	piece, _ := tf.pieceFromOffset(seekingOffset)
-	if tf.hasPiece(piece) == false {
-		tf.tfs.log.Info("We don't have piece %d, setting piece priorities", piece)
-		piecesPriorities := libtorrent.NewStd_vector_int()
-		defer libtorrent.DeleteStd_vector_int(piecesPriorities)
-		curPiece := 0
-		numPieces := tf.torrentInfo.Num_pieces()
-		for _ = 0; curPiece < piece; curPiece++ {
-			piecesPriorities.Add(0)
-		}
-		for _ = 0; curPiece < numPieces; curPiece++ {
-			piecesPriorities.Add(1)
+	// seeking from the end is only the HTTP server getting the size
+	if whence != os.SEEK_END && seekingOffset < tf.fileSize && tf.isComplete() == false {
+		if piece, _ := tf.pieceFromOffset(seekingOffset); tf.inWindow(piece) == false {
+			tf.prioritizeFrom(seekingOffset)
 		}
-		tf.torrentHandle.Prioritize_pieces(piecesPriorities)
 	}
 
-	return tf.File.Seek(offset, whence)
+	return tf.seekFile(offset, whence)
 }
 
-func (tf *TorrentFile) waitForPiece(piece int) error {
-	if tf.hasPiece(piece) {
+// waitForBytes waits until the bytes [from, to) of the piece can be read,
+// which can be before the whole piece is there when serving partial pieces.
+func (tf *TorrentFile) waitForBytes(piece int, from int, to int) error {
+	if tf.bytesAvailable(piece, from, to) {
 		return nil
 	}
 
 	tf.tfs.log.Info("Waiting for piece %d", piece)
 
-	pieceRefreshTicker := time.Tick(piecesRefreshDuration)
+	pieceRefreshTicker := time.NewTicker(piecesRefreshDuration)
+	defer pieceRefreshTicker.Stop()
 	removed, done := tf.removed.Listen()
 	defer close(done)
-	for tf.hasPiece(piece) == false {
+	for tf.bytesAvailable(piece, from, to) == false {
 		select {
 		case <-removed:
 			tf.tfs.log.Info("Unable to wait for piece %d as file was closed", piece)
 			return errors.New("File was closed.")
-		case <-pieceRefreshTicker:
+		case <-pieceRefreshTicker.C:
 			continue
 		}
 	}
@@ -0,0 +1,456 @@
+package bittorrent
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/steeve/libtorrent-go"
+	"github.com/steeve/pulsar/broadcast"
+)
+
+type fakePieces struct {
+	pieces     int
+	priorities []int
+	deadlines  map[int]int
+	// the pieces we have, guarded by haveMx as the readers poll them
+	haveMx sync.Mutex
+	have   Bitfield
+}
+
+func (fp *fakePieces) valid() bool {
+	return true
+}
+
+func (fp *fakePieces) numPieces() int {
+	return fp.pieces
+}
+
+func (fp *fakePieces) prioritize(priorities []int, deadlines map[int]int) {
+	fp.priorities = priorities
+	fp.deadlines = deadlines
+}
+
+func (fp *fakePieces) bitfield() (Bitfield, error) {
+	fp.haveMx.Lock()
+	defer fp.haveMx.Unlock()
+	have := make(Bitfield, (fp.pieces+7)/8)
+	copy(have, fp.have)
+	return have, nil
+}
+
+func (fp *fakePieces) setPiece(piece int) {
+	fp.haveMx.Lock()
+	defer fp.haveMx.Unlock()
+	fp.have.SetBit(piece, true)
+}
+
+func newTestTorrentFS() *TorrentFS {
+	return NewTorrentFS(&BTService{}, os.TempDir())
+}
+
+// newTestTorrentFile is a file of fileSize bytes starting fileOffset bytes
+// in the torrent, each byte being its offset modulo 256.
+func newTestTorrentFile(t *testing.T, tfs *TorrentFS, pieces *fakePieces, pieceLength int, fileOffset int64, fileSize int64) *TorrentFile {
+	file, err := ioutil.TempFile("", "torrentfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, fileSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+	tf := &TorrentFile{
+		File:          file,
+		tfs:           tfs,
+		torrentPieces: pieces,
+		pieceLength:   pieceLength,
+		fileOffset:    fileOffset,
+		fileSize:      fileSize,
+		infoHash:      "test",
+		removed:       broadcast.NewBroadcaster(),
+	}
+	tfs.addReader(tf)
+	return tf
+}
+
+func closeTestTorrentFile(tf *TorrentFile) {
+	tf.File.Close()
+	os.Remove(tf.File.Name())
+}
+
+func TestReadStopsAtPieceAndFileEnd(t *testing.T) {
+	// 16 bytes pieces, the file starting in the middle of the first one
+	tests := []struct {
+		offset int64
+		size   int
+		want   int
+		err    error
+	}{
+		{0, 64, 8, nil},
+		{4, 64, 4, nil},
+		{8, 64, 16, nil},
+		{8, 10, 10, nil},
+		{36, 64, 4, nil},
+		{40, 64, 0, io.EOF},
+		{0, 0, 0, nil},
+	}
+	tfs := newTestTorrentFS()
+	tf := newTestTorrentFile(t, tfs, &fakePieces{pieces: 3}, 16, 8, 40)
+	defer closeTestTorrentFile(tf)
+	// all the pieces are there, and fresh enough not to ask libtorrent
+	tf.pieces = Bitfield{0xff}
+	tf.piecesLastUpdated = time.Now()
+
+	for _, test := range tests {
+		if _, err := tf.Seek(test.offset, os.SEEK_SET); err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, test.size)
+		n, err := tf.Read(data)
+		if n != test.want || err != test.err {
+			t.Errorf("Read(%d bytes) at %d = %d, %v, want %d, %v", test.size, test.offset, n, err, test.want, test.err)
+			continue
+		}
+		for i := 0; i < n; i++ {
+			if data[i] != byte(test.offset+int64(i)) {
+				t.Errorf("Read(%d bytes) at %d read %d at %d", test.size, test.offset, data[i], i)
+				break
+			}
+		}
+	}
+}
+
+func TestReadCompleteFile(t *testing.T) {
+	tfs := newTestTorrentFS()
+	// the torrent may be gone, the file is read as it is
+	pieces := &fakePieces{pieces: 3}
+	tf := newTestTorrentFile(t, tfs, pieces, 16, 8, 40)
+	defer closeTestTorrentFile(tf)
+	tf.complete = true
+
+	if _, err := tf.Seek(20, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 16)
+	if n, err := tf.Read(data); n != 4 || err != nil {
+		t.Errorf("Read(16 bytes) at 20 = %d, %v, want 4, <nil>", n, err)
+	}
+	if pieces.priorities != nil {
+		t.Errorf("complete file prioritized %v", pieces.priorities)
+	}
+}
+
+// fakeFileEntry is the file of the torrent, by its path.
+type fakeFileEntry struct {
+	libtorrent.File_entry
+	path string
+}
+
+func (fe *fakeFileEntry) GetPath() string {
+	return fe.path
+}
+
+func TestSwitchToMoved(t *testing.T) {
+	downloadPath, err := ioutil.TempDir("", "torrentfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(downloadPath)
+	moved := make([]byte, 40)
+	for i := range moved {
+		moved[i] = byte(i)
+	}
+	movedPath := filepath.Join(downloadPath, "video.mkv")
+	if err := ioutil.WriteFile(movedPath, moved, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tfs := NewTorrentFS(&BTService{config: &BTConfiguration{DownloadPath: downloadPath}}, os.TempDir())
+	tf := newTestTorrentFile(t, tfs, &fakePieces{pieces: 3}, 16, 8, 40)
+	defer closeTestTorrentFile(tf)
+	stagingPath := tf.File.Name()
+	defer os.Remove(stagingPath)
+	tf.fileEntry = &fakeFileEntry{path: "video.mkv"}
+	tf.complete = true
+
+	if _, err := tf.Seek(10, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+	tf.switchToMoved()
+	if tf.File.Name() != movedPath {
+		t.Fatalf("serving %s, want %s", tf.File.Name(), movedPath)
+	}
+	if offset, err := tf.Seek(0, os.SEEK_CUR); offset != 10 || err != nil {
+		t.Errorf("offset %d, %v after the switch, want 10", offset, err)
+	}
+	data := make([]byte, 4)
+	if n, err := tf.Read(data); n != 4 || err != nil || data[0] != 10 {
+		t.Errorf("Read after the switch = %d, %v, %v", n, err, data)
+	}
+}
+
+func TestSeek(t *testing.T) {
+	tests := []struct {
+		offset      int64
+		whence      int
+		want        int64
+		windowSet   bool
+		windowStart int
+	}{
+		// from the end, the HTTP server only gets the size
+		{0, os.SEEK_END, 40, false, 0},
+		{-10, os.SEEK_END, 30, false, 0},
+		{20, os.SEEK_SET, 20, true, 1},
+		// within the first half of the window, which doesn't move
+		{4, os.SEEK_CUR, 24, true, 1},
+		{0, os.SEEK_SET, 0, true, 0},
+	}
+	tfs := newTestTorrentFS()
+	pieces := &fakePieces{pieces: 3}
+	tf := newTestTorrentFile(t, tfs, pieces, 16, 8, 40)
+	defer closeTestTorrentFile(tf)
+
+	for _, test := range tests {
+		offset, err := tf.Seek(test.offset, test.whence)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset != test.want {
+			t.Errorf("Seek(%d, %d) = %d, want %d", test.offset, test.whence, offset, test.want)
+		}
+		if tf.windowSet != test.windowSet || tf.windowStart != test.windowStart {
+			t.Errorf("Seek(%d, %d) window at %d (set %t), want %d (set %t)", test.offset, test.whence, tf.windowStart, tf.windowSet, test.windowStart, test.windowSet)
+		}
+	}
+}
+
+func TestPrioritizeReaders(t *testing.T) {
+	// 8MB pieces make a readahead window of minReadaheadPieces
+	const pieceLength = 8 * 1024 * 1024
+	step := readaheadDeadlineStep
+	tests := []struct {
+		name          string
+		windowStarts  []int // -1 for readers without a window
+		wantPriority  map[int]int
+		wantDeadlines map[int]int
+	}{
+		{
+			name:         "one reader",
+			windowStarts: []int{2},
+			wantPriority: map[int]int{2: 7, 3: 7, 4: 7, 5: 7, 6: 1, 7: 1, 8: 1, 9: 1},
+			wantDeadlines: map[int]int{
+				2: 0, 3: step, 4: 2 * step, 5: 3 * step,
+			},
+		},
+		{
+			name:         "overlapping readers",
+			windowStarts: []int{2, 4},
+			wantPriority: map[int]int{2: 7, 3: 7, 4: 7, 5: 7, 6: 7, 7: 7, 8: 1, 9: 1},
+			wantDeadlines: map[int]int{
+				2: 0, 3: step, 4: 0, 5: step, 6: 2 * step, 7: 3 * step,
+			},
+		},
+		{
+			name:         "a reader without a window",
+			windowStarts: []int{6, -1},
+			wantPriority: map[int]int{6: 7, 7: 7, 8: 7, 9: 7},
+			wantDeadlines: map[int]int{
+				6: 0, 7: step, 8: 2 * step, 9: 3 * step,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		tfs := newTestTorrentFS()
+		pieces := &fakePieces{pieces: 12}
+		for _, windowStart := range test.windowStarts {
+			// the file is pieces 0 to 9 of the torrent
+			tf := &TorrentFile{
+				tfs:           tfs,
+				torrentPieces: pieces,
+				pieceLength:   pieceLength,
+				fileSize:      10 * pieceLength,
+				infoHash:      "test",
+			}
+			if windowStart >= 0 {
+				tf.windowStart = windowStart
+				tf.windowSet = true
+			}
+			tfs.addReader(tf)
+		}
+
+		tfs.readersMx.Lock()
+		tfs.prioritizeReaders("test")
+		tfs.readersMx.Unlock()
+
+		wantPriorities := make([]int, pieces.pieces)
+		for piece, priority := range test.wantPriority {
+			wantPriorities[piece] = priority
+		}
+		if reflect.DeepEqual(pieces.priorities, wantPriorities) == false {
+			t.Errorf("%s: priorities %v, want %v", test.name, pieces.priorities, wantPriorities)
+		}
+		if reflect.DeepEqual(pieces.deadlines, test.wantDeadlines) == false {
+			t.Errorf("%s: deadlines %v, want %v", test.name, pieces.deadlines, test.wantDeadlines)
+		}
+	}
+}
+
+// readAsync reads size bytes at offset in a goroutine, sending the result
+// once the read returns.
+func readAsync(t *testing.T, tf *TorrentFile, offset int64, size int) chan error {
+	if _, err := tf.Seek(offset, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		data := make([]byte, size)
+		n, err := tf.Read(data)
+		if err == nil && n != size {
+			err = fmt.Errorf("read %d bytes, want %d", n, size)
+		}
+		done <- err
+	}()
+	return done
+}
+
+func TestReadWaitsForMissingPieces(t *testing.T) {
+	tfs := newTestTorrentFS()
+	// only the first piece is there
+	pieces := &fakePieces{pieces: 3, have: Bitfield{0x80}}
+	tf := newTestTorrentFile(t, tfs, pieces, 16, 8, 40)
+	defer closeTestTorrentFile(tf)
+
+	done := readAsync(t, tf, 8, 16)
+	select {
+	case err := <-done:
+		t.Fatalf("Read of a missing piece returned %v", err)
+	case <-time.After(2 * piecesRefreshDuration):
+	}
+	tfs.readersMx.Lock()
+	priority := pieces.priorities[1]
+	tfs.readersMx.Unlock()
+	if priority != readaheadPriority {
+		t.Errorf("missing piece priority %d, want %d", priority, readaheadPriority)
+	}
+
+	pieces.setPiece(1)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Read once the piece is there: %v", err)
+		}
+	case <-time.After(4 * piecesRefreshDuration):
+		t.Fatal("Read didn't resume once the piece was there")
+	}
+}
+
+func TestReadFailsOnClose(t *testing.T) {
+	tfs := newTestTorrentFS()
+	pieces := &fakePieces{pieces: 3, have: Bitfield{0x80}}
+	tf := newTestTorrentFile(t, tfs, pieces, 16, 8, 40)
+	defer closeTestTorrentFile(tf)
+
+	done := readAsync(t, tf, 24, 16)
+	select {
+	case err := <-done:
+		t.Fatalf("Read of a missing piece returned %v", err)
+	case <-time.After(2 * piecesRefreshDuration):
+	}
+	tf.removed.Signal()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Read of a missing piece succeeded once the file was closed")
+		}
+	case <-time.After(4 * piecesRefreshDuration):
+		t.Fatal("Read kept waiting once the file was closed")
+	}
+}
+
+func TestServeRangesOfConcurrentReaders(t *testing.T) {
+	tests := []struct {
+		rangeHeader  string
+		contentRange string
+		start        int
+		length       int
+	}{
+		// in the pieces that are there
+		{"bytes=0-7", "bytes 0-7/40", 0, 8},
+		// through the last piece, missing until after the request
+		{"bytes=10-29", "bytes 10-29/40", 10, 20},
+	}
+	tfs := newTestTorrentFS()
+	pieces := &fakePieces{pieces: 3, have: Bitfield{0xc0}}
+
+	recorders := make([]*httptest.ResponseRecorder, len(tests))
+	wg := sync.WaitGroup{}
+	for i, test := range tests {
+		tf := newTestTorrentFile(t, tfs, pieces, 16, 8, 40)
+		defer closeTestTorrentFile(tf)
+		r, _ := http.NewRequest("GET", "/video.mkv", nil)
+		r.Header.Set("Range", test.rangeHeader)
+		recorders[i] = httptest.NewRecorder()
+		// no sniffing, it reads the start of the file
+		recorders[i].Header().Set("Content-Type", "video/x-matroska")
+
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder, tf *TorrentFile) {
+			defer wg.Done()
+			http.ServeContent(w, r, "video.mkv", time.Time{}, tf)
+		}(recorders[i], tf)
+	}
+
+	time.Sleep(2 * piecesRefreshDuration)
+	pieces.setPiece(2)
+	served := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(served)
+	}()
+	select {
+	case <-served:
+	case <-time.After(4 * piecesRefreshDuration):
+		t.Fatal("the readers didn't get the pieces")
+	}
+
+	for i, test := range tests {
+		w := recorders[i]
+		if w.Code != http.StatusPartialContent {
+			t.Errorf("%s: status %d, want %d", test.rangeHeader, w.Code, http.StatusPartialContent)
+		}
+		if got := w.Header().Get("Content-Range"); got != test.contentRange {
+			t.Errorf("%s: Content-Range %q, want %q", test.rangeHeader, got, test.contentRange)
+		}
+		if got := w.Header().Get("Content-Length"); got != strconv.Itoa(test.length) {
+			t.Errorf("%s: Content-Length %s, want %d", test.rangeHeader, got, test.length)
+		}
+		body := w.Body.Bytes()
+		if len(body) != test.length {
+			t.Errorf("%s: %d bytes served, want %d", test.rangeHeader, len(body), test.length)
+			continue
+		}
+		for j, b := range body {
+			if b != byte(test.start+j) {
+				t.Errorf("%s: served %d at %d", test.rangeHeader, b, j)
+				break
+			}
+		}
+	}
+}
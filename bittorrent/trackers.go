@@ -11,6 +11,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/steeve/pulsar/util"
 )
 
 const (
@@ -143,6 +145,9 @@ func (tracker *Tracker) Connect() error {
 	if strings.Index(tracker.URL.Host, ":") < 0 {
 		tracker.URL.Host += ":80"
 	}
+	if err := util.CheckHost(tracker.URL.Host); err != nil {
+		return err
+	}
 	var err error
 	tracker.connection, err = net.DialTimeout("udp", tracker.URL.Host, DefaultTimeout)
 	if err != nil {
@@ -157,11 +162,12 @@ func (tracker *Tracker) Connect() error {
 }
 
 func (tracker *Tracker) doScrape(infoHashes [][]byte) []ScrapeResponseEntry {
+	// zero entries on failure, to keep them in line with the torrents
+	entries := make([]ScrapeResponseEntry, len(infoHashes))
 	if err := tracker.sendRequest(ActionScrape, bytes.Join(infoHashes, nil)); err != nil {
-		return nil
+		return entries
 	}
 
-	entries := make([]ScrapeResponseEntry, len(infoHashes))
 	binary.Read(tracker.reader, binary.BigEndian, &entries)
 	return entries
 }
@@ -187,6 +193,13 @@ func (tracker *Tracker) Scrape(torrents []*Torrent) []ScrapeResponseEntry {
 	return entries
 }
 
+func (tracker *Tracker) Close() error {
+	if tracker.connection == nil {
+		return nil
+	}
+	return tracker.connection.Close()
+}
+
 func (tracker *Tracker) String() string {
 	return tracker.URL.String()
 }
@@ -0,0 +1,129 @@
+package bittorrent
+
+import (
+	"net"
+	"time"
+
+	"github.com/steeve/pulsar/util"
+)
+
+const (
+	uploadTuneInterval  = 10 * time.Second
+	uploadTuneProbe     = internetCheckAddress + ":80"
+	uploadRTTInflation  = 2.0  // RTT over baseline*this means the uplink is saturated
+	uploadRateDecrease  = 0.75 // applied when saturated
+	uploadRateIncrease  = 1.1  // applied when not
+	minUploadRate       = 10 * 1024
+	uploadRatePerSlot   = 8 * 1024
+	minUploadSlots      = 2
+	uploadTuneMaxProbes = 3
+	// the baseline is the lowest RTT of the window, which lets it follow
+	// route changes without inflating along with a saturated uplink
+	baselineRTTWindow = 10 * time.Minute
+)
+
+// rttWindow keeps the RTTs of the last baselineRTTWindow.
+type rttWindow struct {
+	samples []time.Duration
+	size    int
+}
+
+func newRTTWindow() *rttWindow {
+	size := int(baselineRTTWindow / uploadTuneInterval)
+	return &rttWindow{samples: make([]time.Duration, 0, size), size: size}
+}
+
+func (w *rttWindow) add(rtt time.Duration) {
+	if len(w.samples) == w.size {
+		w.samples = w.samples[1:]
+	}
+	w.samples = append(w.samples, rtt)
+}
+
+func (w *rttWindow) min() time.Duration {
+	best := time.Duration(0)
+	for _, rtt := range w.samples {
+		if best == 0 || rtt < best {
+			best = rtt
+		}
+	}
+	return best
+}
+
+func probeRTT() (time.Duration, error) {
+	best := time.Duration(0)
+	if err := util.CheckHost(uploadTuneProbe); err != nil {
+		return 0, err
+	}
+	for i := 0; i < uploadTuneMaxProbes; i++ {
+		start := time.Now()
+		conn, err := net.DialTimeout("tcp", uploadTuneProbe, 5*time.Second)
+		if err != nil {
+			return 0, err
+		}
+		conn.Close()
+		if rtt := time.Now().Sub(start); best == 0 || rtt < best {
+			best = rtt
+		}
+	}
+	return best, nil
+}
+
+// Asymmetric links (ADSL, cable) are easily saturated by uploads, which
+// delays the ACKs of our downloads and kills streaming. The tuner lowers the
+// upload rate and slots when the RTT inflates above its baseline, and
+// slowly raises them back otherwise. The upload limit in effect, from the
+// settings, the schedule or the API, is the most it lets through.
+func (s *BTService) uploadTuner() {
+	if s.Config().UploadAutoTune == false {
+		return
+	}
+	s.log.Info("Auto-tuning upload rate")
+
+	ticker := time.NewTicker(uploadTuneInterval)
+	defer ticker.Stop()
+
+	window := newRTTWindow()
+	limit := 0
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+			ceiling := s.RateLimits().Upload
+			rtt, err := probeRTT()
+			if err != nil {
+				continue
+			}
+			window.add(rtt)
+			baseline := window.min()
+
+			uploadRate := s.Session.Status().GetUpload_rate()
+			if float64(rtt) > float64(baseline)*uploadRTTInflation {
+				limit = int(float64(uploadRate) * uploadRateDecrease)
+				if limit < minUploadRate {
+					limit = minUploadRate
+				}
+				limit = capLimit(limit, ceiling)
+				s.log.Info("RTT is %s (baseline %s), lowering upload to %dkb/s", rtt, baseline, limit/1024)
+			} else if limit > 0 {
+				limit = int(float64(limit) * uploadRateIncrease)
+				// stop limiting once we're well over what we actually use
+				// or at the ceiling
+				if limit > uploadRate*2 || (ceiling > 0 && limit >= ceiling) {
+					limit = 0
+				}
+			}
+			s.setTunedUpload(limit)
+		}
+	}
+}
+
+// setTunedUpload lowers the upload limit in effect to limit, zero going
+// back to it.
+func (s *BTService) setTunedUpload(limit int) {
+	s.ratesLock.Lock()
+	s.tunedUpload = limit
+	s.ratesLock.Unlock()
+	s.applyRateLimits(false)
+}
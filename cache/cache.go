@@ -96,9 +96,19 @@ func (w *cachedWriter) Write(data []byte) (int, error) {
 
 // Cache Middleware
 func Cache(store CacheStore, expire time.Duration) gin.HandlerFunc {
+	return CacheBy(store, expire, nil)
+}
+
+// CacheBy caches the pages by URL and by what variant returns, for the
+// pages that differ between users on the same URL.
+func CacheBy(store CacheStore, expire time.Duration, variant func(ctx *gin.Context) string) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var cache responseCache
-		key := cacheKey(PageCachePrefix, ctx.Request.URL.RequestURI())
+		u := ctx.Request.URL.RequestURI()
+		if variant != nil {
+			u = variant(ctx) + "|" + u
+		}
+		key := cacheKey(PageCachePrefix, u)
 		if err := store.Get(key, &cache); err == nil {
 			for k, vals := range cache.Header {
 				for _, v := range vals {
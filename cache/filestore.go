@@ -4,9 +4,13 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"time"
+
+	"github.com/steeve/pulsar/clock"
 )
 
 type FileStore struct {
@@ -36,9 +40,11 @@ func (c *FileStore) Set(key string, value interface{}, expires time.Duration) er
 	defer gzWriter.Close()
 
 	item := fileStoreItem{
-		Key:     key,
-		Value:   value,
-		Expires: time.Now().UTC().Add(expires),
+		Key:   key,
+		Value: value,
+	}
+	if expires != FOREVER {
+		item.Expires = clock.Now().UTC().Add(expires)
 	}
 
 	return json.NewEncoder(gzWriter).Encode(item)
@@ -77,16 +83,38 @@ func (c *FileStore) Get(key string, value interface{}) error {
 	if err = json.NewDecoder(gzReader).Decode(&item); err != nil {
 		return err
 	}
-	if item.Expires.Before(time.Now().UTC()) {
+	if item.Expires.IsZero() == false && item.Expires.Before(clock.Now().UTC()) {
 		return errors.New("key is expired")
 	}
 	return nil
 }
 
 func (c *FileStore) Delete(key string) error {
+	if err := os.Remove(path.Join(c.path, key)); err != nil && os.IsNotExist(err) == false {
+		return err
+	}
 	return nil
 }
 
+// DeletePrefix removes all the items whose key starts with prefix, and
+// returns how many were removed.
+func (c *FileStore) DeletePrefix(prefix string) (int, error) {
+	files, err := ioutil.ReadDir(c.path)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), prefix) == false {
+			continue
+		}
+		if os.Remove(path.Join(c.path, file.Name())) == nil {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
 func (c *FileStore) Increment(key string, delta uint64) (uint64, error) {
 	return 0, ErrNotSupport
 }
@@ -98,3 +126,24 @@ func (c *FileStore) Decrement(key string, delta uint64) (uint64, error) {
 func (c *FileStore) Flush() error {
 	return ErrNotSupport
 }
+
+// Purge removes the expired items, and returns how many were removed.
+func (c *FileStore) Purge() (int, error) {
+	files, err := ioutil.ReadDir(c.path)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		var value interface{}
+		if err := c.Get(file.Name(), &value); err != nil {
+			if os.Remove(path.Join(c.path, file.Name())) == nil {
+				purged++
+			}
+		}
+	}
+	return purged, nil
+}
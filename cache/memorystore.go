@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/clock"
+)
+
+type memoryItem struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// MemoryStore keeps the most recently used values, decoded, in memory. Get
+// hands out the stored value itself, so don't modify what you get.
+type MemoryStore struct {
+	lock     sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		items:    map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+func (c *MemoryStore) Get(key string, value interface{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, exists := c.items[key]
+	if exists == false {
+		return ErrCacheMiss
+	}
+	item := element.Value.(*memoryItem)
+	if item.expires.IsZero() == false && item.expires.Before(clock.Now()) {
+		c.order.Remove(element)
+		delete(c.items, key)
+		return ErrCacheMiss
+	}
+
+	target := reflect.ValueOf(value)
+	stored := reflect.ValueOf(item.value)
+	if target.Kind() != reflect.Ptr || target.IsNil() || stored.Type().AssignableTo(target.Elem().Type()) == false {
+		return errors.New("cache: cannot store cached value in " + target.Type().String())
+	}
+	target.Elem().Set(stored)
+	c.order.MoveToFront(element)
+	return nil
+}
+
+func (c *MemoryStore) Set(key string, value interface{}, expires time.Duration) error {
+	if value == nil {
+		return ErrNotStored
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	item := &memoryItem{key: key, value: value}
+	if expires != FOREVER {
+		item.expires = clock.Now().Add(expires)
+	}
+	if element, exists := c.items[key]; exists {
+		element.Value = item
+		c.order.MoveToFront(element)
+		return nil
+	}
+	c.items[key] = c.order.PushFront(item)
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryItem).key)
+	}
+	return nil
+}
+
+func (c *MemoryStore) Delete(key string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, exists := c.items[key]; exists {
+		c.order.Remove(element)
+		delete(c.items, key)
+	}
+	return nil
+}
+
+func (c *MemoryStore) DeletePrefix(prefix string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	deleted := 0
+	for key, element := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(element)
+			delete(c.items, key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+func (c *MemoryStore) Flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.items = map[string]*list.Element{}
+	c.order.Init()
+	return nil
+}
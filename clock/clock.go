@@ -0,0 +1,155 @@
+// Package clock is the time the caches and the schedules go by. Boxes
+// without a battery backed clock, many Android ones, boot in 1970 and jump
+// to the right time once they sync it, and clocks otherwise get set back
+// and forth: going by the wall clock, the caches would all expire at once,
+// or never.
+//
+// This clock moves at the pace of the wall clock, sampled every second, but
+// when the wall clock jumps it only follows it forward, never back. It also
+// starts from the last time it read, saved in the profile, so it doesn't go
+// back between runs either, unless that time is well ahead of a wall clock
+// that looks set: the mark would then stay ahead for good.
+package clock
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("clock")
+
+const (
+	sampleInterval = time.Second
+	// the wall clock moving more than this between two samples, or back,
+	// jumped
+	maxStep      = 10 * time.Second
+	saveInterval = time.Minute
+	// a wall clock before this wasn't set yet, the mark goes first then
+	unsetBefore = "2015-01-01T00:00:00Z"
+	// how far ahead of a set wall clock the mark may start the clock
+	maxMarkAhead = time.Hour
+)
+
+var (
+	lock     = sync.Mutex{}
+	current  time.Time
+	lastWall time.Time
+	markPath string
+	closing  chan bool
+)
+
+// Must be called with lock held.
+func sample() {
+	wall := time.Now()
+	if current.IsZero() {
+		current = wall
+		lastWall = wall
+		return
+	}
+	step := wall.Sub(lastWall)
+	lastWall = wall
+	if step >= 0 && step <= maxStep {
+		current = current.Add(step)
+		return
+	}
+	if wall.After(current) {
+		log.Warning("The system clock jumped forward by %s", wall.Sub(current))
+		current = wall
+	} else {
+		log.Warning("The system clock jumped back by %s, ignoring it", current.Sub(wall))
+	}
+}
+
+// Now returns the time of the clock, which never goes back.
+func Now() time.Time {
+	lock.Lock()
+	defer lock.Unlock()
+	sample()
+	return current
+}
+
+// Since is how long ago t was, by the clock.
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
+
+// Must be called with lock held.
+func save() {
+	if markPath == "" {
+		return
+	}
+	if err := ioutil.WriteFile(markPath, []byte(current.UTC().Format(time.RFC3339Nano)), 0644); err != nil {
+		log.Error("Unable to save the time: %s", err)
+	}
+}
+
+func wallIsSet(wall time.Time) bool {
+	unset, _ := time.Parse(time.RFC3339, unsetBefore)
+	return wall.After(unset)
+}
+
+// Start keeps the clock sampled and saved in path, starting from the time
+// saved there if the wall clock is behind it.
+func Start(path string) {
+	lock.Lock()
+	defer lock.Unlock()
+	if closing != nil {
+		return
+	}
+	sample()
+	markPath = path
+	if data, err := ioutil.ReadFile(path); err == nil {
+		mark, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+		if err == nil && mark.After(current) {
+			if wallIsSet(current) && mark.Sub(current) > maxMarkAhead {
+				log.Warning("The last time read is %s ahead of the system clock, capping it", mark.Sub(current))
+				mark = current.Add(maxMarkAhead)
+			}
+			log.Warning("The system clock is %s behind the last time it was read, going from there", mark.Sub(current))
+			current = mark.Local()
+		}
+	} else if os.IsNotExist(err) == false {
+		log.Error("Unable to read the last time: %s", err)
+	}
+	save()
+
+	closing = make(chan bool)
+	go func(closing chan bool) {
+		sampleTicker := time.NewTicker(sampleInterval)
+		defer sampleTicker.Stop()
+		saveTicker := time.NewTicker(saveInterval)
+		defer saveTicker.Stop()
+		for {
+			select {
+			case <-closing:
+				return
+			case <-sampleTicker.C:
+				lock.Lock()
+				sample()
+				lock.Unlock()
+			case <-saveTicker.C:
+				lock.Lock()
+				save()
+				lock.Unlock()
+			}
+		}
+	}(closing)
+}
+
+// Stop saves the time, for the next run to start from it.
+func Stop() {
+	lock.Lock()
+	defer lock.Unlock()
+	if closing == nil {
+		return
+	}
+	close(closing)
+	closing = nil
+	sample()
+	save()
+}
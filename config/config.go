@@ -2,10 +2,13 @@ package config
 
 import (
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/events"
 	"github.com/steeve/pulsar/xbmc"
 )
 
@@ -18,26 +21,276 @@ type Configuration struct {
 	Language           string
 	ProfilePath        string
 	KeepFilesAfterStop bool
+	AfterDownloads     string
+	MaxActiveDownloads int
+	QueueDownloadRate  int
 	UploadRateLimit    int
+	UploadAutoTune     bool
 	DownloadRateLimit  int
 	BTListenPortMin    int
 	BTListenPortMax    int
+	StagingPath        string
+	SlowStorage        bool
+	PieceCacheSize     int64
+	AutoDowngrade      bool
+	StartBudget        int
+	PreplayBufferSizes map[string]int
+	ServePartialPieces bool
+	AudioLanguages     []string
+	SubtitleLanguages  []string
+
+	SubtitlesAutoDownload bool
+
+	DeadlineWindow         int
+	DeadlineAggressiveness int
+	EndgameDuplicates      bool
+
+	BandwidthTestMirrors []string
+	TaskSchedules        map[string]time.Duration
+	LocalAnalytics       bool
+	ProviderDebug        bool
+
+	AnimeShows     []string
+	FansubGroups   []string
+	AnimeAudio     int
+	AnimeVerifyCRC bool
+
+	AbsoluteSeasonEpisodes map[string]int
+
+	ScoreResolution       int
+	ScoreCodec            int
+	ScoreSeeds            int
+	ReleaseGroupBlacklist []string
+	HideFailedResults     bool
+
+	FilterMovieMinSize     int
+	FilterMovieMaxSize     int
+	FilterEpisodeMinSize   int
+	FilterEpisodeMaxSize   int
+	FilterRequiredKeywords []string
+	FilterExcludedKeywords []string
+	FilterMaxResolution    string
+	FilterLanguages        []string
+
+	MetadataCacheTTLs map[string]time.Duration
+	MetadataCacheSize int
+
+	TraktClientId     string
+	TraktClientSecret string
+	TraktScrobble     bool
 
 	CustomProviderTimeoutEnabled bool
-	CustomProviderTimeout        int
+	CustomProviderTimeout        int // for the methods without their own
+	MovieProviderTimeout         int
+	EpisodeProviderTimeout       int
+	SearchProviderTimeout        int
+	MaxMovieResults              int
+	MaxEpisodeResults            int
+	MaxSearchResults             int
 
 	SocksEnabled  bool
 	SocksHost     string
 	SocksPort     int
 	SocksLogin    string
 	SocksPassword string
+	SocksTraffic  int
+
+	OutboundAllow []string
+	OutboundDeny  []string
+
+	LibraryPath string
+
+	AutoDownloadEpisodes bool
+	AutoDownloadDays     int
+
+	ProviderAdaptiveTimeouts bool
+	ProviderMaxFailures      int
+
+	DHTBootstrapNodes []string
+	MetadataTimeout   int
+
+	MenuPinned []string
+	MenuOrder  []string
+	MenuHidden []string
+
+	KioskMode bool
+	KioskPin  string
+
+	SizeUnits   string
+	ClockFormat string
+	DateOrder   string
+
+	RateSchedule             []string
+	TorrentDownloadRateLimit int
+	TorrentUploadRateLimit   int
+
+	LocalizedSearchTitles bool
+
+	TLSStrict    bool
+	TLSCABundles map[string]string
+	TLSPins      map[string][]string
+
+	HTTPSEnabled  bool
+	HTTPSCertFile string
+	HTTPSKeyFile  string
+
+	APIRateLimit     int
+	APIMaxConcurrent int
+
+	PlaybackStats bool
+
+	PostProcessScript string
+
+	ProxyType      int
+	ProxyHTTPCalls bool
+	BindInterface  string
+	KillSwitch     bool
+
+	Encryption    int
+	AnonymousMode bool
+	PeerTransport int
+
+	AvailabilityChecks int
+
+	AutoplayNext int
+
+	TVMetadata      int
+	FanartAPIKey    string
+	FanartClientKey string
+
+	MaxCacheSize int64
+
+	MetaSearch          int
+	MetaSearchEndpoints []string
+
+	SeedRatio float64
+	SeedTime  int
+
+	UsenetClient   int
+	UsenetHost     string
+	UsenetAPIKey   string
+	UsenetUsername string
+	UsenetPassword string
+	UsenetCategory string
+
+	MemoryBuffer     bool
+	MemoryBufferSize int64
+
+	SharedDownloadPath bool
+
+	SearchCacheTTL int
+
+	TextOnlyDialogs bool
+
+	WatchedSync          bool
+	WatchedSyncConflicts int
+
+	ProviderLanguageTargeting bool
+
+	RPCToken string
+
+	HTTPUsername string
+	HTTPPassword string
+	HTTPToken    string
+
+	GuestAPIPort   int
+	GuestToken     string
+	GuestEndpoints []string
+
+	LogLevels map[string]string
+
+	ClientIdentity         string
+	SourceClientIdentities map[string]string
+
+	CPULimits int
+	CPUCores  int
+
+	BasePath       string
+	ExternalURL    string
+	TrustedProxies []string
 }
 
 var config = &Configuration{}
 var lock = sync.RWMutex{}
 
+var refresh = struct {
+	sync.Mutex
+	running bool
+	pending bool
+	done    func(*Configuration)
+}{}
+
+const (
+	ListenPort      = 65251
+	HTTPSListenPort = 65253
+)
+
+// What goes through the SOCKS proxy
+const (
+	SocksTrafficAll = iota
+	SocksTrafficTrackersOnly
+	SocksTrafficPeersOnly
+)
+
+// How the proxy is spoken to
+const (
+	ProxyTypeSocks5 = iota
+	ProxyTypeHTTP
+)
+
+// Encryption policies with peers
+const (
+	EncryptionForced = iota
+	EncryptionEnabled
+	EncryptionDisabled
+)
+
+// Transports peers are connected over
+const (
+	PeerTransportPreferTCP = iota
+	PeerTransportMixed
+	PeerTransportTCPOnly
+	PeerTransportUTPOnly
+)
+
+// What happens when an episode ends
+const (
+	AutoplayNextOff = iota
+	AutoplayNextAsk
+	AutoplayNextAuto
+)
+
+// Where the seasons and episodes of shows come from
+const (
+	TVMetadataTVDB = iota
+	TVMetadataTMDB
+)
+
+// When the built-in meta-search provider is searched
+const (
+	MetaSearchAuto = iota // until a provider addon is installed
+	MetaSearchAlways
+	MetaSearchOff
+)
+
+// Where NZB results are downloaded, with usenet_host set
 const (
-	ListenPort = 65251
+	UsenetClientSABnzbd = iota
+	UsenetClientNZBGet
+)
+
+// When the peers handled are limited, for low-end CPUs
+const (
+	CPULimitsAuto = iota
+	CPULimitsOn
+	CPULimitsOff
+)
+
+// Which side wins when an item was marked watched on one and unwatched on
+// another since the last watched sync
+const (
+	WatchedSyncMostRecent = iota
+	WatchedSyncTraktWins
 )
 
 func Get() *Configuration {
@@ -46,42 +299,303 @@ func Get() *Configuration {
 	return config
 }
 
+// Reload reads the configuration, taking the addon settings from the
+// cache when they're there, as on startup.
 func Reload() *Configuration {
+	return reload(false)
+}
+
+// Refresh reads the addon settings from XBMC again in the background, as
+// when they changed, Get() returning the current configuration meanwhile.
+// done is called with the new configuration, if it's any different, which
+// is also published on the event bus.
+// Refreshes requested while one runs are done once, after it.
+func Refresh(done func(*Configuration)) {
+	refresh.Lock()
+	defer refresh.Unlock()
+	refresh.done = done
+	if refresh.running {
+		refresh.pending = true
+		return
+	}
+	refresh.running = true
+
+	go func() {
+		for {
+			previous := Get()
+			newConfig := reload(true)
+
+			refresh.Lock()
+			done := refresh.done
+			refresh.Unlock()
+			if reflect.DeepEqual(previous, newConfig) == false {
+				events.Publish(events.ConfigChanged, newConfig)
+				if done != nil {
+					done(newConfig)
+				}
+			}
+
+			refresh.Lock()
+			if refresh.pending == false {
+				refresh.running = false
+				refresh.Unlock()
+				return
+			}
+			refresh.pending = false
+			refresh.Unlock()
+		}
+	}()
+}
+
+func reload(fresh bool) *Configuration {
 	log.Info("Reloading configuration...")
 
-	info := xbmc.GetAddonInfo()
-	info.Path = xbmc.TranslatePath(info.Path)
-	info.Profile = xbmc.TranslatePath(info.Profile)
+	previous := Get()
+	takeSettingsError()
+	loadConfigFile()
 
-	info.Path = strings.Replace(info.Path, "/storage/emulated/0", "/storage/emulated/legacy", 1)
-	info.Profile = strings.Replace(info.Profile, "/storage/emulated/0", "/storage/emulated/legacy", 1)
+	var info *xbmc.AddonInfo
+	var platform *xbmc.Platform
+	var language string
+	var cached map[string]string
+	if IsDaemonMode() {
+		info = daemonAddonInfo()
+		platform = daemonPlatform()
+		if language = getSettingString("language"); language == "" {
+			language = "en"
+		}
+	} else {
+		info = xbmc.GetAddonInfo()
+		info.Path = xbmc.TranslatePath(info.Path)
+		info.Profile = xbmc.TranslatePath(info.Profile)
+
+		info.Path = strings.Replace(info.Path, "/storage/emulated/0", "/storage/emulated/legacy", 1)
+		info.Profile = strings.Replace(info.Profile, "/storage/emulated/0", "/storage/emulated/legacy", 1)
+
+		if fresh {
+			cached = clearSettingsCache()
+		} else {
+			loadSettingsCache(info.Profile)
+		}
+
+		platform = xbmc.GetPlatform()
+		if language = getSettingString("language"); language == "" {
+			language = xbmc.GetLanguage(xbmc.ISO_639_1)
+		}
+	}
 
 	newConfig := Configuration{
-		DownloadPath:       filepath.Dir(xbmc.GetSettingString("download_path")),
+		DownloadPath:       getSettingPath("download_path"),
 		Info:               info,
-		Platform:           xbmc.GetPlatform(),
-		Language:           xbmc.GetLanguage(xbmc.ISO_639_1),
+		Platform:           platform,
+		Language:           language,
 		ProfilePath:        info.Profile,
-		UploadRateLimit:    xbmc.GetSettingInt("max_upload_rate") * 1024,
-		DownloadRateLimit:  xbmc.GetSettingInt("max_download_rate") * 1024,
-		KeepFilesAfterStop: xbmc.GetSettingBool("keep_files"),
-		BTListenPortMin:    xbmc.GetSettingInt("listen_port_min"),
-		BTListenPortMax:    xbmc.GetSettingInt("listen_port_max"),
-
-		CustomProviderTimeoutEnabled: xbmc.GetSettingBool("custom_provider_timeout_enabled"),
-		CustomProviderTimeout:        xbmc.GetSettingInt("custom_provider_timeout"),
-
-		SocksEnabled:  xbmc.GetSettingBool("socks_enabled"),
-		SocksHost:     xbmc.GetSettingString("socks_host"),
-		SocksPort:     xbmc.GetSettingInt("socks_port"),
-		SocksLogin:    xbmc.GetSettingString("socks_login"),
-		SocksPassword: xbmc.GetSettingString("socks_password"),
+		UploadRateLimit:    getSettingInt("max_upload_rate") * 1024,
+		UploadAutoTune:     getSettingBool("upload_autotune"),
+		DownloadRateLimit:  getSettingInt("max_download_rate") * 1024,
+		KeepFilesAfterStop: getSettingBool("keep_files"),
+		AfterDownloads:     getSettingString("after_downloads"),
+		MaxActiveDownloads: getSettingInt("max_active_downloads"),
+		QueueDownloadRate:  getSettingInt("queue_download_rate") * 1024,
+		BTListenPortMin:    getSettingInt("listen_port_min"),
+		BTListenPortMax:    getSettingInt("listen_port_max"),
+		StagingPath:        getSettingString("staging_path"),
+		SlowStorage:        getSettingBool("slow_storage"),
+		PieceCacheSize:     int64(getSettingInt("piece_cache_size")) * 1024 * 1024,
+		AutoDowngrade:      getSettingBool("auto_downgrade"),
+		StartBudget:        getSettingInt("start_budget"),
+		PreplayBufferSizes: getSettingInts("preplay_buffer_sizes"),
+		ServePartialPieces: getSettingBool("serve_partial_pieces"),
+		AudioLanguages:     getSettingList("audio_languages"),
+		SubtitleLanguages:  getSettingList("subtitle_languages"),
+
+		SubtitlesAutoDownload: getSettingBool("subtitles_auto_download"),
+
+		DeadlineWindow:         getSettingInt("deadline_window"),
+		DeadlineAggressiveness: getSettingInt("deadline_aggressiveness"),
+		EndgameDuplicates:      getSettingBool("endgame_duplicates"),
+
+		BandwidthTestMirrors: getSettingList("bandwidth_test_mirrors"),
+		TaskSchedules:        getSettingDurations("task_schedules"),
+		LocalAnalytics:       getSettingBool("local_analytics"),
+		ProviderDebug:        getSettingBool("provider_debug"),
+
+		AnimeShows:     getSettingList("anime_shows"),
+		FansubGroups:   getSettingList("fansub_groups"),
+		AnimeAudio:     getSettingInt("anime_audio"),
+		AnimeVerifyCRC: getSettingBool("anime_verify_crc"),
+
+		AbsoluteSeasonEpisodes: getSettingInts("absolute_season_episodes"),
+
+		ScoreResolution:       getSettingInt("score_resolution"),
+		ScoreCodec:            getSettingInt("score_codec"),
+		ScoreSeeds:            getSettingInt("score_seeds"),
+		ReleaseGroupBlacklist: getSettingList("release_group_blacklist"),
+		HideFailedResults:     getSettingBool("hide_failed_results"),
+
+		FilterMovieMinSize:     getSettingInt("filter_movie_min_size"),
+		FilterMovieMaxSize:     getSettingInt("filter_movie_max_size"),
+		FilterEpisodeMinSize:   getSettingInt("filter_episode_min_size"),
+		FilterEpisodeMaxSize:   getSettingInt("filter_episode_max_size"),
+		FilterRequiredKeywords: getSettingList("filter_required_keywords"),
+		FilterExcludedKeywords: getSettingList("filter_excluded_keywords"),
+		FilterMaxResolution:    getSettingString("filter_max_resolution"),
+		FilterLanguages:        getSettingList("filter_languages"),
+
+		MetadataCacheTTLs: getSettingDurations("metadata_cache_ttls"),
+		MetadataCacheSize: getSettingInt("metadata_cache_size"),
+
+		TraktClientId:     getSettingString("trakt_client_id"),
+		TraktClientSecret: getSettingString("trakt_client_secret"),
+		TraktScrobble:     getSettingBool("trakt_scrobble"),
+
+		CustomProviderTimeoutEnabled: getSettingBool("custom_provider_timeout_enabled"),
+		CustomProviderTimeout:        getSettingInt("custom_provider_timeout"),
+		MovieProviderTimeout:         getSettingInt("movie_provider_timeout"),
+		EpisodeProviderTimeout:       getSettingInt("episode_provider_timeout"),
+		SearchProviderTimeout:        getSettingInt("search_provider_timeout"),
+		MaxMovieResults:              getSettingInt("max_movie_results"),
+		MaxEpisodeResults:            getSettingInt("max_episode_results"),
+		MaxSearchResults:             getSettingInt("max_search_results"),
+
+		SocksEnabled:  getSettingBool("socks_enabled"),
+		SocksHost:     getSettingString("socks_host"),
+		SocksPort:     getSettingInt("socks_port"),
+		SocksLogin:    getSettingString("socks_login"),
+		SocksPassword: getSettingString("socks_password"),
+		SocksTraffic:  getSettingInt("socks_traffic"),
+
+		OutboundAllow: getSettingList("outbound_allow"),
+		OutboundDeny:  getSettingList("outbound_deny"),
+
+		LibraryPath: getSettingString("library_path"),
+
+		AutoDownloadEpisodes: getSettingBool("auto_download_episodes"),
+		AutoDownloadDays:     getSettingInt("auto_download_days"),
+
+		ProviderAdaptiveTimeouts: getSettingBool("provider_adaptive_timeouts"),
+		ProviderMaxFailures:      getSettingInt("provider_max_failures"),
+
+		DHTBootstrapNodes: getSettingList("dht_bootstrap_nodes"),
+		MetadataTimeout:   getSettingInt("metadata_timeout"),
+
+		MenuPinned: getSettingList("menu_pinned"),
+		MenuOrder:  getSettingList("menu_order"),
+		MenuHidden: getSettingList("menu_hidden"),
+
+		KioskMode: getSettingBool("kiosk_mode"),
+		KioskPin:  getSettingString("kiosk_pin"),
+
+		SizeUnits:   getSettingString("size_units"),
+		ClockFormat: getSettingString("clock_format"),
+		DateOrder:   getSettingString("date_order"),
+
+		RateSchedule:             getSettingList("rate_schedule"),
+		TorrentDownloadRateLimit: getSettingInt("max_torrent_download_rate") * 1024,
+		TorrentUploadRateLimit:   getSettingInt("max_torrent_upload_rate") * 1024,
+
+		LocalizedSearchTitles: getSettingBool("localized_search_titles"),
+
+		TLSStrict:    getSettingBool("tls_strict"),
+		TLSCABundles: getSettingMap("tls_ca_bundles"),
+		TLSPins:      getSettingLists("tls_pins"),
+
+		HTTPSEnabled:  getSettingBool("https"),
+		HTTPSCertFile: getSettingString("https_cert"),
+		HTTPSKeyFile:  getSettingString("https_key"),
+
+		APIRateLimit:     getSettingInt("api_rate_limit"),
+		APIMaxConcurrent: getSettingInt("api_max_concurrent"),
+
+		PlaybackStats: getSettingBool("playback_stats"),
+
+		PostProcessScript: getSettingString("postprocess_script"),
+
+		ProxyType:      getSettingInt("proxy_type"),
+		ProxyHTTPCalls: getSettingBool("proxy_http_calls"),
+		BindInterface:  getSettingString("bind_interface"),
+		KillSwitch:     getSettingBool("kill_switch"),
+
+		Encryption:    getSettingInt("encryption"),
+		AnonymousMode: getSettingBool("anonymous_mode"),
+		PeerTransport: getSettingInt("peer_transport"),
+
+		AvailabilityChecks: getSettingInt("availability_checks"),
+
+		AutoplayNext: getSettingInt("autoplay_next"),
+
+		TVMetadata:      getSettingInt("tv_metadata"),
+		FanartAPIKey:    getSettingString("fanart_api_key"),
+		FanartClientKey: getSettingString("fanart_client_key"),
+
+		MaxCacheSize: int64(getSettingInt("max_cache_size")) * 1024 * 1024 * 1024,
+
+		MetaSearch:          getSettingInt("meta_search"),
+		MetaSearchEndpoints: getSettingList("meta_search_endpoints"),
+
+		SeedRatio: getSettingFloat("seed_ratio"),
+		SeedTime:  getSettingInt("seed_time"),
+
+		UsenetClient:   getSettingInt("usenet_client"),
+		UsenetHost:     getSettingString("usenet_host"),
+		UsenetAPIKey:   getSettingString("usenet_api_key"),
+		UsenetUsername: getSettingString("usenet_username"),
+		UsenetPassword: getSettingString("usenet_password"),
+		UsenetCategory: getSettingString("usenet_category"),
+
+		MemoryBuffer:     getSettingBool("memory_buffer"),
+		MemoryBufferSize: int64(getSettingInt("memory_buffer_size")) * 1024 * 1024,
+
+		SharedDownloadPath: getSettingBool("shared_download_path"),
+
+		SearchCacheTTL: getSettingInt("search_cache_ttl"),
+
+		TextOnlyDialogs: getSettingBool("text_only_dialogs"),
+
+		WatchedSync:          getSettingBool("watched_sync"),
+		WatchedSyncConflicts: getSettingInt("watched_sync_conflicts"),
+
+		ProviderLanguageTargeting: getSettingBool("provider_language_targeting"),
+
+		RPCToken: getSettingString("rpc_token"),
+
+		HTTPUsername: getSettingString("http_username"),
+		HTTPPassword: getSettingString("http_password"),
+		HTTPToken:    getSettingString("http_token"),
+
+		GuestAPIPort:   getSettingInt("guest_api_port"),
+		GuestToken:     getSettingString("guest_token"),
+		GuestEndpoints: getSettingList("guest_endpoints"),
+
+		LogLevels: getSettingMap("log_levels"),
+
+		ClientIdentity:         getSettingString("client_identity"),
+		SourceClientIdentities: getSettingMap("source_client_identities"),
+
+		CPULimits: getSettingInt("cpu_limits"),
+		CPUCores:  getSettingInt("cpu_cores"),
+
+		BasePath:       getSettingString("base_path"),
+		ExternalURL:    getSettingString("external_url"),
+		TrustedProxies: getSettingList("trusted_proxies"),
 	}
+	// a busy XBMC would blank the settings it didn't answer for
+	if err := takeSettingsError(); err != nil && previous.Info != nil {
+		log.Error("Unable to read the settings from XBMC, keeping the current ones: %s", err)
+		if fresh {
+			restoreSettingsCache(cached)
+		}
+		return previous
+	}
+	if IsDaemonMode() == false {
+		saveSettingsCache(info.Profile)
+	}
+
 	lock.Lock()
 	config = &newConfig
 	lock.Unlock()
 
-	return config
+	return &newConfig
 }
 
 func AddonIcon() string {
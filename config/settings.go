@@ -0,0 +1,295 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// Settings are looked up, in order, in:
+//   - the PULSAR_<SETTING_ID> environment variables
+//   - the JSON file pointed to by PULSAR_CONFIG
+//   - the XBMC addon settings, unless running in daemon mode
+const (
+	envPrefix     = "PULSAR_"
+	envConfigFile = envPrefix + "CONFIG"
+	envDaemon     = envPrefix + "DAEMON"
+	envProfile    = envPrefix + "PROFILE_PATH"
+	envAddonPath  = envPrefix + "ADDON_PATH"
+
+	defaultAddonId = "plugin.video.pulsar"
+
+	settingsCacheFile = "settings_cache.json"
+)
+
+var fileSettings = map[string]string{}
+
+// The addon settings are read from XBMC one JSON-RPC round trip each, so
+// they're kept here, and on disk for the next start.
+var (
+	xbmcSettings     = map[string]string{}
+	xbmcSettingsErr  error // see takeSettingsError
+	xbmcSettingsLock = sync.RWMutex{}
+)
+
+// In daemon mode, Pulsar runs without XBMC (in a container for instance),
+// and all the settings come from the environment and the config file.
+func IsDaemonMode() bool {
+	return os.Getenv(envDaemon) == "true" || os.Getenv(envDaemon) == "1"
+}
+
+func loadConfigFile() {
+	settings := map[string]string{}
+	defer func() {
+		fileSettings = settings
+	}()
+
+	configFile := os.Getenv(envConfigFile)
+	if configFile == "" {
+		return
+	}
+	file, err := os.Open(configFile)
+	if err != nil {
+		log.Error("Unable to open config file %s: %s", configFile, err)
+		return
+	}
+	defer file.Close()
+
+	values := map[string]interface{}{}
+	decoder := json.NewDecoder(file)
+	// numbers as written, big ones would print as floats like 1e+06
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		log.Error("Unable to parse config file %s: %s", configFile, err)
+		return
+	}
+	for id, value := range values {
+		settings[id] = fmt.Sprintf("%v", value)
+	}
+	log.Info("Loaded %d settings from %s", len(settings), configFile)
+}
+
+func getSettingString(id string) string {
+	if value := os.Getenv(envPrefix + strings.ToUpper(id)); value != "" {
+		return value
+	}
+	if value, ok := fileSettings[id]; ok {
+		return value
+	}
+	if IsDaemonMode() {
+		return ""
+	}
+	return xbmcSetting(id)
+}
+
+func xbmcSetting(id string) string {
+	xbmcSettingsLock.RLock()
+	value, ok := xbmcSettings[id]
+	xbmcSettingsLock.RUnlock()
+	if ok {
+		return value
+	}
+	value, err := xbmc.GetSetting(id)
+	xbmcSettingsLock.Lock()
+	defer xbmcSettingsLock.Unlock()
+	if err != nil {
+		// not cached, it'd be blank until the next refresh
+		log.Warning("Unable to read setting %s: %s", id, err)
+		xbmcSettingsErr = err
+		return ""
+	}
+	xbmcSettings[id] = value
+	return value
+}
+
+// takeSettingsError is the last error reading the settings from XBMC since
+// it was last called.
+func takeSettingsError() error {
+	xbmcSettingsLock.Lock()
+	defer xbmcSettingsLock.Unlock()
+	err := xbmcSettingsErr
+	xbmcSettingsErr = nil
+	return err
+}
+
+// clearSettingsCache returns the settings cached until then, which
+// restoreSettingsCache puts back if reading them again fails.
+func clearSettingsCache() map[string]string {
+	xbmcSettingsLock.Lock()
+	defer xbmcSettingsLock.Unlock()
+	previous := xbmcSettings
+	xbmcSettings = map[string]string{}
+	return previous
+}
+
+func restoreSettingsCache(settings map[string]string) {
+	xbmcSettingsLock.Lock()
+	xbmcSettings = settings
+	xbmcSettingsLock.Unlock()
+}
+
+// loadSettingsCache reads the settings saved on last run, unless they were
+// already read.
+func loadSettingsCache(profilePath string) {
+	xbmcSettingsLock.Lock()
+	defer xbmcSettingsLock.Unlock()
+	if len(xbmcSettings) > 0 {
+		return
+	}
+	file, err := os.Open(filepath.Join(profilePath, settingsCacheFile))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	settings := map[string]string{}
+	if err := json.NewDecoder(file).Decode(&settings); err != nil {
+		log.Warning("Ignoring the settings cache: %s", err)
+		return
+	}
+	xbmcSettings = settings
+	log.Info("Loaded %d cached settings", len(settings))
+}
+
+func saveSettingsCache(profilePath string) {
+	xbmcSettingsLock.RLock()
+	defer xbmcSettingsLock.RUnlock()
+	file, err := os.Create(filepath.Join(profilePath, settingsCacheFile))
+	if err != nil {
+		log.Warning("Unable to save the settings cache: %s", err)
+		return
+	}
+	defer file.Close()
+	json.NewEncoder(file).Encode(xbmcSettings)
+}
+
+func getSettingInt(id string) int {
+	val, _ := strconv.Atoi(getSettingString(id))
+	return val
+}
+
+func getSettingFloat(id string) float64 {
+	val, _ := strconv.ParseFloat(getSettingString(id), 64)
+	return val
+}
+
+// XBMC says "true", the API and the imports may say "1" or "True".
+func getSettingBool(id string) bool {
+	val, _ := strconv.ParseBool(strings.TrimSpace(getSettingString(id)))
+	return val
+}
+
+// Folder settings, which XBMC gives with a trailing separator and the
+// environment and the settings file may not.
+func getSettingPath(id string) string {
+	path := getSettingString(id)
+	if path == "" {
+		return ""
+	}
+	return filepath.Clean(path)
+}
+
+// Comma separated list settings, such as language preferences.
+func getSettingList(id string) []string {
+	list := make([]string, 0)
+	for _, value := range strings.Split(getSettingString(id), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// Settings such as "cache_cleanup=6h,library_refresh=24h".
+func getSettingDurations(id string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, value := range getSettingList(id) {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil || duration <= 0 {
+			log.Warning("Invalid duration for %s in %s: %s", parts[0], id, parts[1])
+			continue
+		}
+		durations[strings.TrimSpace(parts[0])] = duration
+	}
+	return durations
+}
+
+// Parses "key=10,other=20" settings.
+func getSettingInts(id string) map[string]int {
+	ints := make(map[string]int)
+	for _, value := range getSettingList(id) {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			log.Warning("Invalid number for %s in %s: %s", parts[0], id, parts[1])
+			continue
+		}
+		ints[strings.TrimSpace(parts[0])] = i
+	}
+	return ints
+}
+
+// Parses "key=value,other=value" settings.
+func getSettingMap(id string) map[string]string {
+	values := make(map[string]string)
+	for _, value := range getSettingList(id) {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return values
+}
+
+// Parses "key=a|b,other=c" settings.
+func getSettingLists(id string) map[string][]string {
+	lists := make(map[string][]string)
+	for key, value := range getSettingMap(id) {
+		for _, item := range strings.Split(value, "|") {
+			if item = strings.TrimSpace(item); item != "" {
+				lists[key] = append(lists[key], item)
+			}
+		}
+	}
+	return lists
+}
+
+func daemonAddonInfo() *xbmc.AddonInfo {
+	profile := os.Getenv(envProfile)
+	if profile == "" {
+		profile = filepath.Join(os.Getenv("HOME"), ".pulsar")
+	}
+	addonPath := os.Getenv(envAddonPath)
+	if addonPath == "" {
+		addonPath, _ = os.Getwd()
+	}
+	os.MkdirAll(profile, 0777)
+	return &xbmc.AddonInfo{
+		Id:      defaultAddonId,
+		Name:    "Pulsar",
+		Path:    addonPath,
+		Profile: profile,
+	}
+}
+
+func daemonPlatform() *xbmc.Platform {
+	return &xbmc.Platform{
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+	}
+}
@@ -0,0 +1,142 @@
+// Package events is the bus modules publish what happens on, and subscribe
+// to what they care about, without knowing of each other.
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+type Topic string
+
+const (
+	TorrentAdded    Topic = "torrent.added"
+	TorrentFinished Topic = "torrent.finished"
+	TorrentRemoved  Topic = "torrent.removed"
+	// failing verification too often, see bittorrent.Quarantine
+	TorrentQuarantined Topic = "torrent.quarantined"
+
+	PlaybackStarted  Topic = "playback.started"
+	PlaybackProgress Topic = "playback.progress"
+	PlaybackStopped  Topic = "playback.stopped"
+	PlaybackPaused   Topic = "playback.paused"
+	PlaybackResumed  Topic = "playback.resumed"
+
+	SearchDone Topic = "search.done"
+
+	ConfigChanged Topic = "config.changed"
+)
+
+// How many events a subscriber can be late of before it misses some.
+const subscriberBuffer = 64
+
+var log = logging.MustGetLogger("events")
+
+type Event struct {
+	Topic Topic       `json:"topic"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// Torrent is the data of the torrent events.
+type Torrent struct {
+	InfoHash string `json:"info_hash"`
+	Name     string `json:"name,omitempty"`
+	Download bool   `json:"download,omitempty"` // a background download
+}
+
+// Playback is the data of the playback events. The ids are those of the
+// title played, if known.
+type Playback struct {
+	InfoHash string        `json:"info_hash"`
+	IMDBId   string        `json:"imdb_id,omitempty"`
+	TVDBId   int           `json:"tvdb_id,omitempty"`
+	Season   int           `json:"season,omitempty"`
+	Episode  int           `json:"episode,omitempty"`
+	Position time.Duration `json:"position"`
+	Duration time.Duration `json:"duration"`
+}
+
+// Progress is how far playback went, from 0 to 1.
+func (playback *Playback) Progress() float64 {
+	if playback.Duration == 0 {
+		return 0
+	}
+	return float64(playback.Position) / float64(playback.Duration)
+}
+
+// Search is the data of the search events.
+type Search struct {
+	Method  string `json:"method"`
+	Query   string `json:"query"`
+	Results int    `json:"results"`
+}
+
+type subscriber struct {
+	topics map[Topic]bool
+	c      chan *Event
+}
+
+var (
+	lock        = sync.RWMutex{}
+	subscribers = map[*subscriber]bool{}
+)
+
+// Subscribe returns the events of the topics, all of them if none are
+// given, and the function to call once done with them. Subscribers that
+// don't keep up miss events rather than hold the publishers.
+func Subscribe(topics ...Topic) (<-chan *Event, func()) {
+	s := &subscriber{
+		topics: make(map[Topic]bool, len(topics)),
+		c:      make(chan *Event, subscriberBuffer),
+	}
+	for _, topic := range topics {
+		s.topics[topic] = true
+	}
+	lock.Lock()
+	subscribers[s] = true
+	lock.Unlock()
+
+	once := sync.Once{}
+	return s.c, func() {
+		once.Do(func() {
+			lock.Lock()
+			delete(subscribers, s)
+			lock.Unlock()
+			close(s.c)
+		})
+	}
+}
+
+func Publish(topic Topic, data interface{}) {
+	event := &Event{
+		Topic: topic,
+		Time:  time.Now(),
+		Data:  data,
+	}
+	lock.RLock()
+	defer lock.RUnlock()
+	for s := range subscribers {
+		if len(s.topics) > 0 && s.topics[topic] == false {
+			continue
+		}
+		select {
+		case s.c <- event:
+		default:
+			log.Warning("A subscriber is too slow, dropping %s", topic)
+		}
+	}
+}
+
+// Handle calls fn on every event of the topics, until the bus is done
+// with the subscriber.
+func Handle(fn func(event *Event), topics ...Topic) {
+	c, _ := Subscribe(topics...)
+	go func() {
+		for event := range c {
+			fn(event)
+		}
+	}()
+}
@@ -0,0 +1,251 @@
+// Package fanart gets the artwork of shows and movies from fanart.tv, whose
+// posters, clear arts and logos are better than TMDB's and TVDB's.
+package fanart
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmcvetta/napping"
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/metacache"
+	"github.com/steeve/pulsar/util"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const (
+	fanartEndpoint          = "http://webservice.fanart.tv/v3/"
+	burstRate               = 10
+	burstTime               = 1 * time.Second
+	simultaneousConnections = 10
+)
+
+var log = logging.MustGetLogger("fanart")
+
+var rateLimiter = util.NewRateLimiter(burstRate, burstTime, simultaneousConnections)
+
+var session = &napping.Session{Client: util.NewHTTPClient(util.TLSDefault)}
+
+type Image struct {
+	Id     string `json:"id"`
+	URL    string `json:"url"`
+	Lang   string `json:"lang"`
+	Likes  string `json:"likes"`
+	Season string `json:"season,omitempty"`
+}
+
+type Images []*Image
+
+type ShowImages struct {
+	Name          string `json:"name"`
+	TVDBId        string `json:"thetvdb_id"`
+	ClearArts     Images `json:"hdclearart"`
+	OldClearArts  Images `json:"clearart"`
+	ClearLogos    Images `json:"hdtvlogo"`
+	OldClearLogos Images `json:"clearlogo"`
+	Posters       Images `json:"tvposter"`
+	Backgrounds   Images `json:"showbackground"`
+	Thumbs        Images `json:"tvthumb"`
+	Banners       Images `json:"tvbanner"`
+	SeasonPosters Images `json:"seasonposter"`
+}
+
+type MovieImages struct {
+	Name          string `json:"name"`
+	TMDBId        string `json:"tmdb_id"`
+	ClearArts     Images `json:"hdmovieclearart"`
+	OldClearArts  Images `json:"movieart"`
+	ClearLogos    Images `json:"hdmovielogo"`
+	OldClearLogos Images `json:"movielogo"`
+	Posters       Images `json:"movieposter"`
+	Backgrounds   Images `json:"moviebackground"`
+	Thumbs        Images `json:"moviethumb"`
+	Banners       Images `json:"moviebanner"`
+}
+
+// Enabled tells whether there's an API key to ask fanart.tv with.
+func Enabled() bool {
+	return config.Get().FanartAPIKey != ""
+}
+
+func get(endpoint string, key string, images interface{}) bool {
+	if err := metacache.Get(metacache.Fanart, key, images); err == nil {
+		return true
+	}
+	params := napping.Params{"api_key": config.Get().FanartAPIKey}
+	if clientKey := config.Get().FanartClientKey; clientKey != "" {
+		params["client_key"] = clientKey
+	}
+	var resp *napping.Response
+	var err error
+	rateLimiter.Call(func() {
+		resp, err = session.Get(fanartEndpoint+endpoint, &params, images, nil)
+	})
+	switch {
+	case err != nil:
+		log.Warning("Unable to get %s from fanart.tv: %s", endpoint, err)
+		return false
+	case resp.Status() == 404:
+		// remembered, most titles have no artwork at all
+	case resp.Status() != 200:
+		log.Warning("fanart.tv answered %s with status %d", endpoint, resp.Status())
+		return false
+	}
+	metacache.Set(metacache.Fanart, key, images)
+	return true
+}
+
+// GetShow returns the artwork of the show, nil if there's none or fanart.tv
+// isn't set up.
+func GetShow(tvdbId int) *ShowImages {
+	if Enabled() == false || tvdbId == 0 {
+		return nil
+	}
+	var images *ShowImages
+	if get(fmt.Sprintf("tv/%d", tvdbId), fmt.Sprintf("tv.%d", tvdbId), &images) == false {
+		return nil
+	}
+	return images
+}
+
+// GetMovie returns the artwork of the movie, nil if there's none or
+// fanart.tv isn't set up.
+func GetMovie(tmdbId int) *MovieImages {
+	if Enabled() == false || tmdbId == 0 {
+		return nil
+	}
+	var images *MovieImages
+	if get(fmt.Sprintf("movies/%d", tmdbId), fmt.Sprintf("movie.%d", tmdbId), &images) == false {
+		return nil
+	}
+	return images
+}
+
+type byLikes Images
+
+func (a byLikes) Len() int      { return len(a) }
+func (a byLikes) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byLikes) Less(i, j int) bool {
+	iLikes, _ := strconv.Atoi(a[i].Likes)
+	jLikes, _ := strconv.Atoi(a[j].Likes)
+	return iLikes > jLikes
+}
+
+// Best is the most liked image in the language, then in English, then
+// without text. It's "" when there's none.
+func (images Images) Best(language string) string {
+	sorted := make(Images, len(images))
+	copy(sorted, images)
+	sort.Stable(byLikes(sorted))
+	for _, lang := range []string{language, "en", "00", ""} {
+		for _, image := range sorted {
+			if image.Lang == lang {
+				return image.URL
+			}
+		}
+	}
+	if len(sorted) > 0 {
+		return sorted[0].URL
+	}
+	return ""
+}
+
+// Season keeps the images of the season, "all" ones included.
+func (images Images) Season(season int) Images {
+	kept := make(Images, 0)
+	for _, image := range images {
+		if image.Season == strconv.Itoa(season) || image.Season == "all" {
+			kept = append(kept, image)
+		}
+	}
+	return kept
+}
+
+// first is the best of the first images having one, HD ones coming before
+// the old ones.
+func first(language string, choices ...Images) string {
+	for _, images := range choices {
+		if url := images.Best(language); url != "" {
+			return url
+		}
+	}
+	return ""
+}
+
+func setArt(field *string, url string) {
+	if url != "" {
+		*field = url
+	}
+}
+
+// apply puts the artwork in the list item, keeping what it has when
+// fanart.tv has nothing better.
+func apply(item *xbmc.ListItem, language string, posters, backgrounds Images, clearArt, clearLogo string, thumbs, banners Images) {
+	if item.Art == nil {
+		item.Art = &xbmc.ListItemArt{}
+	}
+	if poster := posters.Best(language); poster != "" {
+		item.Art.Poster = poster
+		item.Art.Thumbnail = poster
+		item.Thumbnail = poster
+	}
+	setArt(&item.Art.FanArt, backgrounds.Best(""))
+	setArt(&item.Art.ClearArt, clearArt)
+	setArt(&item.Art.ClearLogo, clearLogo)
+	setArt(&item.Art.Landscape, thumbs.Best(language))
+	setArt(&item.Art.Banner, banners.Best(language))
+}
+
+func (images *ShowImages) Apply(item *xbmc.ListItem, language string) {
+	if images == nil {
+		return
+	}
+	apply(item, language, images.Posters, images.Backgrounds,
+		first(language, images.ClearArts, images.OldClearArts),
+		first(language, images.ClearLogos, images.OldClearLogos),
+		images.Thumbs, images.Banners)
+}
+
+// ApplySeason does the same for a season, with its own poster.
+func (images *ShowImages) ApplySeason(item *xbmc.ListItem, season int, language string) {
+	if images == nil {
+		return
+	}
+	images.Apply(item, language)
+	if poster := images.SeasonPosters.Season(season).Best(language); poster != "" {
+		item.Art.Poster = poster
+		item.Art.Thumbnail = poster
+		item.Thumbnail = poster
+	}
+}
+
+func (images *MovieImages) Apply(item *xbmc.ListItem, language string) {
+	if images == nil {
+		return
+	}
+	apply(item, language, images.Posters, images.Backgrounds,
+		first(language, images.ClearArts, images.OldClearArts),
+		first(language, images.ClearLogos, images.OldClearLogos),
+		images.Thumbs, images.Banners)
+}
+
+// Lookups runs the lookups of a listing at once, as fanart.tv answers one
+// title per call.
+func Lookups(count int, lookup func(i int)) {
+	if Enabled() == false {
+		return
+	}
+	wg := sync.WaitGroup{}
+	wg.Add(count)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			defer wg.Done()
+			lookup(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -5,6 +5,11 @@ import "github.com/gin-gonic/gin"
 func GATracker() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		path := ctx.Request.URL.Path
+		// don't track supervisor polling, nor scraping
+		if path == "/healthz" || path == "/readyz" || path == "/metrics" {
+			ctx.Next()
+			return
+		}
 		query := ctx.Request.URL.RawQuery
 		if query != "" {
 			path += "?" + query
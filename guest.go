@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/steeve/pulsar/api"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/util"
+)
+
+// serveGuestAPI serves the read-only guest routes, on a port of their own
+// so that the rest stays out of reach of the guests.
+func serveGuestAPI(port int, btService *bittorrent.BTService) {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		log.Error("Unable to listen on guest API port %d: %s", port, err)
+		return
+	}
+	log.Info("Serving the guest API on port %d", port)
+	http.Serve(listener, util.Forwarded(api.GuestRoutes(btService)))
+}
@@ -0,0 +1,147 @@
+package health
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/util"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const (
+	bandwidthTestDuration = 10 * time.Second
+	bandwidthTestMaxSize  = 100 * 1024 * 1024 // 100m
+	diskTestSize          = 64 * 1024 * 1024  // 64m
+	diskTestChunkSize     = 1024 * 1024       // 1m
+)
+
+var DefaultBandwidthMirrors = []string{
+	"http://speedtest.tele2.net/100MB.zip",
+	"http://ipv4.download.thinkbroadband.com/100MB.zip",
+	"http://proof.ovh.net/files/100Mb.dat",
+}
+
+func formatRate(bytes int64, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "n/a"
+	}
+	return util.FormatSpeed(int64(float64(bytes) / elapsed.Seconds()))
+}
+
+// Downloads from a mirror for at most bandwidthTestDuration. Those are
+// plain HTTP downloads, so a low result means the connection itself is
+// slow, not the swarm.
+func checkMirror(mirror string) *Check {
+	check := &Check{
+		Name: "Download from " + mirror,
+		Hint: "Your internet connection is slow or throttled, this is not related to the torrents.",
+	}
+	client := &http.Client{
+		Timeout: bandwidthTestDuration + 5*time.Second,
+	}
+	start := time.Now()
+	resp, err := client.Get(mirror)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		check.Error = resp.Status
+		return check
+	}
+
+	buf := make([]byte, 32*1024)
+	total := int64(0)
+	deadline := start.Add(bandwidthTestDuration)
+	for total < bandwidthTestMaxSize && time.Now().Before(deadline) {
+		n, err := resp.Body.Read(buf)
+		total += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			check.Error = err.Error()
+			break
+		}
+	}
+	elapsed := time.Now().Sub(start)
+	check.Info = fmt.Sprintf("%s in %.1fs (%s)", util.FormatSize(total), elapsed.Seconds(), formatRate(total, elapsed))
+	check.OK = check.Error == ""
+	return check
+}
+
+// Writes and syncs a file on the download path to measure the disk speed.
+func checkDiskWrite() *Check {
+	check := &Check{
+		Name: "Disk write speed",
+		Hint: "The download path is too slow to keep up, choose a local disk in the Pulsar settings.",
+	}
+	file, err := ioutil.TempFile(config.Get().DownloadPath, ".pulsar-speedtest")
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	chunk := make([]byte, diskTestChunkSize)
+	start := time.Now()
+	for written := 0; written < diskTestSize; written += len(chunk) {
+		if _, err := file.Write(chunk); err != nil {
+			check.Error = err.Error()
+			return check
+		}
+	}
+	if err := file.Sync(); err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	elapsed := time.Now().Sub(start)
+	check.Info = formatRate(diskTestSize, elapsed)
+	check.OK = true
+	return check
+}
+
+func BandwidthTest() *Report {
+	mirrors := config.Get().BandwidthTestMirrors
+	if len(mirrors) == 0 {
+		mirrors = DefaultBandwidthMirrors
+	}
+
+	log.Info("Running bandwidth test...")
+	report := &Report{
+		Time:   time.Now(),
+		OK:     true,
+		Checks: make([]*Check, 0, len(mirrors)+1),
+	}
+	for _, mirror := range mirrors {
+		report.Checks = append(report.Checks, checkMirror(mirror))
+	}
+	report.Checks = append(report.Checks, checkDiskWrite())
+	for _, check := range report.Checks {
+		log.Info("%s: %s %s", check.Name, check.Info, check.Error)
+		if check.OK == false {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+// Show displays all the checks of the report.
+func (report *Report) Show(title string) {
+	lines := make([]string, 0, len(report.Checks))
+	for _, check := range report.Checks {
+		if check.OK {
+			lines = append(lines, fmt.Sprintf("%s: %s", check.Name, check.Info))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: FAILED %s", check.Name, check.Error))
+		}
+	}
+	xbmc.ListDialog(title, lines...)
+}
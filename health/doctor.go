@@ -0,0 +1,162 @@
+package health
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/providers"
+)
+
+const (
+	dhtBootstrapWait = 10 * time.Second
+	dhtMinNodes      = 10
+)
+
+var dhtRouters = []string{
+	"router.bittorrent.com:6881",
+	"router.utorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+}
+
+func checkTracker(trackerUrl string) *Check {
+	check := &Check{
+		Name: "Tracker " + trackerUrl,
+		Hint: "UDP trackers are unreachable, your ISP or firewall may be blocking them. Try enabling the proxy for trackers.",
+	}
+	tracker, err := bittorrent.NewTracker(trackerUrl)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	defer tracker.Close()
+	start := time.Now()
+	if err := tracker.Connect(); err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Info = fmt.Sprintf("answered in %dms", time.Now().Sub(start)/time.Millisecond)
+	check.OK = true
+	return check
+}
+
+func checkDHTRouters() *Check {
+	check := &Check{
+		Name: "DHT bootstrap routers resolve",
+		Hint: "DNS resolution is failing, check your network settings.",
+	}
+	resolved := make([]string, 0)
+	for _, router := range dhtRouters {
+		host, _, _ := net.SplitHostPort(router)
+		if _, err := net.LookupHost(host); err == nil {
+			resolved = append(resolved, host)
+		}
+	}
+	check.Info = strings.Join(resolved, ", ")
+	if len(resolved) == 0 {
+		check.Error = "none of the DHT routers could be resolved"
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+func checkDHT(btService *bittorrent.BTService) *Check {
+	check := &Check{
+		Name: "DHT is bootstrapped",
+		Hint: "DHT is unable to find nodes, UDP traffic is probably blocked by your firewall or router.",
+	}
+	deadline := time.Now().Add(dhtBootstrapWait)
+	nodes := btService.DHTNodes()
+	for nodes < dhtMinNodes && time.Now().Before(deadline) {
+		time.Sleep(1 * time.Second)
+		nodes = btService.DHTNodes()
+	}
+	check.Info = fmt.Sprintf("%d nodes", nodes)
+	if nodes < dhtMinNodes {
+		check.Error = fmt.Sprintf("only %d DHT nodes", nodes)
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+func checkIncomingConnections(btService *bittorrent.BTService) *Check {
+	check := &Check{
+		Name: "BitTorrent port is open",
+		Hint: "No peer was able to connect to us. Forward the BitTorrent port on your router or enable UPnP.",
+	}
+	port, _ := btService.ListenPort()
+	check.Info = fmt.Sprintf("port %d", port)
+	if btService.HasIncomingConnections() == false {
+		check.Error = "no incoming connections yet"
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+func checkEncryption(btService *bittorrent.BTService) *Check {
+	check := &Check{
+		Name: "Encryption negotiation with peers",
+		Hint: "Encryption is forced but peers don't negotiate it, which drastically reduces the number of peers.",
+	}
+	total, encrypted := btService.EncryptionStats()
+	check.Info = fmt.Sprintf("%d of %d connected peers use encryption", encrypted, total)
+	if total > 0 && encrypted == 0 && btService.Privacy().Encryption == bittorrent.EncryptionForced {
+		check.Error = "no connected peer negotiated encryption"
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+// Doctor looks for the usual causes of "0 peers": blocked trackers, DHT
+// unable to bootstrap, closed port and failing encryption.
+func Doctor(btService *bittorrent.BTService) *Report {
+	log.Info("Running connectivity doctor...")
+	report := &Report{
+		Time:   time.Now(),
+		OK:     true,
+		Checks: make([]*Check, 0),
+	}
+
+	trackerChecks := make(chan *Check)
+	for _, trackerUrl := range providers.DefaultTrackers {
+		go func(trackerUrl string) {
+			trackerChecks <- checkTracker(trackerUrl)
+		}(trackerUrl)
+	}
+	for _ = range providers.DefaultTrackers {
+		report.Checks = append(report.Checks, <-trackerChecks)
+	}
+
+	report.Checks = append(report.Checks,
+		checkDHTRouters(),
+		checkDHT(btService),
+		checkIncomingConnections(btService),
+		checkEncryption(btService),
+	)
+	for _, check := range report.Checks {
+		if check.OK == false {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+// String renders the report for humans, with hints for what failed.
+func (report *Report) String() string {
+	lines := make([]string, 0, len(report.Checks))
+	for _, check := range report.Checks {
+		if check.OK {
+			lines = append(lines, fmt.Sprintf("[OK]   %s (%s)", check.Name, check.Info))
+		} else {
+			lines = append(lines, fmt.Sprintf("[FAIL] %s: %s", check.Name, check.Error))
+			lines = append(lines, "       "+check.Hint)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,220 @@
+package health
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/clock"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+var log = logging.MustGetLogger("health")
+
+type Check struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Info  string `json:"info,omitempty"`
+	Error string `json:"error,omitempty"`
+	Hint  string `json:"hint,omitempty"`
+}
+
+type Report struct {
+	Time   time.Time `json:"time"`
+	OK     bool      `json:"ok"`
+	Checks []*Check  `json:"checks"`
+}
+
+var (
+	reportLock = sync.RWMutex{}
+	lastReport *Report
+	listenErr  error
+)
+
+// SetListenError records the result of binding the HTTP server port, which
+// is done by main before the self-test runs.
+func SetListenError(err error) {
+	reportLock.Lock()
+	defer reportLock.Unlock()
+	listenErr = err
+}
+
+func checkDownloadPath() *Check {
+	check := &Check{
+		Name: "Download path is writable",
+		Hint: "Choose a download path with enough free space that Kodi can write to in the Pulsar settings.",
+	}
+	downloadPath := config.Get().DownloadPath
+	check.Info = downloadPath
+	file, err := ioutil.TempFile(downloadPath, ".pulsar")
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	file.Close()
+	os.Remove(file.Name())
+	check.OK = true
+	return check
+}
+
+func checkListenPort() *Check {
+	reportLock.RLock()
+	defer reportLock.RUnlock()
+
+	check := &Check{
+		Name: fmt.Sprintf("HTTP port %d is bindable", config.ListenPort),
+		Hint: "Another program (or another Pulsar) is using this port, close it and restart Kodi.",
+	}
+	if listenErr != nil {
+		check.Error = listenErr.Error()
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+func checkBitTorrent(btService *bittorrent.BTService) *Check {
+	check := &Check{
+		Name: "BitTorrent session is listening",
+		Info: "libtorrent " + bittorrent.LibtorrentVersion(),
+		Hint: "Make sure the BitTorrent listen port range in the settings is free and allowed by your firewall.",
+	}
+	if port, listening := btService.ListenPort(); listening == false {
+		check.Error = "session is not listening on any port"
+	} else {
+		check.Info += fmt.Sprintf(", port %d", port)
+		check.OK = true
+	}
+	return check
+}
+
+func checkTMDB() *Check {
+	check := &Check{
+		Name: "TMDB is reachable",
+		Hint: "Check your internet connection and DNS settings, TMDB is needed to browse movies and shows.",
+	}
+	if err := tmdb.Ping(); err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+func checkXBMC() *Check {
+	check := &Check{
+		Name: "XBMC JSON-RPC is reachable",
+		Hint: "Enable \"Allow programs on this system to control XBMC\" in the XBMC network settings.",
+	}
+	if err := xbmc.Ping(); err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+func RunSelfTest(btService *bittorrent.BTService) *Report {
+	log.Info("Running self-test...")
+	report := &Report{
+		Time: time.Now(),
+		OK:   true,
+		Checks: []*Check{
+			checkDownloadPath(),
+			checkListenPort(),
+			checkBitTorrent(btService),
+			checkTMDB(),
+			checkXBMC(),
+		},
+	}
+	for _, check := range report.Checks {
+		if check.OK {
+			log.Info("[OK] %s %s", check.Name, check.Info)
+		} else {
+			log.Error("[FAIL] %s: %s", check.Name, check.Error)
+			report.OK = false
+		}
+	}
+
+	reportLock.Lock()
+	lastReport = report
+	reportLock.Unlock()
+
+	return report
+}
+
+func LastReport() *Report {
+	reportLock.RLock()
+	defer reportLock.RUnlock()
+	return lastReport
+}
+
+// NotifyFailures shows the failed checks along with how to fix them.
+func (report *Report) NotifyFailures() {
+	if report.OK {
+		return
+	}
+	lines := make([]string, 0)
+	for _, check := range report.Checks {
+		if check.OK == false {
+			lines = append(lines, fmt.Sprintf("%s: %s", check.Name, check.Error), check.Hint)
+		}
+	}
+	xbmc.ListDialog("Pulsar self-test failed", lines...)
+}
+
+// NotifyFirstFailure shows the first failed check in a notification, for
+// when there's no waiting on a dialog.
+func (report *Report) NotifyFirstFailure() {
+	for _, check := range report.Checks {
+		if check.OK == false {
+			xbmc.Notify("Pulsar self-test failed", fmt.Sprintf("%s: %s", check.Name, check.Error), config.AddonIcon())
+			return
+		}
+	}
+}
+
+const readyTMDBCacheTime = 1 * time.Minute
+
+var (
+	tmdbCheckLock = sync.Mutex{}
+	tmdbCheck     *Check
+	tmdbCheckTime time.Time
+)
+
+// cachedTMDBCheck avoids hitting TMDB every time a supervisor polls us.
+func cachedTMDBCheck() *Check {
+	tmdbCheckLock.Lock()
+	defer tmdbCheckLock.Unlock()
+	if tmdbCheck == nil || clock.Now().After(tmdbCheckTime.Add(readyTMDBCacheTime)) {
+		tmdbCheck = checkTMDB()
+		tmdbCheckTime = clock.Now()
+	}
+	return tmdbCheck
+}
+
+// Ready tells whether the daemon is able to serve streams: the BitTorrent
+// session is started, the storage is mounted and metadata are reachable.
+func Ready(btService *bittorrent.BTService) *Report {
+	report := &Report{
+		Time: time.Now(),
+		OK:   true,
+		Checks: []*Check{
+			checkBitTorrent(btService),
+			checkDownloadPath(),
+			cachedTMDBCheck(),
+		},
+	}
+	for _, check := range report.Checks {
+		if check.OK == false {
+			report.OK = false
+		}
+	}
+	return report
+}
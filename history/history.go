@@ -0,0 +1,180 @@
+// Package history keeps what was streamed, per profile, with the torrent and
+// the position reached, so playback can resume from the same torrent.
+package history
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/profiles"
+	"github.com/steeve/pulsar/store"
+)
+
+const (
+	bucketName = "history"
+	entriesKey = "entries"
+	maxEntries = 200
+)
+
+// Entry is a streamed movie or episode. Its key is the title key of the
+// failed torrents, as "movie.tt0133093" or "episode.121361.1.1".
+type Entry struct {
+	Key       string    `json:"key"`
+	Title     string    `json:"title"`
+	IMDBId    string    `json:"imdb_id,omitempty"`
+	TVDBId    int       `json:"tvdb_id,omitempty"`
+	Season    int       `json:"season,omitempty"`
+	Episode   int       `json:"episode,omitempty"`
+	URI       string    `json:"uri"`
+	InfoHash  string    `json:"info_hash"`
+	FileIndex int       `json:"file_index"`
+	Position  int       `json:"position"` // seconds
+	Duration  int       `json:"duration"`
+	Watched   bool      `json:"watched"`
+	Updated   time.Time `json:"updated"`
+	Year      int       `json:"year,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+
+	// by the user, see notes.go
+	Note string   `json:"note,omitempty"`
+	Tags []string `json:"tags,omitempty"`
+}
+
+// InProgress tells whether playback stopped before the end.
+func (entry *Entry) InProgress() bool {
+	return entry.Watched == false && entry.Position > 0
+}
+
+type byUpdated []*Entry
+
+func (a byUpdated) Len() int           { return len(a) }
+func (a byUpdated) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byUpdated) Less(i, j int) bool { return a[i].Updated.After(a[j].Updated) }
+
+var lock = sync.Mutex{}
+
+func load() []*Entry {
+	entries := make([]*Entry, 0)
+	profiles.Current().Bucket(bucketName).Get(entriesKey, &entries)
+	return entries
+}
+
+func save(entries []*Entry) error {
+	return profiles.Current().Bucket(bucketName).Set(entriesKey, entries, store.FOREVER)
+}
+
+// List returns the history, most recently played first.
+func List() []*Entry {
+	lock.Lock()
+	entries := load()
+	lock.Unlock()
+
+	sort.Sort(byUpdated(entries))
+	return entries
+}
+
+// ContinueWatching returns the items left unfinished, most recent first.
+func ContinueWatching() []*Entry {
+	entries := make([]*Entry, 0)
+	for _, entry := range List() {
+		if entry.InProgress() {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func Get(key string) *Entry {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, entry := range load() {
+		if entry.Key == key {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Save adds or replaces the entry of the same key, forgetting the oldest
+// entries past maxEntries. The note and tags of the replaced entry are
+// kept, they only change with SetNote and SetTags.
+func Save(entry *Entry) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	entry.Updated = time.Now()
+	entries := []*Entry{entry}
+	for _, existing := range load() {
+		if existing.Key != entry.Key {
+			entries = append(entries, existing)
+		} else {
+			entry.Note, entry.Tags = existing.Note, existing.Tags
+		}
+	}
+	sort.Sort(byUpdated(entries))
+	if len(entries) > maxEntries {
+		entries = entries[:maxEntries]
+	}
+	return save(entries)
+}
+
+// SetWatched marks the entry as watched or not, as when another player or
+// Trakt says so, without bumping it.
+func SetWatched(key string, watched bool) error {
+	return annotate(key, func(entry *Entry) {
+		entry.Watched = watched
+	})
+}
+
+func Remove(key string) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	entries := load()
+	kept := make([]*Entry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Key != key {
+			kept = append(kept, entry)
+		}
+	}
+	return save(kept)
+}
+
+// Merge adds the entries of unknown keys as they are, as when importing
+// them from another addon, and returns how many it added.
+func Merge(imported []*Entry) (int, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	entries := load()
+	known := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		known[entry.Key] = true
+	}
+	added := 0
+	for _, entry := range imported {
+		if entry.Key == "" || known[entry.Key] {
+			continue
+		}
+		known[entry.Key] = true
+		entries = append(entries, entry)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	sort.Sort(byUpdated(entries))
+	if len(entries) > maxEntries {
+		entries = entries[:maxEntries]
+	}
+	return added, save(entries)
+}
+
+func Clear() error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return save(make([]*Entry, 0))
+}
@@ -0,0 +1,114 @@
+package history
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+var ErrNoEntry = errors.New("no such history entry")
+
+// annotate changes the entry of the key in place, without bumping it.
+func annotate(key string, change func(entry *Entry)) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	entries := load()
+	for _, entry := range entries {
+		if entry.Key == key {
+			change(entry)
+			return save(entries)
+		}
+	}
+	return ErrNoEntry
+}
+
+// SetNote sets the note of the entry, an empty one removing it.
+func SetNote(key string, note string) error {
+	return annotate(key, func(entry *Entry) {
+		entry.Note = strings.TrimSpace(note)
+	})
+}
+
+// SetTags replaces the tags of the entry. They're lowercased, and each kept
+// once.
+func SetTags(key string, tags []string) error {
+	return annotate(key, func(entry *Entry) {
+		entry.Tags = normalizeTags(tags)
+	})
+}
+
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+// ParseTags splits tags typed as "kids, rewatch".
+func ParseTags(text string) []string {
+	return normalizeTags(strings.Split(text, ","))
+}
+
+func (entry *Entry) HasTag(tag string) bool {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for _, t := range entry.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches tells whether all the words of the query are in the title, the
+// note or the tags of the entry.
+func (entry *Entry) Matches(query string) bool {
+	text := strings.ToLower(entry.Title + " " + entry.Note + " " + strings.Join(entry.Tags, " "))
+	for _, word := range strings.Fields(strings.ToLower(query)) {
+		if strings.Contains(text, word) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// Search returns the entries matching the query and having the tag, either
+// being optional, most recently played first.
+func Search(query string, tag string) []*Entry {
+	entries := make([]*Entry, 0)
+	for _, entry := range List() {
+		if tag != "" && entry.HasTag(tag) == false {
+			continue
+		}
+		if entry.Matches(query) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Tags lists the tags in use, sorted.
+func Tags() []string {
+	seen := make(map[string]bool)
+	tags := make([]string, 0)
+	for _, entry := range List() {
+		for _, tag := range entry.Tags {
+			if seen[tag] == false {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/util"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// serveHTTPS serves the same routes over TLS, for the LAN. Until the user
+// trusts the certificate in XBMC, the LAN keeps streaming over HTTP, and
+// XBMC always does over local HTTP.
+func serveHTTPS() {
+	cert, err := util.HTTPSCertificate()
+	if err != nil {
+		log.Error("Unable to get an HTTPS certificate: %s", err)
+		return
+	}
+	fingerprint := util.ServeHTTPS(cert)
+
+	http.Handle("/https/certificate", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", "attachment; filename=pulsar.pem")
+		w.Write(util.CertificatePEM(cert))
+	}))
+
+	listener, err := tls.Listen("tcp", ":"+strconv.Itoa(config.HTTPSListenPort), &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		log.Error("Unable to listen on HTTPS port %d: %s", config.HTTPSListenPort, err)
+		return
+	}
+	log.Info("Serving HTTPS on port %d, certificate SHA-256 %s", config.HTTPSListenPort, fingerprint)
+
+	if util.UseHTTPS() == false {
+		go askHTTPSTrust(fingerprint)
+	}
+	http.Serve(listener, util.Forwarded(http.DefaultServeMux))
+}
+
+func askHTTPSTrust(fingerprint string) {
+	choice := xbmc.ListDialog(fmt.Sprintf("Trust the Pulsar certificate %s?", fingerprint),
+		"Trust it, stream over HTTPS",
+		"Not now, stream over HTTP")
+	if choice != 0 {
+		return
+	}
+	if err := util.TrustHTTPS(fingerprint); err != nil {
+		log.Error("Unable to trust the certificate: %s", err)
+		return
+	}
+	xbmc.Notify("Pulsar", "Streams to the LAN now go through HTTPS", config.AddonIcon())
+}
@@ -0,0 +1,66 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/steeve/pulsar/history"
+	"github.com/steeve/pulsar/profiles"
+	"github.com/steeve/pulsar/store"
+	"github.com/steeve/pulsar/watchlist"
+)
+
+// addonProfile is the folder of the profile of the same name in the addon,
+// else of its default one.
+func addonProfile(path string) string {
+	profile := filepath.Join(path, "profiles", profiles.Current().Name)
+	if _, err := os.Stat(profile); err == nil {
+		return profile
+	}
+	return filepath.Join(path, "profiles", profiles.DefaultProfile)
+}
+
+// readItem reads an item of a bucket of the addon, without creating the
+// bucket when it has none.
+func readItem(bucket string, key string, value interface{}) error {
+	if _, err := os.Stat(bucket); err != nil {
+		return err
+	}
+	return store.Open(bucket).Get(key, value)
+}
+
+// importAddon merges the history and the watchlist of a fork, from its
+// addon data folder. The torrents of its history are played again as they
+// are from ours.
+func importAddon(path string) *Report {
+	report := &Report{Source: Addon, Path: path}
+	profile := addonProfile(path)
+	if _, err := os.Stat(profile); err != nil {
+		report.fail("no profile in %s", path)
+		return report
+	}
+
+	entries := make([]*history.Entry, 0)
+	if err := readItem(filepath.Join(profile, "history"), "entries", &entries); err != nil && os.IsNotExist(err) == false {
+		report.fail("unable to read the history: %s", err)
+	}
+	added, err := history.Merge(entries)
+	if err != nil {
+		report.fail("unable to save the history: %s", err)
+	}
+	report.History = added
+
+	for _, kind := range []string{watchlist.Movies, watchlist.Shows} {
+		items := make([]*watchlist.Item, 0)
+		if err := readItem(filepath.Join(profile, "watchlist"), kind, &items); err != nil && os.IsNotExist(err) == false {
+			report.fail("unable to read the %s of the watchlist: %s", kind, err)
+			continue
+		}
+		tmdbIds := make([]int, 0, len(items))
+		for _, item := range items {
+			tmdbIds = append(tmdbIds, item.TMDBId)
+		}
+		addToWatchlist(report, kind, tmdbIds)
+	}
+	return report
+}
@@ -0,0 +1,119 @@
+// Package importer brings in what the user had in similar addons: the
+// movies and shows of their .strm libraries, their Trakt lists, and the
+// history and watchlist of the addons keeping their data as Pulsar does,
+// its forks. The databases of the other streamers (SQLite, Bolt) aren't
+// read, their libraries still are.
+package importer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/localsearch"
+	"github.com/steeve/pulsar/store"
+	"github.com/steeve/pulsar/trakt"
+)
+
+var log = logging.MustGetLogger("importer")
+
+// Where the imports come from
+const (
+	Strm  = "strm"
+	Trakt = "trakt"
+	Addon = "addon"
+)
+
+// the addons whose data is laid out as Pulsar's
+var forks = []string{
+	"plugin.video.pulsar",
+	"plugin.video.quasar",
+}
+
+// Report counts what an import added. What was already there isn't
+// counted.
+type Report struct {
+	Source    string   `json:"source"`
+	Path      string   `json:"path,omitempty"`
+	Movies    int      `json:"movies"` // to the library
+	Shows     int      `json:"shows"`
+	Watchlist int      `json:"watchlist"`
+	History   int      `json:"history"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+func (report *Report) fail(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Warning("Importing from %s: %s", report.Source, message)
+	report.Errors = append(report.Errors, message)
+}
+
+func (report *Report) String() string {
+	return fmt.Sprintf("%d movies, %d shows, %d in the watchlist and %d in the history", report.Movies, report.Shows, report.Watchlist, report.History)
+}
+
+// Candidate is a source found to import from.
+type Candidate struct {
+	Source string `json:"source"`
+	Path   string `json:"path,omitempty"`
+	Label  string `json:"label"`
+}
+
+// Detect lists the forks installed next to Pulsar, and Trakt once it's
+// authorized. The .strm libraries can be anywhere, they're imported from
+// a folder given by the user.
+func Detect() []*Candidate {
+	candidates := make([]*Candidate, 0)
+	addonData := filepath.Dir(config.Get().ProfilePath)
+	for _, id := range forks {
+		if id == config.Get().Info.Id {
+			continue
+		}
+		path := filepath.Join(addonData, id)
+		if _, err := os.Stat(filepath.Join(path, "profiles")); err == nil {
+			candidates = append(candidates, &Candidate{Source: Addon, Path: path, Label: "The history and watchlist of " + id})
+		}
+	}
+	if trakt.Authorized() {
+		candidates = append(candidates, &Candidate{Source: Trakt, Label: "Your Trakt watchlist"})
+	}
+	return candidates
+}
+
+// Import imports from the source: path is the folder of a .strm library or
+// of an addon, and for Trakt, the slug of a list to import along with the
+// watchlist.
+func Import(source string, path string) (*Report, error) {
+	var report *Report
+	switch source {
+	case Strm:
+		report = importStrm(path)
+	case Trakt:
+		report = importTrakt(path)
+	case Addon:
+		report = importAddon(path)
+	default:
+		return nil, fmt.Errorf("unknown source %s", source)
+	}
+	log.Info("Imported from %s %s: %s", source, path, report)
+	localsearch.Invalidate()
+	return report, nil
+}
+
+func offeredStore() *store.Bucket {
+	return store.Global("importer")
+}
+
+// Offered tells whether the user was already offered to import from the
+// candidates found at startup, to ask only once.
+func Offered() bool {
+	offered := false
+	offeredStore().Get("offered", &offered)
+	return offered
+}
+
+func SetOffered() error {
+	return offeredStore().Set("offered", true, store.FOREVER)
+}
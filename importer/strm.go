@@ -0,0 +1,125 @@
+package importer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/library"
+)
+
+var (
+	imdbIdPattern = regexp.MustCompile(`tt\d{7,}`)
+	// thetvdb.com URLs of the .nfo, and the parameters of the plugin URLs
+	tvdbIdPattern = regexp.MustCompile(`(?i)(?:thetvdb\.com/.*[?&](?:series)?id=|[?&]tvdb(?:_?id)?=)(\d+)`)
+	episodeName   = regexp.MustCompile(`(?i)s\d+\s*e\d+|\d+x\d+`)
+)
+
+func readText(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// showId is the TVDB id of the show of an episode, from the plugin URL or
+// from the tvshow.nfo of its folder or of the folder above, the seasons
+// having theirs.
+func showId(path string, url string) string {
+	if match := tvdbIdPattern.FindStringSubmatch(url); match != nil {
+		return match[1]
+	}
+	folder := filepath.Dir(path)
+	for i := 0; i < 2; i++ {
+		if match := tvdbIdPattern.FindStringSubmatch(readText(filepath.Join(folder, "tvshow.nfo"))); match != nil {
+			return match[1]
+		}
+		folder = filepath.Dir(folder)
+	}
+	return ""
+}
+
+// movieId is the IMDB id of a movie, from the plugin URL or from its .nfo.
+func movieId(path string, url string) string {
+	if id := imdbIdPattern.FindString(url); id != "" {
+		return id
+	}
+	for _, nfo := range []string{strings.TrimSuffix(path, filepath.Ext(path)) + ".nfo", filepath.Join(filepath.Dir(path), "movie.nfo")} {
+		if id := imdbIdPattern.FindString(readText(nfo)); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// importStrm adds to the library the movies and shows of the .strm files
+// of other addons found under root. Those whose ids can't be found, in the
+// plugin URL or the .nfo files Kodi scrapes, are skipped.
+func importStrm(root string) *Report {
+	report := &Report{Source: Strm, Path: root}
+	if root == "" {
+		report.fail("no folder to import from")
+		return report
+	}
+	ours := "plugin://" + config.Get().Info.Id + "/"
+	movies := make(map[string]bool)
+	shows := make(map[string]bool)
+	skipped := 0
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil && path == root {
+			return err
+		}
+		if err != nil || info.IsDir() || strings.EqualFold(filepath.Ext(path), ".strm") == false {
+			return nil
+		}
+		url := strings.TrimSpace(readText(path))
+		if strings.HasPrefix(url, ours) {
+			return nil
+		}
+		if episodeName.MatchString(filepath.Base(path)) {
+			if id := showId(path, url); id != "" {
+				shows[id] = true
+				return nil
+			}
+		} else if id := movieId(path, url); id != "" {
+			movies[id] = true
+			return nil
+		}
+		skipped++
+		return nil
+	})
+	if err != nil {
+		report.fail("unable to read %s: %s", root, err)
+		return report
+	}
+	if skipped > 0 {
+		report.fail("no ids found for %d files", skipped)
+	}
+
+	for _, title := range library.Movies() {
+		delete(movies, title.IMDBId)
+	}
+	for _, tvdbId := range library.Shows() {
+		delete(shows, tvdbId)
+	}
+	imdbIds := make([]string, 0, len(movies))
+	for id := range movies {
+		imdbIds = append(imdbIds, id)
+	}
+	tvdbIds := make([]string, 0, len(shows))
+	for id := range shows {
+		tvdbIds = append(tvdbIds, id)
+	}
+	if len(imdbIds) == 0 && len(tvdbIds) == 0 {
+		return report
+	}
+	report.Movies, report.Shows, err = library.AddMany(imdbIds, tvdbIds)
+	if err != nil {
+		report.fail("%s", err)
+	}
+	return report
+}
@@ -0,0 +1,48 @@
+package importer
+
+import (
+	"github.com/steeve/pulsar/trakt"
+	"github.com/steeve/pulsar/watchlist"
+)
+
+// addToWatchlist adds the TMDB ids of a kind, counting those it added.
+func addToWatchlist(report *Report, kind string, tmdbIds []int) {
+	for _, tmdbId := range tmdbIds {
+		if watchlist.Contains(kind, tmdbId) {
+			continue
+		}
+		if err := watchlist.Add(kind, tmdbId); err != nil {
+			report.fail("unable to add %d to the watchlist: %s", tmdbId, err)
+			continue
+		}
+		report.Watchlist++
+	}
+}
+
+// importTrakt copies the Trakt watchlist, and the list of the slug if any,
+// in the local watchlist.
+func importTrakt(slug string) *Report {
+	report := &Report{Source: Trakt, Path: slug}
+	if trakt.Authorized() == false {
+		report.fail("Trakt isn't authorized")
+		return report
+	}
+	for _, kind := range []string{trakt.Movies, trakt.Shows} {
+		tmdbIds, err := trakt.Watchlist(kind)
+		if err != nil {
+			report.fail("unable to get the %s of the watchlist: %s", kind, err)
+			continue
+		}
+		addToWatchlist(report, kind, tmdbIds)
+	}
+	if slug != "" {
+		movies, shows, err := trakt.List(slug)
+		if err != nil {
+			report.fail("unable to get the list %s: %s", slug, err)
+			return report
+		}
+		addToWatchlist(report, watchlist.Movies, movies)
+		addToWatchlist(report, watchlist.Shows, shows)
+	}
+	return report
+}
@@ -0,0 +1,137 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/store"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+	"github.com/steeve/pulsar/watchlist"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const (
+	downloadedKey = "downloaded"
+	// episodes aired longer ago are left to the user
+	defaultAutoDownloadDays = 7
+)
+
+func autoDownloadWindow() time.Duration {
+	days := config.Get().AutoDownloadDays
+	if days <= 0 {
+		days = defaultAutoDownloadDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func episodeKey(show *tvdb.Show, episode *tvdb.Episode) string {
+	return fmt.Sprintf("%d.S%02dE%02d", show.Id, episode.SeasonNumber, episode.EpisodeNumber)
+}
+
+// The episodes queued already, so they aren't downloaded twice.
+func downloadedEpisodes() map[string]time.Time {
+	downloaded := make(map[string]time.Time)
+	bucket().Get(downloadedKey, &downloaded)
+	return downloaded
+}
+
+// recentlyAired tells whether the episode aired within the window.
+func recentlyAired(show *tvdb.Show, episode *tvdb.Episode, window time.Duration) bool {
+	return show.HasAired(episode) && time.Since(show.AirDate(episode)) < window
+}
+
+// bestEpisodeTorrent is the best result that is just the episode, so that
+// a season pack doesn't download a whole season behind the user's back.
+func bestEpisodeTorrent(torrents []*bittorrent.Torrent) *bittorrent.Torrent {
+	sort.Sort(sort.Reverse(providers.ByQuality(torrents)))
+	for _, torrent := range torrents {
+		if torrent.SeasonPack == false {
+			return torrent
+		}
+	}
+	return nil
+}
+
+// trackedShows are the TVDB ids of the subscribed shows and of those of the
+// watchlist, whose new episodes are looked for alike.
+func trackedShows(language string) []string {
+	shows := Shows()
+	tracked := make(map[string]bool)
+	for _, tvdbId := range shows {
+		tracked[tvdbId] = true
+	}
+	for _, show := range tmdb.GetShows(watchlist.Ids(watchlist.Shows), language) {
+		if show == nil || show.ExternalIDs == nil || show.ExternalIDs.TVDBID == 0 {
+			continue
+		}
+		if tvdbId := strconv.Itoa(show.ExternalIDs.TVDBID); tracked[tvdbId] == false {
+			tracked[tvdbId] = true
+			shows = append(shows, tvdbId)
+		}
+	}
+	return shows
+}
+
+// DownloadNewEpisodes queues the best result of the recently aired episodes
+// of the subscribed and watchlist shows, when enabled, but those aired
+// during the vacation. Episodes without results are tried again on the next
+// run, until they're out of the window.
+func DownloadNewEpisodes(btService *bittorrent.BTService) error {
+	if config.Get().AutoDownloadEpisodes == false {
+		return nil
+	}
+	window := autoDownloadWindow()
+	language := config.Get().Language
+	searchers := providers.GetEpisodeSearchers()
+	downloaded := downloadedEpisodes()
+	vacation := GetVacation()
+	queued := 0
+	for _, tvdbId := range trackedShows(language) {
+		show, err := tvdb.FetchShow(tvdbId, language)
+		if err != nil {
+			log.Warning("Unable to get show %s: %s", tvdbId, err)
+			continue
+		}
+		for _, season := range show.Seasons {
+			for _, episode := range season.Episodes {
+				key := episodeKey(show, episode)
+				if _, exists := downloaded[key]; exists || recentlyAired(show, episode, window) == false {
+					continue
+				}
+				if vacation.covers(show.AirDate(episode)) {
+					continue
+				}
+				torrent := bestEpisodeTorrent(providers.SearchEpisode(context.Background(), searchers, show, episode))
+				if torrent == nil {
+					log.Info("No links found yet for %s S%02dE%02d", show.SeriesName, episode.SeasonNumber, episode.EpisodeNumber)
+					continue
+				}
+				if err := btService.AddDownload(torrent.Magnet()); err != nil {
+					log.Error("Unable to download %s: %s", torrent.Name, err)
+					continue
+				}
+				log.Info("Queued %s for %s S%02dE%02d", torrent.Name, show.SeriesName, episode.SeasonNumber, episode.EpisodeNumber)
+				downloaded[key] = time.Now()
+				queued++
+			}
+		}
+	}
+	if queued == 0 {
+		return nil
+	}
+	// forget what's out of the window, it won't be looked at again
+	for key, queuedAt := range downloaded {
+		if time.Since(queuedAt) > 2*window {
+			delete(downloaded, key)
+		}
+	}
+	xbmc.Notify("Pulsar", fmt.Sprintf("Queued %d new episodes", queued), config.AddonIcon())
+	return bucket().Set(downloadedKey, downloaded, store.FOREVER)
+}
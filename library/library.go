@@ -0,0 +1,314 @@
+// Package library adds movies and shows to the XBMC library as .strm files
+// playing through Pulsar, and keeps the subscribed shows up to date.
+package library
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/profiles"
+	"github.com/steeve/pulsar/store"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const (
+	libraryBucket = "library"
+	showsKey      = "shows"
+
+	moviesFolder = "Movies"
+	showsFolder  = "Shows"
+)
+
+var log = logging.MustGetLogger("library")
+
+var (
+	ErrNoLibraryPath = errors.New("no library folder is set")
+	ErrNotFound      = errors.New("not found")
+)
+
+// characters that aren't allowed in file names on some platforms
+var unsafeChars = regexp.MustCompile(`[<>:"/\\|?*]+`)
+
+func safeName(name string) string {
+	return strings.TrimRight(strings.TrimSpace(unsafeChars.ReplaceAllString(name, "")), ".")
+}
+
+// Path is the library folder of the current profile, else the one from the
+// settings.
+func Path() string {
+	if path := profiles.Current().LibraryPath; path != "" {
+		return path
+	}
+	return config.Get().LibraryPath
+}
+
+func pluginURL(format string, args ...interface{}) string {
+	return "plugin://" + config.Get().Info.Id + fmt.Sprintf(format, args...)
+}
+
+// writeStrm doesn't rewrite existing files, and tells whether it wrote one.
+func writeStrm(path string, url string) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	if err := ioutil.WriteFile(path, []byte(url), 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeMovie writes the .strm of the movie, along with an .nfo pointing the
+// scraper to IMDB, and tells whether it wasn't in the library yet.
+func writeMovie(libraryPath string, imdbId string) (bool, error) {
+	movie := tmdb.GetMovieFromIMDB(imdbId, config.Get().Language)
+	if movie == nil {
+		return false, ErrNotFound
+	}
+	name := safeName(movie.Title)
+	if year := strings.Split(movie.ReleaseDate, "-")[0]; year != "" {
+		name = fmt.Sprintf("%s (%s)", name, year)
+	}
+	moviePath := filepath.Join(libraryPath, moviesFolder, name, name)
+	added, err := writeStrm(moviePath+".strm", pluginURL("/movie/%s/play", imdbId))
+	if err != nil || added == false {
+		return false, err
+	}
+	nfo := fmt.Sprintf("http://www.imdb.com/title/%s/", imdbId)
+	if err := ioutil.WriteFile(moviePath+".nfo", []byte(nfo), 0644); err != nil {
+		return false, err
+	}
+	log.Info("Added %s to the library", name)
+	return true, nil
+}
+
+// AddMovie writes the movie in the library and scans it.
+func AddMovie(imdbId string) error {
+	libraryPath := Path()
+	if libraryPath == "" {
+		return ErrNoLibraryPath
+	}
+	added, err := writeMovie(libraryPath, imdbId)
+	if err != nil || added == false {
+		return err
+	}
+	return xbmc.VideoLibraryScan()
+}
+
+// AddMovies writes the movies in the library, scanning it once, and returns
+// how many were added.
+func AddMovies(imdbIds []string) (int, error) {
+	libraryPath := Path()
+	if libraryPath == "" {
+		return 0, ErrNoLibraryPath
+	}
+	count := 0
+	for _, imdbId := range imdbIds {
+		added, err := writeMovie(libraryPath, imdbId)
+		if err != nil {
+			log.Error("Unable to add %s to the library: %s", imdbId, err)
+			continue
+		}
+		if added {
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, nil
+	}
+	return count, xbmc.VideoLibraryScan()
+}
+
+func bucket() *store.Bucket {
+	return profiles.Current().Bucket(libraryBucket)
+}
+
+// Shows returns the TVDB ids of the shows subscribed by the current profile.
+func Shows() []string {
+	shows := make([]string, 0)
+	bucket().Get(showsKey, &shows)
+	return shows
+}
+
+func setSubscribedShows(shows []string) error {
+	return bucket().Set(showsKey, shows, store.FOREVER)
+}
+
+// addShowEpisodes writes the .strm of the aired episodes that aren't in the
+// library yet, and returns how many it added.
+func addShowEpisodes(libraryPath string, show *tvdb.Show) (int, error) {
+	name := safeName(show.SeriesName)
+	showPath := filepath.Join(libraryPath, showsFolder, name)
+	if err := os.MkdirAll(showPath, 0755); err != nil {
+		return 0, err
+	}
+	nfo := fmt.Sprintf("http://thetvdb.com/?tab=series&id=%d", show.Id)
+	if err := ioutil.WriteFile(filepath.Join(showPath, "tvshow.nfo"), []byte(nfo), 0644); err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, season := range show.Seasons {
+		// specials are rarely found
+		if season.Season == 0 {
+			continue
+		}
+		for _, episode := range season.Episodes {
+			if show.HasAired(episode) == false {
+				continue
+			}
+			episodeName := fmt.Sprintf("%s S%02dE%02d.strm", name, episode.SeasonNumber, episode.EpisodeNumber)
+			episodePath := filepath.Join(showPath, fmt.Sprintf("Season %d", episode.SeasonNumber), episodeName)
+			url := pluginURL("/show/%d/season/%d/episode/%d/play", show.Id, episode.SeasonNumber, episode.EpisodeNumber)
+			wrote, err := writeStrm(episodePath, url)
+			if err != nil {
+				return added, err
+			}
+			if wrote {
+				added++
+			}
+		}
+	}
+	return added, nil
+}
+
+// subscribeShow subscribes to the show and writes its aired episodes,
+// returning how many it added.
+func subscribeShow(libraryPath string, tvdbId string) (int, error) {
+	show, err := tvdb.FetchShow(tvdbId, config.Get().Language)
+	if err != nil {
+		return 0, err
+	}
+	shows := Shows()
+	subscribed := false
+	for _, id := range shows {
+		if id == tvdbId {
+			subscribed = true
+			break
+		}
+	}
+	if subscribed == false {
+		if err := setSubscribedShows(append(shows, tvdbId)); err != nil {
+			return 0, err
+		}
+	}
+	added, err := addShowEpisodes(libraryPath, show)
+	if err != nil {
+		return added, err
+	}
+	log.Info("Added %d episodes of %s to the library", added, show.SeriesName)
+	return added, nil
+}
+
+// AddShow subscribes to the show, writes its aired episodes and scans the
+// library.
+func AddShow(tvdbId string) error {
+	libraryPath := Path()
+	if libraryPath == "" {
+		return ErrNoLibraryPath
+	}
+	added, err := subscribeShow(libraryPath, tvdbId)
+	if err != nil {
+		return err
+	}
+	if added > 0 {
+		return xbmc.VideoLibraryScan()
+	}
+	return nil
+}
+
+// AddMany adds the movies and the shows, scanning the library once, and
+// returns how many of each weren't in it yet. It goes on past the titles it
+// can't add, returning the last error.
+func AddMany(imdbIds []string, tvdbIds []string) (int, int, error) {
+	libraryPath := Path()
+	if libraryPath == "" {
+		return 0, 0, ErrNoLibraryPath
+	}
+	var lastErr error
+	movies := 0
+	for _, imdbId := range imdbIds {
+		added, err := writeMovie(libraryPath, imdbId)
+		if err != nil {
+			log.Warning("Unable to add the movie %s: %s", imdbId, err)
+			lastErr = err
+		} else if added {
+			movies++
+		}
+	}
+	shows := 0
+	for _, tvdbId := range tvdbIds {
+		added, err := subscribeShow(libraryPath, tvdbId)
+		if err != nil {
+			log.Warning("Unable to add the show %s: %s", tvdbId, err)
+			lastErr = err
+		} else if added > 0 {
+			shows++
+		}
+	}
+	if movies > 0 || shows > 0 {
+		if err := xbmc.VideoLibraryScan(); err != nil {
+			lastErr = err
+		}
+	}
+	return movies, shows, lastErr
+}
+
+// RemoveShow stops adding the new episodes of the show. The files already
+// written are left alone, as they may be in use.
+func RemoveShow(tvdbId string) error {
+	shows := Shows()
+	kept := make([]string, 0, len(shows))
+	for _, id := range shows {
+		if id != tvdbId {
+			kept = append(kept, id)
+		}
+	}
+	if len(kept) == len(shows) {
+		return ErrNotFound
+	}
+	return setSubscribedShows(kept)
+}
+
+// Update adds the episodes aired since the last update of the subscribed
+// shows, and scans the library if there are any.
+func Update() error {
+	libraryPath := Path()
+	if libraryPath == "" {
+		return nil
+	}
+	language := config.Get().Language
+	added := 0
+	for _, tvdbId := range Shows() {
+		// not the cached show, which would miss the new episodes
+		show, err := tvdb.FetchShow(tvdbId, language)
+		if err != nil {
+			log.Warning("Unable to update show %s: %s", tvdbId, err)
+			continue
+		}
+		count, err := addShowEpisodes(libraryPath, show)
+		if err != nil {
+			log.Error("Unable to add the episodes of %s: %s", show.SeriesName, err)
+			continue
+		}
+		if count > 0 {
+			log.Info("Added %d new episodes of %s", count, show.SeriesName)
+			xbmc.Notify("Pulsar", fmt.Sprintf("New episode of %s available", show.SeriesName), config.AddonIcon())
+		}
+		added += count
+	}
+	if added == 0 {
+		return nil
+	}
+	return xbmc.VideoLibraryScan()
+}
@@ -0,0 +1,89 @@
+package library
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+	"github.com/steeve/pulsar/watchlist"
+)
+
+// refreshPause spaces the refreshes out, TMDB's rate limiter only smooths
+// the bursts and TVDB has none.
+const refreshPause = 2 * time.Second
+
+var imdbIdRegexp = regexp.MustCompile(`tt\d+`)
+
+// libraryMovies returns the IMDB ids of the movies in the library, from the
+// .nfo written along their .strm.
+func libraryMovies(libraryPath string) []string {
+	ids := make([]string, 0)
+	nfos, _ := filepath.Glob(filepath.Join(libraryPath, moviesFolder, "*", "*.nfo"))
+	for _, nfo := range nfos {
+		data, err := ioutil.ReadFile(nfo)
+		if err != nil {
+			continue
+		}
+		if id := imdbIdRegexp.Find(data); id != nil {
+			ids = append(ids, string(id))
+		}
+	}
+	return ids
+}
+
+// RefreshMetadata fetches again the metadata and artwork of everything that
+// is followed: the subscribed shows, the watchlist and the library movies.
+func RefreshMetadata() error {
+	language := config.Get().Language
+	refreshes := make([]func() bool, 0)
+	for _, tvdbId := range Shows() {
+		tvdbId := tvdbId
+		refreshes = append(refreshes, func() bool {
+			_, err := tvdb.RefreshShow(tvdbId, language)
+			if err != nil {
+				log.Warning("Unable to refresh show %s: %s", tvdbId, err)
+			}
+			return err == nil
+		})
+	}
+	for _, tmdbId := range watchlist.Ids(watchlist.Shows) {
+		tmdbId := tmdbId
+		refreshes = append(refreshes, func() bool {
+			return tmdb.RefreshShow(tmdbId, language) != nil
+		})
+	}
+	movies := make([]string, 0)
+	for _, tmdbId := range watchlist.Ids(watchlist.Movies) {
+		movies = append(movies, strconv.Itoa(tmdbId))
+	}
+	if libraryPath := Path(); libraryPath != "" {
+		movies = append(movies, libraryMovies(libraryPath)...)
+	}
+	for _, movieId := range movies {
+		movieId := movieId
+		refreshes = append(refreshes, func() bool {
+			return tmdb.RefreshMovie(movieId, language) != nil
+		})
+	}
+
+	failed := 0
+	for i, refresh := range refreshes {
+		if i > 0 {
+			time.Sleep(refreshPause)
+		}
+		if refresh() == false {
+			failed++
+		}
+	}
+	log.Info("Refreshed the metadata of %d items", len(refreshes)-failed)
+	if failed > 0 {
+		return fmt.Errorf("unable to refresh %d of %d items", failed, len(refreshes))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package library
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+var (
+	folderYear = regexp.MustCompile(`^(.+) \((\d{4})\)$`)
+	nfoIMDBId  = regexp.MustCompile(`tt\d+`)
+	nfoTVDBId  = regexp.MustCompile(`[?&]id=(\d+)`)
+)
+
+// Title is a movie or a show of the library, as found in its folder.
+type Title struct {
+	Name   string
+	Year   int
+	IMDBId string
+	TVDBId int
+}
+
+func readNFO(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func titleFolders(folder string) []string {
+	libraryPath := Path()
+	if libraryPath == "" {
+		return nil
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(libraryPath, folder))
+	if err != nil {
+		return nil
+	}
+	paths := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.IsDir() {
+			paths = append(paths, filepath.Join(libraryPath, folder, info.Name()))
+		}
+	}
+	return paths
+}
+
+// Movies lists the movies written in the library, which may have been
+// added by another profile sharing the folder.
+func Movies() []*Title {
+	titles := make([]*Title, 0)
+	for _, path := range titleFolders(moviesFolder) {
+		title := &Title{Name: filepath.Base(path)}
+		if match := folderYear.FindStringSubmatch(title.Name); match != nil {
+			title.Name = match[1]
+			title.Year, _ = strconv.Atoi(match[2])
+		}
+		nfo := readNFO(filepath.Join(path, filepath.Base(path)+".nfo"))
+		title.IMDBId = nfoIMDBId.FindString(nfo)
+		titles = append(titles, title)
+	}
+	return titles
+}
+
+// ShowTitles lists the shows written in the library.
+func ShowTitles() []*Title {
+	titles := make([]*Title, 0)
+	for _, path := range titleFolders(showsFolder) {
+		title := &Title{Name: filepath.Base(path)}
+		if match := nfoTVDBId.FindStringSubmatch(readNFO(filepath.Join(path, "tvshow.nfo"))); match != nil {
+			title.TVDBId, _ = strconv.Atoi(match[1])
+		}
+		titles = append(titles, title)
+	}
+	return titles
+}
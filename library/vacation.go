@@ -0,0 +1,96 @@
+package library
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/store"
+)
+
+const vacationKey = "vacation"
+
+var ErrBadVacation = errors.New("the vacation must end after it starts")
+
+// Vacation is a date range during which the library is frozen: no new
+// episodes queued, no library or metadata updates, browsing and streaming
+// working as usual. The episodes aired meanwhile aren't queued after it
+// either, so that returning users don't find dozens of downloads.
+type Vacation struct {
+	From  time.Time `json:"from"`
+	Until time.Time `json:"until"`
+}
+
+var vacationLock = sync.Mutex{}
+
+func vacationStore() *store.Bucket {
+	return store.Global("vacation")
+}
+
+// GetVacation returns the current, next or last vacation, nil if there was
+// none.
+func GetVacation() *Vacation {
+	vacationLock.Lock()
+	defer vacationLock.Unlock()
+	vacation := &Vacation{}
+	if err := vacationStore().Get(vacationKey, vacation); err != nil || vacation.Until.IsZero() {
+		return nil
+	}
+	return vacation
+}
+
+func SetVacation(from time.Time, until time.Time) (*Vacation, error) {
+	if until.After(from) == false {
+		return nil, ErrBadVacation
+	}
+	vacationLock.Lock()
+	defer vacationLock.Unlock()
+	vacation := &Vacation{From: from, Until: until}
+	log.Info("Vacation from %s until %s", from.Format(time.RFC1123), until.Format(time.RFC1123))
+	return vacation, vacationStore().Set(vacationKey, vacation, store.FOREVER)
+}
+
+// EndVacation ends the current vacation now, or cancels the next one.
+func EndVacation() error {
+	vacation := GetVacation()
+	if vacation == nil {
+		return nil
+	}
+	now := time.Now()
+	if vacation.From.After(now) {
+		vacationLock.Lock()
+		defer vacationLock.Unlock()
+		return vacationStore().Delete(vacationKey)
+	}
+	if vacation.Until.After(now) {
+		_, err := SetVacation(vacation.From, now)
+		return err
+	}
+	return nil
+}
+
+// Active tells whether the vacation is going on.
+func (vacation *Vacation) Active() bool {
+	now := time.Now()
+	return vacation != nil && now.Before(vacation.From) == false && now.Before(vacation.Until)
+}
+
+func (vacation *Vacation) covers(t time.Time) bool {
+	return vacation != nil && t.Before(vacation.From) == false && t.Before(vacation.Until)
+}
+
+func OnVacation() bool {
+	return GetVacation().Active()
+}
+
+// UnlessOnVacation wraps a scheduled task so that it's skipped during the
+// vacation.
+func UnlessOnVacation(name string, run func() error) func() error {
+	return func() error {
+		if OnVacation() {
+			log.Info("On vacation, skipping %s", name)
+			return nil
+		}
+		return run()
+	}
+}
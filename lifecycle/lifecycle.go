@@ -0,0 +1,145 @@
+// Package lifecycle stops the daemon cleanly, whatever asks for it: a
+// signal, Kodi quitting, or the daemon itself once it's done.
+package lifecycle
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// Reason tells the shutdown hooks whether we'll be back soon.
+type Reason int
+
+const (
+	// Restart is for signals and new daemons taking over: players may
+	// reconnect to the streams once we're back.
+	Restart Reason = iota
+	// Quit is for Kodi exiting, and nothing left to do: nobody will play
+	// the streams again.
+	Quit
+)
+
+func (reason Reason) String() string {
+	if reason == Quit {
+		return "quit"
+	}
+	return "restart"
+}
+
+// How long a hook can take before the shutdown goes on without it.
+const hookTimeout = 20 * time.Second
+
+var log = logging.MustGetLogger("lifecycle")
+
+type hook struct {
+	name string
+	fn   func(reason Reason) error
+}
+
+var (
+	lock  = sync.Mutex{}
+	hooks = make([]*hook, 0)
+	once  = sync.Once{}
+)
+
+// OnShutdown registers fn to run on shutdown. Hooks run in the reverse
+// order of their registration, so what started first stops last.
+func OnShutdown(name string, fn func(reason Reason) error) {
+	lock.Lock()
+	defer lock.Unlock()
+	hooks = append(hooks, &hook{name: name, fn: fn})
+}
+
+// Shutdown runs the hooks, then exits. Only the first call does, the
+// others wait for it.
+func Shutdown(reason Reason) {
+	exit(reason, 0)
+}
+
+// Fail is Shutdown when the daemon can't go on, exiting with 1 so that what
+// started it can tell.
+func Fail(reason Reason) {
+	exit(reason, 1)
+}
+
+func exit(reason Reason, code int) {
+	once.Do(func() {
+		log.Info("Shutting down (%s)...", reason)
+		lock.Lock()
+		registered := make([]*hook, len(hooks))
+		copy(registered, hooks)
+		lock.Unlock()
+		for i := len(registered) - 1; i >= 0; i-- {
+			registered[i].run(reason)
+		}
+		log.Info("Bye bye")
+		os.Exit(code)
+	})
+}
+
+func (h *hook) run(reason Reason) {
+	done := make(chan error, 1)
+	go func() {
+		done <- h.fn(reason)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Error("Unable to stop %s: %s", h.name, err)
+		}
+	case <-time.After(hookTimeout):
+		log.Error("Timed out stopping %s", h.name)
+	}
+}
+
+// HandleSignals shuts down on SIGINT and SIGTERM, which is how upgrades
+// and service managers stop us.
+func HandleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Warning("Received %s", sig)
+		Shutdown(Restart)
+	}()
+}
+
+// WatchParent shuts down when Kodi, our parent, is gone.
+func WatchParent() {
+	go func() {
+		for {
+			if os.Getppid() == 1 {
+				log.Warning("Parent shut down. Me too.")
+				Shutdown(Quit)
+				return
+			}
+			time.Sleep(1 * time.Second)
+		}
+	}()
+}
+
+// RemoveTempFiles removes the temporary files that writes interrupted by a
+// crash left under root, and returns how many there were.
+func RemoveTempFiles(root string) int {
+	removed := 0
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || strings.HasSuffix(path, ".tmp") == false {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			removed++
+		}
+		return nil
+	})
+	if removed > 0 {
+		log.Info("Removed %d temporary files left by a crash", removed)
+	}
+	return removed
+}
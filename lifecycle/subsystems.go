@@ -0,0 +1,88 @@
+package lifecycle
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrUnknownSubsystem = errors.New("unknown subsystem")
+
+// Subsystem is a part of the daemon that can be stopped and started again
+// on its own, as the torrent session, with the API and the rest running.
+type Subsystem struct {
+	Name    string    `json:"name"`
+	Running bool      `json:"running"`
+	Since   time.Time `json:"since"`
+
+	start func() error
+	stop  func() error
+}
+
+var subsystemsLock = sync.Mutex{}
+var subsystems = map[string]*Subsystem{}
+
+// RegisterSubsystem adds a subsystem, running already.
+func RegisterSubsystem(name string, start func() error, stop func() error) {
+	subsystemsLock.Lock()
+	defer subsystemsLock.Unlock()
+	subsystems[name] = &Subsystem{
+		Name:    name,
+		Running: true,
+		Since:   time.Now(),
+		start:   start,
+		stop:    stop,
+	}
+}
+
+// StartSubsystem starts the subsystem, if it's stopped.
+func StartSubsystem(name string) error {
+	return switchSubsystem(name, true)
+}
+
+// StopSubsystem stops the subsystem, if it's running.
+func StopSubsystem(name string) error {
+	return switchSubsystem(name, false)
+}
+
+func switchSubsystem(name string, running bool) error {
+	subsystemsLock.Lock()
+	defer subsystemsLock.Unlock()
+	subsystem, exists := subsystems[name]
+	if exists == false {
+		return ErrUnknownSubsystem
+	}
+	if subsystem.Running == running {
+		return nil
+	}
+	fn, action := subsystem.stop, "Stopping"
+	if running {
+		fn, action = subsystem.start, "Starting"
+	}
+	log.Info("%s %s...", action, name)
+	if err := fn(); err != nil {
+		return err
+	}
+	subsystem.Running = running
+	subsystem.Since = time.Now()
+	return nil
+}
+
+type subsystemsByName []Subsystem
+
+func (a subsystemsByName) Len() int           { return len(a) }
+func (a subsystemsByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a subsystemsByName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+// Subsystems returns a snapshot of the subsystems.
+func Subsystems() []Subsystem {
+	subsystemsLock.Lock()
+	defer subsystemsLock.Unlock()
+	list := make([]Subsystem, 0, len(subsystems))
+	for _, subsystem := range subsystems {
+		list = append(list, *subsystem)
+	}
+	sort.Sort(subsystemsByName(list))
+	return list
+}
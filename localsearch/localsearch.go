@@ -0,0 +1,200 @@
+// Package localsearch finds the titles Pulsar already knows of, in the
+// history, the library and the watchlist, as they're typed: the words of
+// their titles, years, tags and providers are indexed in memory, so
+// searching never goes to TMDB.
+package localsearch
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/clock"
+	"github.com/steeve/pulsar/profiles"
+)
+
+var log = logging.MustGetLogger("localsearch")
+
+// Where the documents come from
+const (
+	History   = "history"
+	Library   = "library"
+	Watchlist = "watchlist"
+)
+
+// Kinds of documents
+const (
+	Movie   = "movie"
+	Show    = "show"
+	Episode = "episode"
+)
+
+// the sources are read again after this, in case they changed elsewhere
+const indexTTL = time.Minute
+
+// Document is a title that's found by the words of its fields.
+type Document struct {
+	Source   string   `json:"source"`
+	Kind     string   `json:"kind"`
+	Title    string   `json:"title"`
+	Year     int      `json:"year,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Provider string   `json:"provider,omitempty"`
+
+	Key     string `json:"key,omitempty"` // of the history entry
+	IMDBId  string `json:"imdb_id,omitempty"`
+	TMDBId  int    `json:"tmdb_id,omitempty"`
+	TVDBId  int    `json:"tvdb_id,omitempty"`
+	Season  int    `json:"season,omitempty"`
+	Episode int    `json:"episode,omitempty"`
+}
+
+func (doc *Document) words() []string {
+	text := doc.Title + " " + strings.Join(doc.Tags, " ") + " " + doc.Provider
+	if doc.Year > 0 {
+		text += " " + strconv.Itoa(doc.Year)
+	}
+	return tokenize(text)
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsLetter(r) == false && unicode.IsDigit(r) == false
+	})
+}
+
+// Index maps the words to the documents having them.
+type Index struct {
+	docs     []*Document
+	postings map[string][]int
+	words    []string // sorted, for prefixes
+	built    time.Time
+	profile  string
+}
+
+// Build indexes the documents, which keep their order when they rank the
+// same.
+func Build(docs []*Document) *Index {
+	index := &Index{
+		docs:     docs,
+		postings: make(map[string][]int),
+		built:    clock.Now(),
+	}
+	for i, doc := range docs {
+		seen := make(map[string]bool)
+		for _, word := range doc.words() {
+			if seen[word] {
+				continue
+			}
+			seen[word] = true
+			index.postings[word] = append(index.postings[word], i)
+		}
+	}
+	index.words = make([]string, 0, len(index.postings))
+	for word := range index.postings {
+		index.words = append(index.words, word)
+	}
+	sort.Strings(index.words)
+	return index
+}
+
+// matches scores the documents having a word starting with prefix, those
+// having it whole scoring higher.
+func (index *Index) matches(prefix string) map[int]int {
+	scores := make(map[int]int)
+	for i := sort.SearchStrings(index.words, prefix); i < len(index.words) && strings.HasPrefix(index.words[i], prefix); i++ {
+		word := index.words[i]
+		score := 1
+		if word == prefix {
+			score = 2
+		}
+		for _, doc := range index.postings[word] {
+			if score > scores[doc] {
+				scores[doc] = score
+			}
+		}
+	}
+	return scores
+}
+
+type result struct {
+	doc   int
+	score int
+}
+
+type byScore []*result
+
+func (a byScore) Len() int      { return len(a) }
+func (a byScore) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byScore) Less(i, j int) bool {
+	if a[i].score != a[j].score {
+		return a[i].score > a[j].score
+	}
+	return a[i].doc < a[j].doc
+}
+
+// Search returns up to limit documents having all the words of the query,
+// the last one being perhaps still typed, so any word matches as a prefix.
+func (index *Index) Search(query string, limit int) []*Document {
+	found := make([]*Document, 0)
+	var total map[int]int
+	for _, word := range tokenize(query) {
+		scores := index.matches(word)
+		if total == nil {
+			total = scores
+			continue
+		}
+		for doc := range total {
+			if score, ok := scores[doc]; ok {
+				total[doc] += score
+			} else {
+				delete(total, doc)
+			}
+		}
+	}
+	results := make([]*result, 0, len(total))
+	for doc, score := range total {
+		results = append(results, &result{doc, score})
+	}
+	sort.Sort(byScore(results))
+	for _, result := range results {
+		if limit > 0 && len(found) == limit {
+			break
+		}
+		found = append(found, index.docs[result.doc])
+	}
+	return found
+}
+
+var (
+	lock    = sync.Mutex{}
+	current *Index
+)
+
+// Search looks in the index of the current profile, building it first if
+// it's stale.
+func Search(query string, limit int) []*Document {
+	lock.Lock()
+	profile := profiles.Current().Name
+	if current == nil || current.profile != profile || clock.Now().Sub(current.built) > indexTTL {
+		start := clock.Now()
+		current = Build(collect())
+		current.profile = profile
+		log.Debug("Indexed %d titles in %s", len(current.docs), clock.Now().Sub(start))
+	}
+	index := current
+	lock.Unlock()
+	return index.Search(query, limit)
+}
+
+// Invalidate has the next search build the index again, once the history,
+// the library or the watchlist changed.
+func Invalidate() {
+	lock.Lock()
+	defer lock.Unlock()
+	current = nil
+}
@@ -0,0 +1,91 @@
+package localsearch
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/history"
+	"github.com/steeve/pulsar/library"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/watchlist"
+)
+
+func releaseYear(date string) int {
+	year, _ := strconv.Atoi(strings.Split(date, "-")[0])
+	return year
+}
+
+// collect reads the titles of the history, most recent first, then of the
+// library and of the watchlist. Those of the watchlist only come from the
+// metadata cache, they're left out until the watchlist was browsed.
+func collect() []*Document {
+	docs := make([]*Document, 0)
+	for _, entry := range history.List() {
+		doc := &Document{
+			Source:   History,
+			Kind:     Movie,
+			Title:    entry.Title,
+			Year:     entry.Year,
+			Tags:     entry.Tags,
+			Provider: entry.Provider,
+			Key:      entry.Key,
+			IMDBId:   entry.IMDBId,
+			TVDBId:   entry.TVDBId,
+			Season:   entry.Season,
+			Episode:  entry.Episode,
+		}
+		if entry.TVDBId != 0 {
+			doc.Kind = Episode
+		}
+		docs = append(docs, doc)
+	}
+
+	for _, movie := range library.Movies() {
+		docs = append(docs, &Document{
+			Source: Library,
+			Kind:   Movie,
+			Title:  movie.Name,
+			Year:   movie.Year,
+			IMDBId: movie.IMDBId,
+		})
+	}
+	for _, show := range library.ShowTitles() {
+		docs = append(docs, &Document{
+			Source: Library,
+			Kind:   Show,
+			Title:  show.Name,
+			TVDBId: show.TVDBId,
+		})
+	}
+
+	language := config.Get().Language
+	for _, tmdbId := range watchlist.Ids(watchlist.Movies) {
+		if movie := tmdb.CachedMovie(tmdbId, language); movie != nil {
+			docs = append(docs, &Document{
+				Source: Watchlist,
+				Kind:   Movie,
+				Title:  movie.Title,
+				Year:   releaseYear(movie.ReleaseDate),
+				TMDBId: tmdbId,
+				IMDBId: movie.IMDBId,
+			})
+		}
+	}
+	for _, tmdbId := range watchlist.Ids(watchlist.Shows) {
+		if show := tmdb.CachedShow(tmdbId, language); show != nil {
+			doc := &Document{
+				Source: Watchlist,
+				Kind:   Show,
+				Title:  show.LocalizedName(),
+				Year:   releaseYear(show.ReleaseDate),
+				TMDBId: tmdbId,
+			}
+			if show.ExternalIDs != nil {
+				doc.TVDBId = show.ExternalIDs.TVDBID
+			}
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
@@ -0,0 +1,226 @@
+// Package logs is where the records of all the loggers go: to stdout as
+// before, to a rotated file of JSON lines in the profile, and to the recent
+// records the API and the log viewer show. Each subsystem logs from its own
+// level, set with the log_levels setting.
+package logs
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+// The subsystems the loggers belong to
+const (
+	Providers  = "providers"
+	BitTorrent = "bittorrent"
+	XBMC       = "xbmc"
+	API        = "api"
+	Other      = "other"
+)
+
+var Subsystems = []string{Providers, BitTorrent, XBMC, API, Other}
+
+var modules = map[string]string{
+	"linkssearch": Providers,
+	"metasearch":  Providers,
+	"btservice":   BitTorrent,
+	"btplayer":    BitTorrent,
+	"libtorrent":  BitTorrent,
+	"piececache":  BitTorrent,
+	"torrentfs":   BitTorrent,
+	"xbmc":        XBMC,
+	"api":         API,
+	"shows":       API,
+	"repository":  API,
+}
+
+// how many records are kept for the viewer
+const recentRecords = 2000
+
+// Entry is a record as the file and the API have it.
+type Entry struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	Subsystem string    `json:"subsystem"`
+	Module    string    `json:"module"`
+	Message   string    `json:"message"`
+}
+
+func subsystemOf(module string) string {
+	if subsystem, ok := modules[module]; ok {
+		return subsystem
+	}
+	// one logger per provider addon
+	if strings.HasPrefix(module, "AddonSearcher") {
+		return Providers
+	}
+	return Other
+}
+
+// Backend passes on the records of the enabled levels, and keeps them.
+type Backend struct {
+	backend logging.Backend
+
+	lock   sync.Mutex
+	levels map[string]logging.Level
+	recent []*Entry
+	seq    uint64
+	file   *rotatingFile
+}
+
+var defaultBackend *Backend
+
+// NewBackend wraps backend, usually stdout. It's the one Recent and the
+// settings go by.
+func NewBackend(backend logging.Backend) *Backend {
+	defaultBackend = &Backend{
+		backend: backend,
+		levels:  make(map[string]logging.Level),
+		recent:  make([]*Entry, 0, recentRecords),
+	}
+	return defaultBackend
+}
+
+func (b *Backend) enabled(subsystem string, level logging.Level) bool {
+	threshold, ok := b.levels[subsystem]
+	if ok == false {
+		threshold = logging.DEBUG
+	}
+	return level <= threshold
+}
+
+func (b *Backend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	subsystem := subsystemOf(rec.Module)
+
+	b.lock.Lock()
+	if b.enabled(subsystem, level) == false {
+		b.lock.Unlock()
+		return nil
+	}
+	b.seq++
+	entry := &Entry{
+		Seq:       b.seq,
+		Time:      rec.Time,
+		Level:     level.String(),
+		Subsystem: subsystem,
+		Module:    rec.Module,
+		Message:   rec.Message(),
+	}
+	if len(b.recent) == recentRecords {
+		copy(b.recent, b.recent[1:])
+		b.recent = b.recent[:recentRecords-1]
+	}
+	b.recent = append(b.recent, entry)
+	if b.file != nil {
+		if line, err := json.Marshal(entry); err == nil {
+			b.file.WriteLine(line)
+		}
+	}
+	b.lock.Unlock()
+
+	return b.backend.Log(level, calldepth+1, rec)
+}
+
+// SetLevels sets the level of the subsystems, such as
+// {"providers": "warning"}. The others log everything.
+func SetLevels(levels map[string]string) {
+	if defaultBackend == nil {
+		return
+	}
+	parsed := make(map[string]logging.Level)
+	for subsystem, name := range levels {
+		if level, err := logging.LogLevel(name); err == nil {
+			parsed[strings.ToLower(subsystem)] = level
+		}
+	}
+	defaultBackend.lock.Lock()
+	defaultBackend.levels = parsed
+	defaultBackend.lock.Unlock()
+}
+
+// Levels are the levels of all the subsystems.
+func Levels() map[string]string {
+	levels := make(map[string]string)
+	if defaultBackend == nil {
+		return levels
+	}
+	defaultBackend.lock.Lock()
+	defer defaultBackend.lock.Unlock()
+	for _, subsystem := range Subsystems {
+		level, ok := defaultBackend.levels[subsystem]
+		if ok == false {
+			level = logging.DEBUG
+		}
+		levels[subsystem] = level.String()
+	}
+	return levels
+}
+
+// Open starts writing the records in dir, rotating the file.
+func Open(dir string) error {
+	if defaultBackend == nil {
+		return nil
+	}
+	file, err := openRotatingFile(dir)
+	if err != nil {
+		return err
+	}
+	defaultBackend.lock.Lock()
+	defaultBackend.file = file
+	defaultBackend.lock.Unlock()
+	return nil
+}
+
+// Recent returns up to count of the records kept after since, the most
+// recent last. An empty subsystem is all of them, and level the least
+// severe level wanted.
+func Recent(since uint64, subsystem string, level string, count int) []*Entry {
+	entries := make([]*Entry, 0)
+	if defaultBackend == nil {
+		return entries
+	}
+	threshold := logging.DEBUG
+	if parsed, err := logging.LogLevel(level); err == nil {
+		threshold = parsed
+	}
+	defaultBackend.lock.Lock()
+	defer defaultBackend.lock.Unlock()
+	for i := len(defaultBackend.recent) - 1; i >= 0 && (count <= 0 || len(entries) < count); i-- {
+		entry := defaultBackend.recent[i]
+		if entry.Seq <= since {
+			break
+		}
+		if subsystem != "" && entry.Subsystem != subsystem {
+			continue
+		}
+		if entryLevel, err := logging.LogLevel(entry.Level); err == nil && entryLevel > threshold {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+type loggerWriter struct {
+	logger *logging.Logger
+}
+
+func (w *loggerWriter) Write(p []byte) (int, error) {
+	w.logger.Info("%s", strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// Writer turns the lines written into INFO records of logger, for the
+// standard library's log.
+func Writer(logger *logging.Logger) io.Writer {
+	return &loggerWriter{logger}
+}
@@ -0,0 +1,70 @@
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	logFileName = "pulsar.log"
+	// the file is rotated past this, keeping the last rotatedFiles ones
+	maxLogFileSize = 5 * 1024 * 1024
+	rotatedFiles   = 3
+)
+
+type rotatingFile struct {
+	path string
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(dir string) (*rotatingFile, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	rf := &rotatingFile{path: filepath.Join(dir, logFileName)}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file, rf.size = file, info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	rf.file.Close()
+	for i := rotatedFiles - 1; i > 0; i-- {
+		os.Rename(rf.path+"."+strconv.Itoa(i), rf.path+"."+strconv.Itoa(i+1))
+	}
+	os.Rename(rf.path, rf.path+".1")
+	return rf.open()
+}
+
+// WriteLine appends the line, rotating the file first if it's full. Must
+// be called with the backend lock held.
+func (rf *rotatingFile) WriteLine(line []byte) {
+	if rf.file == nil {
+		return
+	}
+	if rf.size+int64(len(line))+1 > maxLogFileSize {
+		if err := rf.rotate(); err != nil {
+			rf.file = nil
+			return
+		}
+	}
+	n, _ := rf.file.Write(append(line, '\n'))
+	rf.size += int64(n)
+}
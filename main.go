@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	stdlog "log"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -12,8 +15,18 @@ import (
 	"github.com/op/go-logging"
 	"github.com/steeve/pulsar/api"
 	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/cache"
+	"github.com/steeve/pulsar/clock"
 	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/health"
+	"github.com/steeve/pulsar/library"
+	"github.com/steeve/pulsar/lifecycle"
+	"github.com/steeve/pulsar/logs"
+	_ "github.com/steeve/pulsar/metasearch"
+	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/scheduler"
 	"github.com/steeve/pulsar/util"
+	"github.com/steeve/pulsar/watched"
 	"github.com/steeve/pulsar/xbmc"
 )
 
@@ -34,13 +47,96 @@ func ensureSingleInstance() {
 	http.Head(fmt.Sprintf("http://localhost:%d/shutdown", config.ListenPort))
 }
 
+// instanceId tells the instances sharing a download path apart, the same
+// across restarts.
+func instanceId(conf *config.Configuration) string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%s", hostname, conf.ProfilePath)
+}
+
 func makeBTConfiguration(conf *config.Configuration) *bittorrent.BTConfiguration {
 	btConfig := &bittorrent.BTConfiguration{
 		LowerListenPort: conf.BTListenPortMin,
 		UpperListenPort: conf.BTListenPortMax,
 		DownloadPath:    conf.DownloadPath,
+		StagingPath:     conf.StagingPath,
+		SlowStorage:     conf.SlowStorage,
+		PieceCachePath:  filepath.Join(conf.ProfilePath, "piececache"),
+		PieceCacheSize:  conf.PieceCacheSize,
 		MaxUploadRate:   conf.UploadRateLimit,
 		MaxDownloadRate: conf.DownloadRateLimit,
+
+		DeadlineWindow:         time.Duration(conf.DeadlineWindow) * time.Second,
+		DeadlineAggressiveness: conf.DeadlineAggressiveness,
+		EndgameDuplicates:      conf.EndgameDuplicates,
+
+		UploadAutoTune: conf.UploadAutoTune,
+		AfterDownloads: conf.AfterDownloads,
+
+		QueuePath:          filepath.Join(conf.ProfilePath, "downloads.json"),
+		MaxActiveDownloads: conf.MaxActiveDownloads,
+		QueueDownloadRate:  conf.QueueDownloadRate,
+
+		ResumePath: filepath.Join(conf.ProfilePath, "resume"),
+
+		DHTBootstrapNodes: conf.DHTBootstrapNodes,
+		MetadataTimeout:   time.Duration(conf.MetadataTimeout) * time.Second,
+
+		RateSchedule:           conf.RateSchedule,
+		MaxTorrentDownloadRate: conf.TorrentDownloadRateLimit,
+		MaxTorrentUploadRate:   conf.TorrentUploadRateLimit,
+
+		BindInterface: conf.BindInterface,
+		KillSwitch:    conf.KillSwitch,
+
+		AnonymousMode: conf.AnonymousMode,
+
+		KeptPath:     filepath.Join(conf.ProfilePath, "kept.json"),
+		MaxCacheSize: conf.MaxCacheSize,
+
+		SeedRatio: conf.SeedRatio,
+		SeedTime:  time.Duration(conf.SeedTime) * time.Minute,
+
+		MemoryBuffer:     conf.MemoryBuffer,
+		MemoryPath:       bittorrent.DefaultMemoryPath(),
+		MemoryBufferSize: conf.MemoryBufferSize,
+
+		SharedDownloads: conf.SharedDownloadPath,
+		InstanceId:      instanceId(conf),
+
+		QuarantinePath: filepath.Join(conf.ProfilePath, "quarantine.json"),
+
+		ClientIdentity:         conf.ClientIdentity,
+		SourceClientIdentities: conf.SourceClientIdentities,
+
+		CPUCores: conf.CPUCores,
+	}
+
+	switch conf.Encryption {
+	case config.EncryptionEnabled:
+		btConfig.Encryption = bittorrent.EncryptionEnabled
+	case config.EncryptionDisabled:
+		btConfig.Encryption = bittorrent.EncryptionDisabled
+	default:
+		btConfig.Encryption = bittorrent.EncryptionForced
+	}
+	switch conf.CPULimits {
+	case config.CPULimitsOn:
+		btConfig.CPULimits = bittorrent.CPULimitsOn
+	case config.CPULimitsOff:
+		btConfig.CPULimits = bittorrent.CPULimitsOff
+	default:
+		btConfig.CPULimits = bittorrent.CPULimitsAuto
+	}
+	switch conf.PeerTransport {
+	case config.PeerTransportMixed:
+		btConfig.Transport = bittorrent.TransportMixed
+	case config.PeerTransportTCPOnly:
+		btConfig.Transport = bittorrent.TransportTCPOnly
+	case config.PeerTransportUTPOnly:
+		btConfig.Transport = bittorrent.TransportUTPOnly
+	default:
+		btConfig.Transport = bittorrent.TransportPreferTCP
 	}
 
 	if conf.SocksEnabled == true {
@@ -51,17 +147,57 @@ func makeBTConfiguration(conf *config.Configuration) *bittorrent.BTConfiguration
 			Username: conf.SocksLogin,
 			Password: conf.SocksPassword,
 		}
+		if conf.ProxyType == config.ProxyTypeHTTP {
+			btConfig.Proxy.Type = bittorrent.ProxyTypeSocksHTTPPassword
+		}
+		switch conf.SocksTraffic {
+		case config.SocksTrafficTrackersOnly:
+			btConfig.Proxy.Traffic = bittorrent.ProxyTrackers
+		case config.SocksTrafficPeersOnly:
+			btConfig.Proxy.Traffic = bittorrent.ProxyPeers | bittorrent.ProxyDHT | bittorrent.ProxyWebSeeds
+		default:
+			btConfig.Proxy.Traffic = bittorrent.ProxyAll
+		}
 	}
 
 	return btConfig
 }
 
+// registerSubsystems lists what can be stopped from the API while the rest
+// keeps running.
+func registerSubsystems(btService *bittorrent.BTService) {
+	lifecycle.RegisterSubsystem("bittorrent", btService.Unsuspend, func() error {
+		btService.Suspend()
+		return nil
+	})
+	lifecycle.RegisterSubsystem("episode_downloads", func() error {
+		return scheduler.Resume("episode_downloads")
+	}, func() error {
+		return scheduler.Pause("episode_downloads")
+	})
+	lifecycle.RegisterSubsystem("library_updater", func() error {
+		return scheduler.Resume("library_refresh", "metadata_refresh")
+	}, func() error {
+		return scheduler.Pause("library_refresh", "metadata_refresh")
+	})
+	lifecycle.RegisterSubsystem("providers", func() error {
+		providers.StartSearchers()
+		return nil
+	}, func() error {
+		providers.StopSearchers()
+		return nil
+	})
+}
+
 func main() {
 	// Make sure we are properly multithreaded.
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
 	logging.SetFormatter(logging.MustStringFormatter("%{time:2006-01-02 15:04:05}  %{level:.4s}  %{module:-15s}  %{message}"))
-	logging.SetBackend(logging.NewLogBackend(os.Stdout, "", 0))
+	logging.SetBackend(logs.NewBackend(logging.NewLogBackend(os.Stdout, "", 0)))
+	// the api package logs with the standard library
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(logs.Writer(logging.MustGetLogger("api")))
 
 	for _, line := range strings.Split(PulsarLogo, "\n") {
 		log.Info(line)
@@ -69,49 +205,143 @@ func main() {
 	log.Info("Version: %s Git: %s Go: %s", util.Version, util.GitCommit, runtime.Version())
 
 	conf := config.Reload()
+	logs.SetLevels(conf.LogLevels)
+	if err := logs.Open(filepath.Join(conf.ProfilePath, "logs")); err != nil {
+		log.Error("Unable to write the logs in the profile: %s", err)
+	}
+	clock.Start(filepath.Join(conf.ProfilePath, "clock"))
+	lifecycle.OnShutdown("clock", func(lifecycle.Reason) error {
+		clock.Stop()
+		return nil
+	})
 
 	ensureSingleInstance()
 	Migrate()
+	// what a crash interrupted
+	lifecycle.RemoveTempFiles(conf.ProfilePath)
 
 	xbmc.CloseAllDialogs()
+	xbmc.SetTextOnly(conf.TextOnlyDialogs)
 
 	log.Info("Addon: %s v%s", conf.Info.Id, conf.Info.Version)
 
+	util.InstallHostRules()
+	util.InstallTLS()
 	btService := bittorrent.NewBTService(*makeBTConfiguration(conf))
-
-	var shutdown = func() {
-		log.Info("Shutting down...")
+	lifecycle.OnShutdown("bittorrent", func(reason lifecycle.Reason) error {
+		if reason == lifecycle.Quit {
+			btService.StopStreams()
+		}
 		btService.Close()
-		log.Info("Bye bye")
-		os.Exit(0)
-	}
+		return nil
+	})
+
+	scheduler.Register("cache_cleanup", 6*time.Hour, func() error {
+		_, err := cache.NewFileStore(filepath.Join(config.Get().ProfilePath, "cache")).Purge()
+		return err
+	})
+	scheduler.Register("save_resume_data", 5*time.Minute, btService.SaveResumeData)
+	scheduler.Register("disk_retention", 1*time.Hour, btService.EnforceRetention)
+	scheduler.Register("library_refresh", 24*time.Hour, library.UnlessOnVacation("library_refresh", library.Update))
+	scheduler.Register("metadata_refresh", 7*24*time.Hour, library.UnlessOnVacation("metadata_refresh", library.RefreshMetadata))
+	scheduler.Register("episode_downloads", 1*time.Hour, library.UnlessOnVacation("episode_downloads", func() error {
+		return library.DownloadNewEpisodes(btService)
+	}))
+	scheduler.Register("watched_sync", 6*time.Hour, watched.Sync)
+	scheduler.Register("watchlist_prefetch", 6*time.Hour, api.PrefetchWatchlist)
+	providers.LoadHealth()
+	scheduler.Register("provider_health_decay", 1*time.Hour, func() error {
+		providers.DecayHealth()
+		return providers.SaveHealth()
+	})
+	lifecycle.OnShutdown("provider_health", func(lifecycle.Reason) error {
+		return providers.SaveHealth()
+	})
 
-	var watchParentProcess = func() {
-		for {
-			// did the parent die? shutdown!
-			if os.Getppid() == 1 {
-				log.Warning("Parent shut down. Me too.")
-				go shutdown()
-				break
-			}
-			time.Sleep(1 * time.Second)
+	scheduler.Register("after_downloads", 1*time.Minute, func() error {
+		action := btService.AfterDownloads()
+		if action == bittorrent.AfterDownloadsNothing || btService.DownloadsDone() == false {
+			return nil
 		}
-	}
-	go watchParentProcess()
+		log.Info("All downloads are done, running %s", action)
+		btService.StopSeedingDownloads()
+		switch action {
+		case bittorrent.AfterDownloadsExit:
+			go lifecycle.Shutdown(lifecycle.Quit)
+		case bittorrent.AfterDownloadsSuspend:
+			return xbmc.SystemSuspend()
+		}
+		return nil
+	})
+	scheduler.Start()
+	registerSubsystems(btService)
+	lifecycle.OnShutdown("scheduler", func(lifecycle.Reason) error {
+		scheduler.Stop()
+		return nil
+	})
+
+	lifecycle.WatchParent()
+	lifecycle.HandleSignals()
 
+	api.SubscribeEvents()
 	http.Handle("/", api.Routes(btService))
-	http.Handle("/files/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	http.Handle("/files/", util.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		handler := http.StripPrefix("/files/", http.FileServer(bittorrent.NewTorrentFS(btService, config.Get().DownloadPath)))
 		handler.ServeHTTP(w, r)
-	}))
-	http.Handle("/reload", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		btService.Reconfigure(*makeBTConfiguration(config.Reload()))
-	}))
-	http.Handle("/shutdown", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		shutdown()
-	}))
+	})))
+	var reconfigure = func(conf *config.Configuration) {
+		logs.SetLevels(conf.LogLevels)
+		util.ReloadHostRules()
+		util.ReloadTLS()
+		util.ReloadRegion()
+		xbmc.SetTextOnly(conf.TextOnlyDialogs)
+		if btService.Reconfigure(*makeBTConfiguration(conf)) {
+			xbmc.Notify("Pulsar", "Restart Pulsar for all the new settings to apply", config.AddonIcon())
+		}
+	}
+	// the settings may have changed while we were down
+	config.Refresh(reconfigure)
+
+	// XBMC calls it when the settings changed
+	http.Handle("/reload", util.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config.Refresh(reconfigure)
+	})))
+	// new daemons call it to take over, and the addon with quit=1 when
+	// Kodi exits
+	http.Handle("/shutdown", util.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason := lifecycle.Restart
+		if r.URL.Query().Get("quit") != "" {
+			reason = lifecycle.Quit
+		}
+		lifecycle.Shutdown(reason)
+	})))
+
+	go api.OfferImport()
+
+	if conf.GuestAPIPort != 0 {
+		go serveGuestAPI(conf.GuestAPIPort, btService)
+	}
+
+	listenAddr := ":" + strconv.Itoa(config.ListenPort)
+	if conf.HTTPSEnabled {
+		// plain HTTP stays on the LAN for the Kodis not knowing the certificate
+		go serveHTTPS()
+	}
+	listener, err := net.Listen("tcp", listenAddr)
+	health.SetListenError(err)
+
+	if err != nil {
+		log.Critical("Unable to listen on port %d: %s", config.ListenPort, err)
+		// a dialog would hold the restart until dismissed
+		health.RunSelfTest(btService).NotifyFirstFailure()
+		lifecycle.Fail(lifecycle.Restart)
+	}
+
+	go func() {
+		health.RunSelfTest(btService).NotifyFailures()
+	}()
 
 	xbmc.Notify("Pulsar", "Pulsar daemon has started", config.AddonIcon())
 
-	http.ListenAndServe(":"+strconv.Itoa(config.ListenPort), nil)
+	http.Serve(listener, util.Forwarded(http.DefaultServeMux))
 }
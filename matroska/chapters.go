@@ -0,0 +1,77 @@
+package matroska
+
+import (
+	"io"
+	"time"
+)
+
+const (
+	idChapters         = 0x1043A770
+	idEditionEntry     = 0x45B9
+	idChapterAtom      = 0xB6
+	idChapterTimeStart = 0x91
+	idChapterTimeEnd   = 0x92
+	idChapterDisplay   = 0x80
+	idChapString       = 0x85
+)
+
+type Chapter struct {
+	Title string        `json:"title"`
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end"`
+}
+
+// Reads the chapters of the first edition. Chapters without an end time end
+// where the next one starts.
+func ReadChapters(r io.ReaderAt, fileSize int64) ([]*Chapter, error) {
+	chaptersElement, err := findSegmentElement(r, fileSize, idChapters)
+	if err != nil {
+		return nil, err
+	}
+
+	chapters := make([]*Chapter, 0)
+	err = children(r, chaptersElement, fileSize, func(edition element) (bool, error) {
+		if edition.id != idEditionEntry {
+			return true, nil
+		}
+		return false, children(r, edition, fileSize, func(atom element) (bool, error) {
+			if atom.id != idChapterAtom {
+				return true, nil
+			}
+			chapter := &Chapter{}
+			err := children(r, atom, fileSize, func(field element) (bool, error) {
+				var err error
+				var value uint64
+				switch field.id {
+				case idChapterTimeStart:
+					value, err = readUint(r, field)
+					chapter.Start = time.Duration(value)
+				case idChapterTimeEnd:
+					value, err = readUint(r, field)
+					chapter.End = time.Duration(value)
+				case idChapterDisplay:
+					err = children(r, field, fileSize, func(display element) (bool, error) {
+						var err error
+						if display.id == idChapString && chapter.Title == "" {
+							chapter.Title, err = readString(r, display)
+						}
+						return true, err
+					})
+				}
+				return true, err
+			})
+			chapters = append(chapters, chapter)
+			return true, err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, chapter := range chapters {
+		if chapter.End == 0 && i+1 < len(chapters) {
+			chapter.End = chapters[i+1].Start
+		}
+	}
+	return chapters, nil
+}
@@ -0,0 +1,223 @@
+// Package matroska reads the few bits of Matroska (MKV) metadata Pulsar
+// cares about, such as chapters and tracks, straight from the EBML tree.
+package matroska
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+const (
+	idEBML     = 0x1A45DFA3
+	idSegment  = 0x18538067
+	idSeekHead = 0x114D9B74
+	idSeek     = 0x4DBB
+	idSeekID   = 0x53AB
+	idSeekPos  = 0x53AC
+	idCluster  = 0x1F43B675
+
+	unknownSize = -1
+	unknownVint = 1<<64 - 1
+
+	// what the values we read can weigh, the sizes coming from the file
+	maxUintSize   = 8
+	maxStringSize = 1 << 20
+)
+
+var (
+	ErrNotMatroska = errors.New("not a matroska file")
+	ErrInvalidVint = errors.New("invalid EBML variable size integer")
+	ErrInvalidSize = errors.New("invalid EBML element size")
+)
+
+type element struct {
+	id         uint64
+	size       int64
+	dataOffset int64
+}
+
+func (e element) end() int64 {
+	return e.dataOffset + e.size
+}
+
+// Reads an EBML variable size integer at offset. The length marker is kept
+// for element IDs, as is customary.
+func readVint(r io.ReaderAt, offset int64, keepMarker bool) (uint64, int, error) {
+	first := make([]byte, 1)
+	if _, err := r.ReadAt(first, offset); err != nil {
+		return 0, 0, err
+	}
+	length := 1
+	mask := byte(0x80)
+	for ; length <= 8 && first[0]&mask == 0; length++ {
+		mask >>= 1
+	}
+	if length > 8 {
+		return 0, 0, ErrInvalidVint
+	}
+
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, offset); err != nil {
+		return 0, 0, err
+	}
+	if keepMarker == false {
+		data[0] &= ^mask
+	}
+	value := uint64(0)
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	// all value bits set means the size is unknown
+	if keepMarker == false && value == 1<<(7*uint(length))-1 {
+		return unknownVint, length, nil
+	}
+	return value, length, nil
+}
+
+func readElement(r io.ReaderAt, offset int64) (element, error) {
+	id, idLength, err := readVint(r, offset, true)
+	if err != nil {
+		return element{}, err
+	}
+	size, sizeLength, err := readVint(r, offset+int64(idLength), false)
+	if err != nil {
+		return element{}, err
+	}
+	e := element{
+		id:         id,
+		size:       int64(size),
+		dataOffset: offset + int64(idLength+sizeLength),
+	}
+	if size == unknownVint {
+		e.size = unknownSize
+	} else if size > math.MaxInt64-uint64(e.dataOffset) {
+		return element{}, ErrInvalidSize
+	}
+	return e, nil
+}
+
+// Calls f for each child of parent, until f returns false or an error.
+// Children overflowing their parent or the file are invalid.
+func children(r io.ReaderAt, parent element, fileSize int64, f func(element) (bool, error)) error {
+	end := parent.end()
+	if parent.size == unknownSize || end > fileSize {
+		end = fileSize
+	}
+	for offset := parent.dataOffset; offset < end; {
+		child, err := readElement(r, offset)
+		if err != nil {
+			return err
+		}
+		if child.size != unknownSize && child.end() > end {
+			return ErrInvalidSize
+		}
+		next, err := f(child)
+		if err != nil || next == false || child.size == unknownSize {
+			return err
+		}
+		offset = child.end()
+	}
+	return nil
+}
+
+// Reads the data of e, which must be of a known size up to max.
+func readData(r io.ReaderAt, e element, max int64) ([]byte, error) {
+	if e.size < 0 || e.size > max {
+		return nil, ErrInvalidSize
+	}
+	data := make([]byte, e.size)
+	if _, err := r.ReadAt(data, e.dataOffset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func readUint(r io.ReaderAt, e element) (uint64, error) {
+	data, err := readData(r, e, maxUintSize)
+	if err != nil {
+		return 0, err
+	}
+	value := uint64(0)
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+func readString(r io.ReaderAt, e element) (string, error) {
+	data, err := readData(r, e, maxStringSize)
+	if err != nil {
+		return "", err
+	}
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i]), nil
+		}
+	}
+	return string(data), nil
+}
+
+// Finds the top level element with the given id in the segment, either by
+// scanning up to the first cluster or by following the seek head, which
+// may point at the end of the file.
+func findSegmentElement(r io.ReaderAt, fileSize int64, id uint64) (element, error) {
+	header, err := readElement(r, 0)
+	if err != nil || header.id != idEBML {
+		return element{}, ErrNotMatroska
+	}
+	segment, err := readElement(r, header.end())
+	if err != nil || segment.id != idSegment {
+		return element{}, ErrNotMatroska
+	}
+
+	var found *element
+	seekPosition := int64(-1)
+	err = children(r, segment, fileSize, func(e element) (bool, error) {
+		switch e.id {
+		case id:
+			found = &e
+			return false, nil
+		case idSeekHead:
+			return true, children(r, e, fileSize, func(seek element) (bool, error) {
+				if seek.id != idSeek {
+					return true, nil
+				}
+				seekId := uint64(0)
+				position := uint64(0)
+				hasPosition := false
+				err := children(r, seek, fileSize, func(field element) (bool, error) {
+					var err error
+					switch field.id {
+					case idSeekID:
+						seekId, err = readUint(r, field)
+					case idSeekPos:
+						position, err = readUint(r, field)
+						hasPosition = err == nil
+					}
+					return true, err
+				})
+				if err == nil && hasPosition && seekId == id && position < uint64(fileSize) {
+					seekPosition = int64(position)
+				}
+				return true, err
+			})
+		case idCluster:
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return element{}, err
+	}
+	if found != nil {
+		return *found, nil
+	}
+	if seekPosition >= 0 {
+		e, err := readElement(r, segment.dataOffset+seekPosition)
+		if err == nil && e.id == id {
+			return e, nil
+		}
+	}
+	return element{}, io.EOF
+}
@@ -0,0 +1,87 @@
+package matroska
+
+import (
+	"io"
+)
+
+const (
+	idTracks       = 0x1654AE6B
+	idTrackEntry   = 0xAE
+	idTrackNumber  = 0xD7
+	idTrackType    = 0x83
+	idFlagDefault  = 0x88
+	idFlagForced   = 0x55AA
+	idName         = 0x536E
+	idLanguage     = 0x22B59C
+	idLanguageIETF = 0x22B59D
+)
+
+const (
+	TrackVideo    = 0x01
+	TrackAudio    = 0x02
+	TrackSubtitle = 0x11
+)
+
+type Track struct {
+	Number   int    `json:"number"`
+	Type     int    `json:"type"`
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Default  bool   `json:"default"`
+	Forced   bool   `json:"forced"`
+}
+
+// Reads the tracks in container order. Languages are ISO 639-2 codes,
+// defaulting to "eng" as per the specification.
+func ReadTracks(r io.ReaderAt, fileSize int64) ([]*Track, error) {
+	tracksElement, err := findSegmentElement(r, fileSize, idTracks)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make([]*Track, 0)
+	err = children(r, tracksElement, fileSize, func(entry element) (bool, error) {
+		if entry.id != idTrackEntry {
+			return true, nil
+		}
+		track := &Track{
+			Language: "eng",
+			Default:  true,
+		}
+		ietf := ""
+		err := children(r, entry, fileSize, func(field element) (bool, error) {
+			var err error
+			var value uint64
+			switch field.id {
+			case idTrackNumber:
+				value, err = readUint(r, field)
+				track.Number = int(value)
+			case idTrackType:
+				value, err = readUint(r, field)
+				track.Type = int(value)
+			case idFlagDefault:
+				value, err = readUint(r, field)
+				track.Default = value != 0
+			case idFlagForced:
+				value, err = readUint(r, field)
+				track.Forced = value != 0
+			case idName:
+				track.Name, err = readString(r, field)
+			case idLanguage:
+				track.Language, err = readString(r, field)
+			case idLanguageIETF:
+				ietf, err = readString(r, field)
+			}
+			return true, err
+		})
+		if ietf != "" && track.Language == "und" {
+			track.Language = ietf
+		}
+		tracks = append(tracks, track)
+		return true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tracks, nil
+}
@@ -0,0 +1,169 @@
+// Package metacache caches the TMDB and TVDB responses, in memory and on
+// disk, for as long as each kind of metadata is expected to stay valid.
+package metacache
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/cache"
+	"github.com/steeve/pulsar/config"
+)
+
+// Kinds of metadata, each with its own TTL.
+const (
+	Movie      = "movie"
+	Show       = "show"
+	Season     = "season"
+	Episodes   = "episodes"
+	Collection = "collection"
+	Find       = "find"
+	Fanart     = "fanart"
+
+	AnimeMapping = "anime_mapping"
+)
+
+var defaultTTLs = map[string]time.Duration{
+	Movie:      60 * 24 * time.Hour,
+	Show:       60 * 24 * time.Hour,
+	Season:     7 * 24 * time.Hour,
+	Episodes:   2 * time.Hour, // new episodes get listed all the time
+	Collection: 60 * 24 * time.Hour,
+	Find:       365 * 24 * time.Hour,
+	Fanart:     14 * 24 * time.Hour, // artwork gets added for new shows
+
+	AnimeMapping: 7 * 24 * time.Hour, // the lists get fixed all the time
+}
+
+const defaultMemorySize = 500
+
+// Stats count the lookups of a kind of metadata.
+type Stats struct {
+	MemoryHits uint64 `json:"memory_hits"`
+	DiskHits   uint64 `json:"disk_hits"`
+	Misses     uint64 `json:"misses"`
+}
+
+var (
+	statsLock = sync.Mutex{}
+	stats     = map[string]*Stats{}
+)
+
+func countLookup(kind string, count func(s *Stats)) {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	s, ok := stats[kind]
+	if ok == false {
+		s = &Stats{}
+		stats[kind] = s
+	}
+	count(s)
+}
+
+// LookupStats returns the stats of the kinds looked up since startup.
+func LookupStats() map[string]Stats {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	result := make(map[string]Stats, len(stats))
+	for kind, s := range stats {
+		result[kind] = *s
+	}
+	return result
+}
+
+var (
+	initOnce = sync.Once{}
+	memory   *cache.MemoryStore
+	disk     *cache.FileStore
+)
+
+func stores() (*cache.MemoryStore, *cache.FileStore) {
+	initOnce.Do(func() {
+		size := config.Get().MetadataCacheSize
+		if size <= 0 {
+			size = defaultMemorySize
+		}
+		memory = cache.NewMemoryStore(size)
+		disk = cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
+	})
+	return memory, disk
+}
+
+// TTL is how long metadata of this kind are kept, as set in the settings.
+func TTL(kind string) time.Duration {
+	if ttl, ok := config.Get().MetadataCacheTTLs[kind]; ok {
+		return ttl
+	}
+	return defaultTTLs[kind]
+}
+
+func Kinds() []string {
+	return []string{Movie, Show, Season, Episodes, Collection, Find, Fanart, AnimeMapping}
+}
+
+func fullKey(kind string, key string) string {
+	return fmt.Sprintf("com.pulsar.meta.%s.%s", kind, key)
+}
+
+// Get looks in memory first, then on disk.
+func Get(kind string, key string, value interface{}) error {
+	memory, disk := stores()
+	key = fullKey(kind, key)
+	if err := memory.Get(key, value); err == nil {
+		countLookup(kind, func(s *Stats) { s.MemoryHits++ })
+		return nil
+	}
+	if err := disk.Get(key, value); err != nil {
+		countLookup(kind, func(s *Stats) { s.Misses++ })
+		return err
+	}
+	countLookup(kind, func(s *Stats) { s.DiskHits++ })
+	// ttl is approximative there, the disk copy knows the real one
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && v.IsNil() == false {
+		memory.Set(key, v.Elem().Interface(), TTL(kind))
+	}
+	return nil
+}
+
+func Set(kind string, key string, value interface{}) error {
+	memory, disk := stores()
+	key = fullKey(kind, key)
+	memory.Set(key, value, TTL(kind))
+	return disk.Set(key, value, TTL(kind))
+}
+
+// SetTransient keeps the value in memory only, for ttl, for metadata that
+// shouldn't stick around like fallbacks during an outage.
+func SetTransient(kind string, key string, value interface{}, ttl time.Duration) error {
+	memory, _ := stores()
+	return memory.Set(fullKey(kind, key), value, ttl)
+}
+
+// Invalidate removes a single entry, or all entries of the kind if key is
+// empty. It returns how many entries were removed from disk.
+func Invalidate(kind string, key string) (int, error) {
+	memory, disk := stores()
+	if key != "" {
+		memory.Delete(fullKey(kind, key))
+		return 1, disk.Delete(fullKey(kind, key))
+	}
+	prefix := fullKey(kind, "")
+	memory.DeletePrefix(prefix)
+	return disk.DeletePrefix(prefix)
+}
+
+// InvalidateAll drops every cached metadata, of all kinds.
+func InvalidateAll() (int, error) {
+	total := 0
+	for _, kind := range Kinds() {
+		deleted, err := Invalidate(kind, "")
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+	}
+	return total, nil
+}
@@ -0,0 +1,217 @@
+// Package metasearch is a native provider asking public torrent search
+// aggregators, so that searches return results before any provider addon
+// is installed.
+package metasearch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/providers"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+)
+
+// The endpoints asked when the settings have none. {query} is replaced
+// with the escaped query.
+var DefaultEndpoints = []string{
+	"https://apibay.org/q.php?q={query}&cat=200",
+	"https://torrents-csv.com/service/search?q={query}&size=50",
+}
+
+// Per endpoint, as the aggregators return their whole first page.
+const maxResults = 50
+
+// The fields the aggregators name the torrent attributes with.
+var (
+	nameFields     = []string{"name", "title"}
+	infoHashFields = []string{"info_hash", "infohash", "hash"}
+	magnetFields   = []string{"magnet", "magnet_uri", "magnet_link"}
+	sizeFields     = []string{"size", "size_bytes", "bytes"}
+	seedsFields    = []string{"seeders", "seeds"}
+	peersFields    = []string{"leechers", "peers"}
+)
+
+var log = logging.MustGetLogger("metasearch")
+
+var errNoResults = errors.New("no list of results")
+
+type MetaSearch struct{}
+
+func init() {
+	providers.Register(MetaSearch{})
+}
+
+func (MetaSearch) String() string {
+	return "metasearch"
+}
+
+// Searched is for the auto setting: only until a provider addon is
+// installed.
+func (MetaSearch) Searched(addons int) bool {
+	switch config.Get().MetaSearch {
+	case config.MetaSearchOff:
+		return false
+	case config.MetaSearchAlways:
+		return true
+	}
+	return addons == 0
+}
+
+func endpoints() []string {
+	if list := config.Get().MetaSearchEndpoints; len(list) > 0 {
+		return list
+	}
+	return DefaultEndpoints
+}
+
+func (MetaSearch) SearchLinks(query string) []*bittorrent.Torrent {
+	return search(query)
+}
+
+func (MetaSearch) SearchMovieLinks(movie *tmdb.Movie) []*bittorrent.Torrent {
+	query := movie.Title
+	if year := strings.Split(movie.ReleaseDate, "-")[0]; year != "" {
+		query += " " + year
+	}
+	return search(query)
+}
+
+func (MetaSearch) SearchEpisodeLinks(show *tvdb.Show, episode *tvdb.Episode) []*bittorrent.Torrent {
+	return search(fmt.Sprintf("%s S%02dE%02d", show.SeriesName, episode.SeasonNumber, episode.EpisodeNumber))
+}
+
+// search asks all the endpoints at once, the results being merged on their
+// infohash later on.
+func search(query string) []*bittorrent.Torrent {
+	list := endpoints()
+	results := make([][]*bittorrent.Torrent, len(list))
+	wg := sync.WaitGroup{}
+	wg.Add(len(list))
+	for i, endpoint := range list {
+		go func(i int, endpoint string) {
+			defer wg.Done()
+			torrents, err := searchEndpoint(endpoint, query)
+			if err != nil {
+				log.Warning("Unable to search %s: %s", endpointHost(endpoint), err)
+				return
+			}
+			results[i] = torrents
+		}(i, endpoint)
+	}
+	wg.Wait()
+
+	torrents := make([]*bittorrent.Torrent, 0)
+	for _, endpointTorrents := range results {
+		torrents = append(torrents, endpointTorrents...)
+	}
+	return torrents
+}
+
+func endpointHost(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return endpoint
+}
+
+func searchEndpoint(endpoint string, query string) ([]*bittorrent.Torrent, error) {
+	searchURL := strings.Replace(endpoint, "{query}", url.QueryEscape(query), -1)
+	body, err := providers.ScrapeData(searchURL)
+	if err != nil {
+		return nil, err
+	}
+	items, err := resultsOf(body)
+	if err != nil {
+		return nil, err
+	}
+
+	host := endpointHost(endpoint)
+	torrents := make([]*bittorrent.Torrent, 0, len(items))
+	for _, item := range items {
+		if torrent := torrentOf(item, host); torrent != nil {
+			torrents = append(torrents, torrent)
+		}
+		if len(torrents) == maxResults {
+			break
+		}
+	}
+	return torrents, nil
+}
+
+// resultsOf finds the list of results, which the aggregators return as is,
+// or in some field of an object.
+func resultsOf(body []byte) ([]map[string]interface{}, error) {
+	var list []map[string]interface{}
+	if err := json.Unmarshal(body, &list); err == nil {
+		return list, nil
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		return nil, err
+	}
+	for _, field := range []string{"torrents", "results", "data", "items"} {
+		if raw, ok := object[field]; ok && json.Unmarshal(raw, &list) == nil {
+			return list, nil
+		}
+	}
+	return nil, errNoResults
+}
+
+func torrentOf(item map[string]interface{}, host string) *bittorrent.Torrent {
+	name := stringField(item, nameFields)
+	uri := stringField(item, magnetFields)
+	if strings.HasPrefix(uri, "magnet:") == false {
+		infoHash := strings.ToLower(stringField(item, infoHashFields))
+		// apibay answers an all zeros infohash when nothing matched
+		if len(infoHash) != 40 || strings.Trim(infoHash, "0") == "" {
+			return nil
+		}
+		uri = fmt.Sprintf("magnet:?xt=urn:btih:%s&dn=%s", infoHash, url.QueryEscape(name))
+		for _, tracker := range providers.DefaultTrackers {
+			uri += "&tr=" + url.QueryEscape(tracker)
+		}
+	}
+	torrent := bittorrent.NewTorrent(uri)
+	if torrent.Name == "" {
+		torrent.Name = name
+	}
+	torrent.Size = intField(item, sizeFields)
+	torrent.Seeds = intField(item, seedsFields)
+	torrent.Peers = intField(item, peersFields)
+	torrent.Provider = "metasearch"
+	torrent.ProviderName = host
+	return torrent
+}
+
+func stringField(item map[string]interface{}, names []string) string {
+	for _, name := range names {
+		if value, ok := item[name].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// intField reads numbers, which some aggregators send as strings.
+func intField(item map[string]interface{}, names []string) int64 {
+	for _, name := range names {
+		switch value := item[name].(type) {
+		case float64:
+			return int64(value)
+		case string:
+			if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+				return number
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+// Package metrics writes telemetry in the Prometheus text format, for
+// seedbox users to graph Pulsar with the rest of their stack. The values
+// mostly come from the stats the packages keep anyway, only the histograms
+// are recorded here.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Histogram counts observations, in seconds, by bucket.
+type Histogram struct {
+	lock    sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func NewHistogram(buckets ...float64) *Histogram {
+	sort.Float64s(buckets)
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// Writer writes the metrics, the help and type of each once.
+type Writer struct {
+	w         io.Writer
+	described map[string]bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, described: make(map[string]bool)}
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels takes the labels as name, value pairs.
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (w *Writer) describe(name string, kind string, help string) {
+	if w.described[name] {
+		return
+	}
+	w.described[name] = true
+	fmt.Fprintf(w.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (w *Writer) sample(name string, value float64, labels []string) {
+	fmt.Fprintf(w.w, "%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+func (w *Writer) Gauge(name string, help string, value float64, labels ...string) {
+	w.describe(name, "gauge", help)
+	w.sample(name, value, labels)
+}
+
+func (w *Writer) Counter(name string, help string, value float64, labels ...string) {
+	w.describe(name, "counter", help)
+	w.sample(name, value, labels)
+}
+
+// Summary writes a sum and a count, for averages.
+func (w *Writer) Summary(name string, help string, sum float64, count uint64, labels ...string) {
+	w.describe(name, "summary", help)
+	w.sample(name+"_sum", sum, labels)
+	w.sample(name+"_count", float64(count), labels)
+}
+
+func (w *Writer) Histogram(name string, help string, h *Histogram, labels ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	w.describe(name, "histogram", help)
+	for i, bound := range h.buckets {
+		w.sample(name+"_bucket", float64(h.counts[i]), append(labels, "le", formatValue(bound)))
+	}
+	w.sample(name+"_bucket", float64(h.count), append(labels, "le", "+Inf"))
+	w.sample(name+"_sum", h.sum, labels)
+	w.sample(name+"_count", float64(h.count), labels)
+}
@@ -0,0 +1,137 @@
+package osdb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Video being played, and what we know about it to find its subtitles.
+type Video struct {
+	Path   string
+	Hash   string
+	Size   int64
+	IMDBId string
+	Query  string
+}
+
+// NewVideo hashes the file at path, which needs at least its first and
+// last 64k on disk.
+func NewVideo(path string, size int64) (*Video, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash, err := Hash(file, size)
+	if err != nil {
+		return nil, err
+	}
+	return &Video{Path: path, Hash: hash, Size: size}, nil
+}
+
+// The hash match first, then the IMDB id, then the full text query.
+func (v *Video) payloads(languages []string) []SearchPayload {
+	sublanguageid := strings.Join(languages, ",")
+	payloads := make([]SearchPayload, 0, 3)
+	if v.Hash != "" {
+		payloads = append(payloads, SearchPayload{Hash: v.Hash, Size: v.Size, Languages: sublanguageid})
+	}
+	if v.IMDBId != "" {
+		payloads = append(payloads, SearchPayload{IMDBId: strings.TrimPrefix(v.IMDBId, "tt"), Languages: sublanguageid})
+	}
+	if v.Query != "" {
+		payloads = append(payloads, SearchPayload{Query: v.Query, Languages: sublanguageid})
+	} else if v.Path != "" {
+		payloads = append(payloads, SearchPayload{Query: filepath.Base(v.Path), Languages: sublanguageid})
+	}
+	return payloads
+}
+
+// SearchVideo looks for the subtitles of v, anonymously, and returns them
+// best first.
+func SearchVideo(v *Video, languages []string) (Subtitles, error) {
+	client, err := NewClient()
+	if err != nil {
+		return nil, err
+	}
+	if err := client.LogIn("", "", ""); err != nil {
+		return nil, err
+	}
+	defer client.LogOut()
+
+	subs, err := client.SearchSubtitles(v.payloads(languages))
+	if err != nil {
+		return nil, err
+	}
+	return subs.Ranked(languages), nil
+}
+
+type rankedSubtitles struct {
+	Subtitles
+	languages map[string]int
+}
+
+func (r rankedSubtitles) Less(i, j int) bool {
+	a, b := r.Subtitles[i], r.Subtitles[j]
+	// subtitles matched by hash are synced with the file
+	if (a.MatchedBy == "moviehash") != (b.MatchedBy == "moviehash") {
+		return a.MatchedBy == "moviehash"
+	}
+	if r.languages[a.SubLanguageID] != r.languages[b.SubLanguageID] {
+		return r.languages[a.SubLanguageID] < r.languages[b.SubLanguageID]
+	}
+	aDownloads, _ := strconv.Atoi(a.SubDownloadsCnt)
+	bDownloads, _ := strconv.Atoi(b.SubDownloadsCnt)
+	return aDownloads > bDownloads
+}
+
+func (subs Subtitles) Len() int      { return len(subs) }
+func (subs Subtitles) Swap(i, j int) { subs[i], subs[j] = subs[j], subs[i] }
+
+// Ranked sorts the subtitles by hash match, then by the order of
+// preference of languages, then by popularity.
+func (subs Subtitles) Ranked(languages []string) Subtitles {
+	order := make(map[string]int)
+	for i, language := range languages {
+		order[language] = i
+	}
+	for i := range subs {
+		if _, exists := order[subs[i].SubLanguageID]; exists == false {
+			order[subs[i].SubLanguageID] = len(languages)
+		}
+	}
+	sort.Stable(rankedSubtitles{subs, order})
+	return subs
+}
+
+// DownloadNextTo saves the subtitle in the directory of the video, named
+// after it so players pick it up, and returns its path.
+func (c *Client) DownloadNextTo(s *Subtitle, videoPath string) (string, error) {
+	base := strings.TrimSuffix(videoPath, filepath.Ext(videoPath))
+	format := s.SubFormat
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(s.SubFileName), ".")
+	}
+	path := fmt.Sprintf("%s.%s.%s", base, s.SubLanguageID, format)
+	if err := c.DownloadTo(s, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// DownloadNextTo logs in anonymously to download s next to the video.
+func DownloadNextTo(s *Subtitle, videoPath string) (string, error) {
+	client, err := NewClient()
+	if err != nil {
+		return "", err
+	}
+	if err := client.LogIn("", "", ""); err != nil {
+		return "", err
+	}
+	defer client.LogOut()
+	return client.DownloadNextTo(s, videoPath)
+}
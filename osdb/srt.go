@@ -0,0 +1,154 @@
+package osdb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// how far apart the subtitle and its reference may be
+	maxOffset = 60 * time.Second
+	// the offsets are voted for by slices of this
+	offsetStep = 100 * time.Millisecond
+)
+
+var (
+	ErrNoCues   = errors.New("no subtitles in the file")
+	ErrNoOffset = errors.New("unable to find the subtitle offset")
+)
+
+var srtTiming = regexp.MustCompile(`^(\d+):(\d+):(\d+)[,.](\d+)\s*-->\s*(\d+):(\d+):(\d+)[,.](\d+)`)
+
+// Cue is a subtitle line, shown from Start to End.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  []string
+}
+
+type Cues []*Cue
+
+func parseSRTTime(parts []string) time.Duration {
+	hours, _ := strconv.Atoi(parts[0])
+	minutes, _ := strconv.Atoi(parts[1])
+	seconds, _ := strconv.Atoi(parts[2])
+	millis, _ := strconv.Atoi((parts[3] + "00")[:3])
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second + time.Duration(millis)*time.Millisecond
+}
+
+// ParseSRT reads the cues of a SubRip file.
+func ParseSRT(r io.Reader) (Cues, error) {
+	cues := make(Cues, 0)
+	var cue *Cue
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(strings.TrimPrefix(scanner.Text(), "\ufeff"), "\r")
+		if match := srtTiming.FindStringSubmatch(line); match != nil {
+			cue = &Cue{Start: parseSRTTime(match[1:5]), End: parseSRTTime(match[5:9])}
+			cues = append(cues, cue)
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			cue = nil
+			continue
+		}
+		if cue != nil {
+			cue.Text = append(cue.Text, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cues) == 0 {
+		return nil, ErrNoCues
+	}
+	return cues, nil
+}
+
+func formatSRTTime(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := int64(d / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// WriteSRT writes the cues as a SubRip file.
+func (cues Cues) WriteSRT(w io.Writer) error {
+	for i, cue := range cues {
+		if _, err := fmt.Fprintf(w, "%d\r\n%s --> %s\r\n%s\r\n\r\n", i+1,
+			formatSRTTime(cue.Start), formatSRTTime(cue.End), strings.Join(cue.Text, "\r\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shift returns the cues delayed by offset, or brought forward if it's
+// negative. Those that would be shown before the start are dropped.
+func (cues Cues) Shift(offset time.Duration) Cues {
+	shifted := make(Cues, 0, len(cues))
+	for _, cue := range cues {
+		if cue.End+offset <= 0 {
+			continue
+		}
+		shifted = append(shifted, &Cue{Start: cue.Start + offset, End: cue.End + offset, Text: cue.Text})
+	}
+	return shifted
+}
+
+type durations []time.Duration
+
+func (a durations) Len() int           { return len(a) }
+func (a durations) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a durations) Less(i, j int) bool { return a[i] < a[j] }
+
+// DetectOffset finds by how much the cues are to be shifted to line up
+// with reference, a subtitle known to be in sync, in whatever language:
+// the lines of both start together, whatever they say, so the shift most
+// of their starts agree on wins.
+func (cues Cues) DetectOffset(reference Cues) (time.Duration, error) {
+	votes := make(map[int64]durations)
+	for _, cue := range cues {
+		for _, ref := range reference {
+			diff := ref.Start - cue.Start
+			if diff < -maxOffset || diff > maxOffset {
+				continue
+			}
+			step := int64((diff + offsetStep/2) / offsetStep)
+			if diff < 0 {
+				step = int64((diff - offsetStep/2) / offsetStep)
+			}
+			votes[step] = append(votes[step], diff)
+		}
+	}
+	best, bestVotes := int64(0), 0
+	for step := range votes {
+		// the neighbouring slices count too, an offset may straddle them
+		count := len(votes[step-1]) + len(votes[step]) + len(votes[step+1])
+		if count > bestVotes {
+			best, bestVotes = step, count
+		}
+	}
+	needed := len(cues) / 10
+	if needed < 5 {
+		needed = 5
+	}
+	if bestVotes < needed {
+		return 0, ErrNoOffset
+	}
+	diffs := make(durations, 0, bestVotes)
+	for step := best - 1; step <= best+1; step++ {
+		diffs = append(diffs, votes[step]...)
+	}
+	sort.Sort(diffs)
+	return diffs[len(diffs)/2], nil
+}
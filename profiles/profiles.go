@@ -0,0 +1,151 @@
+// Package profiles separates the data of the people sharing a Pulsar
+// install, kids and adults for instance. Each profile gets its own data
+// buckets, parental settings and library folder.
+package profiles
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/clock"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/store"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const (
+	DefaultProfile = "default"
+
+	profileKey         = "profile"
+	xbmcProfileRefresh = 30 * time.Second
+)
+
+var log = logging.MustGetLogger("profiles")
+
+var ErrInvalidName = errors.New("invalid profile name")
+
+var validName = regexp.MustCompile(`^[\w\- ]+$`)
+
+type Profile struct {
+	Name          string `json:"name"`
+	LibraryPath   string `json:"library_path"`
+	AllowAdult    bool   `json:"allow_adult"`
+	BlockedGenres []int  `json:"blocked_genres"`
+}
+
+var (
+	lock            = sync.Mutex{}
+	selected        = ""
+	xbmcProfile     = ""
+	xbmcProfileTime time.Time
+)
+
+func profilesPath() string {
+	return filepath.Join(config.Get().ProfilePath, "profiles")
+}
+
+func profilePath(name string) string {
+	return filepath.Join(profilesPath(), name)
+}
+
+// The data of a profile is namespaced in buckets, one directory each.
+func (p *Profile) Bucket(name string) *store.Bucket {
+	return store.Open(filepath.Join(profilePath(p.Name), name))
+}
+
+func (p *Profile) AllowsGenre(genreId int) bool {
+	for _, blocked := range p.BlockedGenres {
+		if blocked == genreId {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Profile) Allows(adult bool, genreIds []int) bool {
+	if adult && p.AllowAdult == false {
+		return false
+	}
+	for _, genreId := range genreIds {
+		if p.AllowsGenre(genreId) == false {
+			return false
+		}
+	}
+	return true
+}
+
+// CacheKey tells apart the profiles, and their parental settings, for the
+// pages cached as they see them.
+func (p *Profile) CacheKey() string {
+	return fmt.Sprintf("%s:%t:%v", p.Name, p.AllowAdult, p.BlockedGenres)
+}
+
+func Get(name string) *Profile {
+	profile := &Profile{
+		Name:       name,
+		AllowAdult: true,
+	}
+	if err := store.Open(profilePath(name)).Get(profileKey, profile); err != nil {
+		profile.Name = name
+	}
+	return profile
+}
+
+func Save(profile *Profile) error {
+	if validName.MatchString(profile.Name) == false {
+		return ErrInvalidName
+	}
+	return store.Open(profilePath(profile.Name)).Set(profileKey, profile, store.FOREVER)
+}
+
+func List() []*Profile {
+	profiles := []*Profile{Get(DefaultProfile)}
+	entries, _ := ioutil.ReadDir(profilesPath())
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != DefaultProfile {
+			profiles = append(profiles, Get(entry.Name()))
+		}
+	}
+	return profiles
+}
+
+// Select forces the current profile, an empty name goes back to following
+// the XBMC profile.
+func Select(name string) error {
+	if name != "" && validName.MatchString(name) == false {
+		return ErrInvalidName
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	selected = name
+	log.Info("Selected profile %q", name)
+	return nil
+}
+
+// Current returns the selected profile, or the one named after the current
+// XBMC profile if it exists.
+func Current() *Profile {
+	lock.Lock()
+	defer lock.Unlock()
+
+	if selected != "" {
+		return Get(selected)
+	}
+	if clock.Now().Sub(xbmcProfileTime) > xbmcProfileRefresh {
+		xbmcProfile = xbmc.InfoLabel("System.ProfileName")
+		xbmcProfileTime = clock.Now()
+	}
+	if xbmcProfile != "" && validName.MatchString(xbmcProfile) {
+		if _, err := os.Stat(profilePath(xbmcProfile)); err == nil {
+			return Get(xbmcProfile)
+		}
+	}
+	return Get(DefaultProfile)
+}
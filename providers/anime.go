@@ -0,0 +1,149 @@
+package providers
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/steeve/pulsar/anidb"
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+)
+
+const (
+	AnimeAudioAny = iota
+	AnimeAudioDual
+	AnimeAudioSubbed
+	AnimeAudioDubbed
+)
+
+var (
+	fansubGroupRegexp = regexp.MustCompile(`^\s*\[([^\]]+)\]`)
+	dualAudioRegexp   = regexp.MustCompile(`(?i)\bdual[\s\.\-_]?audio\b`)
+	dubbedRegexp      = regexp.MustCompile(`(?i)\b(dub|dubbed|english[\s\.\-_]dub)\b`)
+)
+
+// Shows flagged in the anime_shows setting, or found on AniDB, are anime.
+// When the AniDB mappings aren't available, shows from Japan in the
+// Animation genre are.
+func isAnime(tvdbId int, tmdbShow *tmdb.Show) bool {
+	for _, id := range config.Get().AnimeShows {
+		if id == strconv.Itoa(tvdbId) {
+			return true
+		}
+	}
+	if anime, known := anidb.IsAnime(tvdbId); known {
+		return anime
+	}
+	if tmdbShow == nil {
+		return false
+	}
+	countryIsJP := false
+	for _, country := range tmdbShow.OriginCountry {
+		if country == "JP" {
+			countryIsJP = true
+			break
+		}
+	}
+	genreIsAnim := false
+	for _, genre := range tmdbShow.Genres {
+		if genre.Name == "Animation" {
+			genreIsAnim = true
+			break
+		}
+	}
+	return countryIsJP && genreIsAnim
+}
+
+// IsAnimeShow is isAnime for the shows only known by their TVDB id, as
+// the player is.
+func IsAnimeShow(tvdbId int) bool {
+	return isAnime(tvdbId, tmdbShowFor(&tvdb.Show{Id: tvdbId}))
+}
+
+func tmdbShowFor(show *tvdb.Show) *tmdb.Show {
+	tmdbFindResults := tmdb.Find(strconv.Itoa(show.Id), "tvdb_id")
+	if tmdbFindResults == nil {
+		return nil
+	}
+	for _, result := range tmdbFindResults.TVResults {
+		// in English, for the genre names
+		return tmdb.GetShow(result.Id, tmdb.FallbackLanguage)
+	}
+	return nil
+}
+
+// FansubGroup returns the group from names like "[Group] Show - 01".
+func FansubGroup(name string) string {
+	if matches := fansubGroupRegexp.FindStringSubmatch(name); matches != nil {
+		return strings.TrimSpace(matches[1])
+	}
+	return ""
+}
+
+func matchesAnimeAudio(name string, audio int) bool {
+	switch audio {
+	case AnimeAudioDual:
+		return dualAudioRegexp.MatchString(name)
+	case AnimeAudioSubbed:
+		return dubbedRegexp.MatchString(name) == false || dualAudioRegexp.MatchString(name)
+	case AnimeAudioDubbed:
+		return dubbedRegexp.MatchString(name) || dualAudioRegexp.MatchString(name)
+	}
+	return true
+}
+
+type byFansubGroup struct {
+	torrents []*bittorrent.Torrent
+	ranks    map[string]int
+}
+
+func (a byFansubGroup) rank(t *bittorrent.Torrent) int {
+	if rank, ok := a.ranks[strings.ToLower(FansubGroup(t.Name))]; ok {
+		return rank
+	}
+	return len(a.ranks)
+}
+
+func (a byFansubGroup) Len() int           { return len(a.torrents) }
+func (a byFansubGroup) Swap(i, j int)      { a.torrents[i], a.torrents[j] = a.torrents[j], a.torrents[i] }
+func (a byFansubGroup) Less(i, j int) bool { return a.rank(a.torrents[i]) < a.rank(a.torrents[j]) }
+
+// Filters on the audio preference, and moves the preferred fansub groups
+// first, in order. Results keep their seeds order otherwise.
+func applyAnimePreferences(torrents []*bittorrent.Torrent, trace *SearchTrace) []*bittorrent.Torrent {
+	audio := config.Get().AnimeAudio
+	filtered := make([]*bittorrent.Torrent, 0, len(torrents))
+	for _, torrent := range torrents {
+		if matchesAnimeAudio(torrent.Name, audio) {
+			filtered = append(filtered, torrent)
+		} else {
+			trace.Drop(torrent, "anime", "doesn't have the preferred audio")
+		}
+	}
+
+	ranks := map[string]int{}
+	for i, group := range config.Get().FansubGroups {
+		ranks[strings.ToLower(group)] = i
+	}
+	if len(ranks) > 0 {
+		sort.Stable(byFansubGroup{filtered, ranks})
+	}
+	return filtered
+}
+
+// animeNumbers are the numbers fansubs may give the episode: the absolute
+// one across seasons, and the AniDB one when seasons are split there.
+func animeNumbers(searchObject *EpisodeSearchObject) []int {
+	numbers := make([]int, 0, 2)
+	if searchObject.AbsoluteNumber > 0 {
+		numbers = append(numbers, searchObject.AbsoluteNumber)
+	}
+	if searchObject.AniDBEpisode > 0 && searchObject.AniDBEpisode != searchObject.AbsoluteNumber {
+		numbers = append(numbers, searchObject.AniDBEpisode)
+	}
+	return numbers
+}
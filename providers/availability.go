@@ -0,0 +1,80 @@
+package providers
+
+import (
+	"sync"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/util"
+)
+
+// verifyAvailability checks the seeds of the top results on the DHT, and
+// then trusts the DHT and the trackers over the often inflated counts of the
+// providers. Results nobody knows of keep what the provider said.
+func verifyAvailability(torrents []*bittorrent.Torrent, scraped map[string]*bittorrent.ScrapeResponseEntry) []*bittorrent.Torrent {
+	conf := config.Get()
+	checks := conf.AvailabilityChecks
+	if checks <= 0 || len(torrents) == 0 {
+		return torrents
+	}
+	if checks > len(torrents) {
+		checks = len(torrents)
+	}
+	// UDP doesn't go through the proxy, keep the DHT off it then
+	useDHT := conf.SocksEnabled == false || conf.SocksTraffic == config.SocksTrafficTrackersOnly
+	bindIP, err := util.BindAddress()
+	if err != nil {
+		log.Warning("Not scraping the DHT: %s", err)
+		useDHT = false
+	}
+
+	log.Info("Verifying the seeds of the top %d results...", checks)
+	verified := 0
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, torrent := range torrents[:checks] {
+		wg.Add(1)
+		go func(torrent *bittorrent.Torrent) {
+			defer wg.Done()
+			var seeds, peers int64
+			known := false
+			if entry, ok := scraped[torrent.InfoHash]; ok && entry.Seeders+entry.Leechers > 0 {
+				seeds, peers = int64(entry.Seeders), int64(entry.Leechers)
+				known = true
+			}
+			if useDHT && torrent.IsPrivate == false {
+				result, err := bittorrent.DHTScrape(torrent.InfoHash, conf.DHTBootstrapNodes, bindIP)
+				if err != nil {
+					log.Debug("Unable to scrape %s on the DHT: %s", torrent.Name, err)
+				} else if result.Responses > 0 {
+					if result.Seeds > seeds {
+						seeds = result.Seeds
+					}
+					if result.Peers > peers {
+						peers = result.Peers
+					}
+					known = true
+				}
+			}
+			if known == false {
+				return
+			}
+			if seeds != torrent.Seeds {
+				log.Info("%s has %d seeds, not %d", torrent.Name, seeds, torrent.Seeds)
+			}
+			torrent.ReportedSeeds = torrent.Seeds
+			torrent.Seeds, torrent.Peers = seeds, peers
+			torrent.SeedsVerified = true
+			lock.Lock()
+			verified++
+			lock.Unlock()
+		}(torrent)
+	}
+	wg.Wait()
+
+	if verified > 0 {
+		bittorrent.SortByScore(torrents)
+	}
+	log.Info("Verified the seeds of %d results", verified)
+	return torrents
+}
@@ -0,0 +1,140 @@
+package providers
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// Providers can declare what they support in the extrainfo section of
+// their addon.xml, e.g. a comma separated "languages" entry such as "fr,it",
+// and "regions" such as "be,ch".
+type Capabilities struct {
+	// the addon name, for display
+	Name string
+	// ISO 639-1 language (or ISO 3166-1 region) codes the provider wants
+	// titles for, and has releases in. Empty means all of them.
+	Languages []string
+	// ISO 3166-1 region codes it also wants the titles of
+	Regions []string
+}
+
+var capsLock = sync.RWMutex{}
+var capabilities = map[string]*Capabilities{}
+
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func GetCapabilities(addonId string) *Capabilities {
+	capsLock.RLock()
+	caps, ok := capabilities[addonId]
+	capsLock.RUnlock()
+	if ok {
+		return caps
+	}
+
+	caps = &Capabilities{Name: addonId}
+	if details := xbmc.GetAddonDetails(addonId, "extrainfo", "name"); details != nil {
+		if details.Name != "" {
+			caps.Name = details.Name
+		}
+		caps.Languages = splitList(details.GetExtraInfo("languages"))
+		caps.Regions = splitList(details.GetExtraInfo("regions"))
+	}
+
+	capsLock.Lock()
+	capabilities[addonId] = caps
+	capsLock.Unlock()
+
+	return caps
+}
+
+func (caps *Capabilities) WantsLanguage(code string) bool {
+	if len(caps.Languages) == 0 {
+		return true
+	}
+	return containsCode(caps.Languages, code)
+}
+
+func (caps *Capabilities) WantsRegion(code string) bool {
+	return caps.WantsLanguage(code) || containsCode(caps.Regions, code)
+}
+
+func containsCode(codes []string, code string) bool {
+	code = strings.ToLower(code)
+	for _, c := range codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Foreign providers declared languages, and not English.
+func (caps *Capabilities) Foreign() bool {
+	return len(caps.Languages) > 0 && caps.WantsLanguage("en") == false
+}
+
+// filterTitles only keeps the title variants the provider asked for
+func (caps *Capabilities) filterTitles(titles map[string]string) map[string]string {
+	filtered := make(map[string]string)
+	for code, title := range titles {
+		if caps.WantsRegion(code) {
+			filtered[code] = title
+		}
+	}
+	return filtered
+}
+
+var languagesLock = sync.Mutex{}
+var convertedLanguages = map[string]string{}
+
+// preferredLanguages are the preferred audio and subtitle languages, as ISO
+// 639-1 codes.
+func preferredLanguages() []string {
+	conf := config.Get()
+	languagesLock.Lock()
+	defer languagesLock.Unlock()
+
+	languages := make([]string, 0)
+	for _, language := range append(append([]string{}, conf.AudioLanguages...), conf.SubtitleLanguages...) {
+		// XBMC knows about all the ISO 639 variants
+		code, ok := convertedLanguages[language]
+		if ok == false {
+			if code = strings.ToLower(xbmc.ConvertLanguage(language, xbmc.ISO_639_1)); code != "" {
+				convertedLanguages[language] = code
+			}
+		}
+		if code != "" && containsCode(languages, code) == false {
+			languages = append(languages, code)
+		}
+	}
+	return languages
+}
+
+// TargetLanguages returns the preferred languages the provider has
+// releases in, and whether to search it at all: with
+// provider_language_targeting on, providers declaring languages are only
+// searched for the preferred ones.
+func (caps *Capabilities) TargetLanguages() ([]string, bool) {
+	preferred := preferredLanguages()
+	if len(caps.Languages) == 0 || len(preferred) == 0 {
+		return preferred, true
+	}
+	targeted := make([]string, 0, len(preferred))
+	for _, language := range preferred {
+		if caps.WantsLanguage(language) {
+			targeted = append(targeted, language)
+		}
+	}
+	return targeted, len(targeted) > 0 || config.Get().ProviderLanguageTargeting == false
+}
@@ -0,0 +1,113 @@
+package providers
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/steeve/pulsar/bittorrent"
+)
+
+type inflightSearch struct {
+	done     chan struct{}
+	torrents []*bittorrent.Torrent
+	waiters  int
+	cancel   context.CancelFunc
+}
+
+var inflightLock = sync.Mutex{}
+var inflight = map[string]*inflightSearch{}
+
+// coalesce makes sure only one search runs at a time for a given key.
+// Callers asking for the same search while it's in flight wait for it and
+// share its results. A caller whose ctx is done stops waiting, the search
+// itself being cancelled once nobody waits for it anymore.
+func coalesce(ctx context.Context, key string, search func(ctx context.Context) []*bittorrent.Torrent) []*bittorrent.Torrent {
+	inflightLock.Lock()
+	s, exists := inflight[key]
+	if exists {
+		log.Info("Search %s is already in flight, waiting for it", key)
+	} else {
+		searchCtx, cancel := context.WithCancel(context.Background())
+		s = &inflightSearch{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		inflight[key] = s
+		go runSearch(searchCtx, key, s, search)
+	}
+	s.waiters++
+	inflightLock.Unlock()
+
+	select {
+	case <-s.done:
+		return copyTorrents(s.torrents)
+	case <-ctx.Done():
+		inflightLock.Lock()
+		s.waiters--
+		if s.waiters == 0 {
+			log.Info("Nobody waits for search %s anymore, cancelling it", key)
+			s.cancel()
+			// those asking for it next start it over
+			forgetInflight(key, s)
+		}
+		inflightLock.Unlock()
+		return make([]*bittorrent.Torrent, 0)
+	}
+}
+
+func runSearch(ctx context.Context, key string, s *inflightSearch, search func(ctx context.Context) []*bittorrent.Torrent) {
+	defer s.cancel()
+	s.torrents = search(ctx)
+	// the results of a cancelled search are whatever came in before
+	if ctx.Err() == nil {
+		rememberResults(key, s.torrents)
+	}
+
+	inflightLock.Lock()
+	forgetInflight(key, s)
+	inflightLock.Unlock()
+	close(s.done)
+}
+
+// forgetInflight must be called with inflightLock held.
+func forgetInflight(key string, s *inflightSearch) {
+	if inflight[key] == s {
+		delete(inflight, key)
+	}
+}
+
+// searchersKey is added to the keys of the searches, for those over other
+// providers not to share their results.
+func searchersKey(searchers []interface{}) string {
+	names := make([]string, 0, len(searchers))
+	for _, searcher := range searchers {
+		names = append(names, providerName(searcher))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// searcherList turns a slice of Searcher, MovieSearcher or EpisodeSearcher
+// into the list searchersKey and fanOut take.
+func searcherList(searchers interface{}) []interface{} {
+	v := reflect.ValueOf(searchers)
+	list := make([]interface{}, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		list = append(list, v.Index(i).Interface())
+	}
+	return list
+}
+
+// callers sort their results in place and score or verify the torrents, so
+// give each of them their own slice and their own torrents
+func copyTorrents(torrents []*bittorrent.Torrent) []*bittorrent.Torrent {
+	dup := make([]*bittorrent.Torrent, len(torrents))
+	for i, torrent := range torrents {
+		torrentCopy := *torrent
+		dup[i] = &torrentCopy
+	}
+	return dup
+}
@@ -0,0 +1,61 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/clock"
+	"github.com/steeve/pulsar/config"
+)
+
+const (
+	debugLogFile       = "providers_debug.log"
+	debugLogMaxBody    = 4 * 1024
+	debugLogMaxEntries = 30 // per provider and per debugLogWindow
+	debugLogWindow     = 1 * time.Minute
+)
+
+var (
+	debugLogLock    = sync.Mutex{}
+	debugLogCounts  = map[string]int{}
+	debugLogStarted time.Time
+)
+
+// Dumps what we send to, and receive from, providers in a separate log when
+// provider_debug is enabled, so that provider authors can see exactly what
+// goes on the wire. Bodies are truncated and entries are rate limited.
+func debugPayload(addonId string, direction string, body []byte) {
+	if config.Get().ProviderDebug == false {
+		return
+	}
+
+	debugLogLock.Lock()
+	defer debugLogLock.Unlock()
+
+	now := clock.Now()
+	if now.Sub(debugLogStarted) > debugLogWindow {
+		debugLogCounts = map[string]int{}
+		debugLogStarted = now
+	}
+	debugLogCounts[addonId]++
+	if debugLogCounts[addonId] > debugLogMaxEntries {
+		return
+	}
+
+	file, err := os.OpenFile(filepath.Join(config.Get().ProfilePath, debugLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		log.Warning("Unable to open the provider debug log: %s", err)
+		return
+	}
+	defer file.Close()
+
+	truncated := ""
+	if len(body) > debugLogMaxBody {
+		truncated = fmt.Sprintf(" (truncated, %d bytes)", len(body))
+		body = body[:debugLogMaxBody]
+	}
+	fmt.Fprintf(file, "%s %s %s%s\n%s\n\n", now.Format("2006-01-02 15:04:05"), addonId, direction, truncated, body)
+}
@@ -0,0 +1,56 @@
+package providers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const (
+	// how long we wait for other providers to be called before sending the
+	// whole batch to XBMC
+	dispatchWindow = 50 * time.Millisecond
+)
+
+type pendingDispatch struct {
+	call xbmc.AddonCall
+	err  chan error
+}
+
+var dispatchLock = sync.Mutex{}
+var dispatchQueue []*pendingDispatch
+
+// executeAddon queues the addon call and sends it along with all the other
+// calls made during the dispatch window in one JSON-RPC batch.
+func executeAddon(addonId string, args ...interface{}) error {
+	pending := &pendingDispatch{
+		call: xbmc.AddonCall{AddonId: addonId, Args: args},
+		err:  make(chan error, 1),
+	}
+
+	dispatchLock.Lock()
+	dispatchQueue = append(dispatchQueue, pending)
+	if len(dispatchQueue) == 1 {
+		time.AfterFunc(dispatchWindow, flushDispatchQueue)
+	}
+	dispatchLock.Unlock()
+
+	return <-pending.err
+}
+
+func flushDispatchQueue() {
+	dispatchLock.Lock()
+	queue := dispatchQueue
+	dispatchQueue = nil
+	dispatchLock.Unlock()
+
+	calls := make([]xbmc.AddonCall, 0, len(queue))
+	for _, pending := range queue {
+		calls = append(calls, pending.call)
+	}
+	log.Info("Dispatching %d provider calls", len(calls))
+	for i, err := range xbmc.ExecuteAddons(calls) {
+		queue[i].err <- err
+	}
+}
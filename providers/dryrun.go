@@ -0,0 +1,149 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+)
+
+// Decision is what became of a result along the search pipeline. Rank is 0
+// for the dropped ones.
+type Decision struct {
+	Name          string                     `json:"name"`
+	InfoHash      string                     `json:"info_hash"`
+	Provider      string                     `json:"provider"`
+	Score         *bittorrent.ScoreBreakdown `json:"score,omitempty"`
+	QualityFactor float64                    `json:"quality_factor"`
+	Seeds         int64                      `json:"seeds"`
+	SeedsVerified bool                       `json:"seeds_verified,omitempty"`
+	Rank          int                        `json:"rank"`
+	DroppedBy     string                     `json:"dropped_by,omitempty"`
+	Reason        string                     `json:"reason,omitempty"`
+}
+
+// SearchTrace is what a dry run decided. Dry runs don't share the searches
+// in flight, nor remember their results for the fallbacks.
+type SearchTrace struct {
+	Method    string      `json:"method"`
+	Providers []string    `json:"providers"`
+	Received  int         `json:"received"`
+	Results   int         `json:"results"`
+	Picked    *Decision   `json:"picked,omitempty"`
+	Decisions []*Decision `json:"decisions"`
+
+	decisions map[string]*Decision
+}
+
+func newSearchTrace(method string, providers []string) *SearchTrace {
+	return &SearchTrace{
+		Method:    method,
+		Providers: providers,
+		Decisions: make([]*Decision, 0),
+		decisions: make(map[string]*Decision),
+	}
+}
+
+func (trace *SearchTrace) decision(torrent *bittorrent.Torrent) *Decision {
+	key := torrent.InfoHash
+	if key == "" {
+		key = "name:" + torrent.Name
+	}
+	decision, ok := trace.decisions[key]
+	if ok == false {
+		decision = &Decision{
+			Name:     torrent.Name,
+			InfoHash: torrent.InfoHash,
+			Provider: torrent.Provider,
+		}
+		trace.decisions[key] = decision
+		trace.Decisions = append(trace.Decisions, decision)
+	}
+	return decision
+}
+
+// receive records the results as the providers returned them. The trace
+// methods do nothing on nil traces, that is outside of dry runs.
+func (trace *SearchTrace) receive(torrents []*bittorrent.Torrent) {
+	if trace == nil {
+		return
+	}
+	trace.Received = len(torrents)
+	for _, torrent := range torrents {
+		trace.decision(torrent)
+	}
+}
+
+// Drop records that a stage of the pipeline dropped the torrent, and why.
+func (trace *SearchTrace) Drop(torrent *bittorrent.Torrent, stage string, reason string) {
+	if trace == nil {
+		return
+	}
+	decision := trace.decision(torrent)
+	if decision.DroppedBy == "" {
+		decision.DroppedBy, decision.Reason = stage, reason
+	}
+}
+
+// Kept ranks the results that made it through the pipeline.
+func (trace *SearchTrace) Kept(torrents []*bittorrent.Torrent) {
+	if trace == nil {
+		return
+	}
+	for i, torrent := range torrents {
+		decision := trace.decision(torrent)
+		decision.Rank = i + 1
+		decision.Score = torrent.ScoreBreakdown()
+		decision.QualityFactor = QualityFactor(torrent)
+		decision.Seeds, decision.SeedsVerified = torrent.Seeds, torrent.SeedsVerified
+	}
+	trace.Results = len(torrents)
+}
+
+// Pick records the result that would be played.
+func (trace *SearchTrace) Pick(torrent *bittorrent.Torrent) {
+	if trace == nil {
+		return
+	}
+	trace.Picked = trace.decision(torrent)
+}
+
+func providerNames(searchers []interface{}) []string {
+	names := make([]string, 0, len(searchers))
+	for _, searcher := range searchers {
+		names = append(names, fmt.Sprint(searcher))
+	}
+	return names
+}
+
+// DryRunSearch runs a plain search through the whole pipeline, and returns
+// its trace along with the results. Callers filtering the results further
+// tell the trace, then call Kept.
+func DryRunSearch(ctx context.Context, searchers []Searcher, query string) (*SearchTrace, []*bittorrent.Torrent) {
+	all := make([]interface{}, 0, len(searchers))
+	for _, searcher := range searchers {
+		all = append(all, searcher)
+	}
+	trace := newSearchTrace("search", providerNames(all))
+	return trace, searchResults(ctx, searchers, query, trace)
+}
+
+func DryRunMovie(ctx context.Context, searchers []MovieSearcher, movie *tmdb.Movie) (*SearchTrace, []*bittorrent.Torrent) {
+	all := make([]interface{}, 0, len(searchers))
+	for _, searcher := range searchers {
+		all = append(all, searcher)
+	}
+	trace := newSearchTrace("search_movie", providerNames(all))
+	return trace, movieResults(ctx, searchers, movie, trace)
+}
+
+func DryRunEpisode(ctx context.Context, searchers []EpisodeSearcher, show *tvdb.Show, episode *tvdb.Episode) (*SearchTrace, []*bittorrent.Torrent) {
+	all := make([]interface{}, 0, len(searchers))
+	for _, searcher := range searchers {
+		all = append(all, searcher)
+	}
+	trace := newSearchTrace("search_episode", providerNames(all))
+	return trace, episodeResults(ctx, searchers, show, episode, trace)
+}
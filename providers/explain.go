@@ -0,0 +1,121 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/steeve/pulsar/bittorrent"
+)
+
+var ErrUnknownResult = errors.New("no recent search returned this result")
+
+// ResultAttributes are what we parsed out of the result, mostly from its
+// name.
+type ResultAttributes struct {
+	Resolution   string `json:"resolution"`
+	VideoCodec   string `json:"video_codec"`
+	AudioCodec   string `json:"audio_codec"`
+	RipType      string `json:"rip_type"`
+	SceneRating  string `json:"scene_rating"`
+	Language     string `json:"language"`
+	ReleaseGroup string `json:"release_group"`
+	SeasonPack   bool   `json:"season_pack"`
+	Size         int64  `json:"size"`
+	Seeds        int64  `json:"seeds"`
+	Peers        int64  `json:"peers"`
+}
+
+// ResultExplanation tells why a result is there, and where it ranks.
+type ResultExplanation struct {
+	Name          string                     `json:"name"`
+	InfoHash      string                     `json:"info_hash"`
+	Provider      string                     `json:"provider"`
+	Payload       json.RawMessage            `json:"payload"`
+	Attributes    *ResultAttributes          `json:"attributes"`
+	Score         *bittorrent.ScoreBreakdown `json:"score"`
+	QualityFactor float64                    `json:"quality_factor"`
+	Rank          int                        `json:"rank"`
+	Results       int                        `json:"results"`
+	Filters       []string                   `json:"filters"`
+}
+
+func nameOf(names []string, index int) string {
+	if index > 0 && index < len(names) {
+		return names[index]
+	}
+	return "unknown"
+}
+
+// ExplainResult breaks down a result of a recent search: what its provider
+// sent, what we made of it, its score and what the filters decided.
+func ExplainResult(infoHash string) (*ResultExplanation, error) {
+	torrent, key := FindResult(infoHash)
+	if torrent == nil {
+		return nil, ErrUnknownResult
+	}
+	sceneRating := "none"
+	if torrent.SceneRating > 0 {
+		sceneRating = strings.ToLower(nameOf(bittorrent.SceneRatings, torrent.SceneRating))
+	}
+	explanation := &ResultExplanation{
+		Name:     torrent.Name,
+		InfoHash: torrent.InfoHash,
+		Provider: torrent.Provider,
+		Payload:  torrent.Payload,
+		Attributes: &ResultAttributes{
+			Resolution:   nameOf(bittorrent.Resolutions, torrent.Resolution),
+			VideoCodec:   nameOf(bittorrent.Codecs, torrent.VideoCodec),
+			AudioCodec:   nameOf(bittorrent.Codecs, torrent.AudioCodec),
+			RipType:      nameOf(bittorrent.Rips, torrent.RipType),
+			SceneRating:  sceneRating,
+			Language:     torrent.Language,
+			ReleaseGroup: torrent.ReleaseGroup,
+			SeasonPack:   torrent.SeasonPack,
+			Size:         torrent.Size,
+			Seeds:        torrent.Seeds,
+			Peers:        torrent.Peers,
+		},
+		Score:         torrent.ScoreBreakdown(),
+		QualityFactor: QualityFactor(torrent),
+		Filters:       make([]string, 0),
+	}
+
+	alternatives := Alternatives(torrent.InfoHash)
+	explanation.Results = len(alternatives)
+	for i, alternative := range alternatives {
+		if alternative.InfoHash == torrent.InfoHash {
+			explanation.Rank = i + 1
+			break
+		}
+	}
+
+	if torrent.Blacklisted() {
+		explanation.Filters = append(explanation.Filters, "release group is blacklisted")
+	} else {
+		explanation.Filters = append(explanation.Filters, "release group is not blacklisted")
+	}
+	// plain searches aren't filtered
+	mediaType := ""
+	switch {
+	case strings.HasPrefix(key, "movie."):
+		mediaType = MediaMovie
+	case strings.HasPrefix(key, "episode."):
+		mediaType = MediaEpisode
+	}
+	if mediaType == "" {
+		explanation.Filters = append(explanation.Filters, "filtering rules don't apply to plain searches")
+	} else {
+		rules := CurrentFilterRules()
+		if reason := rules.rejects(mediaType, torrent, keywordsRe(rules.ExcludedKeywords)); reason != "" {
+			// the rules changed since the search
+			explanation.Filters = append(explanation.Filters, "filtering rules now reject it: "+reason)
+		} else {
+			explanation.Filters = append(explanation.Filters, "passes the filtering rules")
+		}
+	}
+	if torrent.SeasonPack {
+		explanation.Filters = append(explanation.Filters, "kept as a season pack containing the episode")
+	}
+	return explanation, nil
+}
@@ -0,0 +1,164 @@
+package providers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/profiles"
+	"github.com/steeve/pulsar/store"
+)
+
+const (
+	MediaMovie   = "movie"
+	MediaEpisode = "episode"
+
+	filtersBucket = "filters"
+	filtersKey    = "rules"
+)
+
+// FilterRules decide which results are shown at all. Sizes are in
+// megabytes, zero meaning no limit.
+type FilterRules struct {
+	MovieMinSize     int64    `json:"movie_min_size"`
+	MovieMaxSize     int64    `json:"movie_max_size"`
+	EpisodeMinSize   int64    `json:"episode_min_size"`
+	EpisodeMaxSize   int64    `json:"episode_max_size"`
+	RequiredKeywords []string `json:"required_keywords"`
+	ExcludedKeywords []string `json:"excluded_keywords"`
+	MaxResolution    string   `json:"max_resolution"`
+	Languages        []string `json:"languages"`
+}
+
+func settingsFilterRules() *FilterRules {
+	conf := config.Get()
+	return &FilterRules{
+		MovieMinSize:     int64(conf.FilterMovieMinSize),
+		MovieMaxSize:     int64(conf.FilterMovieMaxSize),
+		EpisodeMinSize:   int64(conf.FilterEpisodeMinSize),
+		EpisodeMaxSize:   int64(conf.FilterEpisodeMaxSize),
+		RequiredKeywords: conf.FilterRequiredKeywords,
+		ExcludedKeywords: conf.FilterExcludedKeywords,
+		MaxResolution:    conf.FilterMaxResolution,
+		Languages:        conf.FilterLanguages,
+	}
+}
+
+// CurrentFilterRules are the rules set through the API if any, else those
+// from the settings.
+func CurrentFilterRules() *FilterRules {
+	var rules *FilterRules
+	if err := profiles.Current().Bucket(filtersBucket).Get(filtersKey, &rules); err == nil && rules != nil {
+		return rules
+	}
+	return settingsFilterRules()
+}
+
+// SetFilterRules overrides the rules from the settings, nil going back to
+// them.
+func SetFilterRules(rules *FilterRules) error {
+	bucket := profiles.Current().Bucket(filtersBucket)
+	if rules == nil {
+		return bucket.Delete(filtersKey)
+	}
+	if err := rules.validate(); err != nil {
+		return err
+	}
+	return bucket.Set(filtersKey, rules, store.FOREVER)
+}
+
+func (rules *FilterRules) validate() error {
+	if rules.MaxResolution != "" && resolutionIndex(rules.MaxResolution) < 0 {
+		return fmt.Errorf("unknown resolution %s", rules.MaxResolution)
+	}
+	if rules.MovieMaxSize > 0 && rules.MovieMinSize > rules.MovieMaxSize {
+		return fmt.Errorf("movie min size is over the max size")
+	}
+	if rules.EpisodeMaxSize > 0 && rules.EpisodeMinSize > rules.EpisodeMaxSize {
+		return fmt.Errorf("episode min size is over the max size")
+	}
+	return nil
+}
+
+func resolutionIndex(resolution string) int {
+	for i, name := range bittorrent.Resolutions {
+		if name != "" && strings.EqualFold(name, resolution) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Keywords match whole words, so that "ts" doesn't exclude "ghosts".
+func keywordsRe(keywords []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(keywords))
+	for _, keyword := range keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			quoted = append(quoted, regexp.QuoteMeta(strings.ToLower(keyword)))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(^|[^a-z0-9])(` + strings.Join(quoted, "|") + `)([^a-z0-9]|$)`)
+}
+
+// Why the torrent doesn't pass the rules, or "" when it does. Unknown sizes
+// and resolutions pass.
+func (rules *FilterRules) rejects(mediaType string, torrent *bittorrent.Torrent, excluded *regexp.Regexp) string {
+	minSize, maxSize := rules.MovieMinSize, rules.MovieMaxSize
+	if mediaType == MediaEpisode {
+		minSize, maxSize = rules.EpisodeMinSize, rules.EpisodeMaxSize
+	}
+	if torrent.Size > 0 {
+		if minSize > 0 && torrent.Size < minSize*1024*1024 {
+			return "too small"
+		}
+		if maxSize > 0 && torrent.Size > maxSize*1024*1024 {
+			return "too big"
+		}
+	}
+	name := strings.ToLower(torrent.Name)
+	if excluded != nil && excluded.MatchString(name) {
+		return "excluded keyword"
+	}
+	// every required keyword must be there
+	for _, keyword := range rules.RequiredKeywords {
+		if keywordRe := keywordsRe([]string{keyword}); keywordRe != nil && keywordRe.MatchString(name) == false {
+			return "missing " + keyword
+		}
+	}
+	if max := resolutionIndex(rules.MaxResolution); max > 0 && torrent.Resolution > max {
+		return "resolution over " + rules.MaxResolution
+	}
+	if len(rules.Languages) > 0 && torrent.Language != "" {
+		for _, language := range rules.Languages {
+			if strings.EqualFold(strings.TrimSpace(language), torrent.Language) {
+				return ""
+			}
+		}
+		return "language " + torrent.Language
+	}
+	return ""
+}
+
+// filterResults drops the results that don't pass the current rules.
+func filterResults(mediaType string, torrents []*bittorrent.Torrent, trace *SearchTrace) []*bittorrent.Torrent {
+	rules := CurrentFilterRules()
+	excluded := keywordsRe(rules.ExcludedKeywords)
+	filtered := make([]*bittorrent.Torrent, 0, len(torrents))
+	for _, torrent := range torrents {
+		if reason := rules.rejects(mediaType, torrent, excluded); reason != "" {
+			log.Debug("Filtered %s: %s", torrent.Name, reason)
+			trace.Drop(torrent, "filters", reason)
+			continue
+		}
+		filtered = append(filtered, torrent)
+	}
+	if len(filtered) < len(torrents) {
+		log.Info("Filtering rules removed %d results", len(torrents)-len(filtered))
+	}
+	return filtered
+}
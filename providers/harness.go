@@ -0,0 +1,224 @@
+package providers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+)
+
+// Well known titles every general purpose provider should find.
+const (
+	testMovieIMDBId  = "tt0111161" // The Shawshank Redemption
+	testShowTVDBId   = "81189"     // Breaking Bad
+	testShowSeason   = 1
+	testShowEpisode  = 1
+	testQuery        = "big buck bunny"
+	testMaxSchemaErr = 20
+)
+
+// What the harness can run.
+const (
+	TestMovie   = "movie"
+	TestEpisode = "episode"
+	TestSearch  = "search"
+)
+
+var ErrUnknownTest = errors.New("unknown test, try movie, episode or search")
+
+// FilteredResult is a valid result a real search wouldn't keep.
+type FilteredResult struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+type MethodReport struct {
+	Method        string            `json:"method"`
+	Duration      int64             `json:"duration_ms"`
+	Count         int               `json:"count"`
+	Valid         int               `json:"valid"`
+	NotApplicable bool              `json:"not_applicable"`
+	Error         string            `json:"error,omitempty"`
+	SchemaErrors  []*SchemaError    `json:"schema_errors,omitempty"`
+	Warnings      []*SchemaError    `json:"warnings,omitempty"`
+	Filtered      []*FilteredResult `json:"filtered,omitempty"`
+	Payload       interface{}       `json:"payload,omitempty"`
+	Results       json.RawMessage   `json:"results,omitempty"`
+}
+
+type TestReport struct {
+	Provider string          `json:"provider"`
+	Health   ProviderHealth  `json:"health"`
+	Methods  []*MethodReport `json:"methods"`
+}
+
+// TestOptions replace the canned titles, zero values keeping them.
+type TestOptions struct {
+	IMDBId  string
+	TVDBId  string
+	Season  int
+	Episode int
+	Query   string
+	// include what was sent and the raw results in the reports
+	Raw bool
+}
+
+func truncateErrors(errs []*SchemaError) []*SchemaError {
+	if len(errs) > testMaxSchemaErr {
+		return errs[:testMaxSchemaErr]
+	}
+	return errs
+}
+
+// filterReason tells why a real search would drop a valid result.
+func filterReason(mediaType string, torrent *bittorrent.Torrent, epSearchObject *EpisodeSearchObject) string {
+	if epSearchObject != nil && matchesEpisode(torrent, epSearchObject) == false {
+		return "name doesn't match the episode"
+	}
+	if torrent.Blacklisted() {
+		return "release group is blacklisted"
+	}
+	if mediaType == "" {
+		return ""
+	}
+	rules := CurrentFilterRules()
+	return rules.rejects(mediaType, torrent, keywordsRe(rules.ExcludedKeywords))
+}
+
+func (as *AddonSearcher) testMethod(method string, mediaType string, searchObject interface{}, options *TestOptions) *MethodReport {
+	report := &MethodReport{Method: method}
+	if options.Raw {
+		report.Payload = searchObject
+	}
+	start := time.Now()
+	body, err := as.callRaw(method, searchObject)
+	report.Duration = int64(time.Now().Sub(start) / time.Millisecond)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	var parsed interface{}
+	if options.Raw && json.Unmarshal(body, &parsed) == nil {
+		report.Results = body
+	}
+
+	notApplicable := NotApplicableResponse{}
+	if json.Unmarshal(body, &notApplicable) == nil && notApplicable.NotApplicable {
+		report.NotApplicable = true
+		return report
+	}
+	valid, schemaErrors, err := validateResults(body)
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Count = len(valid)
+	report.SchemaErrors = truncateErrors(schemaErrors)
+
+	var raw []json.RawMessage
+	json.Unmarshal(body, &raw)
+	warnings := make([]*SchemaError, 0)
+	epSearchObject, _ := searchObject.(*EpisodeSearchObject)
+	for i, ok := range valid {
+		if ok == false || i >= len(raw) {
+			continue
+		}
+		report.Valid++
+		fields := map[string]interface{}{}
+		json.Unmarshal(raw[i], &fields)
+		warnings = append(warnings, missingFields(i, fields)...)
+
+		torrent := &bittorrent.Torrent{}
+		if err := json.Unmarshal(normalizeResult(raw[i]), torrent); err != nil {
+			continue
+		}
+		if reason := filterReason(mediaType, torrent, epSearchObject); reason != "" {
+			report.Filtered = append(report.Filtered, &FilteredResult{Index: i, Name: torrent.Name, Reason: reason})
+		}
+	}
+	report.Warnings = truncateErrors(warnings)
+	return report
+}
+
+func (as *AddonSearcher) testMovie(options *TestOptions) (*MethodReport, error) {
+	imdbId := options.IMDBId
+	if imdbId == "" {
+		imdbId = testMovieIMDBId
+	}
+	movie := tmdb.GetMovieFromIMDB(imdbId, config.Get().Language)
+	if movie == nil {
+		return nil, fmt.Errorf("movie %s not found", imdbId)
+	}
+	return as.testMethod("search_movie", MediaMovie, as.GetMovieSearchObject(movie), options), nil
+}
+
+func (as *AddonSearcher) testEpisode(options *TestOptions) (*MethodReport, error) {
+	tvdbId, season, episodeNumber := options.TVDBId, options.Season, options.Episode
+	if tvdbId == "" {
+		tvdbId, season, episodeNumber = testShowTVDBId, testShowSeason, testShowEpisode
+	}
+	if season <= 0 {
+		season = 1
+	}
+	if episodeNumber <= 0 {
+		episodeNumber = 1
+	}
+	notFound := fmt.Errorf("episode %dx%02d of %s not found", season, episodeNumber, tvdbId)
+	show, err := tvdb.NewShowCached(tvdbId, config.Get().Language)
+	if err != nil || len(show.Seasons) <= season {
+		return nil, notFound
+	}
+	episodes := show.Seasons[season].Episodes
+	if len(episodes) < episodeNumber {
+		return nil, notFound
+	}
+	return as.testMethod("search_episode", MediaEpisode, as.GetEpisodeSearchObject(show, episodes[episodeNumber-1]), options), nil
+}
+
+func (as *AddonSearcher) testSearch(options *TestOptions) (*MethodReport, error) {
+	query := options.Query
+	if query == "" {
+		query = testQuery
+	}
+	return as.testMethod("search", "", query, options), nil
+}
+
+// TestProvider runs canned movie and episode searches against a single
+// provider, for provider authors and troubleshooting.
+func TestProvider(addonId string) *TestReport {
+	as := NewAddonSearcher(addonId)
+	report := &TestReport{
+		Provider: addonId,
+		Methods:  make([]*MethodReport, 0),
+	}
+	options := &TestOptions{}
+	if method, err := as.testMovie(options); err == nil {
+		report.Methods = append(report.Methods, method)
+	}
+	if method, err := as.testEpisode(options); err == nil {
+		report.Methods = append(report.Methods, method)
+	}
+	report.Health = GetProviderHealth(addonId)
+	return report
+}
+
+// RunProviderTest runs one search against the provider, reporting what was
+// sent, the raw results and what's wrong with them.
+func RunProviderTest(addonId string, test string, options *TestOptions) (*MethodReport, error) {
+	as := NewAddonSearcher(addonId)
+	switch test {
+	case TestMovie:
+		return as.testMovie(options)
+	case TestEpisode:
+		return as.testEpisode(options)
+	case TestSearch:
+		return as.testSearch(options)
+	}
+	return nil, ErrUnknownTest
+}
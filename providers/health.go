@@ -0,0 +1,246 @@
+package providers
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/clock"
+	"github.com/steeve/pulsar/config"
+)
+
+const (
+	// searches needed before the timeout adapts to the provider
+	adaptiveMinSearches = 5
+	// the adapted timeout gives providers this many times their usual latency
+	adaptiveLatencyFactor = 3
+	// and never goes under this fraction of the configured timeout
+	adaptiveMinFraction = 4
+
+	defaultMaxFailures = 5
+	disableDuration    = 30 * time.Minute
+)
+
+type ProviderHealth struct {
+	Violations    int       `json:"violations"`
+	LastViolation string    `json:"last_violation"`
+	LastSeen      time.Time `json:"last_seen"`
+
+	InvalidResults int            `json:"invalid_results"`
+	FieldErrors    map[string]int `json:"field_errors,omitempty"`
+
+	Searches            int           `json:"searches"`
+	Successes           int           `json:"successes"`
+	Timeouts            int           `json:"timeouts"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	TotalLatency        time.Duration `json:"-"`
+	TotalResults        int           `json:"-"`
+	DisabledUntil       time.Time     `json:"disabled_until"`
+}
+
+// The average latency of the successful searches.
+func (h *ProviderHealth) AverageLatency() time.Duration {
+	if h.Successes == 0 {
+		return 0
+	}
+	return h.TotalLatency / time.Duration(h.Successes)
+}
+
+func (h *ProviderHealth) AverageResults() float64 {
+	if h.Successes == 0 {
+		return 0
+	}
+	return float64(h.TotalResults) / float64(h.Successes)
+}
+
+func (h *ProviderHealth) SuccessRate() float64 {
+	if h.Searches == 0 {
+		return 1
+	}
+	return float64(h.Successes) / float64(h.Searches)
+}
+
+func (h *ProviderHealth) Disabled() bool {
+	return clock.Now().Before(h.DisabledUntil)
+}
+
+// ProviderStatus is the health of a provider along with the computed stats.
+type ProviderStatus struct {
+	Provider string `json:"provider"`
+	ProviderHealth
+	AverageLatency int64   `json:"average_latency_ms"`
+	AverageResults float64 `json:"average_results"`
+	SuccessRate    float64 `json:"success_rate"`
+	Disabled       bool    `json:"disabled"`
+}
+
+var healthLock = sync.RWMutex{}
+var health = map[string]*ProviderHealth{}
+
+func getHealth(addonId string) *ProviderHealth {
+	h, ok := health[addonId]
+	if !ok {
+		h = &ProviderHealth{}
+		health[addonId] = h
+	}
+	return h
+}
+
+func recordViolation(addonId string, reason string) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+
+	h := getHealth(addonId)
+	h.Violations++
+	h.LastViolation = reason
+	h.LastSeen = time.Now()
+}
+
+// Counts the invalid results of a provider, by field.
+func recordSchemaErrors(addonId string, invalid int, errs []*SchemaError) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+
+	h := getHealth(addonId)
+	h.InvalidResults += invalid
+	if h.FieldErrors == nil {
+		h.FieldErrors = make(map[string]int)
+	}
+	for _, err := range errs {
+		h.FieldErrors[err.Field]++
+	}
+	h.LastSeen = time.Now()
+}
+
+func GetProviderHealth(addonId string) ProviderHealth {
+	healthLock.RLock()
+	defer healthLock.RUnlock()
+
+	if h, ok := health[addonId]; ok {
+		copied := *h
+		copied.FieldErrors = make(map[string]int, len(h.FieldErrors))
+		for field, count := range h.FieldErrors {
+			copied.FieldErrors[field] = count
+		}
+		return copied
+	}
+	return ProviderHealth{}
+}
+
+func maxFailures() int {
+	if max := config.Get().ProviderMaxFailures; max > 0 {
+		return max
+	}
+	return defaultMaxFailures
+}
+
+// recordSearch keeps the stats of a search. Providers failing too many times
+// in a row are disabled for a while.
+func recordSearch(addonId string, latency time.Duration, results int, err error) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+
+	h := getHealth(addonId)
+	h.Searches++
+	h.LastSeen = time.Now()
+	if err != nil {
+		if err == errProviderTimeout {
+			h.Timeouts++
+		}
+		h.ConsecutiveFailures++
+		if h.ConsecutiveFailures >= maxFailures() && h.Disabled() == false {
+			log.Warning("Provider %s failed %d times in a row, disabling it for %s", addonId, h.ConsecutiveFailures, disableDuration)
+			h.DisabledUntil = clock.Now().Add(disableDuration)
+		}
+		return
+	}
+	h.Successes++
+	h.ConsecutiveFailures = 0
+	h.TotalLatency += latency
+	h.TotalResults += results
+}
+
+// providerTimeoutFor shortens the timeout of providers that usually answer
+// quickly, so a stuck one doesn't hold the whole search.
+func providerTimeoutFor(addonId string, method string) time.Duration {
+	if timeout := providerTimeoutSetting(addonId); timeout > 0 {
+		return timeout
+	}
+	timeout := methodTimeout(method)
+	if config.Get().ProviderAdaptiveTimeouts == false {
+		return timeout
+	}
+	healthLock.RLock()
+	defer healthLock.RUnlock()
+	h, ok := health[addonId]
+	if !ok || h.Successes < adaptiveMinSearches {
+		return timeout
+	}
+	adapted := adaptiveLatencyFactor * h.AverageLatency()
+	if min := timeout / adaptiveMinFraction; adapted < min {
+		adapted = min
+	}
+	if adapted < timeout {
+		return adapted
+	}
+	return timeout
+}
+
+func providerDisabled(addonId string) bool {
+	healthLock.RLock()
+	defer healthLock.RUnlock()
+	h, ok := health[addonId]
+	return ok && h.Disabled()
+}
+
+// EnableProvider re-enables a disabled provider, or all of them when
+// addonId is empty.
+func EnableProvider(addonId string) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+
+	for id, h := range health {
+		if addonId == "" || id == addonId {
+			h.DisabledUntil = time.Time{}
+			h.ConsecutiveFailures = 0
+		}
+	}
+}
+
+// ProvidersStatus returns the stats of every provider we searched with.
+func ProvidersStatus() []*ProviderStatus {
+	healthLock.RLock()
+	ids := make([]string, 0, len(health))
+	for id := range health {
+		ids = append(ids, id)
+	}
+	healthLock.RUnlock()
+	sort.Strings(ids)
+
+	status := make([]*ProviderStatus, 0, len(ids))
+	for _, id := range ids {
+		h := GetProviderHealth(id)
+		status = append(status, &ProviderStatus{
+			Provider:       id,
+			ProviderHealth: h,
+			AverageLatency: int64(h.AverageLatency() / time.Millisecond),
+			AverageResults: h.AverageResults(),
+			SuccessRate:    h.SuccessRate(),
+			Disabled:       h.Disabled(),
+		})
+	}
+	return status
+}
+
+// DecayHealth forgives one violation to every provider, so that a provider
+// that misbehaved once eventually gets a clean slate.
+func DecayHealth() {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+
+	for _, h := range health {
+		if h.Violations > 0 {
+			h.Violations--
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package providers
+
+import (
+	"time"
+
+	"github.com/steeve/pulsar/store"
+)
+
+const (
+	healthBucket = "health"
+	healthKey    = "providers"
+)
+
+// storedHealth keeps the totals the API doesn't show.
+type storedHealth struct {
+	*ProviderHealth
+	TotalLatency time.Duration `json:"total_latency"`
+	TotalResults int           `json:"total_results"`
+}
+
+// LoadHealth gets the health of the providers back from before the restart,
+// so that the adapted timeouts and the disabled providers stay.
+func LoadHealth() {
+	var stored map[string]*storedHealth
+	if err := store.Global(healthBucket).Get(healthKey, &stored); err != nil {
+		return
+	}
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	for addonId, s := range stored {
+		if s == nil || s.ProviderHealth == nil {
+			continue
+		}
+		s.ProviderHealth.TotalLatency = s.TotalLatency
+		s.ProviderHealth.TotalResults = s.TotalResults
+		health[addonId] = s.ProviderHealth
+	}
+	log.Info("Loaded the health of %d providers", len(stored))
+}
+
+func SaveHealth() error {
+	healthLock.RLock()
+	stored := make(map[string]*storedHealth, len(health))
+	for addonId, h := range health {
+		copied := *h
+		copied.FieldErrors = make(map[string]int, len(h.FieldErrors))
+		for field, count := range h.FieldErrors {
+			copied.FieldErrors[field] = count
+		}
+		stored[addonId] = &storedHealth{
+			ProviderHealth: &copied,
+			TotalLatency:   h.TotalLatency,
+			TotalResults:   h.TotalResults,
+		}
+	}
+	healthLock.RUnlock()
+	return store.Global(healthBucket).Set(healthKey, stored, store.FOREVER)
+}
@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+)
+
+// methodTimeout returns how long we wait for providers for the given search
+// method, since episode searches usually need to be faster than movies.
+func methodTimeout(method string) time.Duration {
+	conf := config.Get()
+	if conf.CustomProviderTimeoutEnabled == false {
+		return providerTimeout()
+	}
+	seconds := 0
+	switch method {
+	case "search_movie":
+		seconds = conf.MovieProviderTimeout
+	case "search_episode":
+		seconds = conf.EpisodeProviderTimeout
+	case "search":
+		seconds = conf.SearchProviderTimeout
+	}
+	if seconds <= 0 {
+		// the single timeout setting of before
+		seconds = conf.CustomProviderTimeout
+	}
+	if seconds <= 0 {
+		return providerTimeout()
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// methodMaxResults returns how many torrents we keep at most for the given
+// search method, 0 meaning no limit.
+func methodMaxResults(method string) int {
+	conf := config.Get()
+	switch method {
+	case "search_movie":
+		return conf.MaxMovieResults
+	case "search_episode":
+		return conf.MaxEpisodeResults
+	case "search":
+		return conf.MaxSearchResults
+	}
+	return 0
+}
+
+// limitResults keeps the best scored torrents, in the order they came in,
+// which the anime preferences may have changed.
+func limitResults(method string, torrents []*bittorrent.Torrent, trace *SearchTrace) []*bittorrent.Torrent {
+	max := methodMaxResults(method)
+	if max <= 0 || len(torrents) <= max {
+		return torrents
+	}
+	log.Info("Keeping the %d best results out of %d", max, len(torrents))
+	ranked := make([]*bittorrent.Torrent, len(torrents))
+	copy(ranked, torrents)
+	bittorrent.SortByScore(ranked)
+	best := make(map[*bittorrent.Torrent]bool, max)
+	for _, torrent := range ranked[:max] {
+		best[torrent] = true
+	}
+	kept := make([]*bittorrent.Torrent, 0, max)
+	for _, torrent := range torrents {
+		if best[torrent] {
+			kept = append(kept, torrent)
+		} else {
+			trace.Drop(torrent, "limits", fmt.Sprintf("over the %d results kept", max))
+		}
+	}
+	return kept
+}
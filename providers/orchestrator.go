@@ -0,0 +1,109 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+)
+
+// The results of one provider, sent as soon as it answers.
+type PartialResults struct {
+	Provider string                `json:"provider"`
+	Torrents []*bittorrent.Torrent `json:"torrents"`
+}
+
+// SearchRun is a search running on all the providers at once.
+type SearchRun struct {
+	Results <-chan *PartialResults
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Cancel stops waiting for the providers that haven't answered yet, and
+// tells those still searching to stop.
+func (run *SearchRun) Cancel() {
+	run.cancel()
+}
+
+// Searchers that can give up on a search when its context is done.
+type cancelable interface {
+	withContext(ctx context.Context) interface{}
+}
+
+func providerName(searcher interface{}) string {
+	if stringer, ok := searcher.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", searcher)
+}
+
+// fanOut runs the search on all the searchers, until ctx is done or the
+// run is cancelled.
+func fanOut(ctx context.Context, searchers []interface{}, search func(searcher interface{}) []*bittorrent.Torrent) *SearchRun {
+	results := make(chan *PartialResults)
+	run := &SearchRun{
+		Results: results,
+	}
+	run.ctx, run.cancel = context.WithCancel(ctx)
+	go func() {
+		wg := sync.WaitGroup{}
+		for _, searcher := range searchers {
+			if c, ok := searcher.(cancelable); ok {
+				searcher = c.withContext(run.ctx)
+			}
+			wg.Add(1)
+			go func(searcher interface{}) {
+				defer wg.Done()
+				partial := &PartialResults{
+					Provider: providerName(searcher),
+					Torrents: search(searcher),
+				}
+				select {
+				case results <- partial:
+				case <-run.ctx.Done():
+				}
+			}(searcher)
+		}
+		wg.Wait()
+		close(results)
+		run.cancel()
+	}()
+	return run
+}
+
+// All the torrents of the run, as they come.
+func (run *SearchRun) torrents() chan *bittorrent.Torrent {
+	torrentsChan := make(chan *bittorrent.Torrent)
+	go func() {
+		defer close(torrentsChan)
+		for partial := range run.Results {
+			for _, torrent := range partial.Torrents {
+				torrentsChan <- torrent
+			}
+		}
+	}()
+	return torrentsChan
+}
+
+func StreamSearch(ctx context.Context, searchers []Searcher, query string) *SearchRun {
+	return fanOut(ctx, searcherList(searchers), func(searcher interface{}) []*bittorrent.Torrent {
+		return searcher.(Searcher).SearchLinks(query)
+	})
+}
+
+func StreamMovie(ctx context.Context, searchers []MovieSearcher, movie *tmdb.Movie) *SearchRun {
+	return fanOut(ctx, searcherList(searchers), func(searcher interface{}) []*bittorrent.Torrent {
+		return searcher.(MovieSearcher).SearchMovieLinks(movie)
+	})
+}
+
+func StreamEpisode(ctx context.Context, searchers []EpisodeSearcher, show *tvdb.Show, episode *tvdb.Episode) *SearchRun {
+	return fanOut(ctx, searcherList(searchers), func(searcher interface{}) []*bittorrent.Torrent {
+		return searcher.(EpisodeSearcher).SearchEpisodeLinks(show, episode)
+	})
+}
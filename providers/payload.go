@@ -1,31 +1,68 @@
 package providers
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 )
 
+// Providers must send back HMAC-SHA256(Secret, body) in this header
+// when calling CallbackURL.
+const SignatureHeader = "X-Pulsar-Signature"
+
 type SearchPayload struct {
 	Method       string      `json:"method"`
 	CallbackURL  string      `json:"callback_url"`
+	CancelURL    string      `json:"cancel_url"` // GET, 410 once we no longer wait for the results
+	Secret       string      `json:"secret"`
 	SearchObject interface{} `json:"search_object"`
 }
 
 type MovieSearchObject struct {
-	IMDBId string            `json:"imdb_id"`
-	Title  string            `json:"title"`
-	Year   int               `json:"year"`
-	Titles map[string]string `json:"titles"`
+	IMDBId           string            `json:"imdb_id"`
+	Title            string            `json:"title"`
+	Year             int               `json:"year"`
+	Titles           map[string]string `json:"titles"`
+	OriginalTitle    string            `json:"original_title"`
+	OriginalLanguage string            `json:"original_language"`
+	Runtime          int               `json:"runtime"` // in minutes
+	Genres           []string          `json:"genres"`
+	Keywords         []string          `json:"keywords"`
+	Languages        []string          `json:"languages"` // the preferred ones the provider has releases in
 }
 
 type EpisodeSearchObject struct {
-	IMDBId         string            `json:"imdb_id"`
-	TVDBId         int               `json:"tvdb_id"`
-	Title          string            `json:"title"`
-	Season         int               `json:"season"`
-	Episode        int               `json:"episode"`
-	Titles         map[string]string `json:"titles"`
-	AbsoluteNumber int               `json:"absolute_number"`
+	IMDBId           string            `json:"imdb_id"`
+	TVDBId           int               `json:"tvdb_id"`
+	Title            string            `json:"title"`
+	Season           int               `json:"season"`
+	Episode          int               `json:"episode"`
+	Titles           map[string]string `json:"titles"`
+	OriginalTitle    string            `json:"original_title"`
+	OriginalLanguage string            `json:"original_language"`
+	AbsoluteNumber   int               `json:"absolute_number"`
+	AniDBId          int               `json:"anidb_id"`
+	AniDBEpisode     int               `json:"anidb_episode"` // as fansubs number split seasons
+	Anime            bool              `json:"anime"`
+	ShowRuntime      int               `json:"show_runtime"`    // in minutes
+	EpisodeRuntime   int               `json:"episode_runtime"` // in minutes
+	EpisodeCount     int               `json:"episode_count"`
+	AirDate          string            `json:"air_date"` // as 2006-01-02, for daily shows
+	Network          string            `json:"network"`
+	Genres           []string          `json:"genres"`
+	Keywords         []string          `json:"keywords"`
+	Languages        []string          `json:"languages"`
+}
+
+// Providers that can't do anything with a search (e.g. an anime tracker
+// asked for a documentary) should answer with this instead of a list of
+// torrents, so that we don't wait for them.
+type NotApplicableResponse struct {
+	NotApplicable bool   `json:"not_applicable"`
+	Reason        string `json:"reason"`
 }
 
 func (sp *SearchPayload) String() string {
@@ -35,3 +72,27 @@ func (sp *SearchPayload) String() string {
 	}
 	return base64.StdEncoding.EncodeToString(b)
 }
+
+func newSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func signBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func checkSignature(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signBody(secret, body))
+	if err != nil {
+		return false
+	}
+	actual, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, actual)
+}
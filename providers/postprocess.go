@@ -0,0 +1,212 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// Users can drop and rank results with a Starlark script, in the profile
+// or wherever the settings say, defining
+//
+//	def process(result, media):
+//	    if media.show == "Doctor Who" and result.release_group == "FoV":
+//	        return 50
+//
+// It returns False to drop the result, a number to add to its score, or
+// None to leave it be. Scripts can't touch the disk or the network, and are
+// stopped after postProcessMaxSteps.
+const (
+	postProcessFile     = "postprocess.star"
+	postProcessFunction = "process"
+	postProcessMaxSteps = 100000 // per result
+)
+
+// scriptMedia is what was searched, the media argument of the script.
+type scriptMedia struct {
+	Type    string
+	Title   string
+	Year    int
+	IMDBId  string
+	Show    string
+	Season  int
+	Episode int
+	Query   string
+}
+
+type postProcessor struct {
+	path    string
+	modTime time.Time
+	process starlark.Callable
+	err     error
+}
+
+var (
+	postProcessorLock = sync.Mutex{}
+	postProcessorLast *postProcessor
+)
+
+func movieMedia(movie *tmdb.Movie) *scriptMedia {
+	year, _ := strconv.Atoi(strings.Split(movie.ReleaseDate, "-")[0])
+	return &scriptMedia{
+		Type:   MediaMovie,
+		Title:  movie.Title,
+		Year:   year,
+		IMDBId: movie.IMDBId,
+	}
+}
+
+func episodeMedia(show *tvdb.Show, episode *tvdb.Episode) *scriptMedia {
+	return &scriptMedia{
+		Type:    MediaEpisode,
+		Title:   episode.EpisodeName,
+		IMDBId:  show.ImdbId,
+		Show:    show.SeriesName,
+		Season:  episode.SeasonNumber,
+		Episode: episode.EpisodeNumber,
+	}
+}
+
+func (media *scriptMedia) value() starlark.Value {
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"type":    starlark.String(media.Type),
+		"title":   starlark.String(media.Title),
+		"year":    starlark.MakeInt(media.Year),
+		"imdb_id": starlark.String(media.IMDBId),
+		"show":    starlark.String(media.Show),
+		"season":  starlark.MakeInt(media.Season),
+		"episode": starlark.MakeInt(media.Episode),
+		"query":   starlark.String(media.Query),
+	})
+}
+
+func resultValue(torrent *bittorrent.Torrent) starlark.Value {
+	group := torrent.ReleaseGroup
+	if group == "" {
+		group = bittorrent.ReleaseGroup(torrent.Name)
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"name":          starlark.String(torrent.Name),
+		"info_hash":     starlark.String(torrent.InfoHash),
+		"size":          starlark.MakeInt64(torrent.Size),
+		"seeds":         starlark.MakeInt64(torrent.Seeds),
+		"peers":         starlark.MakeInt64(torrent.Peers),
+		"private":       starlark.Bool(torrent.IsPrivate),
+		"resolution":    starlark.String(nameOf(bittorrent.Resolutions, torrent.Resolution)),
+		"video_codec":   starlark.String(nameOf(bittorrent.Codecs, torrent.VideoCodec)),
+		"audio_codec":   starlark.String(nameOf(bittorrent.Codecs, torrent.AudioCodec)),
+		"rip_type":      starlark.String(nameOf(bittorrent.Rips, torrent.RipType)),
+		"scene_rating":  starlark.String(nameOf(bittorrent.SceneRatings, torrent.SceneRating)),
+		"language":      starlark.String(torrent.Language),
+		"release_group": starlark.String(group),
+		"season_pack":   starlark.Bool(torrent.SeasonPack),
+		"provider":      starlark.String(torrent.Provider),
+		"provider_name": starlark.String(torrent.ProviderName),
+		"score":         starlark.Float(torrent.Score()),
+	})
+}
+
+func postProcessPath() (string, bool) {
+	conf := config.Get()
+	if conf.PostProcessScript != "" {
+		return conf.PostProcessScript, true
+	}
+	return filepath.Join(conf.ProfilePath, postProcessFile), false
+}
+
+func scriptPrint(thread *starlark.Thread, msg string) {
+	log.Info("%s: %s", thread.Name, msg)
+}
+
+// loadPostProcessor compiles the script again when it changed, and returns
+// nil when there's none.
+func loadPostProcessor() (starlark.Callable, error) {
+	path, configured := postProcessPath()
+	info, err := os.Stat(path)
+	if err != nil {
+		if configured {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	postProcessorLock.Lock()
+	defer postProcessorLock.Unlock()
+	if last := postProcessorLast; last != nil && last.path == path && last.modTime.Equal(info.ModTime()) {
+		return last.process, last.err
+	}
+
+	log.Info("Loading the post-processing script %s", path)
+	loaded := &postProcessor{path: path, modTime: info.ModTime()}
+	thread := &starlark.Thread{Name: filepath.Base(path), Print: scriptPrint}
+	globals, err := starlark.ExecFile(thread, path, nil, nil)
+	if err != nil {
+		loaded.err = err
+	} else if process, ok := globals[postProcessFunction].(starlark.Callable); ok {
+		// frozen, the functions can run on several searches at once
+		globals.Freeze()
+		loaded.process = process
+	} else {
+		loaded.err = fmt.Errorf("%s doesn't define %s(result, media)", path, postProcessFunction)
+	}
+	postProcessorLast = loaded
+	return loaded.process, loaded.err
+}
+
+// postProcess runs the user's script on every result, dropping and ranking
+// them again as it says. Results the script fails on are kept as is.
+func postProcess(media *scriptMedia, torrents []*bittorrent.Torrent, trace *SearchTrace) []*bittorrent.Torrent {
+	process, err := loadPostProcessor()
+	if err != nil {
+		log.Error("Unable to load the post-processing script: %s", err)
+		return torrents
+	}
+	if process == nil {
+		return torrents
+	}
+
+	mediaValue := media.value()
+	kept := make([]*bittorrent.Torrent, 0, len(torrents))
+	rescored := 0
+	for _, torrent := range torrents {
+		thread := &starlark.Thread{Name: postProcessFile, Print: scriptPrint}
+		thread.SetMaxExecutionSteps(postProcessMaxSteps)
+		result, err := starlark.Call(thread, process, starlark.Tuple{resultValue(torrent), mediaValue}, nil)
+		if err != nil {
+			log.Warning("Post-processing script failed on %s: %s", torrent.Name, err)
+			kept = append(kept, torrent)
+			continue
+		}
+		switch result.(type) {
+		case starlark.NoneType:
+		case starlark.Bool:
+			if result == starlark.False {
+				log.Debug("Post-processing script dropped %s", torrent.Name)
+				trace.Drop(torrent, "script", "dropped by the post-processing script")
+				continue
+			}
+		case starlark.Int, starlark.Float:
+			torrent.ScriptScore, _ = starlark.AsFloat(result)
+			rescored++
+		default:
+			log.Warning("Post-processing script returned a %s for %s, expected None, a bool or a number", result.Type(), torrent.Name)
+		}
+		kept = append(kept, torrent)
+	}
+	if rescored > 0 {
+		bittorrent.SortByScore(kept)
+	}
+	log.Info("Post-processing script dropped %d results and scored %d", len(torrents)-len(kept), rescored)
+	return kept
+}
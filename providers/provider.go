@@ -1,6 +1,8 @@
 package providers
 
 import (
+	"sync"
+
 	"github.com/steeve/pulsar/bittorrent"
 	"github.com/steeve/pulsar/tmdb"
 	"github.com/steeve/pulsar/tvdb"
@@ -17,3 +19,32 @@ type MovieSearcher interface {
 type EpisodeSearcher interface {
 	SearchEpisodeLinks(show *tvdb.Show, episode *tvdb.Episode) []*bittorrent.Torrent
 }
+
+var nativeLock = sync.RWMutex{}
+var natives = make([]Searcher, 0)
+
+// Register adds a provider compiled in Pulsar, usually from the init() of
+// its package. It can also implement MovieSearcher and EpisodeSearcher, and
+// fmt.Stringer for its name. Native providers are searched along with the
+// addon ones, and don't need XBMC.
+func Register(searcher Searcher) {
+	nativeLock.Lock()
+	defer nativeLock.Unlock()
+	natives = append(natives, searcher)
+}
+
+// Native providers that aren't always searched, such as those standing in
+// for the addon ones until one is installed.
+type optionalSearcher interface {
+	Searched(addons int) bool
+}
+
+func nativeSearchers() []interface{} {
+	nativeLock.RLock()
+	defer nativeLock.RUnlock()
+	list := make([]interface{}, 0, len(natives))
+	for _, searcher := range natives {
+		list = append(list, searcher)
+	}
+	return list
+}
@@ -0,0 +1,97 @@
+package providers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/clock"
+)
+
+const (
+	recentResultsTTL = 3 * time.Hour
+)
+
+type recentResult struct {
+	torrents []*bittorrent.Torrent
+	expires  time.Time
+}
+
+var recentResultsLock = sync.Mutex{}
+var recentResults = map[string]*recentResult{}
+
+// Keeps the results of the last searches around, so that we can find
+// alternatives to what is playing without asking the providers again.
+func rememberResults(key string, torrents []*bittorrent.Torrent) {
+	recentResultsLock.Lock()
+	defer recentResultsLock.Unlock()
+
+	now := clock.Now()
+	for k, result := range recentResults {
+		if now.After(result.expires) {
+			delete(recentResults, k)
+		}
+	}
+	recentResults[key] = &recentResult{
+		torrents: copyTorrents(torrents),
+		expires:  now.Add(recentResultsTTL),
+	}
+}
+
+// Returns the results of the recent search that returned infoHash.
+func Alternatives(infoHash string) []*bittorrent.Torrent {
+	recentResultsLock.Lock()
+	defer recentResultsLock.Unlock()
+
+	for _, result := range recentResults {
+		for _, torrent := range result.torrents {
+			if torrent.InfoHash == infoHash {
+				return copyTorrents(result.torrents)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the best result of the same search with a lower resolution than
+// infoHash, or nil if there is none.
+func LowerQuality(infoHash string) *bittorrent.Torrent {
+	alternatives := Alternatives(infoHash)
+	current := bittorrent.ResolutionUnkown
+	for _, torrent := range alternatives {
+		if torrent.InfoHash == infoHash {
+			current = torrent.Resolution
+		}
+	}
+	if current == bittorrent.ResolutionUnkown {
+		return nil
+	}
+
+	var best *bittorrent.Torrent
+	for _, torrent := range alternatives {
+		if torrent.Resolution == bittorrent.ResolutionUnkown || torrent.Resolution >= current || torrent.Seeds == 0 {
+			continue
+		}
+		if best == nil || QualityFactor(torrent) > QualityFactor(best) {
+			best = torrent
+		}
+	}
+	return best
+}
+
+// FindResult returns the recent result with infoHash, and the key of the
+// search that returned it.
+func FindResult(infoHash string) (*bittorrent.Torrent, string) {
+	recentResultsLock.Lock()
+	defer recentResultsLock.Unlock()
+
+	for key, result := range recentResults {
+		for _, torrent := range result.torrents {
+			if strings.EqualFold(torrent.InfoHash, infoHash) {
+				return torrent, key
+			}
+		}
+	}
+	return nil, ""
+}
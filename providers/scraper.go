@@ -0,0 +1,164 @@
+package providers
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Used by native providers to fetch and parse tracker pages.
+var ScrapeClient = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+// Pages and their decompressed bodies are cut there, so that a huge or
+// malicious answer can't take all the memory.
+const maxScrapeSize = 10 * 1024 * 1024
+
+var metaCharsetRe = regexp.MustCompile(`(?i)<meta[^>]+charset=["']?([\w-]+)`)
+
+// windows-1252 differs from latin1 in 0x80-0x9F, where it has printable
+// characters instead of control codes.
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+// iso-8859-15 is latin1 with the euro sign and a few french letters.
+var iso885915 = map[byte]rune{
+	0xA4: '€', 0xA6: 'Š', 0xA8: 'š', 0xB4: 'Ž', 0xB8: 'ž', 0xBC: 'Œ', 0xBD: 'œ', 0xBE: 'Ÿ',
+}
+
+// Scrape fetches a page and returns it decompressed, as UTF-8 with its HTML
+// entities decoded.
+func Scrape(url string) (string, error) {
+	body, contentType, err := fetch(url)
+	if err != nil {
+		return "", err
+	}
+	return html.UnescapeString(toUTF8(body, detectCharset(contentType, body))), nil
+}
+
+// ScrapeData fetches an API answer, like JSON, and returns it decompressed
+// but as is otherwise.
+func ScrapeData(url string) ([]byte, error) {
+	body, _, err := fetch(url)
+	return body, err
+}
+
+func fetch(url string) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	resp, err := ScrapeClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, "", errors.New(resp.Status)
+	}
+
+	body, err := decompress(io.LimitReader(resp.Body, maxScrapeSize), resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, "", err
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+func readAll(reader io.Reader) ([]byte, error) {
+	return ioutil.ReadAll(io.LimitReader(reader, maxScrapeSize))
+}
+
+func decompress(body io.Reader, encoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return readAll(reader)
+	case "deflate":
+		raw, err := readAll(body)
+		if err != nil {
+			return nil, err
+		}
+		// deflate is supposed to be zlib wrapped, but plenty of servers send
+		// raw deflate
+		if reader, err := zlib.NewReader(bytes.NewReader(raw)); err == nil {
+			defer reader.Close()
+			if data, err := readAll(reader); err == nil {
+				return data, nil
+			}
+		}
+		reader := flate.NewReader(bytes.NewReader(raw))
+		defer reader.Close()
+		return readAll(reader)
+	}
+	return readAll(body)
+}
+
+// detectCharset looks at the Content-Type header first, then at the page's
+// meta tags.
+func detectCharset(contentType string, body []byte) string {
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		return strings.ToLower(params["charset"])
+	}
+	head := body
+	if len(head) > 2048 {
+		head = head[:2048]
+	}
+	if match := metaCharsetRe.FindSubmatch(head); match != nil {
+		return strings.ToLower(string(match[1]))
+	}
+	if utf8.Valid(body) {
+		return "utf-8"
+	}
+	// most likely, if it isn't UTF-8
+	return "windows-1252"
+}
+
+func toUTF8(body []byte, charset string) string {
+	switch charset {
+	case "iso-8859-1", "latin1", "latin-1", "iso8859-1", "us-ascii":
+		return decodeSingleByte(body, func(b byte) rune { return rune(b) })
+	case "windows-1252", "cp1252":
+		return decodeSingleByte(body, func(b byte) rune {
+			if b >= 0x80 && b <= 0x9F {
+				return windows1252[b-0x80]
+			}
+			return rune(b)
+		})
+	case "iso-8859-15", "latin9", "latin-9":
+		return decodeSingleByte(body, func(b byte) rune {
+			if r, ok := iso885915[b]; ok {
+				return r
+			}
+			return rune(b)
+		})
+	}
+	// UTF-8, or something we can't convert
+	return string(body)
+}
+
+func decodeSingleByte(body []byte, convert func(byte) rune) string {
+	runes := make([]rune, len(body))
+	for i, b := range body {
+		runes[i] = convert(b)
+	}
+	return string(runes)
+}
@@ -1,11 +1,13 @@
 package providers
 
 import (
-	"sort"
+	"context"
+	"fmt"
 	"sync"
 
 	"github.com/op/go-logging"
 	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/events"
 	"github.com/steeve/pulsar/tmdb"
 	"github.com/steeve/pulsar/tvdb"
 )
@@ -24,76 +26,80 @@ var DefaultTrackers = []string{
 
 var log = logging.MustGetLogger("linkssearch")
 
-func Search(searchers []Searcher, query string) []*bittorrent.Torrent {
-	torrentsChan := make(chan *bittorrent.Torrent)
-	go func() {
-		wg := sync.WaitGroup{}
-		for _, searcher := range searchers {
-			wg.Add(1)
-			go func(searcher Searcher) {
-				defer wg.Done()
-				for _, torrent := range searcher.SearchLinks(query) {
-					torrentsChan <- torrent
-				}
-			}(searcher)
-		}
-		wg.Wait()
-		close(torrentsChan)
-	}()
+func Search(ctx context.Context, searchers []Searcher, query string) []*bittorrent.Torrent {
+	return coalesce(ctx, "query."+query+"."+searchersKey(searcherList(searchers)), func(ctx context.Context) []*bittorrent.Torrent {
+		return searchResults(ctx, searchers, query, nil)
+	})
+}
 
-	return processLinks(torrentsChan)
+func searchResults(ctx context.Context, searchers []Searcher, query string, trace *SearchTrace) []*bittorrent.Torrent {
+	torrents := processLinks(ctx, StreamSearch(ctx, searchers, query).torrents(), trace)
+	torrents = postProcess(&scriptMedia{Type: "search", Query: query}, torrents, trace)
+	return searchDone("search", query, limitResults("search", torrents, trace), trace)
 }
 
-func SearchMovie(searchers []MovieSearcher, movie *tmdb.Movie) []*bittorrent.Torrent {
-	torrentsChan := make(chan *bittorrent.Torrent)
-	go func() {
-		wg := sync.WaitGroup{}
-		for _, searcher := range searchers {
-			wg.Add(1)
-			go func(searcher MovieSearcher) {
-				defer wg.Done()
-				for _, torrent := range searcher.SearchMovieLinks(movie) {
-					torrentsChan <- torrent
-				}
-			}(searcher)
-		}
-		wg.Wait()
-		close(torrentsChan)
-	}()
+func SearchMovie(ctx context.Context, searchers []MovieSearcher, movie *tmdb.Movie) []*bittorrent.Torrent {
+	return cached(ctx, movieSearchKey(movie)+"."+searchersKey(searcherList(searchers)), func(ctx context.Context) []*bittorrent.Torrent {
+		return movieResults(ctx, searchers, movie, nil)
+	})
+}
 
-	return processLinks(torrentsChan)
+func movieResults(ctx context.Context, searchers []MovieSearcher, movie *tmdb.Movie, trace *SearchTrace) []*bittorrent.Torrent {
+	torrents := processLinks(ctx, StreamMovie(ctx, searchers, movie).torrents(), trace)
+	torrents = filterResults(MediaMovie, torrents, trace)
+	torrents = postProcess(movieMedia(movie), torrents, trace)
+	return searchDone("search_movie", movie.Title, limitResults("search_movie", torrents, trace), trace)
 }
 
-func SearchEpisode(searchers []EpisodeSearcher, show *tvdb.Show, episode *tvdb.Episode) []*bittorrent.Torrent {
-	torrentsChan := make(chan *bittorrent.Torrent)
-	go func() {
-		wg := sync.WaitGroup{}
-		for _, searcher := range searchers {
-			wg.Add(1)
-			go func(searcher EpisodeSearcher) {
-				defer wg.Done()
-				for _, torrent := range searcher.SearchEpisodeLinks(show, episode) {
-					torrentsChan <- torrent
-				}
-			}(searcher)
-		}
-		wg.Wait()
-		close(torrentsChan)
-	}()
+func SearchEpisode(ctx context.Context, searchers []EpisodeSearcher, show *tvdb.Show, episode *tvdb.Episode) []*bittorrent.Torrent {
+	return cached(ctx, episodeSearchKey(show, episode)+"."+searchersKey(searcherList(searchers)), func(ctx context.Context) []*bittorrent.Torrent {
+		return episodeResults(ctx, searchers, show, episode, nil)
+	})
+}
 
-	return processLinks(torrentsChan)
+func episodeResults(ctx context.Context, searchers []EpisodeSearcher, show *tvdb.Show, episode *tvdb.Episode, trace *SearchTrace) []*bittorrent.Torrent {
+	torrents := processLinks(ctx, StreamEpisode(ctx, searchers, show, episode).torrents(), trace)
+	torrents = filterResults(MediaEpisode, torrents, trace)
+	torrents = postProcess(episodeMedia(show, episode), torrents, trace)
+	if isAnime(show.Id, tmdbShowFor(show)) {
+		torrents = applyAnimePreferences(torrents, trace)
+	}
+	query := fmt.Sprintf("%s S%02dE%02d", show.SeriesName, episode.SeasonNumber, episode.EpisodeNumber)
+	return searchDone("search_episode", query, limitResults("search_episode", torrents, trace), trace)
 }
 
-func processLinks(torrentsChan chan *bittorrent.Torrent) []*bittorrent.Torrent {
+// searchDone tells the event bus about the searches, but the dry runs.
+func searchDone(method string, query string, torrents []*bittorrent.Torrent, trace *SearchTrace) []*bittorrent.Torrent {
+	if trace == nil {
+		events.Publish(events.SearchDone, &events.Search{
+			Method:  method,
+			Query:   query,
+			Results: len(torrents),
+		})
+	}
+	return torrents
+}
+
+// processLinks resolves and scrapes the torrents as they come, nothing
+// being left to do once ctx is done.
+func processLinks(ctx context.Context, torrentsChan chan *bittorrent.Torrent, trace *SearchTrace) []*bittorrent.Torrent {
 	trackers := map[string]*bittorrent.Tracker{}
-	torrentsMap := map[string]*bittorrent.Torrent{}
 
 	torrents := make([]*bittorrent.Torrent, 0)
+	// no trackers to scrape for usenet releases, they come after the torrents
+	nzbs := make([]*bittorrent.Torrent, 0)
 
 	log.Info("Resolving torrent files...")
 	wg := sync.WaitGroup{}
 	for torrent := range torrentsChan {
+		if torrent.IsNZB() {
+			nzbs = append(nzbs, torrent)
+			continue
+		}
 		torrents = append(torrents, torrent)
+		if ctx.Err() != nil {
+			continue
+		}
 		wg.Add(1)
 		go func(torrent *bittorrent.Torrent) {
 			defer wg.Done()
@@ -103,32 +109,20 @@ func processLinks(torrentsChan chan *bittorrent.Torrent) []*bittorrent.Torrent {
 		}(torrent)
 	}
 	wg.Wait()
+	if ctx.Err() != nil {
+		log.Info("Search abandoned, dropping %d links", len(torrents)+len(nzbs))
+		return make([]*bittorrent.Torrent, 0)
+	}
+	trace.receive(append(nzbs, torrents...))
 
+	orderByProvider(torrents)
+	collection := bittorrent.NewTorrentCollection()
 	for _, torrent := range torrents {
-		if torrent.InfoHash == "" { // ignore torrents whose infohash is empty
+		if collection.Add(torrent) == false { // ignore torrents whose infohash is empty
 			log.Error("Infohash is empty for %s\n", torrent.URI)
+			trace.Drop(torrent, "collection", "no info hash")
 			continue
 		}
-		if existingTorrent, exists := torrentsMap[torrent.InfoHash]; exists {
-			existingTorrent.Trackers = append(existingTorrent.Trackers, torrent.Trackers...)
-			if torrent.Resolution > existingTorrent.Resolution {
-				existingTorrent.Resolution = torrent.Resolution
-			}
-			if torrent.VideoCodec > existingTorrent.VideoCodec {
-				existingTorrent.VideoCodec = torrent.VideoCodec
-			}
-			if torrent.AudioCodec > existingTorrent.AudioCodec {
-				existingTorrent.AudioCodec = torrent.AudioCodec
-			}
-			if torrent.RipType > existingTorrent.RipType {
-				existingTorrent.RipType = torrent.RipType
-			}
-			if torrent.SceneRating > existingTorrent.SceneRating {
-				existingTorrent.SceneRating = torrent.SceneRating
-			}
-		} else {
-			torrentsMap[torrent.InfoHash] = torrent
-		}
 		for _, tracker := range torrent.Trackers {
 			bTracker, err := bittorrent.NewTracker(tracker)
 			if err != nil {
@@ -143,15 +137,12 @@ func processLinks(torrentsChan chan *bittorrent.Torrent) []*bittorrent.Torrent {
 		trackers[tracker.URL.Host] = tracker
 	}
 
-	torrents = make([]*bittorrent.Torrent, 0, len(torrentsMap))
-	for _, torrent := range torrentsMap {
-		torrents = append(torrents, torrent)
-	}
+	torrents = collection.Torrents()
 
-	log.Info("Received %d links.\n", len(torrents))
+	log.Info("Received %d links and %d NZBs.\n", len(torrents), len(nzbs))
 
 	if len(torrents) == 0 {
-		return torrents
+		return nzbs
 	}
 
 	log.Info("Scraping torrent metrics from %d trackers...\n", len(trackers))
@@ -173,8 +164,20 @@ func processLinks(torrentsChan chan *bittorrent.Torrent) []*bittorrent.Torrent {
 		close(scrapeResults)
 	}()
 
+	scraped := make(map[string]*bittorrent.ScrapeResponseEntry)
 	for results := range scrapeResults {
 		for i, result := range results {
+			best, ok := scraped[torrents[i].InfoHash]
+			if ok == false {
+				best = &bittorrent.ScrapeResponseEntry{}
+				scraped[torrents[i].InfoHash] = best
+			}
+			if result.Seeders > best.Seeders {
+				best.Seeders = result.Seeders
+			}
+			if result.Leechers > best.Leechers {
+				best.Leechers = result.Leechers
+			}
 			if int64(result.Seeders) > torrents[i].Seeds {
 				torrents[i].Seeds = int64(result.Seeders)
 			}
@@ -184,11 +187,18 @@ func processLinks(torrentsChan chan *bittorrent.Torrent) []*bittorrent.Torrent {
 		}
 	}
 
-	sort.Sort(sort.Reverse(BySeeds(torrents)))
-	log.Info("Sorted torrent candidates:\n")
+	torrents = verifyAvailability(collection.Ranked(), scraped)
+	if trace != nil {
+		for _, torrent := range collection.Torrents() {
+			if torrent.Blacklisted() {
+				trace.Drop(torrent, "blacklist", "release group is blacklisted")
+			}
+		}
+	}
+	log.Info("Ranked torrent candidates:\n")
 	for _, torrent := range torrents {
-		log.Info("%s S:%d P:%d", torrent.Name, torrent.Seeds, torrent.Peers)
+		log.Info("%s S:%d P:%d score:%.1f", torrent.Name, torrent.Seeds, torrent.Peers, torrent.Score())
 	}
 
-	return torrents
+	return append(torrents, nzbs...)
 }
@@ -0,0 +1,146 @@
+package providers
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/clock"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/profiles"
+	"github.com/steeve/pulsar/tmdb"
+	"github.com/steeve/pulsar/tvdb"
+)
+
+// Searches of the same movie or episode with the same filters return the
+// cached results until they're searchCacheTTL old, those past half of it
+// being searched again in the background.
+const defaultSearchCacheTTL = 10 * time.Minute
+
+type cachedSearch struct {
+	torrents   []*bittorrent.Torrent
+	searched   time.Time
+	refreshing bool
+}
+
+var searchCacheLock = sync.Mutex{}
+var searchCache = map[string]*cachedSearch{}
+
+// searchCacheTTL is from the settings, in minutes, negative turning the
+// cache off.
+func searchCacheTTL() time.Duration {
+	minutes := config.Get().SearchCacheTTL
+	if minutes == 0 {
+		return defaultSearchCacheTTL
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// filterProfileKey tells apart the filters the results went through: the
+// profile's, or those of the settings.
+func filterProfileKey() string {
+	data, _ := json.Marshal(CurrentFilterRules())
+	hash := sha1.Sum(data)
+	return fmt.Sprintf("%s.%x", profiles.Current().Name, hash[:4])
+}
+
+// cached returns the cached results of the search if they're fresh enough,
+// else searches through coalesce.
+func cached(ctx context.Context, key string, search func(ctx context.Context) []*bittorrent.Torrent) []*bittorrent.Torrent {
+	return cachedFiltered(ctx, key, filterProfileKey(), search)
+}
+
+// cachedFiltered is cached for the filters of filterKey. Searches through
+// other filters neither share their cached results nor their runs, those
+// being filtered for whoever started them.
+func cachedFiltered(ctx context.Context, key string, filterKey string, search func(ctx context.Context) []*bittorrent.Torrent) []*bittorrent.Torrent {
+	cacheKey := key + "." + filterKey
+	ttl := searchCacheTTL()
+	if ttl <= 0 {
+		return coalesce(ctx, cacheKey, search)
+	}
+
+	searchCacheLock.Lock()
+	entry, ok := searchCache[cacheKey]
+	if ok && clock.Since(entry.searched) < ttl {
+		if clock.Since(entry.searched) > ttl/2 && entry.refreshing == false {
+			entry.refreshing = true
+			go refreshSearch(cacheKey, search)
+		}
+		searchCacheLock.Unlock()
+		log.Info("Returning the cached results of %s", key)
+		return copyTorrents(entry.torrents)
+	}
+	searchCacheLock.Unlock()
+
+	torrents := coalesce(ctx, cacheKey, search)
+	// abandoned, those are no results
+	if ctx.Err() == nil {
+		storeSearch(cacheKey, torrents)
+	}
+	return torrents
+}
+
+// refreshSearch runs in the background, for whoever asks next.
+func refreshSearch(cacheKey string, search func(ctx context.Context) []*bittorrent.Torrent) {
+	log.Info("Refreshing the cached results of %s", cacheKey)
+	storeSearch(cacheKey, coalesce(context.Background(), cacheKey, search))
+}
+
+func storeSearch(cacheKey string, torrents []*bittorrent.Torrent) {
+	searchCacheLock.Lock()
+	defer searchCacheLock.Unlock()
+
+	now := clock.Now()
+	ttl := searchCacheTTL()
+	for k, entry := range searchCache {
+		if now.Sub(entry.searched) > ttl {
+			delete(searchCache, k)
+		}
+	}
+	// nothing found is likely the providers failing, better ask again
+	if len(torrents) == 0 {
+		delete(searchCache, cacheKey)
+		return
+	}
+	searchCache[cacheKey] = &cachedSearch{
+		torrents: copyTorrents(torrents),
+		searched: now,
+	}
+}
+
+// forgetSearch drops the cached results of the search, whatever the
+// filters.
+func forgetSearch(key string) {
+	searchCacheLock.Lock()
+	defer searchCacheLock.Unlock()
+	for k := range searchCache {
+		if strings.HasPrefix(k, key+".") {
+			delete(searchCache, k)
+		}
+	}
+}
+
+func movieSearchKey(movie *tmdb.Movie) string {
+	return fmt.Sprintf("movie.%d", movie.Id)
+}
+
+func episodeSearchKey(show *tvdb.Show, episode *tvdb.Episode) string {
+	return fmt.Sprintf("episode.%d.%d.%d", show.Id, episode.SeasonNumber, episode.EpisodeNumber)
+}
+
+// ForgetMovie makes the next search of the movie ask the providers again.
+func ForgetMovie(movie *tmdb.Movie) {
+	forgetSearch(movieSearchKey(movie))
+}
+
+// ForgetEpisode makes the next search of the episode ask the providers
+// again.
+func ForgetEpisode(show *tvdb.Show, episode *tvdb.Episode) {
+	forgetSearch(episodeSearchKey(show, episode))
+}
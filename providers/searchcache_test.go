@@ -0,0 +1,55 @@
+package providers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+)
+
+func TestCachedSearchesOfConcurrentProfiles(t *testing.T) {
+	filterKeys := []string{"kids.0000", "default.ffff"}
+	started := make(chan string, len(filterKeys))
+	release := make(chan struct{})
+	released := false
+	defer func() {
+		if released == false {
+			close(release)
+		}
+	}()
+	search := func(filterKey string) func(ctx context.Context) []*bittorrent.Torrent {
+		return func(ctx context.Context) []*bittorrent.Torrent {
+			started <- filterKey
+			<-release
+			return []*bittorrent.Torrent{{Name: filterKey, InfoHash: filterKey}}
+		}
+	}
+
+	results := make([][]*bittorrent.Torrent, len(filterKeys))
+	wg := sync.WaitGroup{}
+	for i, filterKey := range filterKeys {
+		wg.Add(1)
+		go func(i int, filterKey string) {
+			defer wg.Done()
+			results[i] = cachedFiltered(context.Background(), "movie.-1.test", filterKey, search(filterKey))
+		}(i, filterKey)
+	}
+	for range filterKeys {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("the searches of both profiles didn't run, one waited for the other's")
+		}
+	}
+	close(release)
+	released = true
+	wg.Wait()
+
+	for i, filterKey := range filterKeys {
+		if len(results[i]) != 1 || results[i][0].Name != filterKey {
+			t.Errorf("profile %s got %v, want its own results", filterKey, results[i])
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package providers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/bittorrent"
+	"github.com/steeve/pulsar/store"
+)
+
+const (
+	settingsBucket = "providers"
+	settingsKey    = "settings"
+)
+
+// How the results are tagged with the provider they come from
+const (
+	TagProvider = "provider" // the default
+	TagGroup    = "group"    // the provider and its group
+	TagNone     = "none"
+)
+
+// ProviderSettings are the user's say on the providers, by addon id or
+// native provider name. Those not in Order are searched after the others.
+type ProviderSettings struct {
+	Order          []string                   `json:"order"`
+	Providers      map[string]*ProviderConfig `json:"providers"`
+	DisabledGroups []string                   `json:"disabled_groups"`
+	Tags           string                     `json:"tags"`
+}
+
+type ProviderConfig struct {
+	Group    string `json:"group,omitempty"`   // e.g. public or private
+	Timeout  int    `json:"timeout,omitempty"` // in seconds, overriding the ones of the searches
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+var settingsLock = sync.Mutex{}
+
+func settingsStore() *store.Bucket {
+	return store.Global(settingsBucket)
+}
+
+func GetProviderSettings() *ProviderSettings {
+	settingsLock.Lock()
+	defer settingsLock.Unlock()
+	return loadProviderSettings()
+}
+
+func loadProviderSettings() *ProviderSettings {
+	settings := &ProviderSettings{}
+	settingsStore().Get(settingsKey, settings)
+	if settings.Providers == nil {
+		settings.Providers = make(map[string]*ProviderConfig)
+	}
+	if settings.Tags == "" {
+		settings.Tags = TagProvider
+	}
+	return settings
+}
+
+// SetProviderSettings replaces the settings, nil going back to the
+// defaults.
+func SetProviderSettings(settings *ProviderSettings) error {
+	settingsLock.Lock()
+	defer settingsLock.Unlock()
+	if settings == nil {
+		return settingsStore().Delete(settingsKey)
+	}
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	return settingsStore().Set(settingsKey, settings, store.FOREVER)
+}
+
+func (settings *ProviderSettings) validate() error {
+	switch settings.Tags {
+	case "", TagProvider, TagGroup, TagNone:
+	default:
+		return fmt.Errorf("unknown tags %s", settings.Tags)
+	}
+	for id, provider := range settings.Providers {
+		if provider == nil {
+			return fmt.Errorf("no settings for %s", id)
+		}
+		if provider.Timeout < 0 {
+			return fmt.Errorf("negative timeout for %s", id)
+		}
+	}
+	return nil
+}
+
+// SetProviderDisabled toggles the provider, unlike EnableProvider which
+// only forgives its failures.
+func SetProviderDisabled(id string, disabled bool) error {
+	settingsLock.Lock()
+	defer settingsLock.Unlock()
+	settings := loadProviderSettings()
+	provider, ok := settings.Providers[id]
+	if ok == false {
+		provider = &ProviderConfig{}
+		settings.Providers[id] = provider
+	}
+	provider.Disabled = disabled
+	return settingsStore().Set(settingsKey, settings, store.FOREVER)
+}
+
+func (settings *ProviderSettings) provider(id string) *ProviderConfig {
+	if provider, ok := settings.Providers[id]; ok {
+		return provider
+	}
+	return &ProviderConfig{}
+}
+
+// switchedOff tells whether the user disabled the provider, or its group.
+func (settings *ProviderSettings) switchedOff(id string) bool {
+	provider := settings.provider(id)
+	if provider.Disabled {
+		return true
+	}
+	for _, group := range settings.DisabledGroups {
+		if provider.Group != "" && strings.EqualFold(group, provider.Group) {
+			return true
+		}
+	}
+	return false
+}
+
+// rank is where the provider comes in the order, those not in it coming
+// last.
+func (settings *ProviderSettings) rank(id string) int {
+	for i, ordered := range settings.Order {
+		if ordered == id {
+			return i
+		}
+	}
+	return len(settings.Order)
+}
+
+type byRank struct {
+	searchers []interface{}
+	settings  *ProviderSettings
+}
+
+func (a byRank) Len() int      { return len(a.searchers) }
+func (a byRank) Swap(i, j int) { a.searchers[i], a.searchers[j] = a.searchers[j], a.searchers[i] }
+func (a byRank) Less(i, j int) bool {
+	return a.settings.rank(providerName(a.searchers[i])) < a.settings.rank(providerName(a.searchers[j]))
+}
+
+// arrange drops the providers the user switched off, and orders the others
+// as they asked.
+func arrange(searchers []interface{}) []interface{} {
+	settings := GetProviderSettings()
+	kept := make([]interface{}, 0, len(searchers))
+	for _, searcher := range searchers {
+		if name := providerName(searcher); settings.switchedOff(name) {
+			log.Info("Skipping provider %s, switched off", name)
+			continue
+		}
+		kept = append(kept, searcher)
+	}
+	sort.Stable(byRank{kept, settings})
+	return kept
+}
+
+type byProviderRank struct {
+	torrents []*bittorrent.Torrent
+	settings *ProviderSettings
+}
+
+func (a byProviderRank) Len() int      { return len(a.torrents) }
+func (a byProviderRank) Swap(i, j int) { a.torrents[i], a.torrents[j] = a.torrents[j], a.torrents[i] }
+func (a byProviderRank) Less(i, j int) bool {
+	return a.settings.rank(a.torrents[i].Provider) < a.settings.rank(a.torrents[j].Provider)
+}
+
+// orderByProvider orders the torrents by the rank of their provider, so
+// that the same torrent from several providers is credited to the first
+// one.
+func orderByProvider(torrents []*bittorrent.Torrent) {
+	sort.Stable(byProviderRank{torrents, GetProviderSettings()})
+}
+
+// providerTimeoutSetting is the timeout the user set for the provider, 0
+// if none.
+func providerTimeoutSetting(id string) time.Duration {
+	return time.Duration(GetProviderSettings().provider(id).Timeout) * time.Second
+}
+
+// ResultTag is what to tag a result with in the views: its provider, and
+// its group if asked to.
+func ResultTag(torrent *bittorrent.Torrent) string {
+	settings := GetProviderSettings()
+	if settings.Tags == TagNone || torrent.ProviderName == "" {
+		return ""
+	}
+	if group := settings.provider(torrent.Provider).Group; settings.Tags == TagGroup && group != "" {
+		return torrent.ProviderName + ", " + group
+	}
+	return torrent.ProviderName
+}
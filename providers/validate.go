@@ -0,0 +1,205 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/steeve/pulsar/bittorrent"
+)
+
+type SchemaError struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("result %d: %s %s", e.Index, e.Field, e.Message)
+}
+
+// Info hashes are hex, or base32 in older magnets.
+var btihRe = regexp.MustCompile(`^urn:btih:([0-9a-fA-F]{40}|[2-7A-Za-z]{32})$`)
+
+// Results work without them, but show up poorly.
+var recommendedFields = []string{"name", "size", "seeds", "peers"}
+
+var numericFields = []string{"size", "seeds", "peers"}
+var nonNegativeFields = map[string]bool{"size": true, "seeds": true, "peers": true}
+var stringFields = []string{"name", "language", "release_group", "provider_name"}
+
+// enumFields are given as their index, or by name such as "1080p".
+var enumFields = map[string][]string{
+	"resolution":   bittorrent.Resolutions,
+	"video_codec":  bittorrent.Codecs,
+	"audio_codec":  bittorrent.Codecs,
+	"rip_type":     bittorrent.Rips,
+	"scene_rating": bittorrent.SceneRatings,
+}
+
+// other names providers use, once normalized
+var enumAliases = map[string]string{
+	"2160p":  "4k",
+	"uhd":    "4k",
+	"x264":   "h264",
+	"avc":    "h264",
+	"webrip": "webdl",
+	"web":    "webdl",
+	"bdrip":  "bluray",
+	"brrip":  "bluray",
+	"dvd":    "dvdrip",
+	"ts":     "telesync",
+	"tc":     "telecine",
+	"scr":    "screener",
+}
+
+func normalizeEnumName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, strings.ToLower(name))
+	if alias, ok := enumAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// enumIndex finds a value of an enum field, -1 when it's not one.
+func enumIndex(names []string, value interface{}) int {
+	switch v := value.(type) {
+	case float64:
+		if v == float64(int(v)) && int(v) >= 0 && int(v) < len(names) {
+			return int(v)
+		}
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return 0
+		}
+		normalized := normalizeEnumName(v)
+		for i, name := range names {
+			if i > 0 && normalizeEnumName(name) == normalized {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func validateTorrent(index int, torrent map[string]interface{}) []*SchemaError {
+	errs := make([]*SchemaError, 0)
+	fail := func(field string, message string) {
+		errs = append(errs, &SchemaError{Index: index, Field: field, Message: message})
+	}
+
+	uri, ok := torrent["uri"].(string)
+	if ok == false || uri == "" {
+		fail("uri", "is missing")
+	} else if u, err := url.Parse(uri); err != nil || u.Scheme == "" {
+		fail("uri", "is not a valid URL")
+	} else if u.Scheme == "magnet" && validMagnet(u) == false {
+		fail("uri", "is a magnet without a valid info hash")
+	}
+	for _, field := range stringFields {
+		if value, exists := torrent[field]; exists && value != nil {
+			if _, ok := value.(string); ok == false {
+				fail(field, "is not a string")
+			}
+		}
+	}
+	for field, names := range enumFields {
+		if value, exists := torrent[field]; exists && value != nil && enumIndex(names, value) < 0 {
+			fail(field, fmt.Sprintf("is not one of %s", strings.Join(names[1:], ", ")))
+		}
+	}
+	for _, field := range numericFields {
+		value, exists := torrent[field]
+		if exists == false || value == nil {
+			continue
+		}
+		number, ok := value.(float64)
+		if ok == false {
+			fail(field, "is not a number")
+		} else if nonNegativeFields[field] && number < 0 {
+			fail(field, "is negative")
+		}
+	}
+	if trackers, exists := torrent["trackers"]; exists && trackers != nil {
+		list, ok := trackers.([]interface{})
+		if ok == false {
+			fail("trackers", "is not a list")
+		}
+		for _, tracker := range list {
+			if _, ok := tracker.(string); ok == false {
+				fail("trackers", "contains a non string")
+				break
+			}
+		}
+	}
+	return errs
+}
+
+func validMagnet(u *url.URL) bool {
+	for _, xt := range u.Query()["xt"] {
+		if btihRe.MatchString(xt) {
+			return true
+		}
+	}
+	return false
+}
+
+// missingFields lists the recommended fields a result doesn't have.
+func missingFields(index int, torrent map[string]interface{}) []*SchemaError {
+	errs := make([]*SchemaError, 0)
+	for _, field := range recommendedFields {
+		value, exists := torrent[field]
+		if name, ok := value.(string); exists == false || value == nil || (ok && strings.TrimSpace(name) == "") {
+			errs = append(errs, &SchemaError{Index: index, Field: field, Message: "is missing"})
+		}
+	}
+	return errs
+}
+
+// normalizeResult turns the enum fields given by name into their index, so
+// that the result unmarshals into a torrent.
+func normalizeResult(result json.RawMessage) json.RawMessage {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(result, &fields); err != nil {
+		return result
+	}
+	changed := false
+	for field, names := range enumFields {
+		if value, ok := fields[field].(string); ok {
+			fields[field] = enumIndex(names, value)
+			changed = true
+		}
+	}
+	if changed == false {
+		return result
+	}
+	normalized, err := json.Marshal(fields)
+	if err != nil {
+		return result
+	}
+	return normalized
+}
+
+// validateResults checks the raw results of a provider, and tells which
+// of them are valid along with what's wrong with the others.
+func validateResults(body []byte) ([]bool, []*SchemaError, error) {
+	var results []map[string]interface{}
+	if err := json.Unmarshal(body, &results); err != nil {
+		return nil, nil, fmt.Errorf("results are not a list of objects: %s", err)
+	}
+	valid := make([]bool, len(results))
+	errs := make([]*SchemaError, 0)
+	for i, result := range results {
+		resultErrs := validateTorrent(i, result)
+		valid[i] = len(resultErrs) == 0
+		errs = append(errs, resultErrs...)
+	}
+	return valid, errs, nil
+}
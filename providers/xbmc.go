@@ -1,11 +1,13 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/rand"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,6 +15,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/anidb"
 	"github.com/steeve/pulsar/bittorrent"
 	"github.com/steeve/pulsar/config"
 	"github.com/steeve/pulsar/tmdb"
@@ -24,6 +27,11 @@ import (
 const (
 	// if >= 80% of episodes have absolute numbers, assume it's because we need it
 	mixAbsoluteNumberPercentage = 0.8
+
+	// sandbox limits, to protect us against misbehaving provider addons
+	maxProviderResults   = 500
+	maxProviderPayload   = 2 * 1024 * 1024 // 2m
+	maxProviderCallbacks = 10
 )
 
 type AddonSearcher struct {
@@ -32,18 +40,57 @@ type AddonSearcher struct {
 
 	addonId string
 	log     *logging.Logger
+	ctx     context.Context
+}
+
+type callback struct {
+	addonId string
+	secret  string
+	c       chan []byte
+	ctx     context.Context
 }
 
 var cbLock = sync.RWMutex{}
-var callbacks = map[string]chan []byte{}
+var callbacks = map[string]*callback{}
+
+// GetCallback registers a callback for a search of the provider, which is
+// removed, its channel closed, once ctx is done.
+func GetCallback(ctx context.Context, addonId string) (string, string, chan []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return "", "", nil, err
+	}
+
+	cbLock.Lock()
+	defer cbLock.Unlock()
+
+	pending := 0
+	for _, cb := range callbacks {
+		if cb.addonId == addonId {
+			pending++
+		}
+	}
+	if pending >= maxProviderCallbacks {
+		return "", "", nil, errors.New("too many pending callbacks")
+	}
 
-func GetCallback() (string, chan []byte) {
 	cid := strconv.Itoa(rand.Int())
+	secret := newSecret()
 	c := make(chan []byte, 1) // make sure we don't block clients when we write on it
-	cbLock.Lock()
-	callbacks[cid] = c
-	cbLock.Unlock()
-	return cid, c
+	callbacks[cid] = &callback{
+		addonId: addonId,
+		secret:  secret,
+		c:       c,
+		ctx:     ctx,
+	}
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			if popCallback(cid) {
+				close(c)
+			}
+		}()
+	}
+	return cid, secret, c, nil
 }
 
 func RemoveCallback(cid string) {
@@ -53,36 +100,142 @@ func RemoveCallback(cid string) {
 	delete(callbacks, cid)
 }
 
+// PendingCallbacks is how many searches wait for their provider.
+func PendingCallbacks() int {
+	cbLock.RLock()
+	defer cbLock.RUnlock()
+
+	return len(callbacks)
+}
+
+// popCallback removes the callback and returns whether it was still
+// registered, so that only one caller gets to close its channel.
+func popCallback(cid string) bool {
+	cbLock.Lock()
+	defer cbLock.Unlock()
+
+	_, ok := callbacks[cid]
+	delete(callbacks, cid)
+	return ok
+}
+
+// CallbackStatus lets providers poll whether we still wait for their
+// results, 410 telling them to stop searching: the search was abandoned,
+// or they were too slow.
+func CallbackStatus(ctx *gin.Context) {
+	cbLock.RLock()
+	cb, ok := callbacks[ctx.Params.ByName("cid")]
+	cbLock.RUnlock()
+	if ok == false || cb.ctx.Err() != nil {
+		ctx.AbortWithStatus(410)
+		return
+	}
+	ctx.String(200, "")
+}
+
 func CallbackHandler(ctx *gin.Context) {
 	cid := ctx.Params.ByName("cid")
 	cbLock.RLock()
-	c, ok := callbacks[cid]
+	cb, ok := callbacks[cid]
 	cbLock.RUnlock()
 	// maybe the callback was already removed because we were too slow,
 	// it's fine.
 	if !ok {
 		return
 	}
-	RemoveCallback(cid)
-	body, _ := ioutil.ReadAll(ctx.Request.Body)
-	c <- body
-	close(c)
+
+	// don't consume the callback on unauthenticated calls, or anybody could
+	// cancel a search by hitting random callback ids, and oversized ones
+	// can't be authenticated
+	body, _ := ioutil.ReadAll(io.LimitReader(ctx.Request.Body, maxProviderPayload+1))
+	if len(body) > maxProviderPayload {
+		log.Warning("Rejected a callback for provider %s bigger than %d bytes from %s", cb.addonId, maxProviderPayload, ctx.Request.RemoteAddr)
+		recordViolation(cb.addonId, "payload too big")
+		ctx.AbortWithStatus(413)
+		return
+	}
+	if checkSignature(cb.secret, body, ctx.Request.Header.Get(SignatureHeader)) == false {
+		log.Warning("Rejected unauthenticated callback for provider %s from %s", cb.addonId, ctx.Request.RemoteAddr)
+		ctx.AbortWithStatus(401)
+		return
+	}
+	debugPayload(cb.addonId, "callback", body)
+	if popCallback(cid) == false {
+		return
+	}
+	defer close(cb.c)
+
+	cb.c <- body
+}
+
+var searchersLock = sync.Mutex{}
+var searchersStopped = false
+
+// StopSearchers makes the searches find no providers, until StartSearchers.
+func StopSearchers() {
+	searchersLock.Lock()
+	defer searchersLock.Unlock()
+	searchersStopped = true
+}
+
+func StartSearchers() {
+	searchersLock.Lock()
+	defer searchersLock.Unlock()
+	searchersStopped = false
+}
+
+func searchersRunning() bool {
+	searchersLock.Lock()
+	defer searchersLock.Unlock()
+	return searchersStopped == false
 }
 
 func getSearchers() []interface{} {
-	list := make([]interface{}, 0)
+	addons := make([]interface{}, 0)
+	if searchersRunning() == false {
+		log.Info("Providers are stopped, skipping them")
+		return addons
+	}
+	if xbmc.IsAvailable() == false {
+		log.Warning("XBMC JSON-RPC is unavailable, skipping addon providers")
+	} else {
+		addons = addonSearchers()
+	}
+	natives := nativeSearchers()
+	list := make([]interface{}, 0, len(natives)+len(addons))
+	for _, searcher := range natives {
+		if optional, ok := searcher.(optionalSearcher); ok && optional.Searched(len(addons)) == false {
+			continue
+		}
+		list = append(list, searcher)
+	}
+	return arrange(append(list, addons...))
+}
+
+func addonSearchers() []interface{} {
+	addons := make([]interface{}, 0)
 	for _, addon := range xbmc.GetAddons("xbmc.python.script", "executable", true).Addons {
 		if strings.HasPrefix(addon.ID, "script.pulsar.") {
-			list = append(list, NewAddonSearcher(addon.ID))
+			if providerDisabled(addon.ID) {
+				log.Info("Skipping disabled provider %s", addon.ID)
+				continue
+			}
+			if _, ok := GetCapabilities(addon.ID).TargetLanguages(); ok == false {
+				log.Info("Skipping provider %s, without releases in the preferred languages", addon.ID)
+				continue
+			}
+			addons = append(addons, NewAddonSearcher(addon.ID))
 		}
 	}
-	return list
+	return addons
 }
 
 func GetMovieSearchers() []MovieSearcher {
 	searchers := make([]MovieSearcher, 0)
 	for _, searcher := range getSearchers() {
-		searchers = append(searchers, searcher.(MovieSearcher))
+		if movieSearcher, ok := searcher.(MovieSearcher); ok {
+			searchers = append(searchers, movieSearcher)
+		}
 	}
 	return searchers
 }
@@ -90,7 +243,9 @@ func GetMovieSearchers() []MovieSearcher {
 func GetEpisodeSearchers() []EpisodeSearcher {
 	searchers := make([]EpisodeSearcher, 0)
 	for _, searcher := range getSearchers() {
-		searchers = append(searchers, searcher.(EpisodeSearcher))
+		if episodeSearcher, ok := searcher.(EpisodeSearcher); ok {
+			searchers = append(searchers, episodeSearcher)
+		}
 	}
 	return searchers
 }
@@ -98,7 +253,9 @@ func GetEpisodeSearchers() []EpisodeSearcher {
 func GetSearchers() []Searcher {
 	searchers := make([]Searcher, 0)
 	for _, searcher := range getSearchers() {
-		searchers = append(searchers, searcher.(Searcher))
+		if s, ok := searcher.(Searcher); ok {
+			searchers = append(searchers, s)
+		}
 	}
 	return searchers
 }
@@ -107,9 +264,25 @@ func NewAddonSearcher(addonId string) *AddonSearcher {
 	return &AddonSearcher{
 		addonId: addonId,
 		log:     logging.MustGetLogger(fmt.Sprintf("AddonSearcher %s", addonId)),
+		ctx:     context.Background(),
 	}
 }
 
+func (as *AddonSearcher) String() string {
+	return as.addonId
+}
+
+// localizedTitles tells whether to search the provider with the titles in
+// the metadata language, rather than the original or English ones.
+func (as *AddonSearcher) localizedTitles() (string, bool) {
+	conf := config.Get()
+	caps := GetCapabilities(as.addonId)
+	if conf.LocalizedSearchTitles == false || conf.Language == tmdb.FallbackLanguage || caps.Foreign() == false {
+		return "", false
+	}
+	return conf.Language, caps.WantsLanguage(conf.Language)
+}
+
 func (as *AddonSearcher) GetMovieSearchObject(movie *tmdb.Movie) *MovieSearchObject {
 	year, _ := strconv.Atoi(strings.Split(movie.ReleaseDate, "-")[0])
 	title := movie.OriginalTitle
@@ -117,92 +290,261 @@ func (as *AddonSearcher) GetMovieSearchObject(movie *tmdb.Movie) *MovieSearchObj
 		title = movie.Title
 	}
 	sObject := &MovieSearchObject{
-		IMDBId: movie.IMDBId,
-		Title:  NormalizeTitle(title),
-		Year:   year,
-		Titles: make(map[string]string),
+		IMDBId:           movie.IMDBId,
+		Title:            NormalizeTitle(title),
+		Year:             year,
+		Titles:           make(map[string]string),
+		OriginalTitle:    NormalizeTitle(movie.OriginalTitle),
+		OriginalLanguage: movie.OriginalLanguage,
+		Runtime:          movie.Runtime,
+		Genres:           make([]string, 0, len(movie.Genres)),
+		Keywords:         make([]string, 0),
+	}
+	for _, genre := range movie.Genres {
+		sObject.Genres = append(sObject.Genres, genre.Name)
+	}
+	if movie.Keywords != nil {
+		for _, keyword := range movie.Keywords.Keywords {
+			sObject.Keywords = append(sObject.Keywords, keyword.Name)
+		}
+	}
+	if movie.AlternativeTitles != nil {
+		for _, title := range movie.AlternativeTitles.Titles {
+			sObject.Titles[strings.ToLower(title.ISO_3166_1)] = NormalizeTitle(title.Title)
+		}
 	}
-	for _, title := range movie.AlternativeTitles.Titles {
-		sObject.Titles[strings.ToLower(title.ISO_3166_1)] = NormalizeTitle(title.Title)
+	// movies are fetched in the metadata language
+	if language, ok := as.localizedTitles(); ok && movie.Title != "" {
+		sObject.Title = NormalizeTitle(movie.Title)
+		if _, exists := sObject.Titles[language]; exists == false {
+			sObject.Titles[language] = sObject.Title
+		}
 	}
+	sObject.Titles = GetCapabilities(as.addonId).filterTitles(sObject.Titles)
+	sObject.Languages, _ = GetCapabilities(as.addonId).TargetLanguages()
 	return sObject
 }
 
 func (as *AddonSearcher) GetEpisodeSearchObject(show *tvdb.Show, episode *tvdb.Episode) *EpisodeSearchObject {
 	seriesName := show.SeriesName
 	absoluteNumber := 0
-	tmdbFindResults := tmdb.Find(strconv.Itoa(show.Id), "tvdb_id")
+	episodeRuntime := show.Runtime
+	network := show.Network
+	titles := make(map[string]string)
+	originalTitle := ""
+	originalLanguage := ""
+	genres := make([]string, 0)
+	keywords := make([]string, 0)
+	episodeCount := 0
+	for _, season := range show.Seasons {
+		if season.Season > 0 {
+			episodeCount += len(season.Episodes)
+		}
+	}
 
-	// FIXME: This can crash
-	if tmdbFindResults != nil {
-		var tmdbShow *tmdb.Show
-		for _, result := range tmdbFindResults.TVResults {
-			tmdbShow = tmdb.GetShow(result.Id, "en")
-			break
+	tmdbShow := tmdbShowFor(show)
+	if tmdbShow != nil {
+		seriesName = tmdbShow.Name
+		originalTitle = NormalizeTitle(tmdbShow.OriginalName)
+		originalLanguage = tmdbShow.OriginalLanguage
+		if tmdbShow.AlternativeTitles != nil {
+			for _, title := range tmdbShow.AlternativeTitles.Titles {
+				titles[strings.ToLower(title.ISO_3166_1)] = NormalizeTitle(title.Title)
+			}
 		}
-		if tmdbShow != nil {
-			seriesName = tmdbShow.Name
+		for _, genre := range tmdbShow.Genres {
+			genres = append(genres, genre.Name)
 		}
-		// is this an anime?
-		countryIsJP := false
-		for _, country := range tmdbShow.OriginCountry {
-			if country == "JP" {
-				countryIsJP = true
-				break
+		if tmdbShow.Keywords != nil {
+			for _, keyword := range tmdbShow.Keywords.Keywords {
+				keywords = append(keywords, keyword.Name)
 			}
 		}
-		genreIsAnim := false
-		for _, genre := range tmdbShow.Genres {
-			if genre.Name == "Animation" {
-				genreIsAnim = true
+		for _, runtime := range tmdbShow.EpisodeRunTime {
+			episodeRuntime = runtime
+			break
+		}
+		if network == "" {
+			for _, n := range tmdbShow.Networks {
+				network = n.Name
 				break
 			}
 		}
-		if countryIsJP && genreIsAnim {
-			absoluteNumber = episode.AbsoluteNumber
+	}
+	// TVDB shows are fetched in the metadata language
+	if language, ok := as.localizedTitles(); ok && show.SeriesName != "" {
+		seriesName = show.SeriesName
+		if _, exists := titles[language]; exists == false {
+			titles[language] = NormalizeTitle(show.SeriesName)
+		}
+	}
+	anime := isAnime(show.Id, tmdbShow)
+	var anidbEpisode *anidb.Episode
+	if anime {
+		absoluteNumber = show.ComputeAbsoluteNumber(episode)
+		if episode.AbsoluteNumber > 0 && episode.AbsoluteNumber != absoluteNumber {
+			as.log.Info("Using absolute number %d instead of TVDB's %d", absoluteNumber, episode.AbsoluteNumber)
 		}
+		anidbEpisode = anidb.Map(show.Id, episode.SeasonNumber, episode.EpisodeNumber, absoluteNumber)
 	}
 
-	return &EpisodeSearchObject{
-		IMDBId:         show.ImdbId,
-		TVDBId:         show.Id,
-		Title:          NormalizeTitle(seriesName),
-		Season:         episode.SeasonNumber,
-		Episode:        episode.EpisodeNumber,
-		AbsoluteNumber: absoluteNumber,
+	searchObject := &EpisodeSearchObject{
+		IMDBId:           show.ImdbId,
+		TVDBId:           show.Id,
+		Title:            NormalizeTitle(seriesName),
+		Season:           episode.SeasonNumber,
+		Episode:          episode.EpisodeNumber,
+		Titles:           GetCapabilities(as.addonId).filterTitles(titles),
+		OriginalTitle:    originalTitle,
+		OriginalLanguage: originalLanguage,
+		AbsoluteNumber:   absoluteNumber,
+		Anime:            anime,
+		ShowRuntime:      show.Runtime,
+		EpisodeRuntime:   episodeRuntime,
+		EpisodeCount:     episodeCount,
+		AirDate:          episode.FirstAired,
+		Network:          network,
+		Genres:           genres,
+		Keywords:         keywords,
 	}
+	searchObject.Languages, _ = GetCapabilities(as.addonId).TargetLanguages()
+	if anidbEpisode != nil {
+		searchObject.AniDBId = anidbEpisode.AniDBId
+		searchObject.AniDBEpisode = anidbEpisode.Number
+	}
+	return searchObject
 }
 
-func (as *AddonSearcher) call(method string, searchObject interface{}) []*bittorrent.Torrent {
-	torrents := make([]*bittorrent.Torrent, 0)
-	cid, c := GetCallback()
-	cbUrl := fmt.Sprintf("%s/callbacks/%s", util.GetHTTPHost(), cid)
+var (
+	errProviderTimeout = errors.New("provider was too slow")
+	errSearchCancelled = errors.New("search was cancelled")
+)
+
+func (as *AddonSearcher) withContext(ctx context.Context) interface{} {
+	c := *as
+	c.ctx = ctx
+	return &c
+}
+
+// callRaw runs the provider and returns the body of its callback, giving up
+// once the searcher's context is done.
+func (as *AddonSearcher) callRaw(method string, searchObject interface{}) ([]byte, error) {
+	cid, secret, c, err := GetCallback(as.ctx, as.addonId)
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return nil, errSearchCancelled
+	}
+	if err != nil {
+		as.log.Warning("Unable to call provider %s: %s", as.addonId, err)
+		recordViolation(as.addonId, err.Error())
+		return nil, err
+	}
+	// providers run in XBMC, next to us
+	cbUrl := fmt.Sprintf("%s/callbacks/%s", util.GetLocalHTTPHost(), cid)
 
 	payload := &SearchPayload{
 		Method:       method,
 		CallbackURL:  cbUrl,
+		CancelURL:    cbUrl,
+		Secret:       secret,
 		SearchObject: searchObject,
 	}
 
-	xbmc.ExecuteAddon(as.addonId, payload.String())
-
-	timeout := providerTimeout()
-	conf := config.Get()
-	if conf.CustomProviderTimeoutEnabled == true {
-		timeout = time.Duration(conf.CustomProviderTimeout) * time.Second
+	if config.Get().ProviderDebug {
+		redacted := *payload
+		redacted.Secret = "<redacted>"
+		body, _ := json.Marshal(redacted)
+		debugPayload(as.addonId, "search payload", body)
 	}
+	if err := executeAddon(as.addonId, payload.String()); err != nil {
+		as.log.Warning("Unable to execute provider %s: %s", as.addonId, err)
+		RemoveCallback(cid)
+		return nil, err
+	}
+
+	timeout := providerTimeoutFor(as.addonId, method)
 
 	select {
 	case <-time.After(timeout):
-		as.log.Info("Provider %s was too slow. Ignored.", as.addonId)
+		as.log.Warning("Provider %s didn't answer %s within %s. Ignored.", as.addonId, method, timeout)
 		RemoveCallback(cid)
-	case result := <-c:
-		json.Unmarshal(result, &torrents)
+		return nil, errProviderTimeout
+	case <-as.ctx.Done():
+		RemoveCallback(cid)
+		return nil, errSearchCancelled
+	case result, ok := <-c:
+		if ok == false && as.ctx.Err() != nil {
+			return nil, errSearchCancelled
+		}
+		return result, nil
+	}
+}
+
+func (as *AddonSearcher) call(method string, searchObject interface{}) []*bittorrent.Torrent {
+	torrents := make([]*bittorrent.Torrent, 0)
+	start := time.Now()
+	result, err := as.callRaw(method, searchObject)
+	if err != errSearchCancelled {
+		defer func() {
+			recordSearch(as.addonId, time.Now().Sub(start), len(torrents), err)
+		}()
+	}
+	if err != nil || len(result) == 0 {
+		return torrents
+	}
+	notApplicable := NotApplicableResponse{}
+	if json.Unmarshal(result, &notApplicable) == nil && notApplicable.NotApplicable {
+		as.log.Info("Provider %s is not applicable: %s", as.addonId, notApplicable.Reason)
+		return torrents
+	}
+	torrents, err = as.parseResults(result)
+	if err != nil {
+		as.log.Warning("Provider %s returned invalid results: %s", as.addonId, err)
+		recordViolation(as.addonId, "invalid results")
+		return torrents
+	}
+	if len(torrents) > maxProviderResults {
+		as.log.Warning("Provider %s returned %d results, keeping only %d.", as.addonId, len(torrents), maxProviderResults)
+		recordViolation(as.addonId, "too many results")
+		torrents = torrents[:maxProviderResults]
 	}
 
 	return torrents
 }
 
+// Only keeps the results matching the schema, rather than letting broken
+// fields unmarshal into zero values.
+func (as *AddonSearcher) parseResults(body []byte) ([]*bittorrent.Torrent, error) {
+	torrents := make([]*bittorrent.Torrent, 0)
+	valid, schemaErrors, err := validateResults(body)
+	if err != nil {
+		return torrents, err
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return torrents, err
+	}
+	for i, ok := range valid {
+		if ok == false {
+			continue
+		}
+		torrent := &bittorrent.Torrent{}
+		if err := json.Unmarshal(normalizeResult(raw[i]), torrent); err == nil {
+			torrent.Provider = as.addonId
+			if torrent.ProviderName == "" {
+				torrent.ProviderName = GetCapabilities(as.addonId).Name
+			}
+			torrent.Payload = raw[i]
+			torrents = append(torrents, torrent)
+		}
+	}
+	if len(schemaErrors) > 0 {
+		invalid := len(valid) - len(torrents)
+		as.log.Warning("Dropped %d invalid results from %s, first error: %s", invalid, as.addonId, schemaErrors[0])
+		recordSchemaErrors(as.addonId, invalid, schemaErrors)
+	}
+	return torrents, nil
+}
+
 func (as *AddonSearcher) SearchLinks(query string) []*bittorrent.Torrent {
 	return as.call("search", query)
 }
@@ -211,20 +553,39 @@ func (as *AddonSearcher) SearchMovieLinks(movie *tmdb.Movie) []*bittorrent.Torre
 	return as.call("search_movie", as.GetMovieSearchObject(movie))
 }
 
+// episodeRef is the episode searched, as release names may number it.
+func episodeRef(epSearchObject *EpisodeSearchObject) bittorrent.EpisodeRef {
+	ref := bittorrent.EpisodeRef{
+		Season:   epSearchObject.Season,
+		Episode:  epSearchObject.Episode,
+		Absolute: animeNumbers(epSearchObject),
+	}
+	ref.Aired, _ = time.Parse("2006-01-02", epSearchObject.AirDate)
+	return ref
+}
+
+// matchesEpisode tells whether the result is the episode, or the season
+// pack containing it.
+func matchesEpisode(torrent *bittorrent.Torrent, epSearchObject *EpisodeSearchObject) bool {
+	lowerName := strings.ToLower(torrent.Name)
+	if bittorrent.ParseEpisodes(lowerName).Matches(episodeRef(epSearchObject)) {
+		return true
+	}
+	if bittorrent.IsSeasonPack(lowerName, epSearchObject.Season) {
+		// the player picks the episode file inside
+		torrent.SeasonPack = true
+		return true
+	}
+	return false
+}
+
 func (as *AddonSearcher) SearchEpisodeLinks(show *tvdb.Show, episode *tvdb.Episode) []*bittorrent.Torrent {
 	epSearchObject := as.GetEpisodeSearchObject(show, episode)
 	torrents := as.call("search_episode", epSearchObject)
-	epMatch := regexp.MustCompile(fmt.Sprintf("(s%02de%02d|%dx%02d)",
-		epSearchObject.Season, epSearchObject.Episode,
-		epSearchObject.Season, epSearchObject.Episode))
-	if epSearchObject.AbsoluteNumber > 0 {
-		epMatch = regexp.MustCompile(fmt.Sprintf("%02d", epSearchObject.AbsoluteNumber))
-	}
 
 	cleanTorrents := make([]*bittorrent.Torrent, 0)
 	for _, torrent := range torrents {
-		lowerName := strings.ToLower(torrent.Name)
-		if epMatch.MatchString(lowerName) {
+		if matchesEpisode(torrent, epSearchObject) {
 			cleanTorrents = append(cleanTorrents, torrent)
 		}
 	}
@@ -0,0 +1,197 @@
+// Package scheduler runs the recurring maintenance jobs of the daemon, such
+// as cache cleanup or library refresh, and keeps track of how they went.
+package scheduler
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/clock"
+	"github.com/steeve/pulsar/config"
+)
+
+var log = logging.MustGetLogger("scheduler")
+
+var (
+	ErrUnknownTask = errors.New("unknown task")
+	ErrTaskPaused  = errors.New("task is paused")
+)
+
+type Task struct {
+	Name      string        `json:"name"`
+	Interval  time.Duration `json:"interval"`
+	LastRun   time.Time     `json:"last_run"`
+	NextRun   time.Time     `json:"next_run"`
+	LastError string        `json:"last_error,omitempty"`
+	Runs      int           `json:"runs"`
+	Failures  int           `json:"failures"`
+	Running   bool          `json:"running"`
+	Paused    bool          `json:"paused,omitempty"`
+
+	run     func() error
+	trigger chan bool
+}
+
+var (
+	lock    = sync.Mutex{}
+	tasks   = map[string]*Task{}
+	started = false
+	closing = make(chan bool)
+)
+
+// Register adds a recurring task. The interval can be overridden from the
+// task_schedules setting, as in "cache_cleanup=6h,library_refresh=24h".
+func Register(name string, interval time.Duration, run func() error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	task := &Task{
+		Name:     name,
+		Interval: interval,
+		run:      run,
+		trigger:  make(chan bool, 1),
+	}
+	tasks[name] = task
+	if started {
+		go task.loop()
+	}
+}
+
+func (task *Task) interval() time.Duration {
+	if interval, ok := config.Get().TaskSchedules[task.Name]; ok {
+		return interval
+	}
+	return task.Interval
+}
+
+func (task *Task) loop() {
+	for {
+		interval := task.interval()
+		lock.Lock()
+		task.NextRun = clock.Now().Add(interval)
+		lock.Unlock()
+
+		select {
+		case <-closing:
+			return
+		case <-task.trigger:
+		case <-time.After(interval):
+		}
+		lock.Lock()
+		paused := task.Paused
+		lock.Unlock()
+		if paused == false {
+			task.execute()
+		}
+	}
+}
+
+func (task *Task) execute() {
+	lock.Lock()
+	task.Running = true
+	lock.Unlock()
+
+	log.Info("Running task %s...", task.Name)
+	start := clock.Now()
+	err := task.run()
+
+	lock.Lock()
+	defer lock.Unlock()
+	task.Running = false
+	task.LastRun = start
+	task.Runs++
+	if err != nil {
+		log.Error("Task %s failed: %s", task.Name, err)
+		task.Failures++
+		task.LastError = err.Error()
+	} else {
+		log.Info("Task %s done in %s", task.Name, clock.Now().Sub(start))
+		task.LastError = ""
+	}
+}
+
+func Start() {
+	lock.Lock()
+	defer lock.Unlock()
+	if started {
+		return
+	}
+	started = true
+	closing = make(chan bool)
+	for _, task := range tasks {
+		go task.loop()
+	}
+}
+
+func Stop() {
+	lock.Lock()
+	defer lock.Unlock()
+	if started {
+		close(closing)
+		started = false
+	}
+}
+
+// RunNow runs the task right away instead of waiting for its schedule.
+func RunNow(name string) error {
+	lock.Lock()
+	defer lock.Unlock()
+	task, exists := tasks[name]
+	if exists == false {
+		return ErrUnknownTask
+	}
+	if task.Paused {
+		return ErrTaskPaused
+	}
+	select {
+	case task.trigger <- true:
+	default: // already triggered
+	}
+	return nil
+}
+
+// Pause skips the runs of the tasks until they're resumed.
+func Pause(names ...string) error {
+	return setPaused(names, true)
+}
+
+func Resume(names ...string) error {
+	return setPaused(names, false)
+}
+
+func setPaused(names []string, paused bool) error {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, name := range names {
+		if _, exists := tasks[name]; exists == false {
+			return ErrUnknownTask
+		}
+	}
+	for _, name := range names {
+		tasks[name].Paused = paused
+	}
+	return nil
+}
+
+type byName []Task
+
+func (a byName) Len() int           { return len(a) }
+func (a byName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+
+// Status returns a snapshot of all the tasks.
+func Status() []Task {
+	lock.Lock()
+	defer lock.Unlock()
+	status := make([]Task, 0, len(tasks))
+	for _, task := range tasks {
+		t := *task
+		t.Interval = task.interval()
+		status = append(status, t)
+	}
+	sort.Sort(byName(status))
+	return status
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/steeve/pulsar/config"
+)
+
+// What's left out of the backups: caches, which are rebuilt, and what only
+// makes sense on this machine.
+var notBackedUp = map[string]bool{
+	"cache":               true,
+	"clock":               true,
+	"piececache":          true,
+	"resume":              true,
+	"https":               true,
+	"logs":                true,
+	"providers_debug.log": true,
+	"settings_cache.json": true,
+}
+
+var ErrBadArchive = errors.New("not a backup archive")
+
+func backedUp(name string) bool {
+	top := strings.SplitN(filepath.ToSlash(name), "/", 2)[0]
+	return notBackedUp[top] == false && strings.HasSuffix(name, ".tmp") == false
+}
+
+// Backup writes a zip archive of the buckets, global and of every profile,
+// along with the rest of the addon data.
+func Backup(w io.Writer) error {
+	root := config.Get().ProfilePath
+	archive := zip.NewWriter(w)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil || name == "." {
+			return err
+		}
+		if backedUp(name) == false {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		header.Method = zip.Store // the items are gzipped already
+		writer, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(writer, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+// Restore puts back the data of a backup, over the current one. The files
+// that aren't in the backup are kept.
+func Restore(r io.ReaderAt, size int64) (int, error) {
+	root := config.Get().ProfilePath
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return 0, ErrBadArchive
+	}
+	restored := 0
+	for _, file := range archive.File {
+		name := filepath.FromSlash(file.Name)
+		// no writing out of the profile, nor over what's not ours
+		if filepath.IsAbs(name) || strings.HasPrefix(filepath.Clean(name), "..") || backedUp(name) == false {
+			log.Warning("Skipping %s from the backup", file.Name)
+			continue
+		}
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		if err := restoreFile(file, filepath.Join(root, name)); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	log.Info("Restored %d files from the backup", restored)
+	return restored, nil
+}
+
+func restoreFile(file *zip.File, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	tmpPath := path + ".tmp"
+	output, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(output, reader); err != nil {
+		output.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := output.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
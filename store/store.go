@@ -0,0 +1,119 @@
+// Package store is where the modules keep their data: namespaced buckets of
+// items, each with its own TTL. Buckets are global, or of a profile (see
+// profiles.Profile.Bucket), and all of them live in the addon profile, which
+// is backed up and restored in one archive.
+package store
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/cache"
+	"github.com/steeve/pulsar/clock"
+	"github.com/steeve/pulsar/config"
+)
+
+// FOREVER is the TTL of the items that never expire.
+const FOREVER = cache.FOREVER
+
+var log = logging.MustGetLogger("store")
+
+// Bucket is a directory of items, one file each named after its key.
+type Bucket struct {
+	*cache.FileStore
+	path string
+}
+
+// Open opens the bucket at path, creating it if needed.
+func Open(path string) *Bucket {
+	return &Bucket{
+		FileStore: cache.NewFileStore(path),
+		path:      path,
+	}
+}
+
+// Global opens a bucket shared by all the profiles.
+func Global(name string) *Bucket {
+	return Open(filepath.Join(config.Get().ProfilePath, name))
+}
+
+type keyedItem struct {
+	Key     string    `json:"key"`
+	Expires time.Time `json:"expires"`
+}
+
+// Keys lists, sorted, the keys of the items that start with prefix and
+// haven't expired.
+func (b *Bucket) Keys(prefix string) ([]string, error) {
+	files, err := ioutil.ReadDir(b.path)
+	if err != nil {
+		return nil, err
+	}
+	now := clock.Now().UTC()
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.IsDir() || strings.HasPrefix(file.Name(), prefix) == false {
+			continue
+		}
+		item, err := b.header(file.Name())
+		if err != nil || (item.Expires.IsZero() == false && item.Expires.Before(now)) {
+			continue
+		}
+		keys = append(keys, item.Key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// header reads the key and expiry of an item, skipping its value.
+func (b *Bucket) header(name string) (*keyedItem, error) {
+	data, err := readGzip(filepath.Join(b.path, name))
+	if err != nil {
+		return nil, err
+	}
+	item := &keyedItem{}
+	if err := json.Unmarshal(data, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// Each calls fn on the items that start with prefix, in the order of their
+// keys, with a function decoding the current one. It stops at the first
+// error fn returns.
+func (b *Bucket) Each(prefix string, fn func(key string, decode func(value interface{}) error) error) error {
+	keys, err := b.Keys(prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		decode := func(value interface{}) error {
+			return b.Get(key, value)
+		}
+		if err := fn(key, decode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readGzip(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
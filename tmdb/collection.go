@@ -0,0 +1,45 @@
+package tmdb
+
+import (
+	"fmt"
+
+	"github.com/jmcvetta/napping"
+	"github.com/steeve/pulsar/metacache"
+)
+
+type Collection struct {
+	Id           int       `json:"id"`
+	Name         string    `json:"name"`
+	Overview     string    `json:"overview"`
+	PosterPath   string    `json:"poster_path"`
+	BackdropPath string    `json:"backdrop_path"`
+	Parts        []*Entity `json:"parts"`
+}
+
+func GetCollection(collectionId int, language string) *Collection {
+	var collection *Collection
+	key := fmt.Sprintf("%d.%s", collectionId, language)
+	if err := metacache.Get(metacache.Collection, key, &collection); err != nil {
+		rateLimiter.Call(func() {
+			session.Get(
+				fmt.Sprintf("%scollection/%d", tmdbEndpoint, collectionId),
+				&napping.Params{"api_key": apiKey, "language": language},
+				&collection,
+				nil,
+			)
+			if collection != nil {
+				metacache.Set(metacache.Collection, key, collection)
+			}
+		})
+	}
+	return collection
+}
+
+// Movies returns the full movies of the collection.
+func (collection *Collection) Movies(language string) Movies {
+	tmdbIds := make([]int, 0, len(collection.Parts))
+	for _, part := range collection.Parts {
+		tmdbIds = append(tmdbIds, part.Id)
+	}
+	return GetMovies(tmdbIds, language)
+}
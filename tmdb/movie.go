@@ -3,15 +3,13 @@ package tmdb
 import (
 	"fmt"
 	"math/rand"
-	"path"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/jmcvetta/napping"
-	"github.com/steeve/pulsar/cache"
-	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/metacache"
 	"github.com/steeve/pulsar/xbmc"
 )
 
@@ -34,6 +32,10 @@ type Movie struct {
 	AlternativeTitles   *struct {
 		Titles []*AlternativeTitle `json:"titles"`
 	} `json:"alternative_titles"`
+	BelongsToCollection *struct {
+		Id   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"belongs_to_collection"`
 	SpokenLanguages []*Language  `json:"spoken_languages"`
 	ExternalIDs     *ExternalIDs `json:"external_ids"`
 
@@ -45,6 +47,10 @@ type Movie struct {
 		Youtube []*Trailer `json:"youtube"`
 	} `json:"trailers"`
 
+	Keywords *struct {
+		Keywords []*IdName `json:"keywords"`
+	} `json:"keywords"`
+
 	Credits *Credits `json:"credits,omitempty"`
 	Images  *Images  `json:"images,omitempty"`
 }
@@ -59,26 +65,51 @@ func GetMovie(tmdbId int, language string) *Movie {
 	return getMovieById(strconv.Itoa(tmdbId), language)
 }
 
+// CachedMovie is the movie if it's in the cache, without asking TMDB.
+func CachedMovie(tmdbId int, language string) *Movie {
+	var movie *Movie
+	if err := metacache.Get(metacache.Movie, fmt.Sprintf("%d.%s", tmdbId, language), &movie); err != nil {
+		return nil
+	}
+	return movie
+}
+
 func getMovieById(movieId string, language string) *Movie {
 	var movie *Movie
-	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := fmt.Sprintf("com.tmdb.movie.%s.%s", movieId, language)
-	if err := cacheStore.Get(key, &movie); err != nil {
+	key := fmt.Sprintf("%s.%s", movieId, language)
+	if err := metacache.Get(metacache.Movie, key, &movie); err == nil && movie != nil {
+		// the memory store shares its values, and the popularity isn't
+		// stored on disk
+		cached := *movie
+		movie = &cached
+		movie.setPopularity()
+	} else {
+		movie = nil
 		rateLimiter.Call(func() {
-			napping.Get(
+			session.Get(
 				tmdbEndpoint+"movie/"+movieId,
-				&napping.Params{"api_key": apiKey, "append_to_response": "credits,images,alternative_titles,translations,external_ids,trailers", "language": language},
+				&napping.Params{"api_key": apiKey, "append_to_response": "credits,images,alternative_titles,translations,external_ids,trailers,keywords", "language": language},
 				&movie,
 				nil,
 			)
-			if movie != nil {
-				cacheStore.Set(key, movie, cacheTime)
-			}
 		})
+		if movie != nil {
+			if movie.Overview == "" && language != FallbackLanguage {
+				if fallback := getMovieById(movieId, FallbackLanguage); fallback != nil {
+					movie.Overview = fallback.Overview
+					if movie.TagLine == "" {
+						movie.TagLine = fallback.TagLine
+					}
+				}
+			}
+			movie.setPopularity()
+			metacache.Set(metacache.Movie, key, movie)
+		}
 	}
-	if movie == nil {
-		return nil
-	}
+	return movie
+}
+
+func (movie *Movie) setPopularity() {
 	switch t := movie.RawPopularity.(type) {
 	case string:
 		popularity, _ := strconv.ParseFloat(t, 64)
@@ -86,7 +117,6 @@ func getMovieById(movieId string, language string) *Movie {
 	case float64:
 		movie.Popularity = t
 	}
-	return movie
 }
 
 func GetMovies(tmdbIds []int, language string) Movies {
@@ -106,7 +136,7 @@ func GetMovies(tmdbIds []int, language string) Movies {
 func GetMovieGenres(language string) []*Genre {
 	genres := GenreList{}
 	rateLimiter.Call(func() {
-		napping.Get(
+		session.Get(
 			tmdbEndpoint+"genre/movie/list",
 			&napping.Params{"api_key": apiKey, "language": language},
 			&genres,
@@ -119,7 +149,7 @@ func GetMovieGenres(language string) []*Genre {
 func SearchMovies(query string, language string) Movies {
 	var results EntityList
 	rateLimiter.Call(func() {
-		napping.Get(
+		session.Get(
 			tmdbEndpoint+"search/movie",
 			&napping.Params{
 				"api_key": apiKey,
@@ -139,7 +169,7 @@ func SearchMovies(query string, language string) Movies {
 func GetList(listId string, language string) Movies {
 	var results *List
 	rateLimiter.Call(func() {
-		napping.Get(
+		session.Get(
 			tmdbEndpoint+"list/"+listId,
 			&napping.Params{
 				"api_key": apiKey,
@@ -162,11 +192,20 @@ func (a ByPopularity) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByPopularity) Less(i, j int) bool { return a[i].Popularity < a[j].Popularity }
 
 func ListMoviesComplete(endpoint string, params napping.Params) Movies {
-	movies := make(Movies, popularMoviesMaxPages*moviesPerPage)
+	return listMovies(endpoint, params, popularMoviesMaxPages)
+}
+
+// Only the first page, for when speed matters more than completeness.
+func ListMoviesPage(endpoint string, params napping.Params) Movies {
+	return listMovies(endpoint, params, 1)
+}
+
+func listMovies(endpoint string, params napping.Params, pages int) Movies {
+	movies := make(Movies, pages*moviesPerPage)
 	params["api_key"] = apiKey
 
 	wg := sync.WaitGroup{}
-	for i := 0; i < popularMoviesMaxPages; i++ {
+	for i := 0; i < pages; i++ {
 		wg.Add(1)
 		go func(page int) {
 			defer wg.Done()
@@ -178,7 +217,7 @@ func ListMoviesComplete(endpoint string, params napping.Params) Movies {
 				tmpParams[k] = v
 			}
 			rateLimiter.Call(func() {
-				napping.Get(
+				session.Get(
 					tmdbEndpoint+endpoint,
 					&tmpParams,
 					&tmp,
@@ -217,16 +256,48 @@ func MostVotedMoviesComplete(genre string, language string) Movies {
 	})
 }
 
+func TrendingMovies(language string) Movies {
+	return ListMoviesPage("discover/movie", napping.Params{
+		"language":                 language,
+		"sort_by":                  "popularity.desc",
+		"primary_release_date.lte": time.Now().UTC().Format("2006-01-02"),
+	})
+}
+
+func PopularMovies(language string) Movies {
+	return ListMoviesPage("movie/popular", napping.Params{"language": language})
+}
+
+func TopRatedMovies(language string) Movies {
+	return ListMoviesPage("movie/top_rated", napping.Params{"language": language})
+}
+
+func NowPlayingMovies(language string) Movies {
+	return ListMoviesPage("movie/now_playing", napping.Params{"language": language})
+}
+
+func SimilarMovies(tmdbId int, language string) Movies {
+	return ListMoviesPage(fmt.Sprintf("movie/%d/similar", tmdbId), napping.Params{"language": language})
+}
+
+// LocalizedTitle is the title in the language the movie was fetched in.
+func (movie *Movie) LocalizedTitle() string {
+	if movie.Title != "" {
+		return movie.Title
+	}
+	return movie.OriginalTitle
+}
+
 func (movie *Movie) ToListItem() *xbmc.ListItem {
 	year, _ := strconv.Atoi(strings.Split(movie.ReleaseDate, "-")[0])
 
 	item := &xbmc.ListItem{
-		Label: movie.OriginalTitle,
+		Label: movie.LocalizedTitle(),
 		Info: &xbmc.ListItemInfo{
 			Year:          year,
 			Count:         rand.Int(),
-			Title:         movie.OriginalTitle,
-			OriginalTitle: movie.Title,
+			Title:         movie.LocalizedTitle(),
+			OriginalTitle: movie.OriginalTitle,
 			Plot:          movie.Overview,
 			PlotOutline:   movie.Overview,
 			TagLine:       movie.TagLine,
@@ -0,0 +1,36 @@
+package tmdb
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/steeve/pulsar/metacache"
+)
+
+func invalidateLanguages(kind string, id string, language string) {
+	metacache.Invalidate(kind, fmt.Sprintf("%s.%s", id, language))
+	if language != FallbackLanguage {
+		metacache.Invalidate(kind, fmt.Sprintf("%s.%s", id, FallbackLanguage))
+	}
+}
+
+// RefreshMovie fetches the movie again, with its new titles and artwork,
+// instead of the cached copy. movieId is a TMDB or an IMDB id.
+func RefreshMovie(movieId string, language string) *Movie {
+	invalidateLanguages(metacache.Movie, movieId, language)
+	return getMovieById(movieId, language)
+}
+
+// RefreshShow fetches the show again. Its seasons are only dropped from the
+// cache, and fetched again when they're needed.
+func RefreshShow(showId int, language string) *Show {
+	invalidateLanguages(metacache.Show, strconv.Itoa(showId), language)
+	show := GetShow(showId, language)
+	if show == nil {
+		return nil
+	}
+	for seasonNumber := 0; seasonNumber <= show.NumberOfSeasons; seasonNumber++ {
+		metacache.Invalidate(metacache.Season, fmt.Sprintf("%d.%d.%s", showId, seasonNumber, language))
+	}
+	return show
+}
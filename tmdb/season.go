@@ -0,0 +1,58 @@
+package tmdb
+
+import (
+	"fmt"
+
+	"github.com/jmcvetta/napping"
+	"github.com/steeve/pulsar/metacache"
+)
+
+type Season struct {
+	Id           int        `json:"id"`
+	Name         string     `json:"name"`
+	Overview     string     `json:"overview"`
+	AirDate      string     `json:"air_date"`
+	PosterPath   string     `json:"poster_path"`
+	SeasonNumber int        `json:"season_number"`
+	Episodes     []*Episode `json:"episodes"`
+}
+
+type Episode struct {
+	Id            int     `json:"id"`
+	Name          string  `json:"name"`
+	Overview      string  `json:"overview"`
+	AirDate       string  `json:"air_date"`
+	SeasonNumber  int     `json:"season_number"`
+	EpisodeNumber int     `json:"episode_number"`
+	StillPath     string  `json:"still_path"`
+	VoteAverage   float32 `json:"vote_average"`
+	VoteCount     int     `json:"vote_count"`
+}
+
+func GetSeason(showId int, seasonNumber int, language string) *Season {
+	var season *Season
+	key := fmt.Sprintf("%d.%d.%s", showId, seasonNumber, language)
+	if err := metacache.Get(metacache.Season, key, &season); err != nil {
+		rateLimiter.Call(func() {
+			session.Get(
+				fmt.Sprintf("%stv/%d/season/%d", tmdbEndpoint, showId, seasonNumber),
+				&napping.Params{"api_key": apiKey, "language": language},
+				&season,
+				nil,
+			)
+			if season != nil {
+				metacache.Set(metacache.Season, key, season)
+			}
+		})
+	}
+	return season
+}
+
+// ImageURL returns the full URL of a TMDB image path, for packages that
+// build their own list items.
+func ImageURL(uri string, size string) string {
+	if uri == "" {
+		return ""
+	}
+	return imageURL(uri, size)
+}
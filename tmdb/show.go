@@ -3,15 +3,13 @@ package tmdb
 import (
 	"fmt"
 	"math/rand"
-	"path"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/jmcvetta/napping"
-	"github.com/steeve/pulsar/cache"
-	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/metacache"
 	"github.com/steeve/pulsar/xbmc"
 )
 
@@ -36,9 +34,15 @@ type Show struct {
 	ProductionCompanies []*IdName    `json:"production_companies"`
 	Status              string       `json:"status"`
 	ExternalIDs         *ExternalIDs `json:"external_ids"`
-	Translations        *struct {
+	AlternativeTitles   *struct {
+		Titles []*AlternativeTitle `json:"results"`
+	} `json:"alternative_titles"`
+	Translations *struct {
 		Translations []*Language `json:"translations"`
 	} `json:"translations"`
+	Keywords *struct {
+		Keywords []*IdName `json:"results"`
+	} `json:"keywords"`
 
 	Credits *Credits `json:"credits,omitempty"`
 	Images  *Images  `json:"images,omitempty"`
@@ -46,26 +50,48 @@ type Show struct {
 
 type Shows []*Show
 
+// CachedShow is the show if it's in the cache, without asking TMDB.
+func CachedShow(showId int, language string) *Show {
+	var show *Show
+	if err := metacache.Get(metacache.Show, fmt.Sprintf("%d.%s", showId, language), &show); err != nil {
+		return nil
+	}
+	return show
+}
+
 func GetShow(showId int, language string) *Show {
 	var show *Show
-	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := fmt.Sprintf("com.tmdb.show.%d.%s", showId, language)
-	if err := cacheStore.Get(key, &show); err != nil {
+	key := fmt.Sprintf("%d.%s", showId, language)
+	if err := metacache.Get(metacache.Show, key, &show); err == nil && show != nil {
+		// the memory store shares its values, and the popularity isn't
+		// stored on disk
+		cached := *show
+		show = &cached
+		show.setPopularity()
+	} else {
+		show = nil
 		rateLimiter.Call(func() {
-			napping.Get(
+			session.Get(
 				tmdbEndpoint+"tv/"+strconv.Itoa(showId),
-				&napping.Params{"api_key": apiKey, "append_to_response": "credits,images,alternative_titles,translations,external_ids", "language": language},
+				&napping.Params{"api_key": apiKey, "append_to_response": "credits,images,alternative_titles,translations,external_ids,keywords", "language": language},
 				&show,
 				nil,
 			)
 		})
 		if show != nil {
-			cacheStore.Set(key, show, cacheTime)
+			if show.Overview == "" && language != FallbackLanguage {
+				if fallback := GetShow(showId, FallbackLanguage); fallback != nil {
+					show.Overview = fallback.Overview
+				}
+			}
+			show.setPopularity()
+			metacache.Set(metacache.Show, key, show)
 		}
 	}
-	if show == nil {
-		return nil
-	}
+	return show
+}
+
+func (show *Show) setPopularity() {
 	switch t := show.RawPopularity.(type) {
 	case string:
 		if popularity, err := strconv.ParseFloat(t, 64); err == nil {
@@ -74,7 +100,6 @@ func GetShow(showId int, language string) *Show {
 	case float64:
 		show.Popularity = t
 	}
-	return show
 }
 
 func GetShows(showIds []int, language string) Shows {
@@ -94,7 +119,7 @@ func GetShows(showIds []int, language string) Shows {
 func SearchShows(query string, language string) Shows {
 	var results EntityList
 	rateLimiter.Call(func() {
-		napping.Get(
+		session.Get(
 			tmdbEndpoint+"search/tv",
 			&napping.Params{
 				"api_key": apiKey,
@@ -112,12 +137,21 @@ func SearchShows(query string, language string) Shows {
 }
 
 func ListShowsComplete(endpoint string, params napping.Params) Shows {
-	shows := make(Shows, popularMoviesMaxPages*moviesPerPage)
+	return listShows(endpoint, params, popularMoviesMaxPages)
+}
+
+// Only the first page, for when speed matters more than completeness.
+func ListShowsPage(endpoint string, params napping.Params) Shows {
+	return listShows(endpoint, params, 1)
+}
+
+func listShows(endpoint string, params napping.Params, pages int) Shows {
+	shows := make(Shows, pages*moviesPerPage)
 
 	params["api_key"] = apiKey
 
 	wg := sync.WaitGroup{}
-	for i := 0; i < popularMoviesMaxPages; i++ {
+	for i := 0; i < pages; i++ {
 		wg.Add(1)
 		go func(page int) {
 			defer wg.Done()
@@ -129,7 +163,7 @@ func ListShowsComplete(endpoint string, params napping.Params) Shows {
 				tmpParams[k] = v
 			}
 			rateLimiter.Call(func() {
-				napping.Get(
+				session.Get(
 					tmdbEndpoint+endpoint,
 					&tmpParams,
 					&tmp,
@@ -168,10 +202,34 @@ func MostVotedShowsComplete(genre string, language string) Movies {
 	})
 }
 
+func TrendingShows(language string) Shows {
+	return ListShowsPage("discover/tv", napping.Params{
+		"language":           language,
+		"sort_by":            "popularity.desc",
+		"first_air_date.lte": time.Now().UTC().Format("2006-01-02"),
+	})
+}
+
+func PopularShows(language string) Shows {
+	return ListShowsPage("tv/popular", napping.Params{"language": language})
+}
+
+func TopRatedShows(language string) Shows {
+	return ListShowsPage("tv/top_rated", napping.Params{"language": language})
+}
+
+func AiringTodayShows(language string) Shows {
+	return ListShowsPage("tv/airing_today", napping.Params{"language": language})
+}
+
+func SimilarShows(tmdbId int, language string) Shows {
+	return ListShowsPage(fmt.Sprintf("tv/%d/similar", tmdbId), napping.Params{"language": language})
+}
+
 func GetTVGenres(language string) []*Genre {
 	genres := GenreList{}
 	rateLimiter.Call(func() {
-		napping.Get(
+		session.Get(
 			tmdbEndpoint+"genre/tv/list",
 			&napping.Params{"api_key": apiKey, "language": language},
 			&genres,
@@ -181,23 +239,31 @@ func GetTVGenres(language string) []*Genre {
 	return genres.Genres
 }
 
+// LocalizedName is the name in the language the show was fetched in.
+func (show *Show) LocalizedName() string {
+	if show.Name != "" {
+		return show.Name
+	}
+	return show.OriginalName
+}
+
 func (show *Show) ToListItem() *xbmc.ListItem {
 	year, _ := strconv.Atoi(strings.Split(show.ReleaseDate, "-")[0])
 
 	item := &xbmc.ListItem{
-		Label: show.OriginalName,
+		Label: show.LocalizedName(),
 		Info: &xbmc.ListItemInfo{
 			Year:          year,
 			Count:         rand.Int(),
-			Title:         show.OriginalName,
-			OriginalTitle: show.Name,
+			Title:         show.LocalizedName(),
+			OriginalTitle: show.OriginalName,
 			Plot:          show.Overview,
 			PlotOutline:   show.Overview,
 			Code:          show.ExternalIDs.IMDBId,
 			Date:          show.ReleaseDate,
 			Votes:         strconv.Itoa(show.VoteCount),
 			Rating:        show.VoteAverage,
-			TVShowTitle:   show.OriginalName,
+			TVShowTitle:   show.LocalizedName(),
 			Premiered:     show.FirstAirDate,
 		},
 		Art: &xbmc.ListItemArt{
@@ -2,14 +2,12 @@ package tmdb
 
 import (
 	"fmt"
-	"path"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/jmcvetta/napping"
-	"github.com/steeve/pulsar/cache"
-	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/metacache"
 	"github.com/steeve/pulsar/util"
 )
 
@@ -60,18 +58,19 @@ type Credits struct {
 }
 
 type Entity struct {
-	IsAdult       bool      `json:"adult"`
-	BackdropPath  string    `json:"backdrop_path"`
-	Id            int       `json:"id"`
-	Genres        []*IdName `json:"genres"`
-	OriginalTitle string    `json:"original_title,omitempty"`
-	ReleaseDate   string    `json:"release_date"`
-	PosterPath    string    `json:"poster_path"`
-	Title         string    `json:"title,omitempty"`
-	VoteAverage   float32   `json:"vote_average"`
-	VoteCount     int       `json:"vote_count"`
-	OriginalName  string    `json:"original_name,omitempty"`
-	Name          string    `json:"name,omitempty"`
+	IsAdult          bool      `json:"adult"`
+	BackdropPath     string    `json:"backdrop_path"`
+	Id               int       `json:"id"`
+	Genres           []*IdName `json:"genres"`
+	OriginalTitle    string    `json:"original_title,omitempty"`
+	OriginalLanguage string    `json:"original_language,omitempty"`
+	ReleaseDate      string    `json:"release_date"`
+	PosterPath       string    `json:"poster_path"`
+	Title            string    `json:"title,omitempty"`
+	VoteAverage      float32   `json:"vote_average"`
+	VoteCount        int       `json:"vote_count"`
+	OriginalName     string    `json:"original_name,omitempty"`
+	Name             string    `json:"name,omitempty"`
 }
 
 type EntityList struct {
@@ -135,10 +134,15 @@ const (
 	burstTime               = 10 * time.Second
 	simultaneousConnections = 20
 	cacheTime               = 60 * 24 * time.Hour
+
+	// untranslated overviews are taken from there
+	FallbackLanguage = "en"
 )
 
 var rateLimiter = util.NewRateLimiter(burstRate, burstTime, simultaneousConnections)
 
+var session = &napping.Session{Client: util.NewHTTPClient(util.TLSTMDB)}
+
 func imageURL(uri string, size string) string {
 	return imageEndpoint + size + uri
 }
@@ -161,7 +165,7 @@ func ListEntities(endpoint string, params napping.Params) []*Entity {
 				tmpParams[k] = v
 			}
 			rateLimiter.Call(func() {
-				napping.Get(
+				session.Get(
 					tmdbEndpoint+endpoint,
 					&tmpParams,
 					&tmp,
@@ -181,19 +185,39 @@ func ListEntities(endpoint string, params napping.Params) []*Entity {
 func Find(externalId string, externalSource string) *FindResult {
 	var result *FindResult
 
-	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := fmt.Sprintf("com.tmdb.find.%s.%s", externalSource, externalId)
-	if err := cacheStore.Get(key, &result); err != nil {
+	key := fmt.Sprintf("%s.%s", externalSource, externalId)
+	if err := metacache.Get(metacache.Find, key, &result); err != nil {
 		rateLimiter.Call(func() {
-			napping.Get(
+			session.Get(
 				tmdbEndpoint+"find/"+externalId,
 				&napping.Params{"api_key": apiKey, "external_source": externalSource},
 				&result,
 				nil,
 			)
-			cacheStore.Set(key, result, 365*24*time.Hour)
+			if result != nil {
+				metacache.Set(metacache.Find, key, result)
+			}
 		})
 	}
 
 	return result
 }
+
+func Ping() error {
+	var result struct {
+		Images interface{} `json:"images"`
+	}
+	resp, err := session.Get(
+		tmdbEndpoint+"configuration",
+		&napping.Params{"api_key": apiKey},
+		&result,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	if resp.Status() != 200 {
+		return fmt.Errorf("TMDB answered with status %d", resp.Status())
+	}
+	return nil
+}
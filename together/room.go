@@ -0,0 +1,209 @@
+// Package together keeps Kodi clients watching the same thing in sync.
+// Clients join a room, report their play/pause/seek actions, and poll the
+// room events to replay the actions of the others locally.
+package together
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	EventJoin  = "join"
+	EventLeave = "leave"
+	EventPlay  = "play"
+	EventPause = "pause"
+	EventSeek  = "seek"
+
+	maxRoomEvents = 100
+	memberTimeout = 2 * time.Minute
+	// rooms nobody joined, published to or polled for this long are
+	// forgotten
+	roomIdleTimeout = 1 * time.Hour
+)
+
+var ErrUnknownEvent = errors.New("unknown event type")
+
+type Event struct {
+	Seq      int       `json:"seq"`
+	Type     string    `json:"type"`
+	Client   string    `json:"client"`
+	Position float64   `json:"position"`
+	URL      string    `json:"url,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// State is what a client joining the room needs to catch up.
+type State struct {
+	Playing  bool     `json:"playing"`
+	Position float64  `json:"position"`
+	URL      string   `json:"url"`
+	Seq      int      `json:"seq"`
+	Members  []string `json:"members"`
+}
+
+type Room struct {
+	mu       sync.Mutex
+	name     string
+	members  map[string]time.Time
+	events   []*Event
+	seq      int
+	playing  bool
+	position float64
+	url      string
+	updated  time.Time
+	active   time.Time
+	changed  chan struct{}
+}
+
+var roomsLock = sync.Mutex{}
+var rooms = map[string]*Room{}
+
+func GetRoom(name string) *Room {
+	roomsLock.Lock()
+	defer roomsLock.Unlock()
+	expireRooms()
+	room, exists := rooms[name]
+	if exists == false {
+		room = &Room{
+			name:    name,
+			members: map[string]time.Time{},
+			active:  time.Now(),
+			changed: make(chan struct{}),
+		}
+		rooms[name] = room
+	}
+	return room
+}
+
+// expireRooms forgets the idle rooms. Must be called with roomsLock held.
+func expireRooms() {
+	now := time.Now()
+	for name, room := range rooms {
+		room.mu.Lock()
+		idle := now.Sub(room.active) > roomIdleTimeout
+		room.mu.Unlock()
+		if idle {
+			delete(rooms, name)
+		}
+	}
+}
+
+// The position moves on its own while playing.
+func (room *Room) currentPosition() float64 {
+	if room.playing {
+		return room.position + time.Now().Sub(room.updated).Seconds()
+	}
+	return room.position
+}
+
+func (room *Room) memberNames() []string {
+	now := time.Now()
+	names := make([]string, 0, len(room.members))
+	for name, lastSeen := range room.members {
+		if now.Sub(lastSeen) > memberTimeout {
+			delete(room.members, name)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func (room *Room) State() *State {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return &State{
+		Playing:  room.playing,
+		Position: room.currentPosition(),
+		URL:      room.url,
+		Seq:      room.seq,
+		Members:  room.memberNames(),
+	}
+}
+
+func (room *Room) Join(client string) *State {
+	room.Publish(&Event{Type: EventJoin, Client: client})
+	return room.State()
+}
+
+func (room *Room) Leave(client string) {
+	room.Publish(&Event{Type: EventLeave, Client: client})
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	delete(room.members, client)
+}
+
+// Publish records an action from a client and wakes up the pollers.
+func (room *Room) Publish(event *Event) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	now := time.Now()
+	switch event.Type {
+	case EventJoin, EventLeave:
+		// updated moves to now below, so the position has to catch up
+		room.position = room.currentPosition()
+		event.Position = room.position
+	case EventPlay:
+		room.playing = true
+		room.position = event.Position
+		if event.URL != "" {
+			room.url = event.URL
+		}
+	case EventPause:
+		room.playing = false
+		room.position = event.Position
+	case EventSeek:
+		room.position = event.Position
+	default:
+		return ErrUnknownEvent
+	}
+	room.updated = now
+	room.active = now
+	room.members[event.Client] = now
+
+	room.seq++
+	event.Seq = room.seq
+	event.Time = now
+	room.events = append(room.events, event)
+	if len(room.events) > maxRoomEvents {
+		room.events = room.events[len(room.events)-maxRoomEvents:]
+	}
+
+	close(room.changed)
+	room.changed = make(chan struct{})
+	return nil
+}
+
+// Events returns the events after seq that were not sent by client,
+// waiting up to timeout for new ones.
+func (room *Room) Events(client string, seq int, timeout time.Duration) []*Event {
+	deadline := time.After(timeout)
+	for {
+		room.mu.Lock()
+		room.members[client] = time.Now()
+		room.active = room.members[client]
+		events := make([]*Event, 0)
+		for _, event := range room.events {
+			if event.Seq > seq && event.Client != client {
+				events = append(events, event)
+			}
+		}
+		if room.seq > seq {
+			seq = room.seq
+		}
+		changed := room.changed
+		room.mu.Unlock()
+
+		if len(events) > 0 {
+			return events
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return events
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package trakt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/profiles"
+	"github.com/steeve/pulsar/store"
+)
+
+const (
+	bucketName = "trakt"
+	tokenKey   = "token"
+)
+
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+func (token *Token) expires() time.Time {
+	return time.Unix(token.CreatedAt+token.ExpiresIn, 0)
+}
+
+var tokenLock = sync.Mutex{}
+
+func loadToken() *Token {
+	var token *Token
+	profiles.Current().Bucket(bucketName).Get(tokenKey, &token)
+	return token
+}
+
+func saveToken(token *Token) error {
+	return profiles.Current().Bucket(bucketName).Set(tokenKey, token, store.FOREVER)
+}
+
+// GetDeviceCode starts the device code flow: the user has to enter the
+// user code at the verification URL.
+func GetDeviceCode() (*DeviceCode, error) {
+	var code *DeviceCode
+	err := request("POST", "/oauth/device/code", map[string]string{
+		"client_id": config.Get().TraktClientId,
+	}, &code, false)
+	return code, err
+}
+
+// PollToken waits for the user to enter the code, until it expires or
+// cancel is closed.
+func PollToken(code *DeviceCode, cancel <-chan struct{}) (*Token, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expires := time.After(time.Duration(code.ExpiresIn) * time.Second)
+	payload := map[string]string{
+		"code":          code.DeviceCode,
+		"client_id":     config.Get().TraktClientId,
+		"client_secret": config.Get().TraktClientSecret,
+	}
+	for {
+		select {
+		case <-cancel:
+			return nil, ErrNotAuthorized
+		case <-expires:
+			return nil, ErrNotAuthorized
+		case <-time.After(interval):
+		}
+
+		var token *Token
+		err := request("POST", "/oauth/device/token", payload, &token, false)
+		if err == nil {
+			tokenLock.Lock()
+			defer tokenLock.Unlock()
+			return token, saveToken(token)
+		}
+		statusErr, ok := err.(*StatusError)
+		if ok == false {
+			return nil, err
+		}
+		switch statusErr.StatusCode {
+		case 400: // still waiting for the user
+		case 429:
+			interval += time.Second
+		default: // denied, expired or already used
+			return nil, err
+		}
+	}
+}
+
+// currentToken returns the access token, refreshing it when it expired.
+func currentToken() (*Token, error) {
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+
+	token := loadToken()
+	if token == nil || token.AccessToken == "" {
+		return nil, ErrNotAuthorized
+	}
+	// Trakt's times are its wall clock's, not the clock package's
+	if time.Now().Before(token.expires().Add(-24 * time.Hour)) {
+		return token, nil
+	}
+
+	var refreshed *Token
+	err := request("POST", "/oauth/token", map[string]string{
+		"refresh_token": token.RefreshToken,
+		"client_id":     config.Get().TraktClientId,
+		"client_secret": config.Get().TraktClientSecret,
+		"redirect_uri":  "urn:ietf:wg:oauth:2.0:oob",
+		"grant_type":    "refresh_token",
+	}, &refreshed, false)
+	if err != nil {
+		return nil, err
+	}
+	return refreshed, saveToken(refreshed)
+}
+
+func Authorized() bool {
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+	token := loadToken()
+	return token != nil && token.AccessToken != ""
+}
+
+func Deauthorize() error {
+	tokenLock.Lock()
+	defer tokenLock.Unlock()
+	return saveToken(&Token{})
+}
@@ -0,0 +1,74 @@
+package trakt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jmcvetta/napping"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/util"
+)
+
+// The v2 API, which the OAuth, sync and scrobble endpoints live on.
+const (
+	APIEndpoint = "https://api.trakt.tv"
+	APIVersion  = "2"
+)
+
+var (
+	httpClient = util.NewHTTPClient(util.TLSTrakt)
+	session    = &napping.Session{Client: httpClient}
+
+	ErrNotAuthorized = errors.New("trakt is not authorized")
+)
+
+// An error status, which the device code flow uses to tell where it's at.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("trakt: %s", err.Status)
+}
+
+// request calls the v2 API, signed with the access token when authorized
+// is set.
+func request(method string, path string, payload interface{}, result interface{}, authorized bool) error {
+	var body bytes.Buffer
+	if payload != nil {
+		if err := json.NewEncoder(&body).Encode(payload); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, APIEndpoint+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("trakt-api-key", config.Get().TraktClientId)
+	req.Header.Set("trakt-api-version", APIVersion)
+	if authorized {
+		token, err := currentToken()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if result == nil || resp.StatusCode == 204 {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
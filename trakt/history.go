@@ -0,0 +1,127 @@
+package trakt
+
+import "time"
+
+type WatchedMovie struct {
+	Plays         int       `json:"plays"`
+	LastWatchedAt time.Time `json:"last_watched_at"`
+	Movie         *Item     `json:"movie"`
+}
+
+type WatchedShow struct {
+	Plays         int              `json:"plays"`
+	LastWatchedAt time.Time        `json:"last_watched_at"`
+	Show          *Item            `json:"show"`
+	Seasons       []*WatchedSeason `json:"seasons"`
+}
+
+type WatchedSeason struct {
+	Number   int               `json:"number"`
+	Episodes []*WatchedEpisode `json:"episodes"`
+}
+
+type WatchedEpisode struct {
+	Number        int       `json:"number"`
+	Plays         int       `json:"plays"`
+	LastWatchedAt time.Time `json:"last_watched_at"`
+}
+
+// WatchedMovies returns the movies watched at least once.
+func WatchedMovies() ([]*WatchedMovie, error) {
+	var movies []*WatchedMovie
+	if err := request("GET", "/sync/watched/movies", nil, &movies, true); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// WatchedShows returns the shows with episodes watched, and those episodes.
+func WatchedShows() ([]*WatchedShow, error) {
+	var shows []*WatchedShow
+	if err := request("GET", "/sync/watched/shows", nil, &shows, true); err != nil {
+		return nil, err
+	}
+	return shows, nil
+}
+
+// HistoryItems are the movies and episodes to add to the watched history,
+// or to remove from it.
+type HistoryItems struct {
+	Movies []*historyMovie `json:"movies,omitempty"`
+	Shows  []*historyShow  `json:"shows,omitempty"`
+}
+
+type historyMovie struct {
+	WatchedAt *time.Time `json:"watched_at,omitempty"`
+	IDs       *IDs       `json:"ids"`
+}
+
+type historyShow struct {
+	IDs     *IDs             `json:"ids"`
+	Seasons []*historySeason `json:"seasons"`
+}
+
+type historySeason struct {
+	Number   int               `json:"number"`
+	Episodes []*historyEpisode `json:"episodes"`
+}
+
+type historyEpisode struct {
+	Number    int        `json:"number"`
+	WatchedAt *time.Time `json:"watched_at,omitempty"`
+}
+
+func watchedAt(at time.Time) *time.Time {
+	if at.IsZero() {
+		return nil
+	}
+	return &at
+}
+
+// AddMovie adds the movie, watched at a time, which a zero one leaves to
+// Trakt.
+func (items *HistoryItems) AddMovie(imdbId string, at time.Time) {
+	items.Movies = append(items.Movies, &historyMovie{
+		WatchedAt: watchedAt(at),
+		IDs:       &IDs{IMDB: imdbId},
+	})
+}
+
+func (items *HistoryItems) AddEpisode(tvdbId int, season int, episode int, at time.Time) {
+	var show *historyShow
+	for _, s := range items.Shows {
+		if s.IDs.TVDB == tvdbId {
+			show = s
+		}
+	}
+	if show == nil {
+		show = &historyShow{IDs: &IDs{TVDB: tvdbId}}
+		items.Shows = append(items.Shows, show)
+	}
+	var showSeason *historySeason
+	for _, s := range show.Seasons {
+		if s.Number == season {
+			showSeason = s
+		}
+	}
+	if showSeason == nil {
+		showSeason = &historySeason{Number: season}
+		show.Seasons = append(show.Seasons, showSeason)
+	}
+	showSeason.Episodes = append(showSeason.Episodes, &historyEpisode{Number: episode, WatchedAt: watchedAt(at)})
+}
+
+func (items *HistoryItems) Empty() bool {
+	return len(items.Movies) == 0 && len(items.Shows) == 0
+}
+
+// AddToHistory marks the items as watched.
+func AddToHistory(items *HistoryItems) error {
+	return request("POST", "/sync/history", items, nil, true)
+}
+
+// RemoveFromHistory marks the items as unwatched, forgetting all their
+// plays.
+func RemoveFromHistory(items *HistoryItems) error {
+	return request("POST", "/sync/history/remove", items, nil, true)
+}
@@ -31,7 +31,7 @@ type Movie struct {
 
 func SearchMovies(query string) MovieList {
 	var movies MovieList
-	napping.Get(fmt.Sprintf("%s/search/movies.json/%s", ENDPOINT, APIKEY),
+	session.Get(fmt.Sprintf("%s/search/movies.json/%s", ENDPOINT, APIKEY),
 		&napping.Params{"query": query, "limit": SearchLimit},
 		&movies,
 		nil)
@@ -39,12 +39,12 @@ func SearchMovies(query string) MovieList {
 }
 
 func TrendingMovies() (movies MovieList) {
-	napping.Get(fmt.Sprintf("%s/movies/trending.json/%s", ENDPOINT, APIKEY), nil, &movies, nil)
+	session.Get(fmt.Sprintf("%s/movies/trending.json/%s", ENDPOINT, APIKEY), nil, &movies, nil)
 	return
 }
 
 func NewMovie(IMDBId string) (movie *Movie) {
-	napping.Get(fmt.Sprintf("%s/movie/summary.json/%s/%s", ENDPOINT, APIKEY, IMDBId), nil, &movie, nil)
+	session.Get(fmt.Sprintf("%s/movie/summary.json/%s/%s", ENDPOINT, APIKEY, IMDBId), nil, &movie, nil)
 	return
 }
 
@@ -0,0 +1,35 @@
+package trakt
+
+const (
+	ScrobbleStart = "start"
+	ScrobblePause = "pause"
+	ScrobbleStop  = "stop"
+)
+
+type scrobbleEpisode struct {
+	Season int `json:"season"`
+	Number int `json:"number"`
+}
+
+type scrobble struct {
+	Movie    *Item            `json:"movie,omitempty"`
+	Show     *Item            `json:"show,omitempty"`
+	Episode  *scrobbleEpisode `json:"episode,omitempty"`
+	Progress float64          `json:"progress"`
+}
+
+// ScrobbleMovie tells Trakt where we're at, progress being a percentage.
+func ScrobbleMovie(action string, imdbId string, progress float64) error {
+	return request("POST", "/scrobble/"+action, &scrobble{
+		Movie:    &Item{IDs: &IDs{IMDB: imdbId}},
+		Progress: progress,
+	}, nil, true)
+}
+
+func ScrobbleEpisode(action string, tvdbId int, season int, episode int, progress float64) error {
+	return request("POST", "/scrobble/"+action, &scrobble{
+		Show:     &Item{IDs: &IDs{TVDB: tvdbId}},
+		Episode:  &scrobbleEpisode{Season: season, Number: episode},
+		Progress: progress,
+	}, nil, true)
+}
@@ -78,14 +78,14 @@ func sanitizeIds(show *Show) {
 
 func NewShow(TVDBId string) *Show {
 	var show *Show
-	napping.Get(fmt.Sprintf("%s/show/summary.json/%s/%s", ENDPOINT, APIKEY, TVDBId), nil, &show, nil)
+	session.Get(fmt.Sprintf("%s/show/summary.json/%s/%s", ENDPOINT, APIKEY, TVDBId), nil, &show, nil)
 	sanitizeIds(show)
 	return show
 }
 
 func SearchShows(query string) ShowList {
 	var shows ShowList
-	napping.Get(fmt.Sprintf("%s/search/shows.json/%s", ENDPOINT, APIKEY),
+	session.Get(fmt.Sprintf("%s/search/shows.json/%s", ENDPOINT, APIKEY),
 		&napping.Params{"query": query, "limit": SearchLimit},
 		&shows,
 		nil)
@@ -97,7 +97,7 @@ func SearchShows(query string) ShowList {
 
 func TrendingShows() ShowList {
 	var shows ShowList
-	napping.Get(fmt.Sprintf("%s/shows/trending.json/%s", ENDPOINT, APIKEY), nil, &shows, nil)
+	session.Get(fmt.Sprintf("%s/shows/trending.json/%s", ENDPOINT, APIKEY), nil, &shows, nil)
 	for _, show := range shows {
 		sanitizeIds(show)
 	}
@@ -106,7 +106,7 @@ func TrendingShows() ShowList {
 
 func (show *Show) Seasons() []*ShowSeason {
 	var seasons []*ShowSeason
-	napping.Get(fmt.Sprintf("%s/show/seasons.json/%s/%s", ENDPOINT, APIKEY, show.TVDBId), nil, &seasons, nil)
+	session.Get(fmt.Sprintf("%s/show/seasons.json/%s/%s", ENDPOINT, APIKEY, show.TVDBId), nil, &seasons, nil)
 	for _, season := range seasons {
 		season.Show = show
 	}
@@ -122,7 +122,7 @@ func (show *Show) Season(season int) *ShowSeason {
 
 func (season *ShowSeason) Episodes() []*ShowEpisode {
 	var episodes []*ShowEpisode
-	napping.Get(fmt.Sprintf("%s/show/season.json/%s/%s/%d", ENDPOINT, APIKEY, season.Show.TVDBId, season.Season), nil, &episodes, nil)
+	session.Get(fmt.Sprintf("%s/show/season.json/%s/%s/%d", ENDPOINT, APIKEY, season.Show.TVDBId, season.Season), nil, &episodes, nil)
 
 	var airedEpisodes []*ShowEpisode
 	now := time.Now().UTC().Unix()
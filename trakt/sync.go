@@ -0,0 +1,93 @@
+package trakt
+
+import "fmt"
+
+const (
+	Movies = "movies"
+	Shows  = "shows"
+)
+
+type IDs struct {
+	Trakt int    `json:"trakt,omitempty"`
+	Slug  string `json:"slug,omitempty"`
+	IMDB  string `json:"imdb,omitempty"`
+	TMDB  int    `json:"tmdb,omitempty"`
+	TVDB  int    `json:"tvdb,omitempty"`
+}
+
+// A movie or show as returned by the v2 API.
+type Item struct {
+	Title string `json:"title,omitempty"`
+	Year  int    `json:"year,omitempty"`
+	IDs   *IDs   `json:"ids"`
+}
+
+type ListEntry struct {
+	Movie *Item `json:"movie"`
+	Show  *Item `json:"show"`
+}
+
+func (entry *ListEntry) item() *Item {
+	if entry.Movie != nil {
+		return entry.Movie
+	}
+	return entry.Show
+}
+
+func tmdbIds(items []*Item) []int {
+	ids := make([]int, 0, len(items))
+	for _, item := range items {
+		if item != nil && item.IDs != nil && item.IDs.TMDB > 0 {
+			ids = append(ids, item.IDs.TMDB)
+		}
+	}
+	return ids
+}
+
+func listIds(path string) ([]int, error) {
+	var entries []*ListEntry
+	if err := request("GET", path, nil, &entries, true); err != nil {
+		return nil, err
+	}
+	items := make([]*Item, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, entry.item())
+	}
+	return tmdbIds(items), nil
+}
+
+// Watchlist returns the TMDB ids of the movies or shows in the watchlist.
+func Watchlist(kind string) ([]int, error) {
+	return listIds(fmt.Sprintf("/sync/watchlist/%s", kind))
+}
+
+func Collection(kind string) ([]int, error) {
+	return listIds(fmt.Sprintf("/sync/collection/%s", kind))
+}
+
+func Recommendations(kind string) ([]int, error) {
+	var items []*Item
+	if err := request("GET", fmt.Sprintf("/recommendations/%s", kind), nil, &items, true); err != nil {
+		return nil, err
+	}
+	return tmdbIds(items), nil
+}
+
+// List returns the TMDB ids of the movies and of the shows in a list of the
+// user, by its slug.
+func List(slug string) ([]int, []int, error) {
+	var entries []*ListEntry
+	if err := request("GET", fmt.Sprintf("/users/me/lists/%s/items", slug), nil, &entries, true); err != nil {
+		return nil, nil, err
+	}
+	movies := make([]*Item, 0)
+	shows := make([]*Item, 0)
+	for _, entry := range entries {
+		if entry.Movie != nil {
+			movies = append(movies, entry.Movie)
+		} else if entry.Show != nil {
+			shows = append(shows, entry.Show)
+		}
+	}
+	return tmdbIds(movies), tmdbIds(shows), nil
+}
@@ -0,0 +1,37 @@
+package tvdb
+
+import (
+	"fmt"
+
+	"github.com/steeve/pulsar/config"
+)
+
+func (show *Show) seasonEpisodeCount(seasonNumber int) int {
+	if count, ok := config.Get().AbsoluteSeasonEpisodes[fmt.Sprintf("%d.%d", show.Id, seasonNumber)]; ok {
+		return count
+	}
+	count := 0
+	for _, season := range show.Seasons {
+		for _, episode := range season.Episodes {
+			if episode.SeasonNumber == seasonNumber {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// ComputeAbsoluteNumber sums the episode counts of the previous seasons,
+// because TVDB absolute numbers are often missing or wrong on long running
+// shows. The count of a season can be overridden with the
+// absolute_season_episodes setting, e.g. "81797.1=61".
+func (show *Show) ComputeAbsoluteNumber(episode *Episode) int {
+	if episode.SeasonNumber <= 0 {
+		return episode.AbsoluteNumber
+	}
+	absoluteNumber := episode.EpisodeNumber
+	for seasonNumber := 1; seasonNumber < episode.SeasonNumber; seasonNumber++ {
+		absoluteNumber += show.seasonEpisodeCount(seasonNumber)
+	}
+	return absoluteNumber
+}
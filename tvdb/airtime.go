@@ -0,0 +1,116 @@
+package tvdb
+
+import (
+	"strings"
+	"time"
+)
+
+// TVDB air times are local to the network, which are mostly American.
+const defaultTimezone = "America/New_York"
+
+var networkTimezones = map[string]string{
+	"bbc one":      "Europe/London",
+	"bbc two":      "Europe/London",
+	"bbc three":    "Europe/London",
+	"bbc four":     "Europe/London",
+	"itv":          "Europe/London",
+	"channel 4":    "Europe/London",
+	"e4":           "Europe/London",
+	"sky1":         "Europe/London",
+	"sky atlantic": "Europe/London",
+	"abc (au)":     "Australia/Sydney",
+	"abc1":         "Australia/Sydney",
+	"cbc":          "America/Toronto",
+	"ctv":          "America/Toronto",
+	"tf1":          "Europe/Paris",
+	"canal+":       "Europe/Paris",
+	"zdf":          "Europe/Berlin",
+	"das erste":    "Europe/Berlin",
+	"svt1":         "Europe/Stockholm",
+	"dr1":          "Europe/Copenhagen",
+	"nrk1":         "Europe/Oslo",
+	"tokyo mx":     "Asia/Tokyo",
+	"tv tokyo":     "Asia/Tokyo",
+	"fuji tv":      "Asia/Tokyo",
+	"nhk":          "Asia/Tokyo",
+	"mbs":          "Asia/Tokyo",
+	"at-x":         "Asia/Tokyo",
+	"kbs2":         "Asia/Seoul",
+	"sbs":          "Asia/Seoul",
+	"tvn":          "Asia/Seoul",
+}
+
+// Some platforms ship without the timezone database, so we fall back to
+// the standard offsets, daylight saving time aside.
+var fallbackOffsets = map[string]int{
+	"America/New_York":  -5,
+	"America/Toronto":   -5,
+	"Europe/London":     0,
+	"Europe/Paris":      1,
+	"Europe/Berlin":     1,
+	"Europe/Stockholm":  1,
+	"Europe/Copenhagen": 1,
+	"Europe/Oslo":       1,
+	"Australia/Sydney":  10,
+	"Asia/Tokyo":        9,
+	"Asia/Seoul":        9,
+}
+
+func loadTimezone(name string) *time.Location {
+	if location, err := time.LoadLocation(name); err == nil {
+		return location
+	}
+	return time.FixedZone(name, fallbackOffsets[name]*60*60)
+}
+
+// Timezone is where the show airs, from its network.
+func (show *Show) Timezone() *time.Location {
+	if name, ok := networkTimezones[strings.ToLower(strings.TrimSpace(show.Network))]; ok {
+		return loadTimezone(name)
+	}
+	return loadTimezone(defaultTimezone)
+}
+
+var airsTimeLayouts = []string{"3:04 PM", "3:04PM", "3 PM", "3PM", "15:04"}
+
+// airsTime parses the many formats of Airs_Time, like "8:00 PM" or "20:00",
+// into the hours and minutes since midnight.
+func (show *Show) airsTime() (time.Duration, bool) {
+	airsTime := strings.ToUpper(strings.TrimSpace(show.AirsTime))
+	for _, layout := range airsTimeLayouts {
+		if t, err := time.Parse(layout, airsTime); err == nil {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, true
+		}
+	}
+	return 0, false
+}
+
+// AirDate is when the episode starts airing in the timezone of its network.
+// Without an air time, it's the end of the day there, so that we never say
+// it aired too early. It is zero when the date is unknown.
+func (show *Show) AirDate(episode *Episode) time.Time {
+	day, err := time.ParseInLocation("2006-01-02", episode.FirstAired, show.Timezone())
+	if err != nil {
+		return time.Time{}
+	}
+	if offset, ok := show.airsTime(); ok {
+		return day.Add(offset)
+	}
+	return day.AddDate(0, 0, 1)
+}
+
+// HasAired tells whether the episode is over where it airs. Episodes
+// without a known date haven't.
+func (show *Show) HasAired(episode *Episode) bool {
+	airDate := show.AirDate(episode)
+	if airDate.IsZero() {
+		return false
+	}
+	return airDate.Add(time.Duration(show.Runtime) * time.Minute).Before(time.Now())
+}
+
+// Upcoming tells whether the episode is known to air later. Undated ones,
+// often specials, aren't.
+func (show *Show) Upcoming(episode *Episode) bool {
+	return show.AirDate(episode).IsZero() == false && show.HasAired(episode) == false
+}
@@ -0,0 +1,71 @@
+package tvdb
+
+import (
+	"fmt"
+
+	"github.com/steeve/pulsar/config"
+)
+
+// Backend builds shows along with their seasons and episodes. Shows are
+// keyed by their TVDB id whichever the backend, as that's what the
+// providers search with.
+type Backend interface {
+	Name() string
+	NewShow(tvdbId string, language string) (*Show, error)
+}
+
+type tvdbBackend struct{}
+
+func (tvdbBackend) Name() string { return "TVDB" }
+
+func (tvdbBackend) NewShow(tvdbId string, language string) (*Show, error) {
+	show, err := NewShow(tvdbId, language)
+	if err != nil {
+		return nil, err
+	}
+	completeFromTMDB(show, language)
+	return show, nil
+}
+
+type tmdbBackend struct{}
+
+func (tmdbBackend) Name() string { return "TMDB" }
+
+func (tmdbBackend) NewShow(tvdbId string, language string) (*Show, error) {
+	return newShowFromTMDB(tvdbId, language)
+}
+
+// Backends are the one from the settings first, then the one to fall back
+// on when it's down.
+func Backends() []Backend {
+	if config.Get().TVMetadata == config.TVMetadataTMDB {
+		return []Backend{tmdbBackend{}, tvdbBackend{}}
+	}
+	return []Backend{tvdbBackend{}, tmdbBackend{}}
+}
+
+// FetchShow gets the show from the backends, skipping the cache.
+func FetchShow(tvdbId string, language string) (*Show, error) {
+	var err error
+	for i, backend := range Backends() {
+		var show *Show
+		if show, err = backend.NewShow(tvdbId, language); err == nil {
+			if i > 0 {
+				log.Info("Got show %s from %s", tvdbId, backend.Name())
+				show.Degraded = true
+			}
+			return show, nil
+		}
+		log.Warning("Unable to get show %s from %s: %s", tvdbId, backend.Name(), err)
+	}
+	return nil, err
+}
+
+// showKey tells the shows of each backend apart, so that changing it in the
+// settings takes effect at once.
+func showKey(tvdbId string, language string) string {
+	if config.Get().TVMetadata == config.TVMetadataTMDB {
+		return fmt.Sprintf("%s.%s.tmdb", tvdbId, language)
+	}
+	return fmt.Sprintf("%s.%s", tvdbId, language)
+}
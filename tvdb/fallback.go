@@ -0,0 +1,151 @@
+package tvdb
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/tmdb"
+)
+
+var log = logging.MustGetLogger("tvdb")
+
+func findTMDBShow(tvdbId string, language string) *tmdb.Show {
+	results := tmdb.Find(tvdbId, "tvdb_id")
+	if results == nil {
+		return nil
+	}
+	for _, result := range results.TVResults {
+		return tmdb.GetShow(result.Id, language)
+	}
+	return nil
+}
+
+func episodeFromTMDB(tvdbId int, episode *tmdb.Episode) *Episode {
+	return &Episode{
+		Id:            strconv.Itoa(episode.Id),
+		EpisodeName:   episode.Name,
+		EpisodeNumber: episode.EpisodeNumber,
+		SeasonNumber:  episode.SeasonNumber,
+		FirstAired:    episode.AirDate,
+		Overview:      episode.Overview,
+		Rating:        strconv.FormatFloat(float64(episode.VoteAverage), 'f', 1, 32),
+		RatingCount:   strconv.Itoa(episode.VoteCount),
+		FileName:      tmdb.ImageURL(episode.StillPath, "w500"),
+		SeriesId:      strconv.Itoa(tvdbId),
+	}
+}
+
+func seasonFromTMDB(tvdbId int, tmdbShow *tmdb.Show, seasonNumber int, language string) *Season {
+	season := &Season{
+		Season:   seasonNumber,
+		Episodes: make(EpisodeList, 0),
+	}
+	tmdbSeason := tmdb.GetSeason(tmdbShow.Id, seasonNumber, language)
+	if tmdbSeason == nil {
+		return season
+	}
+	for _, episode := range tmdbSeason.Episodes {
+		season.Episodes = append(season.Episodes, episodeFromTMDB(tvdbId, episode))
+	}
+	return season
+}
+
+// newShowFromTMDB builds the show from TMDB's TV data, when it's the backend
+// or TVDB is down.
+func newShowFromTMDB(tvdbId string, language string) (*Show, error) {
+	tmdbShow := findTMDBShow(tvdbId, language)
+	if tmdbShow == nil {
+		return nil, errors.New("show not found on TMDB")
+	}
+	id, _ := strconv.Atoi(tvdbId)
+	genres := make([]string, 0, len(tmdbShow.Genres))
+	for _, genre := range tmdbShow.Genres {
+		genres = append(genres, genre.Name)
+	}
+	show := &Show{
+		Id:         id,
+		SeriesName: tmdbShow.Name,
+		Overview:   tmdbShow.Overview,
+		FirstAired: tmdbShow.FirstAirDate,
+		Genre:      "|" + strings.Join(genres, "|") + "|",
+		Language:   language,
+		Poster:     tmdb.ImageURL(tmdbShow.PosterPath, "w500"),
+		FanArt:     tmdb.ImageURL(tmdbShow.BackdropPath, "w1280"),
+		Seasons:    make(SeasonList, 0, tmdbShow.NumberOfSeasons+1),
+		Banners:    make([]*Banner, 0),
+		Actors:     make([]*Actor, 0),
+	}
+	if tmdbShow.ExternalIDs != nil {
+		show.ImdbId = tmdbShow.ExternalIDs.IMDBId
+	}
+	for _, network := range tmdbShow.Networks {
+		show.Network = network.Name
+		break
+	}
+	for _, runtime := range tmdbShow.EpisodeRunTime {
+		show.Runtime = runtime
+		break
+	}
+	if tmdbShow.Images != nil {
+		for _, backdrop := range tmdbShow.Images.Backdrops {
+			show.Banners = append(show.Banners, &Banner{
+				BannerType: "fanart",
+				BannerPath: tmdb.ImageURL(backdrop.FilePath, "w1280"),
+				Language:   backdrop.ISO_639_1,
+			})
+		}
+	}
+	if tmdbShow.Credits != nil {
+		for _, cast := range tmdbShow.Credits.Cast {
+			show.Actors = append(show.Actors, &Actor{
+				Id:        strconv.Itoa(cast.Id),
+				Name:      cast.Name,
+				Role:      cast.Character,
+				Image:     tmdb.ImageURL(cast.ProfilePath, "w185"),
+				SortOrder: cast.Order,
+			})
+		}
+	}
+	for seasonNumber := 0; seasonNumber <= tmdbShow.NumberOfSeasons; seasonNumber++ {
+		show.Seasons = append(show.Seasons, seasonFromTMDB(id, tmdbShow, seasonNumber, language))
+		// the season posters, as TVDB's season banners
+		if tmdbSeason := tmdb.GetSeason(tmdbShow.Id, seasonNumber, language); tmdbSeason != nil && tmdbSeason.PosterPath != "" {
+			show.Banners = append(show.Banners, &Banner{
+				BannerType:  "season",
+				BannerType2: "season",
+				BannerPath:  tmdb.ImageURL(tmdbSeason.PosterPath, "w500"),
+				Language:    language,
+				Season:      seasonNumber,
+			})
+		}
+	}
+	return show, nil
+}
+
+// TVDB sometimes misses whole seasons, fill them from TMDB.
+func completeFromTMDB(show *Show, language string) {
+	tmdbShow := findTMDBShow(strconv.Itoa(show.Id), language)
+	if tmdbShow == nil {
+		return
+	}
+	for seasonNumber := 1; seasonNumber <= tmdbShow.NumberOfSeasons; seasonNumber++ {
+		if seasonNumber < len(show.Seasons) && len(show.Seasons[seasonNumber].Episodes) > 0 {
+			continue
+		}
+		season := seasonFromTMDB(show.Id, tmdbShow, seasonNumber, language)
+		if len(season.Episodes) == 0 {
+			continue
+		}
+		log.Info("Season %d of %s is missing on TVDB, using TMDB", seasonNumber, show.SeriesName)
+		for len(show.Seasons) <= seasonNumber {
+			show.Seasons = append(show.Seasons, &Season{
+				Season:   len(show.Seasons),
+				Episodes: make(EpisodeList, 0),
+			})
+		}
+		show.Seasons[seasonNumber] = season
+		show.Degraded = true
+	}
+}
@@ -0,0 +1,19 @@
+package tvdb
+
+import (
+	"github.com/steeve/pulsar/metacache"
+	"github.com/steeve/pulsar/tmdb"
+)
+
+// RefreshShow fetches the show again from its backend, and what completes
+// it from TMDB, so that renamed episodes and new seasons show up.
+func RefreshShow(tvdbId string, language string) (*Show, error) {
+	if results := tmdb.Find(tvdbId, "tvdb_id"); results != nil {
+		for _, result := range results.TVResults {
+			tmdb.RefreshShow(result.Id, language)
+			break
+		}
+	}
+	metacache.Invalidate(metacache.Episodes, showKey(tvdbId, language))
+	return NewShowCached(tvdbId, language)
+}
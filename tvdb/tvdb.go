@@ -6,14 +6,12 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io/ioutil"
-	"net/http"
-	"path"
 	"sort"
 	"strconv"
 	"time"
 
-	"github.com/steeve/pulsar/cache"
-	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/metacache"
+	"github.com/steeve/pulsar/util"
 )
 
 const (
@@ -24,8 +22,11 @@ const (
 	burstTime               = 1 * time.Second
 	simultaneousConnections = 20
 	cacheTime               = 2 * time.Hour
+	degradedShowTTL         = 15 * time.Minute
 )
 
+var httpClient = util.NewHTTPClient(util.TLSTVDB)
+
 type SeasonList []*Season
 type EpisodeList []*Episode
 
@@ -83,6 +84,9 @@ type Show struct {
 	Seasons SeasonList `xml:"-"`
 	Banners []*Banner  `xml:"-"`
 	Actors  []*Actor   `xml:"-"`
+
+	// filled in from the fallback backend
+	Degraded bool `xml:"-"`
 }
 
 type Season struct {
@@ -125,7 +129,7 @@ func NewShow(tvdbId string, language string) (*Show, error) {
 		Actors []*Actor `xml:"Actor"`
 	}
 
-	resp, err := http.Get(fmt.Sprintf("%s/%s/series/%s/all/%s.zip", tvdbEndpoint, apiKey, tvdbId, language))
+	resp, err := httpClient.Get(fmt.Sprintf("%s/%s/series/%s/all/%s.zip", tvdbEndpoint, apiKey, tvdbId, language))
 	if err != nil {
 		return nil, err
 	}
@@ -195,15 +199,17 @@ func NewShow(tvdbId string, language string) (*Show, error) {
 
 func NewShowCached(tvdbId string, language string) (*Show, error) {
 	var show *Show
-	cacheStore := cache.NewFileStore(path.Join(config.Get().ProfilePath, "cache"))
-	key := fmt.Sprintf("com.tvdb.show.%s.%s", tvdbId, language)
-	if err := cacheStore.Get(key, &show); err != nil {
-		newShow, err := NewShow(tvdbId, language)
+	key := showKey(tvdbId, language)
+	if err := metacache.Get(metacache.Episodes, key, &show); err != nil {
+		newShow, err := FetchShow(tvdbId, language)
 		if err != nil {
 			return nil, err
 		}
-		if newShow != nil {
-			cacheStore.Set(key, newShow, cacheTime)
+		if newShow.Degraded {
+			// try the backend again soon, it may be back
+			metacache.SetTransient(metacache.Episodes, key, newShow, degradedShowTTL)
+		} else {
+			metacache.Set(metacache.Episodes, key, newShow)
 		}
 		show = newShow
 	}
@@ -4,12 +4,16 @@ import (
 	"fmt"
 	"math/rand"
 	"strings"
-	"time"
 
+	"github.com/steeve/pulsar/fanart"
 	"github.com/steeve/pulsar/xbmc"
 )
 
 func imageURL(path string) string {
+	// shows built from TMDB already have full URLs
+	if strings.HasPrefix(path, "http") {
+		return path
+	}
 	return tvdbUrl + "/banners/" + path
 }
 
@@ -22,20 +26,19 @@ func (seasons SeasonList) ToListItems(show *Show) []*xbmc.ListItem {
 			fanarts = append(fanarts, imageURL(banner.BannerPath))
 		}
 	}
-	now := time.Now().UTC()
+	images := fanart.GetShow(show.Id)
 	for _, season := range seasons {
 		if len(season.Episodes) == 0 {
 			continue
 		}
-		airedDateTime := fmt.Sprintf("%s %s EST", season.Episodes[0].FirstAired, show.AirsTime)
-		firstAired, _ := time.Parse("2006-01-02 3:04 PM MST", airedDateTime)
-		if firstAired.Add(time.Duration(show.Runtime) * time.Minute).After(now) {
+		if show.Upcoming(season.Episodes[0]) {
 			continue
 		}
 		item := season.ToListItem(show)
 		if len(fanarts) > 0 {
 			item.Art.FanArt = fanarts[rand.Int()%len(fanarts)]
 		}
+		images.ApplySeason(item, season.Season, show.Language)
 		items = append(items, item)
 	}
 	return items
@@ -53,11 +56,8 @@ func (episodes EpisodeList) ToListItems(show *Show) []*xbmc.ListItem {
 			fanarts = append(fanarts, imageURL(banner.BannerPath))
 		}
 	}
-	now := time.Now().UTC()
 	for _, episode := range episodes {
-		airedDateTime := fmt.Sprintf("%s %s EST", episode.FirstAired, show.AirsTime)
-		firstAired, _ := time.Parse("2006-01-02 3:04 PM MST", airedDateTime)
-		if firstAired.Add(time.Duration(show.Runtime) * time.Minute).After(now) {
+		if show.Upcoming(episode) {
 			continue
 		}
 		item := episode.ToListItem(show)
@@ -0,0 +1,115 @@
+// Package undo delays destructive actions for a short grace period, during
+// which they can be cancelled.
+package undo
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/clock"
+)
+
+const GracePeriod = 10 * time.Second
+
+var log = logging.MustGetLogger("undo")
+
+type Action struct {
+	Id          string    `json:"id"`
+	Description string    `json:"description"`
+	Deadline    time.Time `json:"deadline"`
+
+	timer *time.Timer
+}
+
+type byDeadline []*Action
+
+func (a byDeadline) Len() int      { return len(a) }
+func (a byDeadline) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byDeadline) Less(i, j int) bool {
+	if a[i].Deadline.Equal(a[j].Deadline) {
+		// ids go up as they're scheduled
+		iId, _ := strconv.Atoi(a[i].Id)
+		jId, _ := strconv.Atoi(a[j].Id)
+		return iId < jId
+	}
+	return a[i].Deadline.Before(a[j].Deadline)
+}
+
+var (
+	lock    = sync.Mutex{}
+	nextId  = 0
+	pending = map[string]*Action{}
+)
+
+// Schedule runs commit after the grace period, unless undone before.
+func Schedule(description string, commit func()) *Action {
+	lock.Lock()
+	defer lock.Unlock()
+
+	nextId++
+	action := &Action{
+		Id:          strconv.Itoa(nextId),
+		Description: description,
+		Deadline:    clock.Now().Add(GracePeriod),
+	}
+	action.timer = time.AfterFunc(GracePeriod, func() {
+		lock.Lock()
+		_, stillPending := pending[action.Id]
+		delete(pending, action.Id)
+		lock.Unlock()
+		if stillPending {
+			log.Info("Running %s", action.Description)
+			commit()
+		}
+	})
+	pending[action.Id] = action
+	log.Info("Scheduled %s in %s", description, GracePeriod)
+	return action
+}
+
+// Undo cancels the action, and returns whether it was still pending.
+func Undo(id string) bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	action, ok := pending[id]
+	if ok == false {
+		return false
+	}
+	action.timer.Stop()
+	delete(pending, id)
+	log.Info("Undid %s", action.Description)
+	return true
+}
+
+// UndoLast cancels the most recently scheduled action.
+func UndoLast() *Action {
+	lock.Lock()
+	var last *Action
+	for _, action := range pending {
+		if last == nil || action.Deadline.After(last.Deadline) {
+			last = action
+		}
+	}
+	lock.Unlock()
+	if last == nil || Undo(last.Id) == false {
+		return nil
+	}
+	return last
+}
+
+// Pending lists the actions in the order they were scheduled.
+func Pending() []*Action {
+	lock.Lock()
+	defer lock.Unlock()
+
+	actions := make([]*Action, 0, len(pending))
+	for _, action := range pending {
+		actions = append(actions, action)
+	}
+	sort.Sort(byDeadline(actions))
+	return actions
+}
@@ -0,0 +1,139 @@
+package usenet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type nzbget struct {
+	host     string
+	username string
+	password string
+	category string
+}
+
+type nzbgetRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+type nzbgetResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type nzbgetGroup struct {
+	NZBID           int    `json:"NZBID"`
+	NZBName         string `json:"NZBName"`
+	Name            string `json:"Name"` // in the history
+	Status          string `json:"Status"`
+	FileSizeMB      int64  `json:"FileSizeMB"`
+	RemainingSizeMB int64  `json:"RemainingSizeMB"`
+	DestDir         string `json:"DestDir"`
+	FinalDir        string `json:"FinalDir"`
+}
+
+func (nzb *nzbget) Name() string { return "NZBGet" }
+
+func (nzb *nzbget) call(method string, result interface{}, params ...interface{}) error {
+	body, err := json.Marshal(&nzbgetRequest{Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(nzb.rpcURL(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return errors.New(resp.Status)
+	}
+	response := &nzbgetResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return errors.New(response.Error.Message)
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// rpcURL has the credentials in it, as NZBGet takes them from there too.
+func (nzb *nzbget) rpcURL() string {
+	if nzb.username == "" {
+		return nzb.host + "/jsonrpc"
+	}
+	parts := strings.SplitN(nzb.host, "://", 2)
+	if len(parts) != 2 {
+		return nzb.host + "/jsonrpc"
+	}
+	return fmt.Sprintf("%s://%s:%s@%s/jsonrpc", parts[0], nzb.username, nzb.password, parts[1])
+}
+
+func (nzb *nzbget) Add(nzbURL string, name string) (string, error) {
+	id := 0
+	// name, content, category, priority, add to top, add paused, dupe key,
+	// dupe score, dupe mode, post-processing parameters
+	if err := nzb.call("append", &id, name+".nzb", nzbURL, nzb.category, 0, true, false, "", 0, "SCORE", []interface{}{}); err != nil {
+		return "", err
+	}
+	if id <= 0 {
+		return "", errors.New("NZBGet refused the NZB")
+	}
+	return strconv.Itoa(id), nil
+}
+
+func (nzb *nzbget) Job(id string) (*Job, error) {
+	nzbId, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrNoJob
+	}
+	groups := make([]*nzbgetGroup, 0)
+	if err := nzb.call("listgroups", &groups, 0); err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		if group.NZBID != nzbId {
+			continue
+		}
+		job := &Job{Id: id, Name: group.NZBName, State: StateDownloading}
+		if group.FileSizeMB > 0 {
+			job.Progress = 1 - float64(group.RemainingSizeMB)/float64(group.FileSizeMB)
+		}
+		switch {
+		case group.Status == "QUEUED" || group.Status == "PAUSED":
+			job.State = StateQueued
+		case strings.HasPrefix(group.Status, "PP_") || group.Status != "DOWNLOADING" && group.Status != "FETCHING":
+			job.State = StateProcessing
+		}
+		return job, nil
+	}
+
+	history := make([]*nzbgetGroup, 0)
+	if err := nzb.call("history", &history, false); err != nil {
+		return nil, err
+	}
+	for _, entry := range history {
+		if entry.NZBID != nzbId {
+			continue
+		}
+		job := &Job{Id: id, Name: entry.Name, State: StateCompleted, Progress: 1}
+		if strings.HasPrefix(entry.Status, "SUCCESS") {
+			job.Path = entry.FinalDir
+			if job.Path == "" {
+				job.Path = entry.DestDir
+			}
+		} else {
+			job.State = StateFailed
+			job.Error = "NZBGet reported " + strings.ToLower(entry.Status)
+		}
+		return job, nil
+	}
+	return nil, ErrNoJob
+}
@@ -0,0 +1,114 @@
+package usenet
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type sabnzbd struct {
+	host     string
+	apiKey   string
+	category string
+}
+
+type sabAddResponse struct {
+	Status bool     `json:"status"`
+	Error  string   `json:"error"`
+	NzoIds []string `json:"nzo_ids"`
+}
+
+type sabSlot struct {
+	NzoId       string `json:"nzo_id"`
+	Filename    string `json:"filename"`
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Percentage  string `json:"percentage"`
+	Storage     string `json:"storage"`
+	FailMessage string `json:"fail_message"`
+}
+
+type sabQueueResponse struct {
+	Queue struct {
+		Slots []*sabSlot `json:"slots"`
+	} `json:"queue"`
+}
+
+type sabHistoryResponse struct {
+	History struct {
+		Slots []*sabSlot `json:"slots"`
+	} `json:"history"`
+}
+
+func (sab *sabnzbd) Name() string { return "SABnzbd" }
+
+func (sab *sabnzbd) call(mode string, params url.Values, response interface{}) error {
+	params.Set("mode", mode)
+	params.Set("apikey", sab.apiKey)
+	params.Set("output", "json")
+	resp, err := httpClient.Get(sab.host + "/api?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return errors.New(resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+func (sab *sabnzbd) Add(nzbURL string, name string) (string, error) {
+	params := url.Values{"name": {nzbURL}, "nzbname": {name}}
+	if sab.category != "" {
+		params.Set("cat", sab.category)
+	}
+	response := &sabAddResponse{}
+	if err := sab.call("addurl", params, response); err != nil {
+		return "", err
+	}
+	if response.Status == false || len(response.NzoIds) == 0 {
+		return "", errors.New("SABnzbd refused the NZB: " + response.Error)
+	}
+	return response.NzoIds[0], nil
+}
+
+func (sab *sabnzbd) Job(id string) (*Job, error) {
+	queue := &sabQueueResponse{}
+	if err := sab.call("queue", url.Values{"nzo_ids": {id}}, queue); err != nil {
+		return nil, err
+	}
+	for _, slot := range queue.Queue.Slots {
+		if slot.NzoId == id {
+			percentage, _ := strconv.ParseFloat(slot.Percentage, 64)
+			job := &Job{Id: id, Name: slot.Filename, State: StateDownloading, Progress: percentage / 100}
+			if strings.EqualFold(slot.Status, "Queued") || strings.EqualFold(slot.Status, "Paused") {
+				job.State = StateQueued
+			}
+			return job, nil
+		}
+	}
+
+	// done downloading
+	history := &sabHistoryResponse{}
+	if err := sab.call("history", url.Values{"nzo_ids": {id}}, history); err != nil {
+		return nil, err
+	}
+	for _, slot := range history.History.Slots {
+		if slot.NzoId != id {
+			continue
+		}
+		job := &Job{Id: id, Name: slot.Name, State: StateProcessing, Progress: 1}
+		switch strings.ToLower(slot.Status) {
+		case "completed":
+			job.State = StateCompleted
+			job.Path = slot.Storage
+		case "failed":
+			job.State = StateFailed
+			job.Error = slot.FailMessage
+		}
+		return job, nil
+	}
+	return nil, ErrNoJob
+}
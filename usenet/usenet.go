@@ -0,0 +1,170 @@
+// Package usenet hands NZB results to a SABnzbd or NZBGet instance, and
+// follows their downloads until the files can be played.
+package usenet
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/util"
+)
+
+// States of the jobs, whichever the client.
+const (
+	StateQueued      = "queued"
+	StateDownloading = "downloading"
+	StateProcessing  = "processing" // verifying, repairing, unpacking
+	StateCompleted   = "completed"
+	StateFailed      = "failed"
+)
+
+const pollInterval = 2 * time.Second
+
+var log = logging.MustGetLogger("usenet")
+
+var (
+	ErrDisabled = errors.New("no usenet client set up")
+	ErrCanceled = errors.New("canceled")
+	ErrNoVideo  = errors.New("no video in the download")
+	ErrNoJob    = errors.New("no such job")
+)
+
+var videoExtensions = map[string]bool{
+	".mkv": true, ".mp4": true, ".avi": true, ".m4v": true, ".mov": true,
+	".wmv": true, ".ts": true, ".m2ts": true, ".mpg": true, ".mpeg": true,
+}
+
+// Job is a download of the client.
+type Job struct {
+	Id       string  `json:"id"`
+	Name     string  `json:"name"`
+	State    string  `json:"state"`
+	Progress float64 `json:"progress"`
+	Error    string  `json:"error,omitempty"`
+	Path     string  `json:"path,omitempty"` // once completed
+}
+
+type Client interface {
+	Name() string
+	// Add queues the NZB at the URL, and returns the id of its job.
+	Add(nzbURL string, name string) (string, error)
+	Job(id string) (*Job, error)
+}
+
+var httpClient = util.NewHTTPClient(util.TLSDefault)
+
+// NewClient returns the client from the settings.
+func NewClient() (Client, error) {
+	conf := config.Get()
+	host := strings.TrimSuffix(conf.UsenetHost, "/")
+	switch {
+	case host == "":
+		return nil, ErrDisabled
+	case conf.UsenetClient == config.UsenetClientSABnzbd:
+		return &sabnzbd{host: host, apiKey: conf.UsenetAPIKey, category: conf.UsenetCategory}, nil
+	case conf.UsenetClient == config.UsenetClientNZBGet:
+		return &nzbget{host: host, username: conf.UsenetUsername, password: conf.UsenetPassword, category: conf.UsenetCategory}, nil
+	}
+	return nil, ErrDisabled
+}
+
+// Enabled tells whether NZB results can be played.
+func Enabled() bool {
+	_, err := NewClient()
+	return err == nil
+}
+
+var (
+	jobsLock = sync.Mutex{}
+	jobs     = make(map[string]string) // NZB URLs to their jobs
+)
+
+// Start queues the NZB, unless it was already this session.
+func Start(client Client, nzbURL string, name string) (string, error) {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+	if id, ok := jobs[nzbURL]; ok {
+		if job, err := client.Job(id); err == nil && job.State != StateFailed {
+			return id, nil
+		}
+	}
+	id, err := client.Add(nzbURL, name)
+	if err != nil {
+		return "", err
+	}
+	log.Info("Sent %s to %s as job %s", name, client.Name(), id)
+	jobs[nzbURL] = id
+	return id, nil
+}
+
+// Wait polls the job until it's completed, calling progress on the way,
+// which stops waiting when it returns false.
+func Wait(client Client, id string, progress func(job *Job) bool) (*Job, error) {
+	for {
+		job, err := client.Job(id)
+		if err != nil {
+			return nil, err
+		}
+		switch job.State {
+		case StateCompleted:
+			return job, nil
+		case StateFailed:
+			return job, errors.New(job.Error)
+		}
+		if progress(job) == false {
+			return job, ErrCanceled
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// VideoFile is the biggest video of the completed download, samples
+// aside.
+func VideoFile(path string) (string, error) {
+	best := ""
+	bestSize := int64(0)
+	filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		name := strings.ToLower(info.Name())
+		if videoExtensions[filepath.Ext(name)] == false || strings.Contains(name, "sample") {
+			return nil
+		}
+		if info.Size() > bestSize {
+			best, bestSize = file, info.Size()
+		}
+		return nil
+	})
+	if best == "" {
+		return "", ErrNoVideo
+	}
+	return best, nil
+}
+
+var (
+	filesLock = sync.RWMutex{}
+	files     = make(map[string]string)
+)
+
+// Serve makes the file of the job available to File, for the HTTP server.
+func Serve(id string, file string) {
+	filesLock.Lock()
+	defer filesLock.Unlock()
+	files[id] = file
+}
+
+func File(id string) (string, error) {
+	filesLock.RLock()
+	defer filesLock.RUnlock()
+	if file, ok := files[id]; ok {
+		return file, nil
+	}
+	return "", ErrNoJob
+}
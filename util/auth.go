@@ -0,0 +1,152 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/steeve/pulsar/clock"
+	"github.com/steeve/pulsar/config"
+)
+
+// With a password or a token in the settings, the HTTP service wants them
+// from the LAN, either with basic auth or as a bearer token. Kodi and the
+// addons on this box go through without, and so do the stream URLs we give
+// out, which are signed with a key made at startup, so that they stop
+// working with the next session.
+
+const signedURLValidity = 24 * time.Hour
+
+var urlKey = newURLKey()
+
+func newURLKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// AuthRequired tells whether requests from the LAN need credentials.
+func AuthRequired() bool {
+	conf := config.Get()
+	return conf.HTTPPassword != "" || conf.HTTPToken != ""
+}
+
+// SameSecret compares in constant time, an empty secret matching nothing.
+func SameSecret(given string, secret string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(given), []byte(secret)) == 1
+}
+
+func hasCredentials(r *http.Request) bool {
+	conf := config.Get()
+	if user, password, ok := r.BasicAuth(); ok {
+		sameUser := subtle.ConstantTimeCompare([]byte(user), []byte(conf.HTTPUsername)) == 1
+		return sameUser && SameSecret(password, conf.HTTPPassword)
+	}
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return SameSecret(strings.TrimPrefix(header, "Bearer "), conf.HTTPToken)
+	}
+	return false
+}
+
+// IsLocalRequest tells whether the request comes from this box, which may
+// be through our LAN address, as the URLs we give out use it.
+func IsLocalRequest(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	return isLocalIP(net.ParseIP(host))
+}
+
+// isLocalIP tells whether the IP is one of this box's.
+func isLocalIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func urlSignature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, urlKey)
+	fmt.Fprintf(mac, "%s\n%d", path, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignURL lets the URL through without credentials for a day, when they're
+// needed.
+func SignURL(rawURL string) string {
+	if AuthRequired() == false {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	expires := clock.Now().Add(signedURLValidity).Unix()
+	query := u.Query()
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", urlSignature(u.Path, expires))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func hasValidSignature(r *http.Request) bool {
+	query := r.URL.Query()
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || clock.Now().Unix() > expires {
+		return false
+	}
+	if hmac.Equal([]byte(query.Get("sig")), []byte(urlSignature(r.URL.Path, expires))) {
+		return true
+	}
+	// signed with the base path, which Forwarded stripped
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil && u.Path != r.URL.Path {
+		return hmac.Equal([]byte(query.Get("sig")), []byte(urlSignature(u.Path, expires)))
+	}
+	return false
+}
+
+// RequestAllowed tells whether the request may go through.
+func RequestAllowed(r *http.Request) bool {
+	return AuthRequired() == false || IsLocalRequest(r) || hasCredentials(r) || hasValidSignature(r)
+}
+
+// DenyUnauthorized answers requests that aren't allowed.
+func DenyUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="Pulsar"`)
+	http.Error(w, "Unauthorized", 401)
+}
+
+// RequireAuth guards the handlers not going through the API routes.
+func RequireAuth(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RequestAllowed(r) == false {
+			DenyUnauthorized(w)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,169 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+const (
+	DateDMY = "dmy"
+	DateMDY = "mdy"
+	DateYMD = "ymd"
+)
+
+// Region is how sizes, dates and times are written in the labels we build.
+type Region struct {
+	BinaryUnits bool
+	Clock24     bool
+	DateOrder   string
+}
+
+var (
+	regionLock = sync.Mutex{}
+	region     *Region
+)
+
+// dateOrderOf finds the order of a format like DD/MM/YYYY.
+func dateOrderOf(format string) string {
+	format = strings.ToUpper(format)
+	day, month, year := strings.Index(format, "D"), strings.Index(format, "M"), strings.Index(format, "Y")
+	if day < 0 || month < 0 || year < 0 {
+		return ""
+	}
+	switch {
+	case year < month && month < day:
+		return DateYMD
+	case month < day:
+		return DateMDY
+	}
+	return DateDMY
+}
+
+// xbmcRegion reads the region settings of XBMC. Those left to "regional"
+// are guessed from the country, whose names tell 12h from 24h ones.
+func xbmcRegion() *Region {
+	r := &Region{Clock24: true, DateOrder: DateDMY}
+	country, _ := xbmc.GetSettingValue("locale.country").(string)
+	if strings.Contains(country, "USA") {
+		r.DateOrder = DateMDY
+	}
+	if strings.Contains(country, "12h") {
+		r.Clock24 = false
+	}
+	if clock, _ := xbmc.GetSettingValue("locale.use24hourclock").(string); clock != "" && clock != "regional" {
+		r.Clock24 = clock != "12hours"
+	}
+	if format, _ := xbmc.GetSettingValue("locale.shortdateformat").(string); format != "regional" {
+		if order := dateOrderOf(format); order != "" {
+			r.DateOrder = order
+		}
+	}
+	return r
+}
+
+// CurrentRegion is the region of XBMC, overridden by the settings.
+func CurrentRegion() *Region {
+	regionLock.Lock()
+	defer regionLock.Unlock()
+	if region != nil {
+		return region
+	}
+	conf := config.Get()
+	r := xbmcRegion()
+	r.BinaryUnits = conf.SizeUnits == "binary"
+	switch conf.ClockFormat {
+	case "12h":
+		r.Clock24 = false
+	case "24h":
+		r.Clock24 = true
+	}
+	switch conf.DateOrder {
+	case DateDMY, DateMDY, DateYMD:
+		r.DateOrder = conf.DateOrder
+	}
+	region = r
+	return region
+}
+
+// ReloadRegion picks up the changes to the settings.
+func ReloadRegion() {
+	regionLock.Lock()
+	region = nil
+	regionLock.Unlock()
+}
+
+var (
+	decimalUnits = []string{"B", "kB", "MB", "GB", "TB"}
+	binaryUnits  = []string{"B", "KiB", "MiB", "GiB", "TiB"}
+)
+
+func (r *Region) FormatSize(bytes int64) string {
+	base, units := 1000.0, decimalUnits
+	if r.BinaryUnits {
+		base, units = 1024.0, binaryUnits
+	}
+	value := float64(bytes)
+	i := 0
+	for ; value >= base && i < len(units)-1; i++ {
+		value /= base
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d %s", bytes, units[0])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[i])
+}
+
+func (r *Region) FormatSpeed(bytesPerSecond int64) string {
+	return r.FormatSize(bytesPerSecond) + "/s"
+}
+
+func (r *Region) FormatDate(t time.Time) string {
+	switch r.DateOrder {
+	case DateMDY:
+		return t.Format("01/02/2006")
+	case DateYMD:
+		return t.Format("2006-01-02")
+	}
+	return t.Format("02/01/2006")
+}
+
+func (r *Region) FormatTime(t time.Time) string {
+	if r.Clock24 {
+		return t.Format("15:04")
+	}
+	return t.Format("3:04 PM")
+}
+
+// FormatDateTime leaves the date out for today.
+func (r *Region) FormatDateTime(t time.Time) string {
+	now := time.Now()
+	if t.Year() == now.Year() && t.YearDay() == now.YearDay() {
+		return r.FormatTime(t)
+	}
+	return r.FormatDate(t) + " " + r.FormatTime(t)
+}
+
+func FormatSize(bytes int64) string {
+	return CurrentRegion().FormatSize(bytes)
+}
+
+func FormatSpeed(bytesPerSecond int64) string {
+	return CurrentRegion().FormatSpeed(bytesPerSecond)
+}
+
+func FormatDate(t time.Time) string {
+	return CurrentRegion().FormatDate(t)
+}
+
+func FormatTime(t time.Time) string {
+	return CurrentRegion().FormatTime(t)
+}
+
+func FormatDateTime(t time.Time) string {
+	return CurrentRegion().FormatDateTime(t)
+}
@@ -0,0 +1,175 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/steeve/pulsar/config"
+)
+
+// Behind a reverse proxy, as nginx or Traefik serving us at /pulsar/, the
+// requests come from the proxy. Those of the trusted proxies, this box and
+// the trusted_proxies setting, are taken as coming from the client they're
+// forwarded for, so they aren't let through as local ones, and the URLs
+// given back to it are those it reaches us at. The base path is stripped,
+// for the proxies passing it along. A proxy running on this box must send
+// X-Forwarded-For or one of the proxyHeaders: the requests of one sending
+// none are taken for local ones, and go through without credentials.
+
+var forwardedHeaders = []string{
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Port",
+	"X-Forwarded-Prefix",
+	"X-Forwarded-Proto",
+	"X-Real-Ip",
+}
+
+// proxyHeaders tell a request came through a proxy, without telling from
+// whom. Those coming through this box with them, as from a proxy running on
+// it and left out of the forwarding headers, go as unknownClient: taken as
+// local, the whole LAN would get through without credentials.
+var proxyHeaders = []string{
+	"Forwarded",
+	"Via",
+}
+
+// unknownClient is the address of the clients a proxy doesn't tell, which
+// IsLocalRequest won't take for this box.
+const unknownClient = "forwarded:0"
+
+// isTrustedProxy tells whether the address is this box's, as IsLocalRequest
+// takes them, or one of the trusted proxies.
+func isTrustedProxy(addr string) bool {
+	return isProxyOf(addr, config.Get().TrustedProxies)
+}
+
+func isProxyOf(addr string, proxies []string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if isLocalIP(ip) {
+		return true
+	}
+	for _, proxy := range proxies {
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+		} else if proxyIP := net.ParseIP(proxy); proxyIP != nil && proxyIP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClient is the last address of X-Forwarded-For that isn't a
+// trusted proxy, the one the first of them saw, or else X-Real-Ip. Neither
+// is taken when it's this box's or a trusted proxy's, as anyone behind the
+// proxies could tell those to pass for local.
+func forwardedClient(r *http.Request) string {
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop != "" && isTrustedProxy(hop) == false {
+			return hop
+		}
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-Ip")); realIP != "" && isTrustedProxy(realIP) == false {
+		return realIP
+	}
+	return ""
+}
+
+func hasProxyHeaders(r *http.Request) bool {
+	for _, header := range proxyHeaders {
+		if r.Header.Get(header) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsForwarded tells whether a trusted proxy forwarded the request.
+func IsForwarded(r *http.Request) bool {
+	for _, header := range forwardedHeaders {
+		if r.Header.Get(header) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func stripBasePath(path string, base string) string {
+	base = "/" + strings.Trim(base, "/")
+	if base == "/" {
+		return path
+	}
+	if path == base {
+		return "/"
+	}
+	if strings.HasPrefix(path, base+"/") {
+		return strings.TrimPrefix(path, base)
+	}
+	return path
+}
+
+// Forwarded handles the requests as they came to the proxy, dropping the
+// forwarding headers of those that didn't come through a trusted one.
+func Forwarded(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsForwarded(r) {
+			if isTrustedProxy(r.RemoteAddr) {
+				if client := forwardedClient(r); client != "" {
+					r.RemoteAddr = net.JoinHostPort(client, "0")
+				} else {
+					r.RemoteAddr = unknownClient
+				}
+				r.URL.Path = stripBasePath(r.URL.Path, r.Header.Get("X-Forwarded-Prefix"))
+			} else {
+				for _, header := range forwardedHeaders {
+					r.Header.Del(header)
+				}
+			}
+		} else if hasProxyHeaders(r) && isTrustedProxy(r.RemoteAddr) {
+			r.RemoteAddr = unknownClient
+		}
+		r.URL.Path = stripBasePath(r.URL.Path, config.Get().BasePath)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// ExternalURL is where the client of the request reaches us: the external
+// URL of the settings, or the one the proxy tells, for the forwarded
+// requests, HTTPS for the LAN once trusted, and GetHTTPHost for the others.
+func ExternalURL(r *http.Request) string {
+	if IsForwarded(r) == false {
+		if UseHTTPS() && IsLocalRequest(r) == false {
+			return GetHTTPSHost()
+		}
+		return GetHTTPHost()
+	}
+	if external := config.Get().ExternalURL; external != "" {
+		return strings.TrimRight(external, "/")
+	}
+	scheme := "http"
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+	host := r.Host
+	if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+		host = strings.TrimSpace(strings.Split(forwardedHost, ",")[0])
+	}
+	prefix := r.Header.Get("X-Forwarded-Prefix")
+	if prefix == "" {
+		prefix = config.Get().BasePath
+	}
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+	return scheme + "://" + host + prefix
+}
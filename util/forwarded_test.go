@@ -0,0 +1,127 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestStripBasePath(t *testing.T) {
+	tests := []struct {
+		path string
+		base string
+		want string
+	}{
+		{"/movies/popular", "", "/movies/popular"},
+		{"/movies/popular", "/", "/movies/popular"},
+		{"/pulsar/movies/popular", "/pulsar", "/movies/popular"},
+		{"/pulsar/movies/popular", "pulsar/", "/movies/popular"},
+		{"/pulsar", "/pulsar", "/"},
+		{"/pulsar/", "/pulsar", "/"},
+		{"/pulsarx/movies", "/pulsar", "/pulsarx/movies"},
+		{"/movies/pulsar", "/pulsar", "/movies/pulsar"},
+	}
+	for _, test := range tests {
+		if got := stripBasePath(test.path, test.base); got != test.want {
+			t.Errorf("stripBasePath(%q, %q) = %q, want %q", test.path, test.base, got, test.want)
+		}
+	}
+}
+
+func TestForwardedClient(t *testing.T) {
+	tests := []struct {
+		forwardedFor string
+		realIP       string
+		want         string
+	}{
+		{"", "", ""},
+		{"203.0.113.7", "", "203.0.113.7"},
+		{"203.0.113.7, 127.0.0.1", "", "203.0.113.7"},
+		{"203.0.113.7, 198.51.100.2, 127.0.0.1, ::1", "", "198.51.100.2"},
+		// the client may tell anything, only the hops of the proxies count
+		{"127.0.0.1, 203.0.113.7", "", "203.0.113.7"},
+		{"127.0.0.1, ::1", "", ""},
+		{"127.0.0.1", "203.0.113.7", "203.0.113.7"},
+		{"", "203.0.113.7", "203.0.113.7"},
+		// nor pass for this box
+		{"", "127.0.0.1", ""},
+		{"127.0.0.1", "127.0.0.1", ""},
+		{"127.0.0.1", "::1", ""},
+		{" , 203.0.113.7 ,", "", "203.0.113.7"},
+	}
+	for _, test := range tests {
+		r := &http.Request{Header: http.Header{}}
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		if test.realIP != "" {
+			r.Header.Set("X-Real-Ip", test.realIP)
+		}
+		if got := forwardedClient(r); got != test.want {
+			t.Errorf("forwardedClient(%q, %q) = %q, want %q", test.forwardedFor, test.realIP, got, test.want)
+		}
+	}
+}
+
+func TestHasProxyHeaders(t *testing.T) {
+	tests := []struct {
+		header string
+		value  string
+		want   bool
+	}{
+		{"", "", false},
+		{"Via", "1.1 nginx", true},
+		{"Forwarded", "for=203.0.113.7", true},
+		{"X-Forwarded-For", "203.0.113.7", false},
+	}
+	for _, test := range tests {
+		r := &http.Request{Header: http.Header{}}
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		if got := hasProxyHeaders(r); got != test.want {
+			t.Errorf("hasProxyHeaders(%s: %q) = %t, want %t", test.header, test.value, got, test.want)
+		}
+	}
+}
+
+func TestIsProxyOf(t *testing.T) {
+	proxies := []string{"10.1.0.0/16", "192.0.2.10", "not an address"}
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"127.0.0.1:5555", true},
+		{"[::1]:5555", true},
+		{"10.1.2.3:80", true},
+		{"10.2.0.1:80", false},
+		{"192.0.2.10", true},
+		{"192.0.2.11", false},
+		{"203.0.113.7:80", false},
+		{"forwarded:0", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := isProxyOf(test.addr, proxies); got != test.want {
+			t.Errorf("isProxyOf(%q) = %t, want %t", test.addr, got, test.want)
+		}
+	}
+}
+
+func TestIsProxyOfLocalAddresses(t *testing.T) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if ok == false {
+			continue
+		}
+		hostPort := net.JoinHostPort(ipnet.IP.String(), "80")
+		if isProxyOf(hostPort, nil) == false {
+			t.Errorf("isProxyOf(%q) = false for an address of this box", hostPort)
+		}
+	}
+}
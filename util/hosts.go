@@ -0,0 +1,218 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/config"
+)
+
+var hostsLog = logging.MustGetLogger("hosts")
+
+// HostRules are the outbound allow and deny lists. Entries are hostnames,
+// which also match their subdomains, IPs or CIDR ranges. Deny wins, and a
+// non empty allow list blocks everything else.
+type HostRules struct {
+	allowHosts []string
+	allowNets  []*net.IPNet
+	denyHosts  []string
+	denyNets   []*net.IPNet
+}
+
+func parseHostRules(entries []string) ([]string, []*net.IPNet) {
+	hosts := make([]string, 0)
+	nets := make([]*net.IPNet, 0)
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, ipNet)
+		} else if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		} else {
+			hosts = append(hosts, strings.TrimPrefix(strings.TrimPrefix(entry, "*"), "."))
+		}
+	}
+	return hosts, nets
+}
+
+func NewHostRules(allow []string, deny []string) *HostRules {
+	rules := &HostRules{}
+	rules.allowHosts, rules.allowNets = parseHostRules(allow)
+	rules.denyHosts, rules.denyNets = parseHostRules(deny)
+	return rules
+}
+
+func (rules *HostRules) Empty() bool {
+	return len(rules.allowHosts)+len(rules.allowNets)+len(rules.denyHosts)+len(rules.denyNets) == 0
+}
+
+// The IP ranges, which are all that applies to peers.
+func (rules *HostRules) Nets() ([]*net.IPNet, []*net.IPNet) {
+	return rules.allowNets, rules.denyNets
+}
+
+func matchHost(host string, hosts []string) string {
+	for _, rule := range hosts {
+		if host == rule || strings.HasSuffix(host, "."+rule) {
+			return rule
+		}
+	}
+	return ""
+}
+
+func matchIP(ip net.IP, nets []*net.IPNet) string {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return ipNet.String()
+		}
+	}
+	return ""
+}
+
+// check tells why host, and the IPs it resolved to if any, are blocked.
+func (rules *HostRules) check(host string, ips []net.IP) error {
+	host = strings.ToLower(host)
+	if rule := matchHost(host, rules.denyHosts); rule != "" {
+		return fmt.Errorf("%s is denied by %s", host, rule)
+	}
+	for _, ip := range ips {
+		if rule := matchIP(ip, rules.denyNets); rule != "" {
+			return fmt.Errorf("%s (%s) is denied by %s", host, ip, rule)
+		}
+	}
+	if len(rules.allowHosts) == 0 && len(rules.allowNets) == 0 {
+		return nil
+	}
+	if matchHost(host, rules.allowHosts) != "" {
+		return nil
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("%s is not allowed", host)
+	}
+	for _, ip := range ips {
+		// talking to Kodi and ourselves is always fine
+		if ip.IsLoopback() == false && matchIP(ip, rules.allowNets) == "" {
+			return fmt.Errorf("%s (%s) is not allowed", host, ip)
+		}
+	}
+	return nil
+}
+
+var (
+	hostRulesLock = sync.RWMutex{}
+	hostRules     *HostRules
+)
+
+// CurrentHostRules are the rules from the settings.
+func CurrentHostRules() *HostRules {
+	hostRulesLock.Lock()
+	defer hostRulesLock.Unlock()
+	if hostRules == nil {
+		conf := config.Get()
+		hostRules = NewHostRules(conf.OutboundAllow, conf.OutboundDeny)
+	}
+	return hostRules
+}
+
+// ReloadHostRules picks up the changes to the settings.
+func ReloadHostRules() {
+	hostRulesLock.Lock()
+	hostRules = nil
+	hostRulesLock.Unlock()
+}
+
+// CheckHost returns an error when connecting to addr, a host:port or
+// a bare host, is blocked by the outbound rules.
+func CheckHost(addr string) error {
+	_, err := checkedAddrs(addr)
+	return err
+}
+
+// checkedAddrs are what to connect to for addr: the IPs it resolved to and
+// that were checked against the outbound rules, so that they can't change
+// between the check and the connection. That's addr itself without rules.
+func checkedAddrs(addr string) ([]string, error) {
+	rules := CurrentHostRules()
+	if rules.Empty() {
+		return []string{addr}, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, ""
+	}
+	ips := make([]net.IP, 0)
+	if ip := net.ParseIP(host); ip != nil {
+		ips = append(ips, ip)
+	} else if ips, err = net.LookupIP(host); err != nil {
+		return nil, err
+	}
+	if err := rules.check(host, ips); err != nil {
+		hostsLog.Warning("Blocked connection: %s", err)
+		return nil, err
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if port == "" {
+			addrs = append(addrs, ip.String())
+		} else {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+		}
+	}
+	return addrs, nil
+}
+
+// dialAny connects to the first of addrs that answers.
+func dialAny(addrs []string, dial func(addr string) (net.Conn, error)) (net.Conn, error) {
+	err := fmt.Errorf("no address to connect to")
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = dial(addr); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+var dialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+}
+
+// Dial is net.Dial, with the outbound rules enforced, and bound to the bind
+// interface unless it's local.
+func Dial(network, addr string) (net.Conn, error) {
+	addrs, err := checkedAddrs(addr)
+	if err != nil {
+		return nil, err
+	}
+	d := dialer
+	if isLoopback(addr) == false {
+		if d, err = boundDialer(); err != nil {
+			return nil, err
+		}
+	}
+	return dialAny(addrs, func(addr string) (net.Conn, error) {
+		return d.Dial(network, addr)
+	})
+}
+
+// InstallHostRules enforces the outbound rules and the proxy on the default
+// HTTP client, which the HTTP libraries we use go through.
+func InstallHostRules() {
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport.Dial = Dial
+		transport.Proxy = Proxy
+	}
+}
@@ -0,0 +1,195 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/config"
+)
+
+const (
+	certificateValidity = 10 * 365 * 24 * time.Hour
+)
+
+// The self-signed certificate, and the fingerprint the user trusted, are
+// kept in the profile.
+func httpsPath(name string) string {
+	return filepath.Join(config.Get().ProfilePath, "https", name)
+}
+
+func certificateHosts() ([]string, []net.IP) {
+	hosts := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1)}
+	if localIP, err := LocalIP(); err == nil {
+		ips = append(ips, localIP)
+	}
+	return hosts, ips
+}
+
+func generateCertificate(certFile string, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	hosts, ips := certificateHosts()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Pulsar"}, CommonName: hosts[len(hosts)-1]},
+		NotBefore:             time.Now().Add(-1 * time.Hour),
+		NotAfter:              time.Now().Add(certificateValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              hosts,
+		IPAddresses:           ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// coversLocalIP tells whether the certificate is still valid for the
+// address we give out, which changes with DHCP.
+func coversLocalIP(cert *tls.Certificate) bool {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return false
+	}
+	localIP, err := LocalIP()
+	if err != nil {
+		return true
+	}
+	return leaf.VerifyHostname(localIP.String()) == nil
+}
+
+// HTTPSCertificate is the certificate set in the settings, else ours,
+// generated again when it no longer covers our address.
+func HTTPSCertificate() (tls.Certificate, error) {
+	conf := config.Get()
+	if conf.HTTPSCertFile != "" {
+		return tls.LoadX509KeyPair(conf.HTTPSCertFile, conf.HTTPSKeyFile)
+	}
+	certFile, keyFile := httpsPath("cert.pem"), httpsPath("key.pem")
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && coversLocalIP(&cert) {
+		return cert, nil
+	}
+	if err := generateCertificate(certFile, keyFile); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+// CertificatePEM is what clients need to trust the certificate.
+func CertificatePEM(cert tls.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+}
+
+// Fingerprint is the SHA-256 of the certificate, the way browsers show it.
+func Fingerprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	parts := make([]string, 0, len(sum))
+	for _, b := range sum {
+		parts = append(parts, fmt.Sprintf("%02X", b))
+	}
+	return strings.Join(parts, ":")
+}
+
+// HTTPSTrusted tells whether the user accepted this certificate in XBMC.
+// There's no one to ask in daemon mode.
+func HTTPSTrusted(fingerprint string) bool {
+	if config.IsDaemonMode() {
+		return true
+	}
+	trusted, err := ioutil.ReadFile(httpsPath("trusted"))
+	return err == nil && strings.TrimSpace(string(trusted)) == fingerprint
+}
+
+var (
+	httpsLock        = sync.RWMutex{}
+	httpsFingerprint string
+	httpsTrusted     bool
+)
+
+func TrustHTTPS(fingerprint string) error {
+	if err := ioutil.WriteFile(httpsPath("trusted"), []byte(fingerprint), 0600); err != nil {
+		return err
+	}
+	httpsLock.Lock()
+	defer httpsLock.Unlock()
+	if fingerprint == httpsFingerprint {
+		httpsTrusted = true
+	}
+	return nil
+}
+
+// ServeHTTPS marks the certificate as being served, which GetHTTPHost goes
+// by once it's trusted.
+func ServeHTTPS(cert tls.Certificate) string {
+	fingerprint := Fingerprint(cert)
+	trusted := HTTPSTrusted(fingerprint)
+	httpsLock.Lock()
+	httpsFingerprint, httpsTrusted = fingerprint, trusted
+	httpsLock.Unlock()
+	return fingerprint
+}
+
+// UseHTTPS tells whether the URLs we give out to the LAN go through HTTPS.
+func UseHTTPS() bool {
+	httpsLock.RLock()
+	defer httpsLock.RUnlock()
+	return config.Get().HTTPSEnabled && httpsTrusted
+}
+
+func isKodi(r *http.Request) bool {
+	userAgent := r.Header.Get("User-Agent")
+	return strings.HasPrefix(userAgent, "Kodi/") || strings.HasPrefix(userAgent, "XBMC/")
+}
+
+// StreamURL is the signed URL players are redirected to. Kodi's curl doesn't
+// know our self-signed certificate, so the Kodis of the LAN stream over
+// plain HTTP rather than without checking it.
+func StreamURL(r *http.Request, rawURL string) string {
+	if IsForwarded(r) == false && UseHTTPS() && isKodi(r) && strings.HasPrefix(rawURL, GetHTTPSHost()) {
+		rawURL = GetHTTPHost() + strings.TrimPrefix(rawURL, GetHTTPSHost())
+	}
+	return SignURL(rawURL)
+}
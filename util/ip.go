@@ -33,10 +33,26 @@ func LocalIP() (net.IP, error) {
 	return nil, errors.New("cannot find local IP address")
 }
 
-func GetHTTPHost() string {
+func localHostname() string {
 	hostname := "localhost"
 	if localIP, err := LocalIP(); err == nil {
 		hostname = localIP.String()
 	}
-	return fmt.Sprintf("http://%s:%d", hostname, config.ListenPort)
+	return hostname
+}
+
+// GetHTTPHost is for XBMC, which doesn't know our certificate, and the LAN
+// until HTTPS is in use.
+func GetHTTPHost() string {
+	return fmt.Sprintf("http://%s:%d", localHostname(), config.ListenPort)
+}
+
+// GetHTTPSHost is for the LAN, once the certificate is trusted.
+func GetHTTPSHost() string {
+	return fmt.Sprintf("https://%s:%d", localHostname(), config.HTTPSListenPort)
+}
+
+// GetLocalHTTPHost is for XBMC addons and ourselves, on the same box.
+func GetLocalHTTPHost() string {
+	return fmt.Sprintf("http://127.0.0.1:%d", config.ListenPort)
 }
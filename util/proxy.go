@@ -0,0 +1,135 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/steeve/pulsar/config"
+)
+
+const proxyCheckTimeout = 3 * time.Second
+
+// ProxyAddress is the host:port of the proxy, "" when there's none.
+func ProxyAddress() string {
+	conf := config.Get()
+	if conf.SocksEnabled == false || conf.SocksHost == "" {
+		return ""
+	}
+	return net.JoinHostPort(conf.SocksHost, strconv.Itoa(conf.SocksPort))
+}
+
+// proxyURL is where the HTTP calls go through, nil when they go direct.
+func proxyURL() *url.URL {
+	conf := config.Get()
+	address := ProxyAddress()
+	if address == "" || conf.ProxyHTTPCalls == false {
+		return nil
+	}
+	u := &url.URL{Scheme: "socks5", Host: address}
+	if conf.ProxyType == config.ProxyTypeHTTP {
+		u.Scheme = "http"
+	}
+	if conf.SocksLogin != "" {
+		u.User = url.UserPassword(conf.SocksLogin, conf.SocksPassword)
+	}
+	return u
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+// Proxy is the proxy function of our HTTP transports. XBMC and the local
+// providers are never proxied.
+func Proxy(req *http.Request) (*url.URL, error) {
+	u := proxyURL()
+	if u == nil {
+		return http.ProxyFromEnvironment(req)
+	}
+	if isLoopback(req.URL.Host) {
+		return nil, nil
+	}
+	return u, nil
+}
+
+// BindAddress is the address outbound traffic is bound to, from the
+// bind_interface setting.
+func BindAddress() (net.IP, error) {
+	return InterfaceAddress(config.Get().BindInterface)
+}
+
+// InterfaceAddress resolves an IP or an interface name to an address. It's
+// nil for "", and an error when the interface is down or gone.
+func InterfaceAddress(name string) (net.IP, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("interface %s is down", name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no IPv4 address", name)
+}
+
+// boundDialer is the dialer bound to the bind interface, if any. With the
+// interface down, connections fail rather than leak through the default
+// route.
+func boundDialer() (*net.Dialer, error) {
+	ip, err := BindAddress()
+	if err != nil {
+		return nil, err
+	}
+	if ip == nil {
+		return dialer, nil
+	}
+	return &net.Dialer{
+		Timeout:   dialer.Timeout,
+		KeepAlive: dialer.KeepAlive,
+		LocalAddr: &net.TCPAddr{IP: ip},
+	}, nil
+}
+
+// TunnelUp tells whether the interface and the proxy, those that are set,
+// can be used.
+func TunnelUp(bindInterface string, proxyAddress string) error {
+	ip, err := InterfaceAddress(bindInterface)
+	if err != nil {
+		return err
+	}
+	if proxyAddress != "" {
+		checker := &net.Dialer{Timeout: proxyCheckTimeout}
+		if ip != nil {
+			checker.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+		conn, err := checker.Dial("tcp", proxyAddress)
+		if err != nil {
+			return fmt.Errorf("proxy %s is unreachable: %s", proxyAddress, err)
+		}
+		conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package util
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/config"
+)
+
+// Endpoint classes, each with its own CA bundle and certificate pins.
+const (
+	TLSDefault  = "default"
+	TLSTMDB     = "tmdb"
+	TLSTVDB     = "tvdb"
+	TLSTrakt    = "trakt"
+	TLSTorrents = "torrents"
+)
+
+var tlsLog = logging.MustGetLogger("tls")
+
+var (
+	transportsLock = sync.Mutex{}
+	transports     = map[string]*http.Transport{}
+)
+
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if pool.AppendCertsFromPEM(data) == false {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+// TLSConfig is the TLS configuration of an endpoint class. A CA bundle
+// replaces the system roots, as behind a proxy intercepting TLS. Torrent
+// files come from all sorts of caches, often with broken certificates, so
+// they're only verified in strict mode.
+func TLSConfig(class string) *tls.Config {
+	conf := config.Get()
+	tlsConfig := &tls.Config{}
+	if class == TLSTorrents && conf.TLSStrict == false {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	bundle, ok := conf.TLSCABundles[class]
+	if ok == false {
+		bundle = conf.TLSCABundles[TLSDefault]
+	}
+	if bundle != "" {
+		if pool, err := loadCABundle(bundle); err != nil {
+			tlsLog.Error("Unable to load the %s CA bundle: %s", class, err)
+		} else {
+			tlsConfig.RootCAs = pool
+		}
+	}
+	return tlsConfig
+}
+
+// pinnedDialer only lets TLS connections through when the server's chain
+// has one of the pinned keys, which are the base64 SHA-256 hashes of their
+// SubjectPublicKeyInfo. The chain is always verified, even for the torrents,
+// and only the verified chains count: the server can send any certificate
+// along, the pinned ones included.
+func pinnedDialer(class string, tlsConfig *tls.Config, pins []string) func(string, string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		addrs, err := checkedAddrs(addr)
+		if err != nil {
+			return nil, err
+		}
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		d, err := boundDialer()
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialAny(addrs, func(addr string) (net.Conn, error) {
+			return tls.DialWithDialer(d, network, addr, &tls.Config{
+				ServerName: host,
+				RootCAs:    tlsConfig.RootCAs,
+			})
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, chain := range conn.(*tls.Conn).ConnectionState().VerifiedChains {
+			for _, cert := range chain {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				hash := base64.StdEncoding.EncodeToString(sum[:])
+				for _, pin := range pins {
+					if strings.TrimPrefix(pin, "sha256/") == hash {
+						return conn, nil
+					}
+				}
+			}
+		}
+		conn.Close()
+		tlsLog.Warning("Certificate of %s doesn't match the %s pins", host, class)
+		return nil, fmt.Errorf("certificate of %s doesn't match the %s pins", host, class)
+	}
+}
+
+// pinnedProxy refuses to send the HTTPS requests of a pinned class through
+// a proxy: the transport handshakes through the tunnel itself, without
+// DialTLS, so the pins wouldn't be checked.
+func pinnedProxy(class string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := Proxy(req)
+		if err == nil && u != nil && req.URL.Scheme == "https" {
+			tlsLog.Warning("Not sending %s through the proxy, the %s certificates are pinned", req.URL.Host, class)
+			return nil, fmt.Errorf("the %s certificates are pinned, %s can't go through a proxy", class, req.URL.Host)
+		}
+		return u, err
+	}
+}
+
+func newTransport(class string) *http.Transport {
+	tlsConfig := TLSConfig(class)
+	transport := &http.Transport{
+		Proxy:               Proxy,
+		Dial:                Dial,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if pins := config.Get().TLSPins[class]; len(pins) > 0 {
+		transport.Proxy = pinnedProxy(class)
+		transport.DialTLS = pinnedDialer(class, tlsConfig, pins)
+	}
+	return transport
+}
+
+// TLSTransport is the transport of an endpoint class, with the outbound
+// rules enforced.
+func TLSTransport(class string) *http.Transport {
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+	transport, ok := transports[class]
+	if ok == false {
+		transport = newTransport(class)
+		transports[class] = transport
+	}
+	return transport
+}
+
+type classTransport string
+
+func (class classTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return TLSTransport(string(class)).RoundTrip(req)
+}
+
+// CancelRequest lets the clients with a timeout use it.
+func (class classTransport) CancelRequest(req *http.Request) {
+	TLSTransport(string(class)).CancelRequest(req)
+}
+
+// NewHTTPClient returns a client of an endpoint class, which follows the
+// settings as they change.
+func NewHTTPClient(class string) *http.Client {
+	return &http.Client{Transport: classTransport(class)}
+}
+
+// InstallTLS has the default HTTP client go by the default class settings.
+// It's to be called once, before any request: the transports of the class
+// are swapped as the settings change, not the default one.
+func InstallTLS() {
+	http.DefaultTransport = classTransport(TLSDefault)
+}
+
+// ReloadTLS picks up the changes to the settings. The requests under way
+// finish on the transports they started on.
+func ReloadTLS() {
+	transportsLock.Lock()
+	for _, transport := range transports {
+		transport.CloseIdleConnections()
+	}
+	transports = map[string]*http.Transport{}
+	transportsLock.Unlock()
+}
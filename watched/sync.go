@@ -0,0 +1,393 @@
+// Package watched keeps the watched flags of the Kodi library, of the
+// history and of Trakt in agreement.
+package watched
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/config"
+	"github.com/steeve/pulsar/history"
+	"github.com/steeve/pulsar/profiles"
+	"github.com/steeve/pulsar/store"
+	"github.com/steeve/pulsar/trakt"
+	"github.com/steeve/pulsar/xbmc"
+)
+
+// Items are keyed as in the history: movie.<imdb id> and
+// episode.<tvdb id>.<season>.<episode>. Each sync compares what each side
+// says to what it said the last time, and what changed on one side is
+// carried to the others. Sides marking an item differently since the last
+// sync are settled by the watched_sync_conflicts setting.
+
+const (
+	bucketName = "watched"
+	stateKey   = "state"
+
+	kodiName    = "kodi"
+	historyName = "history"
+	traktName   = "trakt"
+)
+
+var log = logging.MustGetLogger("watched")
+
+type mark struct {
+	Watched bool
+	At      time.Time
+}
+
+type side struct {
+	name string
+	// its unwatched items may have been played again rather than unmarked,
+	// as those of the history
+	unmarks bool
+	// the items it doesn't list are unwatched, as Trakt only lists the
+	// watched ones
+	complete bool
+	read     func() (map[string]*mark, error)
+	write    func(marks map[string]*mark) error
+}
+
+// syncState is what each side said after the last sync, and what they
+// agreed on, which sides that couldn't be read are brought to next time.
+type syncState struct {
+	Agreed map[string]bool            `json:"agreed"`
+	Seen   map[string]map[string]bool `json:"seen"`
+}
+
+var lock = sync.Mutex{}
+
+func bucket() *store.Bucket {
+	return profiles.Current().Bucket(bucketName)
+}
+
+func loadState() *syncState {
+	state := &syncState{}
+	bucket().Get(stateKey, state)
+	if state.Agreed == nil {
+		state.Agreed = make(map[string]bool)
+	}
+	if state.Seen == nil {
+		state.Seen = make(map[string]map[string]bool)
+	}
+	return state
+}
+
+func movieKey(imdbId string) string {
+	return "movie." + imdbId
+}
+
+func episodeKey(tvdbId int, season int, episode int) string {
+	return fmt.Sprintf("episode.%d.%d.%d", tvdbId, season, episode)
+}
+
+// parseKey returns the IMDB id of a movie key, or the TVDB id, season and
+// episode of an episode key.
+func parseKey(key string) (imdbId string, tvdbId int, season int, episode int, ok bool) {
+	parts := strings.Split(key, ".")
+	if len(parts) == 2 && parts[0] == "movie" {
+		return parts[1], 0, 0, 0, true
+	}
+	if len(parts) != 4 || parts[0] != "episode" {
+		return "", 0, 0, 0, false
+	}
+	var err error
+	if tvdbId, err = strconv.Atoi(parts[1]); err != nil {
+		return "", 0, 0, 0, false
+	}
+	if season, err = strconv.Atoi(parts[2]); err != nil {
+		return "", 0, 0, 0, false
+	}
+	if episode, err = strconv.Atoi(parts[3]); err != nil {
+		return "", 0, 0, 0, false
+	}
+	return "", tvdbId, season, episode, true
+}
+
+// Sync reconciles the watched flags, if watched_sync is on.
+func Sync() error {
+	if config.Get().WatchedSync == false {
+		return nil
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	sides := []*side{kodiSide(), historySide()}
+	if trakt.Authorized() {
+		sides = append(sides, traktSide())
+	}
+
+	state := loadState()
+	current := make(map[string]map[string]*mark)
+	keys := make(map[string]bool)
+	for _, s := range sides {
+		marks, err := s.read()
+		if err != nil {
+			log.Warning("Unable to read the watched items of %s: %s", s.name, err)
+			continue
+		}
+		current[s.name] = marks
+		for key := range marks {
+			keys[key] = true
+		}
+	}
+	for key := range state.Agreed {
+		keys[key] = true
+	}
+
+	now := time.Now()
+	writes := make(map[string]map[string]*mark)
+	for key := range keys {
+		target := resolve(state, sides, current, key, now)
+		if target == nil {
+			continue
+		}
+		state.Agreed[key] = target.Watched
+		for _, s := range sides {
+			marks, ok := current[s.name]
+			if ok == false {
+				continue
+			}
+			// unlisted, the items of complete sides are unwatched, those of
+			// the others can't be marked
+			m, listed := marks[key]
+			if listed == false && (s.complete == false || target.Watched == false) {
+				continue
+			}
+			if listed && m.Watched == target.Watched {
+				continue
+			}
+			if writes[s.name] == nil {
+				writes[s.name] = make(map[string]*mark)
+			}
+			writes[s.name][key] = target
+		}
+	}
+
+	var failed error
+	for _, s := range sides {
+		marks, ok := current[s.name]
+		if ok == false {
+			continue
+		}
+		if changed := writes[s.name]; len(changed) > 0 {
+			log.Info("Marking %d items on %s", len(changed), s.name)
+			if err := s.write(changed); err != nil {
+				log.Error("Unable to mark the watched items of %s: %s", s.name, err)
+				failed = err
+			} else {
+				for key, m := range changed {
+					marks[key] = m
+				}
+			}
+		}
+		seen := make(map[string]bool, len(marks))
+		for key, m := range marks {
+			seen[key] = m.Watched
+		}
+		state.Seen[s.name] = seen
+	}
+	if err := bucket().Set(stateKey, state, store.FOREVER); err != nil {
+		return err
+	}
+	return failed
+}
+
+// resolve returns what the item is to be on all sides, nil if nothing is
+// known of it.
+func resolve(state *syncState, sides []*side, current map[string]map[string]*mark, key string, now time.Time) *mark {
+	var winner *mark
+	for _, s := range sides {
+		marks, ok := current[s.name]
+		if ok == false {
+			continue
+		}
+		m, listed := marks[key]
+		if listed == false {
+			if s.complete == false {
+				continue
+			}
+			m = &mark{Watched: false}
+		}
+		// unseen items count as having been unwatched
+		previous := state.Seen[s.name][key]
+		if m.Watched == previous || (m.Watched == false && s.unmarks == false) {
+			continue
+		}
+		// unwatching isn't dated, it happened by now
+		if m.Watched == false || m.At.IsZero() {
+			m = &mark{Watched: m.Watched, At: now}
+		}
+		if s.name == traktName && config.Get().WatchedSyncConflicts == config.WatchedSyncTraktWins {
+			return m
+		}
+		if winner == nil || m.At.After(winner.At) {
+			winner = m
+		}
+	}
+	if winner != nil {
+		return winner
+	}
+	if watched, ok := state.Agreed[key]; ok {
+		return &mark{Watched: watched}
+	}
+	return nil
+}
+
+func kodiSide() *side {
+	movies := make(map[string]*xbmc.LibraryItem)
+	episodes := make(map[string]*xbmc.LibraryItem)
+	return &side{
+		name:    kodiName,
+		unmarks: true,
+		read: func() (map[string]*mark, error) {
+			marks := make(map[string]*mark)
+			libraryMovies, err := xbmc.LibraryMovies()
+			if err != nil {
+				return nil, err
+			}
+			for _, movie := range libraryMovies {
+				if movie.IMDBNumber == "" {
+					continue
+				}
+				key := movieKey(movie.IMDBNumber)
+				movies[key] = movie
+				marks[key] = kodiMark(movie)
+			}
+			libraryEpisodes, err := xbmc.LibraryEpisodes()
+			if err != nil {
+				return nil, err
+			}
+			for _, episode := range libraryEpisodes {
+				tvdbId, err := strconv.Atoi(episode.IMDBNumber)
+				if err != nil {
+					continue
+				}
+				key := episodeKey(tvdbId, episode.Season, episode.Episode)
+				episodes[key] = episode
+				marks[key] = kodiMark(episode)
+			}
+			return marks, nil
+		},
+		write: func(marks map[string]*mark) error {
+			var failed error
+			for key, m := range marks {
+				playCount := 0
+				if m.Watched {
+					playCount = 1
+				}
+				var err error
+				if movie, ok := movies[key]; ok {
+					err = xbmc.SetMoviePlaycount(movie, playCount)
+				} else if episode, ok := episodes[key]; ok {
+					err = xbmc.SetEpisodePlaycount(episode, playCount)
+				}
+				if err != nil {
+					failed = err
+				}
+			}
+			return failed
+		},
+	}
+}
+
+func kodiMark(item *xbmc.LibraryItem) *mark {
+	m := &mark{Watched: item.PlayCount > 0}
+	if lastPlayed, err := time.ParseInLocation("2006-01-02 15:04:05", item.LastPlayed, time.Local); err == nil {
+		m.At = lastPlayed
+	}
+	return m
+}
+
+func historySide() *side {
+	return &side{
+		name: historyName,
+		read: func() (map[string]*mark, error) {
+			marks := make(map[string]*mark)
+			for _, entry := range history.List() {
+				if entry.IMDBId != "" || entry.TVDBId != 0 {
+					marks[entry.Key] = &mark{Watched: entry.Watched, At: entry.Updated}
+				}
+			}
+			return marks, nil
+		},
+		write: func(marks map[string]*mark) error {
+			for key, m := range marks {
+				// leave what's being watched again in continue watching
+				if entry := history.Get(key); m.Watched && entry != nil && entry.InProgress() {
+					continue
+				}
+				if err := history.SetWatched(key, m.Watched); err != nil && err != history.ErrNoEntry {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func traktSide() *side {
+	return &side{
+		name:     traktName,
+		unmarks:  true,
+		complete: true,
+		read: func() (map[string]*mark, error) {
+			marks := make(map[string]*mark)
+			movies, err := trakt.WatchedMovies()
+			if err != nil {
+				return nil, err
+			}
+			for _, movie := range movies {
+				if movie.Movie != nil && movie.Movie.IDs != nil && movie.Movie.IDs.IMDB != "" {
+					marks[movieKey(movie.Movie.IDs.IMDB)] = &mark{Watched: true, At: movie.LastWatchedAt}
+				}
+			}
+			shows, err := trakt.WatchedShows()
+			if err != nil {
+				return nil, err
+			}
+			for _, show := range shows {
+				if show.Show == nil || show.Show.IDs == nil || show.Show.IDs.TVDB == 0 {
+					continue
+				}
+				for _, season := range show.Seasons {
+					for _, episode := range season.Episodes {
+						marks[episodeKey(show.Show.IDs.TVDB, season.Number, episode.Number)] = &mark{Watched: true, At: episode.LastWatchedAt}
+					}
+				}
+			}
+			return marks, nil
+		},
+		write: func(marks map[string]*mark) error {
+			added, removed := &trakt.HistoryItems{}, &trakt.HistoryItems{}
+			for key, m := range marks {
+				items := removed
+				if m.Watched {
+					items = added
+				}
+				imdbId, tvdbId, season, episode, ok := parseKey(key)
+				if ok == false {
+					continue
+				}
+				if imdbId != "" {
+					items.AddMovie(imdbId, m.At)
+				} else {
+					items.AddEpisode(tvdbId, season, episode, m.At)
+				}
+			}
+			if added.Empty() == false {
+				if err := trakt.AddToHistory(added); err != nil {
+					return err
+				}
+			}
+			if removed.Empty() == false {
+				return trakt.RemoveFromHistory(removed)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,96 @@
+// Package watchlist keeps the movies and shows the user wants to watch,
+// locally and per profile, for those who don't use Trakt.
+package watchlist
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/steeve/pulsar/profiles"
+	"github.com/steeve/pulsar/store"
+)
+
+const (
+	Movies = "movies"
+	Shows  = "shows"
+
+	bucketName = "watchlist"
+)
+
+type Item struct {
+	TMDBId int       `json:"tmdb_id"`
+	Added  time.Time `json:"added"`
+}
+
+type byAdded []*Item
+
+func (a byAdded) Len() int           { return len(a) }
+func (a byAdded) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byAdded) Less(i, j int) bool { return a[i].Added.After(a[j].Added) }
+
+var lock = sync.Mutex{}
+
+func load(kind string) []*Item {
+	items := make([]*Item, 0)
+	profiles.Current().Bucket(bucketName).Get(kind, &items)
+	return items
+}
+
+func save(kind string, items []*Item) error {
+	return profiles.Current().Bucket(bucketName).Set(kind, items, store.FOREVER)
+}
+
+func Add(kind string, tmdbId int) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	items := load(kind)
+	for _, item := range items {
+		if item.TMDBId == tmdbId {
+			return nil
+		}
+	}
+	items = append(items, &Item{TMDBId: tmdbId, Added: time.Now()})
+	return save(kind, items)
+}
+
+func Remove(kind string, tmdbId int) error {
+	lock.Lock()
+	defer lock.Unlock()
+
+	items := load(kind)
+	kept := make([]*Item, 0, len(items))
+	for _, item := range items {
+		if item.TMDBId != tmdbId {
+			kept = append(kept, item)
+		}
+	}
+	return save(kind, kept)
+}
+
+func Contains(kind string, tmdbId int) bool {
+	lock.Lock()
+	defer lock.Unlock()
+
+	for _, item := range load(kind) {
+		if item.TMDBId == tmdbId {
+			return true
+		}
+	}
+	return false
+}
+
+// Ids returns the TMDB ids of the watchlist, most recently added first.
+func Ids(kind string) []int {
+	lock.Lock()
+	items := load(kind)
+	lock.Unlock()
+
+	sort.Sort(byAdded(items))
+	ids := make([]int, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.TMDBId)
+	}
+	return ids
+}
@@ -15,9 +15,70 @@ func GetAddons(args ...interface{}) *AddonsList {
 	return &addons
 }
 
-func ExecuteAddon(addonId string, args ...interface{}) {
+type AddonDetails struct {
+	ID        string `json:"addonid"`
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Enabled   bool   `json:"enabled"`
+	ExtraInfo []*struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"extrainfo"`
+}
+
+func GetAddonDetails(addonId string, properties ...string) *AddonDetails {
+	var retVal struct {
+		Addon *AddonDetails `json:"addon"`
+	}
+	executeJSONRPC("Addons.GetAddonDetails", &retVal, Args{addonId, properties})
+	return retVal.Addon
+}
+
+func (ad *AddonDetails) GetExtraInfo(key string) string {
+	for _, info := range ad.ExtraInfo {
+		if info.Key == key {
+			return info.Value
+		}
+	}
+	return ""
+}
+
+func ExecuteAddon(addonId string, args ...interface{}) error {
 	var retVal string
-	executeJSONRPC("Addons.ExecuteAddon", &retVal, Args{addonId, args})
+	return executeJSONRPC("Addons.ExecuteAddon", &retVal, Args{addonId, args})
+}
+
+type AddonCall struct {
+	AddonId string
+	Args    []interface{}
+}
+
+// ExecuteAddons runs all the addons in a single JSON-RPC batch, falling back
+// to one call per addon when the batch didn't run. When it may have, as
+// after a timeout, the addons aren't run twice.
+func ExecuteAddons(calls []AddonCall) []error {
+	argsList := make([]Args, 0, len(calls))
+	for _, call := range calls {
+		argsList = append(argsList, Args{call.AddonId, call.Args})
+	}
+	errs, err := executeJSONRPCBatch("Addons.ExecuteAddon", argsList)
+	if err == nil {
+		return errs
+	}
+	if _, unsent := err.(*batchUnsentError); unsent == false && err != ErrBatchUnsupported {
+		log.Warning("Unable to get the result of the addon calls batch: %s", err)
+		errs = make([]error, len(calls))
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	log.Info("Unable to batch addon calls (%s), executing them one by one", err)
+	errs = make([]error, 0, len(calls))
+	for _, call := range calls {
+		errs = append(errs, ExecuteAddon(call.AddonId, call.Args...))
+	}
+	return errs
 }
 
 type Addon struct {
@@ -1,8 +1,15 @@
 package xbmc
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/op/go-logging"
+	"github.com/steeve/pulsar/clock"
 	"github.com/steeve/pulsar/jsonrpc"
 )
 
@@ -22,11 +29,64 @@ var (
 	}
 )
 
+const (
+	jsonRPCTimeout   = 10 * time.Second
+	breakerThreshold = 3
+	breakerCooldown  = 30 * time.Second
+)
+
+var (
+	ErrCircuitOpen      = errors.New("JSON-RPC circuit is open")
+	ErrBatchUnsupported = errors.New("JSON-RPC batches are not supported")
+
+	log = logging.MustGetLogger("xbmc")
+
+	jsonRPCBreaker   = &circuitBreaker{name: "JSON-RPC"}
+	jsonRPCExBreaker = &circuitBreaker{name: "JSON-RPC Ex"}
+)
+
+// circuitBreaker stops calling a JSON-RPC server that keeps failing, so that
+// a hung Kodi doesn't block every request on its timeout.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	name      string
+	failures  int
+	openUntil time.Time
+}
+
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return clock.Now().After(cb.openUntil)
+}
+
+func (cb *circuitBreaker) success() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures = 0
+}
+
+func (cb *circuitBreaker) failure() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failures++
+	if cb.failures >= breakerThreshold {
+		log.Warning("%s failed %d times in a row, skipping calls for %s", cb.name, cb.failures, breakerCooldown)
+		cb.openUntil = clock.Now().Add(breakerCooldown)
+		cb.failures = 0
+	}
+}
+
+func IsAvailable() bool {
+	return jsonRPCBreaker.allow()
+}
+
 func getConnection(hosts ...string) (net.Conn, error) {
 	var err error
 
 	for _, host := range hosts {
-		c, err := net.Dial("tcp", host)
+		var c net.Conn
+		c, err = net.DialTimeout("tcp", host, jsonRPCTimeout)
 		if err == nil {
 			return c, nil
 		}
@@ -35,30 +95,134 @@ func getConnection(hosts ...string) (net.Conn, error) {
 	return nil, err
 }
 
-func executeJSONRPC(method string, retVal interface{}, args []interface{}) error {
+func call(breaker *circuitBreaker, hosts []string, timeout time.Duration, method string, retVal interface{}, args []interface{}) error {
 	if args == nil {
 		args = Args{}
 	}
-	conn, err := getConnection(XBMCJSONRPCHosts...)
+	if breaker.allow() == false {
+		return ErrCircuitOpen
+	}
+	conn, err := getConnection(hosts...)
 	if err != nil {
-		panic(err)
+		breaker.failure()
+		return err
 	}
 	defer conn.Close()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
 
 	client := jsonrpc.NewClient(conn)
-	return client.Call(method, args, retVal)
+	err = client.Call(method, args, retVal)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		log.Warning("%s call to %s timed out", breaker.name, method)
+		breaker.failure()
+	} else {
+		breaker.success()
+	}
+	return err
+}
+
+func executeJSONRPC(method string, retVal interface{}, args []interface{}) error {
+	return call(jsonRPCBreaker, XBMCJSONRPCHosts, jsonRPCTimeout, method, retVal, args)
 }
 
 func executeJSONRPCEx(method string, retVal interface{}, args []interface{}) error {
-	if args == nil {
-		args = Args{}
+	return call(jsonRPCExBreaker, XBMCExJSONRPCHosts, jsonRPCTimeout, method, retVal, args)
+}
+
+// For calls that wait on the user (keyboard, select dialogs...), which can't
+// have a timeout.
+func executeInteractiveJSONRPCEx(method string, retVal interface{}, args []interface{}) error {
+	return call(jsonRPCExBreaker, XBMCExJSONRPCHosts, 0, method, retVal, args)
+}
+
+type batchRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+	Id      int         `json:"id"`
+}
+
+type batchResponse struct {
+	Id    int         `json:"id"`
+	Error interface{} `json:"error"`
+}
+
+// batchUnsentError is a batch that never reached XBMC, so none of its calls
+// ran.
+type batchUnsentError struct {
+	err error
+}
+
+func (e *batchUnsentError) Error() string {
+	return e.err.Error()
+}
+
+// executeJSONRPCBatch sends all the calls in a single JSON-RPC 2.0 batch
+// request, and returns one error per call. The batch wasn't run on a
+// batchUnsentError or ErrBatchUnsupported, it may have been on the others.
+func executeJSONRPCBatch(method string, argsList []Args) ([]error, error) {
+	if jsonRPCBreaker.allow() == false {
+		return nil, &batchUnsentError{ErrCircuitOpen}
 	}
-	conn, err := getConnection(XBMCExJSONRPCHosts...)
+	conn, err := getConnection(XBMCJSONRPCHosts...)
 	if err != nil {
-		panic(err)
+		jsonRPCBreaker.failure()
+		return nil, &batchUnsentError{err}
 	}
 	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(jsonRPCTimeout))
 
-	client := jsonrpc.NewClient(conn)
-	return client.Call(method, args, retVal)
+	requests := make([]batchRequest, 0, len(argsList))
+	for i, args := range argsList {
+		requests = append(requests, batchRequest{
+			JSONRPC: "2.0",
+			Method:  method,
+			Params:  args,
+			Id:      i,
+		})
+	}
+	if err := json.NewEncoder(conn).Encode(requests); err != nil {
+		jsonRPCBreaker.failure()
+		return nil, &batchUnsentError{err}
+	}
+	var answer json.RawMessage
+	if err := json.NewDecoder(conn).Decode(&answer); err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			jsonRPCBreaker.failure()
+		}
+		return nil, err
+	}
+	jsonRPCBreaker.success()
+	if len(answer) > 0 && answer[0] == '{' {
+		// the XBMCs without batches answer with a single invalid request
+		// error
+		return nil, ErrBatchUnsupported
+	}
+	responses := make([]batchResponse, 0, len(requests))
+	if err := json.Unmarshal(answer, &responses); err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(requests))
+	for i := range errs {
+		errs[i] = errors.New("no response in batch")
+	}
+	for _, response := range responses {
+		if response.Id < 0 || response.Id >= len(errs) {
+			continue
+		}
+		if response.Error != nil {
+			errs[response.Id] = fmt.Errorf("%v", response.Error)
+		} else {
+			errs[response.Id] = nil
+		}
+	}
+	return errs, nil
+}
+
+func Ping() error {
+	var retVal string
+	return executeJSONRPC("JSONRPC.Ping", &retVal, nil)
 }
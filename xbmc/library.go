@@ -0,0 +1,134 @@
+package xbmc
+
+import (
+	"strconv"
+	"strings"
+)
+
+type LibraryItem struct {
+	MovieId    int    `json:"movieid"`
+	EpisodeId  int    `json:"episodeid"`
+	TVShowId   int    `json:"tvshowid"`
+	Label      string `json:"label"`
+	File       string `json:"file"`
+	IMDBNumber string `json:"imdbnumber"`
+	Season     int    `json:"season"`
+	Episode    int    `json:"episode"`
+	PlayCount  int    `json:"playcount"`
+	LastPlayed string `json:"lastplayed"` // local time, as "2006-01-02 15:04:05"
+}
+
+func isStrm(file string) bool {
+	return strings.HasSuffix(strings.ToLower(file), ".strm")
+}
+
+// LibraryMovie returns the .strm movie of the library with this IMDB id.
+func LibraryMovie(imdbId string) *LibraryItem {
+	var retVal struct {
+		Movies []*LibraryItem `json:"movies"`
+	}
+	executeJSONRPC("VideoLibrary.GetMovies", &retVal, Args{[]string{"imdbnumber", "file", "playcount"}})
+	for _, movie := range retVal.Movies {
+		if movie.IMDBNumber == imdbId && isStrm(movie.File) {
+			return movie
+		}
+	}
+	return nil
+}
+
+// LibraryEpisode returns the .strm episode of the library for the show
+// with this TVDB id, which is what XBMC scrapers store as imdbnumber.
+func LibraryEpisode(tvdbId int, season int, episode int) *LibraryItem {
+	var shows struct {
+		TVShows []*LibraryItem `json:"tvshows"`
+	}
+	executeJSONRPC("VideoLibrary.GetTVShows", &shows, Args{[]string{"imdbnumber"}})
+	for _, show := range shows.TVShows {
+		if show.IMDBNumber != strconv.Itoa(tvdbId) {
+			continue
+		}
+		var episodes struct {
+			Episodes []*LibraryItem `json:"episodes"`
+		}
+		executeJSONRPC("VideoLibrary.GetEpisodes", &episodes, Args{show.TVShowId, season, []string{"episode", "file", "playcount"}})
+		for _, e := range episodes.Episodes {
+			if e.Episode == episode && isStrm(e.File) {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// LibraryMovies returns the .strm movies of the library.
+func LibraryMovies() ([]*LibraryItem, error) {
+	var retVal struct {
+		Movies []*LibraryItem `json:"movies"`
+	}
+	if err := executeJSONRPC("VideoLibrary.GetMovies", &retVal, Args{[]string{"imdbnumber", "file", "playcount", "lastplayed"}}); err != nil {
+		return nil, err
+	}
+	movies := make([]*LibraryItem, 0, len(retVal.Movies))
+	for _, movie := range retVal.Movies {
+		if isStrm(movie.File) {
+			movies = append(movies, movie)
+		}
+	}
+	return movies, nil
+}
+
+// LibraryEpisodes returns the .strm episodes of the library, their
+// IMDBNumber being the TVDB id of their show.
+func LibraryEpisodes() ([]*LibraryItem, error) {
+	var shows struct {
+		TVShows []*LibraryItem `json:"tvshows"`
+	}
+	if err := executeJSONRPC("VideoLibrary.GetTVShows", &shows, Args{[]string{"imdbnumber"}}); err != nil {
+		return nil, err
+	}
+	episodes := make([]*LibraryItem, 0)
+	for _, show := range shows.TVShows {
+		var retVal struct {
+			Episodes []*LibraryItem `json:"episodes"`
+		}
+		// season -1 is all of them
+		if err := executeJSONRPC("VideoLibrary.GetEpisodes", &retVal, Args{show.TVShowId, -1, []string{"season", "episode", "file", "playcount", "lastplayed"}}); err != nil {
+			return nil, err
+		}
+		for _, episode := range retVal.Episodes {
+			if isStrm(episode.File) {
+				episode.IMDBNumber = show.IMDBNumber
+				episodes = append(episodes, episode)
+			}
+		}
+	}
+	return episodes, nil
+}
+
+// SetMovieWatched increments the playcount of the movie, which also marks
+// it as watched.
+func SetMovieWatched(movie *LibraryItem) error {
+	return SetMoviePlaycount(movie, movie.PlayCount+1)
+}
+
+func SetEpisodeWatched(episode *LibraryItem) error {
+	return SetEpisodePlaycount(episode, episode.PlayCount+1)
+}
+
+// SetMoviePlaycount sets the playcount of the movie, 0 marking it as
+// unwatched.
+func SetMoviePlaycount(movie *LibraryItem, playCount int) error {
+	var retVal string
+	return executeJSONRPC("VideoLibrary.SetMovieDetails", &retVal, Args{movie.MovieId, nil, playCount})
+}
+
+func SetEpisodePlaycount(episode *LibraryItem, playCount int) error {
+	var retVal string
+	return executeJSONRPC("VideoLibrary.SetEpisodeDetails", &retVal, Args{episode.EpisodeId, nil, playCount})
+}
+
+// VideoLibraryScan makes XBMC pick up the new files of its video sources.
+func VideoLibraryScan() error {
+	var retVal string
+	return executeJSONRPC("VideoLibrary.Scan", &retVal, nil)
+}
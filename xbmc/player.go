@@ -0,0 +1,69 @@
+package xbmc
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parses time labels such as Player.Time and Player.Duration, formatted as
+// hh:mm:ss or mm:ss.
+func ParseTimeLabel(label string) time.Duration {
+	duration := time.Duration(0)
+	for _, part := range strings.Split(label, ":") {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return 0
+		}
+		duration = duration*60 + time.Duration(value)
+	}
+	return duration * time.Second
+}
+
+func PlayerTime() time.Duration {
+	return ParseTimeLabel(InfoLabel("Player.Time"))
+}
+
+func PlayerDuration() time.Duration {
+	return ParseTimeLabel(InfoLabel("Player.Duration"))
+}
+
+// PlayerProgress returns the position and the duration of what's playing,
+// in one call.
+func PlayerProgress() (time.Duration, time.Duration) {
+	labels := InfoLabels("Player.Time", "Player.Duration")
+	return ParseTimeLabel(labels["Player.Time"]), ParseTimeLabel(labels["Player.Duration"])
+}
+
+// PlayerIsPaused tells whether the video player is paused, at speed 0.
+func PlayerIsPaused() bool {
+	properties := struct {
+		Speed *int `json:"speed"`
+	}{}
+	if err := executeJSONRPC("Player.GetProperties", &properties, Args{1, []string{"speed"}}); err != nil {
+		return false
+	}
+	return properties.Speed != nil && *properties.Speed == 0
+}
+
+func PlayerSeek(position time.Duration) {
+	seconds := int(position.Seconds())
+	var retVal string
+	executeJSONRPC("Player.Seek", &retVal, Args{1, map[string]interface{}{
+		"hours":   seconds / 3600,
+		"minutes": (seconds / 60) % 60,
+		"seconds": seconds % 60,
+	}})
+}
+
+// index is the position of the stream amongst the audio streams
+func PlayerSetAudioStream(index int) {
+	var retVal string
+	executeJSONRPC("Player.SetAudioStream", &retVal, Args{1, index})
+}
+
+// index is the position of the stream amongst the subtitle streams
+func PlayerSetSubtitle(index int, enable bool) {
+	var retVal string
+	executeJSONRPC("Player.SetSubtitle", &retVal, Args{1, index, enable})
+}
@@ -0,0 +1,6 @@
+package xbmc
+
+func SystemSuspend() error {
+	var retVal string
+	return executeJSONRPC("System.Suspend", &retVal, nil)
+}
@@ -0,0 +1,76 @@
+package xbmc
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// In text-only mode, for screen readers, progress dialogs are replaced by
+// notifications saying the same, and labels are stripped of what only
+// makes sense to the eye: formatting tags and symbols.
+
+// Notifications are sent as progress passes each step, in percent.
+const textProgressStep = 25
+
+var (
+	textOnlyLock = sync.RWMutex{}
+	textOnly     = false
+
+	formattingTagsRe = regexp.MustCompile(`(?i)\[/?(COLOR[^\]]*|B|I|UPPERCASE|LOWERCASE|CAPITALIZE|LIGHT)\]`)
+)
+
+func SetTextOnly(enabled bool) {
+	textOnlyLock.Lock()
+	defer textOnlyLock.Unlock()
+	textOnly = enabled
+}
+
+func TextOnly() bool {
+	textOnlyLock.RLock()
+	defer textOnlyLock.RUnlock()
+	return textOnly
+}
+
+// PlainLabel removes the formatting tags and the symbols, such as emojis,
+// from the label.
+func PlainLabel(label string) string {
+	label = formattingTagsRe.ReplaceAllString(label, "")
+	label = strings.Map(func(r rune) rune {
+		// emoji come with variation selectors, joiners and skin tones
+		if unicode.Is(unicode.So, r) || unicode.Is(unicode.Variation_Selector, r) || r == 0x200D || (r >= 0x1F3FB && r <= 0x1F3FF) {
+			return -1
+		}
+		return r
+	}, label)
+	return strings.Join(strings.Fields(label), " ")
+}
+
+func (items ListItems) plain() {
+	for _, item := range items {
+		item.Label = PlainLabel(item.Label)
+		item.Label2 = PlainLabel(item.Label2)
+		if item.Info != nil {
+			item.Info.Title = PlainLabel(item.Info.Title)
+		}
+	}
+}
+
+// textMessage is what the text-only dialogs say: the non empty lines.
+func textMessage(lines ...string) string {
+	message := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if line = PlainLabel(line); line != "" {
+			message = append(message, line)
+		}
+	}
+	return strings.Join(message, ", ")
+}
+
+func (dp *DialogProgress) notify(percent int, lines ...string) {
+	dp.lastStep = percent / textProgressStep
+	if message := textMessage(lines...); message != "" {
+		Notify(dp.title, message)
+	}
+}
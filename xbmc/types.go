@@ -116,6 +116,9 @@ type StreamInfoEntry struct {
 }
 
 func NewView(contentType string, items ListItems) *View {
+	if TextOnly() {
+		items.plain()
+	}
 	return &View{
 		ContentType: contentType,
 		Items:       items,
@@ -31,3 +31,12 @@ func GetLanguage(format int) string {
 	executeJSONRPCEx("GetLanguage", &retVal, Args{format})
 	return retVal
 }
+
+// GetSettingValue reads a setting of XBMC itself, such as the region ones.
+func GetSettingValue(setting string) interface{} {
+	var retVal struct {
+		Value interface{} `json:"value"`
+	}
+	executeJSONRPC("Settings.GetSettingValue", &retVal, Args{setting})
+	return retVal.Value
+}
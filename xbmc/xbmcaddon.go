@@ -25,9 +25,16 @@ func GetAddonInfo() *AddonInfo {
 	return &retVal
 }
 
-func GetSettingString(id string) (retVal string) {
-	executeJSONRPCEx("GetSetting", &retVal, Args{id})
-	return
+func GetSettingString(id string) string {
+	retVal, _ := GetSetting(id)
+	return retVal
+}
+
+// GetSetting is GetSettingString telling when XBMC couldn't be asked.
+func GetSetting(id string) (string, error) {
+	retVal := ""
+	err := executeJSONRPCEx("GetSetting", &retVal, Args{id})
+	return retVal, err
 }
 
 func GetSettingInt(id string) int {
@@ -1,10 +1,24 @@
 package xbmc
 
+import "errors"
+
 type DialogProgress struct {
 	hWnd int64
+
+	// text-only mode
+	textOnly bool
+	title    string
+	lastStep int
 }
 
+// NewDialogProgress shows a progress dialog, or in text-only mode, notifies
+// the lines. Those dialogs can't be cancelled.
 func NewDialogProgress(title, line1, line2, line3 string) *DialogProgress {
+	if TextOnly() {
+		dp := &DialogProgress{textOnly: true, title: PlainLabel(title)}
+		dp.notify(0, line1, line2, line3)
+		return dp
+	}
 	retVal := int64(-1)
 	executeJSONRPCEx("DialogProgress_Create", &retVal, Args{title, line1, line2, line3})
 	if retVal < 0 {
@@ -16,21 +30,63 @@ func NewDialogProgress(title, line1, line2, line3 string) *DialogProgress {
 }
 
 func (dp *DialogProgress) Update(percent int, line1, line2, line3 string) {
+	if dp.textOnly {
+		if percent/textProgressStep != dp.lastStep {
+			dp.notify(percent, line1, line2, line3)
+		}
+		return
+	}
 	retVal := -1
 	executeJSONRPCEx("DialogProgress_Update", &retVal, Args{dp.hWnd, percent, line1, line2, line3})
 }
 
 func (dp *DialogProgress) IsCanceled() bool {
+	if dp.textOnly {
+		return false
+	}
 	retVal := 0
 	executeJSONRPCEx("DialogProgress_IsCanceled", &retVal, Args{dp.hWnd})
 	return retVal != 0
 }
 
 func (dp *DialogProgress) Close() {
+	if dp.textOnly {
+		return
+	}
 	retVal := -1
 	executeJSONRPCEx("DialogProgress_Close", &retVal, Args{dp.hWnd})
 }
 
+// Overlay is a small window drawn over the video, which leaves the focus to
+// the player.
+type Overlay struct {
+	hWnd int64
+}
+
+// NewOverlay fails with the plugins that don't draw overlays.
+func NewOverlay(title string) (*Overlay, error) {
+	retVal := int64(-1)
+	if err := executeJSONRPCEx("Overlay_Create", &retVal, Args{title}); err != nil {
+		return nil, err
+	}
+	if retVal < 0 {
+		return nil, errors.New("unable to create the overlay")
+	}
+	return &Overlay{
+		hWnd: retVal,
+	}, nil
+}
+
+func (o *Overlay) Update(lines ...string) error {
+	retVal := -1
+	return executeJSONRPCEx("Overlay_Update", &retVal, Args{o.hWnd, lines})
+}
+
+func (o *Overlay) Close() error {
+	retVal := -1
+	return executeJSONRPCEx("Overlay_Close", &retVal, Args{o.hWnd})
+}
+
 func Notify(args ...interface{}) {
 	var retVal string
 	executeJSONRPC("GUI.ShowNotification", &retVal, args)
@@ -49,16 +105,26 @@ func InfoLabel(label string) string {
 
 func Keyboard(args ...interface{}) string {
 	var retVal string
-	executeJSONRPCEx("Keyboard", &retVal, args)
+	executeInteractiveJSONRPCEx("Keyboard", &retVal, args)
 	return retVal
 }
 
 func ListDialog(title string, items ...string) int {
 	retVal := -1
-	executeJSONRPCEx("Dialog_Select", &retVal, Args{title, items})
+	executeInteractiveJSONRPCEx("Dialog_Select", &retVal, Args{title, items})
 	return retVal
 }
 
+// MultiSelectDialog returns the indexes of the items the user ticked, or nil
+// if they cancelled. It fails with the plugins that don't have the dialog.
+func MultiSelectDialog(title string, items ...string) ([]int, error) {
+	var retVal []int
+	if err := executeInteractiveJSONRPCEx("Dialog_MultiSelect", &retVal, Args{title, items}); err != nil {
+		return nil, err
+	}
+	return retVal, nil
+}
+
 func PlayerGetPlayingFile() string {
 	retVal := ""
 	executeJSONRPCEx("Player_GetPlayingFile", &retVal, nil)
@@ -71,6 +137,13 @@ func PlayerIsPlaying() bool {
 	return retVal != 0
 }
 
+// Loads an external subtitle file in the player. It fails with the plugins
+// that can't.
+func PlayerSetSubtitles(path string) error {
+	retVal := 0
+	return executeJSONRPCEx("Player_SetSubtitles", &retVal, Args{path})
+}
+
 func CloseAllDialogs() bool {
 	retVal := 0
 	executeJSONRPCEx("Dialog_CloseAll", &retVal, nil)